@@ -0,0 +1,210 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Function that creates a server for a node with an empty chain, which network.Chain is set to until the test ends
+func newTestServer(t *testing.T) (*Server, *upload.Environment) {
+	dir := t.TempDir()
+	blockchain := core.NewBlockchain(core.NewMemoryChainStore())
+	blockchain.AddBlock(core.NewGenesisBlock(time.Unix(1700000000, 0)))
+	_, identityKey, _ := ed25519.GenerateKey(rand.Reader)
+	chunkStore, _ := storage.NewChunkStore(filepath.Join(dir, "chunks"))
+	manifestStore, _ := storage.NewManifestStore(filepath.Join(dir, "manifests"))
+	pinSet, _ := storage.NewPinSet(filepath.Join(dir, "pins.json"))
+	env := upload.NewEnvironment(blockchain, identityKey, chunkStore, manifestStore, pinSet)
+	network.Chain = blockchain
+	t.Cleanup(func() { network.Chain = nil })
+	server := &Server{Manifests: manifestStore, Chunks: chunkStore, ObjectDir: filepath.Join(dir, "objects"),
+		ObjectParams: upload.Params{Workers: 1, Retries: 1}}
+	os.MkdirAll(server.ObjectDir, 0755)
+	return server, env
+}
+
+// Function that uploads a file with the given contents and name, returning the result of the upload
+func uploadTestFile(t *testing.T, env *upload.Environment, contents string, name string) *upload.Result {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(path, []byte(contents), 0644)
+	result, err := upload.Run(context.Background(), env, upload.Params{FilePath: path, Workers: 1, Retries: 1,
+		Name: name})
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+	return result
+}
+
+// Function that sends a request to a handler with the given path values, returning the recorded response
+func serve(handler http.HandlerFunc, request *http.Request, pathValues ...string) *httptest.ResponseRecorder {
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		request.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+// Tests that uploads submitted with fewer than one mining worker or attempt are refused before they are queued
+func TestHandleSubmitUpload_Validation(t *testing.T) {
+	server := &Server{}
+	for _, body := range []string{
+		`{"filePath": "file", "workers": 0, "retries": 1}`,
+		`{"filePath": "file", "workers": 2, "retries": 0}`,
+		`{"filePath": "file", "workers": -1, "retries": -1}`,
+	} {
+		recorder := httptest.NewRecorder()
+		server.handleSubmitUpload(recorder, httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(body)))
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("FAIL: Expected %s to be refused with status 400, got %d", body, recorder.Code)
+		}
+	}
+}
+
+// Tests that an object stored through the S3 endpoint can be read back and is listed in its bucket, and that bodies
+// over the maximum object size are refused whether or not they declare their length
+func TestS3Objects(t *testing.T) {
+	server, env := newTestServer(t)
+	server.Uploads = upload.NewScheduler(env, 1)
+
+	put := httptest.NewRequest(http.MethodPut, "/backups/daily/notes.txt", strings.NewReader("object contents"))
+	if response := serve(server.handlePutObject, put, "bucket", "backups", "key", "daily/notes.txt"); response.Code != http.StatusOK ||
+		response.Header().Get("ETag") == "" {
+		t.Fatalf("FAIL: Expected the object to be stored, got %d: %s", response.Code, response.Body)
+	}
+	get := httptest.NewRequest(http.MethodGet, "/backups/daily/notes.txt", nil)
+	if response := serve(server.handleGetObject, get, "bucket", "backups", "key", "daily/notes.txt"); response.Code != http.StatusOK ||
+		response.Body.String() != "object contents" {
+		t.Errorf("FAIL: Expected to read the object back, got %d: %q", response.Code, response.Body)
+	}
+	list := httptest.NewRequest(http.MethodGet, "/backups?list-type=2&prefix=daily/", nil)
+	if response := serve(server.handleListObjects, list, "bucket", "backups"); response.Code != http.StatusOK ||
+		!strings.Contains(response.Body.String(), "<Key>daily/notes.txt</Key>") {
+		t.Errorf("FAIL: Expected the object to be listed, got %d: %s", response.Code, response.Body)
+	}
+	missing := httptest.NewRequest(http.MethodGet, "/backups/missing", nil)
+	if response := serve(server.handleGetObject, missing, "bucket", "backups", "key", "missing"); response.Code != http.StatusNotFound {
+		t.Errorf("FAIL: Expected a missing object to be reported with status 404, got %d", response.Code)
+	}
+
+	server.MaxObjectSize = 4
+	declared := httptest.NewRequest(http.MethodPut, "/backups/large", strings.NewReader("too large"))
+	if response := serve(server.handlePutObject, declared, "bucket", "backups", "key", "large"); response.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("FAIL: Expected a body declared over the maximum size to be refused with status 413, got %d", response.Code)
+	}
+	undeclared := httptest.NewRequest(http.MethodPut, "/backups/large", strings.NewReader("too large"))
+	undeclared.ContentLength = -1
+	if response := serve(server.handlePutObject, undeclared, "bucket", "backups", "key", "large"); response.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("FAIL: Expected a body read over the maximum size to be refused with status 413, got %d", response.Code)
+	}
+}
+
+// Tests that the gateway serves committed files by Merkle root and by name, and refuses files it cannot serve
+func TestGateway(t *testing.T) {
+	server, env := newTestServer(t)
+	result := uploadTestFile(t, env, "gateway contents", "notes")
+
+	file := serve(server.handleGatewayFile, httptest.NewRequest(http.MethodGet, "/file/"+result.MerkleRoot, nil),
+		"root", result.MerkleRoot)
+	if file.Code != http.StatusOK || file.Body.String() != "gateway contents" ||
+		file.Header().Get("ETag") != `"`+result.MerkleRoot+`"` {
+		t.Errorf("FAIL: Expected the file to be served by Merkle root, got %d: %q", file.Code, file.Body)
+	}
+	cached := httptest.NewRequest(http.MethodGet, "/file/"+result.MerkleRoot, nil)
+	cached.Header.Set("If-None-Match", `"`+result.MerkleRoot+`"`)
+	if response := serve(server.handleGatewayFile, cached, "root", result.MerkleRoot); response.Code != http.StatusNotModified {
+		t.Errorf("FAIL: Expected a cached file to be reported as not modified, got %d", response.Code)
+	}
+	named := serve(server.handleGatewayName, httptest.NewRequest(http.MethodGet, "/name/notes", nil), "name", "notes")
+	if named.Code != http.StatusOK || named.Body.String() != "gateway contents" {
+		t.Errorf("FAIL: Expected the file to be served by name, got %d: %q", named.Code, named.Body)
+	}
+
+	unknown := hex.EncodeToString(make([]byte, 32))
+	if response := serve(server.handleGatewayFile, httptest.NewRequest(http.MethodGet, "/file/"+unknown, nil),
+		"root", unknown); response.Code != http.StatusNotFound {
+		t.Errorf("FAIL: Expected an unknown file to be reported with status 404, got %d", response.Code)
+	}
+	if response := serve(server.handleGatewayFile, httptest.NewRequest(http.MethodGet, "/file/invalid", nil),
+		"root", "invalid"); response.Code != http.StatusBadRequest {
+		t.Errorf("FAIL: Expected an invalid Merkle root to be refused with status 400, got %d", response.Code)
+	}
+}
+
+// Tests that the explorer shows a block's details and links to the metadata of the file it commits
+func TestExplorer(t *testing.T) {
+	server, env := newTestServer(t)
+	result := uploadTestFile(t, env, "explorer contents", "notes")
+
+	page := serve(server.handleExplorerBlock, httptest.NewRequest(http.MethodGet, "/explorer/blocks/"+result.BlockHash, nil),
+		"hash", result.BlockHash)
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "/explorer/files/"+result.MerkleRoot) {
+		t.Errorf("FAIL: Expected the block page to link to its file, got %d: %s", page.Code, page.Body)
+	}
+	file := serve(server.handleExplorerFile, httptest.NewRequest(http.MethodGet, "/explorer/files/"+result.MerkleRoot, nil),
+		"root", result.MerkleRoot)
+	if file.Code != http.StatusOK || !strings.Contains(file.Body.String(), "notes.txt") {
+		t.Errorf("FAIL: Expected the file page to show the file's metadata, got %d: %s", file.Code, file.Body)
+	}
+	missing := hex.EncodeToString(make([]byte, 32))
+	if response := serve(server.handleExplorerBlock, httptest.NewRequest(http.MethodGet, "/explorer/blocks/"+missing, nil),
+		"hash", missing); response.Code != http.StatusNotFound {
+		t.Errorf("FAIL: Expected an unknown block to be reported with status 404, got %d", response.Code)
+	}
+}
+
+// Tests that the block page of a batch block links to the metadata of every file the batch commits
+func TestExplorer_BatchBlock(t *testing.T) {
+	server, env := newTestServer(t)
+	env.Batcher = upload.NewBatcher(env, 200*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go env.Batcher.Run(ctx)
+
+	var wg sync.WaitGroup
+	results := make([]*upload.Result, 2)
+	errs := make([]error, 2)
+	for i, contents := range []string{"first file", "second file"} {
+		path := filepath.Join(t.TempDir(), "notes.txt")
+		os.WriteFile(path, []byte(contents), 0644)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = upload.Run(ctx, env, upload.Params{FilePath: path, Workers: 1, Retries: 1})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Run() failed with error: %v", err)
+		}
+	}
+	if results[0].BlockHash != results[1].BlockHash {
+		t.Fatalf("FAIL: Expected both uploads to be committed in one block")
+	}
+
+	page := serve(server.handleExplorerBlock, httptest.NewRequest(http.MethodGet, "/explorer/blocks/"+results[0].BlockHash, nil),
+		"hash", results[0].BlockHash)
+	for _, result := range results {
+		if !strings.Contains(page.Body.String(), "/explorer/files/"+result.MerkleRoot) {
+			t.Errorf("FAIL: Expected the batch block page to link to file %s, got %s", result.MerkleRoot, page.Body)
+		}
+	}
+	if block := env.Chain.LastBlock(); strings.Contains(page.Body.String(), "/explorer/files/"+hex.EncodeToString(block.MerkelRoot)) {
+		t.Errorf("FAIL: Expected the batch block page not to link to the batch's own Merkle root")
+	}
+}
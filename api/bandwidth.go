@@ -0,0 +1,29 @@
+package api
+
+import (
+	"blockchain-storage/network"
+	"encoding/json"
+	"net/http"
+)
+
+// Function that handles requests for the rates the node's transfers with peers are limited to
+func handleGetBandwidth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, network.GetBandwidthLimits())
+}
+
+// Function that handles requests to change the rates the node's transfers are limited to while it runs
+// The new limits replace all of the old ones and apply to transfers that are already running
+func handleSetBandwidth(w http.ResponseWriter, r *http.Request) {
+	var limits network.BandwidthLimits
+	err := json.NewDecoder(r.Body).Decode(&limits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = network.SetBandwidthLimits(limits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, network.GetBandwidthLimits())
+}
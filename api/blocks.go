@@ -0,0 +1,136 @@
+package api
+
+import (
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Function that builds a block index query from the parameters of a blocks request
+// The parameters are uploader (a peer ID or hex encoded public key), from and to (dates as YYYY-MM-DD, with to being
+// exclusive), since (an age such as 30d, which cannot be combined with from), tag and file
+func ParseBlockQuery(values url.Values, now time.Time) (storage.BlockQuery, error) {
+	var query storage.BlockQuery
+	if uploader := values.Get("uploader"); uploader != "" {
+		publicKey, err := ParseUploader(uploader)
+		if err != nil {
+			return query, err
+		}
+		query.Uploader = publicKey
+	}
+	for _, date := range []struct {
+		value  string
+		target *time.Time
+	}{{values.Get("from"), &query.From}, {values.Get("to"), &query.To}} {
+		if date.value == "" {
+			continue
+		}
+		parsed, err := time.ParseInLocation(time.DateOnly, date.value, time.Local)
+		if err != nil {
+			return query, fmt.Errorf("invalid date %q: %w", date.value, err)
+		}
+		*date.target = parsed
+	}
+	if since := values.Get("since"); since != "" {
+		if !query.From.IsZero() {
+			return query, errors.New("since and from cannot both be given")
+		}
+		age, err := storage.ParseAge(since)
+		if err != nil {
+			return query, err
+		}
+		query.From = now.Add(-age)
+	}
+	query.Tag = values.Get("tag")
+	query.FileName = values.Get("file")
+	return query, nil
+}
+
+// Function that converts a peer ID or hex encoded public key into the raw public key recorded in blocks
+func ParseUploader(uploader string) ([]byte, error) {
+	peerID, err := peer.Decode(uploader)
+	if err == nil {
+		publicKey, err := peerID.ExtractPublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return publicKey.Raw()
+	}
+	publicKey, err := hex.DecodeString(uploader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uploader %q: expected a peer ID or a hex encoded public key", uploader)
+	}
+	return publicKey, nil
+}
+
+// Function that handles searches of the blockchain's metadata, e.g. GET /blocks?uploader=<peer ID>&since=30d
+func (server *Server) handleQueryBlocks(w http.ResponseWriter, r *http.Request) {
+	if server.QueryBlocks == nil {
+		http.Error(w, "node has no block index", http.StatusNotFound)
+		return
+	}
+	query, err := ParseBlockQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := server.QueryBlocks(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Always return a list, even when no blocks match
+	if records == nil {
+		records = []storage.BlockRecord{}
+	}
+	writeJSON(w, records)
+}
+
+// Function that handles requests for the proof that a chunk belongs to a committed file, e.g.
+// GET /proofs/<root>/<index>?confirmations=6, with the root given hex encoded or as a CID
+// The proof holds the headers from the block committing the file up to the tip, or to the given number of blocks after
+// it, so that it can be checked with verify.Inclusion without a node.
+func (server *Server) handleInclusionProof(w http.ResponseWriter, r *http.Request) {
+	merkleRoot, err := verify.ParseHash(r.PathValue("root"))
+	if err != nil {
+		http.Error(w, "invalid Merkle root "+r.PathValue("root"), http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		http.Error(w, "invalid chunk index "+r.PathValue("index"), http.StatusBadRequest)
+		return
+	}
+	confirmations := 0
+	if value := r.URL.Query().Get("confirmations"); value != "" {
+		confirmations, err = strconv.Atoi(value)
+		if err != nil || confirmations < 0 {
+			http.Error(w, "invalid number of confirmations "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	if server.Manifests == nil || network.Chain == nil {
+		http.Error(w, "node has no manifests or blockchain loaded", http.StatusServiceUnavailable)
+		return
+	}
+	// Only the manifest lists the chunk hashes the Merkle proof is built from
+	manifest, err := server.Manifests.GetManifest(merkleRoot)
+	if err != nil {
+		http.Error(w, "node has no manifest for the file", http.StatusNotFound)
+		return
+	}
+	proof, err := network.Chain.InclusionProof(manifest, index, confirmations)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, proof)
+}
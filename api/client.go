@@ -0,0 +1,90 @@
+package api
+
+import (
+	"blockchain-storage/network"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Function that sends a request to an endpoint of a running node's API
+// The body (if not nil) is sent as JSON and the JSON response is decoded into value (if not nil)
+func Request(addr string, method string, path string, body any, value any) error {
+	response, err := openRequest(addr, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if value == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// Function that asks a running node to fetch the missing chunks of a file, passing each chunk to progress as soon as
+// the node is done with it
+func FetchChunks(addr string, request FetchRequest, progress func(network.ChunkFetch)) (*FetchResponse, error) {
+	request.Progress = true
+	response, err := openRequest(addr, http.MethodPost, "/chunks/fetch", request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var line FetchProgress
+		err = decoder.Decode(&line)
+		if err != nil {
+			return nil, fmt.Errorf("node API stream ended early: %w", err)
+		}
+		if line.Chunk != nil && progress != nil {
+			progress(*line.Chunk)
+		}
+		if line.Done == nil {
+			continue
+		}
+		if line.Error != "" {
+			return line.Done, errors.New(line.Error)
+		}
+		return line.Done, nil
+	}
+}
+
+// Function that sends a request to an endpoint of a running node's API, returning the response if it succeeded
+// The body (if not nil) is sent as JSON, and the caller must close the body of the response
+func openRequest(addr string, method string, path string, body any) (*http.Response, error) {
+	var requestBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewReader(jsonBody)
+	}
+
+	request, err := http.NewRequest(method, "http://"+addr+path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		// The API reports errors as plain text so include it in the returned error
+		message, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		return nil, fmt.Errorf("node API returned status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	return response, nil
+}
+
+// Function that queries an endpoint of a running node's API and decodes the JSON response into value
+func Get(addr string, path string, value any) error {
+	return Request(addr, http.MethodGet, path, nil, value)
+}
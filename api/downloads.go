@@ -0,0 +1,136 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of download reports the node keeps, with the oldest being dropped first
+const maxDownloadReports = 100
+
+// Source recorded for chunks that were already held in the local chunk store
+const LocalChunkSource = "local"
+
+// DownloadReport - Structure describing where every chunk of a downloaded file came from and how it was verified
+type DownloadReport struct {
+	ID            string              `json:"id,omitempty"`    // Identifier the node keeps the report under
+	Alias         string              `json:"alias"`           // Alias the file was downloaded by
+	OutputPath    string              `json:"outputPath"`      // Path the file was written to
+	MerkleRoot    string              `json:"merkleRoot"`      // Hex encoded Merkle root of the file
+	CID           string              `json:"cid"`             // CID identifying the file by its Merkle root
+	BlockIndex    int64               `json:"blockIndex"`      // Height of the block committing to the Merkle root
+	HashAlgorithm string              `json:"hashAlgorithm"`   // Algorithm of the chunk hashes and the Merkle tree
+	Started       time.Time           `json:"started"`         // Time the download started
+	Finished      time.Time           `json:"finished"`        // Time every chunk had been verified
+	Verified      bool                `json:"verified"`        // Whether every chunk passed its proof and the file matched its root
+	Error         string              `json:"error,omitempty"` // Why the file was not written (empty if it was)
+	Chunks        []ChunkVerification `json:"chunks"`          // How each chunk was retrieved and verified, in file order
+}
+
+// ChunkVerification - Structure describing how a single chunk of a downloaded file was retrieved and verified
+type ChunkVerification struct {
+	Index        int           `json:"index"`                  // Position of the chunk in the file
+	Hash         string        `json:"hash"`                   // Hex encoded hash of the chunk
+	CID          string        `json:"cid"`                    // CID identifying the chunk by its hash
+	Source       string        `json:"source"`                 // Peer that served the chunk, or local if it was already held
+	Proof        bool          `json:"proof"`                  // Whether the chunk passed its Merkle proof against the root
+	Attempts     int           `json:"attempts"`               // Number of peers the chunk was requested from
+	CorruptPeers []string      `json:"corruptPeers,omitempty"` // Peers that served a corrupt copy or reported one
+	Duration     time.Duration `json:"duration"`               // Time taken to fetch the chunk (zero if it was already held)
+}
+
+// DownloadLog - Structure holding the reports of the most recent downloads, so that they can be audited later
+type DownloadLog struct {
+	mutex   sync.Mutex
+	reports []DownloadReport
+}
+
+// Function that creates an empty download log
+func NewDownloadLog() *DownloadLog {
+	return &DownloadLog{}
+}
+
+// Function that records a download report and returns the identifier it is kept under
+func (downloadLog *DownloadLog) Add(report DownloadReport) (string, error) {
+	// Generate a random identifier for the report
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+	report.ID = hex.EncodeToString(idBytes)
+
+	downloadLog.mutex.Lock()
+	defer downloadLog.mutex.Unlock()
+	downloadLog.reports = append(downloadLog.reports, report)
+	if len(downloadLog.reports) > maxDownloadReports {
+		downloadLog.reports = downloadLog.reports[len(downloadLog.reports)-maxDownloadReports:]
+	}
+	return report.ID, nil
+}
+
+// Function that returns the report kept under an identifier, if it is still kept
+func (downloadLog *DownloadLog) Get(id string) (DownloadReport, bool) {
+	downloadLog.mutex.Lock()
+	defer downloadLog.mutex.Unlock()
+	for _, report := range downloadLog.reports {
+		if report.ID == id {
+			return report, true
+		}
+	}
+	return DownloadReport{}, false
+}
+
+// Function that returns every kept report, oldest first
+func (downloadLog *DownloadLog) List() []DownloadReport {
+	downloadLog.mutex.Lock()
+	defer downloadLog.mutex.Unlock()
+	return append([]DownloadReport{}, downloadLog.reports...)
+}
+
+// Function that handles the recording of a finished download's report
+func (server *Server) handleRecordDownload(w http.ResponseWriter, r *http.Request) {
+	if server.Downloads == nil {
+		http.Error(w, "node does not keep download reports", http.StatusNotFound)
+		return
+	}
+	var report DownloadReport
+	err := json.NewDecoder(r.Body).Decode(&report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := server.Downloads.Add(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, SubmitResponse{ID: id})
+}
+
+// Function that handles requests for the list of download reports
+func (server *Server) handleListDownloads(w http.ResponseWriter, r *http.Request) {
+	if server.Downloads == nil {
+		http.Error(w, "node does not keep download reports", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, server.Downloads.List())
+}
+
+// Function that handles requests for a single download report
+func (server *Server) handleGetDownload(w http.ResponseWriter, r *http.Request) {
+	if server.Downloads == nil {
+		http.Error(w, "node does not keep download reports", http.StatusNotFound)
+		return
+	}
+	report, found := server.Downloads.Get(r.PathValue("id"))
+	if !found {
+		http.Error(w, "download report not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, report)
+}
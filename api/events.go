@@ -0,0 +1,43 @@
+package api
+
+import (
+	"blockchain-storage/events"
+	"encoding/json"
+	"net/http"
+)
+
+// Function that handles subscriptions to the node's events, streaming one JSON object per line until the client
+// disconnects. The type query parameter, which may be repeated, limits the stream to events of the given types
+func (server *Server) handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	if server.Events == nil {
+		http.Error(w, "node does not publish events", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	var types []events.Type
+	for _, eventType := range r.URL.Query()["type"] {
+		types = append(types, events.Type(eventType))
+	}
+	subscription := server.Events.Subscribe(types...)
+	defer subscription.Unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-subscription.Events():
+			if encoder.Encode(event) != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/verify"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of blocks listed on each page of the block explorer
+const explorerPageSize = 50
+
+// Function that registers the pages of the block explorer, a read-only HTML view of the node's blockchain
+func (server *Server) registerExplorer(mux *http.ServeMux) {
+	mux.HandleFunc("GET /explorer", server.handleExplorerBlocks)
+	mux.HandleFunc("GET /explorer/blocks/{hash}", server.handleExplorerBlock)
+	mux.HandleFunc("GET /explorer/files/{root}", server.handleExplorerFile)
+	mux.HandleFunc("GET /explorer/search", server.handleExplorerSearch)
+}
+
+// Function that handles requests for a page of blocks, newest first
+// The before query parameter lists the blocks below a height, which is how older pages are reached
+func (server *Server) handleExplorerBlocks(w http.ResponseWriter, r *http.Request) {
+	if network.Chain == nil {
+		renderExplorerError(w, http.StatusServiceUnavailable, "The node has no blockchain loaded")
+		return
+	}
+	before := int64(network.Chain.Length())
+	if value := r.URL.Query().Get("before"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 0 {
+			renderExplorerError(w, http.StatusBadRequest, "Invalid height "+value)
+			return
+		}
+		before = min(before, parsed)
+	}
+
+	var blocks []*core.Block
+	err := network.Chain.Iterate(before-explorerPageSize, before-1, func(block *core.Block) error {
+		blocks = append([]*core.Block{block}, blocks...)
+		return nil
+	})
+	if err != nil {
+		renderExplorerError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page := struct {
+		Blocks []*core.Block
+		Older  int64 // Height to list the blocks below for the next page (0 if there is none)
+	}{Blocks: blocks}
+	if len(blocks) > 0 && blocks[len(blocks)-1].Index > 0 {
+		page.Older = blocks[len(blocks)-1].Index
+	}
+	renderExplorer(w, "blocks", page)
+}
+
+// Function that handles requests for the details of a single block
+func (server *Server) handleExplorerBlock(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(r.PathValue("hash"))
+	if err != nil || network.Chain == nil {
+		renderExplorerError(w, http.StatusNotFound, "No block with hash "+r.PathValue("hash"))
+		return
+	}
+	block, err := network.Chain.GetBlockByHash(hash)
+	if err != nil {
+		renderExplorerError(w, http.StatusNotFound, "No block with hash "+r.PathValue("hash"))
+		return
+	}
+	page := struct {
+		Block *core.Block
+		Held  map[string]bool // Whether the node holds the manifest of each file the block commits, by hex Merkle root
+	}{Block: block, Held: make(map[string]bool)}
+	if server.Manifests != nil {
+		for _, file := range block.FileRecords() {
+			_, err := server.Manifests.GetManifest(file.MerkleRoot)
+			page.Held[hex.EncodeToString(file.MerkleRoot)] = err == nil
+		}
+	}
+	renderExplorer(w, "block", page)
+}
+
+// Function that handles requests for the metadata of a file, along with its version history
+func (server *Server) handleExplorerFile(w http.ResponseWriter, r *http.Request) {
+	merkleRoot, err := verify.ParseHash(r.PathValue("root"))
+	if err != nil || server.Manifests == nil || network.Chain == nil {
+		renderExplorerError(w, http.StatusNotFound, "No file with Merkle root "+r.PathValue("root"))
+		return
+	}
+	manifest, err := server.Manifests.GetManifest(merkleRoot)
+	if err != nil {
+		renderExplorerError(w, http.StatusNotFound, "The node holds no manifest for Merkle root "+r.PathValue("root"))
+		return
+	}
+	page := struct {
+		Manifest *core.Manifest
+		Block    *core.Block        // Block committing the file (nil if it is not on the chain)
+		History  []core.FileVersion // Versions of the file up to this one, oldest first
+		Deleted  *core.Block        // Tombstone block that deleted the file (nil if it was not deleted)
+	}{Manifest: manifest}
+	page.Block, _ = network.Chain.GetBlockByMerkelRoot(merkleRoot)
+	page.History, _ = network.Chain.History(merkleRoot)
+	page.Deleted, _ = network.Chain.FindTombstone(merkleRoot)
+	renderExplorer(w, "file", page)
+}
+
+// Function that handles searches, redirecting to the block matching a height, block hash, Merkle root or claimed name
+func (server *Server) handleExplorerSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	block, err := searchBlock(query)
+	if err != nil {
+		renderExplorerError(w, http.StatusNotFound, "Nothing matches "+query)
+		return
+	}
+	http.Redirect(w, r, "/explorer/blocks/"+hex.EncodeToString(block.Hash), http.StatusSeeOther)
+}
+
+// Function that finds the block a search refers to
+func searchBlock(query string) (*core.Block, error) {
+	if network.Chain == nil || query == "" {
+		return nil, core.ErrBlockNotFound
+	}
+	if height, err := strconv.ParseInt(query, 10, 64); err == nil {
+		return network.Chain.GetBlockByHeight(height)
+	}
+	if hash, err := verify.ParseHash(query); err == nil {
+		if block, err := network.Chain.GetBlockByHash(hash); err == nil {
+			return block, nil
+		}
+		if block, err := network.Chain.GetBlockByMerkelRoot(hash); err == nil {
+			return block, nil
+		}
+	}
+	claim, err := network.Chain.ResolveName(query, -1)
+	if err != nil {
+		return nil, err
+	}
+	return network.Chain.GetBlockByMerkelRoot(claim.MerkleRoot)
+}
+
+// Function that writes an explorer page from its template
+func renderExplorer(w http.ResponseWriter, name string, page any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := explorerTemplates.ExecuteTemplate(w, name, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Function that writes an explorer page explaining why a request failed
+func renderExplorerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	explorerTemplates.ExecuteTemplate(w, "error", message)
+}
+
+// Function that returns the peer ID of an uploader from the public key recorded in its blocks
+func uploaderID(publicKey []byte) string {
+	if len(publicKey) == 0 {
+		return ""
+	}
+	peerID, err := core.UploaderPeerID(publicKey)
+	if err != nil {
+		return hex.EncodeToString(publicKey)
+	}
+	return peerID
+}
+
+// Functions available to the explorer's templates
+var explorerFuncs = template.FuncMap{
+	"hex": hex.EncodeToString,
+	"short": func(value []byte) string {
+		encoded := hex.EncodeToString(value)
+		if len(encoded) > 16 {
+			return encoded[:16] + "…"
+		}
+		return encoded
+	},
+	"cid": func(manifest *core.Manifest) string {
+		cid, _ := manifest.CID()
+		return cid
+	},
+	"time":     func(t time.Time) string { return t.Local().Format(time.DateTime) },
+	"uploader": uploaderID,
+	"size":     func(bytes int64) string { return strconv.FormatInt(bytes, 10) + " bytes" },
+}
+
+// Templates of the explorer's pages, which share a layout with a search box
+var explorerTemplates = template.Must(template.New("explorer").Funcs(explorerFuncs).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Block explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.3em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+code { font-size: 0.9em; }
+</style></head><body>
+<h1><a href="/explorer">Block explorer</a></h1>
+<form action="/explorer/search"><input name="q" size="70" placeholder="Height, block hash, Merkle root or name">
+<button>Search</button></form>
+{{end}}
+{{define "footer"}}</body></html>{{end}}
+
+{{define "error"}}{{template "header"}}<p>{{.}}</p>{{template "footer"}}{{end}}
+
+{{define "blocks"}}{{template "header"}}
+<table><tr><th>Height</th><th>Hash</th><th>Merkle root</th><th>Time</th><th>Name</th></tr>
+{{range .Blocks}}<tr><td>{{.Index}}</td><td><a href="/explorer/blocks/{{hex .Hash}}"><code>{{short .Hash}}</code></a></td>
+<td><code>{{short .MerkelRoot}}</code></td><td>{{time .Timestamp}}</td><td>{{.Name}}</td></tr>
+{{end}}</table>
+{{if .Older}}<p><a href="/explorer?before={{.Older}}">Older blocks</a></p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "block"}}{{template "header"}}{{with .Block}}
+<h2>Block {{.Index}}</h2>
+<table>
+<tr><th>Hash</th><td><code>{{hex .Hash}}</code></td></tr>
+<tr><th>Previous block</th><td>{{if .PrevHash}}<a href="/explorer/blocks/{{hex .PrevHash}}"><code>{{hex .PrevHash}}</code></a>{{end}}</td></tr>
+<tr><th>Merkle root</th><td><code>{{hex .MerkelRoot}}</code>{{if and (not .Files) (index $.Held (hex .MerkelRoot))}} (<a href="/explorer/files/{{hex .MerkelRoot}}">file</a>){{end}}</td></tr>
+<tr><th>Time</th><td>{{time .Timestamp}}</td></tr>
+<tr><th>Nonce</th><td>{{.Nonce}}</td></tr>
+<tr><th>Uploader</th><td><code>{{uploader .UploaderPublicKey}}</code></td></tr>
+{{if .Name}}<tr><th>Name</th><td>{{.Name}}</td></tr>{{end}}
+{{if .PrevVersion}}<tr><th>Previous version</th><td><code>{{hex .PrevVersion}}</code></td></tr>{{end}}
+{{if .Tombstone}}<tr><th>Deletes</th><td><code>{{hex .Tombstone}}</code></td></tr>{{end}}
+{{if .Pruned}}<tr><th>Pruned</th><td>Only the header of this block is kept</td></tr>{{end}}
+</table>
+{{if .Files}}<h3>Files</h3><table><tr><th>Merkle root</th><th>Name</th><th>Previous version</th></tr>
+{{range .Files}}<tr><td><code>{{hex .MerkleRoot}}</code>{{if index $.Held (hex .MerkleRoot)}} (<a href="/explorer/files/{{hex .MerkleRoot}}">file</a>){{end}}</td><td>{{.Name}}</td><td><code>{{short .PrevVersion}}</code></td></tr>
+{{end}}</table>{{end}}{{end}}
+{{template "footer"}}{{end}}
+
+{{define "file"}}{{template "header"}}{{with .Manifest}}
+<h2>{{.Alias}}</h2>
+<table>
+<tr><th>Merkle root</th><td><code>{{hex .MerkleRoot}}</code></td></tr>
+{{with cid .}}<tr><th>CID</th><td><code>{{.}}</code></td></tr>{{end}}
+<tr><th>File name</th><td>{{.FileName}}</td></tr>
+<tr><th>Size</th><td>{{size .FileSize}}</td></tr>
+<tr><th>Chunks</th><td>{{len .ChunkHashes}} of {{size .ChunkSize}}</td></tr>
+{{if .Tags}}<tr><th>Tags</th><td>{{range .Tags}}{{.}} {{end}}</td></tr>{{end}}
+{{if .Encrypted}}<tr><th>Encrypted</th><td>yes</td></tr>{{end}}
+{{if .Compression}}<tr><th>Compression</th><td>{{.Compression}}</td></tr>{{end}}
+</table>{{end}}
+{{if .Block}}<p>Committed in <a href="/explorer/blocks/{{hex .Block.Hash}}">block {{.Block.Index}}</a></p>{{end}}
+{{if .Deleted}}<p>Deleted in <a href="/explorer/blocks/{{hex .Deleted.Hash}}">block {{.Deleted.Index}}</a></p>{{end}}
+{{if .History}}<h3>Versions</h3><table><tr><th>Version</th><th>Merkle root</th><th>Height</th><th>Time</th></tr>
+{{range .History}}<tr><td>{{.Version}}</td><td><code>{{short .MerkleRoot}}</code></td><td>{{.Height}}</td><td>{{time .Timestamp}}</td></tr>
+{{end}}</table>{{end}}
+{{template "footer"}}{{end}}
+`))
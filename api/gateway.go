@@ -0,0 +1,175 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// Caching allowed for files requested by Merkle root, which are identified by their contents and so never change
+const immutableCache = "public, max-age=31536000, immutable"
+
+// Function that serves the HTTP gateway on the given address (blocks until the server fails)
+// The gateway only serves the files committed on the chain, so unlike the local API it can be exposed to browsers and
+// other clients that are not trusted with the node
+func (server *Server) ServeGateway(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /file/{root}", server.handleGatewayFile)
+	mux.HandleFunc("GET /name/{name}", server.handleGatewayName)
+	// Inclusion proofs only reveal what the chain already commits, so auditors can fetch them from the gateway too
+	mux.HandleFunc("GET /proof/{root}/{index}", server.handleInclusionProof)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Function that handles requests for a file by its Merkle root, given hex encoded or as a CID
+func (server *Server) handleGatewayFile(w http.ResponseWriter, r *http.Request) {
+	merkleRoot, err := verify.ParseHash(r.PathValue("root"))
+	if err != nil {
+		http.Error(w, "invalid Merkle root "+r.PathValue("root"), http.StatusBadRequest)
+		return
+	}
+	server.serveFile(w, r, merkleRoot, immutableCache)
+}
+
+// Function that handles requests for the file a name claimed in the on-chain registry currently points at
+func (server *Server) handleGatewayName(w http.ResponseWriter, r *http.Request) {
+	if network.Chain == nil {
+		http.Error(w, "node has no blockchain loaded", http.StatusServiceUnavailable)
+		return
+	}
+	claim, err := network.Chain.ResolveName(r.PathValue("name"), -1)
+	if errors.Is(err, core.ErrNameNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A name can be pointed at a new version, so clients must check it is still current before using a cached copy
+	server.serveFile(w, r, claim.MerkleRoot, "no-cache")
+}
+
+// Function that streams a file committed on the chain, fetching any chunks the node does not hold from the network
+func (server *Server) serveFile(w http.ResponseWriter, r *http.Request, merkleRoot []byte, cacheControl string) {
+	if network.Chain == nil || server.Manifests == nil || server.Chunks == nil {
+		http.Error(w, "node does not serve files", http.StatusServiceUnavailable)
+		return
+	}
+	manifest, err := server.Manifests.GetManifest(merkleRoot)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("node holds no manifest for file %x", merkleRoot), http.StatusNotFound)
+		return
+	}
+	status, err := checkServedFile(manifest)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == fileETag(manifest) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(manifest.FileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": manifest.FileName}))
+	w.Header().Set("Cache-Control", cacheControl)
+	server.writeFile(w, r, manifest)
+}
+
+// Function that checks a file can be served, returning the status to refuse it with if it cannot
+// The chunk hashes in the manifest are checked against the Merkle root, and every chunk is checked against its hash
+// as it is read, so only the file committed on the chain is ever served. Encrypted files are refused, as the node
+// would otherwise hand their contents to anyone who asked.
+func checkServedFile(manifest *core.Manifest) (int, error) {
+	merkleRoot := manifest.MerkleRoot
+	if _, err := network.Chain.GetBlockByMerkelRoot(merkleRoot); err != nil {
+		return http.StatusNotFound, fmt.Errorf("file %x is not committed on the chain", merkleRoot)
+	}
+	if tombstone, err := network.Chain.FindTombstone(merkleRoot); err == nil {
+		return http.StatusGone, fmt.Errorf("file %x was deleted by its uploader in block %d", merkleRoot, tombstone.Index)
+	}
+	if manifest.Encrypted || manifest.Key != nil {
+		return http.StatusForbidden, fmt.Errorf("file %x is encrypted", merkleRoot)
+	}
+	builder := core.MerkleBuilder{Algorithm: manifest.HashAlgorithm}
+	for _, chunkHash := range manifest.ChunkHashes {
+		builder.AddHash(chunkHash)
+	}
+	if root, err := builder.Root(); err != nil || !bytes.Equal(root, merkleRoot) {
+		return http.StatusInternalServerError, errors.New("manifest does not match the file's Merkle root")
+	}
+	return http.StatusOK, nil
+}
+
+// Function that reads a chunk of a served file from the chunk store if it is held there and from the cache otherwise
+func (server *Server) readChunk(hash []byte) ([]byte, error) {
+	if server.Cache != nil && !server.Chunks.HasChunk(hash) {
+		if chunk, _, found := server.Cache.Get(hash); found {
+			return chunk, nil
+		}
+	}
+	return server.Chunks.GetChunk(hash)
+}
+
+// Function that returns the entity tag of a file, which is its Merkle root as files are identified by their contents
+func fileETag(manifest *core.Manifest) string {
+	return `"` + hex.EncodeToString(manifest.MerkleRoot) + `"`
+}
+
+// Function that writes the contents of a checked file as the response, along with its length and entity tag
+// Missing chunks are fetched before the response starts, so that a failure can still be reported with its status.
+// Responses to HEAD requests only carry the headers, so nothing is fetched for them.
+func (server *Server) writeFile(w http.ResponseWriter, r *http.Request, manifest *core.Manifest) {
+	merkleRoot := manifest.MerkleRoot
+	missing := false
+	for _, chunkHash := range manifest.ChunkHashes {
+		missing = missing || !(server.Chunks.HasChunk(chunkHash) || (server.Cache != nil && server.Cache.Has(chunkHash)))
+	}
+	if missing && r.Method != http.MethodHead {
+		transfer := server.startTransfer(merkleRoot)
+		record := func(fetch network.ChunkFetch) {
+			server.recordTransfer(transfer, fetch)
+		}
+		// Files are fetched into the cache so that serving them does not pin them, unless the cache could not hold a
+		// whole file until it has been written out
+		var err error
+		if server.Cache != nil && manifest.FileSize+manifest.Padding <= server.Cache.Capacity() {
+			_, err = network.CacheChunks(r.Context(), merkleRoot, manifest.ChunkHashes, record)
+		} else {
+			_, err = network.FetchChunks(r.Context(), merkleRoot, manifest.ChunkHashes, nil, record)
+		}
+		server.endTransfer(transfer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(manifest.FileSize, 10))
+	w.Header().Set("ETag", fileETag(manifest))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Once streaming starts the status can no longer change, so a chunk that cannot be read ends the response early
+	err := storage.ReassembleFile(w, manifest, nil, func(index int) ([]byte, error) {
+		return server.readChunk(manifest.ChunkHashes[index])
+	})
+	if err != nil {
+		logger.Error("error encountered when writing served file", "merkleRoot", hex.EncodeToString(merkleRoot),
+			"error", err)
+	}
+}
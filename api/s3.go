@@ -0,0 +1,481 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/upload"
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namespace of the XML documents returned by the S3 endpoint
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Greatest number of objects returned by one listing, which S3 clients expect to page through
+const maxListedObjects = 1000
+
+// Interval at which an object being stored is checked for having been committed
+const objectPollInterval = 200 * time.Millisecond
+
+// Greatest size of an object stored by default, which is the largest object S3 stores in a single request
+const DefaultMaxObjectSize = 5 << 30
+
+// Names S3 clients accept for buckets, which are the part of an alias before its first slash
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3Error - Error document returned by the S3 endpoint
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// s3Bucket - Bucket listed by the S3 endpoint
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// s3BucketList - Document listing the buckets of the S3 endpoint
+type s3BucketList struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   string     `xml:"Owner>ID"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+// s3Object - Object listed in a bucket by the S3 endpoint
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// s3Prefix - Common prefix of the keys grouped together by a listing's delimiter
+type s3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// s3ObjectList - Document listing the objects of a bucket, in the layout of either version of the listing
+type s3ObjectList struct {
+	XMLName        xml.Name   `xml:"ListBucketResult"`
+	Xmlns          string     `xml:"xmlns,attr"`
+	Name           string     `xml:"Name"`
+	Prefix         string     `xml:"Prefix"`
+	Delimiter      string     `xml:"Delimiter,omitempty"`
+	MaxKeys        int        `xml:"MaxKeys"`
+	IsTruncated    bool       `xml:"IsTruncated"`
+	Marker         *string    `xml:"Marker,omitempty"`
+	NextMarker     string     `xml:"NextMarker,omitempty"`
+	StartAfter     string     `xml:"StartAfter,omitempty"`
+	Token          string     `xml:"ContinuationToken,omitempty"`
+	NextToken      string     `xml:"NextContinuationToken,omitempty"`
+	KeyCount       *int       `xml:"KeyCount,omitempty"`
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []s3Prefix `xml:"CommonPrefixes"`
+}
+
+// Function that serves the S3-compatible object endpoint on the given address (blocks until the server fails)
+// Buckets and keys map onto aliases, so the object stored at key in bucket is the latest version of the file uploaded
+// under the alias bucket/key. Only path-style requests are understood, and requests are not authenticated, so like the
+// local API the endpoint must only be exposed to clients trusted with the node.
+func (server *Server) ServeS3(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", server.handleListBuckets)
+	mux.HandleFunc("PUT /{bucket}", server.handleCreateBucket)
+	mux.HandleFunc("GET /{bucket}", server.handleListObjects)
+	mux.HandleFunc("GET /{bucket}/{$}", server.handleListObjects)
+	mux.HandleFunc("GET /{bucket}/{key...}", server.handleGetObject)
+	mux.HandleFunc("PUT /{bucket}/{key...}", server.handlePutObject)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "operation is not supported")
+	})
+	return http.ListenAndServe(addr, mux)
+}
+
+// Function that writes an S3 error document with the given status
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeXML(w, status, s3Error{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// Function that writes a value encoded as XML with the given status
+func writeXML(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	err := xml.NewEncoder(w).Encode(value)
+	if err != nil {
+		logger.Error("error encountered when writing XML response", "error", err)
+	}
+}
+
+// Function that checks the node can serve objects, writing an error if it cannot
+func (server *Server) servesObjects(w http.ResponseWriter, r *http.Request) bool {
+	if network.Chain == nil || server.Manifests == nil || server.Chunks == nil {
+		writeS3Error(w, r, http.StatusServiceUnavailable, "ServiceUnavailable", "node does not serve objects")
+		return false
+	}
+	return true
+}
+
+// Function that checks the bucket a request names is valid, writing an error if it is not
+func validBucket(w http.ResponseWriter, r *http.Request) bool {
+	if !bucketNamePattern.MatchString(r.PathValue("bucket")) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidBucketName", "invalid bucket name "+r.PathValue("bucket"))
+		return false
+	}
+	return true
+}
+
+// Function that returns the latest version of every object whose alias starts with a prefix, by alias
+// Objects that are not committed, were deleted or are encrypted are left out, as they cannot be read back.
+func (server *Server) latestObjects(prefix string) (map[string]*core.Manifest, error) {
+	manifests, err := server.Manifests.ListManifests()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]*core.Manifest)
+	for _, manifest := range manifests {
+		if strings.HasPrefix(manifest.Alias, prefix) {
+			versions[manifest.Alias] = append(versions[manifest.Alias], manifest)
+		}
+	}
+	objects := make(map[string]*core.Manifest)
+	for alias, aliasVersions := range versions {
+		manifest, err := network.Chain.ResolveManifestAtHeight(aliasVersions, -1)
+		if err != nil || manifest.Encrypted || manifest.Key != nil {
+			continue
+		}
+		if _, err := network.Chain.FindTombstone(manifest.MerkleRoot); err == nil {
+			continue
+		}
+		objects[alias] = manifest
+	}
+	return objects, nil
+}
+
+// Function that returns the time the block committing a file was created, which is when its object was last modified
+func objectModified(manifest *core.Manifest) time.Time {
+	block, err := network.Chain.GetBlockByMerkelRoot(manifest.MerkleRoot)
+	if err != nil {
+		return time.Time{}
+	}
+	return block.Timestamp.UTC()
+}
+
+// Function that handles requests listing the buckets, which are the first segments of the aliases of stored files
+func (server *Server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	if !server.servesObjects(w, r) {
+		return
+	}
+	objects, err := server.latestObjects("")
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	// A bucket is listed as created when its oldest object was
+	created := make(map[string]time.Time)
+	for alias, manifest := range objects {
+		bucket, _, found := strings.Cut(alias, "/")
+		if !found || !bucketNamePattern.MatchString(bucket) {
+			continue
+		}
+		modified := objectModified(manifest)
+		if oldest, seen := created[bucket]; !seen || modified.Before(oldest) {
+			created[bucket] = modified
+		}
+	}
+	list := s3BucketList{Xmlns: s3Namespace, Owner: network.GetHostID(), Buckets: []s3Bucket{}}
+	for bucket, creationDate := range created {
+		list.Buckets = append(list.Buckets, s3Bucket{Name: bucket, CreationDate: creationDate.Format(time.RFC3339)})
+	}
+	sort.Slice(list.Buckets, func(i, j int) bool {
+		return list.Buckets[i].Name < list.Buckets[j].Name
+	})
+	writeXML(w, http.StatusOK, list)
+}
+
+// Function that handles requests creating a bucket
+// Buckets only exist through the aliases of the files stored in them, so there is nothing to create
+func (server *Server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
+	if !validBucket(w, r) {
+		return
+	}
+	w.Header().Set("Location", "/"+r.PathValue("bucket"))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Function that handles requests listing the objects of a bucket, in either version of the S3 listing
+// Keys are listed in order, and a listing that is cut short continues after the last key or common prefix it returned.
+func (server *Server) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	if !server.servesObjects(w, r) || !validBucket(w, r) {
+		return
+	}
+	bucket := r.PathValue("bucket")
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := maxListedObjects
+	if value := query.Get("max-keys"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys "+value)
+			return
+		}
+		maxKeys = min(parsed, maxListedObjects)
+	}
+	version2 := query.Get("list-type") == "2"
+	after := query.Get("marker")
+	if version2 {
+		after = max(query.Get("start-after"), query.Get("continuation-token"))
+	}
+
+	objects, err := server.latestObjects(bucket + "/" + prefix)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	keys := make([]string, 0, len(objects))
+	for alias := range objects {
+		keys = append(keys, strings.TrimPrefix(alias, bucket+"/"))
+	}
+	sort.Strings(keys)
+
+	list := s3ObjectList{Xmlns: s3Namespace, Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys,
+		Contents: []s3Object{}}
+	last := ""
+	for _, key := range keys {
+		if key <= after {
+			continue
+		}
+		// Keys sharing a prefix up to the delimiter are listed once as that prefix
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				if commonPrefix == last || commonPrefix <= after {
+					continue
+				}
+				if len(list.Contents)+len(list.CommonPrefixes) == maxKeys {
+					list.IsTruncated = true
+					break
+				}
+				list.CommonPrefixes = append(list.CommonPrefixes, s3Prefix{Prefix: commonPrefix})
+				last = commonPrefix
+				continue
+			}
+		}
+		if len(list.Contents)+len(list.CommonPrefixes) == maxKeys {
+			list.IsTruncated = true
+			break
+		}
+		manifest := objects[bucket+"/"+key]
+		list.Contents = append(list.Contents, s3Object{Key: key, Size: manifest.FileSize, ETag: fileETag(manifest),
+			LastModified: objectModified(manifest).Format(time.RFC3339), StorageClass: "STANDARD"})
+		last = key
+	}
+
+	if version2 {
+		keyCount := len(list.Contents) + len(list.CommonPrefixes)
+		list.KeyCount = &keyCount
+		list.StartAfter = query.Get("start-after")
+		list.Token = query.Get("continuation-token")
+		if list.IsTruncated {
+			list.NextToken = last
+		}
+	} else {
+		marker := query.Get("marker")
+		list.Marker = &marker
+		if list.IsTruncated {
+			list.NextMarker = last
+		}
+	}
+	writeXML(w, http.StatusOK, list)
+}
+
+// Function that handles requests reading an object, which is streamed like a file served by the gateway
+func (server *Server) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	if !server.servesObjects(w, r) || !validBucket(w, r) {
+		return
+	}
+	versions, err := server.Manifests.ManifestsByAlias(r.PathValue("bucket") + "/" + r.PathValue("key"))
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	manifest, err := network.Chain.ResolveManifestAtHeight(versions, -1)
+	if err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", "no object stored at key "+r.PathValue("key"))
+		return
+	}
+	status, err := checkServedFile(manifest)
+	if err != nil {
+		switch status {
+		case http.StatusNotFound, http.StatusGone:
+			writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", err.Error())
+		case http.StatusForbidden:
+			writeS3Error(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+		default:
+			writeS3Error(w, r, status, "InternalError", err.Error())
+		}
+		return
+	}
+	if r.Header.Get("If-None-Match") == fileETag(manifest) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Last-Modified", objectModified(manifest).Format(http.TimeFormat))
+	server.writeFile(w, r, manifest)
+}
+
+// Function that handles requests storing an object, which is uploaded under the alias bucket/key
+// The response is only sent once the object is committed on the chain, so it can be read back as soon as it is. The
+// upload keeps placing the object on more peers in the background if too few acknowledged storing it in time.
+func (server *Server) handlePutObject(w http.ResponseWriter, r *http.Request) {
+	if !server.servesObjects(w, r) || !validBucket(w, r) {
+		return
+	}
+	key := r.PathValue("key")
+	if r.Header.Get("x-amz-copy-source") != "" {
+		writeS3Error(w, r, http.StatusNotImplemented, "NotImplemented", "copying objects is not supported")
+		return
+	}
+
+	// The body is capped so that a client cannot fill the node's disk with a single object
+	maxSize := server.MaxObjectSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxObjectSize
+	}
+	if r.ContentLength > maxSize {
+		writeS3Error(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", "object is larger than the maximum object size")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+
+	// The body is spooled to a file named after the key, as uploads read the file they store and record its name
+	dir, err := os.MkdirTemp(server.ObjectDir, "object-")
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, path.Base(key))
+	err = spoolObject(filePath, r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeS3Error(w, r, http.StatusRequestEntityTooLarge, "EntityTooLarge", "object is larger than the maximum object size")
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	params := server.ObjectParams
+	params.FilePath = filePath
+	params.Alias = r.PathValue("bucket") + "/" + key
+	id, err := server.Uploads.Submit(params)
+	if err != nil {
+		writeS3Error(w, r, http.StatusServiceUnavailable, "SlowDown", err.Error())
+		return
+	}
+	info, err := server.awaitCommitted(r, id)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("ETag", `"`+info.Result.MerkleRoot+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Function that waits until an upload is committed on the chain, cancelling it if the client goes away first
+// Uploads still being placed on more peers count as committed, as their file is no longer read.
+func (server *Server) awaitCommitted(r *http.Request, id string) (upload.JobInfo, error) {
+	ticker := time.NewTicker(objectPollInterval)
+	defer ticker.Stop()
+	for {
+		info, err := server.Uploads.Get(id)
+		if err != nil {
+			return upload.JobInfo{}, err
+		}
+		switch info.Status {
+		case upload.JobCompleted, upload.JobDegraded:
+			return info, nil
+		case upload.JobFailed, upload.JobCancelled:
+			return upload.JobInfo{}, fmt.Errorf("upload %s: %s", info.Status, info.Error)
+		}
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			// The spooled file is removed once the handler returns, so the upload must have stopped reading it by then
+			server.Uploads.Cancel(id)
+			server.Uploads.Await(context.WithoutCancel(r.Context()), id)
+			return upload.JobInfo{}, r.Context().Err()
+		}
+	}
+}
+
+// Function that writes the body of a request storing an object to a file
+// SDKs that sign their payloads send it in aws-chunked encoding, which is decoded back into the object's contents.
+func spoolObject(filePath string, r *http.Request) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.HasPrefix(r.Header.Get("x-amz-content-sha256"), "STREAMING-") {
+		err = decodeAWSChunked(file, r.Body)
+	} else {
+		_, err = io.Copy(file, r.Body)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Function that decodes a body in aws-chunked encoding, where every chunk is preceded by its hex encoded size and
+// signature on a line of its own, and the body ends with an empty chunk optionally followed by trailing headers
+// Chunk signatures are not checked, as requests are not authenticated.
+func decodeAWSChunked(w io.Writer, body io.Reader) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("error encountered when reading chunk header: %w", err)
+		}
+		sizeField, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeField, 16, 64)
+		if err != nil || size < 0 {
+			return errors.New("invalid chunk size " + sizeField)
+		}
+		if size == 0 {
+			return nil
+		}
+		_, err = io.CopyN(w, reader, size)
+		if err != nil {
+			return fmt.Errorf("error encountered when reading chunk: %w", err)
+		}
+		separator := make([]byte, 2)
+		_, err = io.ReadFull(reader, separator)
+		if err != nil || string(separator) != "\r\n" {
+			return errors.New("chunk is not terminated by CRLF")
+		}
+	}
+}
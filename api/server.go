@@ -0,0 +1,311 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/events"
+	"blockchain-storage/logging"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+// Logger of the local API
+var logger = logging.Component(logging.API)
+
+// Server - Structure holding the subsystems of the running node that the local API exposes
+type Server struct {
+	Uploads   *upload.Scheduler // Scheduler running asynchronous uploads
+	Downloads *DownloadLog      // Reports of recent downloads (nil if the node does not keep them)
+	Events    *events.Bus       // Bus the node's events are published on (nil if the node does not publish them)
+
+	// Function searching the metadata of the node's blockchain (nil if the node does not index its blockchain)
+	QueryBlocks func(query storage.BlockQuery) ([]storage.BlockRecord, error)
+
+	// Function exporting a consistent snapshot of the node's data into a new directory (nil if the node cannot)
+	CreateSnapshot func(dir string, includeChunks bool) (*storage.SnapshotInfo, error)
+
+	// Whether the block explorer's HTML pages are served under /explorer
+	Explorer bool
+	// Store of the manifests the explorer shows file metadata from and the gateway serves files by (nil shows none)
+	Manifests *storage.ManifestStore
+	// Store of the chunks the gateway reassembles files from (nil serves no files)
+	Chunks *storage.ChunkStore
+	// Cache the gateway fetches the missing chunks of unpinned files into (nil fetches them into the chunk store)
+	Cache *storage.ChunkCache
+
+	// Parameters objects stored through the S3 endpoint are uploaded with, apart from their path and alias
+	ObjectParams upload.Params
+	// Directory the bodies of objects are written to while they are uploaded (the system's temporary directory if empty)
+	ObjectDir string
+	// Greatest size in bytes of an object stored through the S3 endpoint (DefaultMaxObjectSize if 0)
+	MaxObjectSize int64
+
+	// Fetches of chunks for downloads that are currently running, which are reported in the node's status
+	transfersMutex sync.Mutex
+	transfers      map[*TransferStatus]struct{}
+}
+
+// PeerStatus - Structure describing a connected peer as reported by the API
+type PeerStatus struct {
+	ID        string                 `json:"id"`        // Peer ID of the peer
+	Addrs     []string               `json:"addrs"`     // Multiaddresses the peer is reachable on
+	Integrity network.IntegrityStats `json:"integrity"` // Chunk verification statistics of the peer
+	Excluded  bool                   `json:"excluded"`  // Whether the peer is currently excluded from chunk requests
+	Score     float64                `json:"score"`     // Reputation score of the peer
+
+	Capabilities *network.Capabilities `json:"capabilities,omitempty"` // Capabilities from the peer's current signed advert
+	Version      *network.NodeVersion  `json:"version,omitempty"`      // Build the peer last attested to running
+}
+
+// SubmitResponse - Structure returned when an upload is submitted
+type SubmitResponse struct {
+	ID string `json:"id"` // Identifier of the upload job
+}
+
+// FetchRequest - Structure describing the chunks of a file to fetch from the network
+type FetchRequest struct {
+	MerkleRoot  []byte   `json:"merkleRoot"`  // Merkle root of the file, used to find its providers
+	ChunkHashes [][]byte `json:"chunkHashes"` // Hashes of the chunks of the file
+	Progress    bool     `json:"progress"`    // Whether to stream a FetchProgress line for each chunk as it is fetched
+
+	// Capability granting access to the chunks of an encrypted file (may be nil)
+	Capability *core.Capability `json:"capability,omitempty"`
+}
+
+// FetchResponse - Structure returned once the missing chunks of a file have been fetched
+type FetchResponse struct {
+	Chunks []network.ChunkFetch `json:"chunks"` // How each chunk that was missing was fetched
+}
+
+// FetchProgress - Line streamed while chunks are fetched for a request asking for progress
+// A line is streamed for every chunk that was missing, followed by a final line holding the whole response
+type FetchProgress struct {
+	Chunk *network.ChunkFetch `json:"chunk,omitempty"` // How a chunk was fetched, as soon as it is done with
+	Done  *FetchResponse      `json:"done,omitempty"`  // How every missing chunk was fetched (final line only)
+	Error string              `json:"error,omitempty"` // Why fetching failed (final line only, empty if it succeeded)
+}
+
+// AuditRequest - Structure describing a file whose providers are challenged for random chunks
+type AuditRequest struct {
+	MerkleRoot  []byte   `json:"merkleRoot"`           // Merkle root of the file, used to find its providers
+	ChunkHashes [][]byte `json:"chunkHashes"`          // Hashes of the chunks of the file
+	Challenges  int      `json:"challenges,omitempty"` // Number of chunks each provider is challenged for (0 for the default)
+	Record      bool     `json:"record,omitempty"`     // Whether failed challenges count against the providers' reputation
+}
+
+// AuditResponse - Structure returned once every provider of a file has been challenged
+type AuditResponse struct {
+	Providers []network.ProviderAudit `json:"providers"` // How each provider answered its challenges
+}
+
+// Function that serves the node's local API on the given address (blocks until the server fails)
+func (server *Server) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", server.handleStatus)
+	mux.HandleFunc("GET /peers", handlePeers)
+	mux.HandleFunc("GET /bandwidth", handleGetBandwidth)
+	mux.HandleFunc("PUT /bandwidth", handleSetBandwidth)
+	mux.HandleFunc("GET /uploads", server.takesUploads(server.handleListUploads))
+	mux.HandleFunc("POST /uploads", server.takesUploads(server.handleSubmitUpload))
+	mux.HandleFunc("GET /uploads/queue", server.takesUploads(server.handleUploadQueue))
+	mux.HandleFunc("GET /uploads/{id}", server.takesUploads(server.handleGetUpload))
+	mux.HandleFunc("DELETE /uploads/{id}", server.takesUploads(server.handleCancelUpload))
+	mux.HandleFunc("POST /chunks/fetch", server.handleFetchChunks)
+	mux.HandleFunc("POST /audits", handleAudit)
+	mux.HandleFunc("GET /downloads", server.handleListDownloads)
+	mux.HandleFunc("POST /downloads", server.handleRecordDownload)
+	mux.HandleFunc("GET /downloads/{id}", server.handleGetDownload)
+	mux.HandleFunc("GET /blocks", server.handleQueryBlocks)
+	mux.HandleFunc("GET /proofs/{root}/{index}", server.handleInclusionProof)
+	mux.HandleFunc("POST /snapshots", server.handleCreateSnapshot)
+	mux.HandleFunc("GET /events", server.handleStreamEvents)
+	if server.Explorer {
+		server.registerExplorer(mux)
+	}
+	// Metrics published through expvar are exposed in JSON form
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// Function that handles requests for the list of connected peers
+func handlePeers(w http.ResponseWriter, r *http.Request) {
+	integrityStats := network.GetIntegrityStats()
+
+	var peers []PeerStatus
+	for _, peerInfo := range network.GetPeers() {
+		status := PeerStatus{
+			ID:        peerInfo.ID.String(),
+			Integrity: integrityStats[peerInfo.ID],
+			Excluded:  network.IsPeerExcluded(peerInfo.ID),
+			Score:     network.GetReputation(peerInfo.ID).Score,
+		}
+		if capabilities, found := network.GetCapabilities(peerInfo.ID); found {
+			status.Capabilities = &capabilities
+		}
+		if version, found := network.GetPeerVersion(peerInfo.ID); found {
+			status.Version = &version
+		}
+		for _, addr := range peerInfo.Addrs {
+			status.Addrs = append(status.Addrs, addr.String())
+		}
+		peers = append(peers, status)
+	}
+	writeJSON(w, peers)
+}
+
+// Function that wraps a handler of upload requests, refusing them if the node takes no uploads (as with bootstrap nodes)
+func (server *Server) takesUploads(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.Uploads == nil {
+			http.Error(w, "node does not take uploads", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Function that handles requests for the list of upload jobs
+func (server *Server) handleListUploads(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, server.Uploads.List())
+}
+
+// Function that handles requests for the state of the upload queue as a whole
+func (server *Server) handleUploadQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, server.Uploads.Status())
+}
+
+// Function that handles the submission of a new upload, returning the job ID without waiting for it to run
+func (server *Server) handleSubmitUpload(w http.ResponseWriter, r *http.Request) {
+	var params upload.Params
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Mining needs at least one worker and one attempt, so the upload would only fail once it had been queued
+	if params.Workers < 1 || params.Retries < 1 {
+		http.Error(w, "workers and retries must be at least 1", http.StatusBadRequest)
+		return
+	}
+	id, err := server.Uploads.Submit(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, SubmitResponse{ID: id})
+}
+
+// Function that handles requests for the state of an upload job
+// If the wait query parameter is set, the response is only sent once the job has finished
+func (server *Server) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var info upload.JobInfo
+	var err error
+	if r.URL.Query().Get("wait") == "true" {
+		// Waiting stops if the client goes away, as the request's context is then cancelled
+		info, err = server.Uploads.Await(r.Context(), id)
+	} else {
+		info, err = server.Uploads.Get(id)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// Function that handles the cancellation of an upload job
+func (server *Server) handleCancelUpload(w http.ResponseWriter, r *http.Request) {
+	err := server.Uploads.Cancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function that handles requests to fetch the chunks of a file that are missing from the node's chunk store
+// The response describes where each missing chunk was fetched from, so that downloads can report it
+func (server *Server) handleFetchChunks(w http.ResponseWriter, r *http.Request) {
+	var request FetchRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The fetch is listed in the node's status while it runs
+	transfer := server.startTransfer(request.MerkleRoot)
+	defer server.endTransfer(transfer)
+
+	// Fetching stops if the client goes away, as the request's context is then cancelled
+	if !request.Progress {
+		fetches, err := network.FetchChunks(r.Context(), request.MerkleRoot, request.ChunkHashes, request.Capability, func(fetch network.ChunkFetch) {
+			server.recordTransfer(transfer, fetch)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, FetchResponse{Chunks: fetches})
+		return
+	}
+
+	// Once streaming starts the status can no longer change, so a failure is reported in the final line instead
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	fetches, err := network.FetchChunks(r.Context(), request.MerkleRoot, request.ChunkHashes, request.Capability, func(fetch network.ChunkFetch) {
+		server.recordTransfer(transfer, fetch)
+		encoder.Encode(FetchProgress{Chunk: &fetch})
+		flusher.Flush()
+	})
+	done := FetchProgress{Done: &FetchResponse{Chunks: fetches}}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	encoder.Encode(done)
+}
+
+// Function that handles requests to challenge every provider of a file for random chunks of it
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	var request AuditRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audits, err := network.AuditProviders(r.Context(), request.MerkleRoot, request.ChunkHashes, request.Challenges,
+		request.Record)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, AuditResponse{Providers: audits})
+}
+
+// Function that writes a value to the response as JSON
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Function that writes an error to the response with a status code matching the error
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, upload.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusConflict)
+}
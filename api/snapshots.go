@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+)
+
+// SnapshotRequest - Structure describing a snapshot of the node's data to create
+type SnapshotRequest struct {
+	Dir           string `json:"dir"`           // Absolute path of the directory to create the snapshot in (must not exist)
+	IncludeChunks bool   `json:"includeChunks"` // Whether to copy the chunk files as well as the chunk index
+}
+
+// Function that handles requests to export a snapshot of the node's data while it runs
+func (server *Server) handleCreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	if server.CreateSnapshot == nil {
+		http.Error(w, "node cannot create snapshots", http.StatusNotFound)
+		return
+	}
+	var request SnapshotRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The directory is resolved by the node, so a relative path would depend on where the node was started
+	if !filepath.IsAbs(request.Dir) {
+		http.Error(w, "snapshot directory must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	info, err := server.CreateSnapshot(request.Dir, request.IncludeChunks)
+	if errors.Is(err, fs.ErrExist) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, info)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Number of the most recent blocks included in the node's status
+const recentBlockCount = 5
+
+// NodeStatus - Structure summarising the state of a running node
+type NodeStatus struct {
+	PeerID       string                 `json:"peerId"`               // Peer ID of the node
+	Addrs        []string               `json:"addrs"`                // Full multiaddresses the node can be reached on
+	ChainLength  int                    `json:"chainLength"`          // Number of blocks in the node's chain
+	Synced       bool                   `json:"synced"`               // Whether the chain is synced with the chains of the node's peers
+	Peers        int                    `json:"peers"`                // Number of connected peers
+	StoragePeers int                    `json:"storagePeers"`         // Number of connected peers that data could be placed on
+	Chunks       int                    `json:"chunks"`               // Number of chunks in the node's chunk store
+	UsedSpace    int64                  `json:"usedSpace"`            // Space taken up by the chunk store on disk
+	StorageQuota int64                  `json:"storageQuota"`         // Space the chunk store may take up before placed chunks are refused (0 for no quota)
+	Cache        *storage.CacheStats    `json:"cache,omitempty"`      // Contents and hit rate of the cache of served chunks (nil if the node has none)
+	RelayCache   *storage.CacheStats    `json:"relayCache,omitempty"` // Contents and hit rate of the cache of chunks fetched for peers (nil unless the node only caches chunks)
+	Credits      *storage.CreditBalance `json:"credits,omitempty"`    // Storage the node provided to and consumed from its peers (nil if it keeps no ledger)
+	RecentBlocks []BlockSummary         `json:"recentBlocks"`         // Most recent blocks, newest first
+	Transfers    []TransferStatus       `json:"transfers"`            // Fetches of chunks for downloads that are currently running
+}
+
+// BlockSummary - Structure describing a block in the node's status
+type BlockSummary struct {
+	Height     int64     `json:"height"`     // Height of the block
+	Hash       string    `json:"hash"`       // Hex encoded hash of the block
+	MerkleRoot string    `json:"merkleRoot"` // Hex encoded Merkle root of the file committed in the block
+	Timestamp  time.Time `json:"timestamp"`  // Time the block was created
+}
+
+// TransferStatus - Structure describing a running fetch of a file's chunks
+type TransferStatus struct {
+	MerkleRoot string    `json:"merkleRoot"` // Hex encoded Merkle root of the file
+	Chunks     int       `json:"chunks"`     // Number of missing chunks done with so far
+	Failed     int       `json:"failed"`     // Number of those chunks that could not be fetched
+	Bytes      int64     `json:"bytes"`      // Size of the chunks fetched so far
+	Started    time.Time `json:"started"`    // Time the fetch started
+}
+
+// Function that handles requests for the status of the node
+func (server *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := NodeStatus{
+		PeerID:       network.GetHostID(),
+		Addrs:        network.GetHostAddrs(),
+		StorageQuota: network.StorageQuota(),
+		Synced:       network.Synced(),
+		Peers:        len(network.GetPeers()),
+		StoragePeers: network.StoragePeerCount(),
+		RecentBlocks: []BlockSummary{},
+		Transfers:    server.activeTransfers(),
+	}
+	if network.Chain != nil {
+		status.ChainLength = network.Chain.Length()
+		err := network.Chain.Iterate(int64(status.ChainLength-recentBlockCount), -1, func(block *core.Block) error {
+			// Blocks are iterated oldest first but listed newest first
+			status.RecentBlocks = append([]BlockSummary{{Height: block.Index, Hash: hex.EncodeToString(block.Hash),
+				MerkleRoot: hex.EncodeToString(block.MerkelRoot), Timestamp: block.Timestamp}}, status.RecentBlocks...)
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if network.Chunks != nil {
+		status.Chunks = len(network.Chunks.Hashes())
+		status.UsedSpace = network.Chunks.UsedSpace()
+	}
+	if network.Cache != nil {
+		stats := network.Cache.Stats()
+		status.Cache = &stats
+	}
+	if network.RelayCache != nil {
+		stats := network.RelayCache.Stats()
+		status.RelayCache = &stats
+	}
+	if network.Ledger != nil {
+		balance := network.Ledger.Balance()
+		status.Credits = &balance
+	}
+	writeJSON(w, status)
+}
+
+// Function that records the start of a fetch of a file's chunks
+func (server *Server) startTransfer(merkleRoot []byte) *TransferStatus {
+	transfer := &TransferStatus{MerkleRoot: hex.EncodeToString(merkleRoot), Started: time.Now()}
+	server.transfersMutex.Lock()
+	defer server.transfersMutex.Unlock()
+	if server.transfers == nil {
+		server.transfers = make(map[*TransferStatus]struct{})
+	}
+	server.transfers[transfer] = struct{}{}
+	return transfer
+}
+
+// Function that records a chunk a running fetch is done with
+func (server *Server) recordTransfer(transfer *TransferStatus, fetch network.ChunkFetch) {
+	server.transfersMutex.Lock()
+	defer server.transfersMutex.Unlock()
+	transfer.Chunks++
+	transfer.Bytes += int64(fetch.Size)
+	if fetch.Error != "" {
+		transfer.Failed++
+	}
+}
+
+// Function that records the end of a fetch
+func (server *Server) endTransfer(transfer *TransferStatus) {
+	server.transfersMutex.Lock()
+	defer server.transfersMutex.Unlock()
+	delete(server.transfers, transfer)
+}
+
+// Function that returns a copy of every running fetch, oldest first
+func (server *Server) activeTransfers() []TransferStatus {
+	server.transfersMutex.Lock()
+	defer server.transfersMutex.Unlock()
+	transfers := []TransferStatus{}
+	for transfer := range server.transfers {
+		transfers = append(transfers, *transfer)
+	}
+	slices.SortFunc(transfers, func(a, b TransferStatus) int { return a.Started.Compare(b.Started) })
+	return transfers
+}
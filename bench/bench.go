@@ -0,0 +1,234 @@
+// Package bench measures how fast the local machine chunks files, builds Merkle trees, mines blocks and stores chunks,
+// so that chunk sizes and worker counts can be picked for it.
+package bench
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Names of the benchmarks
+const (
+	Chunking   = "chunking"
+	Merkle     = "merkle"
+	Mining     = "mining"
+	StoreWrite = "store-write"
+	StoreRead  = "store-read"
+)
+
+// Difficulty blocks are mined at while measuring the hashrate, at which only an all-zero hash would be accepted
+const unreachableDifficulty = 256
+
+// Measurement - Structure describing how fast one benchmark ran with one setting
+type Measurement struct {
+	Benchmark  string        `json:"benchmark"`  // Name of the benchmark
+	Setting    string        `json:"setting"`    // Chunk size, hash algorithm or worker count the benchmark ran with
+	Operations uint64        `json:"operations"` // Number of chunks, hashes or chunk store operations completed
+	Bytes      int64         `json:"bytes"`      // Number of bytes processed (0 for mining)
+	Duration   time.Duration `json:"duration"`   // Time taken
+
+	OpsPerSecond   float64 `json:"opsPerSecond"`   // Operations completed per second
+	BytesPerSecond float64 `json:"bytesPerSecond"` // Bytes processed per second
+}
+
+// Function that creates a measurement and works out its rates
+func newMeasurement(benchmark string, setting string, operations uint64, bytes int64, duration time.Duration) Measurement {
+	measurement := Measurement{Benchmark: benchmark, Setting: setting, Operations: operations, Bytes: bytes,
+		Duration: duration}
+	if seconds := duration.Seconds(); seconds > 0 {
+		measurement.OpsPerSecond = float64(operations) / seconds
+		measurement.BytesPerSecond = float64(bytes) / seconds
+	}
+	return measurement
+}
+
+// Options - Structure holding what the benchmarks are run with
+type Options struct {
+	Dir            string                 // Directory the test file and chunk store are created in (removed afterwards)
+	FileSize       int64                  // Size of the test file chunked and hashed, in bytes
+	ChunkSizes     []int64                // Chunk sizes the test file is chunked with
+	Algorithms     []verify.HashAlgorithm // Hash algorithms Merkle trees are built with
+	Workers        []int                  // Worker counts blocks are mined with
+	MiningDuration time.Duration          // Time blocks are mined for with each worker count
+	StoreChunks    int                    // Number of chunks written to and read back from the chunk store
+	StoreChunkSize int64                  // Size of the chunks written to the chunk store, in bytes
+}
+
+// Function that runs every benchmark, passing each measurement to report as soon as it is made
+// Benchmarks with no settings in the options are skipped. Cancelling the context stops the benchmarks early.
+func Run(ctx context.Context, options Options, report func(Measurement)) error {
+	if options.FileSize <= 0 {
+		return errors.New("test file size must be positive")
+	}
+	dir, err := os.MkdirTemp(options.Dir, "bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "file")
+	contents := make([]byte, options.FileSize)
+	rand.Read(contents)
+	err = os.WriteFile(filePath, contents, 0644)
+	if err != nil {
+		return err
+	}
+
+	var chunks [][]byte
+	for _, chunkSize := range options.ChunkSizes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		measurement, fileChunks, err := measureChunking(filePath, chunkSize)
+		if err != nil {
+			return err
+		}
+		report(measurement)
+		chunks = fileChunks
+	}
+	// Merkle trees are built over the chunks of the last chunk size, or over chunks of the whole file without one
+	if chunks == nil {
+		chunks = [][]byte{contents}
+	}
+	for _, algorithm := range options.Algorithms {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		measurement, err := measureMerkle(algorithm, chunks)
+		if err != nil {
+			return err
+		}
+		report(measurement)
+	}
+	for _, workers := range options.Workers {
+		measurement, err := measureMining(ctx, workers, options.MiningDuration)
+		if err != nil {
+			return err
+		}
+		report(measurement)
+	}
+	if options.StoreChunks > 0 {
+		measurements, err := measureStore(ctx, filepath.Join(dir, "chunks"), options.StoreChunks, options.StoreChunkSize)
+		if err != nil {
+			return err
+		}
+		for _, measurement := range measurements {
+			report(measurement)
+		}
+	}
+	return nil
+}
+
+// Function that measures how fast a file is split into chunks of a size
+func measureChunking(filePath string, chunkSize int64) (Measurement, [][]byte, error) {
+	if err := core.ValidateChunkSize(chunkSize); err != nil {
+		return Measurement{}, nil, err
+	}
+	start := time.Now()
+	chunks, err := core.ChunkFileBytes(filePath, chunkSize)
+	if err != nil {
+		return Measurement{}, nil, err
+	}
+	var size int64
+	for _, chunk := range chunks {
+		size += int64(len(chunk))
+	}
+	setting := fmt.Sprintf("chunk size %d", chunkSize)
+	return newMeasurement(Chunking, setting, uint64(len(chunks)), size, time.Since(start)), chunks, nil
+}
+
+// Function that measures how fast a Merkle tree is built over chunks with an algorithm, which includes hashing them
+func measureMerkle(algorithm verify.HashAlgorithm, chunks [][]byte) (Measurement, error) {
+	if !algorithm.Available() {
+		return Measurement{}, fmt.Errorf("%w: %s", verify.ErrUnknownHash, algorithm)
+	}
+	var size int64
+	for _, chunk := range chunks {
+		size += int64(len(chunk))
+	}
+	start := time.Now()
+	_, err := core.NewMerkleTreeWith(algorithm, chunks)
+	if err != nil {
+		return Measurement{}, err
+	}
+	return newMeasurement(Merkle, string(algorithm.Canonical()), uint64(len(chunks)), size, time.Since(start)), nil
+}
+
+// Function that measures the proof of work hashrate of a number of workers by mining a block that can never be mined
+// for a while
+// Operations are the hashes calculated up to the last progress report, so the duration should span a few reports.
+func measureMining(ctx context.Context, workers int, duration time.Duration) (Measurement, error) {
+	if workers <= 0 {
+		return Measurement{}, fmt.Errorf("invalid worker count %d", workers)
+	}
+	blockchain := core.NewBlockchain(core.NewMemoryChainStore())
+	blockchain.AddBlock(core.NewGenesisBlock(time.Now()))
+	block := core.CreateBlock(blockchain, make([]byte, 32), core.Records{}, nil, verify.SHA256)
+
+	var last core.MiningProgress
+	miningCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	err := block.MineWithProgress(miningCtx, unreachableDifficulty, workers, 1, func(progress core.MiningProgress) {
+		last = progress
+	})
+	if ctx.Err() != nil {
+		return Measurement{}, ctx.Err()
+	}
+	if !errors.Is(err, core.ErrMiningAborted) {
+		return Measurement{}, fmt.Errorf("mining did not run for the whole benchmark: %v", err)
+	}
+	setting := fmt.Sprintf("workers %d", workers)
+	return newMeasurement(Mining, setting, last.Attempts, 0, last.Elapsed), nil
+}
+
+// Function that measures how many chunks a chunk store in a directory writes and then reads back per second
+func measureStore(ctx context.Context, dir string, count int, chunkSize int64) ([]Measurement, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size must be positive")
+	}
+	chunkStore, err := storage.NewChunkStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		chunks[i] = make([]byte, chunkSize)
+		rand.Read(chunks[i])
+	}
+	setting := fmt.Sprintf("chunk size %d", chunkSize)
+
+	hashes := make([][]byte, 0, count)
+	start := time.Now()
+	for _, chunk := range chunks {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		hash, err := chunkStore.PutChunk(chunk)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	written := newMeasurement(StoreWrite, setting, uint64(count), int64(count)*chunkSize, time.Since(start))
+
+	start = time.Now()
+	for _, hash := range hashes {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		_, err := chunkStore.GetChunk(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	read := newMeasurement(StoreRead, setting, uint64(count), int64(count)*chunkSize, time.Since(start))
+	return []Measurement{written, read}, nil
+}
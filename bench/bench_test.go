@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/verify"
+	"context"
+	"testing"
+	"time"
+)
+
+// Tests that every benchmark reports a measurement for each of its settings, with rates worked out from them
+func TestRun(t *testing.T) {
+	interval := core.MiningProgressInterval
+	core.MiningProgressInterval = 10 * time.Millisecond
+	defer func() { core.MiningProgressInterval = interval }()
+
+	options := Options{
+		Dir:            t.TempDir(),
+		FileSize:       64 * 1024,
+		ChunkSizes:     []int64{4 * 1024, 16 * 1024},
+		Algorithms:     []verify.HashAlgorithm{verify.SHA256, verify.BLAKE3},
+		Workers:        []int{1, 2},
+		MiningDuration: 100 * time.Millisecond,
+		StoreChunks:    8,
+		StoreChunkSize: 1024,
+	}
+	var measurements []Measurement
+	err := Run(context.Background(), options, func(measurement Measurement) {
+		measurements = append(measurements, measurement)
+	})
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+
+	expected := []string{Chunking, Chunking, Merkle, Merkle, Mining, Mining, StoreWrite, StoreRead}
+	if len(measurements) != len(expected) {
+		t.Fatalf("FAIL: Expected %d measurements, got %+v", len(expected), measurements)
+	}
+	for i, measurement := range measurements {
+		if measurement.Benchmark != expected[i] || measurement.Operations == 0 || measurement.OpsPerSecond <= 0 {
+			t.Errorf("FAIL: Expected a %s measurement with operations, got %+v", expected[i], measurement)
+		}
+	}
+	if measurements[0].Operations != 16 || measurements[1].Operations != 4 || measurements[0].Bytes != options.FileSize {
+		t.Errorf("FAIL: Expected the file to be chunked into 16 and then 4 chunks, got %+v", measurements[:2])
+	}
+	if measurements[2].Setting != string(verify.SHA256) || measurements[3].Setting != string(verify.BLAKE3) {
+		t.Errorf("FAIL: Expected Merkle trees to be built with each algorithm, got %+v", measurements[2:4])
+	}
+}
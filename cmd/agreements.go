@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var agreementsCmd = &cobra.Command{
+	Use:   "agreements <name|root>",
+	Short: "Lists the storage agreements recorded for a file",
+	Long: `This command lists every storage agreement recorded on the chain for a file, oldest first: which provider
+			promised its uploader to store the file, at what replication and until when. Agreements are recorded by
+			uploads made with --agreement once every replica has been placed, and each one is signed by its provider.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := blockchain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		agreements, err := blockchain.Agreements(merkleRoot)
+		if err != nil {
+			return err
+		}
+		return printResult(nonNil(agreements), func() {
+			now := time.Now()
+			for _, agreement := range agreements {
+				state := "active"
+				if now.After(agreement.Expiry) {
+					state = "expired"
+				}
+				fmt.Printf("block=%d  provider=%s  uploader=%s  replication=%d  until %s  %s\n", agreement.BlockIndex,
+					agreement.Provider, agreement.Uploader, agreement.Replication, agreement.Expiry.Format(time.RFC3339),
+					state)
+			}
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agreementsCmd)
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+)
+
+var auditChallenges int
+var auditRecord bool
+
+var auditCmd = &cobra.Command{
+	Use:   "audit <name|root>",
+	Short: "Challenges the providers of a file to prove they store it",
+	Long: `This command has the running node find every provider of a file and challenge each one for random chunks of
+			it, which the provider must serve matching their hashes. Whether each provider passed is reported, and with
+			--record every failed challenge counts against the provider's reputation on the node. The command fails if
+			any provider fails a challenge.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := blockchain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		// The chunks to challenge providers for are taken from the manifest
+		manifest, err := manifestStore.GetManifest(merkleRoot)
+		if err != nil {
+			return fmt.Errorf("no manifest for file %x: %w", merkleRoot, err)
+		}
+
+		request := api.AuditRequest{MerkleRoot: manifest.MerkleRoot, ChunkHashes: manifest.ChunkHashes,
+			Challenges: auditChallenges, Record: auditRecord}
+		var response api.AuditResponse
+		err = api.Request(apiAddr, http.MethodPost, "/audits", request, &response)
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, audit := range response.Providers {
+			if audit.Passed < audit.Challenges {
+				failed++
+			}
+		}
+		err = printResult(response, func() {
+			for _, audit := range response.Providers {
+				printProviderAudit(audit)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d providers failed the audit", failed, len(response.Providers))
+		}
+		return nil
+	},
+}
+
+// Function that prints how a provider answered its challenges, followed by each challenge it failed
+func printProviderAudit(audit network.ProviderAudit) {
+	result := "PASS"
+	if audit.Passed < audit.Challenges {
+		result = "FAIL"
+	}
+	fmt.Printf("%s  %s  %d/%d challenges passed\n", result, audit.Peer, audit.Passed, audit.Challenges)
+	for _, failure := range audit.Failures {
+		fmt.Printf("      %s: %s\n", failure.Hash, failure.Error)
+	}
+}
+
+func init() {
+	auditCmd.Flags().IntVar(&auditChallenges, "challenges", network.DefaultAuditChallenges, "Number of random chunks each provider is challenged for")
+	auditCmd.Flags().BoolVar(&auditRecord, "record", false, "Count failed challenges against the providers' reputation")
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/network"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+)
+
+var newBandwidthKiB bandwidthFlags
+
+// bandwidthFlags - Structure holding bandwidth limits given on the command line in KiB per second (0 for no limit)
+type bandwidthFlags struct {
+	upload       int64
+	download     int64
+	peerUpload   int64
+	peerDownload int64
+}
+
+var bandwidthCmd = &cobra.Command{
+	Use:   "bandwidth",
+	Short: "Prints the bandwidth limits of a running node",
+	Long:  `This command prints the rates a running node's chunk transfers with its peers are limited to`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var limits network.BandwidthLimits
+		err := api.Get(apiAddr, "/bandwidth", &limits)
+		if err != nil {
+			return err
+		}
+		return printResult(limits, func() { printBandwidthLimits(limits) })
+	},
+}
+
+var bandwidthSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Changes the bandwidth limits of a running node",
+	Long: `This command changes the rates a running node's chunk transfers are limited to, including transfers that are
+			already running. Limits that are not given are left as they are, e.g.
+			bandwidth set --max-upload 512 --max-peer-download 0`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var limits network.BandwidthLimits
+		err := api.Get(apiAddr, "/bandwidth", &limits)
+		if err != nil {
+			return err
+		}
+		given := newBandwidthKiB.limits()
+		if cmd.Flags().Changed("max-upload") {
+			limits.Upload = given.Upload
+		}
+		if cmd.Flags().Changed("max-download") {
+			limits.Download = given.Download
+		}
+		if cmd.Flags().Changed("max-peer-upload") {
+			limits.PeerUpload = given.PeerUpload
+		}
+		if cmd.Flags().Changed("max-peer-download") {
+			limits.PeerDownload = given.PeerDownload
+		}
+
+		err = api.Request(apiAddr, http.MethodPut, "/bandwidth", limits, &limits)
+		if err != nil {
+			return err
+		}
+		return printResult(limits, func() { printBandwidthLimits(limits) })
+	},
+}
+
+// Function that adds the flags setting each bandwidth limit to a command
+func (flags *bandwidthFlags) register(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&flags.upload, "max-upload", 0, "KiB per second the node may send to all peers together (0 for no limit)")
+	cmd.Flags().Int64Var(&flags.download, "max-download", 0, "KiB per second the node may receive from all peers together (0 for no limit)")
+	cmd.Flags().Int64Var(&flags.peerUpload, "max-peer-upload", 0, "KiB per second the node may send to a single peer (0 for no limit)")
+	cmd.Flags().Int64Var(&flags.peerDownload, "max-peer-download", 0, "KiB per second the node may receive from a single peer (0 for no limit)")
+}
+
+// Function that converts the limits given on the command line into bytes per second
+func (flags *bandwidthFlags) limits() network.BandwidthLimits {
+	return network.BandwidthLimits{
+		Upload:       flags.upload << 10,
+		Download:     flags.download << 10,
+		PeerUpload:   flags.peerUpload << 10,
+		PeerDownload: flags.peerDownload << 10,
+	}
+}
+
+// Function that prints each bandwidth limit on its own line
+func printBandwidthLimits(limits network.BandwidthLimits) {
+	fmt.Printf("Upload         %s\n", formatRate(limits.Upload))
+	fmt.Printf("Download       %s\n", formatRate(limits.Download))
+	fmt.Printf("Peer upload    %s\n", formatRate(limits.PeerUpload))
+	fmt.Printf("Peer download  %s\n", formatRate(limits.PeerDownload))
+}
+
+// Function that formats a rate in bytes per second, with a rate of 0 meaning no limit
+func formatRate(bytesPerSecond int64) string {
+	if bytesPerSecond == 0 {
+		return "no limit"
+	}
+	return formatSize(bytesPerSecond) + "/s"
+}
+
+func init() {
+	rootCmd.AddCommand(bandwidthCmd)
+	bandwidthCmd.AddCommand(bandwidthSetCmd)
+	newBandwidthKiB.register(bandwidthSetCmd)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"blockchain-storage/bench"
+	"blockchain-storage/verify"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"runtime"
+	"time"
+)
+
+var benchFileSize int64
+var benchChunkSizes []string
+var benchHashes []string
+var benchWorkers []int
+var benchMiningDuration time.Duration
+var benchStoreChunks int
+var benchStoreChunkSize string
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measures how fast this machine chunks, hashes, mines and stores files",
+	Long: `This command measures how fast a random test file is split into chunks of each chunk size, how fast Merkle
+			trees are built over its chunks with each hash algorithm, the proof of work hashrate of each number of
+			mining workers, and how many chunks per second the chunk store writes and reads back. The test file and
+			chunk store are created in the system's temporary directory and removed afterwards. The fastest chunk size
+			and worker count are suggested at the end, which can be given to "upload --chunk-size" and "--workers".`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options := bench.Options{
+			Dir:            os.TempDir(),
+			FileSize:       benchFileSize * 1024 * 1024,
+			Workers:        benchWorkers,
+			MiningDuration: benchMiningDuration,
+			StoreChunks:    benchStoreChunks,
+		}
+		for _, value := range benchChunkSizes {
+			chunkSize, err := parseChunkSize(value)
+			if err != nil || chunkSize == 0 {
+				return fmt.Errorf("invalid chunk size: %s", value)
+			}
+			options.ChunkSizes = append(options.ChunkSizes, chunkSize)
+		}
+		for _, name := range benchHashes {
+			algorithm := verify.HashAlgorithm(name)
+			if !algorithm.Available() {
+				return fmt.Errorf("unknown hash algorithm: %s", name)
+			}
+			options.Algorithms = append(options.Algorithms, algorithm)
+		}
+		storeChunkSize, err := parseChunkSize(benchStoreChunkSize)
+		if err != nil || storeChunkSize == 0 {
+			return fmt.Errorf("invalid chunk size: %s", benchStoreChunkSize)
+		}
+		options.StoreChunkSize = storeChunkSize
+
+		var fastestChunking, fastestMining bench.Measurement
+		err = bench.Run(cmd.Context(), options, func(measurement bench.Measurement) {
+			switch {
+			case measurement.Benchmark == bench.Chunking && measurement.BytesPerSecond > fastestChunking.BytesPerSecond:
+				fastestChunking = measurement
+			case measurement.Benchmark == bench.Mining && measurement.OpsPerSecond > fastestMining.OpsPerSecond:
+				fastestMining = measurement
+			}
+			printResult(measurement, func() {
+				fmt.Printf("%-12s %-20s %s\n", measurement.Benchmark, measurement.Setting, formatMeasurement(measurement))
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if !jsonOutput && fastestChunking.Benchmark != "" {
+			fmt.Printf("Fastest chunking: %s\n", fastestChunking.Setting)
+		}
+		if !jsonOutput && fastestMining.Benchmark != "" {
+			fmt.Printf("Fastest mining: %s\n", fastestMining.Setting)
+		}
+		return nil
+	},
+}
+
+// Function that formats the rates of a measurement in the units that suit its benchmark
+func formatMeasurement(measurement bench.Measurement) string {
+	switch measurement.Benchmark {
+	case bench.Mining:
+		return formatHashrate(measurement.OpsPerSecond)
+	case bench.StoreWrite, bench.StoreRead:
+		return fmt.Sprintf("%.0f chunks/s (%s/s)", measurement.OpsPerSecond, formatSize(int64(measurement.BytesPerSecond)))
+	default:
+		return fmt.Sprintf("%s/s (%.0f chunks/s)", formatSize(int64(measurement.BytesPerSecond)), measurement.OpsPerSecond)
+	}
+}
+
+// Function that returns the worker counts benchmarked by default, doubling from 1 up to the number of CPUs
+func defaultBenchWorkers() []int {
+	var workers []int
+	for count := 1; count < runtime.NumCPU(); count *= 2 {
+		workers = append(workers, count)
+	}
+	return append(workers, runtime.NumCPU())
+}
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	var hashes []string
+	for _, algorithm := range verify.HashAlgorithms() {
+		hashes = append(hashes, string(algorithm))
+	}
+	benchCmd.Flags().Int64Var(&benchFileSize, "file-size", 64, "Size of the test file in MB")
+	benchCmd.Flags().StringSliceVar(&benchChunkSizes, "chunk-size", []string{"256KB", "1MB", "4MB", "16MB"}, "Chunk size to split the test file with, e.g. 512KB or 16MB (repeatable)")
+	benchCmd.Flags().StringSliceVar(&benchHashes, "hash", hashes, "Hash algorithm to build Merkle trees with (repeatable)")
+	benchCmd.Flags().IntSliceVar(&benchWorkers, "workers", defaultBenchWorkers(), "Number of mining workers to measure the hashrate of (repeatable)")
+	benchCmd.Flags().DurationVar(&benchMiningDuration, "mining-duration", 3*time.Second, "Time to mine for with each number of workers")
+	benchCmd.Flags().IntVar(&benchStoreChunks, "store-chunks", 200, "Number of chunks written to and read back from the chunk store (0 skips it)")
+	benchCmd.Flags().StringVar(&benchStoreChunkSize, "store-chunk-size", "1MB", "Size of the chunks written to the chunk store")
+}
@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var exportPath, exportFormat string
+var queryUploader, queryFrom, queryTo, querySince, queryTag, queryFileName string
+
+var chainCmd = &cobra.Command{
+	Use:   "chain",
+	Short: "Manages the local copy of the blockchain",
+	Long:  `This command groups together the operations on the blockchain held by this node`,
+	// No run function needed as this command only groups subcommands
+}
+
+var exportChainCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the blockchain as a portable archive",
+	Long: `This command writes every block in the chain store to a single file, which can be imported by another node
+			with 'chain import' to bootstrap it or restore a backup. By default the file is an archive holding the blocks
+			in their canonical binary encoding with a checksum trailer, while --format json writes the blocks as JSON.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chainStore, err := openChainStore()
+		if err != nil {
+			return err
+		}
+		blockchain := core.NewBlockchain(chainStore)
+		path := exportPath
+		var blocks int
+		switch exportFormat {
+		case "archive":
+			if path == "" {
+				path = chainArchivePath
+			}
+			blocks, err = writeChainArchive(blockchain, path)
+		case "json":
+			if path == "" {
+				path = blockchainPath
+			}
+			err = blockchain.WriteToFile(path)
+			blocks = blockchain.Length()
+		default:
+			return fmt.Errorf("invalid export format: %s. The format must be archive or json", exportFormat)
+		}
+		if err != nil {
+			return err
+		}
+		result := struct {
+			Blocks int    `json:"blocks"` // Number of blocks exported
+			Path   string `json:"path"`   // File the blocks were exported to
+			Format string `json:"format"` // Format of the file (archive or json)
+		}{blocks, path, exportFormat}
+		return printResult(result, func() { fmt.Printf("Exported %d blocks to %s\n", result.Blocks, result.Path) })
+	},
+}
+
+var importChainCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Imports the blocks of a chain archive",
+	Long: `This command verifies a chain archive written by 'chain export' and adds its blocks to the chain store.
+			Blocks the node already holds must match the archive, so an archive can bootstrap a fresh node or extend the
+			chain of a node that is behind, but never replaces blocks. The node must not be running.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// A running node holds the chain store open and would not see the imported blocks
+		var status api.NodeStatus
+		if api.Get(apiAddr, "/status", &status) == nil {
+			return errors.New("the node is running, stop it before importing a chain archive")
+		}
+		archive, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		blocks, err := core.ReadArchive(archive)
+		if err != nil {
+			return err
+		}
+		chainStore, err := openChainStore()
+		if err != nil {
+			return err
+		}
+		blockchain, err := configureBlockchain(chainStore)
+		if err != nil {
+			return err
+		}
+		added, err := blockchain.ImportBlocks(blocks, core.MiningDifficulty)
+		if err != nil {
+			return err
+		}
+		result := struct {
+			Blocks int `json:"blocks"` // Number of blocks in the archive
+			Added  int `json:"added"`  // Number of blocks the node did not already hold
+			Height int `json:"height"` // Number of blocks in the chain after the import
+		}{len(blocks), added, blockchain.Length()}
+		return printResult(result, func() {
+			fmt.Printf("Imported %d new blocks out of %d, the chain now has %d blocks\n", result.Added, result.Blocks,
+				result.Height)
+		})
+	},
+}
+
+var queryChainCmd = &cobra.Command{
+	Use:     "query",
+	Aliases: []string{"list"},
+	Short:   "Searches the blockchain by uploader, time, tag and file name",
+	Long: `This command searches the block metadata kept in a SQLite index, which is brought up to date with the chain
+store before every query. Dates are given as YYYY-MM-DD, with --to being exclusive, e.g. every upload by a peer in
+March is --uploader <peer ID> --from 2026-03-01 --to 2026-04-01, while --since 30d covers the last 30 days.
+A running node answers the same queries on its local API at GET /blocks?uploader=...&since=30d`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		query, err := api.ParseBlockQuery(url.Values{
+			"uploader": {queryUploader},
+			"from":     {queryFrom},
+			"to":       {queryTo},
+			"since":    {querySince},
+			"tag":      {queryTag},
+			"file":     {queryFileName},
+		}, time.Now())
+		if err != nil {
+			return err
+		}
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		blockIndex, err := storage.NewBlockIndex(blockIndexPath)
+		if err != nil {
+			return err
+		}
+		defer blockIndex.Close()
+
+		err = blockIndex.Sync(blockchain, manifestStore)
+		if err != nil {
+			return err
+		}
+		records, err := blockIndex.Query(query)
+		if err != nil {
+			return err
+		}
+		return printResult(nonNil(records), func() {
+			for _, record := range records {
+				fmt.Printf("%d  %s  %x  uploader=%x  %s", record.Height, record.Timestamp.Format(time.RFC3339),
+					record.MerkleRoot, record.Uploader, record.FileName)
+				if len(record.Tags) > 0 {
+					fmt.Printf("  tags=%s", strings.Join(record.Tags, ","))
+				}
+				fmt.Println()
+			}
+		})
+	},
+}
+
+var pruneChainCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Keeps only the headers of blocks beyond the prune depth",
+	Long: `This command reduces every block older than --prune-depth blocks to its header, which is enough to validate the
+			chain and proofs of inclusion. Nodes started with --prune-depth keep their chain pruned as blocks are added.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pruneDepth <= 0 {
+			return errors.New("a prune depth greater than 0 must be given with --prune-depth")
+		}
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		pruned, err := blockchain.Prune()
+		if err != nil {
+			return err
+		}
+		result := struct {
+			Pruned     int `json:"pruned"`     // Number of blocks reduced to their header
+			PruneDepth int `json:"pruneDepth"` // Number of most recent blocks kept in full
+		}{pruned, pruneDepth}
+		return printResult(result, func() {
+			fmt.Printf("Pruned %d blocks, keeping the last %d blocks in full\n", result.Pruned, result.PruneDepth)
+		})
+	},
+}
+
+var namesChainCmd = &cobra.Command{
+	Use:   "names",
+	Short: "Lists the names claimed in the on-chain name registry",
+	Long: `This command lists every name claimed with "upload --name", along with the file it points at and its owner.
+			Any of the names can be given to "download" instead of an alias.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		names, err := blockchain.Names()
+		if err != nil {
+			return err
+		}
+		return printResult(names, func() {
+			for _, claim := range names {
+				fmt.Printf("%s  %x  owner=%x  height=%d\n", claim.Name, claim.MerkleRoot, claim.Owner, claim.Height)
+			}
+		})
+	},
+}
+
+// Function that writes a chain archive of the blockchain to a file, returning how many blocks it holds
+// The archive is written to a temporary file that is renamed once complete, so a failed export never leaves a partial
+// archive behind
+func writeChainArchive(blockchain *core.Blockchain, path string) (int, error) {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tempFile.Name())
+	blocks, err := blockchain.WriteArchive(tempFile)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return blocks, os.Rename(tempFile.Name(), path)
+}
+
+// Function that opens the chain store of the configured backend
+func openChainStore() (core.ChainStore, error) {
+	path, err := chainStoreFile()
+	if err != nil {
+		return nil, err
+	}
+	return openChainStoreAt(path)
+}
+
+// Function that returns the path of the file the chain store of the configured backend keeps the blocks in
+func chainStoreFile() (string, error) {
+	switch chainBackend {
+	case "bolt":
+		return chainStorePath, nil
+	case "ndjson":
+		return blocksFilePath, nil
+	case "json":
+		return blockchainPath, nil
+	default:
+		return "", fmt.Errorf("invalid chain backend: %s. The backend must be bolt, ndjson or json", chainBackend)
+	}
+}
+
+// Function that opens a chain store of the configured backend keeping the blocks in the given file
+func openChainStoreAt(path string) (core.ChainStore, error) {
+	switch chainBackend {
+	case "bolt":
+		return storage.NewBoltChainStore(path)
+	case "ndjson":
+		return storage.NewNDJSONChainStore(path)
+	case "json":
+		return storage.NewJSONChainStore(path)
+	default:
+		return nil, fmt.Errorf("invalid chain backend: %s. The backend must be bolt, ndjson or json", chainBackend)
+	}
+}
+
+// Function that loads the blockchain from the chain store of the configured backend
+// The first time a chain store is used it is empty, so any blockchain previously saved as JSON is imported into it
+func loadBlockchain() (*core.Blockchain, error) {
+	chainStore, err := openChainStore()
+	if err != nil {
+		return nil, err
+	}
+	blockchain, err := configureBlockchain(chainStore)
+	if err != nil {
+		return nil, err
+	}
+	if blockchain.Length() > 0 {
+		return blockchain, nil
+	}
+
+	blocks, err := core.BlocksFromFile(blockchainPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("blockchain is empty and there is no blockchain file to import")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		err := blockchain.AddBlock(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blockchain, nil
+}
+
+// Function that creates a blockchain on top of a chain store with the prune depth and checkpoints given on the
+// command line
+func configureBlockchain(chainStore core.ChainStore) (*core.Blockchain, error) {
+	blockchain := core.NewBlockchain(chainStore)
+	blockchain.PruneDepth = pruneDepth
+	// Copy the built-in checkpoints so that adding to them does not change the shared list
+	blockchain.Checkpoints = append([]core.Checkpoint{}, core.DefaultCheckpoints...)
+	for _, value := range checkpoints {
+		checkpoint, err := core.ParseCheckpoint(value)
+		if err != nil {
+			return nil, err
+		}
+		blockchain.Checkpoints = append(blockchain.Checkpoints, checkpoint)
+	}
+	return blockchain, nil
+}
+
+func init() {
+	rootCmd.AddCommand(chainCmd)
+	chainCmd.AddCommand(exportChainCmd)
+	exportChainCmd.Flags().StringVarP(&exportPath, "output", "o", "", "Path to write the file to (defaults to "+chainArchivePath+" for archives and "+blockchainPath+" for JSON)")
+	exportChainCmd.Flags().StringVar(&exportFormat, "format", "archive", "Format of the exported file (archive or json)")
+	chainCmd.AddCommand(importChainCmd)
+	chainCmd.AddCommand(queryChainCmd)
+	chainCmd.AddCommand(pruneChainCmd)
+	chainCmd.AddCommand(namesChainCmd)
+	queryChainCmd.Flags().StringVar(&queryUploader, "uploader", "", "Only blocks uploaded by this peer ID or hex encoded public key")
+	queryChainCmd.Flags().StringVar(&queryFrom, "from", "", "Only blocks created on or after this date (YYYY-MM-DD)")
+	queryChainCmd.Flags().StringVar(&queryTo, "to", "", "Only blocks created before this date (YYYY-MM-DD)")
+	queryChainCmd.Flags().StringVar(&querySince, "since", "", "Only blocks created within this long before now, e.g. 30d, 2w or 12h")
+	queryChainCmd.Flags().StringVar(&queryTag, "tag", "", "Only blocks whose file was uploaded with this tag")
+	queryChainCmd.Flags().StringVar(&queryFileName, "file", "", "Only blocks whose file name or alias contains this text")
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/upload"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"time"
+)
+
+// Escape sequence that moves the cursor to the top left of the terminal and clears it
+const clearScreen = "\033[H\033[2J"
+
+// Number of peers listed on the dashboard, with the best ranked peers listed first
+const dashboardPeerCount = 10
+
+var refreshInterval time.Duration
+
+var dashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Shows a live view of a running node",
+	Long: `This command fills the terminal with a view of a running node that is refreshed from its local API: the
+			connected peers, the chain height and most recent blocks, the uploads and downloads in progress and the space
+			taken up by the chunk store. Press Ctrl-C to leave.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !isatty.IsTerminal(os.Stdout.Fd()) {
+			return errors.New("the dashboard needs a terminal, use --json with the peers and jobs commands for scripts")
+		}
+		if refreshInterval < 100*time.Millisecond {
+			return fmt.Errorf("invalid refresh interval: %s. The interval must be at least 100ms", refreshInterval)
+		}
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			fmt.Print(clearScreen + renderDashboard())
+			select {
+			case <-cmd.Context().Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// Function that renders the dashboard from the node's API, showing why the node could not be queried if it cannot
+func renderDashboard() string {
+	var screen strings.Builder
+	var status api.NodeStatus
+	var peers []api.PeerStatus
+	var jobs []upload.JobInfo
+	err := api.Get(apiAddr, "/status", &status)
+	if err == nil {
+		err = api.Get(apiAddr, "/peers", &peers)
+	}
+	if err == nil {
+		err = api.Get(apiAddr, "/uploads", &jobs)
+	}
+	fmt.Fprintf(&screen, "Node %s on %s    %s\n\n", shortID(status.PeerID), apiAddr, time.Now().Format(time.TimeOnly))
+	if err != nil {
+		fmt.Fprintf(&screen, "Node unreachable: %s\n", err)
+		return screen.String()
+	}
+
+	synced := "synced"
+	if !status.Synced {
+		synced = "syncing"
+	}
+	fmt.Fprintf(&screen, "Chain    %d blocks (%s)\n", status.ChainLength, synced)
+	fmt.Fprintf(&screen, "Storage  %d chunks, %s used\n", status.Chunks, formatSize(status.UsedSpace))
+	if cache := status.Cache; cache != nil {
+		fmt.Fprintf(&screen, "Cache    %d chunks, %s in memory, %s on disk, %d hits, %d misses\n",
+			cache.MemoryChunks+cache.DiskChunks, formatSize(cache.MemoryBytes), formatSize(cache.DiskBytes), cache.Hits,
+			cache.Misses)
+	}
+	if cache := status.RelayCache; cache != nil {
+		fmt.Fprintf(&screen, "Relay    %d chunks, %s in memory, %s on disk, %d hits, %d misses\n",
+			cache.MemoryChunks+cache.DiskChunks, formatSize(cache.MemoryBytes), formatSize(cache.DiskBytes), cache.Hits,
+			cache.Misses)
+	}
+	if credits := status.Credits; credits != nil {
+		fmt.Fprintf(&screen, "Credits  %s stored for peers, %s stored by peers, balance %s\n", formatSize(credits.Provided),
+			formatSize(credits.Consumed), formatBalance(credits.Balance))
+	}
+	fmt.Fprintf(&screen, "Peers    %d connected, %d storing\n\n", status.Peers, status.StoragePeers)
+
+	fmt.Fprintln(&screen, "PEERS")
+	for i, peerStatus := range peers {
+		if i == dashboardPeerCount {
+			fmt.Fprintf(&screen, "  ... and %d more\n", len(peers)-dashboardPeerCount)
+			break
+		}
+		state := "ok"
+		if peerStatus.Excluded {
+			state = "excluded"
+		}
+		fmt.Fprintf(&screen, "  %-14s score=%-4.0f failure-rate=%.2f  %s\n", shortID(peerStatus.ID), peerStatus.Score,
+			peerStatus.Integrity.FailureRate(), state)
+	}
+
+	fmt.Fprintln(&screen, "\nRECENT BLOCKS")
+	for _, block := range status.RecentBlocks {
+		fmt.Fprintf(&screen, "  %-6d %s  hash=%s  root=%s\n", block.Height, block.Timestamp.Format(time.DateTime),
+			shortID(block.Hash), shortID(block.MerkleRoot))
+	}
+
+	fmt.Fprintln(&screen, "\nACTIVE TRANSFERS")
+	active := 0
+	for _, job := range jobs {
+		if job.Status.IsFinal() {
+			continue
+		}
+		active++
+		fmt.Fprintf(&screen, "  upload    %s  %-9s %s\n", job.ID, job.Status, job.Params.FilePath)
+	}
+	for _, transfer := range status.Transfers {
+		active++
+		fmt.Fprintf(&screen, "  download  root=%s  %d chunks (%d failed), %s in %s\n", shortID(transfer.MerkleRoot),
+			transfer.Chunks, transfer.Failed, formatSize(transfer.Bytes), time.Since(transfer.Started).Round(time.Second))
+	}
+	if active == 0 {
+		fmt.Fprintln(&screen, "  none")
+	}
+	fmt.Fprintln(&screen, "\nPress Ctrl-C to leave")
+	return screen.String()
+}
+
+// Function that shortens a peer ID or hex encoded hash to fit in a column
+func shortID(id string) string {
+	if len(id) <= 12 {
+		return id
+	}
+	return id[:6] + ".." + id[len(id)-4:]
+}
+
+// Function that formats a number of bytes with the largest unit that keeps it above 1
+func formatSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// Function that formats a credit balance in bytes with its sign, so that a debt reads as negative
+func formatBalance(balance int64) string {
+	if balance < 0 {
+		return "-" + formatSize(-balance)
+	}
+	return "+" + formatSize(balance)
+}
+
+func init() {
+	rootCmd.AddCommand(dashboardCmd)
+	dashboardCmd.Flags().DurationVar(&refreshInterval, "refresh", 2*time.Second, "Time between refreshes of the dashboard")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"blockchain-storage/upload"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <name|root>",
+	Short: "Deletes a file from the network",
+	Long: `This command deletes a file uploaded by this node by committing a tombstone block signed with its identity key.
+			Nodes holding the file's manifest unpin it and delete its chunks as the tombstone reaches them, while the chain
+			keeps both the block committing the file and its tombstone as a record of the deletion. The file is given by
+			its claimed name or the hex encoded Merkle root of the version to delete.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if workers < 1 || workers > 12 {
+			return fmt.Errorf("invalid worker number: %d. Workers must be between 1 and 12", workers)
+		}
+		if retries < 1 || retries > 5 {
+			return fmt.Errorf("invalid retry number: %d. Retries must be between 1 and 5", retries)
+		}
+
+		env, err := loadUploadEnvironment()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := env.Chain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		// Ctrl-C cancels the command's context, which stops the mining workers before the tombstone is committed
+		result, err := upload.Delete(cmd.Context(), env, merkleRoot, workers, retries)
+		if errors.Is(err, context.Canceled) {
+			return errors.New("deletion interrupted")
+		}
+		if err != nil {
+			return err
+		}
+		return printResult(result, func() {
+			fmt.Printf("Deleted %s in block %d, removing %d local chunks (%s)\n", result.MerkleRoot, result.BlockIndex,
+				result.DeletedChunks, formatSize(result.ReclaimedBytes))
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().IntVarP(&workers, "workers", "w", 4, "Number of concurrent block mining workers (1-12)")
+	deleteCmd.Flags().IntVarP(&retries, "retries", "r", 3, "Number of retries if mining fails (1-5)")
+}
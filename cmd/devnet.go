@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Name of the file in the devnet directory recording the nodes that were started
+const devnetStateFile = "devnet.json"
+
+// Name of the file in the devnet directory listing the address of every node, which every node connects to
+const devnetPeersFile = "peers.txt"
+
+var devnetNodes int
+var devnetDir string
+var devnetBasePort int
+var devnetBaseAPIPort int
+var devnetDifficulty uint
+
+// DevnetNode - Structure describing a node of a local test network
+type DevnetNode struct {
+	PID    int    `json:"pid"`    // Process ID of the node
+	PeerID string `json:"peerID"` // Peer ID of the node
+	Port   int    `json:"port"`   // TCP port the node listens on
+	API    string `json:"api"`    // Address of the node's local API
+	Dir    string `json:"dir"`    // Directory holding the node's data and log
+}
+
+var devnetCmd = &cobra.Command{
+	Use:   "devnet",
+	Short: "Runs a local test network",
+	Long:  `This command groups together the operations for starting and stopping a network of nodes on this machine`,
+	// No run function needed as this command only groups subcommands
+}
+
+var devnetUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Starts a local test network",
+	Long: `This command starts nodes on this machine that share a genesis block, mine with a low difficulty and are
+			connected to each other. Every node keeps its data in its own directory under --dir, and the other
+			commands can be pointed at a node with --api, e.g. p2p-storage upload --async --api 127.0.0.1:5101 <file>`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if devnetNodes < 1 {
+			return fmt.Errorf("invalid node number: %d. A devnet needs at least one node", devnetNodes)
+		}
+		if _, err := os.Stat(filepath.Join(devnetDir, devnetStateFile)); err == nil {
+			return fmt.Errorf("a devnet is already running in %s, stop it with 'devnet down' first", devnetDir)
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		genesis := core.NewGenesisBlock(time.Now())
+		nodes := make([]DevnetNode, devnetNodes)
+		var peers []byte
+		for i := range nodes {
+			nodes[i], err = prepareDevnetNode(i, genesis)
+			if err != nil {
+				return err
+			}
+			peers = fmt.Appendf(peers, "/ip4/127.0.0.1/tcp/%d/p2p/%s\n", nodes[i].Port, nodes[i].PeerID)
+		}
+		peersPath := filepath.Join(devnetDir, devnetPeersFile)
+		err = os.WriteFile(peersPath, peers, 0644)
+		if err != nil {
+			return err
+		}
+
+		// Nodes only connect to the peers that are already running when they start, so starting them from the last
+		// one means that every node is connected to all of the nodes after it, and the first node to all of them
+		for i := len(nodes) - 1; i >= 0; i-- {
+			nodes[i].PID, err = startDevnetNode(executable, nodes[i], peersPath)
+			if err != nil {
+				stopDevnetNodes(nodes[i+1:])
+				return err
+			}
+		}
+
+		jsonNodes, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(devnetDir, devnetStateFile), jsonNodes, 0644)
+		if err != nil {
+			stopDevnetNodes(nodes)
+			return err
+		}
+		return printResult(nodes, func() {
+			for _, node := range nodes {
+				fmt.Printf("%s  pid=%d  port=%d  api=%s  log=%s\n", node.PeerID, node.PID, node.Port, node.API,
+					filepath.Join(node.Dir, "node.log"))
+			}
+		})
+	},
+}
+
+var devnetDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Stops a local test network and deletes its data",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jsonNodes, err := os.ReadFile(filepath.Join(devnetDir, devnetStateFile))
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no devnet is running in %s", devnetDir)
+		}
+		if err != nil {
+			return err
+		}
+		var nodes []DevnetNode
+		err = json.Unmarshal(jsonNodes, &nodes)
+		if err != nil {
+			return err
+		}
+		stopDevnetNodes(nodes)
+		err = os.RemoveAll(devnetDir)
+		if err != nil {
+			return err
+		}
+		result := struct {
+			Stopped int `json:"stopped"` // Number of nodes stopped
+		}{len(nodes)}
+		return printResult(result, func() { fmt.Printf("Stopped %d nodes\n", result.Stopped) })
+	},
+}
+
+// Function that prepares the data directory of a devnet node
+func prepareDevnetNode(index int, genesis *core.Block) (DevnetNode, error) {
+	nodeDir := filepath.Join(devnetDir, "node"+strconv.Itoa(index))
+	runDir := filepath.Join(nodeDir, "run")
+	storageDir := filepath.Join(nodeDir, filepath.Base(filepath.Dir(blockchainPath)))
+	for _, dir := range []string{runDir, storageDir} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return DevnetNode{}, err
+		}
+	}
+
+	// Every node imports the same genesis block, and its identity is created up front to know its peer ID
+	err := core.WriteBlocksToFile([]*core.Block{genesis}, filepath.Join(storageDir, filepath.Base(blockchainPath)))
+	if err != nil {
+		return DevnetNode{}, err
+	}
+	identityKey, err := core.LoadIdentityKey(filepath.Join(storageDir, filepath.Base(identityKeyPath)))
+	if err != nil {
+		return DevnetNode{}, err
+	}
+	peerID, err := network.PeerIDFromKey(identityKey)
+	if err != nil {
+		return DevnetNode{}, err
+	}
+
+	return DevnetNode{
+		PeerID: peerID.String(),
+		Port:   devnetBasePort + index,
+		API:    "127.0.0.1:" + strconv.Itoa(devnetBaseAPIPort+index),
+		Dir:    nodeDir,
+	}, nil
+}
+
+// Function that starts a devnet node in the background and returns its process ID
+// The node runs from a directory next to its data, as the node finds its data relative to where it is run from
+func startDevnetNode(executable string, node DevnetNode, peersPath string) (int, error) {
+	// Nodes only talk to each other, so NAT traversal and local network discovery are turned off
+	args := []string{"start", "--port", strconv.Itoa(node.Port), "--quic-port", "0", "--api", node.API,
+		"--difficulty", strconv.FormatUint(uint64(devnetDifficulty), 10), "--peers-file", peersPath, "--nat=false",
+		"--relay=false", "--hole-punching=false", "--mdns=false", "--capacity", "1"}
+
+	logFile, err := os.Create(filepath.Join(node.Dir, "node.log"))
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+	process := exec.Command(executable, args...)
+	process.Dir = filepath.Join(node.Dir, "run")
+	process.Stdout = logFile
+	process.Stderr = logFile
+	err = process.Start()
+	if err != nil {
+		return 0, err
+	}
+	pid := process.Process.Pid
+	// The node keeps running after this command exits, until it is stopped by 'devnet down'
+	return pid, process.Process.Release()
+}
+
+// Function that stops the processes of devnet nodes, ignoring nodes that have already stopped
+func stopDevnetNodes(nodes []DevnetNode) {
+	for _, node := range nodes {
+		process, err := os.FindProcess(node.PID)
+		if err != nil {
+			continue
+		}
+		err = process.Kill()
+		if err != nil && !errors.Is(err, os.ErrProcessDone) {
+			fmt.Fprintf(os.Stderr, "error encountered when stopping node %s: %s\n", node.PeerID, err)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(devnetCmd)
+	devnetCmd.AddCommand(devnetUpCmd, devnetDownCmd)
+	devnetCmd.PersistentFlags().StringVar(&devnetDir, "dir", filepath.Join(os.TempDir(), "p2p-storage-devnet"), "Directory holding the data of every devnet node")
+	devnetUpCmd.Flags().IntVarP(&devnetNodes, "nodes", "n", 3, "Number of nodes to start")
+	devnetUpCmd.Flags().IntVar(&devnetBasePort, "base-port", 4101, "TCP port of the first node, with the others on the following ports")
+	devnetUpCmd.Flags().IntVar(&devnetBaseAPIPort, "base-api-port", 5101, "API port of the first node, with the others on the following ports")
+	devnetUpCmd.Flags().UintVar(&devnetDifficulty, "difficulty", 2, "Proof of work difficulty used by every node")
+}
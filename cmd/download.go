@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/core"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/tracing"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Extension added to a downloaded file's path to name its verification report
+const downloadReportExtension = ".verification.json"
+
+var atHeight int64
+var fileVersion int
+var outputPath string
+var capabilityPath string
+
+var downloadCmd = &cobra.Command{
+	Use:   "download",
+	Short: "Downloads a file from the network",
+	Long: `This command retrieves a file by the name claimed for it on the chain, or the alias it was uploaded under, and
+			reassembles it from its chunks.
+			By default the latest version is retrieved, but --at-height retrieves the version that was current at a block height
+			and --version retrieves a version by its number in the file's history, as listed by "history".
+			An encrypted file uploaded by another node is downloaded with --capability, giving the capability its uploader
+			shared with "share", which holds the file's manifest and the key its chunks are decrypted with.
+			A verification report recording where every chunk came from and whether it passed its Merkle proof is saved
+			next to the file and recorded with the running node, where "jobs downloads" lists it.`,
+	Args: cobra.ExactArgs(1), // There is exactly one mandatory argument which is the alias of the file
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		started := time.Now()
+		ctx, span := tracing.Start(cmd.Context(), "download", attribute.String("alias", args[0]))
+		defer func() { tracing.End(span, err) }()
+
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		// A capability hands over the manifest of an encrypted file, which is kept so the file can be resolved like any other
+		var capability *core.Capability
+		if capabilityPath != "" {
+			capability, err = importCapability(blockchain, manifestStore, capabilityPath)
+			if err != nil {
+				return err
+			}
+		}
+		manifest, err := resolveManifest(blockchain, manifestStore, args[0], atHeight)
+		if err == nil && fileVersion > 0 {
+			manifest, err = resolveVersion(blockchain, manifestStore, manifest, fileVersion)
+		}
+		if err != nil {
+			return err
+		}
+		// Nodes delete the chunks of a deleted file, so there is no point asking peers for them
+		tombstone, err := blockchain.FindTombstone(manifest.MerkleRoot)
+		if err == nil {
+			return fmt.Errorf("file %x was deleted by its uploader in block %d", manifest.MerkleRoot, tombstone.Index)
+		}
+
+		chunkStore, err := openChunkStore(chunkStorePath)
+		if err != nil {
+			return err
+		}
+
+		// Any chunks not held locally are fetched from the network by the running node into the shared chunk store
+		missing := 0
+		for _, chunkHash := range manifest.ChunkHashes {
+			if !chunkStore.HasChunk(chunkHash) {
+				missing++
+			}
+		}
+		var fetched api.FetchResponse
+		if missing > 0 {
+			// The node reports every chunk as it is done with, which drives the progress bar
+			request := api.FetchRequest{MerkleRoot: manifest.MerkleRoot, ChunkHashes: manifest.ChunkHashes}
+			if capability != nil && bytes.Equal(capability.MerkleRoot, manifest.MerkleRoot) {
+				request.Capability = capability.Redacted()
+			}
+			bar := newProgressBar("Downloading "+args[0], missing)
+			_, fetchSpan := tracing.Start(ctx, "download.fetch")
+			response, err := api.FetchChunks(apiAddr, request, func(fetch network.ChunkFetch) { bar.Add(fetch.Size) })
+			bar.Finish()
+			tracing.End(fetchSpan, err)
+			if err != nil {
+				return err
+			}
+			fetched = *response
+			// The node's chunk store index has changed so it needs to be reloaded
+			chunkStore, err = openChunkStore(chunkStorePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		var chunks [][]byte
+		for _, chunkHash := range manifest.ChunkHashes {
+			chunk, err := chunkStore.GetChunk(chunkHash)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, chunk)
+		}
+
+		if outputPath == "" {
+			outputPath = manifest.FileName
+		}
+		_, verifySpan := tracing.Start(ctx, "download.verify", attribute.Int("chunks", len(chunks)))
+		report := verifyDownload(blockchain, manifest, chunks, fetched.Chunks)
+		verifySpan.SetAttributes(attribute.Bool("verified", report.Verified))
+		verifySpan.End()
+		report.Alias = args[0]
+		report.OutputPath = outputPath
+		report.Started = started
+
+		// Check the chunks against the Merkle root committed in the blockchain before writing the file
+		if !report.Verified {
+			err = storage.ErrChunkMismatch
+		}
+		var key []byte
+		if err == nil {
+			key, err = fileKey(nil, manifest)
+		}
+		if err == nil {
+			err = writeDownload(outputPath, manifest, key, chunks)
+		}
+		// The report is kept even if the file was not written, so that the failed chunks can be traced to their peers
+		if err != nil {
+			report.Error = err.Error()
+		}
+		reportErr := saveDownloadReport(report)
+		if err != nil {
+			return err
+		}
+		return reportErr
+	},
+}
+
+// Function that resolves the manifest of the file a name or alias pointed at as of a block height
+// A name claimed in the on-chain registry takes precedence over the aliases files were uploaded under on this node
+func resolveManifest(blockchain *core.Blockchain, manifestStore *storage.ManifestStore, nameOrAlias string,
+	height int64) (*core.Manifest, error) {
+	claim, err := blockchain.ResolveName(nameOrAlias, height)
+	if err == nil {
+		manifest, err := manifestStore.GetManifest(claim.MerkleRoot)
+		if err != nil {
+			return nil, fmt.Errorf("name %s points at file %x, whose manifest is not held by this node: %w",
+				nameOrAlias, claim.MerkleRoot, err)
+		}
+		return manifest, nil
+	}
+	if !errors.Is(err, core.ErrNameNotFound) {
+		return nil, err
+	}
+
+	// Find every version of the file and resolve which one was current at the requested height
+	versions, err := manifestStore.ManifestsByAlias(nameOrAlias)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no file uploaded under the name or alias %s", nameOrAlias)
+	}
+	return blockchain.ResolveManifestAtHeight(versions, height)
+}
+
+// Function that reads a capability and keeps the manifest and key it hands over in the manifest store
+// The capability is checked against the chain first, so a forged or expired capability is not taken in
+func importCapability(blockchain *core.Blockchain, manifestStore *storage.ManifestStore,
+	path string) (*core.Capability, error) {
+	capability, err := core.ReadCapability(path)
+	if err != nil {
+		return nil, err
+	}
+	err = blockchain.VerifyCapability(capability, capability.Grantee, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if capability.Manifest == nil || capability.Key == nil ||
+		!bytes.Equal(capability.Manifest.MerkleRoot, capability.MerkleRoot) {
+		return nil, errors.New("capability does not hand over the file's manifest and key")
+	}
+	manifest := *capability.Manifest
+	manifest.Key = capability.Key
+	err = manifestStore.PutManifest(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	return capability, nil
+}
+
+// Function that writes a downloaded file reassembled from its chunks to a path
+func writeDownload(path string, manifest *core.Manifest, key []byte, chunks [][]byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = storage.ReassembleFile(file, manifest, key, func(index int) ([]byte, error) {
+		return chunks[index], nil
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Function that resolves the manifest of a numbered version from the version history of a file
+func resolveVersion(blockchain *core.Blockchain, manifestStore *storage.ManifestStore, manifest *core.Manifest,
+	version int) (*core.Manifest, error) {
+	found, err := blockchain.FileVersion(manifest.MerkleRoot, version)
+	if err != nil {
+		return nil, err
+	}
+	versionManifest, err := manifestStore.GetManifest(found.MerkleRoot)
+	if err != nil {
+		return nil, fmt.Errorf("version %d is file %x, whose manifest is not held by this node: %w", version,
+			found.MerkleRoot, err)
+	}
+	return versionManifest, nil
+}
+
+// Function that checks every chunk of a downloaded file with its Merkle proof and records where it came from
+func verifyDownload(blockchain *core.Blockchain, manifest *core.Manifest, chunks [][]byte,
+	fetched []network.ChunkFetch) *api.DownloadReport {
+	report := &api.DownloadReport{
+		MerkleRoot:    hex.EncodeToString(manifest.MerkleRoot),
+		BlockIndex:    -1,
+		HashAlgorithm: string(manifest.HashAlgorithm.Canonical()),
+	}
+	if block, err := blockchain.GetBlockByMerkelRoot(manifest.MerkleRoot); err == nil {
+		report.BlockIndex = block.Index
+	}
+	// Manifests only fail to produce CIDs for hash algorithms without a multihash code, which are reported without one
+	report.CID, _ = manifest.CID()
+
+	fetches := make(map[string]network.ChunkFetch, len(fetched))
+	for _, fetch := range fetched {
+		fetches[fetch.Hash] = fetch
+	}
+	proofs := manifest.VerifyChunkProofs(chunks)
+	report.Verified = manifest.VerifyChunks(chunks)
+	for i, chunkHash := range manifest.ChunkHashes {
+		chunk := api.ChunkVerification{Index: i, Hash: hex.EncodeToString(chunkHash), Source: api.LocalChunkSource}
+		chunk.CID, _ = manifest.ChunkCID(i)
+		if fetch, found := fetches[chunk.Hash]; found {
+			chunk.Source = fetch.Peer
+			chunk.Attempts = fetch.Attempts
+			chunk.CorruptPeers = fetch.CorruptPeers
+			chunk.Duration = fetch.Duration
+		}
+		chunk.Proof = i < len(proofs) && proofs[i]
+		report.Verified = report.Verified && chunk.Proof
+		report.Chunks = append(report.Chunks, chunk)
+	}
+	report.Finished = time.Now()
+	return report
+}
+
+// Function that saves a download report next to the downloaded file and records it with the running node
+// Recording it is best effort, as files held entirely in the local chunk store can be downloaded without a node
+func saveDownloadReport(report *api.DownloadReport) error {
+	path := report.OutputPath + downloadReportExtension
+	var recorded api.SubmitResponse
+	err := api.Request(apiAddr, http.MethodPost, "/downloads", report, &recorded)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "download report was not recorded with the node: %s\n", err)
+	} else {
+		report.ID = recorded.ID
+	}
+
+	jsonReport, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, jsonReport, 0644)
+	if err != nil {
+		return err
+	}
+	// With --json the report itself is printed, as it describes everything the download did
+	return printResult(report, func() { fmt.Printf("Verification report saved to %s\n", path) })
+}
+
+func init() {
+	rootCmd.AddCommand(downloadCmd)
+	// A negative height (the default) means the latest version is retrieved
+	downloadCmd.Flags().Int64Var(&atHeight, "at-height", -1, "Retrieve the version of the file that was current at this block height")
+	downloadCmd.Flags().IntVar(&fileVersion, "version", 0, "Retrieve this version of the file, counting from 1 for its first version")
+	downloadCmd.Flags().StringVar(&capabilityPath, "capability", "", "Path of a capability granting access to an encrypted file")
+	downloadCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to write the file to (defaults to its original name)")
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <name|root>",
+	Short: "Lists the versions of a file",
+	Long: `This command lists every version of a file, oldest first, by following the previous version each upload made
+			with --previous (or by claiming its name again) references. The file is given by its claimed name, in which
+			case the history leads up to the version the name points at, or by the hex encoded Merkle root of a version.
+			Any of the version numbers can be given to "download --version".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := blockchain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		history, err := blockchain.History(merkleRoot)
+		if err != nil {
+			return err
+		}
+		return printResult(history, func() {
+			for _, version := range history {
+				fmt.Printf("v%d  %x  block=%d  %s  uploader=%x", version.Version, version.MerkleRoot, version.Height,
+					version.Timestamp.Format(time.RFC3339), version.Uploader)
+				if version.Name != "" {
+					fmt.Printf("  name=%s", version.Name)
+				}
+				fmt.Println()
+			}
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/core"
+	"blockchain-storage/keystore"
+	"blockchain-storage/network"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"time"
+)
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Prints the node's peer ID and the multiaddresses it can be reached on",
+	Long: `This command prints the peer ID derived from the node's identity key. If the node is running, the full
+			multiaddresses it listens on are printed too, any of which can be passed to another node's --bootstrap flag.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !identityExists() {
+			return errors.New("the node has no identity key, generate one with 'identity generate'")
+		}
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		identityKey, err := loadIdentityKey(keys)
+		if err != nil {
+			return err
+		}
+		result, err := newIdentityResult(identityKey)
+		if err != nil {
+			return err
+		}
+
+		// Only the running node knows the addresses it ended up listening on
+		var status api.NodeStatus
+		if api.Get(apiAddr, "/status", &status) == nil && status.PeerID == result.PeerID {
+			result.Running = true
+			result.Addrs = nonNil(status.Addrs)
+		}
+		return printResult(result, func() {
+			fmt.Printf("Peer ID: %s\n", result.PeerID)
+			if !result.Running {
+				fmt.Println("The node is not running, start it to see the addresses it can be reached on")
+			}
+			for _, addr := range result.Addrs {
+				fmt.Println(addr)
+			}
+		})
+	},
+}
+
+var identityGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generates the node's identity key",
+	Long: `This command generates the node's identity key, which gives it its peer ID and signs the blocks it mines.
+			A node generates its key the first time it needs one, so this is only needed to know the peer ID up front.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if identityExists() {
+			return errors.New("the node already has an identity key, replace it with 'identity rotate'")
+		}
+		identityKey, err := core.LoadIdentityKey(identityKeyPath)
+		if err != nil {
+			return err
+		}
+		result, err := newIdentityResult(identityKey)
+		if err != nil {
+			return err
+		}
+		return printResult(result, func() { fmt.Printf("Generated identity key, the node's peer ID is %s\n", result.PeerID) })
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replaces the node's identity key, changing its peer ID",
+	Long: `This command replaces the node's identity key with a newly generated one, which changes its peer ID.
+			Files uploaded with the old key can only be deleted or shared with it, so it is kept: renamed next to the
+			identity key file, or in the keystore as a signing key named identity-<time it was replaced>.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !identityExists() {
+			return errors.New("the node has no identity key, generate one with 'identity generate'")
+		}
+		// A running node keeps using the key it was started with, so it would carry on under the old peer ID
+		var status api.NodeStatus
+		if api.Get(apiAddr, "/status", &status) == nil {
+			return errors.New("the node is running, stop it before rotating its identity key")
+		}
+
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		oldKey, err := loadIdentityKey(keys)
+		if err != nil {
+			return err
+		}
+		_, newKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+
+		// The old key is kept before being replaced, so it is never lost if the replacement fails
+		suffix := strconv.FormatInt(time.Now().Unix(), 10)
+		var previous string
+		if keys != nil {
+			previous = "signing key identity-" + suffix
+			err = keys.SetSigningKey("identity-"+suffix, oldKey)
+			if err == nil {
+				err = keys.SetIdentityKey(newKey)
+			}
+		} else {
+			previous = identityKeyPath + "." + suffix + ".old"
+			err = os.Rename(identityKeyPath, previous)
+			if err == nil {
+				// File permissions 0600 means only the file owner can read and write the key
+				err = os.WriteFile(identityKeyPath, []byte(hex.EncodeToString(newKey.Seed())), 0600)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		result, err := newIdentityResult(newKey)
+		if err != nil {
+			return err
+		}
+		oldPeerID, err := network.PeerIDFromKey(oldKey)
+		if err != nil {
+			return err
+		}
+		result.PreviousPeerID = oldPeerID.String()
+		result.PreviousKey = previous
+		return printResult(result, func() {
+			fmt.Printf("Rotated identity key, the node's peer ID is now %s (was %s)\n", result.PeerID, result.PreviousPeerID)
+			fmt.Printf("The old key was kept as %s\n", previous)
+		})
+	},
+}
+
+// Type - Structure describing the node's identity for JSON output
+type identityResult struct {
+	PeerID         string   `json:"peerId"`                   // Peer ID derived from the identity key
+	PublicKey      string   `json:"publicKey"`                // Hex encoded public key blocks uploaded by the node are signed with
+	Running        bool     `json:"running"`                  // Whether the node is running
+	Addrs          []string `json:"addrs"`                    // Full multiaddresses the running node can be reached on
+	PreviousPeerID string   `json:"previousPeerId,omitempty"` // Peer ID the node had before its key was rotated (rotate only)
+	PreviousKey    string   `json:"previousKey,omitempty"`    // Where the key replaced by the rotation was kept (rotate only)
+}
+
+// Function that describes the identity an identity key gives the node
+func newIdentityResult(identityKey ed25519.PrivateKey) (identityResult, error) {
+	peerID, err := network.PeerIDFromKey(identityKey)
+	if err != nil {
+		return identityResult{}, err
+	}
+	return identityResult{
+		PeerID:    peerID.String(),
+		PublicKey: hex.EncodeToString(identityKey.Public().(ed25519.PublicKey)),
+		Addrs:     []string{},
+	}, nil
+}
+
+// Function that checks whether the node has an identity key, either in its keystore or in the identity key file
+func identityExists() bool {
+	if keystore.Exists(keystorePath) {
+		return true
+	}
+	_, err := os.Stat(identityKeyPath)
+	return err == nil
+}
+
+func init() {
+	rootCmd.AddCommand(identityCmd)
+	identityCmd.AddCommand(identityGenerateCmd)
+	identityCmd.AddCommand(identityRotateCmd)
+}
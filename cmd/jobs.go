@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/upload"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manages upload jobs on a running node",
+	Long: `This command groups together the operations for watching, awaiting and cancelling asynchronous uploads,
+			and for auditing the verification reports of recent downloads`,
+	// No run function needed as this command only groups subcommands
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists every upload job",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var jobs []upload.JobInfo
+		err := api.Get(apiAddr, "/uploads", &jobs)
+		if err != nil {
+			return err
+		}
+		return printResult(nonNil(jobs), func() {
+			for _, job := range jobs {
+				printJob(job)
+			}
+		})
+	},
+}
+
+var jobsQueueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Shows how many upload jobs are in each state and how many workers run them",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var status upload.QueueStatus
+		err := api.Get(apiAddr, "/uploads/queue", &status)
+		if err != nil {
+			return err
+		}
+		return printResult(status, func() {
+			fmt.Printf("Workers: %d\n", status.Workers)
+			fmt.Printf("Waiting: %d of %d\n", status.Jobs[upload.JobQueued], status.Capacity)
+			for _, jobStatus := range []upload.JobStatus{upload.JobDeferred, upload.JobRunning, upload.JobDegraded,
+				upload.JobCompleted, upload.JobFailed, upload.JobCancelled} {
+				fmt.Printf("%s: %d\n", strings.ToUpper(string(jobStatus[:1]))+string(jobStatus[1:]), status.Jobs[jobStatus])
+			}
+			if !status.Persisted {
+				fmt.Println("Jobs are not saved across restarts")
+			}
+			if status.SaveError != "" {
+				fmt.Printf("Jobs could not be saved: %s\n", status.SaveError)
+			}
+		})
+	},
+}
+
+var jobsStatusCmd = &cobra.Command{
+	Use:   "status <job-id>",
+	Short: "Shows the state of an upload job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var job upload.JobInfo
+		err := api.Get(apiAddr, "/uploads/"+args[0], &job)
+		if err != nil {
+			return err
+		}
+		return printResult(job, func() { printJob(job) })
+	},
+}
+
+var jobsWatchCmd = &cobra.Command{
+	Use:   "watch <job-id>",
+	Short: "Prints every change in the state of an upload job until it finishes",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var lastStatus upload.JobStatus
+		for {
+			var job upload.JobInfo
+			err := api.Get(apiAddr, "/uploads/"+args[0], &job)
+			if err != nil {
+				return err
+			}
+			if job.Status != lastStatus {
+				err = printResult(job, func() { printJob(job) })
+				if err != nil {
+					return err
+				}
+				lastStatus = job.Status
+			}
+			if job.Status.IsFinal() {
+				return nil
+			}
+			time.Sleep(time.Second)
+		}
+	},
+}
+
+var jobsWaitCmd = &cobra.Command{
+	Use:   "wait <job-id>",
+	Short: "Waits for an upload job to finish",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var job upload.JobInfo
+		err := api.Get(apiAddr, "/uploads/"+args[0]+"?wait=true", &job)
+		if err != nil {
+			return err
+		}
+		err = printResult(job, func() { printJob(job) })
+		if err != nil {
+			return err
+		}
+		if job.Status != upload.JobCompleted {
+			return fmt.Errorf("upload job %s did not complete", job.ID)
+		}
+		return nil
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <job-id>",
+	Short: "Cancels an upload job",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return api.Request(apiAddr, http.MethodDelete, "/uploads/"+args[0], nil, nil)
+	},
+}
+
+var jobsDownloadsCmd = &cobra.Command{
+	Use:   "downloads",
+	Short: "Lists the verification reports of recent downloads",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var reports []api.DownloadReport
+		err := api.Get(apiAddr, "/downloads", &reports)
+		if err != nil {
+			return err
+		}
+		return printResult(nonNil(reports), func() {
+			for _, report := range reports {
+				status := "verified"
+				if !report.Verified {
+					status = "unverified"
+				}
+				fmt.Printf("%s  %s  %s  root=%s chunks=%d\n", report.ID, status, report.OutputPath, report.MerkleRoot,
+					len(report.Chunks))
+			}
+		})
+	},
+}
+
+var jobsReportCmd = &cobra.Command{
+	Use:   "report <download-id>",
+	Short: "Prints the full verification report of a download",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var report api.DownloadReport
+		err := api.Get(apiAddr, "/downloads/"+args[0], &report)
+		if err != nil {
+			return err
+		}
+		jsonReport, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonReport))
+		return nil
+	},
+}
+
+// Function that prints a single line describing an upload job
+func printJob(job upload.JobInfo) {
+	switch job.Status {
+	case upload.JobCompleted:
+		fmt.Printf("%s  %s  %s  root=%s block=%d replicas=%d\n", job.ID, job.Status, job.Params.FilePath,
+			job.Result.MerkleRoot, job.Result.BlockIndex, len(job.Result.Replicas))
+	case upload.JobDeferred, upload.JobDegraded, upload.JobFailed:
+		fmt.Printf("%s  %s  %s  error=%s\n", job.ID, job.Status, job.Params.FilePath, job.Error)
+	default:
+		fmt.Printf("%s  %s  %s\n", job.ID, job.Status, job.Params.FilePath)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd, jobsQueueCmd, jobsStatusCmd, jobsWatchCmd, jobsWaitCmd, jobsCancelCmd, jobsDownloadsCmd,
+		jobsReportCmd)
+}
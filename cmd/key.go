@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"blockchain-storage/storage"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var custodians []string
+var threshold int
+var sharesDir string
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manages the key chunks are encrypted with",
+	Long: `This command groups together the operations on the node-local key that chunks are encrypted with at rest.
+			Chunks encrypted with the key cannot be read without it, so the key can be escrowed with custodians.`,
+	// No run function needed as this command only groups subcommands
+}
+
+var keyInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generates a chunk key, encrypting every chunk stored from then on",
+	Long: `This command generates the node's chunk key. Chunks stored from then on are encrypted with it, while chunks
+			stored before stay readable as they are. Escrow the key with 'storage key escrow' so that it can be recovered.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := storage.GenerateChunkKey(chunkKeyPath)
+		if errors.Is(err, os.ErrExist) {
+			return errors.New("the node already has a chunk key")
+		}
+		if err != nil {
+			return err
+		}
+		result := keyResult{Fingerprint: storage.KeyFingerprint(key)}
+		return printResult(result, func() { fmt.Printf("Generated chunk key %s\n", result.Fingerprint) })
+	},
+}
+
+var keyEscrowCmd = &cobra.Command{
+	Use:   "escrow",
+	Short: "Splits the chunk key into shares for custodians",
+	Long: `This command splits the node's chunk key with Shamir secret sharing, writing one share file per custodian.
+			Any --threshold shares recover the key, while fewer reveal nothing about it, e.g.
+			storage key escrow --custodian alice,bob,carol --threshold 2`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := storage.LoadChunkKey(chunkKeyPath)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return errors.New("the node has no chunk key, generate one with 'storage key init'")
+		}
+		shares, err := storage.SplitKey(key, custodians, threshold)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(sharesDir, 0700)
+		if err != nil {
+			return err
+		}
+		result := keyResult{Fingerprint: storage.KeyFingerprint(key), Threshold: threshold}
+		for _, share := range shares {
+			path := filepath.Join(sharesDir, share.Custodian+".share.json")
+			err = storage.WriteKeyShare(path, share)
+			if err != nil {
+				return err
+			}
+			result.Shares = append(result.Shares, path)
+		}
+		return printResult(result, func() {
+			for i, share := range shares {
+				fmt.Printf("Wrote share %d for %s to %s\n", share.Index, share.Custodian, result.Shares[i])
+			}
+			fmt.Printf("Any %d of the %d shares recover key %s\n", threshold, len(shares), result.Fingerprint)
+		})
+	},
+}
+
+var keyRecoverCmd = &cobra.Command{
+	Use:   "recover <share-file>...",
+	Short: "Recovers the chunk key from custodians' shares",
+	Long:  `This command combines the share files returned by custodians to recover a lost chunk key`,
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var shares []*storage.KeyShare
+		for _, path := range args {
+			share, err := storage.ReadKeyShare(path)
+			if err != nil {
+				return err
+			}
+			shares = append(shares, share)
+		}
+		key, err := storage.CombineShares(shares)
+		if err != nil {
+			return err
+		}
+
+		// Never overwrite a key, as chunks encrypted with it would become unreadable
+		err = storage.SaveChunkKey(chunkKeyPath, key)
+		if errors.Is(err, os.ErrExist) {
+			existing, loadErr := storage.LoadChunkKey(chunkKeyPath)
+			if loadErr == nil && storage.KeyFingerprint(existing) == storage.KeyFingerprint(key) {
+				result := keyResult{Fingerprint: storage.KeyFingerprint(key), AlreadyHeld: true}
+				return printResult(result, func() { fmt.Println("The node already has the recovered chunk key") })
+			}
+			return errors.New("the node already has a different chunk key")
+		}
+		if err != nil {
+			return err
+		}
+		result := keyResult{Fingerprint: storage.KeyFingerprint(key)}
+		return printResult(result, func() { fmt.Printf("Recovered chunk key %s\n", result.Fingerprint) })
+	},
+}
+
+// Type - Structure describing the outcome of a chunk key command for JSON output
+type keyResult struct {
+	Fingerprint string   `json:"fingerprint"`           // Fingerprint identifying the chunk key
+	Threshold   int      `json:"threshold,omitempty"`   // Number of shares needed to recover the key (escrow only)
+	Shares      []string `json:"shares,omitempty"`      // Paths of the share files written (escrow only)
+	AlreadyHeld bool     `json:"alreadyHeld,omitempty"` // Whether the node already had the recovered key (recover only)
+}
+
+func init() {
+	storageCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keyInitCmd)
+	keyCmd.AddCommand(keyEscrowCmd)
+	keyCmd.AddCommand(keyRecoverCmd)
+	keyEscrowCmd.Flags().StringSliceVar(&custodians, "custodian", nil, "Names of the custodians to give a share to")
+	keyEscrowCmd.Flags().IntVar(&threshold, "threshold", 2, "Number of shares needed to recover the key")
+	keyEscrowCmd.Flags().StringVar(&sharesDir, "out", "key-shares", "Directory to write the share files to")
+}
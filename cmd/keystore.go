@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/keystore"
+	"blockchain-storage/storage"
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+// Environment variables a passphrase is read from instead of prompting for it, so that scripts can run unattended
+const (
+	passphraseEnv    = "BLOCKCHAIN_STORAGE_PASSPHRASE"
+	newPassphraseEnv = "BLOCKCHAIN_STORAGE_NEW_PASSPHRASE"
+)
+
+var exportKey string
+var exportOutput string
+var rotateSigningKey string
+
+// Reader shared by every passphrase prompt, so that lines buffered by one prompt are not lost to the next
+var stdinReader = bufio.NewReader(os.Stdin)
+
+var keystoreCmd = &cobra.Command{
+	Use:   "keystore",
+	Short: "Manages the passphrase-protected keystore holding the node's keys",
+	Long: `This command groups together the operations on the node's keystore, which holds its identity key, the keys of
+			the encrypted files it uploaded and its signing keys, encrypted under a passphrase. The passphrase is read
+			from ` + passphraseEnv + ` if it is set and prompted for otherwise.`,
+	// No run function needed as this command only groups subcommands
+}
+
+var keystoreInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Creates the keystore, moving the node's keys into it",
+	Long: `This command creates the node's keystore under a new passphrase. The identity key and the keys held in the
+			manifests of encrypted files are moved into it, and removed from the plaintext files they were kept in.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if keystore.Exists(keystorePath) {
+			return errors.New("the node already has a keystore")
+		}
+		identityKey, err := core.LoadIdentityKey(identityKeyPath)
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		passphrase, err := readNewPassphrase(passphraseEnv)
+		if err != nil {
+			return err
+		}
+		keys, err := keystore.Create(keystorePath, passphrase, identityKey)
+		if err != nil {
+			return err
+		}
+
+		// Keys are only removed from the manifests once the keystore holding them has been saved
+		manifests, err := manifestStore.ListManifests()
+		if err != nil {
+			return err
+		}
+		migrated := 0
+		for _, manifest := range manifests {
+			if manifest.Key == nil {
+				continue
+			}
+			err = keys.SetFileKey(manifest.MerkleRoot, manifest.Key)
+			if err != nil {
+				return err
+			}
+			manifest.Key = nil
+			manifest.Encrypted = true
+			err = manifestStore.PutManifest(manifest)
+			if err != nil {
+				return err
+			}
+			migrated++
+		}
+		err = os.Remove(identityKeyPath)
+		if err != nil {
+			return err
+		}
+
+		result := keystoreResult{Summary: keys.Summary(), MigratedFileKeys: migrated}
+		return printResult(result, func() {
+			fmt.Printf("Created keystore %s for identity %s, moving %d file keys into it\n", keystorePath,
+				result.IdentityPublicKey, migrated)
+		})
+	},
+}
+
+var keystoreUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Checks the passphrase and lists the keys the keystore holds",
+	Long: `This command unlocks the node's keystore with its passphrase and lists the keys it holds without revealing
+			them, which checks the passphrase before it is needed to start the node or upload a file`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			return errors.New("the node has no keystore, create one with 'keystore init'")
+		}
+		result := keystoreResult{Summary: keys.Summary()}
+		return printResult(result, func() {
+			fmt.Printf("Identity: %s\n", result.IdentityPublicKey)
+			fmt.Printf("File keys: %d\n", result.FileKeys)
+			fmt.Printf("Signing keys: %s\n", strings.Join(result.SigningKeys, ", "))
+		})
+	},
+}
+
+var keystoreExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes a key held in the keystore to a file",
+	Long: `This command writes one of the keys held in the keystore, hex encoded, to a file only its owner can read, e.g.
+			keystore export --key identity
+			keystore export --key file:<name|root> -o report.key
+			keystore export --key signing:release`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, name, _ := strings.Cut(exportKey, ":")
+		if (kind == "identity") != (name == "") {
+			return fmt.Errorf("invalid key %q, expected identity, file:<name|root> or signing:<name>", exportKey)
+		}
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			return errors.New("the node has no keystore, create one with 'keystore init'")
+		}
+
+		var key []byte
+		output := exportOutput
+		switch kind {
+		case "identity":
+			key = keys.IdentityKey().Seed()
+			if output == "" {
+				output = "identity.key"
+			}
+		case "file":
+			blockchain, err := loadBlockchain()
+			if err != nil {
+				return err
+			}
+			merkleRoot, err := blockchain.ResolveFile(name)
+			if err != nil {
+				return err
+			}
+			heldKey, found := keys.FileKey(merkleRoot)
+			if !found {
+				return fmt.Errorf("keystore holds no key for file %x", merkleRoot)
+			}
+			key = heldKey
+			if output == "" {
+				output = hex.EncodeToString(merkleRoot) + ".key"
+			}
+		case "signing":
+			signingKey, found := keys.SigningKey(name)
+			if !found {
+				return fmt.Errorf("keystore holds no signing key named %s", name)
+			}
+			key = signingKey.Seed()
+			if output == "" {
+				output = name + ".signing.key"
+			}
+		default:
+			return fmt.Errorf("invalid key %q, expected identity, file:<name|root> or signing:<name>", exportKey)
+		}
+
+		// File permissions 0600 means only the file owner can read the exported key
+		err = os.WriteFile(output, []byte(hex.EncodeToString(key)), 0600)
+		if err != nil {
+			return err
+		}
+		result := keystoreExportResult{Key: exportKey, OutputPath: output}
+		return printResult(result, func() { fmt.Printf("Exported %s to %s\n", exportKey, output) })
+	},
+}
+
+var keystoreRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Changes the keystore's passphrase, or replaces one of its signing keys",
+	Long: `This command re-encrypts the keystore under a new passphrase, read from ` + newPassphraseEnv + ` if it is
+			set. With --signing-key it instead replaces the named signing key with a newly generated one.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			return errors.New("the node has no keystore, create one with 'keystore init'")
+		}
+
+		if rotateSigningKey != "" {
+			signingKey, err := keys.RotateSigningKey(rotateSigningKey)
+			if err != nil {
+				return err
+			}
+			result := keystoreRotateResult{
+				SigningKey: rotateSigningKey,
+				PublicKey:  hex.EncodeToString(signingKey.Public().(ed25519.PublicKey)),
+			}
+			return printResult(result, func() {
+				fmt.Printf("Replaced signing key %s, its public key is now %s\n", rotateSigningKey, result.PublicKey)
+			})
+		}
+
+		passphrase, err := readNewPassphrase(newPassphraseEnv)
+		if err != nil {
+			return err
+		}
+		err = keys.ChangePassphrase(passphrase)
+		if err != nil {
+			return err
+		}
+		return printResult(keystoreRotateResult{Passphrase: true}, func() { fmt.Println("Changed the keystore's passphrase") })
+	},
+}
+
+// Type - Structure describing a keystore for JSON output
+type keystoreResult struct {
+	keystore.Summary
+	MigratedFileKeys int `json:"migratedFileKeys,omitempty"` // Number of file keys moved out of manifests (init only)
+}
+
+// Type - Structure describing an exported key for JSON output
+type keystoreExportResult struct {
+	Key        string `json:"key"`        // Key that was exported
+	OutputPath string `json:"outputPath"` // Path of the file the key was written to
+}
+
+// Type - Structure describing a rotation for JSON output
+type keystoreRotateResult struct {
+	Passphrase bool   `json:"passphrase,omitempty"` // Whether the passphrase was changed
+	SigningKey string `json:"signingKey,omitempty"` // Name of the signing key that was replaced
+	PublicKey  string `json:"publicKey,omitempty"`  // Hex encoded public key of the new signing key
+}
+
+// Function that opens the node's keystore, returning nil if the node has none
+func openKeystore() (*keystore.Keystore, error) {
+	if !keystore.Exists(keystorePath) {
+		return nil, nil
+	}
+	passphrase, err := readPassphrase(passphraseEnv, "Keystore passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	return keystore.Open(keystorePath, passphrase)
+}
+
+// Function that loads the node's identity key from its keystore, or from the identity key file if it has no keystore
+func loadIdentityKey(keys *keystore.Keystore) (ed25519.PrivateKey, error) {
+	if keys != nil {
+		return keys.IdentityKey(), nil
+	}
+	return core.LoadIdentityKey(identityKeyPath)
+}
+
+// Function that returns the key an encrypted file's chunks were encrypted with (nil if the file is not encrypted)
+// The key is taken from the manifest if it holds one, and from the node's keystore otherwise, which is opened if it
+// was not already
+func fileKey(keys *keystore.Keystore, manifest *core.Manifest) ([]byte, error) {
+	if manifest.Key != nil || !manifest.Encrypted {
+		return manifest.Key, nil
+	}
+	if keys == nil {
+		var err error
+		keys, err = openKeystore()
+		if err != nil {
+			return nil, err
+		}
+		if keys == nil {
+			return nil, fmt.Errorf("key of file %x is not held by this node", manifest.MerkleRoot)
+		}
+	}
+	key, found := keys.FileKey(manifest.MerkleRoot)
+	if !found {
+		return nil, fmt.Errorf("key of file %x is not held by this node", manifest.MerkleRoot)
+	}
+	return key, nil
+}
+
+// Function that reads a passphrase from an environment variable, or prompts for it if the variable is not set
+func readPassphrase(envVar string, prompt string) ([]byte, error) {
+	if passphrase, set := os.LookupEnv(envVar); set {
+		return []byte(passphrase), nil
+	}
+	// The prompt goes to stderr so that it does not end up in JSON output
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, fmt.Errorf("error encountered when reading the passphrase: %w", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// Function that reads a new passphrase, asking for it twice when prompting so that a typo cannot lock the keystore
+func readNewPassphrase(envVar string) ([]byte, error) {
+	if passphrase, set := os.LookupEnv(envVar); set {
+		return []byte(passphrase), nil
+	}
+	passphrase, err := readPassphrase(envVar, "New keystore passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	repeated, err := readPassphrase(envVar, "Repeat the passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func init() {
+	rootCmd.AddCommand(keystoreCmd)
+	keystoreCmd.AddCommand(keystoreInitCmd)
+	keystoreCmd.AddCommand(keystoreUnlockCmd)
+	keystoreCmd.AddCommand(keystoreExportCmd)
+	keystoreCmd.AddCommand(keystoreRotateCmd)
+	keystoreExportCmd.Flags().StringVar(&exportKey, "key", "identity", "Key to export: identity, file:<name|root> or signing:<name>")
+	keystoreExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "File to write the key to (named after the key by default)")
+	keystoreRotateCmd.Flags().StringVar(&rotateSigningKey, "signing-key", "", "Name of the signing key to replace instead of changing the passphrase")
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/mount"
+	"blockchain-storage/storage"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var readAhead int
+
+var mountCmd = &cobra.Command{
+	Use:   "mount <dir>",
+	Short: "Mounts the stored files as a read-only filesystem",
+	Long: `This command exposes the stored files as a read-only filesystem at a directory, until it is interrupted.
+			The names directory lists every file by the name claimed for it on the chain, and the files directory lists
+			the latest version of every file uploaded on this node by its alias. Encrypted and deleted files are left out.
+			Chunks are read as the files are, with any not held locally fetched from the network by the running node and
+			checked against the file's Merkle root. Reading a file also fetches the chunks after the ones being read, so
+			files read from start to end rarely wait for the network.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		chunkStore, err := openChunkStore(chunkStorePath)
+		if err != nil {
+			return err
+		}
+		source := &mount.Source{
+			Chain:     blockchain,
+			Manifests: manifestStore,
+			Chunks:    chunkStore,
+			ReadAhead: readAhead,
+			// The running node fetches missing chunks into the shared chunk store, where the mount reads them from
+			Fetch: func(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte) error {
+				request := api.FetchRequest{MerkleRoot: merkleRoot, ChunkHashes: chunkHashes}
+				_, err := api.FetchChunks(apiAddr, request, nil)
+				return err
+			},
+		}
+
+		server, err := mount.Mount(args[0], source)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Mounted stored files at %s (interrupt to unmount)\n", args[0])
+		go func() {
+			<-cmd.Context().Done()
+			err := server.Unmount()
+			if err != nil {
+				fmt.Printf("Failed to unmount %s: %s\n", args[0], err)
+			}
+		}()
+		server.Wait()
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mountCmd)
+	mountCmd.Flags().IntVar(&readAhead, "read-ahead", 4, "Number of chunks fetched ahead of the ones being read")
+}
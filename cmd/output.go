@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Whether commands print their results as JSON instead of text
+var jsonOutput bool
+
+// Function that prints the result of a command, as a single line of JSON with --json or with the given text printer
+// otherwise. Commands that report several results over time print each one on its own line, so that the output can
+// be read line by line by scripts
+func printResult(result any, printText func()) error {
+	if !jsonOutput {
+		printText()
+		return nil
+	}
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// Function that returns a list that is printed as an empty JSON array rather than null when it has no elements
+func nonNil[T any](list []T) []T {
+	if list == nil {
+		return []T{}
+	}
+	return list
+}
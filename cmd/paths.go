@@ -0,0 +1,23 @@
+package cmd
+
+// Locations of the node's persistent data, relative to the directory the CLI is run from
+const (
+	blockchainPath    = "../storage/blockchain.json"
+	chainStorePath    = "../storage/blockchain.db"
+	blocksFilePath    = "../storage/blocks.ndjson"
+	chainArchivePath  = "../storage/blockchain.archive"
+	blockIndexPath    = "../storage/blocks.sqlite"
+	identityKeyPath   = "../storage/identity.key"
+	chunkKeyPath      = "../storage/chunks.key"
+	chunkStorePath    = "../storage/chunks"
+	manifestStorePath = "../storage/manifests"
+	pinsPath          = "../storage/pins.json"
+	accessListPath    = "../storage/access.json"
+	keystorePath      = "../storage/keystore.json"
+	objectSpoolPath   = "../storage/objects"
+	uploadQueuePath   = "../storage/uploads.json"
+	chunkCachePath    = "../storage/cache"
+	relayCachePath    = "../storage/relay-cache"
+	proofStorePath    = "../storage/proofs"
+	creditLedgerPath  = "../storage/credits.ndjson"
+)
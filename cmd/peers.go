@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "Lists the peers of a running node",
+	Long:  `This command lists the peers connected to a running node along with their reputation and chunk verification statistics`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var peers []api.PeerStatus
+		err := api.Get(apiAddr, "/peers", &peers)
+		if err != nil {
+			return err
+		}
+
+		return printResult(nonNil(peers), func() { printPeers(peers) })
+	},
+}
+
+// Function that prints a single line describing each connected peer
+func printPeers(peers []api.PeerStatus) {
+	for _, peerStatus := range peers {
+		status := "ok"
+		if peerStatus.Excluded {
+			status = "excluded"
+		}
+		fmt.Printf("%s  score=%.0f verified=%d failed=%d failure-rate=%.2f %s", peerStatus.ID, peerStatus.Score,
+			peerStatus.Integrity.Verified, peerStatus.Integrity.Failed, peerStatus.Integrity.FailureRate(), status)
+		if peerStatus.Capabilities != nil {
+			fmt.Printf(" free=%d roles=%v price=%.2f", peerStatus.Capabilities.FreeSpace, peerStatus.Capabilities.Roles,
+				peerStatus.Capabilities.Price)
+		}
+		fmt.Println()
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(peersCmd)
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"os"
+	"strings"
+	"time"
+)
+
+// Minimum time between the lines written for a transfer when stderr is not a terminal
+const progressLogInterval = 5 * time.Second
+
+// Width of a progress bar in characters
+const progressBarWidth = 30
+
+// Type - Structure showing the progress of a file's transfer on stderr, as a bar redrawn in place on a terminal or as
+// a line at most every progressLogInterval otherwise (e.g. when the output is captured by CI)
+type progressBar struct {
+	label    string    // Name of the transfer, e.g. the file being transferred
+	total    int       // Number of chunks to transfer
+	done     int       // Number of chunks transferred so far
+	bytes    int64     // Size of the chunks transferred so far
+	started  time.Time // Time the transfer started
+	logged   time.Time // Time the last line was written (when stderr is not a terminal)
+	terminal bool      // Whether stderr is a terminal
+}
+
+// Function that starts showing the progress of a transfer of the given number of chunks
+func newProgressBar(label string, total int) *progressBar {
+	return &progressBar{label: label, total: total, started: time.Now(), terminal: isatty.IsTerminal(os.Stderr.Fd())}
+}
+
+// Function that records a transferred chunk of the given size and shows the new progress
+func (bar *progressBar) Add(size int) {
+	bar.done++
+	bar.bytes += int64(size)
+	if bar.terminal {
+		fmt.Fprintf(os.Stderr, "\r%s   ", bar.line())
+		return
+	}
+	if bar.done == bar.total || time.Since(bar.logged) >= progressLogInterval {
+		bar.logged = time.Now()
+		fmt.Fprintln(os.Stderr, bar.line())
+	}
+}
+
+// Function that ends the bar's line, so that anything printed afterwards starts on a line of its own
+func (bar *progressBar) Finish() {
+	if bar.terminal && bar.done > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// Function that describes the progress of the transfer with its throughput and estimated time remaining
+func (bar *progressBar) line() string {
+	elapsed := time.Since(bar.started)
+	throughput := float64(bar.bytes) / max(elapsed.Seconds(), 0.001)
+	eta := "0s"
+	if bar.done < bar.total {
+		// The remaining chunks are assumed to take as long on average as the chunks transferred so far
+		remaining := time.Duration(float64(elapsed) / float64(bar.done) * float64(bar.total-bar.done))
+		eta = remaining.Round(time.Second).String()
+	}
+	percent := bar.done * 100 / bar.total
+	if !bar.terminal {
+		return fmt.Sprintf("%s: %d/%d chunks (%d%%), %s, ETA %s", bar.label, bar.done, bar.total, percent,
+			formatSize(int64(throughput))+"/s", eta)
+	}
+	filled := progressBarWidth * bar.done / bar.total
+	graphic := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	return fmt.Sprintf("%s [%s] %3d%%  %d/%d chunks  %s  ETA %s", bar.label, graphic, percent, bar.done, bar.total,
+		formatSize(int64(throughput))+"/s", eta)
+}
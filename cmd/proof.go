@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+)
+
+var proofConfirmations int
+var proofOutput string
+
+var proofCmd = &cobra.Command{
+	Use:   "proof <name|root> <chunk-index>",
+	Short: "Proves that a chunk belongs to a committed file",
+	Long: `This command builds the proof that the chunk at a position in a file belongs to the file committed on the
+			chain: the chunk's Merkle proof along with the headers from the block committing the file up to the tip (or
+			up to --confirmations blocks after it). The proof is checked before it is printed, and with --output it is
+			written as JSON that auditors can check with the verify package without running a node.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunkIndex, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid chunk index %q", args[1])
+		}
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := blockchain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		manifest, err := manifestStore.GetManifest(merkleRoot)
+		if err != nil {
+			return fmt.Errorf("no manifest for file %x: %w", merkleRoot, err)
+		}
+		proof, err := blockchain.InclusionProof(manifest, chunkIndex, proofConfirmations)
+		if err != nil {
+			return err
+		}
+		err = verify.Inclusion(proof, nil, core.MiningDifficulty)
+		if err != nil {
+			return fmt.Errorf("proof does not verify against the local chain: %w", err)
+		}
+		if proofOutput != "" {
+			jsonProof, err := json.MarshalIndent(proof, "", "  ")
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(proofOutput, jsonProof, 0644)
+			if err != nil {
+				return err
+			}
+		}
+		return printResult(proof, func() {
+			block, tip := proof.Headers[0], proof.Headers[len(proof.Headers)-1]
+			fmt.Printf("File           %x\n", proof.MerkleRoot)
+			fmt.Printf("Chunk          %d of %d  %x\n", proof.ChunkIndex, proof.ChunkCount, proof.ChunkHash)
+			fmt.Printf("Merkle proof   %d steps\n", len(proof.Proof))
+			fmt.Printf("Block          %d  %x\n", block.Index, block.Hash)
+			fmt.Printf("Confirmations  %d  (tip %d  %x)\n", len(proof.Headers)-1, tip.Index, tip.Hash)
+			if proofOutput != "" {
+				fmt.Printf("Written to     %s\n", proofOutput)
+			}
+		})
+	},
+}
+
+func init() {
+	proofCmd.Flags().IntVar(&proofConfirmations, "confirmations", 0, "Number of blocks after the committing block to include headers for (0 includes every block up to the tip)")
+	proofCmd.Flags().StringVarP(&proofOutput, "output", "o", "", "File to write the proof to as JSON")
+	rootCmd.AddCommand(proofCmd)
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var verifyOnly bool
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <snapshot-dir>",
+	Short: "Restores the node's data from a backup snapshot",
+	Long: `This command restores the blockchain, chunks, manifests and pins from a backup snapshot, which is a copy of the
+			node's data directory. Only data that passes verification is restored. Use --verify-only to check the snapshot
+			and report exactly what a full restore would recover without touching the live data directory.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		snapshot := snapshotLayout(args[0])
+		// The snapshot is checked against the same checkpoints the restored chain is imported with, so the report
+		// matches what the restore does
+		verifier, err := configureBlockchain(core.NewMemoryChainStore())
+		if err != nil {
+			return err
+		}
+		report, err := storage.PlanRestore(snapshot, verifier.Checkpoints, core.MiningDifficulty)
+		if err != nil {
+			return err
+		}
+		// The JSON summary describes the restore before it runs, with the exit status telling whether it succeeded
+		err = printResult(newRestoreSummary(report), func() { printRestoreReport(report) })
+		if err != nil {
+			return err
+		}
+
+		if verifyOnly {
+			if !report.Complete() {
+				return errors.New("snapshot failed verification, a restore would only recover part of it")
+			}
+			if !jsonOutput {
+				fmt.Println("Snapshot verified, a restore would recover all of it")
+			}
+			return nil
+		}
+
+		// The chain is restored into a fresh chain store, which only takes the place of the live one once every block
+		// has been restored
+		livePath, err := chainStoreFile()
+		if err != nil {
+			return err
+		}
+		restoredPath := livePath + ".restore"
+		err = storage.RemoveChainFile(restoredPath)
+		if err != nil {
+			return err
+		}
+		chainStore, err := openChainStoreAt(restoredPath)
+		if err != nil {
+			return err
+		}
+		blockchain, err := configureBlockchain(chainStore)
+		if err != nil {
+			return err
+		}
+		chunkStore, err := openChunkStore(chunkStorePath)
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		err = storage.RunRestore(report, snapshot, blockchain, core.MiningDifficulty, chunkStore, manifestStore, pinsPath)
+		if err != nil {
+			storage.RemoveChainFile(restoredPath)
+			return err
+		}
+		err = storage.ReplaceChainFile(restoredPath, livePath)
+		if err != nil {
+			return err
+		}
+		if !jsonOutput {
+			fmt.Println("Restore complete")
+		}
+		return nil
+	},
+}
+
+// Function that locates every part of the node's data within a snapshot of its data directory
+func snapshotLayout(dir string) storage.DataLayout {
+	return storage.DataLayout{
+		ChainJSONPath:   filepath.Join(dir, filepath.Base(blockchainPath)),
+		ChainBoltPath:   filepath.Join(dir, filepath.Base(chainStorePath)),
+		ChainNDJSONPath: filepath.Join(dir, filepath.Base(blocksFilePath)),
+		ChunkDir:        filepath.Join(dir, filepath.Base(chunkStorePath)),
+		ManifestDir:     filepath.Join(dir, filepath.Base(manifestStorePath)),
+		PinsPath:        filepath.Join(dir, filepath.Base(pinsPath)),
+		ChunkKeyPath:    snapshotKeyPath(dir),
+	}
+}
+
+// Function that locates the key a snapshot's chunks are encrypted with
+// Keys are often kept out of backups, so the node's own key (e.g. one recovered from escrow) is used if the snapshot
+// has none
+func snapshotKeyPath(dir string) string {
+	path := filepath.Join(dir, filepath.Base(chunkKeyPath))
+	if _, err := os.Stat(path); err != nil {
+		return chunkKeyPath
+	}
+	return path
+}
+
+// Type - Structure summarising a restore report for JSON output, adding the counts of what would be recovered
+type restoreSummary struct {
+	*storage.RestoreReport
+	Blocks    int  `json:"blocks"`    // Number of valid blocks that would be restored
+	Chunks    int  `json:"chunks"`    // Number of chunks that would be restored
+	Manifests int  `json:"manifests"` // Number of manifests that would be restored
+	Pins      int  `json:"pins"`      // Number of pins that would be restored
+	Complete  bool `json:"complete"`  // Whether every part of the snapshot would be restored in full
+}
+
+// Function that summarises a restore report for JSON output
+func newRestoreSummary(report *storage.RestoreReport) restoreSummary {
+	summary := restoreSummary{RestoreReport: report, Blocks: len(report.Blocks), Chunks: len(report.Chunks),
+		Manifests: len(report.Manifests), Complete: report.Complete()}
+	if report.Pins != nil {
+		summary.Pins = len(report.Pins.Pins)
+	}
+	return summary
+}
+
+// Function that prints what a restore would recover from a snapshot and what it would have to skip
+func printRestoreReport(report *storage.RestoreReport) {
+	if report.ChainErr != "" {
+		fmt.Printf("Blockchain: not recoverable (%s)\n", report.ChainErr)
+	} else {
+		fmt.Printf("Blockchain: %d of %d blocks valid in %s\n", len(report.Blocks), report.ChainLength, report.ChainSource)
+	}
+
+	fmt.Printf("Chunks: %d recoverable (%d bytes)\n", len(report.Chunks), report.ChunkBytes)
+	for _, name := range report.CorruptChunks {
+		fmt.Printf("  corrupt chunk file: %s\n", name)
+	}
+	for _, hash := range report.MissingChunks {
+		fmt.Printf("  indexed chunk without a file: %s\n", hash)
+	}
+	for _, name := range report.UnindexedChunks {
+		fmt.Printf("  chunk file missing from the index: %s\n", name)
+	}
+
+	fmt.Printf("Manifests: %d recoverable\n", len(report.Manifests))
+	for _, name := range report.InvalidManifests {
+		fmt.Printf("  invalid manifest: %s\n", name)
+	}
+
+	switch {
+	case report.PinsErr != "":
+		fmt.Printf("Pins: not recoverable (%s)\n", report.PinsErr)
+	case report.Pins == nil:
+		fmt.Println("Pins: none in snapshot")
+	default:
+		fmt.Printf("Pins: %d recoverable\n", len(report.Pins.Pins))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().BoolVar(&verifyOnly, "verify-only", false, "Check the snapshot and report what would be recovered without restoring anything")
+}
@@ -1,21 +1,75 @@
 package cmd
 
 import (
+	"blockchain-storage/core"
+	"blockchain-storage/logging"
+	"blockchain-storage/network"
+	"blockchain-storage/tracing"
+	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
+var apiAddr string
+var chainBackend string
+var pruneDepth int
+var compressChunks bool
+var checkpoints []string
+var logLevel string
+var logFormat string
+var traceFile string
+
 var rootCmd = &cobra.Command{
 	Use:   "p2p-storage",
 	Short: "P2P decentralised cloud storage system",
 	Long: `This is a fully-decentralised cloud storage system that runs on a peer-to-peer network.
 			It utilises core in order to track all file uploads.`,
 	// No run function needed for root command
+	// Logging is configured before any command runs so that every component logs at the chosen level and format
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		err := logging.Configure(os.Stderr, logLevel, logFormat)
+		if err != nil || traceFile == "" {
+			return err
+		}
+		return tracing.Install(traceFile)
+	},
+}
+
+func init() {
+	rootCmd.Version = network.BuildVersion().String()
+	// The API address is used both by the start command to listen on and by other commands to query the node
+	rootCmd.PersistentFlags().StringVar(&apiAddr, "api", "127.0.0.1:5001", "Address of the node's local API")
+	rootCmd.PersistentFlags().StringVar(&chainBackend, "chain-backend", "bolt", "Storage backend of the blockchain (bolt, ndjson or json)")
+	rootCmd.PersistentFlags().StringSliceVar(&checkpoints, "checkpoint", nil, "Block hashes required at fixed heights, as <height>:<hex hash>, in addition to the built-in checkpoints")
+	// Lowering the difficulty is only meant for local test networks, as blocks mined with it are rejected by other nodes
+	rootCmd.PersistentFlags().UintVar(&core.MiningDifficulty, "difficulty", core.MiningDifficulty, "Proof of work difficulty (leading zero bits) of mined and accepted blocks")
+	// Logs are written to stderr so that they never mix with command output; only warnings and errors by default
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "warn", "Least severe level of log records written (debug, info, warn or error)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "Format of log records (text or json)")
+	// Results are printed on stdout as JSON for scripts, while logs and progress never mix with them
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print command results as JSON instead of text")
+	// Tracing is off by default; with a trace file, the spans of uploads, downloads and peer requests are appended to it
+	rootCmd.PersistentFlags().StringVar(&traceFile, "trace-file", "", "File to append a JSON line to for every finished trace span")
+	rootCmd.PersistentFlags().IntVar(&pruneDepth, "prune-depth", 0, "Number of recent blocks kept in full, keeping only headers of older blocks (0 keeps every block)")
+	// Chunks are addressed by the hash of their uncompressed contents, so compressed and uncompressed chunks mix freely
+	rootCmd.PersistentFlags().BoolVar(&compressChunks, "compress-chunks", false, "Compress chunks stored from then on with zstd when that makes them smaller")
 }
 
+// Function that runs the command given on the command line
+// Commands are given a context that is cancelled on Ctrl-C or SIGTERM, so that long running work such as mining stops
+// cleanly. A second signal kills the process straight away.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
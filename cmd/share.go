@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var grantee string
+var capabilityExpiry string
+var capabilityOutput string
+
+var shareCmd = &cobra.Command{
+	Use:   "share <name|root>",
+	Short: "Grants a peer read access to an encrypted file",
+	Long: `This command mints a capability granting a peer read access to a file uploaded by this node with --encrypt.
+			The capability is signed with the node's identity key and holds the file's manifest and the key its chunks are
+			encrypted with, so it must only be handed to the peer it grants access to. Storage nodes check the capability
+			before serving the file's chunks, until it expires, e.g.
+			share photos-2024 --peer 12D3KooW... --expires 7d --output photos.capability.json
+			The peer then downloads the file with "download photos-2024 --capability photos.capability.json".`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := peer.Decode(grantee); err != nil {
+			return fmt.Errorf("invalid peer ID: %s. %w", grantee, err)
+		}
+		validity, err := storage.ParseAge(capabilityExpiry)
+		if err != nil {
+			return err
+		}
+
+		blockchain, err := loadBlockchain()
+		if err != nil {
+			return err
+		}
+		merkleRoot, err := blockchain.ResolveFile(args[0])
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		manifest, err := manifestStore.GetManifest(merkleRoot)
+		if err != nil {
+			return err
+		}
+		if manifest.Key == nil && !manifest.Encrypted {
+			return errors.New("file is not encrypted, so any peer can already read it")
+		}
+		keys, err := openKeystore()
+		if err != nil {
+			return err
+		}
+		identityKey, err := loadIdentityKey(keys)
+		if err != nil {
+			return err
+		}
+		key, err := fileKey(keys, manifest)
+		if err != nil {
+			return err
+		}
+
+		capability := core.NewCapability(identityKey, manifest, grantee, key, time.Now().Add(validity))
+		// Storage nodes only honour capabilities issued by the uploader of the file
+		err = blockchain.VerifyCapability(capability, grantee, time.Now())
+		if err != nil {
+			return err
+		}
+		output := capabilityOutput
+		if output == "" {
+			output = hex.EncodeToString(merkleRoot) + ".capability.json"
+		}
+		err = core.WriteCapability(output, capability)
+		if err != nil {
+			return err
+		}
+
+		result := struct {
+			MerkleRoot string    `json:"merkleRoot"` // Hex encoded Merkle root of the shared file
+			Grantee    string    `json:"grantee"`    // Peer ID of the peer granted access
+			Expiry     time.Time `json:"expiry"`     // Time the capability stops granting access
+			Path       string    `json:"path"`       // Path the capability was written to
+		}{hex.EncodeToString(merkleRoot), grantee, capability.Expiry, output}
+		return printResult(result, func() {
+			fmt.Printf("Granted %s access to %s until %s, hand over %s to it\n", result.Grantee, result.MerkleRoot,
+				result.Expiry.Format(time.RFC3339), result.Path)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+	shareCmd.Flags().StringVar(&grantee, "peer", "", "Peer ID of the peer to grant access to")
+	shareCmd.Flags().StringVar(&capabilityExpiry, "expires", "7d", "How long the capability grants access for, e.g. 30d, 2w or 12h")
+	shareCmd.Flags().StringVarP(&capabilityOutput, "output", "o", "", "Path to write the capability to (defaults to <root>.capability.json)")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/storage"
+	"fmt"
+	"github.com/spf13/cobra"
+	"net/http"
+	"path/filepath"
+)
+
+var snapshotChunks bool
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manages snapshots of a running node's data",
+	Long:  `This command groups together the operations for exporting the data of a running node`,
+	// No run function needed as this command only groups subcommands
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create <snapshot-dir>",
+	Short: "Exports a consistent snapshot of a running node's data",
+	Long: `This command asks the running node to export its blockchain, chunk index, manifests and pins into a new
+			directory as they were at a single point in time, without stopping the node. The snapshot records a hash of
+			the node's configuration and of every file it holds, and can be restored with the restore command.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The snapshot is written by the node, which may have been started in another directory
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		var info storage.SnapshotInfo
+		request := api.SnapshotRequest{Dir: dir, IncludeChunks: snapshotChunks}
+		err = api.Request(apiAddr, http.MethodPost, "/snapshots", request, &info)
+		if err != nil {
+			return err
+		}
+		return printResult(info, func() {
+			fmt.Printf("Snapshot created in %s\n", dir)
+			fmt.Printf("Chain: %d blocks up to %s (%s)\n", info.ChainHeight+1, info.ChainHead, info.ChainFile)
+			fmt.Printf("Chunks: %d indexed, data copied: %t\n", info.Chunks, info.ChunkData)
+			fmt.Printf("Manifests: %d, pins: %d\n", info.Manifests, info.Pins)
+			fmt.Printf("Config hash: %s\n", info.ConfigHash)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCreateCmd.Flags().BoolVar(&snapshotChunks, "chunks", true, "Copy the chunk files as well as the chunk index")
+}
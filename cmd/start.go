@@ -0,0 +1,487 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/core"
+	"blockchain-storage/events"
+	"blockchain-storage/logging"
+	"blockchain-storage/network"
+	"blockchain-storage/resources"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var port int
+var quicPort int
+var webSocketPort int
+var bootstrapAddr string
+var allowedPeers []string
+var bannedPeers []string
+var allowedCIDRs []string
+var bannedCIDRs []string
+var enableAutoNAT bool
+var enableRelay bool
+var enableHolePunching bool
+var relayService bool
+var staticRelays []string
+var uploadConcurrency int
+var enableMDNS bool
+var enableDHTDiscovery bool
+var staticPeersFile string
+var dnsSeeds []string
+var enablePeerExchange bool
+var capacityGiB int64
+var maxStorageBytes int64
+var roles []string
+var price float64
+var maxCPUPercent float64
+var maxMemoryMB uint64
+var maxDiskIOMB uint64
+var bandwidthKiB bandwidthFlags
+var messageRates []string
+var minPeerVersion string
+var minStoragePeers int
+var replicationFactor int
+var repairInterval time.Duration
+var enableExplorer bool
+var gatewayAddr string
+var s3Addr string
+var s3Replicas int
+var fastSync bool
+var batchWindow time.Duration
+var batchSize int
+var reprovideInterval time.Duration
+var cacheMemoryMB int64
+var cacheDiskMB int64
+var relayCacheMemoryMB int64
+var relayCacheDiskMB int64
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Starts a storage node",
+	Long:  `This command starts a node that joins the P2P network and serves the local API used by the other commands`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rateLimits, err := messageRateLimits(messageRates)
+		if err != nil {
+			return err
+		}
+		// Hole punching upgrades relayed connections, so it is off by default when relays are
+		if !enableRelay && !cmd.Flags().Changed("hole-punching") {
+			enableHolePunching = false
+		}
+		// The node's roles decide which of its subsystems run, as a node that stores nothing for its peers has no use
+		// for some of them
+		exclusiveRole, err := network.ExclusiveRole(roles)
+		if err != nil {
+			return err
+		}
+		clientOnly := exclusiveRole == network.ClientRole
+		// A bootstrap node only serves the DHT and peer discovery, so it serves no files and takes no uploads
+		bootstrapOnly := exclusiveRole == network.BootstrapRole
+		if bootstrapOnly && (gatewayAddr != "" || s3Addr != "") {
+			return errors.New("a bootstrap node serves no files, so --gateway and --s3 cannot be given with the bootstrap role")
+		}
+		env, err := loadUploadEnvironment()
+		if err != nil {
+			return err
+		}
+		// A light client keeps only headers and the files it retrieves, storing nothing for its peers
+		lightClient := exclusiveRole == network.LightRole
+		env.Chain.HeadersOnly = lightClient
+		// Bring a chain that was previously kept in full down to the prune depth before the node starts adding blocks
+		_, err = env.Chain.Prune()
+		if err != nil {
+			return err
+		}
+		// Subsystems announce what happens in the node on a single bus, which the local API streams to subscribers
+		bus := events.NewBus()
+		env.Chain.Events = bus
+		env.ChunkStore.Events = bus
+		network.Events = bus
+		network.Chain = env.Chain
+		network.Chunks = env.ChunkStore
+		network.Access = env.Access
+		network.Proofs, err = storage.NewProofStore(proofStorePath)
+		if err != nil {
+			return err
+		}
+		network.Ledger, err = storage.NewCreditLedger(creditLedgerPath)
+		if err != nil {
+			return err
+		}
+		// Chunks of unpinned files fetched to serve them are cached apart from the chunk store
+		var chunkCache *storage.ChunkCache
+		if !bootstrapOnly && (cacheMemoryMB > 0 || cacheDiskMB > 0) {
+			cacheDir := ""
+			if cacheDiskMB > 0 {
+				cacheDir = chunkCachePath
+			}
+			chunkCache, err = storage.NewChunkCache(cacheMemoryMB<<20, cacheDir, cacheDiskMB<<20)
+			if err != nil {
+				return err
+			}
+			network.Cache = chunkCache
+		}
+		// A cache node stores nothing permanently, keeping the chunks it fetches for its peers in a cache of their own
+		cacheOnly := exclusiveRole == network.CacheRole
+		if cacheOnly {
+			if relayCacheMemoryMB <= 0 && relayCacheDiskMB <= 0 {
+				return errors.New("the cache role needs a relay cache, so --relay-cache-memory or --relay-cache-disk must be positive")
+			}
+			relayCacheDir := ""
+			if relayCacheDiskMB > 0 {
+				relayCacheDir = relayCachePath
+			}
+			network.RelayCache, err = storage.NewChunkCache(relayCacheMemoryMB<<20, relayCacheDir, relayCacheDiskMB<<20)
+			if err != nil {
+				return err
+			}
+		}
+		go applyTombstones(cmd.Context(), env, bus.Subscribe(events.BlockAdded))
+
+		// With resource ceilings set, mining, chunk transfers and audits are throttled while the node exceeds them
+		limits := resources.Limits{CPUPercent: maxCPUPercent, Memory: maxMemoryMB << 20, DiskIO: maxDiskIOMB << 20}
+		if limits.Enabled() {
+			monitor := resources.NewMonitor(limits)
+			go monitor.Run(cmd.Context())
+			env.Resources = monitor
+			network.Resources = monitor
+			expvar.Publish("resourceUsage", expvar.Func(func() any {
+				return map[string]any{"usage": monitor.Usage(), "throttleFactor": monitor.Factor()}
+			}))
+		}
+
+		// Uploads submitted through the API are mined with pre-emption and announced to the network
+		env.NewTips = network.NewTips
+		env.Broadcast = network.BroadcastBlock
+		env.Provide = func(manifest *core.Manifest) {
+			go func() {
+				err := network.ProvideManifest(cmd.Context(), manifest)
+				if err != nil {
+					logging.Component(logging.Network).Error("error encountered when announcing upload", "error", err)
+				}
+			}()
+		}
+		server := &api.Server{Downloads: api.NewDownloadLog(), Events: bus, Explorer: enableExplorer,
+			Manifests: env.ManifestStore, Chunks: env.ChunkStore, Cache: chunkCache}
+		if !bootstrapOnly {
+			// Uploads are only reported as successful once enough peers acknowledge storing them
+			env.Place = network.PlaceChunks
+			env.Audit = network.AuditReplica
+			// Agreements with the peers holding an upload are signed by them and recorded under the node's peer ID
+			env.Agree = network.RequestAgreement
+			peerID, err := network.PeerIDFromKey(env.IdentityKey)
+			if err != nil {
+				return err
+			}
+			env.PeerID = peerID.String()
+			// Pinned files are kept on enough live peers, placing them on new peers when their holders disappear
+			env.FindHolders = network.FindReplicaHolders
+			if repairInterval > 0 {
+				go repairReplicas(cmd.Context(), upload.NewRepairManager(env, replicationFactor), repairInterval)
+			}
+			// With a batch window, uploads arriving close together share one mined block
+			if batchWindow > 0 {
+				env.Batcher = upload.NewBatcher(env, batchWindow, batchSize)
+				go env.Batcher.Run(cmd.Context())
+			}
+			// Uploads are deferred until enough storage peers are connected and the chain is synced
+			env.Health = func() error {
+				return network.CheckHealth(minStoragePeers)
+			}
+			// Uploads left unfinished when the node last stopped are queued again
+			server.Uploads, err = upload.NewPersistentScheduler(env, uploadConcurrency, uploadQueuePath)
+			if err != nil {
+				return err
+			}
+		}
+		blockIndex, err := storage.NewBlockIndex(blockIndexPath)
+		if err != nil {
+			return err
+		}
+		defer blockIndex.Close()
+		// The index is brought up to date before every query, one query at a time so that syncs do not overlap
+		var blockIndexMutex sync.Mutex
+		server.QueryBlocks = func(query storage.BlockQuery) ([]storage.BlockRecord, error) {
+			blockIndexMutex.Lock()
+			defer blockIndexMutex.Unlock()
+			err := blockIndex.Sync(env.Chain, env.ManifestStore)
+			if err != nil {
+				return nil, err
+			}
+			return blockIndex.Query(query)
+		}
+		// Snapshots are laid out like the data directory, so that they can be restored with the restore command
+		server.CreateSnapshot = func(dir string, includeChunks bool) (*storage.SnapshotInfo, error) {
+			options := storage.SnapshotOptions{IncludeChunks: includeChunks, ConfigHash: configHash(cmd)}
+			return storage.CreateSnapshot(dir, snapshotLayout(dir), env.Chain.Store, env.ChunkStore, env.ManifestStore,
+				env.PinSet, options)
+		}
+
+		// Serve the local API in the background so that the node can be queried while it runs
+		go func() {
+			err := server.Serve(apiAddr)
+			if err != nil {
+				logging.Component(logging.API).Error("local API stopped", "error", err)
+			}
+		}()
+		// The gateway listens on an address of its own, so that it can be exposed without exposing the local API
+		if gatewayAddr != "" {
+			go func() {
+				err := server.ServeGateway(gatewayAddr)
+				if err != nil {
+					logging.Component(logging.API).Error("HTTP gateway stopped", "error", err)
+				}
+			}()
+		}
+		// Objects stored through the S3 endpoint are uploaded like files submitted through the local API
+		if s3Addr != "" {
+			err := os.MkdirAll(objectSpoolPath, 0755)
+			if err != nil {
+				return err
+			}
+			server.ObjectDir = objectSpoolPath
+			server.ObjectParams = upload.Params{Workers: 4, Retries: 3, Replicas: s3Replicas}
+			go func() {
+				err := server.ServeS3(loopbackAddr(s3Addr))
+				if err != nil {
+					logging.Component(logging.API).Error("S3 endpoint stopped", "error", err)
+				}
+			}()
+		}
+
+		return network.StartNode(cmd.Context(), network.Config{
+			Port:          port,
+			QUICPort:      quicPort,
+			WebSocketPort: webSocketPort,
+			BootstrapAddr: bootstrapAddr,
+			IdentityKey:   env.IdentityKey,
+			AllowedPeers:  allowedPeers,
+			BannedPeers:   bannedPeers,
+			AllowedCIDRs:  allowedCIDRs,
+			BannedCIDRs:   bannedCIDRs,
+
+			EnableAutoNAT:      enableAutoNAT,
+			EnableRelay:        enableRelay,
+			EnableHolePunching: enableHolePunching,
+			RelayService:       relayService,
+			StaticRelays:       staticRelays,
+
+			EnableDHTDiscovery: enableDHTDiscovery,
+			EnableMDNS:         enableMDNS,
+			StaticPeersFile:    staticPeersFile,
+			DNSSeeds:           dnsSeeds,
+			EnablePeerExchange: enablePeerExchange,
+
+			MinPeerVersion:  minPeerVersion,
+			Bandwidth:       bandwidthKiB.limits(),
+			MessageRates:    rateLimits,
+			MaxStorageBytes: maxStorageBytes,
+			FastSync:        fastSync,
+			CacheOnly:       cacheOnly,
+			LightClient:     lightClient,
+			ClientOnly:      clientOnly,
+			BootstrapOnly:   bootstrapOnly,
+
+			ProvidedContent:   providedContentFunc(env, bootstrapOnly),
+			ReprovideInterval: reprovideInterval,
+			Capabilities:      nodeCapabilities(env, exclusiveRole != ""),
+		})
+	},
+}
+
+// Function that applies the rate limits given on the command line over the default ones
+func messageRateLimits(values []string) (network.MessageRateLimits, error) {
+	limits := network.DefaultMessageRateLimits()
+	for _, value := range values {
+		messageType, limit, err := network.ParseMessageRateLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[messageType] = limit
+	}
+	return limits, nil
+}
+
+// Function that binds an address without a host to loopback, so that an endpoint is only exposed when asked to be
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// Function that hashes the node's configuration, being the value of every flag it was started with
+func configHash(cmd *cobra.Command) string {
+	hash := sha256.New()
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		fmt.Fprintf(hash, "%s=%s\n", flag.Name, flag.Value)
+	})
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Function that lists the hashes of every Merkle root and chunk held by the node so that they can be announced
+func providedContent(env *upload.Environment) [][]byte {
+	var hashes [][]byte
+	manifests, err := env.ManifestStore.ListManifests()
+	if err != nil {
+		logging.Component(logging.Storage).Error("error encountered when listing manifests", "error", err)
+	}
+	for _, manifest := range manifests {
+		hashes = append(hashes, manifest.MerkleRoot)
+	}
+	for _, chunkHash := range env.ChunkStore.Hashes() {
+		hashes = append(hashes, chunkHash)
+	}
+	return hashes
+}
+
+// Function that returns the function listing the content the node announces, or nil for a bootstrap node, which holds
+// no content of its own to announce
+func providedContentFunc(env *upload.Environment, bootstrapOnly bool) func() [][]byte {
+	if bootstrapOnly {
+		return nil
+	}
+	return func() [][]byte {
+		return providedContent(env)
+	}
+}
+
+// Function that builds the capabilities advertised by the node, or nil if it neither offers storage nor refuses chunks
+// The free space advertised is the offered capacity less the space already taken up by the chunk store. Cache
+// nodes, light clients, client-only and bootstrap nodes advertise their role with no free space, as chunks cannot be
+// placed on them.
+func nodeCapabilities(env *upload.Environment, refusesChunks bool) func() network.Capabilities {
+	if refusesChunks {
+		return func() network.Capabilities {
+			return network.Capabilities{Roles: roles, Price: price}
+		}
+	}
+	if capacityGiB <= 0 {
+		return nil
+	}
+	return func() network.Capabilities {
+		freeSpace := capacityGiB<<30 - env.ChunkStore.UsedSpace()
+		// Peers are not told about space the storage quota would refuse their chunks for
+		if maxStorageBytes > 0 {
+			freeSpace = min(freeSpace, maxStorageBytes-env.ChunkStore.UsedSpace())
+		}
+		if freeSpace < 0 {
+			freeSpace = 0
+		}
+		return network.Capabilities{FreeSpace: freeSpace, Roles: roles, Price: price}
+	}
+}
+
+// Function that honours every tombstone added to the chain by deleting the file's local chunks, until the context ends
+func applyTombstones(ctx context.Context, env *upload.Environment, subscription *events.Subscription) {
+	defer subscription.Unsubscribe()
+	for {
+		select {
+		case event := <-subscription.Events():
+			block, err := env.Chain.GetBlockByHash(event.Hash)
+			if err != nil || len(block.Tombstone) == 0 {
+				continue
+			}
+			report, err := upload.ApplyTombstone(env, block.Tombstone)
+			if err != nil {
+				logging.Component(logging.Storage).Error("error encountered when deleting a file's chunks", "file",
+					hex.EncodeToString(block.Tombstone), "error", err)
+				continue
+			}
+			logging.Component(logging.Storage).Info("deleted file", "file", hex.EncodeToString(block.Tombstone),
+				"chunks", len(report.Candidates), "tombstone", block.Index)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Function that checks the replication of every pinned file at the given interval, until the context ends
+func repairReplicas(ctx context.Context, manager *upload.RepairManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report, err := manager.RepairOnce(ctx)
+			if err != nil {
+				logging.Component(logging.Storage).Error("error encountered when repairing replicas", "error", err)
+				continue
+			}
+			if report.Placed > 0 || len(report.Degraded) > 0 {
+				logging.Component(logging.Storage).Info("repaired replicas", "checked", report.Checked,
+					"repaired", report.Repaired, "placed", report.Placed, "degraded", len(report.Degraded))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(startCmd)
+	startCmd.Flags().IntVarP(&port, "port", "p", 4001, "TCP port to listen on")
+	startCmd.Flags().IntVar(&quicPort, "quic-port", 4001, "UDP port to listen on for QUIC (0 disables QUIC)")
+	startCmd.Flags().IntVar(&webSocketPort, "ws-port", 0, "TCP port to listen on for WebSockets (0 disables WebSockets)")
+	startCmd.Flags().StringVarP(&bootstrapAddr, "bootstrap", "b", "", "Multiaddress of a bootstrap peer")
+	startCmd.Flags().IntVar(&uploadConcurrency, "upload-workers", 2, "Number of uploads submitted through the API processed at once")
+	startCmd.Flags().BoolVar(&enableExplorer, "explorer", false, "Serve a block explorer on the local API at /explorer")
+	startCmd.Flags().StringVar(&gatewayAddr, "gateway", "", "Address to serve stored files over HTTP on at /file/<merkle-root or CID> and /name/<name> (empty disables it)")
+	startCmd.Flags().StringVar(&s3Addr, "s3", "", "Address to serve an S3-compatible object endpoint on, storing objects under the alias <bucket>/<key> (empty disables it, and an address without a host only listens on loopback)")
+	startCmd.Flags().IntVar(&s3Replicas, "s3-replicas", 1, "Number of peers that must acknowledge storing an object stored through the S3 endpoint")
+	startCmd.Flags().DurationVar(&batchWindow, "batch-window", 0, "Time uploads wait for others to be committed in the same block (0 mines a block for every upload)")
+	startCmd.Flags().IntVar(&batchSize, "batch-size", 100, "Greatest number of uploads committed in one block")
+	startCmd.Flags().IntVar(&minStoragePeers, "min-storage-peers", 1, "Number of connected storage peers needed before submitted uploads run (fewer defers them)")
+	startCmd.Flags().IntVar(&replicationFactor, "replication-factor", 3, "Number of live peers every pinned file should be held by")
+	startCmd.Flags().DurationVar(&repairInterval, "repair-interval", 10*time.Minute, "Interval between checks re-replicating pinned files short of live peers (0 disables them)")
+	startCmd.Flags().StringSliceVar(&allowedPeers, "allow-peer", nil, "Peer IDs allowed to connect (all peers if empty)")
+	startCmd.Flags().StringSliceVar(&bannedPeers, "ban-peer", nil, "Peer IDs refused at connection time")
+	startCmd.Flags().StringSliceVar(&allowedCIDRs, "allow-cidr", nil, "IP ranges allowed to connect (all ranges if empty)")
+	startCmd.Flags().StringSliceVar(&bannedCIDRs, "ban-cidr", nil, "IP ranges refused at connection time")
+	// NAT traversal is enabled by default so that home users can participate without extra configuration
+	startCmd.Flags().BoolVar(&enableAutoNAT, "nat", true, "Detect reachability with AutoNAT and request router port mappings")
+	startCmd.Flags().BoolVar(&enableRelay, "relay", true, "Allow connections through circuit relays")
+	startCmd.Flags().BoolVar(&enableHolePunching, "hole-punching", true, "Upgrade relayed connections with hole punching (off by default with --relay=false)")
+	startCmd.Flags().BoolVar(&relayService, "relay-service", false, "Act as a circuit relay for nodes behind NAT")
+	startCmd.Flags().StringSliceVar(&staticRelays, "static-relay", nil, "Multiaddresses of relays to use when not reachable")
+	// Every discovery backend can be enabled independently and all of them feed the same list of peers
+	startCmd.Flags().BoolVar(&enableDHTDiscovery, "dht-discovery", true, "Discover peers advertising the protocol in the DHT")
+	startCmd.Flags().BoolVar(&enableMDNS, "mdns", true, "Discover peers on the local network through mDNS")
+	startCmd.Flags().StringVar(&staticPeersFile, "peers-file", "", "File listing peer multiaddresses to connect to, one per line")
+	startCmd.Flags().StringSliceVar(&dnsSeeds, "dns-seed", nil, "Domains whose dnsaddr TXT records list peers to connect to")
+	startCmd.Flags().BoolVar(&enablePeerExchange, "pex", true, "Ask connected peers for samples of the peers they know")
+	startCmd.Flags().DurationVar(&reprovideInterval, "reprovide-interval", 12*time.Hour, "Interval the node announces the files and chunks it holds in the DHT again at, before their provider records expire")
+	startCmd.Flags().BoolVar(&fastSync, "fast-sync", false, "Catch up from a snapshot of a peer's chain up to the latest checkpoint when far behind, syncing only later blocks in full")
+
+	startCmd.Flags().Int64Var(&capacityGiB, "capacity", 0, "GiB of storage offered to other peers (0 does not advertise any)")
+	startCmd.Flags().Int64Var(&cacheMemoryMB, "cache-memory", 64, "MiB of memory used to cache chunks of unpinned files the node serves (0 disables the memory tier)")
+	startCmd.Flags().Int64Var(&cacheDiskMB, "cache-disk", 0, "MiB of disk, separate from pinned storage, that cached chunks pushed out of memory move to (0 disables the disk tier)")
+	startCmd.Flags().Int64Var(&relayCacheMemoryMB, "relay-cache-memory", 256, "MiB of memory a node with the cache role caches the chunks it fetches for peers in")
+	startCmd.Flags().Int64Var(&relayCacheDiskMB, "relay-cache-disk", 4096, "MiB of disk a node with the cache role moves relayed chunks pushed out of memory to (0 keeps them in memory only)")
+	startCmd.Flags().Int64Var(&maxStorageBytes, "max-storage-bytes", 0, "Bytes the chunk store may take up before chunks placed by peers are refused (0 for no quota)")
+	startCmd.Flags().StringSliceVar(&roles, "role", []string{"storage"}, "Roles advertised to other peers (e.g. storage or relay, cache to store nothing permanently and cache the chunks fetched for peers instead, light to keep only headers and fetch only chunks proven to belong to committed files, client to upload and download without storing for peers, or bootstrap to only serve the DHT and peer discovery)")
+	startCmd.Flags().Float64Var(&price, "price", 0, "Price advertised per GiB stored")
+	startCmd.Flags().StringVar(&minPeerVersion, "min-peer-version", "", "Minimum node version (e.g. v1.2.0) peers must attest to before data is placed on them")
+	// Resource ceilings are off by default, as they are only needed when the node shares a machine with other work
+	startCmd.Flags().Float64Var(&maxCPUPercent, "max-cpu", 0, "Percentage of the machine's CPU the node may use before throttling itself (0 for no limit)")
+	startCmd.Flags().Uint64Var(&maxMemoryMB, "max-memory", 0, "MiB of memory the node may use before throttling itself (0 for no limit)")
+	startCmd.Flags().Uint64Var(&maxDiskIOMB, "max-disk-io", 0, "MiB per second of disk I/O the node may use before throttling itself (0 for no limit)")
+	// Bandwidth limits are off by default too, and can be changed while the node runs with 'bandwidth set'
+	bandwidthKiB.register(startCmd)
+	startCmd.Flags().StringSliceVar(&messageRates, "message-rate", nil, "Rate a single peer may send a type of message at, e.g. RequestChunks=20/50 for 20 per second with bursts of 50 (repeatable, a rate of 0 lifts the limit)")
+}
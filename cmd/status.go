@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows the status of a running node",
+	Long: `This command shows a summary of a running node: its chain, the space taken up by its chunk store, its peers
+			and its storage credit balance. The balance is the bytes the node stored for its peers less the bytes its
+			peers stored for it, backed by the signed acknowledgments of each placed chunk.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var status api.NodeStatus
+		err := api.Get(apiAddr, "/status", &status)
+		if err != nil {
+			return err
+		}
+		return printResult(status, func() { printStatus(status) })
+	},
+}
+
+// Function that prints a summary of a node's status, with a line for each peer in its credit ledger
+func printStatus(status api.NodeStatus) {
+	synced := "synced"
+	if !status.Synced {
+		synced = "syncing"
+	}
+	fmt.Printf("Node     %s\n", status.PeerID)
+	fmt.Printf("Chain    %d blocks (%s)\n", status.ChainLength, synced)
+	fmt.Printf("Storage  %d chunks, %s used\n", status.Chunks, formatSize(status.UsedSpace))
+	fmt.Printf("Peers    %d connected, %d storing\n", status.Peers, status.StoragePeers)
+	credits := status.Credits
+	if credits == nil {
+		return
+	}
+	fmt.Printf("Credits  %s stored for peers, %s stored by peers, balance %s (%d receipts)\n",
+		formatSize(credits.Provided), formatSize(credits.Consumed), formatBalance(credits.Balance), credits.Receipts)
+	for _, peerCredits := range credits.Peers {
+		fmt.Printf("  %-14s provided=%s consumed=%s balance=%s\n", shortID(peerCredits.Peer),
+			formatSize(peerCredits.Provided), formatSize(peerCredits.Consumed),
+			formatBalance(peerCredits.Provided-peerCredits.Consumed))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"blockchain-storage/api"
+	"blockchain-storage/storage"
+	"fmt"
+	"github.com/spf13/cobra"
+	"time"
+)
+
+var chunkDir string
+var dryRun bool
+var largestFiles int
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Manages the local chunk store",
+	Long:  `This command groups together the maintenance operations for the chunks stored on this node`,
+	// No run function needed as this command only groups subcommands
+}
+
+var rebuildIndexCmd = &cobra.Command{
+	Use:   "rebuild-index",
+	Short: "Rebuilds the chunk store index from the chunk headers",
+	Long:  `This command reconstructs the chunk store index by scanning the self-describing header of every stored chunk`,
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunkStore, err := openChunkStore(chunkDir)
+		if err != nil {
+			return err
+		}
+
+		skipped, err := chunkStore.RebuildIndex()
+		if err != nil {
+			return err
+		}
+
+		// Report any chunk files whose headers could not be read, as these need manual inspection
+		result := struct {
+			Chunks  int      `json:"chunks"`  // Number of chunks in the rebuilt index
+			Skipped []string `json:"skipped"` // Chunk files whose headers could not be read
+		}{len(chunkStore.Index), nonNil(skipped)}
+		return printResult(result, func() {
+			for _, name := range skipped {
+				fmt.Printf("Skipped chunk file with invalid header: %s\n", name)
+			}
+			fmt.Printf("Rebuilt index with %d chunks\n", result.Chunks)
+		})
+	},
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Deletes chunks that no longer need to be kept",
+	Long: `This command deletes chunks that are unreferenced, only belong to files whose lease has expired, or only belong
+			to unpinned files. Use --dry-run to report what would be deleted without deleting anything.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunkStore, err := openChunkStore(chunkDir)
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		manifests, err := manifestStore.ListManifests()
+		if err != nil {
+			return err
+		}
+		pinSet, err := storage.NewPinSet(pinsPath)
+		if err != nil {
+			return err
+		}
+
+		report := storage.PlanGC(chunkStore, manifests, pinSet, time.Now())
+
+		if !dryRun {
+			err = chunkStore.RunGC(report)
+			if err != nil {
+				return err
+			}
+		}
+		report.Candidates = nonNil(report.Candidates)
+		result := struct {
+			*storage.GCReport
+			DryRun bool `json:"dryRun"` // Whether the chunks were left in place
+		}{report, dryRun}
+		return printResult(result, func() {
+			// List exactly which chunks are affected, followed by a breakdown for each reason
+			for _, candidate := range report.Candidates {
+				fmt.Printf("%s  %d bytes  %s\n", candidate.Hash, candidate.Size, candidate.Reason)
+			}
+			for _, reason := range []storage.GCReason{storage.ReasonUnreferenced, storage.ReasonExpiredLease, storage.ReasonUnpinned} {
+				fmt.Printf("%s: %d chunks, %d bytes\n", reason, report.CountByReason[reason], report.BytesByReason[reason])
+			}
+			if dryRun {
+				fmt.Printf("Dry run: %d chunks (%d bytes) would be reclaimed\n", len(report.Candidates), report.ReclaimedBytes)
+			} else {
+				fmt.Printf("Reclaimed %d chunks (%d bytes)\n", len(report.Candidates), report.ReclaimedBytes)
+			}
+		})
+	},
+}
+
+var duCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Reports how the space taken up by the chunk store breaks down",
+	Long: `This command reports the space taken up by the chunk store, split into chunks of pinned files, chunks cached for
+			files that are not pinned, and orphaned chunks no manifest references (which 'storage gc' deletes), along
+			with the files taking up the most space. If the node is running, the space left under its quota is reported too.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		chunkStore, err := openChunkStore(chunkDir)
+		if err != nil {
+			return err
+		}
+		manifestStore, err := storage.NewManifestStore(manifestStorePath)
+		if err != nil {
+			return err
+		}
+		manifests, err := manifestStore.ListManifests()
+		if err != nil {
+			return err
+		}
+		pinSet, err := storage.NewPinSet(pinsPath)
+		if err != nil {
+			return err
+		}
+
+		result := struct {
+			*storage.UsageReport
+			Quota          int64 `json:"quota"`          // Space the running node's quota allows (0 if it has none or is not running)
+			RemainingQuota int64 `json:"remainingQuota"` // Space left under the quota (0 if there is no quota)
+		}{UsageReport: storage.PlanUsage(chunkStore, manifests, pinSet, time.Now(), largestFiles)}
+		// The quota is only known to the running node, as it is set when the node is started
+		var status api.NodeStatus
+		if api.Get(apiAddr, "/status", &status) == nil && status.StorageQuota > 0 {
+			result.Quota = status.StorageQuota
+			result.RemainingQuota = max(status.StorageQuota-result.Total.Bytes, 0)
+		}
+		return printResult(result, func() {
+			fmt.Printf("Total     %10s  %d chunks\n", formatSize(result.Total.Bytes), result.Total.Chunks)
+			fmt.Printf("Pinned    %10s  %d chunks\n", formatSize(result.Pinned.Bytes), result.Pinned.Chunks)
+			fmt.Printf("Cached    %10s  %d chunks\n", formatSize(result.Cached.Bytes), result.Cached.Chunks)
+			fmt.Printf("Orphaned  %10s  %d chunks\n", formatSize(result.Orphaned.Bytes), result.Orphaned.Chunks)
+			if result.Quota > 0 {
+				fmt.Printf("Quota     %10s  %s remaining\n", formatSize(result.Quota), formatSize(result.RemainingQuota))
+			}
+			if len(result.Largest) > 0 {
+				fmt.Println("\nLargest files")
+			}
+			for _, file := range result.Largest {
+				pinned := ""
+				if file.Pinned {
+					pinned = "  pinned"
+				}
+				fmt.Printf("  %10s  %s  %s%s\n", formatSize(file.Bytes), file.MerkleRoot, file.Alias, pinned)
+			}
+		})
+	},
+}
+
+// Function that opens a chunk store, encrypting new chunks with the node's chunk key if it has one
+// New chunks are compressed before they are encrypted if compression is enabled
+func openChunkStore(dir string) (*storage.ChunkStore, error) {
+	chunkStore, err := storage.NewChunkStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	chunkStore.Compress = compressChunks
+	chunkStore.Key, err = storage.LoadChunkKey(chunkKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return chunkStore, nil
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+	storageCmd.AddCommand(rebuildIndexCmd)
+	storageCmd.AddCommand(gcCmd)
+	gcCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be deleted without deleting anything")
+	storageCmd.AddCommand(duCmd)
+	duCmd.Flags().IntVarP(&largestFiles, "top", "n", 10, "Number of the largest files to list")
+	// The chunk store directory is shared by every storage subcommand
+	storageCmd.PersistentFlags().StringVarP(&chunkDir, "dir", "d", chunkStorePath, "Directory of the chunk store")
+}
@@ -1,69 +1,251 @@
 package cmd
 
 import (
+	"blockchain-storage/api"
 	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"blockchain-storage/verify"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var workers int
 var retries int
+var alias string
+var async bool
+var replicas int
+var audit bool
+var private bool
+var hashAlgorithm string
+var uploadTags []string
+var chunkSize string
+var force bool
+var claimName string
+var prevVersion string
+var encrypt bool
+var agreementDuration time.Duration
 
 var uploadCmd = &cobra.Command{
-	Use:   "upload",
-	Short: "Uploads a file to the network",
-	Long:  `This command is used to upload a file to the P2P network and store it on multiple nodes`,
-	Args:  cobra.ExactArgs(1), // There is exactly one mandatory argument which is the filepath
+	Use:   "upload <file>...",
+	Short: "Uploads files to the network",
+	Long: `This command is used to upload a file to the P2P network and store it on multiple nodes.
+			The file is only placed on peers when --replicas is given, in which case the upload succeeds once that
+			many peers have acknowledged storing it.
+			With --async the upload is submitted to a running node and the ID of its job is printed immediately.
+			Several files are always submitted to a running node, which queues them and runs as many at once as
+			its upload workers allow, printing the ID of each file's job.
+			The node defers the upload while too few storage peers are connected or its chain is not synced,
+			unless --force is given.`,
+	Args: cobra.MinimumNArgs(1), // Every argument is the path of a file to upload
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Perform optional flag checks:
 		// Number of miner workers needs to be between 1 and 12
 		// Number of retries needs to be between 1 and 5
 		if workers < 1 || workers > 12 {
-			return fmt.Errorf("invalid worker number: %d. Workers must be between 1 and 12", &workers)
+			return fmt.Errorf("invalid worker number: %d. Workers must be between 1 and 12", workers)
 		}
 		if retries < 1 || retries > 5 {
-			return fmt.Errorf("invalid retry number: %d. Retries must be between 1 and 5", &retries)
+			return fmt.Errorf("invalid retry number: %d. Retries must be between 1 and 5", retries)
 		}
 
-		// First the file needs to be chunked (with a chunk size of 64MB)
-		chunks, err := core.ChunkFile(args[0], 64)
+		// An alias, name or previous version belongs to a single file
+		if len(args) > 1 && (alias != "" || claimName != "" || prevVersion != "") {
+			return errors.New("--alias, --name and --previous can only be given when uploading a single file")
+		}
+
+		if claimName != "" {
+			err := core.ValidateName(claimName)
+			if err != nil {
+				return fmt.Errorf("invalid name: %s. %w", claimName, err)
+			}
+		}
+
+		if replicas < 0 {
+			return fmt.Errorf("invalid replica number: %d. Replicas cannot be negative", replicas)
+		}
+
+		if agreementDuration != 0 && (agreementDuration < time.Second || replicas == 0) {
+			return fmt.Errorf("invalid agreement duration: %s. Agreements last at least 1s and need --replicas", agreementDuration)
+		}
+
+		algorithm := verify.HashAlgorithm(hashAlgorithm)
+		if !algorithm.Available() {
+			return fmt.Errorf("invalid hash algorithm: %s. Supported algorithms are %v", hashAlgorithm, verify.HashAlgorithms())
+		}
+
+		parsedChunkSize, err := parseChunkSize(chunkSize)
 		if err != nil {
 			return err
 		}
 
-		// Create merkle tree of file
-		merkleTree := core.NewMerkleTree(chunks)
+		params := upload.Params{FilePath: args[0], Alias: alias, Workers: workers, Retries: retries, Replicas: replicas, Audit: audit, Private: private}
+		params.HashAlgorithm = algorithm
+		params.Tags = uploadTags
+		params.ChunkSize = parsedChunkSize
+		params.Force = force
+		params.Name = claimName
+		params.PrevVersion = prevVersion
+		params.Encrypt = encrypt
+		params.AgreementDuration = agreementDuration
 
-		// TODO: Network stuff once that functionality is implemented
+		if async && len(args) == 1 {
+			response, err := submitUpload(params, args[0])
+			if err != nil {
+				return err
+			}
+			return printResult(response, func() { fmt.Println(response.ID) })
+		}
+		if len(args) > 1 {
+			return submitUploads(params, args)
+		}
 
 		// TODO: Check blockchain length from network
 
-		blockchain, err := core.BlockchainFromFile("../storage/blockchain.json")
+		// Without a running node the file cannot be placed on peers, so only commit it if that is asked for
+		if replicas > 0 && !force {
+			return errors.New("uploads are placed on peers by a running node, so upload with --async to have the node " +
+				"place the file once the network is healthy, or with --force to commit it without placing it")
+		}
+
+		env, err := loadUploadEnvironment()
 		if err != nil {
 			return err
 		}
-
-		// Create the block
-		block := core.CreateBlock(blockchain, merkleTree.Root.Hash)
-
-		// Mine the block (difficulty is hardcoded as 5)
-		err = block.Mine(uint(5), workers, retries)
+		// Show a progress bar while the file's chunks are stored, created once the number of chunks is known
+		var bar *progressBar
+		env.ChunkProgress = func(stored, total, size int) {
+			if bar == nil {
+				bar = newProgressBar("Storing "+filepath.Base(args[0]), total)
+			}
+			bar.Add(size)
+			if stored == total {
+				bar.Finish()
+			}
+		}
+		// Show a live hashrate line while the block is mined, ending it before anything else is printed
+		// JSON output is meant to be parsed, so it is left without the progress line
+		var mining bool
+		env.Progress = func(progress core.MiningProgress) {
+			if jsonOutput {
+				return
+			}
+			mining = true
+			fmt.Printf("\rMining block: %s across %d workers, %d hashes in %s   ",
+				formatHashrate(progress.Hashrate), len(progress.WorkerHashrates), progress.Attempts, progress.Elapsed.Round(time.Second))
+		}
+		// Ctrl-C cancels the command's context, which stops the mining workers before the block is committed
+		result, err := upload.Run(cmd.Context(), env, params)
+		if mining {
+			fmt.Println()
+		}
+		if errors.Is(err, context.Canceled) {
+			return errors.New("upload interrupted")
+		}
+		if errors.Is(err, upload.ErrDegraded) {
+			// Without a running node the file cannot be placed on peers, so the upload is not reported as successful
+			printErr := printResult(result, func() {
+				fmt.Printf("Committed %s in block %d, but it is not stored on enough peers\n", result.MerkleRoot, result.BlockIndex)
+			})
+			if printErr != nil {
+				return printErr
+			}
+			return fmt.Errorf("%w (upload with --async to have a running node keep placing it)", err)
+		}
 		if err != nil {
 			return err
 		}
+		return printResult(result, func() { fmt.Printf("Uploaded %s (%s) in block %d\n", result.MerkleRoot, result.CID, result.BlockIndex) })
+	},
+}
 
-		// At this point in execution block must have successfully been mined so add it to the blockchain
-		blockchain.AddBlock(block)
+// SubmittedUpload - Structure pairing a file with the ID of the job it was submitted to a running node as
+type SubmittedUpload struct {
+	FilePath string `json:"filePath"` // Path of the file as given on the command line
+	ID       string `json:"id"`       // ID of the upload job
+}
+
+// Function that submits a file to a running node for upload with the given parameters
+func submitUpload(params upload.Params, filePath string) (api.SubmitResponse, error) {
+	// The node may run from a different directory so it needs an absolute path to the file
+	absolutePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return api.SubmitResponse{}, err
+	}
+	params.FilePath = absolutePath
+
+	var response api.SubmitResponse
+	err = api.Request(apiAddr, http.MethodPost, "/uploads", params, &response)
+	return response, err
+}
 
-		// Save blockchain back to file
-		err = blockchain.WriteToFile("../storage/blockchain.json")
+// Function that submits several files to a running node's upload queue and prints the job ID of each
+// Submitting stops at the first file the node refuses, such as once its queue is full, but the files submitted
+// before it are still printed so that their jobs can be followed.
+func submitUploads(params upload.Params, filePaths []string) error {
+	var submitted []SubmittedUpload
+	var submitErr error
+	for _, filePath := range filePaths {
+		response, err := submitUpload(params, filePath)
 		if err != nil {
-			return err
+			submitErr = fmt.Errorf("failed to submit %s: %w", filePath, err)
+			break
+		}
+		submitted = append(submitted, SubmittedUpload{FilePath: filePath, ID: response.ID})
+	}
+	err := printResult(nonNil(submitted), func() {
+		for _, submission := range submitted {
+			fmt.Printf("%s  %s\n", submission.ID, submission.FilePath)
 		}
+	})
+	if err != nil {
+		return err
+	}
+	return submitErr
+}
 
-		// Exit successfully
-		return nil
-	},
+// Function that loads everything an upload needs from the node's persistent data
+func loadUploadEnvironment() (*upload.Environment, error) {
+	blockchain, err := loadBlockchain()
+	if err != nil {
+		return nil, err
+	}
+	// Load the node's identity key, which is used to sign the block as its uploader
+	keys, err := openKeystore()
+	if err != nil {
+		return nil, err
+	}
+	identityKey, err := loadIdentityKey(keys)
+	if err != nil {
+		return nil, err
+	}
+	chunkStore, err := openChunkStore(chunkStorePath)
+	if err != nil {
+		return nil, err
+	}
+	manifestStore, err := storage.NewManifestStore(manifestStorePath)
+	if err != nil {
+		return nil, err
+	}
+	pinSet, err := storage.NewPinSet(pinsPath)
+	if err != nil {
+		return nil, err
+	}
+	accessList, err := storage.NewAccessList(accessListPath)
+	if err != nil {
+		return nil, err
+	}
+	env := upload.NewEnvironment(blockchain, identityKey, chunkStore, manifestStore, pinSet)
+	env.Access = accessList
+	env.Keys = keys
+	return env, nil
 }
 
 func init() {
@@ -71,4 +253,48 @@ func init() {
 	// Default values if flags not provided are 4 workers and 3 retries
 	uploadCmd.Flags().IntVarP(&workers, "workers", "w", 4, "Number of concurrent block mining workers (1-12)")
 	uploadCmd.Flags().IntVarP(&retries, "retries", "r", 3, "Number of retries if mining fails (1-5)")
+	uploadCmd.Flags().StringVarP(&alias, "alias", "a", "", "Name to upload the file under (defaults to the file name)")
+	// Unlike an alias, a claimed name is recorded in the block, so every node resolves it to the same file
+	uploadCmd.Flags().StringVar(&claimName, "name", "", "Name to claim for the file in the on-chain name registry (only its first claimer can update it)")
+	uploadCmd.Flags().StringVar(&prevVersion, "previous", "", "Name or Merkle root of the file this upload is a new version of")
+	uploadCmd.Flags().BoolVar(&async, "async", false, "Submit the upload to a running node and print its job ID")
+	uploadCmd.Flags().IntVar(&replicas, "replicas", 0, "Number of peers that must acknowledge storing the file before the upload succeeds (0 does not place it on peers)")
+	uploadCmd.Flags().BoolVar(&private, "private", false, "Pad chunks to a uniform size and randomise placement timing so the file's size is hidden")
+	uploadCmd.Flags().BoolVar(&encrypt, "encrypt", false, "Encrypt the file so that only peers granted access with \"share\" can read it")
+	uploadCmd.Flags().BoolVar(&force, "force", false, "Upload even if the network is unhealthy (too few storage peers or an unsynced chain)")
+	uploadCmd.Flags().BoolVar(&audit, "audit", false, "Also require every acknowledging peer to pass an audit of its replica")
+	uploadCmd.Flags().DurationVar(&agreementDuration, "agreement", 0, "Time every acknowledging peer promises to store the file for, recorded on the chain once the upload is placed (0 records no agreement)")
+	uploadCmd.Flags().StringSliceVar(&uploadTags, "tag", nil, "Label to record in the file's manifest, which the chain can be searched by (repeatable)")
+	uploadCmd.Flags().StringVar(&chunkSize, "chunk-size", "auto", "Size of the file's chunks, e.g. 512KB or 16MB (auto picks it from the file size)")
+	uploadCmd.Flags().StringVar(&hashAlgorithm, "hash", string(verify.SHA256), "Hash algorithm for the file's chunks, Merkle tree and block (sha256 or blake3, which is faster for large files)")
+}
+
+// Function that formats a number of hashes per second with the largest unit that keeps it above 1
+func formatHashrate(hashrate float64) string {
+	units := []string{"H/s", "kH/s", "MH/s", "GH/s"}
+	unit := 0
+	for hashrate >= 1000 && unit < len(units)-1 {
+		hashrate /= 1000
+		unit++
+	}
+	return fmt.Sprintf("%.2f %s", hashrate, units[unit])
+}
+
+// Function that parses a chunk size given as a number of bytes with an optional KB or MB suffix (0 for auto)
+func parseChunkSize(value string) (int64, error) {
+	if value == "auto" {
+		return 0, nil
+	}
+	unit := int64(1)
+	number := strings.ToUpper(value)
+	for suffix, size := range map[string]int64{"KB": 1024, "MB": 1024 * 1024} {
+		if trimmed, found := strings.CutSuffix(number, suffix); found {
+			number, unit = trimmed, size
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 || size > core.MaxChunkSize/unit {
+		return 0, fmt.Errorf("invalid chunk size: %s. The chunk size must be auto or between 1 byte and %dMB", value, core.MaxChunkSize/(1024*1024))
+	}
+	return size * unit, nil
 }
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"time"
+)
+
+// Errors returned when recording storage agreements
+var (
+	ErrAgreementUploader  = errors.New("only the uploader of a file can record agreements to store it")
+	ErrMalformedAgreement = errors.New("agreement must name its uploader, provider and file and have a duration and replication")
+	ErrAgreementSignature = errors.New("agreement is not signed by its provider")
+)
+
+// AgreementRecord - Structure describing a storage agreement recorded on the chain and when it runs
+type AgreementRecord struct {
+	Agreement
+	BlockIndex int64     `json:"blockIndex"` // Index of the block recording the agreement
+	Start      time.Time `json:"start"`      // Time the agreement started, which is the timestamp of its block
+	Expiry     time.Time `json:"expiry"`     // Time the provider's promise to store the file runs out
+}
+
+// Function that returns the Merkle root a block recording storage agreements records, calculated with the given
+// algorithm as it is recorded in the block
+func AgreementsRoot(algorithm verify.HashAlgorithm, agreements []Agreement) []byte {
+	return verify.AgreementsRoot(recordedAlgorithm(algorithm), agreements)
+}
+
+// Function that checks whether the storage agreements a block records (if any) may be recorded by it
+// Every agreement must be for a file committed earlier in the blockchain and not deleted since, and only the uploader
+// of the file can record agreements to store it. Each agreement must name the block's signer as its uploader and be
+// signed by its provider.
+func (blockchain *Blockchain) CheckAgreements(block *Block) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.checkAgreements(block)
+}
+
+// Function that checks whether the storage agreements a block records may be recorded by it while the lock is held
+func (blockchain *Blockchain) checkAgreements(block *Block) error {
+	if len(block.Agreements) == 0 {
+		return nil
+	}
+	uploader, err := UploaderPeerID(block.UploaderPublicKey)
+	if err != nil {
+		return ErrAgreementUploader
+	}
+	for _, agreement := range block.Agreements {
+		if agreement.Uploader == "" || agreement.Provider == "" || len(agreement.MerkleRoot) == 0 ||
+			agreement.Duration == 0 || agreement.Replication == 0 {
+			return ErrMalformedAgreement
+		}
+		if agreement.Uploader != uploader {
+			return ErrAgreementUploader
+		}
+		if !agreementSigned(agreement) {
+			return ErrAgreementSignature
+		}
+		fileBlock, err := blockchain.Store.GetByMerkleRoot(agreement.MerkleRoot)
+		if errors.Is(err, ErrBlockNotFound) || (err == nil && fileBlock.Index >= block.Index) {
+			return errors.New("agreement is not for a file committed earlier in the blockchain")
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(fileBlock.UploaderPublicKey, block.UploaderPublicKey) {
+			return ErrAgreementUploader
+		}
+		_, err = blockchain.Store.GetByMerkleRoot(TombstoneRoot(agreement.MerkleRoot))
+		if err == nil {
+			return errors.New("agreement is for a file that has been deleted")
+		}
+		if !errors.Is(err, ErrBlockNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that returns the peer ID of the node whose identity key has the given Ed25519 public key, which is the
+// peer ID agreements recorded in the node's blocks name as their uploader
+func UploaderPeerID(publicKey []byte) (string, error) {
+	key, err := crypto.UnmarshalEd25519PublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	peerID, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return peerID.String(), nil
+}
+
+// Function that checks whether an agreement's terms are signed by the identity key of the peer it names as provider
+func agreementSigned(agreement Agreement) bool {
+	provider, err := peer.Decode(agreement.Provider)
+	if err != nil {
+		return false
+	}
+	publicKey, err := provider.ExtractPublicKey()
+	if err != nil {
+		return false
+	}
+	valid, err := publicKey.Verify(verify.AgreementTerms(agreement), agreement.Signature)
+	return err == nil && valid
+}
+
+// Function that returns every storage agreement recorded for a file, oldest first
+func (blockchain *Blockchain) Agreements(merkleRoot []byte) ([]AgreementRecord, error) {
+	var records []AgreementRecord
+	err := blockchain.Iterate(0, -1, func(block *Block) error {
+		for _, agreement := range block.Agreements {
+			if bytes.Equal(agreement.MerkleRoot, merkleRoot) {
+				records = append(records, AgreementRecord{
+					Agreement:  agreement,
+					BlockIndex: block.Index,
+					Start:      block.Timestamp,
+					Expiry:     block.Timestamp.Add(time.Duration(agreement.Duration) * time.Second),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic bytes and version at the start of a chain archive
+const (
+	chainArchiveMagic   = "BCSCHAIN"
+	chainArchiveVersion = 1
+)
+
+// Errors returned when reading or importing a chain archive
+var (
+	ErrChainArchiveCorrupt  = errors.New("chain archive is corrupt")
+	ErrChainArchiveConflict = errors.New("chain archive conflicts with the local blockchain")
+)
+
+// Function that writes every block of the blockchain to a portable archive, returning how many blocks were written
+// The archive starts with magic bytes and a version byte, followed by each block in the canonical wire encoding prefixed
+// with its length as a uvarint. A zero length marks the end of the blocks, after which comes the number of blocks as a
+// uvarint and the SHA-256 of everything before it, so that a truncated or damaged archive is detected when it is read.
+// The blocks are written under the read lock, so the archive is a consistent copy of the chain.
+func (blockchain *Blockchain) WriteArchive(w io.Writer) (int, error) {
+	checksum := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(w, checksum))
+	writer.WriteString(chainArchiveMagic)
+	writer.WriteByte(chainArchiveVersion)
+
+	count := 0
+	err := blockchain.Iterate(0, -1, func(block *Block) error {
+		encoded, err := block.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		writer.Write(binary.AppendUvarint(nil, uint64(len(encoded))))
+		_, err = writer.Write(encoded)
+		count++
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	writer.Write(binary.AppendUvarint([]byte{0}, uint64(count)))
+	err = writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.Write(checksum.Sum(nil))
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Function that reads the blocks of a chain archive written by WriteArchive, oldest first
+// The checksum trailer is verified before any block is decoded, and every block must be in the canonical encoding
+func ReadArchive(r io.Reader) ([]*Block, error) {
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) < len(chainArchiveMagic)+1+sha256.Size ||
+		!bytes.HasPrefix(contents, []byte(chainArchiveMagic)) {
+		return nil, fmt.Errorf("%w: not a chain archive", ErrChainArchiveCorrupt)
+	}
+	if contents[len(chainArchiveMagic)] != chainArchiveVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrChainArchiveCorrupt, contents[len(chainArchiveMagic)])
+	}
+	body := contents[:len(contents)-sha256.Size]
+	checksum := sha256.Sum256(body)
+	if !bytes.Equal(checksum[:], contents[len(body):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrChainArchiveCorrupt)
+	}
+
+	blocks := []*Block{}
+	remaining := body[len(chainArchiveMagic)+1:]
+	for {
+		length, n := binary.Uvarint(remaining)
+		if n <= 0 || length > uint64(len(remaining)-n) {
+			return nil, ErrChainArchiveCorrupt
+		}
+		remaining = remaining[n:]
+		if length == 0 {
+			break
+		}
+		block := &Block{}
+		err := block.UnmarshalBinary(remaining[:length])
+		if err != nil {
+			return nil, fmt.Errorf("%w: block %d: %s", ErrChainArchiveCorrupt, len(blocks), err)
+		}
+		blocks = append(blocks, block)
+		remaining = remaining[length:]
+	}
+	count, n := binary.Uvarint(remaining)
+	if n <= 0 || n != len(remaining) || count != uint64(len(blocks)) {
+		return nil, fmt.Errorf("%w: expected %d blocks, found %d", ErrChainArchiveCorrupt, count, len(blocks))
+	}
+	return blocks, nil
+}
+
+// Function that adds the blocks of an archive to the blockchain, returning how many of them were new
+// Blocks the blockchain already holds must match the archive, so an archive can only extend the local chain. New blocks
+// are checked as AddValidBlock checks blocks received from other nodes, with their hash, link, proof of work and
+// signature verified and the rules on what they record enforced. Only blocks at or below the latest checkpoint are
+// imported without a signature, whether or not they are marked as pruned. The archive's checksum only detects damage,
+// so it is never trusted in place of these checks.
+func (blockchain *Blockchain) ImportBlocks(blocks []*Block, difficulty uint) (int, error) {
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+
+	added := 0
+	trusted := blockchain.latestCheckpoint()
+	prevBlock := blockchain.lastBlock()
+	for i, block := range blocks {
+		if block.Index != int64(i) {
+			return added, fmt.Errorf("%w: block %d has height %d", ErrChainArchiveCorrupt, i, block.Index)
+		}
+		if block.Index < int64(blockchain.length()) {
+			existing, err := blockchain.Store.GetByHeight(block.Index)
+			if err != nil {
+				return added, err
+			}
+			if !bytes.Equal(existing.Hash, block.Hash) {
+				return added, fmt.Errorf("%w: blocks at height %d differ", ErrChainArchiveConflict, block.Index)
+			}
+			continue
+		}
+		// The genesis block is not mined or signed, so only its hash is checked
+		if prevBlock == nil && !bytes.Equal(block.Hash, block.calculateHash()) {
+			return added, errors.New("genesis block does not match its hash")
+		}
+		if prevBlock != nil {
+			err := verify.Block(block.header(), prevBlock.header(), difficulty, trusted)
+			if err == nil && block.Size() > MaxBlockSize {
+				err = ErrBlockTooLarge
+			}
+			if err != nil {
+				return added, fmt.Errorf("block %d is not a valid extension of the blockchain: %w", block.Index, err)
+			}
+		}
+		err := blockchain.checkRules(block)
+		if err != nil {
+			return added, fmt.Errorf("block %d: %w", block.Index, err)
+		}
+		err = blockchain.addBlock(block)
+		if err != nil {
+			return added, err
+		}
+		prevBlock = block
+		added++
+	}
+	return added, nil
+}
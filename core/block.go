@@ -1,24 +1,35 @@
 package core
 
 import (
+	"blockchain-storage/verify"
 	"bytes"
 	"context"
-	"crypto/sha256"
+	"crypto/ed25519"
+	"encoding/json"
 	"errors"
 	"math"
 	"math/big"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
-// Initialise global big Integer variables
-var one *big.Int = big.NewInt(1)
-var maxHash *big.Int = new(big.Int) // Represents the max integer value a 256-bit hash can have
+// Difficulty (number of leading zero bits) that every mined block must satisfy
+// Every node on a network must use the same difficulty, so it is only lowered for local test networks
+var MiningDifficulty uint = 5
 
-func init() {
-	maxHash.Lsh(one, 256)     // Left shifts 1 256 times (1 followed by 256 zeroes)
-	maxHash.Sub(maxHash, one) // Subtracts 1 to just get 256 1's
-}
+// Consensus maximum size (in bytes) of an encoded block. Every node rejects larger blocks so that a single huge
+// upload cannot produce a block that other nodes refuse to relay or store
+const MaxBlockSize = 1024 * 1024
+
+// Maximum amount a received block's timestamp may be ahead of the local clock (after correcting for the sender's
+// clock offset) before the block is rejected
+const MaxBlockTimeDrift = 2 * time.Minute
+
+// Error returned when mining is aborted before a valid nonce is found
+var ErrMiningAborted = errors.New("mining aborted")
+
+// Error returned when a block is larger than the consensus maximum size
+var ErrBlockTooLarge = errors.New("block exceeds the maximum block size")
 
 // Structure of a single block in the blockchain
 
@@ -29,41 +40,205 @@ type Block struct {
 	PrevHash   []byte    `json:"prevHash"`   // Hash of the previous block in the blockchain
 	Hash       []byte    `json:"hash"`       // Hash of the current block
 	Nonce      int       `json:"nonce"`      // Nonce used for proof of work
+
+	UploaderPublicKey []byte `json:"uploaderPublicKey"` // Ed25519 public key of the node that uploaded the file
+	Signature         []byte `json:"signature"`         // Uploader's signature over the block hash
+
+	Pruned bool `json:"pruned,omitempty"` // Whether only the block's header is kept (see Header)
+
+	// Hash of the records dropped from a pruned block, which the block hash covers in their place (see Header)
+	RecordsHash []byte `json:"recordsHash,omitempty"`
+
+	// Algorithm of the block hash and of the Merkle root of its file (SHA-256 if empty)
+	HashAlgorithm verify.HashAlgorithm `json:"hashAlgorithm,omitempty"`
+	// Encoding the block's contents are hashed in (blocks created before the canonical encoding have version 0)
+	Version uint8 `json:"version,omitempty"`
+	// Wire encoded fields added by newer versions of the protocol, which are kept so that the block hash still matches
+	Extensions []byte `json:"extensions,omitempty"`
+	// Records of the files the block commits if it commits several, in which case its Merkle root is the root of the
+	// records (see verify.RecordsRoot) and the block itself carries no records
+	Files []FileRecord `json:"files,omitempty"`
+	// Records about the block's file, which only blocks of the wire hash version can carry
+	Records
+}
+
+// FileRecord - Record of one of the files a block committing several files commits
+type FileRecord = verify.FileRecord
+
+// Agreement - Record of a provider's promise to store a file for its uploader
+type Agreement = verify.Agreement
+
+// Records - Structure of the optional records a block carries about its file, which are covered by the block hash
+type Records struct {
+	Name        string `json:"name,omitempty"`        // Name claimed for the file in the name registry (empty if none)
+	PrevVersion []byte `json:"prevVersion,omitempty"` // Merkle root of the previous version of the file (empty if none)
+	Tombstone   []byte `json:"tombstone,omitempty"`   // Merkle root of the file the block deletes (empty if none)
+	// Storage agreements the block records, under their Merkle root (see AgreementsRoot)
+	Agreements []Agreement `json:"agreements,omitempty"`
+}
+
+// Function to convert a block into the header checked by the verify package
+func (block *Block) header() *verify.Header {
+	return &verify.Header{
+		Index:             block.Index,
+		Timestamp:         block.Timestamp,
+		MerkleRoot:        block.MerkelRoot,
+		PrevHash:          block.PrevHash,
+		Hash:              block.Hash,
+		Nonce:             block.Nonce,
+		UploaderPublicKey: block.UploaderPublicKey,
+		Signature:         block.Signature,
+		Pruned:            block.Pruned,
+		HashAlgorithm:     block.HashAlgorithm,
+		Version:           block.Version,
+		Extensions:        block.Extensions,
+		Name:              block.Name,
+		PrevVersion:       block.PrevVersion,
+		Tombstone:         block.Tombstone,
+		Files:             block.Files,
+		Agreements:        block.Agreements,
+		RecordsHash:       block.RecordsHash,
+	}
+}
+
+// Function that returns the records of every file the block commits
+// A block committing a single file records it in its own Merkle root and records
+func (block *Block) FileRecords() []FileRecord {
+	if len(block.Files) > 0 {
+		return block.Files
+	}
+	return []FileRecord{{MerkleRoot: block.MerkelRoot, Name: block.Name, PrevVersion: block.PrevVersion}}
+}
+
+// Function that returns the record of the file with a Merkle root that the block commits (false if it commits none)
+func (block *Block) Record(merkleRoot []byte) (FileRecord, bool) {
+	for _, file := range block.FileRecords() {
+		if bytes.Equal(file.MerkleRoot, merkleRoot) {
+			return file, true
+		}
+	}
+	return FileRecord{}, false
+}
+
+// Function that returns every Merkle root the block can be looked up by: its own and that of each file it commits
+func (block *Block) CommittedRoots() [][]byte {
+	roots := [][]byte{block.MerkelRoot}
+	for _, file := range block.Files {
+		roots = append(roots, file.MerkleRoot)
+	}
+	return roots
 }
 
 // Function to calculate the hash of a block
 func (block *Block) calculateHash() []byte {
-	// Convert index, timestamp, and nonce fields to a string, append together and join to contents
-	contents := []byte(strconv.FormatInt(block.Index, 10) + block.Timestamp.String() + string(rune(block.Nonce)))
-	// Add the other []byte arrays
-	contents = append(contents, block.MerkelRoot...)
-	contents = append(contents, block.PrevHash...)
-	hash := sha256.Sum256(contents)
-	// The hash returned is a 32-bit array so need to return a copy of it as a slice
-	return hash[:]
+	return verify.HeaderHash(block.header())
+}
+
+// Function to strip a block down to its header, which is kept in place of blocks deep enough in a pruned chain
+// The header holds the fields covered by the block hash, so the hash links and Merkle roots (and with them proofs of
+// inclusion) can still be checked. The uploader's signature is kept too, as a header above the latest checkpoint is
+// only trusted with it. Blocks of verify.HashVersionRecords only hash a hash of their records, so their header drops
+// the records and keeps that hash, while older blocks keep their records as their hash covers every one of them.
+func (block *Block) Header() *Block {
+	if block.Version >= verify.HashVersionRecords {
+		return &Block{
+			Index:             block.Index,
+			Timestamp:         block.Timestamp,
+			MerkelRoot:        block.MerkelRoot,
+			PrevHash:          block.PrevHash,
+			Hash:              block.Hash,
+			Nonce:             block.Nonce,
+			UploaderPublicKey: block.UploaderPublicKey,
+			Signature:         block.Signature,
+			Pruned:            true,
+			RecordsHash:       verify.RecordsHash(block.header()),
+			HashAlgorithm:     block.HashAlgorithm,
+			Version:           block.Version,
+		}
+	}
+	return &Block{
+		Index:             block.Index,
+		Timestamp:         block.Timestamp,
+		MerkelRoot:        block.MerkelRoot,
+		PrevHash:          block.PrevHash,
+		Hash:              block.Hash,
+		Nonce:             block.Nonce,
+		UploaderPublicKey: block.UploaderPublicKey,
+		Signature:         block.Signature,
+		Pruned:            true,
+		HashAlgorithm:     block.HashAlgorithm,
+		Version:           block.Version,
+		Extensions:        block.Extensions,
+		Files:             block.Files,
+		Records:           block.Records,
+	}
+}
+
+// Function to calculate the size of a block as it is encoded for storage and transfer between nodes
+func (block *Block) Size() int {
+	jsonBlock, err := json.Marshal(block)
+	if err != nil {
+		// A block that cannot be encoded can never be relayed so treat it as being too large
+		return math.MaxInt
+	}
+	return len(jsonBlock)
+}
+
+// Function that checks the parts of a block that do not depend on its previous block: its size, hash, proof of work and
+// the uploader's signature
+// A block arriving before its parent can only be checked this far, and is checked in full once the parent arrives
+func (block *Block) VerifyDetached(difficulty uint) error {
+	if block.Size() > MaxBlockSize {
+		return ErrBlockTooLarge
+	}
+	header := block.header()
+	if !header.HashAlgorithm.Available() {
+		return verify.ErrUnknownHash
+	}
+	if !bytes.Equal(block.Hash, verify.HeaderHash(header)) {
+		return verify.ErrHashMismatch
+	}
+	if !verify.ProofOfWork(block.Hash, difficulty) {
+		return verify.ErrInsufficientWork
+	}
+	if !verify.Signature(header) {
+		return verify.ErrBadSignature
+	}
+	return nil
 }
 
 // Function to check if a block is valid
 // Note that this does not work for the genesis block
 func (block *Block) isValid(prevBlock *Block, difficulty uint) bool {
-	// First check if block's hash is correct
-	if !bytes.Equal(block.Hash, block.calculateHash()) {
+	// Check the block is within the consensus size limit before doing any expensive checks
+	if block.Size() > MaxBlockSize {
 		return false
 	}
-	// Check prevHash correctly matches previous block
-	if !bytes.Equal(block.PrevHash, prevBlock.Hash) {
+	// Blocks received from other nodes always carry their signature and records, so a pruned block (or one keeping
+	// only the hash of its records) is never a valid extension
+	if block.Pruned || len(block.RecordsHash) > 0 {
 		return false
 	}
-	// Check previous index is one less than current index
-	if block.Index != prevBlock.Index+1 {
-		return false
-	}
-	// Check the proof of work is valid
-	target := new(big.Int).Rsh(maxHash, difficulty)
-	if new(big.Int).SetBytes(block.Hash).Cmp(target) > 0 {
-		return false
+	// Check the hash, link to the previous block, proof of work and the uploader's signature
+	return verify.Block(block.header(), prevBlock.header(), difficulty, -1) == nil
+}
+
+// Function to sign a mined block with the uploader's private key
+// The signature covers the block hash, which in turn covers the uploader's public key, so the block must be mined
+// before it is signed
+func (block *Block) Sign(privateKey ed25519.PrivateKey) error {
+	// The private key must belong to the uploader recorded in the block
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	if !bytes.Equal(publicKey, block.UploaderPublicKey) {
+		return errors.New("private key does not match the block's uploader public key")
 	}
-	return true
+	block.Signature = ed25519.Sign(privateKey, block.Hash)
+	return nil
+}
+
+// Function to verify the uploader's signature over the block hash
+func (block *Block) verifySignature() bool {
+	return verify.Signature(block.header())
 }
 
 // PowResult - Structure for holding the proof of work result found by a miner
@@ -72,14 +247,47 @@ type PowResult struct {
 	Hash  []byte
 }
 
+// MiningProgress - Structure describing how mining a block is going, which is reported periodically while mining
+type MiningProgress struct {
+	Attempts        uint64        // Number of hashes calculated so far, across all workers and retries
+	Elapsed         time.Duration // Time spent mining so far
+	Hashrate        float64       // Hashes per second across all workers over the last reporting interval
+	WorkerHashrates []float64     // Hashes per second of each worker over the last reporting interval
+}
+
+// Interval between reports of mining progress
+var MiningProgressInterval = time.Second
+
 // Function for handling asynchronous mining for proof of work
+// ctx - context that aborts mining and stops every worker when cancelled, in which case ErrMiningAborted is returned
 // difficulty - number of hex digits at the start of the hash that need to be zero
 // workers - number of asynchronous miner workers to use
 // retries - number of retries to attempt if the block is failed to be mined
-func (block *Block) Mine(difficulty uint, workers int, retries int) error {
+func (block *Block) Mine(ctx context.Context, difficulty uint, workers int, retries int) error {
+	return block.MineWithProgress(ctx, difficulty, workers, retries, nil)
+}
+
+// Function for handling asynchronous mining that calls progress every MiningProgressInterval until mining ends
+// progress is called from a separate goroutine but never after mining has returned, and a nil progress disables it
+func (block *Block) MineWithProgress(parent context.Context, difficulty uint, workers int, retries int, progress func(MiningProgress)) error {
+	// Every worker counts the hashes it calculates so that the hashrate can be reported while mining
+	hashCounts := make([]atomic.Uint64, workers)
+	if progress != nil {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			reportMiningProgress(hashCounts, progress, stop)
+			close(stopped)
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+
 	// Calculate that target that the hash needs to be smaller than or equal to based on the difficulty
 	// This involves right shifting the max hash value by the difficulty (equivalent to leading number of zeroes)
-	target := new(big.Int).Rsh(maxHash, difficulty)
+	target := verify.Target(difficulty)
 
 	attempts := 0
 	for attempts < retries {
@@ -87,13 +295,14 @@ func (block *Block) Mine(difficulty uint, workers int, retries int) error {
 		result := make(chan *PowResult)
 
 		// Create a cancellable context to signal to workers to end computation once a result has been found
-		ctx, cancel := context.WithCancel(context.Background())
+		// It is derived from the parent context so that cancelling the parent also stops all workers
+		ctx, cancel := context.WithCancel(parent)
 
 		// Start all workers and initialise a counter for how many have failed
 		failed := 0
 		failure := make(chan bool, workers)
 		for i := 0; i < workers; i++ {
-			go proofOfWorkMiner(ctx, target, i, workers, result, failure, *block)
+			go proofOfWorkMiner(ctx, target, i, workers, result, failure, &hashCounts[i], *block)
 		}
 
 		// Loop waiting for either a valid nonce to be found by any worker, or for all workers to fail
@@ -110,6 +319,10 @@ func (block *Block) Mine(difficulty uint, workers int, retries int) error {
 				return nil
 			case <-failure:
 				failed++
+			case <-parent.Done():
+				// Mining was aborted from outside so stop all workers without modifying the block
+				cancel()
+				return ErrMiningAborted
 			}
 		}
 		// This code block can only be reached if all the workers have failed
@@ -118,6 +331,7 @@ func (block *Block) Mine(difficulty uint, workers int, retries int) error {
 		cancel()
 		attempts++
 		block.Timestamp = time.Now()
+		minerLogger.Debug("no valid nonce found, retrying with a new timestamp", "block", block.Index, "attempt", attempts)
 	}
 
 	// All attempts have been used up, return an error
@@ -126,7 +340,8 @@ func (block *Block) Mine(difficulty uint, workers int, retries int) error {
 
 // Function for a single proof of work miner
 // The block is passed in via parameters as it is then pass by value (copied) and each worker gets its own copy
-func proofOfWorkMiner(ctx context.Context, target *big.Int, startNonce int, nonceIncrement int, result chan *PowResult, failure chan bool, block Block) {
+// The number of hashes calculated is added to attempts as the worker goes
+func proofOfWorkMiner(ctx context.Context, target *big.Int, startNonce int, nonceIncrement int, result chan *PowResult, failure chan bool, attempts *atomic.Uint64, block Block) {
 	// Set the starting nonce of the block and declare the integer representation of the hash
 	block.Nonce = startNonce
 	hashInt := new(big.Int)
@@ -141,13 +356,15 @@ func proofOfWorkMiner(ctx context.Context, target *big.Int, startNonce int, nonc
 			// Calculate the hash of the block and its integer representation
 			hash := block.calculateHash()
 			hashInt = hashInt.SetBytes(hash)
+			attempts.Add(1)
 
 			// Check if the hash is a valid solution (less than or equal to the target)
 			if hashInt.Cmp(target) <= 0 {
 				// If it is valid, send a result of both the nonce and the hash down the results channel
-				result <- &PowResult{
-					Nonce: block.Nonce,
-					Hash:  hash,
+				// If mining has already been cancelled nobody is listening, so return instead of blocking forever
+				select {
+				case result <- &PowResult{Nonce: block.Nonce, Hash: hash}:
+				case <-ctx.Done():
 				}
 				return
 			} else {
@@ -163,9 +380,52 @@ func proofOfWorkMiner(ctx context.Context, target *big.Int, startNonce int, nonc
 	}
 }
 
+// Function that reports the progress of mining every MiningProgressInterval until stop is closed
+func reportMiningProgress(attempts []atomic.Uint64, progress func(MiningProgress), stop <-chan struct{}) {
+	start := time.Now()
+	last := start
+	previous := make([]uint64, len(attempts))
+	ticker := time.NewTicker(MiningProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			interval := now.Sub(last).Seconds()
+			report := MiningProgress{Elapsed: now.Sub(start), WorkerHashrates: make([]float64, len(attempts))}
+			for i := range attempts {
+				current := attempts[i].Load()
+				report.Attempts += current
+				report.WorkerHashrates[i] = float64(current-previous[i]) / interval
+				report.Hashrate += report.WorkerHashrates[i]
+				previous[i] = current
+			}
+			last = now
+			progress(report)
+		}
+	}
+}
+
+// Function to create the genesis block of a new network
+// The genesis block is neither mined nor signed, so every node given the same timestamp creates the same block
+func NewGenesisBlock(timestamp time.Time) *Block {
+	block := &Block{
+		Index:      0,
+		Timestamp:  timestamp.UTC(),
+		MerkelRoot: []byte("genesis"),
+		PrevHash:   []byte{},
+		Version:    verify.CurrentHashVersion,
+	}
+	block.Hash = block.calculateHash()
+	return block
+}
+
 // Function to create a new block and return a pointer to it
-func CreateBlock(blockchain *Blockchain, merkelRoot []byte) *Block {
-	prevBlock := blockchain.Blocks[len(blockchain.Blocks)-1]
+// The uploader's public key is recorded in the block so that the signature can later be verified, as is the hash
+// algorithm the Merkle root was calculated with, which is also used for the block hash
+func CreateBlock(blockchain *Blockchain, merkelRoot []byte, records Records, uploaderPublicKey ed25519.PublicKey, algorithm verify.HashAlgorithm) *Block {
+	prevBlock := blockchain.LastBlock()
 	block := &Block{
 		Index:      prevBlock.Index + 1,
 		Timestamp:  time.Now(),
@@ -173,6 +433,31 @@ func CreateBlock(blockchain *Blockchain, merkelRoot []byte) *Block {
 		PrevHash:   prevBlock.Hash,
 		Hash:       nil,
 		Nonce:      0,
+
+		UploaderPublicKey: uploaderPublicKey,
+		HashAlgorithm:     recordedAlgorithm(algorithm),
+		Version:           verify.CurrentHashVersion,
+		Records:           records,
+	}
+	block.Hash = block.calculateHash()
+	return block
+}
+
+// Function to create a new block committing several files and return a pointer to it
+// The block's Merkle root is the root of the files' records, which must all have been calculated with the given hash
+// algorithm
+func CreateBatchBlock(blockchain *Blockchain, files []FileRecord, uploaderPublicKey ed25519.PublicKey, algorithm verify.HashAlgorithm) *Block {
+	prevBlock := blockchain.LastBlock()
+	block := &Block{
+		Index:      prevBlock.Index + 1,
+		Timestamp:  time.Now(),
+		MerkelRoot: verify.RecordsRoot(algorithm, files),
+		PrevHash:   prevBlock.Hash,
+
+		UploaderPublicKey: uploaderPublicKey,
+		HashAlgorithm:     recordedAlgorithm(algorithm),
+		Version:           verify.CurrentHashVersion,
+		Files:             files,
 	}
 	block.Hash = block.calculateHash()
 	return block
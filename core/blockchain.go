@@ -1,107 +1,516 @@
+// Package core implements the blocks, blockchain, Merkle trees and manifests of the blockchain storage network.
+// It is the only implementation of them, and the other packages build on its exported API.
 package core
 
 import (
+	"blockchain-storage/events"
+	"blockchain-storage/logging"
+	"blockchain-storage/verify"
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
+// Prefix of the footer line holding the checksum of a blockchain file
+const chainFileFooterPrefix = "sha256:"
+
+// Extension of the backup of the previous copy of a blockchain file
+const chainFileBackupExtension = ".bak"
+
+// Logger of the chain component
+var chainLogger = logging.Component(logging.Chain)
+
+// Error returned when a blockchain file is truncated or does not match its checksum
+var ErrChainFileCorrupt = errors.New("blockchain file is corrupt")
+
+// Error returned when a block commits a Merkle root already committed by another uploader
+var ErrRootOwned = errors.New("merkle root is already committed by another uploader")
+
 // Blockchain structure
+// The blocks themselves are held by a chain store, so the same blockchain logic works with any storage backend
 type Blockchain struct {
-	Blocks                []*Block `json:"blocks"`
-	BlocksMapByHash       map[string]*Block
-	BlocksMapByMerkelRoot map[string]*Block
+	Store ChainStore
+
+	// Number of most recent blocks kept in full, with only the headers of older blocks being kept (0 keeps every block)
+	// The headers of blocks of verify.HashVersionRecords drop their records, so names, tombstones and the files of
+	// blocks committing several files are only known (and enforced) for the blocks that are still kept in full
+	PruneDepth int
+
+	// Whether only the header of every block is kept, as light clients do, in which case PruneDepth is ignored
+	HeadersOnly bool
+
+	// Hashes that blocks at fixed heights must have
+	Checkpoints []Checkpoint
+
+	// Bus that a BlockAdded event is published on for every added block (nil publishes nothing)
+	Events *events.Bus
+
+	// The miner, sync handlers and API queries use the blockchain concurrently, so blocks are added under the write
+	// lock and read under the read lock. Exported methods take the lock, while unexported ones expect it to be held.
+	mutex sync.RWMutex
+}
+
+// Function to create a blockchain on top of a chain store
+func NewBlockchain(store ChainStore) *Blockchain {
+	return &Blockchain{Store: store, Checkpoints: DefaultCheckpoints}
 }
 
 // Function to add a new block to the blockchain (via pointer)
-func (blockchain *Blockchain) AddBlock(block *Block) {
-	// Add the block pointer to the list
-	blockchain.Blocks = append(blockchain.Blocks, block)
-	// Add the block pointer to a hashmap between hash of blocks and block pointers
-	blockchain.BlocksMapByHash[hex.EncodeToString(block.Hash)] = block
-	// Add the block pointer to a hashmap between merkel root of blocks and block pointers
-	blockchain.BlocksMapByMerkelRoot[hex.EncodeToString(block.MerkelRoot)] = block
+// Blocks that conflict with a checkpoint are refused. In a pruned blockchain the block that falls beyond the prune
+// depth is reduced to its header, and a blockchain keeping only headers keeps just the block's header.
+func (blockchain *Blockchain) AddBlock(block *Block) error {
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+	return blockchain.addBlock(block)
+}
+
+// Function to add a new block to the blockchain while the write lock is held
+func (blockchain *Blockchain) addBlock(block *Block) error {
+	err := blockchain.checkCheckpoints(block)
+	if err != nil {
+		chainLogger.Warn("refused block conflicting with a checkpoint", "block", block.Index, "error", err)
+		return err
+	}
+	if blockchain.HeadersOnly && !block.Pruned {
+		block = block.Header()
+	}
+	err = blockchain.Store.PutBlock(block)
+	if err != nil {
+		return err
+	}
+	blockchain.Events.Publish(events.Event{Type: events.BlockAdded, Height: block.Index, Hash: block.Hash,
+		MerkleRoot: block.MerkelRoot})
+	if blockchain.PruneDepth <= 0 {
+		return nil
+	}
+	return blockchain.pruneBlock(block.Index - int64(blockchain.PruneDepth))
+}
+
+// Function to reduce every block beyond the prune depth (or every block, if only headers are kept) to its header,
+// returning how many blocks were pruned
+// This is needed when a blockchain that was kept in full is first pruned, after which AddBlock keeps it pruned
+func (blockchain *Blockchain) Prune() (int, error) {
+	if blockchain.PruneDepth <= 0 && !blockchain.HeadersOnly {
+		return 0, nil
+	}
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+	pruneBelow := int64(blockchain.length() - blockchain.PruneDepth)
+	if blockchain.HeadersOnly {
+		pruneBelow = int64(blockchain.length())
+	}
+	var heights []int64
+	err := blockchain.Store.Iterate(func(block *Block) error {
+		if !block.Pruned && block.Index < pruneBelow {
+			heights = append(heights, block.Index)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, height := range heights {
+		err := blockchain.pruneBlock(height)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(heights) > 0 {
+		chainLogger.Info("pruned blocks beyond the prune depth", "blocks", len(heights), "pruneDepth", blockchain.PruneDepth)
+	}
+	return len(heights), nil
+}
+
+// Function to replace the block at a height with its header
+func (blockchain *Blockchain) pruneBlock(height int64) error {
+	if height < 0 {
+		return nil
+	}
+	block, err := blockchain.Store.GetByHeight(height)
+	if err != nil || block.Pruned {
+		return err
+	}
+	return blockchain.Store.PutBlock(block.Header())
 }
 
-// Function to retrieve a pointer to the last block of the Blockchain
+// Function to add a block received from another node, only if it is a valid extension of the current tip
+// A block claiming a name it may not claim is refused, so that no node lets a name be taken from its owner, as is a
+// block referencing a previous version or deleting a file that is not an earlier file of the same uploader
+// The checks and the addition happen under one lock, so two blocks received at once cannot both extend the same tip
+func (blockchain *Blockchain) AddValidBlock(block *Block, difficulty uint) error {
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+
+	lastBlock := blockchain.lastBlock()
+	if lastBlock == nil || !block.isValid(lastBlock, difficulty) {
+		return errors.New("block is not a valid extension of the blockchain")
+	}
+	err := blockchain.checkRules(block)
+	if err != nil {
+		return err
+	}
+	return blockchain.addBlock(block)
+}
+
+// Function that checks whether a block follows the rules on what it may record while the lock is held
+func (blockchain *Blockchain) checkRules(block *Block) error {
+	err := blockchain.checkRootOwner(block)
+	if err != nil {
+		return err
+	}
+	err = blockchain.checkNameClaim(block)
+	if err != nil {
+		return err
+	}
+	err = blockchain.checkPrevVersion(block)
+	if err != nil {
+		return err
+	}
+	err = blockchain.checkTombstone(block)
+	if err != nil {
+		return err
+	}
+	return blockchain.checkAgreements(block)
+}
+
+// Function that checks that no Merkle root a block commits was committed earlier by another uploader
+// The chain stores look a root up by the latest block committing it, so refusing re-commits by other uploaders keeps
+// that block's uploader the file's owner, who alone can delete it or grant access to it
+func (blockchain *Blockchain) checkRootOwner(block *Block) error {
+	for _, root := range block.CommittedRoots() {
+		committed, err := blockchain.Store.GetByMerkleRoot(root)
+		if errors.Is(err, ErrBlockNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if committed.Index < block.Index && !bytes.Equal(committed.UploaderPublicKey, block.UploaderPublicKey) {
+			return ErrRootOwned
+		}
+	}
+	return nil
+}
+
+// Function to retrieve a pointer to the last block of the Blockchain (nil if the blockchain is empty)
 func (blockchain *Blockchain) LastBlock() *Block {
-	return blockchain.Blocks[len(blockchain.Blocks)-1]
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.lastBlock()
+}
+
+// Function to retrieve the last block while the lock is held
+func (blockchain *Blockchain) lastBlock() *Block {
+	block, err := blockchain.Store.Head()
+	if err != nil {
+		return nil
+	}
+	return block
 }
 
 // Function to retrieve the length of the blockchain
 func (blockchain *Blockchain) Length() int {
-	return len(blockchain.Blocks)
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.length()
+}
+
+// Function to retrieve the length of the blockchain while the lock is held
+func (blockchain *Blockchain) length() int {
+	lastBlock := blockchain.lastBlock()
+	if lastBlock == nil {
+		return 0
+	}
+	return int(lastBlock.Index) + 1
 }
 
 // Function to retrieve a pointer to a block according to its hash
 func (blockchain *Blockchain) GetBlockByHash(hash []byte) (*Block, error) {
-	block, found := blockchain.BlocksMapByHash[hex.EncodeToString(hash)]
-	if !found {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	block, err := blockchain.Store.GetByHash(hash)
+	if errors.Is(err, ErrBlockNotFound) {
 		return nil, errors.New("no block with matching hash in the blockchain")
 	}
-	return block, nil
+	return block, err
+}
+
+// Function that returns the public key of the uploader of a committed file
+// Blocks re-committing a root another uploader committed are refused (see checkRootOwner), so whichever block the
+// root is looked up by was uploaded by the uploader of the first block committing it
+func (blockchain *Blockchain) FileUploader(merkleRoot []byte) ([]byte, error) {
+	block, err := blockchain.GetBlockByMerkelRoot(merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	return block.UploaderPublicKey, nil
 }
 
 // Function to retrieve a pointer to a block according to the merkel root
 func (blockchain *Blockchain) GetBlockByMerkelRoot(merkelRoot []byte) (*Block, error) {
-	block, found := blockchain.BlocksMapByMerkelRoot[hex.EncodeToString(merkelRoot)]
-	if !found {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	block, err := blockchain.Store.GetByMerkleRoot(merkelRoot)
+	if errors.Is(err, ErrBlockNotFound) {
 		return nil, errors.New("no block with matching merkel root in the blockchain")
 	}
-	return block, nil
+	return block, err
 }
 
-// Function to validate the entire blockchain (works with blockchains length >= 1)
-func (blockchain *Blockchain) validateChain() bool {
-	for i := 1; i < len(blockchain.Blocks); i++ {
-		if !bytes.Equal(blockchain.Blocks[i].PrevHash, blockchain.Blocks[i-1].Hash) {
-			return false
+// Function to retrieve a pointer to a block according to its height
+func (blockchain *Blockchain) GetBlockByHeight(height int64) (*Block, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.Store.GetByHeight(height)
+}
+
+// Function to retrieve every block of the blockchain in order
+func (blockchain *Blockchain) Blocks() ([]*Block, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	blocks := []*Block{}
+	err := blockchain.Store.Iterate(func(block *Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	return blocks, err
+}
+
+// Function that calls fn on every block from height from up to and including height to, in height order, stopping at
+// the first error (a negative to iterates up to the tip)
+// The blockchain is read locked throughout, so blocks cannot be added part way through but fn must not add any itself
+func (blockchain *Blockchain) Iterate(from int64, to int64, fn func(block *Block) error) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	tip := int64(blockchain.length()) - 1
+	if to < 0 || to > tip {
+		to = tip
+	}
+	for height := max(from, 0); height <= to; height++ {
+		block, err := blockchain.Store.GetByHeight(height)
+		if err != nil {
+			return err
+		}
+		err = fn(block)
+		if err != nil {
+			return err
 		}
 	}
-	return true
+	return nil
 }
 
-// Function to write the entire blockchain to a file for persistence
+// Function to retrieve every block added after the block with the given hash, oldest first
+// This is what a peer whose tip is the given block is missing, so it is empty if the block is the tip
+func (blockchain *Blockchain) BlocksSince(hash []byte) ([]*Block, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	since, err := blockchain.Store.GetByHash(hash)
+	if errors.Is(err, ErrBlockNotFound) {
+		return nil, errors.New("no block with matching hash in the blockchain")
+	}
+	if err != nil {
+		return nil, err
+	}
+	blocks := []*Block{}
+	for height := since.Index + 1; height < int64(blockchain.length()); height++ {
+		block, err := blockchain.Store.GetByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Error used to stop iterating once an invalid block is found
+var errInvalidChain = errors.New("invalid chain")
+
+// Function to validate the entire blockchain (works with blockchains length >= 1)
+func (blockchain *Blockchain) validateChain(difficulty uint) bool {
+	return blockchain.ValidLength(difficulty) == blockchain.Length()
+}
+
+// Function that counts how many blocks from the start of the chain are valid, stopping at the first invalid block
+// Blocks above the latest checkpoint are checked as blocks received from other nodes are, with their hash, link, proof
+// of work and signature verified, and the genesis block is checked against its hash as it is neither mined nor signed.
+// Blocks up to the latest checkpoint are already trusted, so for those only the links and checkpointed hashes are
+// checked. Being marked as pruned excuses a block from none of these checks.
+func (blockchain *Blockchain) ValidLength(difficulty uint) int {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	latestCheckpoint := blockchain.latestCheckpoint()
+	checkpoints := make(map[int64][]byte, len(blockchain.Checkpoints))
+	for _, checkpoint := range blockchain.Checkpoints {
+		checkpoints[checkpoint.Height] = checkpoint.Hash
+	}
+
+	valid := 0
+	var prevBlock *Block
+	blockchain.Store.Iterate(func(block *Block) error {
+		if hash, found := checkpoints[block.Index]; found && !bytes.Equal(hash, block.Hash) {
+			return errInvalidChain
+		}
+		switch {
+		case block.Index <= latestCheckpoint:
+			if prevBlock != nil && !bytes.Equal(block.PrevHash, prevBlock.Hash) {
+				return errInvalidChain
+			}
+		case prevBlock == nil:
+			if !bytes.Equal(block.Hash, block.calculateHash()) {
+				return errInvalidChain
+			}
+		default:
+			if verify.Block(block.header(), prevBlock.header(), difficulty, latestCheckpoint) != nil {
+				return errInvalidChain
+			}
+		}
+		prevBlock = block
+		valid++
+		return nil
+	})
+	return valid
+}
+
+// Function to write the entire blockchain to a JSON file, which is used as an export format
 func (blockchain *Blockchain) WriteToFile(filepath string) error {
-	// Convert blockchain (list of blocks only) to JSON
-	// The maps are not saved as this is simply duplicating data
-	jsonBlockchain, err := json.MarshalIndent(blockchain.Blocks, "", "  ")
+	blocks, err := blockchain.Blocks()
+	if err != nil {
+		return err
+	}
+	return WriteBlocksToFile(blocks, filepath)
+}
+
+// Function to write a list of blocks to a JSON file
+// The file is written in full to a temporary file which then atomically replaces the old one, so a crash mid-write can
+// never leave a half-written chain behind. The previous copy is kept as a backup, and a checksum footer lets a
+// truncated or otherwise damaged file be detected when it is read back.
+func WriteBlocksToFile(blocks []*Block, filepath string) error {
+	// Convert the list of blocks to JSON
+	jsonBlockchain, err := json.MarshalIndent(blocks, "", "  ")
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(jsonBlockchain)
+	contents := append(jsonBlockchain, []byte("\n"+chainFileFooterPrefix+hex.EncodeToString(checksum[:])+"\n")...)
+	return writeFileAtomic(filepath, contents)
+}
+
+// Function that writes a file by writing and syncing a temporary file and then renaming it over the target
+// Any existing file at the target is first moved to the backup path
+func writeFileAtomic(path string, contents []byte) error {
+	dir := filepath.Dir(path)
+	tempFile, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	defer os.Remove(tempPath)
+
+	_, err = tempFile.Write(contents)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 	// File permissions 0644 means read and write for file owner, but read-only for group and others
-	return os.WriteFile(filepath, jsonBlockchain, 0644)
+	err = os.Chmod(tempPath, 0644)
+	if err != nil {
+		return err
+	}
+
+	// Only a copy that is still readable is worth keeping as a backup
+	if _, err := readBlocksFile(path); err == nil {
+		err = os.Rename(path, path+chainFileBackupExtension)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(tempPath, path)
+	if err != nil {
+		return err
+	}
+
+	// Sync the directory so that the renames themselves survive a crash
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	dirFile.Sync()
+	return nil
 }
 
-// Function to read the blockchain from a JSON file and load into memory
-func BlockchainFromFile(filepath string) (*Blockchain, error) {
+// Function to read the blocks saved in a JSON file
+// If the file is missing, truncated or fails its checksum, the backup of the previous copy is read instead
+func BlocksFromFile(filepath string) ([]*Block, error) {
+	blocks, err := readBlocksFile(filepath)
+	if err == nil {
+		return blocks, nil
+	}
+	backupBlocks, backupErr := readBlocksFile(filepath + chainFileBackupExtension)
+	if backupErr == nil {
+		chainLogger.Warn("blockchain file could not be read, falling back to its last good copy", "file", filepath,
+			"error", err)
+		return backupBlocks, nil
+	}
+	return nil, err
+}
+
+// Function that reads and verifies a single JSON blockchain file
+// Files written before the checksum footer was introduced are accepted as long as they are valid JSON
+func readBlocksFile(path string) ([]*Block, error) {
 	// Read the json file
-	jsonBlockchain, err := os.ReadFile(filepath)
+	contents, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
+	jsonBlockchain := contents
+	trimmed := bytes.TrimSuffix(contents, []byte("\n"))
+	footerStart := bytes.LastIndexByte(trimmed, '\n') + 1
+	if bytes.HasPrefix(trimmed[footerStart:], []byte(chainFileFooterPrefix)) {
+		if footerStart == 0 {
+			return nil, ErrChainFileCorrupt
+		}
+		jsonBlockchain = trimmed[:footerStart-1]
+		checksum := sha256.Sum256(jsonBlockchain)
+		if string(trimmed[footerStart+len(chainFileFooterPrefix):]) != hex.EncodeToString(checksum[:]) {
+			return nil, ErrChainFileCorrupt
+		}
+	}
+
 	// Convert the json byte data into structs
 	var blocks []*Block
 	err = json.Unmarshal(jsonBlockchain, &blocks)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %s", ErrChainFileCorrupt, err)
 	}
+	return blocks, nil
+}
 
-	// Create blockchain structure
-	blockchain := &Blockchain{
-		Blocks:                blocks,
-		BlocksMapByHash:       make(map[string]*Block),
-		BlocksMapByMerkelRoot: make(map[string]*Block),
+// Function to read the blockchain from a JSON file and load into an in-memory chain store
+func BlockchainFromFile(filepath string) (*Blockchain, error) {
+	blocks, err := BlocksFromFile(filepath)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create the mappings that were not saved
+	blockchain := NewBlockchain(NewMemoryChainStore())
 	for _, block := range blocks {
-		blockchain.BlocksMapByHash[hex.EncodeToString(block.Hash)] = block
-		blockchain.BlocksMapByMerkelRoot[hex.EncodeToString(block.MerkelRoot)] = block
+		err := blockchain.AddBlock(block)
+		if err != nil {
+			return nil, err
+		}
 	}
-
 	return blockchain, nil
 }
@@ -1,13 +1,22 @@
 package core
 
 import (
-	"blockchain-storage"
+	"blockchain-storage/verify"
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"math/big"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,11 +42,11 @@ func TestBlock_calculateHash(t *testing.T) {
 func TestBlock_mine(t *testing.T) {
 	block := &Block{Index: 1, Timestamp: time.Now(), MerkelRoot: []byte("merkel"), PrevHash: []byte("prevhash")}
 	difficulty := uint(12)
-	if block.mine(difficulty, 2, 1) != nil {
+	if block.Mine(context.Background(), difficulty, 2, 1) != nil {
 		t.Errorf("FAIL: Mining failed")
 	}
 
-	target := new(big.Int).Rsh(maxHash, difficulty)
+	target := verify.Target(difficulty)
 	hashInt := new(big.Int).SetBytes(block.Hash)
 
 	if hashInt.Cmp(target) > 0 {
@@ -45,14 +54,122 @@ func TestBlock_mine(t *testing.T) {
 	}
 }
 
+// Tests that mining can be aborted through its context
+func TestBlock_Mine_Cancelled(t *testing.T) {
+	block := &Block{Index: 1, Timestamp: time.Now(), MerkelRoot: []byte("merkel"), PrevHash: []byte("prevhash")}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The difficulty is high enough that the block cannot be mined before the cancellation is noticed
+	if block.Mine(ctx, 200, 2, 1) != ErrMiningAborted {
+		t.Errorf("FAIL: Mine() did not report that mining was aborted")
+	}
+}
+
+// Tests that mining reports its progress while it runs and stops reporting once it returns
+func TestBlock_MineWithProgress(t *testing.T) {
+	defer func(interval time.Duration) { MiningProgressInterval = interval }(MiningProgressInterval)
+	MiningProgressInterval = 10 * time.Millisecond
+
+	block := &Block{Index: 1, Timestamp: time.Now(), MerkelRoot: []byte("merkel"), PrevHash: []byte("prevhash")}
+	ctx, cancel := context.WithCancel(context.Background())
+	reports := make(chan MiningProgress, 100)
+	var returned atomic.Bool
+	progress := func(report MiningProgress) {
+		if returned.Load() {
+			t.Errorf("FAIL: Progress was reported after mining returned")
+		}
+		select {
+		case reports <- report:
+		default:
+		}
+		// Stop mining once a couple of reports have come in
+		if report.Attempts > 0 && len(reports) >= 2 {
+			cancel()
+		}
+	}
+	if block.MineWithProgress(ctx, 200, 3, 1, progress) != ErrMiningAborted {
+		t.Fatalf("FAIL: MineWithProgress() did not report that mining was aborted")
+	}
+	returned.Store(true)
+
+	first, second := <-reports, <-reports
+	if len(second.WorkerHashrates) != 3 {
+		t.Errorf("FAIL: Expected a hashrate for each of 3 workers, got %d", len(second.WorkerHashrates))
+	}
+	if second.Attempts < first.Attempts || second.Elapsed <= first.Elapsed {
+		t.Errorf("FAIL: Progress went backwards from %+v to %+v", first, second)
+	}
+	if second.Attempts == 0 || second.Hashrate <= 0 {
+		t.Errorf("FAIL: Expected hashes to have been calculated, got %+v", second)
+	}
+}
+
+// Tests that mining is pre-empted and rebased when a competing block arrives at the same height
+func TestMineOnTip_Preemption(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("genesis_hash")})
+
+	newTips := make(chan *Block)
+	type mineResult struct {
+		block *Block
+		err   error
+	}
+	results := make(chan mineResult, 1)
+	go func() {
+		block, err := MineOnTip(context.Background(), blockchain, []byte("merkel"), Records{}, verify.SHA256, privateKey, 18, 2, 1, newTips, nil)
+		results <- mineResult{block, err}
+	}()
+
+	// Sending a stale tip first guarantees the miner has started on height 1 before the chain is modified
+	newTips <- blockchain.LastBlock()
+
+	// A competing block at height 1 is received and added by the network layer, then announced to the miner
+	competing := &Block{Index: 1, Hash: []byte("competing_hash"), MerkelRoot: []byte("competing_merkel")}
+	blockchain.AddBlock(competing)
+	newTips <- competing
+
+	result := <-results
+	block, err := result.block, result.err
+	if err != nil {
+		t.Fatalf("MineOnTip() failed with error: %v", err)
+	}
+	if block.Index != 2 || !bytes.Equal(block.PrevHash, competing.Hash) {
+		t.Errorf("FAIL: Mined block was not rebased on top of the competing block")
+	}
+	if !block.verifySignature() {
+		t.Errorf("FAIL: Mined block was not signed")
+	}
+
+	// A block larger than the maximum block size must be refused before any mining takes place
+	_, err = MineOnTip(context.Background(), blockchain, make([]byte, MaxBlockSize), Records{}, verify.SHA256, privateKey, 18, 2, 1, nil, nil)
+	if err != ErrBlockTooLarge {
+		t.Errorf("FAIL: MineOnTip() did not refuse to mine an oversized block")
+	}
+	// Nor may a block that only fits the maximum block size until it is signed
+	root := make([]byte, MaxBlockSize*3/4)
+	for CreateBlock(blockchain, root, Records{}, privateKey.Public().(ed25519.PublicKey), verify.SHA256).Size() > MaxBlockSize {
+		root = root[:len(root)-3]
+	}
+	_, err = MineOnTip(context.Background(), blockchain, root, Records{}, verify.SHA256, privateKey, 4, 2, 1, nil, nil)
+	if err != ErrBlockTooLarge {
+		t.Errorf("FAIL: MineOnTip() did not refuse a block that is too large once signed, got %v", err)
+	}
+}
+
 // Tests the block validation logic
 func TestBlock_isValid(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
 	prevBlock := &Block{Index: 0, Hash: []byte("genesis_hash")}
-	block := &Block{Index: 1, Timestamp: time.Now(), MerkelRoot: []byte("new root"), PrevHash: prevBlock.Hash}
+	block := &Block{Index: 1, Timestamp: time.Now(), MerkelRoot: []byte("new root"), PrevHash: prevBlock.Hash, UploaderPublicKey: publicKey}
 	difficulty := uint(10)
-	if block.mine(difficulty, 2, 1) != nil {
+	if block.Mine(context.Background(), difficulty, 2, 1) != nil {
 		t.Errorf("FAIL: Mining failed")
 	}
+	if block.Sign(privateKey) != nil {
+		t.Errorf("FAIL: Signing failed")
+	}
 
 	// Test a valid block
 	if !block.isValid(prevBlock, difficulty) {
@@ -67,6 +184,21 @@ func TestBlock_isValid(t *testing.T) {
 	}
 	block.MerkelRoot = originalMerkelRoot
 
+	// Test invalid signature
+	originalSignature := block.Signature
+	block.Signature = ed25519.Sign(privateKey, []byte("something else"))
+	if block.isValid(prevBlock, difficulty) {
+		t.Errorf("FAIL: isValid() returned true for a block with an invalid signature")
+	}
+	block.Signature = originalSignature
+
+	// Test oversized block
+	block.MerkelRoot = make([]byte, MaxBlockSize)
+	if block.isValid(prevBlock, difficulty) {
+		t.Errorf("FAIL: isValid() returned true for a block larger than the maximum block size")
+	}
+	block.MerkelRoot = originalMerkelRoot
+
 	// Test invalid index
 	block.Index = 99
 	if block.isValid(prevBlock, difficulty) {
@@ -74,13 +206,29 @@ func TestBlock_isValid(t *testing.T) {
 	}
 }
 
+// Tests that a block can only be signed by the key of its recorded uploader
+func TestBlock_Sign(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	block := &Block{Hash: []byte("hash"), UploaderPublicKey: publicKey}
+
+	if block.Sign(otherPrivateKey) == nil {
+		t.Errorf("FAIL: Sign() accepted a private key that does not match the uploader public key")
+	}
+	if block.Sign(privateKey) != nil || !block.verifySignature() {
+		t.Errorf("FAIL: A block signed by its uploader failed signature verification")
+	}
+}
+
 // Tests the creation of a new block
 func Test_createBlock(t *testing.T) {
 	genesis := &Block{Index: 0, Hash: []byte("genesis_hash")}
-	bc := &Blockchain{Blocks: []*Block{genesis}}
+	bc := NewBlockchain(NewMemoryChainStore())
+	bc.AddBlock(genesis)
 
 	merkelRoot := []byte("new_merkel_root")
-	newBlock := createBlock(bc, merkelRoot)
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	newBlock := CreateBlock(bc, merkelRoot, Records{}, publicKey, verify.SHA256)
 
 	if newBlock.Index != genesis.Index+1 {
 		t.Errorf("FAIL: Expected index %d, got %d", genesis.Index+1, newBlock.Index)
@@ -88,26 +236,25 @@ func Test_createBlock(t *testing.T) {
 	if !bytes.Equal(newBlock.PrevHash, genesis.Hash) {
 		t.Errorf("FAIL: PrevHash was not set correctly")
 	}
+	if !bytes.Equal(newBlock.UploaderPublicKey, publicKey) {
+		t.Errorf("FAIL: UploaderPublicKey was not set correctly")
+	}
 }
 
 // Tests adding a block and verifies blockchain state
 func TestBlockchain_addBlock(t *testing.T) {
-	blockchain := &Blockchain{
-		Blocks:                []*Block{},
-		BlocksMapByHash:       make(map[string]*Block),
-		BlocksMapByMerkelRoot: make(map[string]*Block),
-	}
+	blockchain := NewBlockchain(NewMemoryChainStore())
 
 	genesis := &Block{Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis_merkel")}
-	blockchain.addBlock(genesis)
+	blockchain.AddBlock(genesis)
 
-	newBlock := &Block{Hash: []byte("new_hash"), MerkelRoot: []byte("new_merkel")}
-	blockchain.addBlock(newBlock)
+	newBlock := &Block{Index: 1, Hash: []byte("new_hash"), MerkelRoot: []byte("new_merkel")}
+	blockchain.AddBlock(newBlock)
 
-	if blockchain.length() != 2 {
+	if blockchain.Length() != 2 {
 		t.Errorf("FAIL: addBlock did not result in the correct blockchain length")
 	}
-	if !bytes.Equal(blockchain.lastBlock().Hash, newBlock.Hash) {
+	if !bytes.Equal(blockchain.LastBlock().Hash, newBlock.Hash) {
 		t.Errorf("FAIL: lastBlock is not the newly added block")
 	}
 }
@@ -115,50 +262,243 @@ func TestBlockchain_addBlock(t *testing.T) {
 // Tests retrieving blocks by hash and merkel root
 func TestBlockchain_Getters(t *testing.T) {
 	block1 := &Block{Hash: []byte("hash1"), MerkelRoot: []byte("merkel1")}
-	blockchain := &Blockchain{
-		Blocks:                []*Block{},
-		BlocksMapByHash:       make(map[string]*Block),
-		BlocksMapByMerkelRoot: make(map[string]*Block),
-	}
-	blockchain.addBlock(block1)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(block1)
 
 	// Test successful get
-	foundBlock, err := blockchain.getBlockByHash([]byte("hash1"))
+	foundBlock, err := blockchain.GetBlockByHash([]byte("hash1"))
 	if err != nil || !bytes.Equal(foundBlock.Hash, block1.Hash) {
 		t.Errorf("FAIL: getBlockByHash failed to retrieve correct block")
 	}
 
 	// Test non-existent hash
-	_, err = blockchain.getBlockByHash([]byte("non_existent_hash"))
+	_, err = blockchain.GetBlockByHash([]byte("non_existent_hash"))
 	if err == nil {
 		t.Errorf("FAIL: getBlockByHash should have returned an error for non-existent hash")
 	}
 }
 
+// Tests iterating over a range of heights and listing the blocks added after a given block
+func TestBlockchain_IterateBlocksSince(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	for i := 0; i < 5; i++ {
+		blockchain.AddBlock(&Block{Index: int64(i), Hash: []byte(fmt.Sprintf("hash%d", i)),
+			MerkelRoot: []byte(fmt.Sprintf("merkel%d", i))})
+	}
+
+	var heights []int64
+	blockchain.Iterate(1, 3, func(block *Block) error {
+		heights = append(heights, block.Index)
+		return nil
+	})
+	if fmt.Sprint(heights) != "[1 2 3]" {
+		t.Errorf("FAIL: Expected heights [1 2 3] from Iterate(1, 3), got %v", heights)
+	}
+	heights = nil
+	blockchain.Iterate(3, -1, func(block *Block) error {
+		heights = append(heights, block.Index)
+		return nil
+	})
+	if fmt.Sprint(heights) != "[3 4]" {
+		t.Errorf("FAIL: Expected heights [3 4] when iterating up to the tip, got %v", heights)
+	}
+
+	blocks, err := blockchain.BlocksSince([]byte("hash2"))
+	if err != nil || len(blocks) != 2 || blocks[0].Index != 3 || blocks[1].Index != 4 {
+		t.Errorf("FAIL: Expected blocks 3 and 4 after block 2, got %v (%v)", blocks, err)
+	}
+	if blocks, err := blockchain.BlocksSince([]byte("hash4")); err != nil || len(blocks) != 0 {
+		t.Errorf("FAIL: Expected no blocks after the tip, got %v (%v)", blocks, err)
+	}
+	if _, err := blockchain.BlocksSince([]byte("unknown")); err == nil {
+		t.Errorf("FAIL: BlocksSince() succeeded for a block not in the blockchain")
+	}
+}
+
+// Tests that blocks can be added while other goroutines read the blockchain (run with -race to check the locking)
+func TestBlockchain_Concurrent(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(&Block{Hash: []byte("hash0"), MerkelRoot: []byte("merkel0")})
+
+	const blocks = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i < blocks; i++ {
+			blockchain.AddBlock(&Block{Index: int64(i), Hash: []byte(fmt.Sprintf("hash%d", i)),
+				MerkelRoot: []byte(fmt.Sprintf("merkel%d", i))})
+		}
+	}()
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < blocks; i++ {
+				blockchain.GetBlockByHash([]byte(fmt.Sprintf("hash%d", i)))
+				if lastBlock := blockchain.LastBlock(); lastBlock == nil || blockchain.Length() < int(lastBlock.Index)+1 {
+					t.Errorf("FAIL: Length() fell behind the last block read before it")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if blockchain.Length() != blocks {
+		t.Errorf("FAIL: Expected %d blocks after adding them concurrently with reads, got %d", blocks, blockchain.Length())
+	}
+	if _, err := blockchain.GetBlockByHash([]byte(fmt.Sprintf("hash%d", blocks-1))); err != nil {
+		t.Errorf("FAIL: Last added block could not be retrieved by hash: %v", err)
+	}
+}
+
+// Function that mines a chain of the given number of blocks after a genesis block at difficulty 4, returning the chain
+// along with its blocks
+func mineTestChain(t *testing.T, blocks int, privateKey ed25519.PrivateKey) (*Blockchain, []*Block) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	genesis := NewGenesisBlock(time.Unix(1700000000, 0))
+	blockchain.AddBlock(genesis)
+	mined := []*Block{genesis}
+	for i := 1; i <= blocks; i++ {
+		block, err := MineOnTip(context.Background(), blockchain, []byte{byte(i)}, Records{}, verify.SHA256, privateKey, 4,
+			2, 1, nil, nil)
+		if err != nil || blockchain.AddValidBlock(block, 4) != nil {
+			t.Fatalf("FAIL: Failed to add block %d: %v", i, err)
+		}
+		mined = append(mined, block)
+	}
+	return blockchain, mined
+}
+
 // Tests the validation of the entire blockchain's integrity
 func TestBlockchain_validateChain(t *testing.T) {
-	blockchain := &Blockchain{
-		Blocks:                []*Block{},
-		BlocksMapByHash:       make(map[string]*Block),
-		BlocksMapByMerkelRoot: make(map[string]*Block),
-	}
-	block1 := &Block{Hash: []byte("hash1"), PrevHash: []byte{}}
-	block2 := &Block{Hash: []byte("hash2"), PrevHash: []byte("hash1")}
-	blockchain.addBlock(block1)
-	blockchain.addBlock(block2)
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain, blocks := mineTestChain(t, 2, privateKey)
 
 	// Test a valid chain
-	if !blockchain.validateChain() {
+	if !blockchain.validateChain(4) {
 		t.Errorf("FAIL: validateChain returned false for a valid chain")
 	}
+	if blockchain.validateChain(256) {
+		t.Errorf("FAIL: validateChain returned true for a chain without enough work")
+	}
+
+	// Test an invalid chain (forged signature)
+	blocks[2].Signature = make([]byte, ed25519.SignatureSize)
+	if blockchain.validateChain(4) {
+		t.Errorf("FAIL: validateChain returned true for a chain with a forged signature")
+	}
+	blocks[2].Sign(privateKey)
+
+	// Test an invalid chain (records altered while keeping the hash and signature)
+	blocks[2].Name = "forged"
+	if blockchain.ValidLength(4) != 2 {
+		t.Errorf("FAIL: validateChain did not stop at a block whose records were altered")
+	}
+	blocks[2].Name = ""
 
 	// Test an invalid chain (broken link)
-	blockchain.Blocks[1].PrevHash = []byte("tampered_prev_hash")
-	if blockchain.validateChain() {
+	blocks[2].PrevHash = []byte("tampered_prev_hash")
+	if blockchain.validateChain(4) {
 		t.Errorf("FAIL: validateChain returned true for an invalid chain")
 	}
 }
 
+// Tests that blocks beyond the prune depth are reduced to headers that still validate
+func TestBlockchain_Prune(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain, _ := mineTestChain(t, 4, privateKey)
+
+	// A chain kept in full is pruned in one go, after which adding blocks keeps it pruned
+	blockchain.PruneDepth = 2
+	pruned, err := blockchain.Prune()
+	if err != nil || pruned != 3 {
+		t.Fatalf("FAIL: Expected 3 blocks to be pruned, got %d (error %v)", pruned, err)
+	}
+	next, err := MineOnTip(context.Background(), blockchain, []byte{5}, Records{Name: "report"}, verify.SHA256,
+		privateKey, 4, 2, 1, nil, nil)
+	if err != nil || blockchain.AddValidBlock(next, 4) != nil {
+		t.Fatalf("FAIL: Failed to add a block to the pruned chain: %v", err)
+	}
+
+	for height := int64(0); height < 6; height++ {
+		block, _ := blockchain.GetBlockByHeight(height)
+		if block.Pruned != (height < 4) {
+			t.Errorf("FAIL: Expected block %d to have pruned set to %t", height, height < 4)
+		}
+		if block.Pruned && height > 0 && (block.Signature == nil || !bytes.Equal(block.MerkelRoot, []byte{byte(height)})) {
+			t.Errorf("FAIL: Pruned block %d did not keep its header and signature", height)
+		}
+	}
+	if !blockchain.validateChain(4) {
+		t.Errorf("FAIL: validateChain returned false for a pruned chain")
+	}
+
+	// A header drops its block's records and keeps their hash, which the block hash covers in their place
+	header := next.Header()
+	prev, _ := blockchain.GetBlockByHeight(4)
+	if header.Name != "" || len(header.RecordsHash) == 0 || verify.Block(header.header(), prev.header(), 4, -1) != nil {
+		t.Errorf("FAIL: Expected the header to drop its records and still verify, got %+v", header)
+	}
+	header.RecordsHash = []byte("tampered")
+	if err := verify.Block(header.header(), prev.header(), 4, -1); !errors.Is(err, verify.ErrHashMismatch) {
+		t.Errorf("FAIL: Expected ErrHashMismatch for a header with a tampered records hash, got %v", err)
+	}
+
+	// Marking a block as pruned does not excuse a missing signature above the latest checkpoint
+	block, _ := blockchain.GetBlockByHeight(2)
+	block.Signature = nil
+	if blockchain.ValidLength(4) != 2 {
+		t.Errorf("FAIL: validateChain accepted an unsigned pruned block above the latest checkpoint")
+	}
+}
+
+// Tests that blocks up to the latest checkpoint are trusted and that forks before a checkpoint are refused
+func TestBlockchain_Checkpoints(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain, blocks := mineTestChain(t, 3, privateKey)
+
+	// Signatures and proof of work are no longer checked up to the checkpoint, but still are after it
+	blockchain.Checkpoints = []Checkpoint{{Height: 2, Hash: blocks[2].Hash}}
+	blocks[1].Signature = nil
+	if !blockchain.validateChain(4) {
+		t.Errorf("FAIL: validateChain checked a signature below the checkpoint")
+	}
+	if blockchain.ValidLength(256) != 3 {
+		t.Errorf("FAIL: validateChain did not check the proof of work only above the checkpoint")
+	}
+	blocks[3].Signature = nil
+	if blockchain.validateChain(4) {
+		t.Errorf("FAIL: validateChain did not check a signature above the checkpoint")
+	}
+	blocks[3].Sign(privateKey)
+
+	blockchain.Checkpoints = []Checkpoint{{Height: 2, Hash: []byte("other")}}
+	if blockchain.validateChain(4) {
+		t.Errorf("FAIL: validateChain accepted a chain that does not match a checkpoint")
+	}
+
+	// Replacing blocks is only allowed after the latest checkpoint
+	blockchain.Checkpoints = []Checkpoint{{Height: 2, Hash: blocks[2].Hash}}
+	if err := blockchain.AddBlock(&Block{Index: 1, Hash: []byte("fork"), PrevHash: blocks[0].Hash}); err != ErrCheckpointMismatch {
+		t.Errorf("FAIL: Expected a fork before the checkpoint to be refused, got %v", err)
+	}
+	if err := blockchain.AddBlock(&Block{Index: 2, Hash: []byte("fork"), PrevHash: blocks[1].Hash}); err != ErrCheckpointMismatch {
+		t.Errorf("FAIL: Expected a block not matching the checkpoint to be refused, got %v", err)
+	}
+	if err := blockchain.AddBlock(&Block{Index: 3, Hash: []byte("fork"), PrevHash: blocks[2].Hash}); err != nil {
+		t.Errorf("FAIL: A fork after the checkpoint was refused: %v", err)
+	}
+
+	if _, err := ParseCheckpoint("12:zz"); err == nil {
+		t.Errorf("FAIL: A checkpoint with an invalid hash was parsed")
+	}
+	if checkpoint, err := ParseCheckpoint("12:0a0b"); err != nil || checkpoint.Height != 12 || !bytes.Equal(checkpoint.Hash, []byte{10, 11}) {
+		t.Errorf("FAIL: A valid checkpoint was not parsed correctly")
+	}
+}
+
 // Tests the creation of a merkle tree with an even number of leaves
 func TestNewMerkleTree_EvenLeaves(t *testing.T) {
 	data := [][]byte{
@@ -168,7 +508,7 @@ func TestNewMerkleTree_EvenLeaves(t *testing.T) {
 		[]byte("chunk4"),
 	}
 
-	tree := blockchain_storage.newMerkleTree(data)
+	tree := NewMerkleTree(data)
 
 	// Manually calculate expected root hash
 	h1 := sha256.Sum256(data[0])
@@ -198,7 +538,7 @@ func TestNewMerkleTree_OddLeaves(t *testing.T) {
 		[]byte("chunk3"),
 	}
 
-	tree := blockchain_storage.newMerkleTree(data)
+	tree := NewMerkleTree(data)
 
 	// Manually calculate expected root hash for odd leaves (last one is duplicated)
 	h1 := sha256.Sum256(data[0])
@@ -213,6 +553,11 @@ func TestNewMerkleTree_OddLeaves(t *testing.T) {
 	if !bytes.Equal(tree.Root.Hash, expectedRoot[:]) {
 		t.Errorf("FAIL: Merkle root for odd leaves is incorrect")
 	}
+
+	// Auditors using the verify package must arrive at the same root from the chunk hashes alone
+	if !bytes.Equal(verify.MerkleRoot([][]byte{h1[:], h2[:], h3[:]}), tree.Root.Hash) {
+		t.Errorf("FAIL: verify.MerkleRoot does not match the root of the Merkle tree")
+	}
 }
 
 // Tests the entire proof generation and validation lifecycle
@@ -224,24 +569,24 @@ func TestMerkleProof(t *testing.T) {
 		[]byte("4"),
 		[]byte("5"),
 	}
-	tree := blockchain_storage.newMerkleTree(data)
+	tree := NewMerkleTree(data)
 	merkleRoot := tree.Root.Hash
 
 	// Test a valid proof for one of the chunks
 	chunkIndex := 2
-	validProof := tree.generateMerkleProof(chunkIndex)
+	validProof := tree.GenerateMerkleProof(chunkIndex)
 
-	if !blockchain_storage.validateMerkleProof(data[chunkIndex], merkleRoot, validProof) {
+	if !ValidateMerkleProof(data[chunkIndex], merkleRoot, validProof) {
 		t.Errorf("FAIL: A valid merkle proof failed to validate")
 	}
 
 	// Test with incorrect data
-	if blockchain_storage.validateMerkleProof([]byte("6"), merkleRoot, validProof) {
+	if ValidateMerkleProof([]byte("6"), merkleRoot, validProof) {
 		t.Errorf("FAIL: Merkle proof validated with incorrect data")
 	}
 
 	// Test with an incorrect merkle root
-	if blockchain_storage.validateMerkleProof(data[chunkIndex], []byte("bad root"), validProof) {
+	if ValidateMerkleProof(data[chunkIndex], []byte("bad root"), validProof) {
 		t.Errorf("FAIL: Merkle proof validated with an incorrect root hash")
 	}
 
@@ -250,7 +595,7 @@ func TestMerkleProof(t *testing.T) {
 	copy(tamperedProof, validProof)
 	hashArray := sha256.Sum256([]byte("tampered hash"))
 	tamperedProof[0].Hash = hashArray[:]
-	if blockchain_storage.validateMerkleProof(data[chunkIndex], merkleRoot, tamperedProof) {
+	if ValidateMerkleProof(data[chunkIndex], merkleRoot, tamperedProof) {
 		t.Errorf("FAIL: A tampered merkle proof was successfully validated")
 	}
 }
@@ -258,7 +603,7 @@ func TestMerkleProof(t *testing.T) {
 // Tests edge case of a tree with only one chunk
 func TestNewMerkleTree_SingleLeaf(t *testing.T) {
 	data := [][]byte{[]byte("single chunk")}
-	tree := blockchain_storage.newMerkleTree(data)
+	tree := NewMerkleTree(data)
 
 	expectedRoot := sha256.Sum256(data[0])
 
@@ -267,31 +612,257 @@ func TestNewMerkleTree_SingleLeaf(t *testing.T) {
 	}
 
 	// Proof for a single-node tree should be empty
-	proof := tree.generateMerkleProof(0)
+	proof := tree.GenerateMerkleProof(0)
 	if len(proof) != 0 {
 		t.Errorf("FAIL: Merkle proof for a single leaf tree should be empty")
 	}
 
-	if !blockchain_storage.validateMerkleProof(data[0], tree.Root.Hash, proof) {
+	if !ValidateMerkleProof(data[0], tree.Root.Hash, proof) {
 		t.Errorf("FAIL: Validation failed for a single leaf tree")
 	}
 }
 
+// Tests that a merkle tree survives a compact binary and a JSON round trip, still generating the same proofs
+func TestMerkleTree_Encoding(t *testing.T) {
+	data := [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4"), []byte("5")}
+	tree := NewMerkleTree(data)
+
+	encoded, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() failed with error: %v", err)
+	}
+	if len(encoded) != 2+5*32 {
+		t.Errorf("FAIL: Expected the compact encoding to only hold the leaf hashes, got %d bytes", len(encoded))
+	}
+	decoded := &MerkleTree{}
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatalf("UnmarshalBinary() failed with error: %v", err)
+	}
+	if !bytes.Equal(decoded.Root.Hash, tree.Root.Hash) || !ValidateMerkleProof(data[4], tree.Root.Hash, decoded.GenerateMerkleProof(4)) {
+		t.Errorf("FAIL: Decoded tree does not match the original tree")
+	}
+	if err := decoded.UnmarshalBinary(encoded[:len(encoded)-1]); err != ErrMalformedMerkleTree {
+		t.Errorf("FAIL: A truncated tree was decoded")
+	}
+
+	jsonTree, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatalf("FAIL: Merkle tree could not be encoded as JSON: %v", err)
+	}
+	fromJSON := &MerkleTree{}
+	if err := json.Unmarshal(jsonTree, fromJSON); err != nil || !bytes.Equal(fromJSON.Root.Hash, tree.Root.Hash) {
+		t.Errorf("FAIL: Merkle tree did not survive a JSON round trip (error %v)", err)
+	}
+	tampered := bytes.Replace(jsonTree, []byte(`"leaves":["`), []byte(`"leaves":["AA`), 1)
+	if err := json.Unmarshal(tampered, fromJSON); err == nil {
+		t.Errorf("FAIL: A tree whose leaves do not produce its root was decoded")
+	}
+}
+
+// Tests that BLAKE3 files produce their own Merkle trees, manifests and blocks, which are recorded and verified with it
+func TestHashAlgorithm_BLAKE3(t *testing.T) {
+	chunks := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	tree, err := NewMerkleTreeWith(verify.BLAKE3, chunks)
+	if err != nil {
+		t.Fatalf("NewMerkleTreeWith() failed with error: %v", err)
+	}
+	if bytes.Equal(tree.Root.Hash, NewMerkleTree(chunks).Root.Hash) {
+		t.Errorf("FAIL: BLAKE3 tree has the same root as the SHA-256 tree")
+	}
+	if _, err := NewMerkleTreeWith("unknown", chunks); err != verify.ErrUnknownHash {
+		t.Errorf("FAIL: Expected ErrUnknownHash for an unregistered algorithm, got %v", err)
+	}
+	if !verify.MerkleProofWith(verify.BLAKE3, chunks[2], tree.Root.Hash, tree.GenerateMerkleProof(2)) {
+		t.Errorf("FAIL: BLAKE3 Merkle proof failed verification")
+	}
+	builder := &MerkleBuilder{Algorithm: verify.BLAKE3}
+	for _, chunk := range chunks {
+		builder.AddChunk(chunk)
+	}
+	if root, err := builder.Root(); err != nil || !bytes.Equal(root, tree.Root.Hash) {
+		t.Errorf("FAIL: Streamed BLAKE3 root does not match the Merkle tree")
+	}
+
+	// Both encodings keep the algorithm, while SHA-256 trees keep their original encodings
+	encoded, _ := tree.MarshalBinary()
+	decoded := &MerkleTree{}
+	if err := decoded.UnmarshalBinary(encoded); err != nil || decoded.Algorithm != verify.BLAKE3 ||
+		!bytes.Equal(decoded.Root.Hash, tree.Root.Hash) {
+		t.Errorf("FAIL: BLAKE3 tree did not survive a binary round trip (error %v)", err)
+	}
+	jsonTree, _ := json.Marshal(tree)
+	if err := json.Unmarshal(jsonTree, decoded); err != nil || decoded.Algorithm != verify.BLAKE3 {
+		t.Errorf("FAIL: BLAKE3 tree did not survive a JSON round trip (error %v)", err)
+	}
+	if jsonSHA256, _ := json.Marshal(NewMerkleTree(chunks)); bytes.Contains(jsonSHA256, []byte("algorithm")) {
+		t.Errorf("FAIL: SHA-256 tree records its algorithm: %s", jsonSHA256)
+	}
+
+	manifest := NewManifest("file", "file", 1, chunks, tree)
+	if manifest.HashAlgorithm != verify.BLAKE3 || !manifest.VerifyChunks(chunks) {
+		t.Errorf("FAIL: BLAKE3 manifest does not record or verify with its algorithm")
+	}
+	manifest.HashAlgorithm = ""
+	if manifest.VerifyChunks(chunks) {
+		t.Errorf("FAIL: BLAKE3 chunks verified as SHA-256 chunks")
+	}
+
+	// A block records the algorithm and is hashed with it, so nodes verify it without being told the algorithm
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(0, 0)))
+	block, err := MineOnTip(context.Background(), blockchain, tree.Root.Hash, Records{}, verify.BLAKE3, privateKey, 8, 2, 1, nil, nil)
+	if err != nil {
+		t.Fatalf("MineOnTip() failed with error: %v", err)
+	}
+	if block.HashAlgorithm != verify.BLAKE3 || !block.isValid(blockchain.LastBlock(), 8) {
+		t.Errorf("FAIL: BLAKE3 block does not record its algorithm or failed validation")
+	}
+	if err := verify.ManifestCommitment(manifest.ChunkHashes, manifest.MerkleRoot, block.header()); err != nil {
+		t.Errorf("FAIL: BLAKE3 manifest commitment failed verification: %v", err)
+	}
+	sha256Block := CreateBlock(blockchain, tree.Root.Hash, Records{}, privateKey.Public().(ed25519.PublicKey), verify.SHA256)
+	if jsonBlock, _ := json.Marshal(sha256Block); bytes.Contains(jsonBlock, []byte("hashAlgorithm")) {
+		t.Errorf("FAIL: SHA-256 block records its algorithm")
+	}
+}
+
+// Tests that new blocks use the canonical hash encoding on top of legacy chains and keep their hash through JSON
+func TestCreateBlock_HashVersion(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	genesis := &Block{Index: 0, Timestamp: time.Unix(0, 0), MerkelRoot: []byte("genesis"), PrevHash: []byte{}}
+	genesis.Hash = genesis.calculateHash()
+	blockchain.AddBlock(genesis)
+
+	block, err := MineOnTip(context.Background(), blockchain, []byte("merkel"), Records{}, verify.SHA256, privateKey, 8, 2, 1, nil, nil)
+	if err != nil {
+		t.Fatalf("MineOnTip() failed with error: %v", err)
+	}
+	if block.Version != verify.CurrentHashVersion || !block.isValid(genesis, 8) {
+		t.Fatalf("FAIL: Block on a legacy chain was not created and validated with the current hash version")
+	}
+	jsonBlock, _ := json.Marshal(block)
+	decoded := &Block{}
+	json.Unmarshal(jsonBlock, decoded)
+	if !bytes.Equal(decoded.calculateHash(), block.Hash) {
+		t.Errorf("FAIL: Block hash changed through a JSON round trip")
+	}
+}
+
+// Tests that chunk sizes grow with the file size within their bounds
+func TestChooseChunkSize(t *testing.T) {
+	for fileSize, expected := range map[int64]int64{
+		0:                 MinChunkSize,
+		1000:              MinChunkSize,
+		256 * 1024 * 1024: MinChunkSize,
+		1 << 30:           1024 * 1024,
+		(1 << 30) + 1:     2 * 1024 * 1024,
+		1 << 40:           MaxChunkSize,
+	} {
+		if chunkSize := ChooseChunkSize(fileSize); chunkSize != expected {
+			t.Errorf("FAIL: Expected a chunk size of %d for a file of %d bytes, got %d", expected, fileSize, chunkSize)
+		}
+	}
+	if ValidateChunkSize(0) == nil || ValidateChunkSize(MaxChunkSize+1) == nil || ValidateChunkSize(1) != nil {
+		t.Errorf("FAIL: Explicit chunk sizes were not checked against their bounds")
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("0123456789"), 0644)
+	if chunks, err := ChunkFileBytes(path, 4); err != nil || len(chunks) != 3 || string(chunks[2]) != "89" {
+		t.Errorf("FAIL: File was not split into chunks of 4 bytes (error %v)", err)
+	}
+}
+
+// Tests that padded chunks all have the chunk size and reassemble the original file once the padding is stripped
+func TestPadChunks(t *testing.T) {
+	chunks := [][]byte{[]byte("12345678"), []byte("123")}
+	padded, padding, err := PadChunks(chunks, 8)
+	if err != nil {
+		t.Fatalf("PadChunks() failed with error: %v", err)
+	}
+	if padding != 5 || len(padded[0]) != 8 || len(padded[1]) != 8 || string(chunks[1]) != "123" {
+		t.Errorf("FAIL: Expected the last chunk to be padded by 5 bytes without changing the original chunks")
+	}
+
+	manifest := NewManifest("file", "file.txt", 8, padded, NewMerkleTree(padded))
+	manifest.FileSize -= padding
+	manifest.Padding = padding
+	if manifest.FileSize != 11 || !manifest.VerifyChunks(padded) {
+		t.Errorf("FAIL: Manifest of a padded file does not describe the original file")
+	}
+	unpadded, err := manifest.Unpad(padded)
+	if err != nil || string(unpadded[0]) != "12345678" || string(unpadded[1]) != "123" {
+		t.Errorf("FAIL: Unpad() did not restore the original chunks (error %v)", err)
+	}
+
+	manifest.Padding = 9
+	if _, err := manifest.Unpad(padded); err == nil {
+		t.Errorf("FAIL: Unpad() accepted padding longer than the last chunk")
+	}
+}
+
+// Tests that the streaming Merkle builder produces the same root as building the whole tree, for any number of chunks
+func TestMerkleBuilder(t *testing.T) {
+	builder := &MerkleBuilder{}
+	if _, err := builder.Root(); err != ErrNoLeaves {
+		t.Errorf("FAIL: Expected ErrNoLeaves from an empty builder, got %v", err)
+	}
+
+	var chunks [][]byte
+	for n := 1; n <= 40; n++ {
+		chunk := []byte{byte(n)}
+		chunks = append(chunks, chunk)
+		builder.AddChunk(chunk)
+		root, err := builder.Root()
+		if err != nil || !bytes.Equal(root, NewMerkleTree(chunks).Root.Hash) {
+			t.Fatalf("FAIL: Streamed Merkle root for %d chunks does not match the Merkle tree", n)
+		}
+	}
+	if len(builder.pending) > 6 {
+		t.Errorf("FAIL: Builder kept %d subtrees for 40 chunks", len(builder.pending))
+	}
+}
+
+// Tests that a file streamed chunk by chunk is split the same way as when it is read into memory
+func TestStreamChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	contents := bytes.Repeat([]byte("0123456789"), 300000)
+	os.WriteFile(path, contents, 0644)
+
+	file, _ := os.Open(path)
+	defer file.Close()
+	builder := &MerkleBuilder{}
+	var streamed []byte
+	err := StreamChunks(file, 1024*1024, func(chunk []byte) error {
+		builder.AddChunk(chunk)
+		streamed = append(streamed, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamChunks() failed with error: %v", err)
+	}
+
+	chunks, _ := ChunkFile(path, 1)
+	root, _ := builder.Root()
+	if builder.Len() != len(chunks) || !bytes.Equal(root, NewMerkleTree(chunks).Root.Hash) || !bytes.Equal(streamed, contents) {
+		t.Errorf("FAIL: Streamed chunks do not match the chunks of the file read into memory")
+	}
+}
+
 // Test the blockchain persistence through file read/writing
 func TestPersistence(t *testing.T) {
 	//Create a temporary directory and an original blockchain
 	tempDir := t.TempDir()
 	testFile := filepath.Join(tempDir, "blockchain.json")
 
-	originalBlockchain := &Blockchain{
-		Blocks: []*Block{
-			{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("merkel0")},
-			{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("merkel1")},
-		},
-	}
+	originalBlockchain := NewBlockchain(NewMemoryChainStore())
+	originalBlockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("merkel0")})
+	originalBlockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("merkel1")})
 
 	// est writeToFile
-	err := originalBlockchain.writeToFile(testFile)
+	err := originalBlockchain.WriteToFile(testFile)
 	if err != nil {
 		t.Fatalf("writeToFile() failed with error: %v", err)
 	}
@@ -302,24 +873,735 @@ func TestPersistence(t *testing.T) {
 	}
 
 	// Test blockchainFromFile
-	loadedBlockchain, err := blockchainFromFile(testFile)
+	loadedBlockchain, err := BlockchainFromFile(testFile)
 	if err != nil {
 		t.Fatalf("blockchainFromFile() failed with error: %v", err)
 	}
 
 	// Check if the number of blocks is the same
-	if len(loadedBlockchain.Blocks) != len(originalBlockchain.Blocks) {
-		t.Fatalf("Loaded blockchain has wrong number of blocks. Got %d, want %d", len(loadedBlockchain.Blocks), len(originalBlockchain.Blocks))
+	if loadedBlockchain.Length() != originalBlockchain.Length() {
+		t.Fatalf("Loaded blockchain has wrong number of blocks. Got %d, want %d", loadedBlockchain.Length(), originalBlockchain.Length())
 	}
 
 	// Check if the block data is consistent
-	if !bytes.Equal(loadedBlockchain.Blocks[1].Hash, originalBlockchain.Blocks[1].Hash) {
+	if !bytes.Equal(loadedBlockchain.LastBlock().Hash, originalBlockchain.LastBlock().Hash) {
 		t.Errorf("Loaded block data does not match original data")
 	}
 
-	// Check if the lookup maps were rebuilt correctly
-	_, ok := loadedBlockchain.BlocksMapByHash[hex.EncodeToString(originalBlockchain.Blocks[1].Hash)]
-	if !ok {
-		t.Errorf("Map lookup failed in loaded blockchain, indicating maps were not rebuilt")
+	// Check if the lookups were rebuilt correctly
+	_, err = loadedBlockchain.GetBlockByHash(originalBlockchain.LastBlock().Hash)
+	if err != nil {
+		t.Errorf("Hash lookup failed in loaded blockchain, indicating lookups were not rebuilt")
+	}
+}
+
+// Tests that a truncated or corrupted blockchain file is detected and the last good copy is read instead
+func TestPersistence_CorruptFile(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "blockchain.json")
+	first := &Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("merkel0")}
+	second := &Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("merkel1")}
+	WriteBlocksToFile([]*Block{first}, testFile)
+	err := WriteBlocksToFile([]*Block{first, second}, testFile)
+	if err != nil {
+		t.Fatalf("WriteBlocksToFile() failed with error: %v", err)
+	}
+	if blocks, err := BlocksFromFile(testFile); err != nil || len(blocks) != 2 {
+		t.Fatalf("FAIL: Expected to read back 2 blocks, got %d (error %v)", len(blocks), err)
+	}
+
+	// Simulate a crash that cut the file short, which should fall back to the previous copy
+	contents, _ := os.ReadFile(testFile)
+	os.WriteFile(testFile, contents[:len(contents)-10], 0644)
+	if _, err := readBlocksFile(testFile); !errors.Is(err, ErrChainFileCorrupt) {
+		t.Errorf("FAIL: Expected a truncated file to be detected as corrupt, got %v", err)
+	}
+	blocks, err := BlocksFromFile(testFile)
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("FAIL: Expected to fall back to the last good copy with 1 block, got %d (error %v)", len(blocks), err)
+	}
+
+	// A single flipped byte must also be caught by the checksum even though the JSON still parses
+	os.WriteFile(testFile, bytes.Replace(contents, []byte("merkel"), []byte("merkeL"), 1), 0644)
+	if _, err := readBlocksFile(testFile); !errors.Is(err, ErrChainFileCorrupt) {
+		t.Errorf("FAIL: Expected a modified file to fail its checksum, got %v", err)
+	}
+
+	// Files written before the checksum footer existed are still readable
+	os.WriteFile(testFile, []byte(`[{"index": 0, "hash": "aGFzaDA="}]`), 0644)
+	if blocks, err := readBlocksFile(testFile); err != nil || len(blocks) != 1 {
+		t.Errorf("FAIL: Expected a file without a footer to be read, got error %v", err)
+	}
+}
+
+// Tests that a chain archive round trips, detects damage and only ever extends the local chain
+func TestChainArchive(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(1700000000, 0)))
+	for i := 1; i < 4; i++ {
+		block, err := MineOnTip(context.Background(), blockchain, []byte{byte(i)}, Records{}, verify.SHA256, privateKey, 4,
+			2, 1, nil, nil)
+		if err != nil || blockchain.AddValidBlock(block, 4) != nil {
+			t.Fatalf("FAIL: Failed to add block %d: %v", i, err)
+		}
+	}
+	var archive bytes.Buffer
+	count, err := blockchain.WriteArchive(&archive)
+	if err != nil || count != 4 {
+		t.Fatalf("FAIL: Expected 4 blocks to be archived, got %d (error %v)", count, err)
+	}
+
+	// A fresh node holding only the genesis block is brought up to the archived tip
+	fresh := NewBlockchain(NewMemoryChainStore())
+	genesis, _ := blockchain.GetBlockByHeight(0)
+	fresh.AddBlock(genesis)
+	blocks, err := ReadArchive(bytes.NewReader(archive.Bytes()))
+	if err != nil || len(blocks) != 4 {
+		t.Fatalf("FAIL: Expected to read back 4 blocks, got %d (error %v)", len(blocks), err)
+	}
+	added, err := fresh.ImportBlocks(blocks, 4)
+	if err != nil || added != 3 || !bytes.Equal(fresh.LastBlock().Hash, blockchain.LastBlock().Hash) {
+		t.Fatalf("FAIL: Expected 3 blocks to be imported up to the archived tip, got %d (error %v)", added, err)
+	}
+	if added, err := fresh.ImportBlocks(blocks, 4); err != nil || added != 0 {
+		t.Errorf("FAIL: Expected importing the same archive again to add nothing, got %d (error %v)", added, err)
+	}
+
+	// Truncated or modified archives fail their checksum
+	if _, err := ReadArchive(bytes.NewReader(archive.Bytes()[:archive.Len()-1])); !errors.Is(err, ErrChainArchiveCorrupt) {
+		t.Errorf("FAIL: Expected a truncated archive to be detected as corrupt, got %v", err)
+	}
+	damaged := bytes.Clone(archive.Bytes())
+	damaged[20] ^= 1
+	if _, err := ReadArchive(bytes.NewReader(damaged)); !errors.Is(err, ErrChainArchiveCorrupt) {
+		t.Errorf("FAIL: Expected a modified archive to fail its checksum, got %v", err)
+	}
+
+	// Neither a chain that has diverged nor a forged block is accepted
+	forked := NewBlockchain(NewMemoryChainStore())
+	forked.AddBlock(&Block{Hash: []byte("other genesis"), PrevHash: []byte{}})
+	if _, err := forked.ImportBlocks(blocks, 4); !errors.Is(err, ErrChainArchiveConflict) {
+		t.Errorf("FAIL: Expected an archive of another chain to conflict, got %v", err)
+	}
+	forgedGenesis := *blocks[0]
+	forgedGenesis.MerkelRoot = []byte("forged")
+	if _, err := NewBlockchain(NewMemoryChainStore()).ImportBlocks([]*Block{&forgedGenesis}, 4); err == nil {
+		t.Errorf("FAIL: Genesis block not matching its hash was imported")
+	}
+	// A block whose records were swapped while keeping its hash and signature is caught by its hash
+	tampered := *blocks[2]
+	tampered.MerkelRoot = []byte("forged")
+	if added, err := NewBlockchain(NewMemoryChainStore()).ImportBlocks([]*Block{blocks[0], blocks[1], &tampered}, 4); !errors.Is(err,
+		verify.ErrHashMismatch) || added != 2 {
+		t.Errorf("FAIL: Expected the import to stop at the tampered block after 2 blocks, got %d (error %v)", added, err)
+	}
+	// A mined but unsigned block claiming another uploader's key is not excused by being marked as pruned, unless it is
+	// covered by a checkpoint
+	partial := NewBlockchain(NewMemoryChainStore())
+	for _, block := range blocks[:3] {
+		partial.AddBlock(block)
+	}
+	victimKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	forged := CreateBlock(partial, []byte("forged"), Records{}, victimKey, verify.SHA256)
+	if err := forged.Mine(context.Background(), 4, 2, 1); err != nil {
+		t.Fatalf("Mine() failed with error: %v", err)
+	}
+	forged.Pruned = true
+	if added, err := NewBlockchain(NewMemoryChainStore()).ImportBlocks([]*Block{blocks[0], blocks[1], blocks[2], forged},
+		4); !errors.Is(err, verify.ErrBadSignature) || added != 3 {
+		t.Errorf("FAIL: Expected the unsigned pruned block to be refused after 3 blocks, got %d (error %v)", added, err)
+	}
+	checkpointed := NewBlockchain(NewMemoryChainStore())
+	checkpointed.Checkpoints = []Checkpoint{{Height: 3, Hash: forged.Hash}}
+	if added, err := checkpointed.ImportBlocks([]*Block{blocks[0], blocks[1], blocks[2], forged}, 4); err != nil || added != 4 {
+		t.Errorf("FAIL: Expected an unsigned block covered by a checkpoint to be imported, got %d (error %v)", added, err)
+	}
+
+	blocks[3].Signature = make([]byte, ed25519.SignatureSize)
+	if added, err := NewBlockchain(NewMemoryChainStore()).ImportBlocks(blocks, 4); err == nil || added != 3 {
+		t.Errorf("FAIL: Expected the import to stop at the forged block after 3 blocks, got %d (error %v)", added, err)
+	}
+}
+
+// Tests that a snapshot's blocks bring a node up to the snapshot's head, and that tampered snapshots are refused
+func TestChainSnapshot(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	genesis := NewGenesisBlock(time.Unix(1700000000, 0))
+	blockchain.AddBlock(genesis)
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	for i := 0; i < 4; i++ {
+		records := Records{}
+		if i == 1 {
+			records.Name = "report"
+		}
+		block := CreateBlock(blockchain, []byte{byte(i)}, records, publicKey, verify.SHA256)
+		block.Sign(privateKey)
+		blockchain.AddBlock(block)
+	}
+	_, nodeKey, _ := ed25519.GenerateKey(rand.Reader)
+	snapshot, blocks, err := blockchain.CreateSnapshot(3, nodeKey, time.Now())
+	if err != nil || len(blocks) != 4 {
+		t.Fatalf("FAIL: Expected a snapshot covering 4 blocks, got %d (error %v)", len(blocks), err)
+	}
+	if snapshot.State != (StateSummary{Files: 3, Names: 1}) {
+		t.Errorf("FAIL: Expected the snapshot to summarise 3 files and 1 name, got %+v", snapshot.State)
+	}
+
+	// Only a snapshot reaching no further than a checkpoint is trusted
+	if _, err := NewBlockchain(NewMemoryChainStore()).ApplySnapshot(snapshot, blocks, 0); !errors.Is(err,
+		ErrSnapshotUntrusted) {
+		t.Errorf("FAIL: Expected a snapshot beyond every checkpoint to be refused, got %v", err)
+	}
+	trusted := []Checkpoint{{Height: 3, Hash: blocks[3].Hash}}
+	fresh := NewBlockchain(NewMemoryChainStore())
+	fresh.Checkpoints = trusted
+	fresh.AddBlock(genesis)
+	added, err := fresh.ApplySnapshot(snapshot, blocks, 0)
+	if err != nil || added != 3 || fresh.Length() != 4 {
+		t.Fatalf("FAIL: Expected 3 blocks to be added, got %d (error %v)", added, err)
+	}
+	// The block after the snapshot is synced in full on top of the blocks it covers
+	last, _ := blockchain.GetBlockByHeight(4)
+	if err := fresh.AddValidBlock(last, 0); err != nil {
+		t.Errorf("FAIL: Expected the block after the snapshot to extend the synced blocks, got %v", err)
+	}
+
+	// Each test applies the snapshot to a fresh blockchain trusting the snapshot's head
+	checkpointed := func(checkpoints ...Checkpoint) *Blockchain {
+		blockchain := NewBlockchain(NewMemoryChainStore())
+		blockchain.Checkpoints = append(checkpoints, trusted...)
+		return blockchain
+	}
+	tampered := *snapshot
+	tampered.State.Names = 2
+	if _, err := checkpointed().ApplySnapshot(&tampered, blocks, 0); !errors.Is(err, ErrSnapshotSignature) {
+		t.Errorf("FAIL: Expected a modified snapshot to fail its signature, got %v", err)
+	}
+	other := Checkpoint{Height: 2, Hash: []byte("other")}
+	if _, err := checkpointed(other).ApplySnapshot(snapshot, blocks, 0); !errors.Is(err, ErrCheckpointMismatch) {
+		t.Errorf("FAIL: Expected a snapshot conflicting with a checkpoint to be refused, got %v", err)
+	}
+
+	// A block deleting another uploader's file is refused on import, even with valid proof of work
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	tombstone := CreateBlock(blockchain, TombstoneRoot([]byte{0}), Records{Tombstone: []byte{0}},
+		otherKey.Public().(ed25519.PublicKey), verify.SHA256)
+	tombstone.Sign(otherKey)
+	blockchain.AddBlock(tombstone)
+	forged, forgedBlocks, err := blockchain.CreateSnapshot(5, nodeKey, time.Now())
+	if err != nil {
+		t.Fatalf("CreateSnapshot() failed with error: %v", err)
+	}
+	target := checkpointed(Checkpoint{Height: 5, Hash: forgedBlocks[5].Hash})
+	if added, err := target.ApplySnapshot(forged, forgedBlocks, 0); !errors.Is(err, ErrNotUploader) || added != 5 {
+		t.Errorf("FAIL: Expected the forged tombstone to be refused after 5 blocks, got %d (error %v)", added, err)
+	}
+
+	blocks[2].Name = "renamed"
+	if _, err := checkpointed().ApplySnapshot(snapshot, blocks, 0); err == nil {
+		t.Errorf("FAIL: Expected a snapshot with a modified block to be refused")
+	}
+}
+
+// Tests that headers are checked against the tip along with their signatures, stopping at the first invalid one
+func TestBlockchain_ValidateHeaders(t *testing.T) {
+	source := NewBlockchain(NewMemoryChainStore())
+	genesis := NewGenesisBlock(time.Unix(1700000000, 0))
+	source.AddBlock(genesis)
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	var headers []*Block
+	for i := 0; i < 3; i++ {
+		block := CreateBlock(source, []byte{byte(i)}, Records{}, publicKey, verify.SHA256)
+		block.Sign(privateKey)
+		source.AddBlock(block)
+		headers = append(headers, block.Header())
+	}
+
+	local := NewBlockchain(NewMemoryChainStore())
+	local.AddBlock(genesis)
+	if valid, err := local.ValidateHeaders(headers, 0); err != nil || valid != 3 {
+		t.Errorf("FAIL: Expected 3 valid headers, got %d (error %v)", valid, err)
+	}
+	if valid, err := local.ValidateHeaders(headers, 256); !errors.Is(err, verify.ErrInsufficientWork) || valid != 0 {
+		t.Errorf("FAIL: Expected headers without enough work to be refused, got %d (error %v)", valid, err)
+	}
+	headers[2].MerkelRoot = []byte("tampered")
+	if valid, err := local.ValidateHeaders(headers, 0); !errors.Is(err, verify.ErrHashMismatch) || valid != 2 {
+		t.Errorf("FAIL: Expected the headers before a tampered one to be valid, got %d (error %v)", valid, err)
+	}
+}
+
+// Tests that inclusion proofs built from a node's chain and manifest verify for every chunk, and that they are refused
+// for a different chunk, position or chain
+func TestBlockchain_InclusionProof(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(1700000000, 0)))
+	chunks := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	manifest := NewManifest("file", "file", 6, chunks, NewMerkleTree(chunks))
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, merkleRoot := range [][]byte{manifest.MerkleRoot, []byte("later"), []byte("latest")} {
+		block := CreateBlock(blockchain, merkleRoot, Records{}, publicKey, verify.SHA256)
+		block.Sign(privateKey)
+		blockchain.AddBlock(block)
+	}
+
+	for i, chunk := range chunks {
+		proof, err := blockchain.InclusionProof(manifest, i, 0)
+		if err != nil || len(proof.Headers) != 3 {
+			t.Fatalf("FAIL: Expected a proof of chunk %d with 3 headers, got %v (%v)", i, proof, err)
+		}
+		if err := verify.Inclusion(proof, chunk, 0); err != nil {
+			t.Errorf("FAIL: Expected the proof of chunk %d to verify, got %v", i, err)
+		}
+	}
+
+	proof, _ := blockchain.InclusionProof(manifest, 1, 1)
+	if len(proof.Headers) != 2 {
+		t.Errorf("FAIL: Expected the headers to stop after 1 confirmation, got %d", len(proof.Headers))
+	}
+	if err := verify.Inclusion(proof, chunks[0], 0); !errors.Is(err, verify.ErrChunkMismatch) {
+		t.Errorf("FAIL: Expected a different chunk to be refused, got %v", err)
+	}
+	proof.ChunkIndex = 2
+	if err := verify.Inclusion(proof, chunks[1], 0); !errors.Is(err, verify.ErrPositionMismatch) {
+		t.Errorf("FAIL: Expected a proof claiming the wrong position to be refused, got %v", err)
+	}
+	proof.ChunkIndex = 1
+	proof.Headers[1].Nonce++
+	if err := verify.Inclusion(proof, chunks[1], 0); !errors.Is(err, verify.ErrHashMismatch) {
+		t.Errorf("FAIL: Expected a tampered header to be refused, got %v", err)
+	}
+	if _, err := blockchain.InclusionProof(manifest, 3, 0); err == nil {
+		t.Errorf("FAIL: Expected a proof of a chunk past the end of the file to be refused")
+	}
+
+	// The last chunk of the odd level is paired with a copy of itself, which leads to the same root but is no chunk
+	duplicate, _ := blockchain.InclusionProof(manifest, 2, 0)
+	duplicate.Proof[0].Left = true
+	duplicate.ChunkIndex = 3
+	if err := verify.Inclusion(duplicate, chunks[2], 0); !errors.Is(err, verify.ErrPositionMismatch) {
+		t.Errorf("FAIL: Expected a proof through the copy of the last chunk to be refused, got %v", err)
+	}
+}
+
+// Tests that each chunk of a file is checked on its own, so that only the bad chunks fail their proofs
+func TestManifest_VerifyChunkProofs(t *testing.T) {
+	for _, algorithm := range []verify.HashAlgorithm{verify.SHA256, verify.BLAKE3} {
+		chunks := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+		tree, _ := NewMerkleTreeWith(algorithm, chunks)
+		manifest := NewManifest("file", "file", 6, chunks, tree)
+
+		proofs := manifest.VerifyChunkProofs(chunks)
+		if len(proofs) != 3 || !proofs[0] || !proofs[1] || !proofs[2] {
+			t.Errorf("FAIL: %s: Expected every chunk to pass its proof, got %v", algorithm, proofs)
+		}
+		tampered := [][]byte{chunks[0], []byte("forged"), chunks[2]}
+		proofs = manifest.VerifyChunkProofs(tampered)
+		if !proofs[0] || proofs[1] || !proofs[2] {
+			t.Errorf("FAIL: %s: Expected only the forged chunk to fail its proof, got %v", algorithm, proofs)
+		}
+		if proofs := manifest.VerifyChunkProofs(chunks[:2]); proofs[0] || proofs[1] {
+			t.Errorf("FAIL: %s: Chunks of a file with a chunk missing passed their proofs", algorithm)
+		}
+	}
+}
+
+// Tests resolving which version of a file was current at a given block height
+func TestBlockchain_ResolveManifestAtHeight(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	version1 := &Manifest{Alias: "file", MerkleRoot: []byte("root1")}
+	version2 := &Manifest{Alias: "file", MerkleRoot: []byte("root2")}
+	uncommitted := &Manifest{Alias: "file", MerkleRoot: []byte("root3")}
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	blockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: version1.MerkleRoot})
+	blockchain.AddBlock(&Block{Index: 2, Hash: []byte("hash2"), MerkelRoot: []byte("other file")})
+	blockchain.AddBlock(&Block{Index: 3, Hash: []byte("hash3"), MerkelRoot: version2.MerkleRoot})
+	versions := []*Manifest{version2, uncommitted, version1}
+
+	tests := []struct {
+		height   int64
+		expected *Manifest
+	}{
+		{1, version1},
+		{2, version1},
+		{3, version2},
+		{-1, version2},
+	}
+	for _, test := range tests {
+		resolved, err := blockchain.ResolveManifestAtHeight(versions, test.height)
+		if err != nil || resolved != test.expected {
+			t.Errorf("FAIL: Wrong version resolved at height %d", test.height)
+		}
+	}
+
+	// Before the first version was committed there is nothing to resolve
+	if _, err := blockchain.ResolveManifestAtHeight(versions, 0); err == nil {
+		t.Errorf("FAIL: A version was resolved at a height before any version was committed")
+	}
+}
+
+// Tests that names are owned by their first claimer, who alone can point them at newer files
+func TestBlockchain_NameRegistry(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	owner, other := []byte("owner key"), []byte("other key")
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	blockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"), UploaderPublicKey: owner,
+		Records: Records{Name: "photos-2024"}})
+	blockchain.AddBlock(&Block{Index: 2, Hash: []byte("hash2"), MerkelRoot: []byte("root2"), UploaderPublicKey: owner,
+		Records: Records{Name: "photos-2024"}})
+
+	if err := blockchain.CanClaimName("photos-2024", owner); err != nil {
+		t.Errorf("FAIL: Owner could not claim its own name again: %v", err)
+	}
+	if err := blockchain.CanClaimName("photos-2024", other); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("FAIL: Expected ErrNameTaken when claiming another uploader's name, got %v", err)
+	}
+	if err := blockchain.CanClaimName("Not A Name", owner); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("FAIL: Expected ErrInvalidName for a name breaking the naming rules, got %v", err)
+	}
+	if err := blockchain.CanClaimName("documents", other); err != nil {
+		t.Errorf("FAIL: Unclaimed name could not be claimed: %v", err)
+	}
+
+	// The name points at the newest claim, but resolving at an earlier height gives the file it pointed at then
+	claim, err := blockchain.ResolveName("photos-2024", -1)
+	if err != nil || !bytes.Equal(claim.MerkleRoot, []byte("root2")) || claim.ClaimedSince != 1 || claim.Height != 2 {
+		t.Errorf("FAIL: Expected the name to point at the newest claim, got %+v (%v)", claim, err)
+	}
+	claim, err = blockchain.ResolveName("photos-2024", 1)
+	if err != nil || !bytes.Equal(claim.MerkleRoot, []byte("root1")) {
+		t.Errorf("FAIL: Expected the name to point at the first claim at height 1, got %+v (%v)", claim, err)
+	}
+	if _, err := blockchain.ResolveName("photos-2024", 0); !errors.Is(err, ErrNameNotFound) {
+		t.Errorf("FAIL: Expected ErrNameNotFound before the name was claimed, got %v", err)
+	}
+
+	// A block claiming another uploader's name is refused by the chain
+	block := &Block{Index: 3, MerkelRoot: []byte("root3"), UploaderPublicKey: other, Records: Records{Name: "photos-2024"}}
+	if err := blockchain.CheckNameClaim(block); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("FAIL: Expected a block claiming a taken name to be refused, got %v", err)
+	}
+	names, err := blockchain.Names()
+	if err != nil || len(names) != 1 || names[0].Name != "photos-2024" {
+		t.Errorf("FAIL: Expected a single claimed name, got %+v (%v)", names, err)
+	}
+}
+
+// Tests that file versions are followed back through the previous version each block references
+func TestBlockchain_History(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	owner, other := []byte("owner key"), []byte("other key")
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	blockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"), UploaderPublicKey: owner})
+	blockchain.AddBlock(&Block{Index: 2, Hash: []byte("hash2"), MerkelRoot: []byte("other file"), UploaderPublicKey: other})
+	blockchain.AddBlock(&Block{Index: 3, Hash: []byte("hash3"), MerkelRoot: []byte("root2"), UploaderPublicKey: owner,
+		Records: Records{PrevVersion: []byte("root1")}})
+
+	history, err := blockchain.History([]byte("root2"))
+	if err != nil || len(history) != 2 || !bytes.Equal(history[0].MerkleRoot, []byte("root1")) ||
+		history[0].Version != 1 || history[1].Version != 2 || history[1].Height != 3 {
+		t.Fatalf("FAIL: Expected two versions oldest first, got %+v (%v)", history, err)
+	}
+	version, err := blockchain.FileVersion([]byte("root2"), 1)
+	if err != nil || !bytes.Equal(version.MerkleRoot, []byte("root1")) {
+		t.Errorf("FAIL: Expected version 1 to be the first file, got %+v (%v)", version, err)
+	}
+	if _, err := blockchain.FileVersion([]byte("root2"), 3); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("FAIL: Expected ErrVersionNotFound for a version beyond the history, got %v", err)
+	}
+
+	// Only earlier files of the same uploader can be extended
+	tests := []struct {
+		prevVersion []byte
+		uploader    []byte
+		expected    error
+	}{
+		{[]byte("root2"), owner, nil},
+		{[]byte("root2"), other, ErrForeignPrevVersion},
+		{[]byte("unknown"), owner, ErrUnknownPrevVersion},
+	}
+	for _, test := range tests {
+		block := &Block{Index: 4, UploaderPublicKey: test.uploader, Records: Records{PrevVersion: test.prevVersion}}
+		if err := blockchain.CheckPrevVersion(block); !errors.Is(err, test.expected) {
+			t.Errorf("FAIL: Expected %v when extending %s, got %v", test.expected, test.prevVersion, err)
+		}
+	}
+}
+
+// Tests that a block committing several files commits to their records and makes each of them its own file
+func TestBlockchain_BatchBlocks(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(1700000000, 0)))
+	first, err := MineOnTip(context.Background(), blockchain, []byte("root1"), Records{Name: "photos"}, verify.SHA256,
+		privateKey, 4, 2, 1, nil, nil)
+	if err != nil || blockchain.AddValidBlock(first, 4) != nil {
+		t.Fatalf("FAIL: Failed to add the first file: %v", err)
+	}
+
+	files := []FileRecord{
+		{MerkleRoot: []byte("root2"), Name: "photos", PrevVersion: []byte("root1")},
+		{MerkleRoot: []byte("root3"), Name: "documents"},
+		{MerkleRoot: []byte("root4")},
+	}
+	batch, err := MineBatchOnTip(context.Background(), blockchain, files, verify.SHA256, privateKey, 4, 2, 1, nil, nil)
+	if err != nil {
+		t.Fatalf("MineBatchOnTip() failed with error: %v", err)
+	}
+	if !bytes.Equal(batch.MerkelRoot, verify.RecordsRoot(verify.SHA256, files)) {
+		t.Errorf("FAIL: Batch block does not commit to the root of its records")
+	}
+
+	// Records that do not match the block's Merkle root are refused
+	tampered := *batch
+	tampered.Files = files[1:]
+	if err := blockchain.AddValidBlock(&tampered, 4); err == nil {
+		t.Errorf("FAIL: Batch block with tampered records was accepted")
+	}
+	if err := blockchain.AddValidBlock(batch, 4); err != nil {
+		t.Fatalf("FAIL: Batch block was refused: %v", err)
+	}
+
+	// Every file of the batch can be looked up, versioned and named like a file committed on its own
+	for _, file := range files {
+		block, err := blockchain.GetBlockByMerkelRoot(file.MerkleRoot)
+		if err != nil || block.Index != batch.Index {
+			t.Errorf("FAIL: Expected file %s to be committed in the batch block, got %v", file.MerkleRoot, err)
+		}
+	}
+	history, err := blockchain.History([]byte("root2"))
+	if err != nil || len(history) != 2 || history[1].Name != "photos" || !bytes.Equal(history[0].MerkleRoot, []byte("root1")) {
+		t.Errorf("FAIL: Expected the batched file to be the second version, got %+v (%v)", history, err)
+	}
+	claim, err := blockchain.ResolveName("documents", -1)
+	if err != nil || !bytes.Equal(claim.MerkleRoot, []byte("root3")) {
+		t.Errorf("FAIL: Expected the batched name to resolve to its file, got %+v (%v)", claim, err)
+	}
+	state, err := blockchain.stateSummary(batch.Index)
+	if err != nil || state.Files != 4 || state.Names != 2 {
+		t.Errorf("FAIL: Expected four files and two names, got %+v (%v)", state, err)
+	}
+
+	// The records survive the wire encoding
+	encoded, _ := batch.MarshalBinary()
+	decoded := &Block{}
+	if err := decoded.UnmarshalBinary(encoded); err != nil || len(decoded.Files) != 3 ||
+		!bytes.Equal(decoded.calculateHash(), batch.Hash) {
+		t.Errorf("FAIL: Batch block did not survive the wire encoding: %+v (%v)", decoded, err)
+	}
+}
+
+// Tests that only the uploader of a file can delete it, and only once
+func TestBlockchain_CheckTombstone(t *testing.T) {
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	owner, other := []byte("owner key"), []byte("other key")
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	blockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"), UploaderPublicKey: owner})
+
+	tombstone := func(uploader []byte, merkleRoot []byte) *Block {
+		return &Block{Index: 2, Hash: []byte("hash2"), MerkelRoot: TombstoneRoot(merkleRoot), UploaderPublicKey: uploader,
+			Records: Records{Tombstone: merkleRoot}}
+	}
+	if err := blockchain.CheckTombstone(tombstone(other, []byte("root1"))); !errors.Is(err, ErrNotUploader) {
+		t.Errorf("FAIL: Expected ErrNotUploader for a tombstone by another uploader, got %v", err)
+	}
+	if err := blockchain.CheckTombstone(tombstone(owner, []byte("unknown"))); err == nil {
+		t.Errorf("FAIL: Tombstone deleting an unknown file was accepted")
+	}
+	malformed := tombstone(owner, []byte("root1"))
+	malformed.MerkelRoot = []byte("root1")
+	if err := blockchain.CheckTombstone(malformed); !errors.Is(err, ErrMalformedTombstone) {
+		t.Errorf("FAIL: Expected ErrMalformedTombstone for a tombstone with the wrong Merkle root, got %v", err)
+	}
+
+	block := tombstone(owner, []byte("root1"))
+	if err := blockchain.CheckTombstone(block); err != nil {
+		t.Fatalf("FAIL: Uploader could not delete its own file: %v", err)
+	}
+	blockchain.AddBlock(block)
+	if found, err := blockchain.FindTombstone([]byte("root1")); err != nil || found.Index != 2 {
+		t.Errorf("FAIL: Expected the file's tombstone to be found, got %v", err)
+	}
+	again := tombstone(owner, []byte("root1"))
+	again.Index = 3
+	if err := blockchain.CheckTombstone(again); !errors.Is(err, ErrAlreadyDeleted) {
+		t.Errorf("FAIL: Expected ErrAlreadyDeleted when deleting a file twice, got %v", err)
+	}
+}
+
+// Tests that a file cannot be taken over by another uploader re-committing its root and then deleting it
+func TestBlockchain_RecommitRoot(t *testing.T) {
+	_, ownerKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(1700000000, 0)))
+	mine := func(key ed25519.PrivateKey, merkleRoot []byte, records Records) *Block {
+		block, err := MineOnTip(context.Background(), blockchain, merkleRoot, records, verify.SHA256, key, 4, 2, 1, nil,
+			nil)
+		if err != nil {
+			t.Fatalf("MineOnTip() failed with error: %v", err)
+		}
+		return block
+	}
+	file := mine(ownerKey, []byte("root1"), Records{})
+	if err := blockchain.AddValidBlock(file, 4); err != nil {
+		t.Fatalf("FAIL: Failed to add the file: %v", err)
+	}
+
+	recommit := mine(otherKey, []byte("root1"), Records{})
+	if err := blockchain.AddValidBlock(recommit, 4); !errors.Is(err, ErrRootOwned) {
+		t.Fatalf("FAIL: Expected ErrRootOwned when another uploader re-commits a root, got %v", err)
+	}
+	// Light clients enforce the same rule on the headers they keep
+	light := NewBlockchain(NewMemoryChainStore())
+	light.HeadersOnly = true
+	genesis, _ := blockchain.GetBlockByHeight(0)
+	light.AddBlock(genesis)
+	unsigned := *file
+	unsigned.Signature = nil
+	if added, err := light.AddHeaders([]*Block{&unsigned}, 4); !errors.Is(err, verify.ErrBadSignature) || added != 0 {
+		t.Errorf("FAIL: Expected a light client to refuse an unsigned header, got %d (error %v)", added, err)
+	}
+	if added, err := light.AddHeaders([]*Block{file, recommit}, 4); !errors.Is(err, ErrRootOwned) || added != 1 {
+		t.Errorf("FAIL: Expected a light client to refuse the re-committing header, got %d (error %v)", added, err)
+	}
+	tombstone := Records{Tombstone: []byte("root1")}
+	if err := blockchain.AddValidBlock(mine(otherKey, TombstoneRoot([]byte("root1")), tombstone), 4); !errors.Is(err,
+		ErrNotUploader) {
+		t.Errorf("FAIL: Expected ErrNotUploader for a tombstone by another uploader, got %v", err)
+	}
+	if err := blockchain.AddValidBlock(mine(ownerKey, []byte("root1"), Records{}), 4); err != nil {
+		t.Errorf("FAIL: Uploader could not re-commit its own file: %v", err)
+	}
+	if err := blockchain.AddValidBlock(mine(ownerKey, TombstoneRoot([]byte("root1")), tombstone), 4); err != nil {
+		t.Errorf("FAIL: Uploader could not delete its own file: %v", err)
+	}
+}
+
+// Tests that only the uploader of a committed file can record agreements to store it, under the root of the agreements,
+// and only with the signature of each provider
+func TestBlockchain_Agreements(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	uploader, _ := UploaderPeerID(publicKey)
+	// Each provider signs the terms of its agreement with its identity key
+	sign := func(agreement Agreement) Agreement {
+		_, providerKey, _ := ed25519.GenerateKey(rand.Reader)
+		privKey, _, _ := crypto.KeyPairFromStdKey(&providerKey)
+		provider, _ := peer.IDFromPrivateKey(privKey)
+		agreement.Provider = provider.String()
+		agreement.Signature, _ = privKey.Sign(verify.AgreementTerms(agreement))
+		return agreement
+	}
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(NewGenesisBlock(time.Unix(1700000000, 0)))
+	file, err := MineOnTip(context.Background(), blockchain, []byte("root1"), Records{}, verify.SHA256, privateKey, 4, 2,
+		1, nil, nil)
+	if err != nil || blockchain.AddValidBlock(file, 4) != nil {
+		t.Fatalf("FAIL: Failed to add the file: %v", err)
+	}
+
+	agreements := []Agreement{
+		sign(Agreement{Uploader: uploader, MerkleRoot: []byte("root1"), Duration: 3600, Replication: 2}),
+		sign(Agreement{Uploader: uploader, MerkleRoot: []byte("root1"), Duration: 3600, Replication: 2}),
+	}
+	mine := func(key ed25519.PrivateKey, agreements []Agreement) *Block {
+		block, err := MineOnTip(context.Background(), blockchain, AgreementsRoot(verify.SHA256, agreements),
+			Records{Agreements: agreements}, verify.SHA256, key, 4, 2, 1, nil, nil)
+		if err != nil {
+			t.Fatalf("MineOnTip() failed with error: %v", err)
+		}
+		return block
+	}
+	if err := blockchain.AddValidBlock(mine(otherKey, agreements), 4); !errors.Is(err, ErrAgreementUploader) {
+		t.Errorf("FAIL: Expected ErrAgreementUploader for agreements recorded by another uploader, got %v", err)
+	}
+	unknown := []Agreement{sign(Agreement{Uploader: uploader, MerkleRoot: []byte("unknown"), Duration: 1,
+		Replication: 1})}
+	if err := blockchain.AddValidBlock(mine(privateKey, unknown), 4); err == nil {
+		t.Errorf("FAIL: Agreement to store an unknown file was accepted")
+	}
+	misnamed := []Agreement{sign(Agreement{Uploader: "uploader", MerkleRoot: []byte("root1"), Duration: 1,
+		Replication: 1})}
+	if err := blockchain.AddValidBlock(mine(privateKey, misnamed), 4); !errors.Is(err, ErrAgreementUploader) {
+		t.Errorf("FAIL: Expected ErrAgreementUploader for an agreement naming another uploader, got %v", err)
+	}
+	// Terms changed after the provider signed them are no longer covered by its signature
+	forged := sign(Agreement{Uploader: uploader, MerkleRoot: []byte("root1"), Duration: 1, Replication: 1})
+	forged.Duration = 3600
+	if err := blockchain.AddValidBlock(mine(privateKey, []Agreement{forged}), 4); !errors.Is(err, ErrAgreementSignature) {
+		t.Errorf("FAIL: Expected ErrAgreementSignature for an agreement its provider did not sign, got %v", err)
+	}
+
+	block := mine(privateKey, agreements)
+	tampered := *block
+	tampered.Agreements = agreements[1:]
+	if err := blockchain.AddValidBlock(&tampered, 4); err == nil {
+		t.Errorf("FAIL: Block with tampered agreements was accepted")
+	}
+	if err := blockchain.AddValidBlock(block, 4); err != nil {
+		t.Fatalf("FAIL: Agreements block was refused: %v", err)
+	}
+	records, err := blockchain.Agreements([]byte("root1"))
+	if err != nil || len(records) != 2 || records[1].Provider != agreements[1].Provider || records[0].BlockIndex != block.Index ||
+		!records[0].Expiry.Equal(block.Timestamp.Add(time.Hour)) {
+		t.Errorf("FAIL: Expected both agreements to run for an hour from their block, got %+v (%v)", records, err)
+	}
+
+	// The agreements survive the wire encoding
+	encoded, _ := block.MarshalBinary()
+	decoded := &Block{}
+	if err := decoded.UnmarshalBinary(encoded); err != nil || len(decoded.Agreements) != 2 ||
+		!bytes.Equal(decoded.Agreements[0].Signature, agreements[0].Signature) ||
+		!bytes.Equal(decoded.calculateHash(), block.Hash) {
+		t.Errorf("FAIL: Agreements block did not survive the wire encoding: %+v (%v)", decoded, err)
+	}
+}
+
+// Tests that capabilities only grant access to their grantee, until they expire, when issued by the file's uploader
+func TestBlockchain_VerifyCapability(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	blockchain := NewBlockchain(NewMemoryChainStore())
+	blockchain.AddBlock(&Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	blockchain.AddBlock(&Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"), UploaderPublicKey: publicKey})
+	manifest := &Manifest{MerkleRoot: []byte("root1"), Key: []byte("file key")}
+	now := time.Now()
+
+	capability := NewCapability(privateKey, manifest, "grantee", manifest.Key, now.Add(time.Hour))
+	if capability.Manifest.Key != nil {
+		t.Errorf("FAIL: Capability hands over the key inside the manifest as well as on its own")
+	}
+	if err := blockchain.VerifyCapability(capability, "grantee", now); err != nil {
+		t.Fatalf("FAIL: Valid capability was refused: %v", err)
+	}
+	// Storage nodes are presented the capability without its key, which is still covered by the signature
+	redacted := capability.Redacted()
+	if redacted.Key != nil || redacted.Manifest != nil || blockchain.VerifyCapability(redacted, "grantee", now) != nil {
+		t.Errorf("FAIL: Redacted capability kept its key or was refused")
+	}
+
+	tampered := *capability
+	tampered.Expiry = now.Add(24 * time.Hour)
+	wrongKey := *capability
+	wrongKey.Key = []byte("other key")
+	tests := []struct {
+		name       string
+		capability *Capability
+		grantee    string
+		now        time.Time
+		expected   error
+	}{
+		{"another grantee", capability, "someone else", now, ErrCapabilityGrantee},
+		{"expired", capability, "grantee", now.Add(2 * time.Hour), ErrCapabilityExpired},
+		{"tampered", &tampered, "grantee", now, ErrCapabilitySignature},
+		{"wrong key", &wrongKey, "grantee", now, ErrCapabilityKey},
+		{"other issuer", NewCapability(otherKey, manifest, "grantee", manifest.Key, now.Add(time.Hour)), "grantee", now,
+			ErrCapabilityIssuer},
+	}
+	for _, test := range tests {
+		if err := blockchain.VerifyCapability(test.capability, test.grantee, test.now); !errors.Is(err, test.expected) {
+			t.Errorf("FAIL: Expected %v for a capability with %s, got %v", test.expected, test.name, err)
+		}
 	}
 }
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+)
+
+// Function that encodes a block in the canonical wire encoding that blocks are sent between nodes in
+func (block *Block) MarshalBinary() ([]byte, error) {
+	return verify.EncodeHeader(block.header()), nil
+}
+
+// Function that decodes a block from the canonical wire encoding, rejecting any other encoding of it
+func (block *Block) UnmarshalBinary(encoded []byte) error {
+	header, err := verify.DecodeHeader(encoded)
+	if err != nil {
+		return err
+	}
+	*block = Block{
+		Index:             header.Index,
+		Timestamp:         header.Timestamp,
+		MerkelRoot:        header.MerkleRoot,
+		PrevHash:          header.PrevHash,
+		Hash:              header.Hash,
+		Nonce:             header.Nonce,
+		UploaderPublicKey: header.UploaderPublicKey,
+		Signature:         header.Signature,
+		Pruned:            header.Pruned,
+		HashAlgorithm:     header.HashAlgorithm,
+		Version:           header.Version,
+		Extensions:        header.Extensions,
+		Files:             header.Files,
+		Records: Records{Name: header.Name, PrevVersion: header.PrevVersion, Tombstone: header.Tombstone,
+			Agreements: header.Agreements},
+		RecordsHash: header.RecordsHash,
+	}
+	return nil
+}
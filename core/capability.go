@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Errors returned when a capability does not grant access to a file
+var (
+	ErrCapabilitySignature = errors.New("capability is not signed by its issuer")
+	ErrCapabilityExpired   = errors.New("capability has expired")
+	ErrCapabilityGrantee   = errors.New("capability was granted to another peer")
+	ErrCapabilityIssuer    = errors.New("capability was not issued by the uploader of the file")
+	ErrCapabilityKey       = errors.New("capability's key does not match the key it was signed for")
+)
+
+// Capability - Structure granting a peer read access to an encrypted file until it expires
+// The capability is signed by the uploader of the file over everything but the key itself, which is covered through
+// its hash. The grantee keeps the key to decrypt the file's chunks, and presents the capability without it (see
+// Redacted) to storage nodes, which check it before serving the chunks.
+type Capability struct {
+	MerkleRoot []byte    `json:"merkleRoot"`         // Merkle root of the file access is granted to
+	Grantee    string    `json:"grantee"`            // Peer ID of the peer granted access
+	Expiry     time.Time `json:"expiry"`             // Time the capability stops granting access
+	KeyHash    []byte    `json:"keyHash"`            // SHA-256 hash of the key the file's chunks are encrypted with
+	Key        []byte    `json:"key,omitempty"`      // Key the file's chunks are encrypted with (left out when presented)
+	Issuer     []byte    `json:"issuer"`             // Public key of the uploader that issued the capability
+	Signature  []byte    `json:"signature"`          // Issuer's signature over the capability
+	Manifest   *Manifest `json:"manifest,omitempty"` // Manifest of the file, needed by the grantee to fetch its chunks
+}
+
+// Function that mints a capability granting a peer access to a file, signed with the uploader's key
+func NewCapability(privateKey ed25519.PrivateKey, manifest *Manifest, grantee string, key []byte,
+	expiry time.Time) *Capability {
+	keyHash := sha256.Sum256(key)
+	// The capability carries the key itself, so the manifest it is handed over with does not need to
+	shared := *manifest
+	shared.Key = nil
+	capability := &Capability{
+		MerkleRoot: manifest.MerkleRoot,
+		Grantee:    grantee,
+		Expiry:     expiry.UTC(),
+		KeyHash:    keyHash[:],
+		Key:        key,
+		Issuer:     privateKey.Public().(ed25519.PublicKey),
+		Manifest:   &shared,
+	}
+	capability.Signature = ed25519.Sign(privateKey, capability.signedContents())
+	return capability
+}
+
+// Function that encodes the fields of a capability covered by its signature
+func (capability *Capability) signedContents() []byte {
+	var contents bytes.Buffer
+	contents.WriteString("capability:")
+	for _, field := range [][]byte{capability.MerkleRoot, []byte(capability.Grantee), capability.KeyHash} {
+		contents.Write(binary.AppendUvarint(nil, uint64(len(field))))
+		contents.Write(field)
+	}
+	contents.Write(binary.BigEndian.AppendUint64(nil, uint64(capability.Expiry.UnixNano())))
+	return contents.Bytes()
+}
+
+// Function that returns a copy of the capability without the key or manifest, to be presented to storage nodes
+func (capability *Capability) Redacted() *Capability {
+	redacted := *capability
+	redacted.Key = nil
+	redacted.Manifest = nil
+	return &redacted
+}
+
+// Function that checks that a capability is signed by its issuer, grants access to a peer and has not expired
+func (capability *Capability) Verify(grantee string, now time.Time) error {
+	if len(capability.Issuer) != ed25519.PublicKeySize ||
+		!ed25519.Verify(capability.Issuer, capability.signedContents(), capability.Signature) {
+		return ErrCapabilitySignature
+	}
+	if capability.Key != nil {
+		keyHash := sha256.Sum256(capability.Key)
+		if !bytes.Equal(keyHash[:], capability.KeyHash) {
+			return ErrCapabilityKey
+		}
+	}
+	if capability.Grantee != grantee {
+		return ErrCapabilityGrantee
+	}
+	if now.After(capability.Expiry) {
+		return ErrCapabilityExpired
+	}
+	return nil
+}
+
+// Function that checks that a capability grants a peer access to a file committed by the capability's issuer
+func (blockchain *Blockchain) VerifyCapability(capability *Capability, grantee string, now time.Time) error {
+	err := capability.Verify(grantee, now)
+	if err != nil {
+		return err
+	}
+	uploader, err := blockchain.FileUploader(capability.MerkleRoot)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(uploader, capability.Issuer) {
+		return ErrCapabilityIssuer
+	}
+	return nil
+}
+
+// Function that writes a capability to a file, to be handed to its grantee
+// File permissions 0600 means only the file owner can read the key the capability carries
+func WriteCapability(path string, capability *Capability) error {
+	jsonCapability, err := json.MarshalIndent(capability, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, jsonCapability, 0600)
+}
+
+// Function that reads a capability handed over by the uploader of a file
+func ReadCapability(path string) (*Capability, error) {
+	jsonCapability, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	capability := &Capability{}
+	err = json.Unmarshal(jsonCapability, capability)
+	if err != nil {
+		return nil, err
+	}
+	return capability, nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+// Error returned when a chain store does not hold a requested block
+var ErrBlockNotFound = errors.New("block not found in the chain store")
+
+// Error returned when a block is stored at a height beyond the next one
+var ErrBlockGap = errors.New("blocks must be added to the chain store in height order")
+
+// ChainStore - Interface for storage of the blockchain, so that different backends are interchangeable
+// Blocks are stored individually by height. A block can be added at the next height or replace the block at an
+// existing height, but never leave a gap.
+type ChainStore interface {
+	PutBlock(block *Block) error                       // Stores a block at its height
+	GetByHash(hash []byte) (*Block, error)             // Returns the block with a hash
+	GetByHeight(height int64) (*Block, error)          // Returns the block at a height
+	GetByMerkleRoot(merkleRoot []byte) (*Block, error) // Returns the block committing to a Merkle root
+	Iterate(fn func(block *Block) error) error         // Calls fn on every block in height order, stopping at the first error
+	Head() (*Block, error)                             // Returns the block at the greatest height
+}
+
+// MemoryChainStore - Chain store keeping every block in memory
+type MemoryChainStore struct {
+	blocks         []*Block
+	blocksByHash   map[string]*Block
+	blocksByMerkle map[string]*Block
+}
+
+// Function that creates an empty in-memory chain store
+func NewMemoryChainStore() *MemoryChainStore {
+	return &MemoryChainStore{
+		blocks:         []*Block{},
+		blocksByHash:   make(map[string]*Block),
+		blocksByMerkle: make(map[string]*Block),
+	}
+}
+
+// Function that stores a block at its height, replacing any block already at that height
+func (store *MemoryChainStore) PutBlock(block *Block) error {
+	height := int64(len(store.blocks))
+	if block.Index > height {
+		return ErrBlockGap
+	}
+	if block.Index < height {
+		replaced := store.blocks[block.Index]
+		delete(store.blocksByHash, hex.EncodeToString(replaced.Hash))
+		for _, root := range replaced.CommittedRoots() {
+			delete(store.blocksByMerkle, hex.EncodeToString(root))
+		}
+		store.blocks[block.Index] = block
+	} else {
+		store.blocks = append(store.blocks, block)
+	}
+	store.blocksByHash[hex.EncodeToString(block.Hash)] = block
+	for _, root := range block.CommittedRoots() {
+		store.blocksByMerkle[hex.EncodeToString(root)] = block
+	}
+	return nil
+}
+
+// Function that returns the block with a hash
+func (store *MemoryChainStore) GetByHash(hash []byte) (*Block, error) {
+	block, found := store.blocksByHash[hex.EncodeToString(hash)]
+	if !found {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+// Function that returns the block at a height
+func (store *MemoryChainStore) GetByHeight(height int64) (*Block, error) {
+	if height < 0 || height >= int64(len(store.blocks)) {
+		return nil, ErrBlockNotFound
+	}
+	return store.blocks[height], nil
+}
+
+// Function that returns the block committing to a Merkle root
+func (store *MemoryChainStore) GetByMerkleRoot(merkleRoot []byte) (*Block, error) {
+	block, found := store.blocksByMerkle[hex.EncodeToString(merkleRoot)]
+	if !found {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+// Function that calls fn on every block in height order
+func (store *MemoryChainStore) Iterate(fn func(block *Block) error) error {
+	for _, block := range store.blocks {
+		err := fn(block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that returns the block at the greatest height
+func (store *MemoryChainStore) Head() (*Block, error) {
+	if len(store.blocks) == 0 {
+		return nil, ErrBlockNotFound
+	}
+	return store.blocks[len(store.blocks)-1], nil
+}
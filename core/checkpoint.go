@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error returned when a block conflicts with a checkpoint
+var ErrCheckpointMismatch = errors.New("block diverges from the chain before a checkpoint")
+
+// Checkpoint - Hash that the block at a fixed height must have
+// Blocks up to the latest checkpoint are known to be part of the chain, so they do not need their signatures checked
+// again (nor their proof of work when the chain is validated) and no fork below the checkpoint is accepted
+type Checkpoint struct {
+	Height int64  // Height of the checkpointed block
+	Hash   []byte // Hash the block at that height must have
+}
+
+// Checkpoints built into every node, extended with any given on the command line
+var DefaultCheckpoints = []Checkpoint{}
+
+// Function that parses a checkpoint written as <height>:<hex encoded hash>
+func ParseCheckpoint(value string) (Checkpoint, error) {
+	heightValue, hashValue, found := strings.Cut(value, ":")
+	if !found {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint %q: expected <height>:<hash>", value)
+	}
+	height, err := strconv.ParseInt(heightValue, 10, 64)
+	if err != nil || height < 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint height %q", heightValue)
+	}
+	hash, err := hex.DecodeString(hashValue)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint hash %q", hashValue)
+	}
+	return Checkpoint{Height: height, Hash: hash}, nil
+}
+
+// Function that returns the height of the latest checkpoint (-1 if there are no checkpoints)
+func (blockchain *Blockchain) LatestCheckpoint() int64 {
+	return blockchain.latestCheckpoint()
+}
+
+// Function that returns the height of the latest checkpoint without taking the lock, as the checkpoints never change
+// once the blockchain is in use
+func (blockchain *Blockchain) latestCheckpoint() int64 {
+	latest := int64(-1)
+	for _, checkpoint := range blockchain.Checkpoints {
+		if checkpoint.Height > latest {
+			latest = checkpoint.Height
+		}
+	}
+	return latest
+}
+
+// Function that checks whether a block agrees with the checkpoints
+// A block at a checkpointed height must have the checkpointed hash, and a block below the latest checkpoint cannot
+// replace a different block that is already held, as that would fork the chain before the checkpoint
+func (blockchain *Blockchain) checkCheckpoints(block *Block) error {
+	for _, checkpoint := range blockchain.Checkpoints {
+		if checkpoint.Height == block.Index && !bytes.Equal(checkpoint.Hash, block.Hash) {
+			return ErrCheckpointMismatch
+		}
+	}
+	if block.Index < blockchain.latestCheckpoint() {
+		existing, err := blockchain.Store.GetByHeight(block.Index)
+		if err == nil && !bytes.Equal(existing.Hash, block.Hash) {
+			return ErrCheckpointMismatch
+		}
+	}
+	return nil
+}
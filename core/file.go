@@ -1,12 +1,47 @@
 package core
 
 import (
+	"fmt"
 	"io"
 	"os"
 )
 
+// Bounds of the chunk sizes picked for files. Small files get small chunks so that padding a private upload wastes
+// little space, while large files get larger chunks so that their manifests stay small.
+const (
+	MinChunkSize = 256 * 1024       // Smallest chunk size picked automatically (256KB)
+	MaxChunkSize = 64 * 1024 * 1024 // Largest chunk size of any file (64MB)
+)
+
+// Number of chunks a file is aimed to be split into when its chunk size is picked automatically
+const targetChunkCount = 1024
+
+// Function that picks the chunk size for a file of the given size
+// The chunk size is the smallest power of two that splits the file into at most targetChunkCount chunks, bounded by
+// MinChunkSize and MaxChunkSize
+func ChooseChunkSize(fileSize int64) int64 {
+	chunkSize := int64(MinChunkSize)
+	for chunkSize < MaxChunkSize && chunkSize*targetChunkCount < fileSize {
+		chunkSize *= 2
+	}
+	return chunkSize
+}
+
+// Function that checks that a chunk size given explicitly can be used
+func ValidateChunkSize(chunkSize int64) error {
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return fmt.Errorf("invalid chunk size: %d bytes. The chunk size must be between 1 byte and %d bytes", chunkSize, MaxChunkSize)
+	}
+	return nil
+}
+
 // Function that chunks a file given a filepath and a chunk size in MB
 func ChunkFile(filepath string, chunkSizeMB int64) ([][]byte, error) {
+	return ChunkFileBytes(filepath, chunkSizeMB*1024*1024)
+}
+
+// Function that chunks a file given a filepath and a chunk size in bytes
+func ChunkFileBytes(filepath string, chunkSize int64) ([][]byte, error) {
 	// Open the file and check for any errors. Defer the closing of the file for when the function returns
 	file, err := os.Open(filepath)
 	if err != nil {
@@ -16,7 +51,7 @@ func ChunkFile(filepath string, chunkSizeMB int64) ([][]byte, error) {
 
 	// Declare the chunks array and a buffer to hold the read chunks
 	var chunks [][]byte
-	buffer := make([]byte, chunkSizeMB*1024*1024)
+	buffer := make([]byte, chunkSize)
 	for {
 		// Read the amount of bytes allowed in the buffer
 		bytesRead, err := file.Read(buffer)
@@ -60,3 +95,26 @@ func BuildFile(filepath string, chunks [][]byte) error {
 
 	return nil
 }
+
+// Function that reads a file chunk by chunk, calling a function with each chunk as soon as it has been read
+// Only one chunk is held in memory at a time, as the same buffer is reused for every chunk, so the function must copy
+// any chunk it wants to keep after it returns
+func StreamChunks(reader io.Reader, chunkSize int64, handleChunk func(chunk []byte) error) error {
+	buffer := make([]byte, chunkSize)
+	for {
+		// Unlike a single read, ReadFull only returns a short chunk at the end of the file
+		bytesRead, err := io.ReadFull(reader, buffer)
+		if bytesRead > 0 {
+			chunkErr := handleChunk(buffer[:bytesRead])
+			if chunkErr != nil {
+				return chunkErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	// Every node can verify blocks and files hashed with BLAKE3
+	_ "blockchain-storage/verify/blake3hash"
+)
+
+// Function that returns a hash algorithm as it is recorded in blocks and manifests
+// SHA-256 is recorded as the empty name, so blocks and manifests using it are encoded (and hashed) as they were before
+// hash algorithms were recorded
+func recordedAlgorithm(algorithm verify.HashAlgorithm) verify.HashAlgorithm {
+	if algorithm.Canonical() == verify.SHA256 {
+		return ""
+	}
+	return algorithm
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"errors"
+)
+
+// Function that checks how many of a list of headers validly extend the blockchain, in order from its tip
+// Only the hash, link, proof of work and signature of each header are checked, which is cheap compared to fetching
+// and checking full blocks, so a chain of headers can be checked before any of its blocks are downloaded. The error
+// explains why the first header that does not extend the chain was refused (nil if every header does).
+func (blockchain *Blockchain) ValidateHeaders(headers []*Block, difficulty uint) (int, error) {
+	prevBlock := blockchain.LastBlock()
+	if prevBlock == nil {
+		return 0, errors.New("blockchain is empty")
+	}
+	trusted := blockchain.LatestCheckpoint()
+	prev := prevBlock.header()
+	for i, block := range headers {
+		header := block.header()
+		err := verify.Block(header, prev, difficulty, trusted)
+		if err != nil {
+			return i, &verify.ChainError{Index: block.Index, Err: err}
+		}
+		prev = header
+	}
+	return len(headers), nil
+}
+
+// Function that adds headers validly extending the blockchain in place of their blocks, returning how many were added
+// Only a blockchain keeping nothing but headers can be extended this way, as the files of the blocks are never checked.
+// Every header above the latest checkpoint must carry its uploader's signature, so the uploaders the rules on what
+// blocks record are enforced against are authenticated. The rules only see the records the headers keep, which for
+// blocks of verify.HashVersionRecords is just their Merkle root. The error explains why the first header that was not
+// added was refused (nil if every one was).
+func (blockchain *Blockchain) AddHeaders(headers []*Block, difficulty uint) (int, error) {
+	if !blockchain.HeadersOnly {
+		return 0, errors.New("blockchain keeps full blocks")
+	}
+	blockchain.mutex.Lock()
+	defer blockchain.mutex.Unlock()
+	prevBlock := blockchain.lastBlock()
+	if prevBlock == nil {
+		return 0, errors.New("blockchain is empty")
+	}
+	trusted := blockchain.latestCheckpoint()
+	prev := prevBlock.header()
+	for i, block := range headers {
+		header := block.Header()
+		err := verify.Block(header.header(), prev, difficulty, trusted)
+		if err != nil {
+			return i, &verify.ChainError{Index: block.Index, Err: err}
+		}
+		err = blockchain.checkRules(header)
+		if err != nil {
+			return i, &verify.ChainError{Index: block.Index, Err: err}
+		}
+		err = blockchain.addBlock(header)
+		if err != nil {
+			return i, err
+		}
+		prev = header.header()
+	}
+	return len(headers), nil
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Function that loads the node's Ed25519 identity key from a file, generating and saving a new one if it does not exist
+// The key is stored as the hex encoded 32-byte seed
+func LoadIdentityKey(filepath string) (ed25519.PrivateKey, error) {
+	hexSeed, err := os.ReadFile(filepath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		// No identity exists yet so generate a new key pair and save it
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		// File permissions 0600 means only the file owner can read and write the key
+		err = os.WriteFile(filepath, []byte(hex.EncodeToString(privateKey.Seed())), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(hexSeed)))
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("identity key file does not contain a valid Ed25519 seed")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"fmt"
+)
+
+// Function that builds the proof that a chunk of a file belongs to the file committed in the blockchain, for auditors
+// to check with verify.Inclusion
+// The Merkle proof is built from the chunk hashes in the file's manifest, and the headers run from the block committing
+// the file up to at most the given number of blocks after it (0 runs up to the tip).
+func (blockchain *Blockchain) InclusionProof(manifest *Manifest, chunkIndex int,
+	confirmations int) (*verify.InclusionProof, error) {
+	if chunkIndex < 0 || chunkIndex >= len(manifest.ChunkHashes) {
+		return nil, fmt.Errorf("file has no chunk %d", chunkIndex)
+	}
+	block, err := blockchain.GetBlockByMerkelRoot(manifest.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	// The tree is built with the block's algorithm, as that is the one the proof is checked with
+	tree, err := NewMerkleTreeFromHashesWith(block.HashAlgorithm.Canonical(), manifest.ChunkHashes)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tree.Root.Hash, manifest.MerkleRoot) {
+		return nil, verify.ErrCommitmentInvalid
+	}
+
+	proof := &verify.InclusionProof{
+		MerkleRoot: manifest.MerkleRoot,
+		ChunkIndex: chunkIndex,
+		ChunkCount: len(manifest.ChunkHashes),
+		ChunkHash:  manifest.ChunkHashes[chunkIndex],
+		Proof:      tree.GenerateMerkleProof(chunkIndex),
+	}
+	to := int64(-1)
+	if confirmations > 0 {
+		to = block.Index + int64(confirmations)
+	}
+	err = blockchain.Iterate(block.Index, to, func(block *Block) error {
+		proof.Headers = append(proof.Headers, block.header())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
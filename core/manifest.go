@@ -0,0 +1,172 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Manifest - Structure describing an uploaded file and how to reassemble it from its chunks
+type Manifest struct {
+	Alias       string   `json:"alias"`       // Human-readable name the file was uploaded under
+	FileName    string   `json:"fileName"`    // Original name of the file
+	FileSize    int64    `json:"fileSize"`    // Size of the original file in bytes
+	ChunkSize   int64    `json:"chunkSize"`   // Size of every chunk (apart from possibly the last) in bytes
+	ChunkHashes [][]byte `json:"chunkHashes"` // Hashes of the chunks in file order
+	MerkleRoot  []byte   `json:"merkleRoot"`  // Merkle root of the chunks, which is committed to in a block
+	Padding     int64    `json:"padding"`     // Number of random bytes padding the last chunk to the full chunk size
+
+	HashAlgorithm verify.HashAlgorithm `json:"hashAlgorithm,omitempty"` // Algorithm of the chunk hashes (SHA-256 if empty)
+	Tags          []string             `json:"tags,omitempty"`          // Labels the file was uploaded with, used to search the chain
+	AutoChunkSize bool                 `json:"autoChunkSize,omitempty"` // Whether the chunk size was picked from the file size
+
+	// Key the file's chunks were encrypted with before they were hashed, which only the uploader and the peers it
+	// granted access to hold (empty if the file is not encrypted, or if the key is held in the node's keystore)
+	Key       []byte `json:"key,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"` // Whether the file's chunks are encrypted
+
+	// Compression the uploader stored the file's chunks with ("zstd", empty if they were stored as is)
+	// Chunk hashes, and so the Merkle root, are always of the uncompressed chunks, so a file keeps the same identity
+	// however each node stores its chunks and however they are sent between nodes
+	Compression string `json:"compression,omitempty"`
+}
+
+// Function that creates a manifest for a file given its chunks and the Merkle tree built from them
+func NewManifest(alias string, fileName string, chunkSize int64, chunks [][]byte, merkleTree *MerkleTree) *Manifest {
+	manifest := &Manifest{
+		Alias:      alias,
+		FileName:   fileName,
+		ChunkSize:  chunkSize,
+		MerkleRoot: merkleTree.Root.Hash,
+
+		HashAlgorithm: recordedAlgorithm(merkleTree.Algorithm),
+	}
+	for i, chunk := range chunks {
+		manifest.FileSize += int64(len(chunk))
+		manifest.ChunkHashes = append(manifest.ChunkHashes, merkleTree.Leaves[i].Hash)
+	}
+	return manifest
+}
+
+// Function that pads the last chunk of a file with random bytes up to the full chunk size, returning the padding length
+// Every chunk of a padded file has the same size, so observers of the network cannot infer the file's exact size from
+// its chunks. Random bytes are used so that padded chunks of different files cannot be matched against each other.
+func PadChunks(chunks [][]byte, chunkSize int64) ([][]byte, int64, error) {
+	if len(chunks) == 0 {
+		return chunks, 0, nil
+	}
+	last := chunks[len(chunks)-1]
+	padding := chunkSize - int64(len(last))
+	if padding <= 0 {
+		return chunks, 0, nil
+	}
+
+	randomBytes := make([]byte, padding)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+	padded := append(chunks[:len(chunks)-1:len(chunks)-1], append(append([]byte{}, last...), randomBytes...))
+	return padded, padding, nil
+}
+
+// Function that strips the padding recorded in the manifest from the last of a file's chunks
+// The chunks must have been checked with VerifyChunks first, as the padding is covered by the Merkle root
+func (manifest *Manifest) Unpad(chunks [][]byte) ([][]byte, error) {
+	if manifest.Padding == 0 {
+		return chunks, nil
+	}
+	if len(chunks) == 0 || manifest.Padding < 0 || manifest.Padding > int64(len(chunks[len(chunks)-1])) {
+		return nil, errors.New("manifest padding does not fit in the file's last chunk")
+	}
+	last := chunks[len(chunks)-1]
+	unpadded := append(chunks[:len(chunks)-1:len(chunks)-1], last[:int64(len(last))-manifest.Padding])
+	return unpadded, nil
+}
+
+// Function that returns the file's Merkle root as a multihash, naming the algorithm of its Merkle tree
+func (manifest *Manifest) RootMultihash() (verify.Multihash, error) {
+	return verify.NewMultihash(manifest.HashAlgorithm, manifest.MerkleRoot)
+}
+
+// Function that returns the hash of the chunk at a position in the file as a multihash
+func (manifest *Manifest) ChunkMultihash(index int) (verify.Multihash, error) {
+	if index < 0 || index >= len(manifest.ChunkHashes) {
+		return nil, fmt.Errorf("file has no chunk %d", index)
+	}
+	return verify.NewMultihash(manifest.HashAlgorithm, manifest.ChunkHashes[index])
+}
+
+// Function that returns the CID of the file, which identifies it by its Merkle root
+func (manifest *Manifest) CID() (string, error) {
+	multihash, err := manifest.RootMultihash()
+	if err != nil {
+		return "", err
+	}
+	return verify.EncodeMultihashCID(verify.CodecFile, multihash), nil
+}
+
+// Function that returns the CID of the chunk at a position in the file
+func (manifest *Manifest) ChunkCID(index int) (string, error) {
+	multihash, err := manifest.ChunkMultihash(index)
+	if err != nil {
+		return "", err
+	}
+	return verify.EncodeMultihashCID(verify.CodecChunk, multihash), nil
+}
+
+// Function that checks whether a set of chunks reassembles the file described by the manifest
+func (manifest *Manifest) VerifyChunks(chunks [][]byte) bool {
+	if len(chunks) != len(manifest.ChunkHashes) || len(chunks) == 0 {
+		return false
+	}
+	merkleTree, err := NewMerkleTreeWith(manifest.HashAlgorithm, chunks)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(merkleTree.Root.Hash, manifest.MerkleRoot)
+}
+
+// Function that checks every chunk of a file on its own with a Merkle proof built from the chunk hashes in the
+// manifest, returning whether each chunk belongs to the file with the manifest's Merkle root
+// Unlike VerifyChunks this shows which chunks are bad, so that a download can report where each chunk failed
+func (manifest *Manifest) VerifyChunkProofs(chunks [][]byte) []bool {
+	results := make([]bool, len(chunks))
+	if len(chunks) != len(manifest.ChunkHashes) || len(chunks) == 0 || !manifest.HashAlgorithm.Available() {
+		return results
+	}
+	merkleTree := newMerkleTreeFromHashes(manifest.HashAlgorithm.Canonical(), manifest.ChunkHashes)
+	for i, chunk := range chunks {
+		proof := merkleTree.GenerateMerkleProof(i)
+		results[i] = verify.MerkleProofWith(manifest.HashAlgorithm, chunk, manifest.MerkleRoot, proof)
+	}
+	return results
+}
+
+// Function that resolves which of several versions of a file was current at a given block height
+// The current version is the one committed in the highest block at or below the height. Versions that are not
+// committed in the blockchain are ignored. A negative height resolves the latest version.
+func (blockchain *Blockchain) ResolveManifestAtHeight(versions []*Manifest, height int64) (*Manifest, error) {
+	var resolved *Manifest
+	var resolvedIndex int64 = -1
+	for _, manifest := range versions {
+		block, err := blockchain.GetBlockByMerkelRoot(manifest.MerkleRoot)
+		if err != nil {
+			continue
+		}
+		if height >= 0 && block.Index > height {
+			continue
+		}
+		if block.Index > resolvedIndex {
+			resolved = manifest
+			resolvedIndex = block.Index
+		}
+	}
+
+	if resolved == nil {
+		return nil, errors.New("no version of the file was committed at or below the given height")
+	}
+	return resolved, nil
+}
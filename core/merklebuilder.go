@@ -0,0 +1,84 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"errors"
+)
+
+// Error returned when the root of a Merkle tree without any leaves is asked for
+var ErrNoLeaves = errors.New("merkle tree has no leaves")
+
+// MerkleBuilder - Structure computing the Merkle root of a file from its chunk hashes one at a time
+// Only the roots of the complete subtrees that are still waiting for a sibling are kept (one per level at most), so the
+// root of a file with n chunks is computed in O(log n) memory. The root matches the one of NewMerkleTreeWith.
+type MerkleBuilder struct {
+	Algorithm verify.HashAlgorithm // Hash algorithm of the tree (SHA-256 if empty), which must be registered
+
+	pending [][]byte // Root of the complete subtree waiting for a sibling at each level (nil if there is none)
+	count   int      // Number of leaves added so far
+}
+
+// Function that adds the next chunk of a file to the tree
+func (builder *MerkleBuilder) AddChunk(chunk []byte) {
+	hash, _ := builder.Algorithm.Sum(chunk)
+	builder.AddHash(hash)
+}
+
+// Function that adds the hash of the next chunk of a file to the tree
+func (builder *MerkleBuilder) AddHash(hash []byte) {
+	node := append([]byte{}, hash...)
+	// Combine the new node with the waiting subtrees like carrying when adding one to a binary counter
+	level := 0
+	for ; level < len(builder.pending) && builder.pending[level] != nil; level++ {
+		node = builder.hashPair(builder.pending[level], node)
+		builder.pending[level] = nil
+	}
+	if level == len(builder.pending) {
+		builder.pending = append(builder.pending, nil)
+	}
+	builder.pending[level] = node
+	builder.count++
+}
+
+// Function that returns the number of leaves added to the tree
+func (builder *MerkleBuilder) Len() int {
+	return builder.count
+}
+
+// Function that finalises the tree and returns its root
+// At every level of NewMerkleTree an odd node out is paired with itself, so the subtrees still waiting for a sibling
+// are combined from the lowest level up, duplicating a node whenever it is the last one on a level with others
+func (builder *MerkleBuilder) Root() ([]byte, error) {
+	if builder.count == 0 {
+		return nil, ErrNoLeaves
+	}
+	if !builder.Algorithm.Available() {
+		return nil, verify.ErrUnknownHash
+	}
+	var carry []byte
+	for level, node := range builder.pending {
+		higher := false
+		for _, above := range builder.pending[level+1:] {
+			higher = higher || above != nil
+		}
+		switch {
+		case node != nil && carry != nil:
+			carry = builder.hashPair(node, carry)
+		case node != nil || carry != nil:
+			if node == nil {
+				node = carry
+			}
+			if !higher {
+				return node, nil
+			}
+			carry = builder.hashPair(node, node)
+		}
+	}
+	return carry, nil
+}
+
+// Function that calculates the hash of an inner node from the hashes of its children
+func (builder *MerkleBuilder) hashPair(left []byte, right []byte) []byte {
+	hash, _ := builder.Algorithm.Sum(left, right)
+	return hash
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Versions of the compact binary encoding of Merkle trees. SHA-256 trees keep the first version, while trees built
+// with any other hash algorithm are encoded with the algorithm's identifier after the version byte
+const (
+	merkleTreeEncodingVersion          = 1
+	merkleTreeAlgorithmEncodingVersion = 2
+)
+
+// Error returned when an encoded Merkle tree cannot be decoded
+var ErrMalformedMerkleTree = errors.New("encoded Merkle tree is malformed")
+
+// Function that encodes a merkle tree compactly, so that it can be cached on disk between sessions
+// Every inner node can be recomputed from the leaves, so only the leaf hashes are kept: a version byte and the number
+// of leaves (as a uvarint), followed by the 32-byte hash of every leaf
+func (merkleTree *MerkleTree) MarshalBinary() ([]byte, error) {
+	encoded := []byte{merkleTreeEncodingVersion}
+	if merkleTree.Algorithm.Canonical() != verify.SHA256 {
+		id, err := merkleTree.Algorithm.ID()
+		if err != nil {
+			return nil, err
+		}
+		encoded = []byte{merkleTreeAlgorithmEncodingVersion, id}
+	}
+	encoded = binary.AppendUvarint(encoded, uint64(len(merkleTree.Leaves)))
+	for _, leaf := range merkleTree.Leaves {
+		if len(leaf.Hash) != sha256.Size {
+			return nil, ErrMalformedMerkleTree
+		}
+		encoded = append(encoded, leaf.Hash...)
+	}
+	return encoded, nil
+}
+
+// Function that decodes a merkle tree encoded by MarshalBinary, rebuilding its inner nodes from the leaves
+func (merkleTree *MerkleTree) UnmarshalBinary(encoded []byte) error {
+	if len(encoded) == 0 {
+		return ErrMalformedMerkleTree
+	}
+	algorithm := verify.SHA256
+	switch encoded[0] {
+	case merkleTreeEncodingVersion:
+		encoded = encoded[1:]
+	case merkleTreeAlgorithmEncodingVersion:
+		// SHA-256 trees must use the first version, so that every tree has exactly one encoding
+		if len(encoded) < 2 || encoded[1] == 0 {
+			return ErrMalformedMerkleTree
+		}
+		var err error
+		algorithm, err = verify.HashAlgorithmByID(encoded[1])
+		if err != nil {
+			return err
+		}
+		encoded = encoded[2:]
+	default:
+		return ErrMalformedMerkleTree
+	}
+	count, n := binary.Uvarint(encoded)
+	if n <= 0 || n != len(binary.AppendUvarint(nil, count)) {
+		return ErrMalformedMerkleTree
+	}
+	hashes := encoded[n:]
+	if count == 0 || count != uint64(len(hashes)/sha256.Size) || len(hashes)%sha256.Size != 0 {
+		return ErrMalformedMerkleTree
+	}
+
+	var chunkHashes [][]byte
+	for i := 0; i < len(hashes); i += sha256.Size {
+		chunkHashes = append(chunkHashes, hashes[i:i+sha256.Size])
+	}
+	*merkleTree = *newMerkleTreeFromHashes(algorithm, chunkHashes)
+	return nil
+}
+
+// Structure holding the JSON encoding of a merkle tree, which only lists its root and leaf hashes
+type merkleTreeJSON struct {
+	Algorithm verify.HashAlgorithm `json:"algorithm,omitempty"`
+	Root      []byte               `json:"root"`
+	Leaves    [][]byte             `json:"leaves"`
+}
+
+// Function that encodes a merkle tree as JSON
+// Nodes point to their parents, so the tree cannot be encoded field by field
+func (merkleTree *MerkleTree) MarshalJSON() ([]byte, error) {
+	encoded := merkleTreeJSON{Algorithm: recordedAlgorithm(merkleTree.Algorithm), Root: merkleTree.Root.Hash}
+	for _, leaf := range merkleTree.Leaves {
+		encoded.Leaves = append(encoded.Leaves, leaf.Hash)
+	}
+	return json.Marshal(encoded)
+}
+
+// Function that decodes a merkle tree from JSON, checking that the leaves produce the root it lists
+func (merkleTree *MerkleTree) UnmarshalJSON(data []byte) error {
+	var encoded merkleTreeJSON
+	err := json.Unmarshal(data, &encoded)
+	if err != nil {
+		return err
+	}
+	if len(encoded.Leaves) == 0 {
+		return ErrMalformedMerkleTree
+	}
+	if !encoded.Algorithm.Available() {
+		return verify.ErrUnknownHash
+	}
+	decoded := newMerkleTreeFromHashes(encoded.Algorithm, encoded.Leaves)
+	if !bytes.Equal(decoded.Root.Hash, encoded.Root) {
+		return ErrMalformedMerkleTree
+	}
+	*merkleTree = *decoded
+	return nil
+}
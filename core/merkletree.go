@@ -1,15 +1,15 @@
 package core
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"blockchain-storage/verify"
 )
 
 // MerkleTree - Data structure for holding the root node and all leaves of a merkle tree
 // THe list of leaf nodes is in order of file chunks (i.e. chunk i's leaf node can be addressed via MerkleTree.Leaves[i]
 type MerkleTree struct {
-	Root   *MerkleNode
-	Leaves []*MerkleNode
+	Root      *MerkleNode
+	Leaves    []*MerkleNode
+	Algorithm verify.HashAlgorithm // Hash algorithm the tree was built with (SHA-256 if empty)
 }
 
 // MerkleNode - Recursively defined data structure for a binary merkle tree nodes that will hold file chunk hashes
@@ -21,36 +21,72 @@ type MerkleNode struct {
 }
 
 // Function that creates a new non-leaf merkle node given a left and right node
-func newMerkleNode(left, right, parent *MerkleNode) *MerkleNode {
+// The algorithm must already be known to be registered
+func newMerkleNode(algorithm verify.HashAlgorithm, left, right, parent *MerkleNode) *MerkleNode {
 	// The hash of a non-leaf node is the sum of the two leaf nodes' hashes
-	hash := sha256.Sum256(append(left.Hash, right.Hash...))
+	hash, _ := algorithm.Sum(left.Hash, right.Hash)
 	return &MerkleNode{
 		Left:   left,
 		Right:  right,
 		Parent: parent,
-		Hash:   hash[:],
+		Hash:   hash,
 	}
 }
 
 // Function that creates a new leaf merkle node given a file chunk of data that is used for the hash
-func newLeafMerkleNode(fileChunk []byte) *MerkleNode {
-	hash := sha256.Sum256(fileChunk)
+func newLeafMerkleNode(algorithm verify.HashAlgorithm, fileChunk []byte) *MerkleNode {
+	hash, _ := algorithm.Sum(fileChunk)
 	return &MerkleNode{
 		Left:   nil,
 		Right:  nil,
 		Parent: nil,
-		Hash:   hash[:],
+		Hash:   hash,
 	}
 }
 
-// Function that creates a new merkle tree given an array of file chunks
+// Function that creates a new SHA-256 merkle tree given an array of file chunks
 func NewMerkleTree(fileChunks [][]byte) *MerkleTree {
+	merkleTree, _ := NewMerkleTreeWith(verify.SHA256, fileChunks)
+	return merkleTree
+}
+
+// Function that creates a new merkle tree with the given hash algorithm
+func NewMerkleTreeWith(algorithm verify.HashAlgorithm, fileChunks [][]byte) (*MerkleTree, error) {
+	if !algorithm.Available() {
+		return nil, verify.ErrUnknownHash
+	}
 	// For every file chunk, create a leaf merkle node
 	var leafNodes []*MerkleNode
 	for _, chunk := range fileChunks {
-		leafNodes = append(leafNodes, newLeafMerkleNode(chunk))
+		leafNodes = append(leafNodes, newLeafMerkleNode(algorithm, chunk))
 	}
+	return buildMerkleTree(algorithm, leafNodes), nil
+}
+
+// Function that creates a SHA-256 merkle tree from the hashes of a file's chunks, without needing the chunks themselves
+func NewMerkleTreeFromHashes(chunkHashes [][]byte) *MerkleTree {
+	return newMerkleTreeFromHashes(verify.SHA256, chunkHashes)
+}
+
+// Function that creates a merkle tree from the hashes of a file's chunks with the given hash algorithm
+func NewMerkleTreeFromHashesWith(algorithm verify.HashAlgorithm, chunkHashes [][]byte) (*MerkleTree, error) {
+	if !algorithm.Available() {
+		return nil, verify.ErrUnknownHash
+	}
+	return newMerkleTreeFromHashes(algorithm, chunkHashes), nil
+}
 
+// Function that creates a merkle tree from chunk hashes with an algorithm that is known to be registered
+func newMerkleTreeFromHashes(algorithm verify.HashAlgorithm, chunkHashes [][]byte) *MerkleTree {
+	var leafNodes []*MerkleNode
+	for _, hash := range chunkHashes {
+		leafNodes = append(leafNodes, &MerkleNode{Hash: append([]byte{}, hash...)})
+	}
+	return buildMerkleTree(algorithm, leafNodes)
+}
+
+// Function that builds the rest of a merkle tree on top of its leaf nodes
+func buildMerkleTree(algorithm verify.HashAlgorithm, leafNodes []*MerkleNode) *MerkleTree {
 	// The tree will now be built bottom-up
 	// Hence, set the current level to be the slice of leaf nodes
 	currentLevel := leafNodes
@@ -70,7 +106,7 @@ func NewMerkleTree(fileChunks [][]byte) *MerkleTree {
 
 		// Iterate over pairs of nodes, creating the parent node for them
 		for i := 0; i < len(currentLevel); i += 2 {
-			parent := newMerkleNode(currentLevel[i], currentLevel[i+1], nil)
+			parent := newMerkleNode(algorithm, currentLevel[i], currentLevel[i+1], nil)
 			levelAbove = append(levelAbove, parent)
 			currentLevel[i].Parent = parent
 			currentLevel[i+1].Parent = parent
@@ -81,16 +117,14 @@ func NewMerkleTree(fileChunks [][]byte) *MerkleTree {
 	}
 
 	return &MerkleTree{
-		Leaves: leafNodes,
-		Root:   currentLevel[0],
+		Leaves:    leafNodes,
+		Root:      currentLevel[0],
+		Algorithm: algorithm.Canonical(),
 	}
 }
 
 // MerkleProofStep - A structure that holds each step of the Merkle Proof
-type MerkleProofStep struct {
-	Hash []byte // The hash of the current step in the proof
-	Left bool   // A boolean value indicating whether the hash corresponds to a left child node
-}
+type MerkleProofStep = verify.ProofStep
 
 // This function is used to generate a merkle proof for any file chunk
 func (merkleTree *MerkleTree) GenerateMerkleProof(chunkIndex int) []MerkleProofStep {
@@ -114,18 +148,5 @@ func (merkleTree *MerkleTree) GenerateMerkleProof(chunkIndex int) []MerkleProofS
 
 // This function is used to verify a merkle proof for any file chunk
 func ValidateMerkleProof(data []byte, merkleRoot []byte, merkleProof []MerkleProofStep) bool {
-	// Calculate the hash of the data received
-	hash := sha256.Sum256(data)
-
-	// Loop over every single step in the received proof
-	for _, proofStep := range merkleProof {
-		// If the hash corresponds to a left node, prepend the proof hash to the current hash
-		if proofStep.Left {
-			hash = sha256.Sum256(append(proofStep.Hash, hash[:]...))
-		} else {
-			// If the hash corresponds to a right node, append the proof hash to the current hash
-			hash = sha256.Sum256(append(hash[:], proofStep.Hash...))
-		}
-	}
-	return bytes.Equal(hash[:], merkleRoot)
+	return verify.MerkleProof(data, merkleRoot, merkleProof)
 }
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"blockchain-storage/logging"
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"math"
+)
+
+// Logger of the mining component
+var minerLogger = logging.Component(logging.Miner)
+
+// Function that mines and signs a block for a pending upload on top of the current tip of the blockchain
+// If a block at the same (or a greater) height arrives on newTips while mining, the local block would be a guaranteed
+// orphan, so mining is aborted and restarted on top of the new tip. The caller is responsible for adding received
+// blocks to the blockchain before sending them on newTips. A nil newTips channel disables pre-emption.
+// The Merkle root must have been calculated with the given hash algorithm, which the block records along with the
+// given records about the file.
+// Mining progress is reported to progress (if not nil), starting again from zero whenever mining restarts on a new tip.
+func MineOnTip(ctx context.Context, blockchain *Blockchain, merkelRoot []byte, records Records, algorithm verify.HashAlgorithm, identityKey ed25519.PrivateKey, difficulty uint, workers int, retries int, newTips <-chan *Block, progress func(MiningProgress)) (*Block, error) {
+	create := func(publicKey ed25519.PublicKey) *Block {
+		return CreateBlock(blockchain, merkelRoot, records, publicKey, algorithm)
+	}
+	return mineOnTip(ctx, create, identityKey, difficulty, workers, retries, newTips, progress)
+}
+
+// Function that mines and signs a block committing several pending uploads on top of the current tip of the blockchain
+// Every file's Merkle root must have been calculated with the given hash algorithm, and the block is pre-empted by new
+// tips in the same way as MineOnTip
+func MineBatchOnTip(ctx context.Context, blockchain *Blockchain, files []FileRecord, algorithm verify.HashAlgorithm, identityKey ed25519.PrivateKey, difficulty uint, workers int, retries int, newTips <-chan *Block, progress func(MiningProgress)) (*Block, error) {
+	create := func(publicKey ed25519.PublicKey) *Block {
+		return CreateBatchBlock(blockchain, files, publicKey, algorithm)
+	}
+	return mineOnTip(ctx, create, identityKey, difficulty, workers, retries, newTips, progress)
+}
+
+// Function that mines and signs the block create makes on top of the current tip, creating it again on every new tip
+func mineOnTip(ctx context.Context, create func(ed25519.PublicKey) *Block, identityKey ed25519.PrivateKey, difficulty uint, workers int, retries int, newTips <-chan *Block, progress func(MiningProgress)) (_ *Block, err error) {
+	ctx, span := tracing.Start(ctx, "core.mine", attribute.Int("difficulty", int(difficulty)),
+		attribute.Int("workers", workers))
+	defer func() { tracing.End(span, err) }()
+
+	publicKey := identityKey.Public().(ed25519.PublicKey)
+	for {
+		// Create the block on top of whatever the current tip is
+		block := create(publicKey)
+
+		// Mining a block that every other node would reject is wasted work
+		if minedSize(block) > MaxBlockSize {
+			return nil, ErrBlockTooLarge
+		}
+
+		preempted, err := mineUntilPreempted(ctx, block, difficulty, workers, retries, newTips, progress)
+		if err != nil {
+			return nil, err
+		}
+		if preempted {
+			span.AddEvent("preempted", trace.WithAttributes(attribute.Int64("block", block.Index)))
+			minerLogger.Info("mining pre-empted by a new tip, restarting on top of it", "block", block.Index)
+			continue
+		}
+
+		// Mining succeeded so sign the block as its uploader
+		err = block.Sign(identityKey)
+		if err != nil {
+			return nil, err
+		}
+		span.SetAttributes(attribute.Int64("block", block.Index), attribute.Int("nonce", block.Nonce))
+		minerLogger.Info("mined block", "block", block.Index, "nonce", block.Nonce)
+		return block, nil
+	}
+}
+
+// Function that returns the size a block will have once it is mined and signed
+// Room is reserved for the hash, the longest nonce and the signature, which are only filled in by mining and signing
+func minedSize(block *Block) int {
+	reserved := *block
+	reserved.Hash = make([]byte, sha256.Size)
+	reserved.Nonce = math.MaxInt
+	reserved.Signature = make([]byte, ed25519.SignatureSize)
+	return reserved.Size()
+}
+
+// Function that mines a block until it either succeeds, fails, or is pre-empted by a new tip at the same height
+func mineUntilPreempted(ctx context.Context, block *Block, difficulty uint, workers int, retries int, newTips <-chan *Block, progress func(MiningProgress)) (bool, error) {
+	// Mine in the background so that new tips can be watched for at the same time
+	mineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- block.MineWithProgress(mineCtx, difficulty, workers, retries, progress)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			return false, err
+		case tip := <-newTips:
+			// Blocks below the height being mined do not affect the current work
+			if tip.Index < block.Index {
+				continue
+			}
+			// Abort the current work and wait for the workers to stop before rebasing on the new tip
+			cancel()
+			<-done
+			return true, nil
+		}
+	}
+}
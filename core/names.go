@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"sort"
+)
+
+// Errors returned by the name registry
+var (
+	ErrInvalidName  = errors.New("names must be 1 to 63 lowercase letters, digits, dots, dashes or underscores, starting with a letter or digit")
+	ErrNameTaken    = errors.New("name is already claimed by another uploader")
+	ErrNameNotFound = errors.New("name has not been claimed")
+)
+
+// Pattern every claimed name must match
+// Names are at most 63 characters long, so they can never be mistaken for a hex encoded Merkle root
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// NameClaim - Structure describing a name in the registry that blocks claim names for their files in
+// The first block claiming a name makes its uploader the owner of the name. Only the owner can claim the name again,
+// which points the name at the file of the newer block, and blocks claiming a name owned by another uploader are
+// rejected by every node.
+type NameClaim struct {
+	Name         string `json:"name"`         // Name that was claimed
+	Owner        []byte `json:"owner"`        // Public key of the uploader that first claimed the name
+	MerkleRoot   []byte `json:"merkleRoot"`   // Merkle root of the file the name currently points at
+	Height       int64  `json:"height"`       // Height of the block that last claimed the name
+	ClaimedSince int64  `json:"claimedSince"` // Height of the block that first claimed the name
+}
+
+// Function that checks whether a name follows the naming rules
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// Function that builds the name registry as it was at a given block height (a negative height builds it at the tip)
+// The lock must be held. Claims that break the registry's rules are skipped, although nodes never accept blocks carrying them
+func (blockchain *Blockchain) nameRegistry(height int64) (map[string]*NameClaim, error) {
+	registry := make(map[string]*NameClaim)
+	err := blockchain.Store.Iterate(func(block *Block) error {
+		if height >= 0 && block.Index > height {
+			return nil
+		}
+		for _, file := range block.FileRecords() {
+			if file.Name == "" || ValidateName(file.Name) != nil {
+				continue
+			}
+			claim, found := registry[file.Name]
+			if !found {
+				claim = &NameClaim{Name: file.Name, Owner: block.UploaderPublicKey, ClaimedSince: block.Index}
+				registry[file.Name] = claim
+			} else if !bytes.Equal(claim.Owner, block.UploaderPublicKey) {
+				continue
+			}
+			claim.MerkleRoot = file.MerkleRoot
+			claim.Height = block.Index
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Function that resolves what a name pointed at as of a given block height (a negative height resolves it at the tip)
+func (blockchain *Blockchain) ResolveName(name string, height int64) (*NameClaim, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.resolveName(name, height)
+}
+
+// Function that resolves what a name pointed at while the lock is held
+func (blockchain *Blockchain) resolveName(name string, height int64) (*NameClaim, error) {
+	registry, err := blockchain.nameRegistry(height)
+	if err != nil {
+		return nil, err
+	}
+	claim, found := registry[name]
+	if !found {
+		return nil, ErrNameNotFound
+	}
+	return claim, nil
+}
+
+// Function that lists every claimed name, sorted by name
+func (blockchain *Blockchain) Names() ([]NameClaim, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	registry, err := blockchain.nameRegistry(-1)
+	if err != nil {
+		return nil, err
+	}
+	claims := make([]NameClaim, 0, len(registry))
+	for _, claim := range registry {
+		claims = append(claims, *claim)
+	}
+	sort.Slice(claims, func(i, j int) bool { return claims[i].Name < claims[j].Name })
+	return claims, nil
+}
+
+// Function that checks whether an uploader may claim a name on top of the current tip
+// A name may be claimed if it follows the naming rules and is either unclaimed or already owned by the uploader
+func (blockchain *Blockchain) CanClaimName(name string, uploader []byte) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.canClaimName(name, uploader)
+}
+
+// Function that checks whether an uploader may claim a name while the lock is held
+func (blockchain *Blockchain) canClaimName(name string, uploader []byte) error {
+	err := ValidateName(name)
+	if err != nil {
+		return err
+	}
+	claim, err := blockchain.resolveName(name, -1)
+	if errors.Is(err, ErrNameNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(claim.Owner, uploader) {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// Function that checks whether a block may claim the names it carries (if any) on top of the current tip
+func (blockchain *Blockchain) CheckNameClaim(block *Block) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.checkNameClaim(block)
+}
+
+// Function that checks whether a block may claim the names it carries while the lock is held
+func (blockchain *Blockchain) checkNameClaim(block *Block) error {
+	for _, file := range block.FileRecords() {
+		if file.Name == "" {
+			continue
+		}
+		err := blockchain.canClaimName(file.Name, block.UploaderPublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned when a chain snapshot fails verification
+var (
+	ErrSnapshotSignature = errors.New("chain snapshot is not signed by its creator")
+	ErrSnapshotMismatch  = errors.New("chain snapshot does not match its blocks")
+	ErrSnapshotUntrusted = errors.New("chain snapshot is not covered by a trusted checkpoint")
+)
+
+// StateSummary - Structure summarising the state the chain builds up to a block
+type StateSummary struct {
+	Files      int `json:"files"`      // Number of files committed by blocks (excluding tombstones)
+	Names      int `json:"names"`      // Number of names claimed in the name registry
+	Tombstones int `json:"tombstones"` // Number of files deleted by tombstone blocks
+}
+
+// ChainSnapshot - Structure describing the chain up to a block, signed by the node that created it
+// A node far behind its peers can fetch a snapshot along with the blocks it covers, check them against its checkpoints
+// and proof of work, and then only sync the recent blocks after the snapshot in full
+type ChainSnapshot struct {
+	Height          int64        `json:"height"`          // Height of the last block covered by the snapshot
+	HeadHash        []byte       `json:"headHash"`        // Hash of the last block covered by the snapshot
+	State           StateSummary `json:"state"`           // State of the chain as of the last block
+	CreatedAt       time.Time    `json:"createdAt"`       // Time the snapshot was created
+	SignerPublicKey []byte       `json:"signerPublicKey"` // Ed25519 public key of the node that created the snapshot
+	Signature       []byte       `json:"signature"`       // Creator's signature over the snapshot's contents
+}
+
+// Function that creates a snapshot of the chain up to a height, signed with the given key, along with every block it
+// covers as the blockchain stores it
+// Pruned blocks are returned as their headers, which may have dropped their records, so the state is summarised from
+// the same blocks that are returned and can be checked against them.
+func (blockchain *Blockchain) CreateSnapshot(height int64, signingKey ed25519.PrivateKey, now time.Time) (*ChainSnapshot, []*Block, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	if height < 0 || height >= int64(blockchain.length()) {
+		return nil, nil, ErrBlockNotFound
+	}
+	blocks := make([]*Block, 0, height+1)
+	for i := int64(0); i <= height; i++ {
+		block, err := blockchain.Store.GetByHeight(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	state, err := blockchain.stateSummary(height)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot := &ChainSnapshot{
+		Height:          height,
+		HeadHash:        blocks[height].Hash,
+		State:           state,
+		CreatedAt:       now.UTC(),
+		SignerPublicKey: signingKey.Public().(ed25519.PublicKey),
+	}
+	snapshot.Signature = ed25519.Sign(signingKey, snapshot.signedContents())
+	return snapshot, blocks, nil
+}
+
+// Function that summarises the state of the chain as of a height while the lock is held
+func (blockchain *Blockchain) stateSummary(height int64) (StateSummary, error) {
+	var state StateSummary
+	err := blockchain.Store.Iterate(func(block *Block) error {
+		if block.Index == 0 || block.Index > height {
+			return nil
+		}
+		if len(block.Tombstone) > 0 {
+			state.Tombstones++
+		} else {
+			state.Files += len(block.FileRecords())
+		}
+		return nil
+	})
+	if err != nil {
+		return StateSummary{}, err
+	}
+	registry, err := blockchain.nameRegistry(height)
+	if err != nil {
+		return StateSummary{}, err
+	}
+	state.Names = len(registry)
+	return state, nil
+}
+
+// Function that encodes the contents of a snapshot covered by its signature
+// Every field has a fixed width or an explicit length, so no two different snapshots share an encoding
+func (snapshot *ChainSnapshot) signedContents() []byte {
+	contents := binary.BigEndian.AppendUint64(nil, uint64(snapshot.Height))
+	contents = binary.BigEndian.AppendUint32(contents, uint32(len(snapshot.HeadHash)))
+	contents = append(contents, snapshot.HeadHash...)
+	for _, count := range []int{snapshot.State.Files, snapshot.State.Names, snapshot.State.Tombstones} {
+		contents = binary.BigEndian.AppendUint64(contents, uint64(count))
+	}
+	contents = binary.BigEndian.AppendUint64(contents, uint64(snapshot.CreatedAt.UnixNano()))
+	hash := sha256.Sum256(contents)
+	return hash[:]
+}
+
+// Function that checks the snapshot was signed by the key it records
+func (snapshot *ChainSnapshot) VerifySignature() bool {
+	if len(snapshot.SignerPublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(snapshot.SignerPublicKey, snapshot.signedContents(), snapshot.Signature)
+}
+
+// Function that verifies a snapshot and adds the blocks it covers to the blockchain, returning how many were new
+// The blocks (any of which may be pruned to their headers) must form a chain from the local genesis block to the
+// snapshot's head that meets the difficulty and agrees with every checkpoint up to the head, and must build up the
+// state the snapshot summarises. A snapshot is signed by whichever peer served it, so only one reaching no further
+// than a checkpoint is trusted. The blocks are then imported with ImportBlocks, which enforces the rules on what each
+// of them records.
+func (blockchain *Blockchain) ApplySnapshot(snapshot *ChainSnapshot, blocks []*Block, difficulty uint) (int, error) {
+	if !snapshot.VerifySignature() {
+		return 0, ErrSnapshotSignature
+	}
+	if blockchain.latestCheckpoint() < snapshot.Height {
+		return 0, ErrSnapshotUntrusted
+	}
+	if int64(len(blocks)) != snapshot.Height+1 || !bytes.Equal(blocks[snapshot.Height].Hash, snapshot.HeadHash) {
+		return 0, fmt.Errorf("%w: expected blocks up to height %d", ErrSnapshotMismatch, snapshot.Height)
+	}
+	chainHeaders := make([]*verify.Header, len(blocks))
+	for i, block := range blocks {
+		chainHeaders[i] = block.header()
+	}
+	err := verify.Chain(chainHeaders, difficulty, blockchain.latestCheckpoint())
+	if err != nil {
+		return 0, err
+	}
+	for _, checkpoint := range blockchain.Checkpoints {
+		if checkpoint.Height <= snapshot.Height && !bytes.Equal(blocks[checkpoint.Height].Hash, checkpoint.Hash) {
+			return 0, ErrCheckpointMismatch
+		}
+	}
+
+	// The state is summarised from the records the blocks still carry, as the snapshot's creator summarised it
+	covered := NewBlockchain(NewMemoryChainStore())
+	for _, block := range blocks {
+		err := covered.Store.PutBlock(block)
+		if err != nil {
+			return 0, err
+		}
+	}
+	state, err := covered.stateSummary(snapshot.Height)
+	if err != nil {
+		return 0, err
+	}
+	if state != snapshot.State {
+		return 0, fmt.Errorf("%w: state summary differs", ErrSnapshotMismatch)
+	}
+	return blockchain.ImportBlocks(blocks, difficulty)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// Errors returned when deleting files
+var (
+	ErrNotUploader        = errors.New("only the uploader of a file can delete it")
+	ErrAlreadyDeleted     = errors.New("file has already been deleted")
+	ErrMalformedTombstone = errors.New("tombstone must record only the file it deletes")
+)
+
+// Prefix hashed with a deleted file's Merkle root to give the Merkle root of its tombstone
+var tombstonePrefix = []byte("tombstone:")
+
+// Function that returns the Merkle root a tombstone block deleting a file records
+// Every block records a Merkle root, and deriving it from the deleted file keeps it unique while letting the
+// tombstone of a file be looked up like any other block
+func TombstoneRoot(merkleRoot []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{}, tombstonePrefix...), merkleRoot...))
+	return hash[:]
+}
+
+// Function that checks whether the file a tombstone block deletes (if any) may be deleted by it
+// Only the uploader of a file can delete it, and the block deleting it is kept on the chain as a record of the deletion
+func (blockchain *Blockchain) CheckTombstone(block *Block) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.checkTombstone(block)
+}
+
+// Function that checks whether the file a tombstone block deletes may be deleted by it while the lock is held
+func (blockchain *Blockchain) checkTombstone(block *Block) error {
+	if len(block.Tombstone) == 0 {
+		return nil
+	}
+	if block.Name != "" || len(block.PrevVersion) > 0 || !bytes.Equal(block.MerkelRoot, TombstoneRoot(block.Tombstone)) {
+		return ErrMalformedTombstone
+	}
+	fileBlock, err := blockchain.Store.GetByMerkleRoot(block.Tombstone)
+	if errors.Is(err, ErrBlockNotFound) || (err == nil && (fileBlock.Index >= block.Index || len(fileBlock.Tombstone) > 0)) {
+		return errors.New("tombstone does not delete a file committed earlier in the blockchain")
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(fileBlock.UploaderPublicKey, block.UploaderPublicKey) {
+		return ErrNotUploader
+	}
+	_, err = blockchain.Store.GetByMerkleRoot(TombstoneRoot(block.Tombstone))
+	if err == nil {
+		return ErrAlreadyDeleted
+	}
+	if !errors.Is(err, ErrBlockNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Function that returns the tombstone block that deleted a file (ErrBlockNotFound if the file was not deleted)
+func (blockchain *Blockchain) FindTombstone(merkleRoot []byte) (*Block, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.Store.GetByMerkleRoot(TombstoneRoot(merkleRoot))
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned when following the versions of a file
+var (
+	ErrUnknownPrevVersion = errors.New("previous version is not a file committed earlier in the blockchain")
+	ErrForeignPrevVersion = errors.New("previous version was uploaded by another uploader")
+	ErrVersionNotFound    = errors.New("file has no such version")
+)
+
+// FileVersion - Structure describing one version of a file in its version history
+type FileVersion struct {
+	Version    int       `json:"version"`        // Number of the version, counting from 1 for the first version
+	MerkleRoot []byte    `json:"merkleRoot"`     // Merkle root of the version
+	Height     int64     `json:"height"`         // Height of the block committing the version
+	Timestamp  time.Time `json:"timestamp"`      // Time the block committing the version was created
+	Uploader   []byte    `json:"uploader"`       // Public key of the uploader of the version
+	Name       string    `json:"name,omitempty"` // Name the version claimed in the name registry (empty if none)
+}
+
+// Function that checks whether the previous versions a block references (if any) may be extended by it
+// The previous version must be committed in an earlier block by the same uploader, so nobody can graft their file onto
+// the history of another uploader's file
+func (blockchain *Blockchain) CheckPrevVersion(block *Block) error {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	return blockchain.checkPrevVersion(block)
+}
+
+// Function that checks whether the previous versions a block references may be extended by it while the lock is held
+func (blockchain *Blockchain) checkPrevVersion(block *Block) error {
+	for _, file := range block.FileRecords() {
+		if len(file.PrevVersion) == 0 {
+			continue
+		}
+		prevBlock, err := blockchain.Store.GetByMerkleRoot(file.PrevVersion)
+		if errors.Is(err, ErrBlockNotFound) || (err == nil && (prevBlock.Index >= block.Index || len(prevBlock.Tombstone) > 0)) {
+			return ErrUnknownPrevVersion
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(prevBlock.UploaderPublicKey, block.UploaderPublicKey) {
+			return ErrForeignPrevVersion
+		}
+	}
+	return nil
+}
+
+// Function that returns the versions of a file up to and including the one with the given Merkle root, oldest first
+// The history is followed back through the previous version every block references
+func (blockchain *Blockchain) History(merkleRoot []byte) ([]FileVersion, error) {
+	blockchain.mutex.RLock()
+	defer blockchain.mutex.RUnlock()
+	var chain []*Block
+	var records []FileRecord
+	for root := merkleRoot; len(root) > 0; {
+		block, err := blockchain.Store.GetByMerkleRoot(root)
+		if err != nil {
+			return nil, err
+		}
+		// The Merkle root of a block committing several files is the root of their records rather than of a file
+		record, found := block.Record(root)
+		if !found {
+			return nil, ErrBlockNotFound
+		}
+		// Previous versions are always committed in earlier blocks, which also rules out following a loop forever
+		if len(chain) > 0 && block.Index >= chain[len(chain)-1].Index {
+			return nil, ErrUnknownPrevVersion
+		}
+		chain = append(chain, block)
+		records = append(records, record)
+		root = record.PrevVersion
+	}
+
+	history := make([]FileVersion, len(chain))
+	for i, block := range chain {
+		version := len(chain) - i
+		history[version-1] = FileVersion{
+			Version:    version,
+			MerkleRoot: records[i].MerkleRoot,
+			Height:     block.Index,
+			Timestamp:  block.Timestamp,
+			Uploader:   block.UploaderPublicKey,
+			Name:       records[i].Name,
+		}
+	}
+	return history, nil
+}
+
+// Function that returns a numbered version from the history of the file with the given Merkle root
+func (blockchain *Blockchain) FileVersion(merkleRoot []byte, version int) (*FileVersion, error) {
+	history, err := blockchain.History(merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > len(history) {
+		return nil, fmt.Errorf("%w: version %d requested but the file has %d", ErrVersionNotFound, version, len(history))
+	}
+	return &history[version-1], nil
+}
+
+// Function that resolves a claimed name, or a Merkle root committed in the blockchain given hex encoded or as a CID, to
+// a Merkle root
+func (blockchain *Blockchain) ResolveFile(nameOrRoot string) ([]byte, error) {
+	claim, err := blockchain.ResolveName(nameOrRoot, -1)
+	if err == nil {
+		return claim.MerkleRoot, nil
+	}
+	if !errors.Is(err, ErrNameNotFound) {
+		return nil, err
+	}
+	merkleRoot, decodeErr := verify.ParseHash(nameOrRoot)
+	if decodeErr != nil {
+		return nil, fmt.Errorf("%s is neither a claimed name nor a Merkle root", nameOrRoot)
+	}
+	_, err = blockchain.GetBlockByMerkelRoot(merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	return merkleRoot, nil
+}
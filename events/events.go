@@ -0,0 +1,110 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of events a subscription holds for its subscriber before further events are dropped
+const subscriptionBuffer = 64
+
+// Type - Kind of event published on the bus
+type Type string
+
+const (
+	BlockAdded      Type = "BlockAdded"      // A block was added to the blockchain, whether mined locally or received
+	ChunkStored     Type = "ChunkStored"     // A chunk was written to the chunk store
+	PeerConnected   Type = "PeerConnected"   // A handshake with a newly connected peer completed
+	PeerLost        Type = "PeerLost"        // A peer stopped answering heartbeats and was pruned
+	PeerReconnected Type = "PeerReconnected" // A peer whose connection dropped was connected to again
+	ReplicaPlaced   Type = "ReplicaPlaced"   // A peer acknowledged verifying and storing every chunk of a file
+	SyncCompleted   Type = "SyncCompleted"   // The blockchain caught up with the longest chain reported by peers
+)
+
+// Event - Structure describing something that happened in the node
+type Event struct {
+	Type       Type      `json:"type"`                 // Kind of event
+	Time       time.Time `json:"time"`                 // Time the event was published
+	Height     int64     `json:"height,omitempty"`     // Height of the added block, or of the chain's tip once synced
+	Hash       []byte    `json:"hash,omitempty"`       // Hash of the added block or stored chunk
+	MerkleRoot []byte    `json:"merkleRoot,omitempty"` // Merkle root of the file committed in the added block or placed
+	Peer       string    `json:"peer,omitempty"`       // Peer ID of the connected or lost peer, or of the replica's holder
+}
+
+// Bus - Structure passing events from the subsystems publishing them to every interested subscriber
+// Publishing never blocks: a subscriber that falls more than subscriptionBuffer events behind misses the events that
+// do not fit, which are counted on its subscription. A nil bus drops every event, so publishers never need a check.
+type Bus struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+// Subscription - Structure through which a subscriber receives events of the types it subscribed to
+type Subscription struct {
+	bus     *Bus
+	types   map[Type]bool // Types of event delivered (every type if empty)
+	events  chan Event
+	dropped atomic.Uint64
+}
+
+// Function that creates a bus without any subscribers
+func NewBus() *Bus {
+	return &Bus{subscriptions: make(map[*Subscription]struct{})}
+}
+
+// Function that subscribes to events of the given types, or to every event if no types are given
+func (bus *Bus) Subscribe(types ...Type) *Subscription {
+	subscription := &Subscription{bus: bus, types: make(map[Type]bool), events: make(chan Event, subscriptionBuffer)}
+	for _, eventType := range types {
+		subscription.types[eventType] = true
+	}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+// Function that passes an event to every subscriber of its type, stamping it with the current time if it has none
+func (bus *Bus) Publish(event Event) {
+	if bus == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	for subscription := range bus.subscriptions {
+		if len(subscription.types) > 0 && !subscription.types[event.Type] {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			subscription.dropped.Add(1)
+		}
+	}
+}
+
+// Function that returns the channel events are delivered on, which is closed once the subscription ends
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Function that returns how many events were dropped because the subscriber fell behind
+func (subscription *Subscription) Dropped() uint64 {
+	return subscription.dropped.Load()
+}
+
+// Function that ends the subscription (ending it more than once has no effect)
+func (subscription *Subscription) Unsubscribe() {
+	bus := subscription.bus
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if _, found := bus.subscriptions[subscription]; !found {
+		return
+	}
+	delete(bus.subscriptions, subscription)
+	close(subscription.events)
+}
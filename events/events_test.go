@@ -0,0 +1,49 @@
+package events
+
+import (
+	"testing"
+)
+
+// Tests that events reach only the subscribers of their type and that slow subscribers never block publishing
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe()
+	blocks := bus.Subscribe(BlockAdded)
+
+	bus.Publish(Event{Type: BlockAdded, Height: 1})
+	bus.Publish(Event{Type: ChunkStored, Hash: []byte{1}})
+
+	if len(all.Events()) != 2 || len(blocks.Events()) != 1 {
+		t.Fatalf("FAIL: Expected 2 events for the unfiltered subscriber and 1 for the filtered one, got %d and %d",
+			len(all.Events()), len(blocks.Events()))
+	}
+	event := <-blocks.Events()
+	if event.Type != BlockAdded || event.Height != 1 || event.Time.IsZero() {
+		t.Errorf("FAIL: Expected a timestamped BlockAdded event at height 1, got %+v", event)
+	}
+
+	// A subscriber that falls behind misses the events that do not fit in its buffer
+	for i := 0; i < subscriptionBuffer; i++ {
+		bus.Publish(Event{Type: BlockAdded})
+	}
+	if all.Dropped() != 2 || blocks.Dropped() != 0 {
+		t.Errorf("FAIL: Expected 2 dropped events for the unfiltered subscriber and none for the filtered one, got %d and %d",
+			all.Dropped(), blocks.Dropped())
+	}
+
+	// An ended subscription's channel is closed once it is drained and it receives nothing more
+	all.Unsubscribe()
+	all.Unsubscribe()
+	bus.Publish(Event{Type: BlockAdded})
+	count := 0
+	for range all.Events() {
+		count++
+	}
+	if count != subscriptionBuffer {
+		t.Errorf("FAIL: Expected %d buffered events after unsubscribing, got %d", subscriptionBuffer, count)
+	}
+
+	// Publishing on a nil bus does nothing
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: SyncCompleted})
+}
@@ -0,0 +1,326 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Version of the keystore file format
+const fileVersion = 1
+
+// Parameters of the Argon2id key derivation, following the second recommended option of RFC 9106
+const (
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024 // In KiB
+	kdfThreads = 4
+	kdfKeySize = 32
+	saltSize   = 16
+)
+
+// Bounds on the key derivation parameters read from a keystore file, so a corrupted file cannot exhaust memory
+const (
+	maxKDFTime    = 64
+	maxKDFMemory  = 4 * 1024 * 1024 // In KiB
+	maxKDFThreads = 64
+)
+
+// Errors returned when a keystore cannot be opened
+var (
+	ErrWrongPassphrase = errors.New("passphrase does not unlock the keystore")
+	ErrEmptyPassphrase = errors.New("passphrase must not be empty")
+	ErrUnknownVersion  = errors.New("keystore file has an unknown format version")
+	ErrCorrupted       = errors.New("keystore file is corrupted")
+)
+
+// Keystore - Structure holding the node's keys, which are saved to disk encrypted under a passphrase
+// The passphrase is stretched with Argon2id into the key that the contents are encrypted with by AES-256-GCM. Every
+// save uses a new nonce, and changing the passphrase also picks a new salt.
+type Keystore struct {
+	path     string
+	kdf      kdfParams
+	key      []byte // Key derived from the passphrase
+	contents contents
+	mutex    sync.Mutex
+}
+
+// Structure of the keys held in a keystore, which is only ever written to disk encrypted
+type contents struct {
+	Identity    []byte            `json:"identity"`    // Seed of the node's Ed25519 identity key
+	FileKeys    map[string][]byte `json:"fileKeys"`    // Keys encrypted files were encrypted with, keyed by hex encoded Merkle root
+	SigningKeys map[string][]byte `json:"signingKeys"` // Seeds of named Ed25519 signing keys
+}
+
+// Structure of a keystore file
+type keystoreFile struct {
+	Version    int       `json:"version"`    // Version of the file format
+	KDF        kdfParams `json:"kdf"`        // Parameters the passphrase is stretched with
+	Nonce      []byte    `json:"nonce"`      // Nonce the contents were encrypted with
+	Ciphertext []byte    `json:"ciphertext"` // Encrypted contents
+}
+
+// Structure of the parameters the passphrase is stretched with, which are kept so they can be raised in the future
+type kdfParams struct {
+	Algorithm string `json:"algorithm"` // Key derivation function (always argon2id)
+	Salt      []byte `json:"salt"`      // Random salt of the keystore
+	Time      uint32 `json:"time"`      // Number of passes over the memory
+	Memory    uint32 `json:"memory"`    // Memory used in KiB
+	Threads   uint8  `json:"threads"`   // Degree of parallelism
+}
+
+// Summary - Structure describing what a keystore holds without revealing any of the keys
+type Summary struct {
+	IdentityPublicKey string   `json:"identityPublicKey"` // Hex encoded public key of the node's identity key
+	FileKeys          int      `json:"fileKeys"`          // Number of file encryption keys held
+	SigningKeys       []string `json:"signingKeys"`       // Names of the signing keys held, sorted
+}
+
+// Function that checks whether a keystore file exists
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Function that creates a keystore holding an identity key, refusing to overwrite an existing keystore
+func Create(path string, passphrase []byte, identity ed25519.PrivateKey) (*Keystore, error) {
+	if Exists(path) {
+		return nil, os.ErrExist
+	}
+	keystore := &Keystore{
+		path: path,
+		contents: contents{
+			Identity:    identity.Seed(),
+			FileKeys:    make(map[string][]byte),
+			SigningKeys: make(map[string][]byte),
+		},
+	}
+	err := keystore.setPassphrase(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return keystore, keystore.save()
+}
+
+// Function that opens a keystore by decrypting it with its passphrase
+func Open(path string, passphrase []byte) (*Keystore, error) {
+	jsonFile, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file keystoreFile
+	err = json.Unmarshal(jsonFile, &file)
+	if err != nil {
+		return nil, err
+	}
+	if file.Version != fileVersion || file.KDF.Algorithm != "argon2id" {
+		return nil, ErrUnknownVersion
+	}
+	if !file.KDF.valid() {
+		return nil, ErrCorrupted
+	}
+
+	keystore := &Keystore{path: path, kdf: file.KDF, key: deriveKey(passphrase, file.KDF)}
+	gcm, err := newGCM(keystore.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	// The contents are authenticated, so a wrong passphrase is told apart from a corrupted file by the tag failing
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	err = json.Unmarshal(plaintext, &keystore.contents)
+	if err != nil {
+		return nil, err
+	}
+	if keystore.contents.FileKeys == nil {
+		keystore.contents.FileKeys = make(map[string][]byte)
+	}
+	if keystore.contents.SigningKeys == nil {
+		keystore.contents.SigningKeys = make(map[string][]byte)
+	}
+	return keystore, nil
+}
+
+// Function that returns the node's identity key
+func (keystore *Keystore) IdentityKey() ed25519.PrivateKey {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	return ed25519.NewKeyFromSeed(keystore.contents.Identity)
+}
+
+// Function that replaces the node's identity key and saves the keystore
+func (keystore *Keystore) SetIdentityKey(identity ed25519.PrivateKey) error {
+	keystore.mutex.Lock()
+	keystore.contents.Identity = identity.Seed()
+	keystore.mutex.Unlock()
+	return keystore.save()
+}
+
+// Function that adds a named signing key, replacing any key held under the name, and saves the keystore
+func (keystore *Keystore) SetSigningKey(name string, signingKey ed25519.PrivateKey) error {
+	keystore.mutex.Lock()
+	keystore.contents.SigningKeys[name] = signingKey.Seed()
+	keystore.mutex.Unlock()
+	return keystore.save()
+}
+
+// Function that returns the key an encrypted file was encrypted with, if the keystore holds it
+func (keystore *Keystore) FileKey(merkleRoot []byte) ([]byte, bool) {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	key, found := keystore.contents.FileKeys[hex.EncodeToString(merkleRoot)]
+	return key, found
+}
+
+// Function that adds the key an encrypted file was encrypted with and saves the keystore
+func (keystore *Keystore) SetFileKey(merkleRoot []byte, key []byte) error {
+	keystore.mutex.Lock()
+	keystore.contents.FileKeys[hex.EncodeToString(merkleRoot)] = append([]byte{}, key...)
+	keystore.mutex.Unlock()
+	return keystore.save()
+}
+
+// Function that returns a named signing key, if the keystore holds it
+func (keystore *Keystore) SigningKey(name string) (ed25519.PrivateKey, bool) {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	seed, found := keystore.contents.SigningKeys[name]
+	if !found {
+		return nil, false
+	}
+	return ed25519.NewKeyFromSeed(seed), true
+}
+
+// Function that generates a new named signing key, replacing any key held under the name, and saves the keystore
+func (keystore *Keystore) RotateSigningKey(name string) (ed25519.PrivateKey, error) {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return signingKey, keystore.SetSigningKey(name, signingKey)
+}
+
+// Function that re-encrypts the keystore under a new passphrase, with a new salt, and saves it
+func (keystore *Keystore) ChangePassphrase(passphrase []byte) error {
+	keystore.mutex.Lock()
+	err := keystore.setPassphrase(passphrase)
+	keystore.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return keystore.save()
+}
+
+// Function that describes what the keystore holds
+func (keystore *Keystore) Summary() Summary {
+	keystore.mutex.Lock()
+	defer keystore.mutex.Unlock()
+	identity := ed25519.NewKeyFromSeed(keystore.contents.Identity)
+	summary := Summary{
+		IdentityPublicKey: hex.EncodeToString(identity.Public().(ed25519.PublicKey)),
+		FileKeys:          len(keystore.contents.FileKeys),
+		SigningKeys:       []string{},
+	}
+	for name := range keystore.contents.SigningKeys {
+		summary.SigningKeys = append(summary.SigningKeys, name)
+	}
+	sort.Strings(summary.SigningKeys)
+	return summary
+}
+
+// Function that picks a new salt and derives the key the keystore is encrypted with from a passphrase
+func (keystore *Keystore) setPassphrase(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrEmptyPassphrase
+	}
+	salt := make([]byte, saltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	keystore.kdf = kdfParams{Algorithm: "argon2id", Salt: salt, Time: kdfTime, Memory: kdfMemory, Threads: kdfThreads}
+	keystore.key = deriveKey(passphrase, keystore.kdf)
+	return nil
+}
+
+// Function that encrypts the keystore with a new nonce and writes it to its file
+// The file is written in full to a temporary file which then atomically replaces the old one, so a crash mid-write
+// can never lose the keys
+func (keystore *Keystore) save() error {
+	keystore.mutex.Lock()
+	plaintext, err := json.Marshal(keystore.contents)
+	file := keystoreFile{Version: fileVersion, KDF: keystore.kdf}
+	key := keystore.key
+	keystore.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(file.Nonce)
+	if err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+	jsonFile, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// File permissions 0600 means only the file owner can read and write the keystore
+	tempFile, err := os.CreateTemp(filepath.Dir(keystore.path), filepath.Base(keystore.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(jsonFile)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tempFile.Name(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), keystore.path)
+}
+
+// Function that checks whether key derivation parameters are within the bounds a keystore may use
+func (params kdfParams) valid() bool {
+	return params.Time >= 1 && params.Time <= maxKDFTime &&
+		params.Memory >= 8*uint32(params.Threads) && params.Memory <= maxKDFMemory &&
+		params.Threads >= 1 && params.Threads <= maxKDFThreads
+}
+
+// Function that stretches a passphrase into a key with Argon2id
+func deriveKey(passphrase []byte, params kdfParams) []byte {
+	return argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, kdfKeySize)
+}
+
+// Function that creates an AES-GCM cipher from the key a keystore is encrypted with
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
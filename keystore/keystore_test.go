@@ -0,0 +1,126 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that keys held in a keystore survive it being saved and reopened, and that only its passphrase opens it
+func TestKeystore_CreateOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	keystore, err := Create(path, []byte("correct horse"), identity)
+	if err != nil {
+		t.Fatalf("Create() failed with error: %v", err)
+	}
+	if _, err := Create(path, []byte("correct horse"), identity); !errors.Is(err, os.ErrExist) {
+		t.Errorf("FAIL: Expected Create() to refuse to overwrite a keystore, got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("FAIL: Expected the keystore file to only be readable by its owner, got %v (%v)", info.Mode(), err)
+	}
+
+	fileRoot := []byte("merkle root")
+	if err := keystore.SetFileKey(fileRoot, []byte("file key")); err != nil {
+		t.Fatalf("SetFileKey() failed with error: %v", err)
+	}
+	signingKey, err := keystore.RotateSigningKey("release")
+	if err != nil {
+		t.Fatalf("RotateSigningKey() failed with error: %v", err)
+	}
+	contents, _ := os.ReadFile(path)
+	if bytes.Contains(contents, []byte("file key")) || bytes.Contains(contents, identity.Seed()) {
+		t.Errorf("FAIL: Keystore file holds its keys in plaintext")
+	}
+
+	if _, err := Open(path, []byte("wrong horse")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("FAIL: Expected ErrWrongPassphrase when opening with the wrong passphrase, got %v", err)
+	}
+	reopened, err := Open(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Open() failed with error: %v", err)
+	}
+	if !reopened.IdentityKey().Equal(identity) {
+		t.Errorf("FAIL: Reopened keystore does not hold the identity key")
+	}
+	if key, found := reopened.FileKey(fileRoot); !found || string(key) != "file key" {
+		t.Errorf("FAIL: Reopened keystore does not hold the file key, got %q", key)
+	}
+	if key, found := reopened.SigningKey("release"); !found || !key.Equal(signingKey) {
+		t.Errorf("FAIL: Reopened keystore does not hold the signing key")
+	}
+	if _, found := reopened.SigningKey("missing"); found {
+		t.Errorf("FAIL: Keystore returned a signing key it was never given")
+	}
+	summary := reopened.Summary()
+	if summary.FileKeys != 1 || len(summary.SigningKeys) != 1 || summary.SigningKeys[0] != "release" {
+		t.Errorf("FAIL: Unexpected keystore summary %+v", summary)
+	}
+}
+
+// Tests that changing the passphrase re-encrypts the keystore so that only the new passphrase opens it
+func TestKeystore_ChangePassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	keystore, err := Create(path, []byte("old passphrase"), identity)
+	if err != nil {
+		t.Fatalf("Create() failed with error: %v", err)
+	}
+	if err := keystore.ChangePassphrase(nil); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Errorf("FAIL: Expected ErrEmptyPassphrase when changing to an empty passphrase, got %v", err)
+	}
+	if err := keystore.ChangePassphrase([]byte("new passphrase")); err != nil {
+		t.Fatalf("ChangePassphrase() failed with error: %v", err)
+	}
+
+	if _, err := Open(path, []byte("old passphrase")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("FAIL: Old passphrase still opens the keystore (%v)", err)
+	}
+	reopened, err := Open(path, []byte("new passphrase"))
+	if err != nil {
+		t.Fatalf("Open() failed with error: %v", err)
+	}
+	if !reopened.IdentityKey().Equal(identity) {
+		t.Errorf("FAIL: Keystore lost its identity key when the passphrase was changed")
+	}
+}
+
+// Tests that a keystore file with a malformed nonce or out of bounds key derivation parameters is refused instead of
+// panicking or exhausting memory
+func TestKeystore_OpenCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := Create(path, []byte("passphrase"), identity); err != nil {
+		t.Fatalf("Create() failed with error: %v", err)
+	}
+	original, _ := os.ReadFile(path)
+
+	corruptions := map[string]func(file *keystoreFile){
+		"short nonce":  func(file *keystoreFile) { file.Nonce = file.Nonce[:4] },
+		"no threads":   func(file *keystoreFile) { file.KDF.Threads = 0 },
+		"no passes":    func(file *keystoreFile) { file.KDF.Time = 0 },
+		"huge memory":  func(file *keystoreFile) { file.KDF.Memory = ^uint32(0) },
+		"tiny memory":  func(file *keystoreFile) { file.KDF.Memory = 1 },
+		"many threads": func(file *keystoreFile) { file.KDF.Threads = 255 },
+	}
+	for name, corrupt := range corruptions {
+		var file keystoreFile
+		if err := json.Unmarshal(original, &file); err != nil {
+			t.Fatalf("Unmarshal() failed with error: %v", err)
+		}
+		corrupt(&file)
+		contents, _ := json.Marshal(file)
+		if err := os.WriteFile(path, contents, 0600); err != nil {
+			t.Fatalf("WriteFile() failed with error: %v", err)
+		}
+		if _, err := Open(path, []byte("passphrase")); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("FAIL: Expected ErrCorrupted for a keystore with a %s, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Components of the node that log under their own name, given in the component field of every record
+const (
+	Network   = "network"
+	Chain     = "chain"
+	Storage   = "storage"
+	Miner     = "miner"
+	Resources = "resources"
+	API       = "api"
+)
+
+// Output formats that records can be written in
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Least severe level that is logged, shared by every component
+var level = new(slog.LevelVar)
+
+// Handler that records are currently written with, which Configure replaces
+var output atomic.Pointer[slog.Handler]
+
+func init() {
+	// Until the node is configured, warnings and errors are written to stderr as text
+	level.Set(slog.LevelWarn)
+	setOutput(os.Stderr, FormatText)
+}
+
+// Function that returns the logger of a component
+// Loggers can be created before logging is configured, as they always write with the latest configuration
+func Component(name string) *slog.Logger {
+	return slog.New(&switchHandler{}).With("component", name)
+}
+
+// Function that sets the level and format that every component logs with from then on
+func Configure(w io.Writer, levelName string, format string) error {
+	var newLevel slog.Level
+	err := newLevel.UnmarshalText([]byte(levelName))
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: expected debug, info, warn or error", levelName)
+	}
+	format = strings.ToLower(format)
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format %q: expected %s or %s", format, FormatText, FormatJSON)
+	}
+	level.Set(newLevel)
+	setOutput(w, format)
+	return nil
+}
+
+// Function that replaces the handler records are written with
+func setOutput(w io.Writer, format string) {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	output.Store(&handler)
+}
+
+// Type - Structure of a handler that passes records on to the current output handler
+// The attributes and groups added to a logger are kept and applied to whichever handler is current when a record is
+// written, so that loggers held in package variables follow later configuration
+type switchHandler struct {
+	derive []func(slog.Handler) slog.Handler
+}
+
+// Function that returns the current output handler with the logger's attributes and groups applied
+func (handler *switchHandler) current() slog.Handler {
+	current := *output.Load()
+	for _, derive := range handler.derive {
+		current = derive(current)
+	}
+	return current
+}
+
+func (handler *switchHandler) Enabled(_ context.Context, recordLevel slog.Level) bool {
+	return recordLevel >= level.Level()
+}
+
+func (handler *switchHandler) Handle(ctx context.Context, record slog.Record) error {
+	return handler.current().Handle(ctx, record)
+}
+
+func (handler *switchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (handler *switchHandler) WithGroup(name string) slog.Handler {
+	return handler.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// Function that returns a copy of the handler with one more attribute or group change
+func (handler *switchHandler) with(derive func(slog.Handler) slog.Handler) slog.Handler {
+	return &switchHandler{derive: append(handler.derive[:len(handler.derive):len(handler.derive)], derive)}
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Tests that loggers created before logging is configured follow the configured level and format
+func TestConfigure(t *testing.T) {
+	defer Configure(os.Stderr, "warn", FormatText)
+
+	logger := Component(Network).With("peer", "peer_id")
+	var output bytes.Buffer
+	if err := Configure(&output, "info", FormatJSON); err != nil {
+		t.Fatalf("Configure() failed with error: %v", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("shown", "block", 3)
+
+	var record map[string]any
+	if err := json.Unmarshal(output.Bytes(), &record); err != nil {
+		t.Fatalf("FAIL: Expected a single JSON record, got %q", output.String())
+	}
+	if record["msg"] != "shown" || record["component"] != Network || record["peer"] != "peer_id" || record["block"] != 3.0 {
+		t.Errorf("FAIL: Expected the record to hold its component and attributes, got %v", record)
+	}
+
+	output.Reset()
+	if err := Configure(&output, "DEBUG", FormatText); err != nil {
+		t.Fatalf("Configure() failed with error: %v", err)
+	}
+	logger.WithGroup("chunk").Debug("now shown", "hash", "abc")
+	if line := output.String(); !strings.Contains(line, "component=network") || !strings.Contains(line, "chunk.hash=abc") {
+		t.Errorf("FAIL: Expected a text record with the component and grouped attributes, got %q", line)
+	}
+
+	if Configure(&output, "loud", FormatText) == nil || Configure(&output, "info", "xml") == nil {
+		t.Errorf("FAIL: Configure() accepted an invalid level or format")
+	}
+}
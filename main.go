@@ -1,4 +1,6 @@
-package blockchain_storage
+// Command blockchain-storage runs a node of the blockchain storage network and the commands that talk to it.
+// The blocks, blockchain and Merkle trees are implemented once, in the core package, which the commands build on.
+package main
 
 import "blockchain-storage/cmd"
 
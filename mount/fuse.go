@@ -0,0 +1,99 @@
+//go:build linux || darwin
+
+package mount
+
+import (
+	"context"
+	"errors"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"io"
+	"syscall"
+)
+
+// Function that mounts the files of a source read-only at a directory, listing them as of when it is mounted
+func Mount(dir string, source *Source) (Server, error) {
+	entries, err := source.Entries()
+	if err != nil {
+		return nil, err
+	}
+	root := &dirNode{}
+	options := &fs.Options{
+		MountOptions: fuse.MountOptions{FsName: "blockchain-storage", Name: "blockchain-storage", Options: []string{"ro"}},
+		OnAdd: func(ctx context.Context) {
+			dirs := make(map[string]*fs.Inode)
+			for _, dir := range []string{NamesDir, FilesDir} {
+				dirs[dir] = root.NewPersistentInode(ctx, &dirNode{}, fs.StableAttr{Mode: syscall.S_IFDIR})
+				root.AddChild(dir, dirs[dir], false)
+			}
+			for _, entry := range entries {
+				file := root.NewPersistentInode(ctx, &fileNode{source: source, entry: entry}, fs.StableAttr{})
+				dirs[entry.Dir].AddChild(entry.Name, file, false)
+			}
+		},
+	}
+	return fs.Mount(dir, root, options)
+}
+
+// dirNode - Directory of a mount, whose entries are all added when it is mounted
+type dirNode struct {
+	fs.Inode
+}
+
+var _ fs.NodeGetattrer = (*dirNode)(nil)
+
+func (node *dirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFDIR | 0555
+	return fs.OK
+}
+
+// fileNode - File of a mount, whose chunks are read when it is first opened
+type fileNode struct {
+	fs.Inode
+	source *Source
+	entry  Entry
+}
+
+var _ fs.NodeGetattrer = (*fileNode)(nil)
+var _ fs.NodeOpener = (*fileNode)(nil)
+var _ fs.NodeReader = (*fileNode)(nil)
+var _ fs.NodeReleaser = (*fileNode)(nil)
+
+func (node *fileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = syscall.S_IFREG | 0444
+	out.Size = uint64(node.entry.Manifest.FileSize)
+	return fs.OK
+}
+
+func (node *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	file, err := node.source.Open(node.entry.Manifest)
+	if err != nil {
+		logger.Error("error encountered when opening mounted file", "file", node.entry.Name, "error", err)
+		return nil, 0, syscall.EIO
+	}
+	// Files never change once committed, so the kernel may keep what it has read of them
+	return file, fuse.FOPEN_KEEP_CACHE, fs.OK
+}
+
+func (node *fileNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	file, ok := f.(*File)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	n, err := file.ReadAt(ctx, dest, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		logger.Error("error encountered when reading mounted file", "file", node.entry.Name, "error", err)
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:n]), fs.OK
+}
+
+func (node *fileNode) Release(ctx context.Context, f fs.FileHandle) syscall.Errno {
+	if file, ok := f.(*File); ok {
+		file.Close()
+	}
+	return fs.OK
+}
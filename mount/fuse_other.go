@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package mount
+
+import "errors"
+
+// Function that mounts the files of a source, which FUSE is not available for on this platform
+func Mount(dir string, source *Source) (Server, error) {
+	return nil, errors.New("mounting is only supported on Linux and macOS")
+}
@@ -0,0 +1,281 @@
+// Package mount exposes the files stored on the network as a read-only filesystem, reading their chunks on demand.
+package mount
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/logging"
+	"blockchain-storage/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Directories of the mount listing files by the names claimed for them on the chain, and by the aliases they were
+// uploaded under on this node
+const (
+	NamesDir = "names"
+	FilesDir = "files"
+)
+
+// Number of chunks of a file kept in memory, so that the small reads a filesystem splits a chunk into do not read it
+// from the chunk store again each time
+const cachedChunks = 4
+
+// Error returned when an encrypted file is opened, as only files stored as is can be read back through a mount
+var ErrEncryptedFile = errors.New("encrypted files cannot be mounted")
+
+// Server - Mounted filesystem, which serves the files until it is unmounted
+type Server interface {
+	Wait()          // Waits until the filesystem is unmounted
+	Unmount() error // Unmounts the filesystem
+}
+
+// Logger of the storage component, which the mount reads chunks from
+var logger = logging.Component(logging.Storage)
+
+// FetchFunc - Function fetching chunks of the file with a Merkle root from the network into the chunk store
+type FetchFunc func(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte) error
+
+// Source - Structure providing the files a mount exposes and the chunks they are read from
+type Source struct {
+	Chain     *core.Blockchain       // Blockchain the files must be committed on, and that names are resolved with
+	Manifests *storage.ManifestStore // Store of the manifests describing the files
+	Chunks    *storage.ChunkStore    // Store the chunks of the files are read from
+	Fetch     FetchFunc              // Fetches chunks missing from the chunk store (nil only reads local chunks)
+	ReadAhead int                    // Number of chunks fetched ahead of the last one read (0 fetches none ahead)
+}
+
+// Entry - Structure describing a file exposed by a mount
+type Entry struct {
+	Dir      string         // Directory the file is listed in (NamesDir or FilesDir)
+	Name     string         // Name of the file within its directory
+	Manifest *core.Manifest // Manifest of the file
+}
+
+// Function that lists the files the mount exposes, as of the chain and manifests the source holds
+// Every claimed name whose file's manifest is held is listed under NamesDir, and the latest version of every alias is
+// listed under FilesDir. Deleted and encrypted files are left out, as are names pointing at files whose manifests are
+// not held, as there is no way to tell how to reassemble them.
+func (source *Source) Entries() ([]Entry, error) {
+	var entries []Entry
+	claims, err := source.Chain.Names()
+	if err != nil {
+		return nil, err
+	}
+	for _, claim := range claims {
+		manifest, err := source.Manifests.GetManifest(claim.MerkleRoot)
+		if err != nil || !source.mountable(manifest) {
+			continue
+		}
+		entries = append(entries, Entry{Dir: NamesDir, Name: claim.Name, Manifest: manifest})
+	}
+
+	manifests, err := source.Manifests.ListManifests()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string][]*core.Manifest)
+	for _, manifest := range manifests {
+		versions[manifest.Alias] = append(versions[manifest.Alias], manifest)
+	}
+	names := make(map[string]bool)
+	for alias, aliasVersions := range versions {
+		manifest, err := source.Chain.ResolveManifestAtHeight(aliasVersions, -1)
+		if err != nil || !source.mountable(manifest) {
+			continue
+		}
+		// Aliases are free-form, so they are made into valid file names, skipping any that then collide
+		name := fileName(alias)
+		if name == "" || names[name] {
+			continue
+		}
+		names[name] = true
+		entries = append(entries, Entry{Dir: FilesDir, Name: name, Manifest: manifest})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir < entries[j].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Function that checks whether a file can be exposed by the mount
+func (source *Source) mountable(manifest *core.Manifest) bool {
+	if manifest.Encrypted || manifest.Key != nil {
+		return false
+	}
+	_, err := source.Chain.FindTombstone(manifest.MerkleRoot)
+	return err != nil
+}
+
+// Function that turns an alias into a file name, replacing path separators (empty if it cannot be made into one)
+func fileName(alias string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(alias)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// File - Structure reading the contents of a mounted file from its chunks, fetching missing chunks on demand
+type File struct {
+	source   *Source
+	manifest *core.Manifest
+
+	mutex       sync.Mutex
+	cache       map[int][]byte // Recently read chunks by their position in the file
+	cacheOrder  []int          // Positions of the cached chunks, oldest first
+	fetchedUpTo int            // Position up to which chunks have been fetched ahead of reads
+
+	readingAhead sync.WaitGroup     // Read-aheads still fetching chunks in the background
+	ctx          context.Context    // Context of the read-aheads, which ends when the file is closed
+	cancel       context.CancelFunc // Function ending the context of the read-aheads
+}
+
+// Function that opens a file for reading after checking its manifest's chunk hashes against its Merkle root
+// Each chunk is checked against its hash when it is read, so a file can only ever read back as committed on the chain
+func (source *Source) Open(manifest *core.Manifest) (*File, error) {
+	if manifest.Encrypted || manifest.Key != nil {
+		return nil, ErrEncryptedFile
+	}
+	if _, err := source.Chain.GetBlockByMerkelRoot(manifest.MerkleRoot); err != nil {
+		return nil, fmt.Errorf("file %x is not committed on the chain", manifest.MerkleRoot)
+	}
+	if manifest.ChunkSize <= 0 {
+		return nil, fmt.Errorf("manifest of file %x has no chunk size", manifest.MerkleRoot)
+	}
+	builder := core.MerkleBuilder{Algorithm: manifest.HashAlgorithm}
+	for _, chunkHash := range manifest.ChunkHashes {
+		builder.AddHash(chunkHash)
+	}
+	root, err := builder.Root()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(root, manifest.MerkleRoot) {
+		return nil, fmt.Errorf("manifest of file %x does not match its Merkle root", manifest.MerkleRoot)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &File{source: source, manifest: manifest, cache: make(map[int][]byte), fetchedUpTo: -1, ctx: ctx,
+		cancel: cancel}, nil
+}
+
+// Function that closes the file, stopping its read-aheads and waiting for them to end
+func (file *File) Close() {
+	file.cancel()
+	file.readingAhead.Wait()
+}
+
+// Function that returns the size of the file in bytes
+func (file *File) Size() int64 {
+	return file.manifest.FileSize
+}
+
+// Function that reads the file's contents starting at an offset, returning io.EOF once the end of the file is reached
+func (file *File) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	chunkSize := file.manifest.ChunkSize
+	read := 0
+	for read < len(p) && off < file.Size() {
+		index := int(off / chunkSize)
+		if index >= len(file.manifest.ChunkHashes) {
+			return read, io.ErrUnexpectedEOF
+		}
+		chunk, err := file.chunk(ctx, index)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], chunk[off-int64(index)*chunkSize:])
+		if n == 0 {
+			return read, io.ErrUnexpectedEOF
+		}
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// Function that returns the contents of a chunk of the file, without the padding of the last chunk
+func (file *File) chunk(ctx context.Context, index int) ([]byte, error) {
+	file.mutex.Lock()
+	chunk, found := file.cache[index]
+	file.mutex.Unlock()
+	if found {
+		return chunk, nil
+	}
+
+	file.readAhead(index)
+	chunkHash := file.manifest.ChunkHashes[index]
+	chunk, err := file.source.Chunks.GetChunk(chunkHash)
+	if errors.Is(err, fs.ErrNotExist) && file.source.Fetch != nil {
+		err = file.source.Fetch(ctx, file.manifest.MerkleRoot, [][]byte{chunkHash})
+		if err == nil {
+			chunk, err = file.source.Chunks.GetChunk(chunkHash)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Only the last chunk is padded, so the contents of a chunk end at the file's size
+	start := int64(index) * file.manifest.ChunkSize
+	if end := file.Size() - start; end < int64(len(chunk)) {
+		chunk = chunk[:max(end, 0)]
+	}
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+	if _, found := file.cache[index]; !found {
+		file.cache[index] = chunk
+		file.cacheOrder = append(file.cacheOrder, index)
+		if len(file.cacheOrder) > cachedChunks {
+			delete(file.cache, file.cacheOrder[0])
+			file.cacheOrder = file.cacheOrder[1:]
+		}
+	}
+	return chunk, nil
+}
+
+// Function that fetches the chunks after a chunk being read in the background, so that sequential reads find them in
+// the chunk store by the time they reach them
+// The node skips the chunks it already holds, so each chunk is only asked for once however the file is read
+func (file *File) readAhead(index int) {
+	if file.source.Fetch == nil || file.source.ReadAhead <= 0 {
+		return
+	}
+	file.mutex.Lock()
+	from := max(index+1, file.fetchedUpTo+1)
+	to := min(index+file.source.ReadAhead, len(file.manifest.ChunkHashes)-1)
+	if from > to {
+		file.mutex.Unlock()
+		return
+	}
+	file.fetchedUpTo = to
+	file.mutex.Unlock()
+
+	file.readingAhead.Add(1)
+	go func() {
+		defer file.readingAhead.Done()
+		err := file.source.Fetch(file.ctx, file.manifest.MerkleRoot, file.manifest.ChunkHashes[from:to+1])
+		if err != nil {
+			logger.Debug("error encountered when reading ahead", "merkleRoot", fmt.Sprintf("%x", file.manifest.MerkleRoot),
+				"from", from, "to", to, "error", err)
+			// The chunks are fetched on demand instead, and may be read ahead again
+			file.mutex.Lock()
+			file.fetchedUpTo = min(file.fetchedUpTo, from-1)
+			file.mutex.Unlock()
+		}
+	}()
+}
@@ -0,0 +1,93 @@
+package mount
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that a mount lists files by name and alias, and reads them back by fetching the chunks it does not hold
+func TestSource_ReadAt(t *testing.T) {
+	dir := t.TempDir()
+	blockchain := core.NewBlockchain(core.NewMemoryChainStore())
+	blockchain.AddBlock(core.NewGenesisBlock(time.Unix(0, 0)))
+	_, identityKey, _ := ed25519.GenerateKey(rand.Reader)
+	// The file is uploaded into one chunk store and mounted from another, which has to fetch every chunk
+	uploaded, _ := storage.NewChunkStore(filepath.Join(dir, "uploaded"))
+	chunkStore, _ := storage.NewChunkStore(filepath.Join(dir, "chunks"))
+	manifestStore, _ := storage.NewManifestStore(filepath.Join(dir, "manifests"))
+	pinSet, _ := storage.NewPinSet(filepath.Join(dir, "pins.json"))
+	env := upload.NewEnvironment(blockchain, identityKey, uploaded, manifestStore, pinSet)
+
+	contents := "the contents of a file read through a mount"
+	path := filepath.Join(dir, "notes.txt")
+	os.WriteFile(path, []byte(contents), 0644)
+	_, err := upload.Run(context.Background(), env, upload.Params{FilePath: path, Workers: 1, Retries: 1, ChunkSize: 8,
+		Name: "notes"})
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+
+	var fetchMutex sync.Mutex
+	fetched := 0
+	source := &Source{Chain: blockchain, Manifests: manifestStore, Chunks: chunkStore, ReadAhead: 2,
+		Fetch: func(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte) error {
+			fetchMutex.Lock()
+			defer fetchMutex.Unlock()
+			for _, chunkHash := range chunkHashes {
+				chunk, err := uploaded.GetChunk(chunkHash)
+				if err != nil {
+					return err
+				}
+				chunkStore.PutChunk(chunk)
+				fetched++
+			}
+			return nil
+		}}
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries() failed with error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Dir != FilesDir || entries[0].Name != "notes.txt" ||
+		entries[1].Dir != NamesDir || entries[1].Name != "notes" {
+		t.Fatalf("FAIL: Expected the file to be listed by its alias and its name, got %+v", entries)
+	}
+
+	file, err := source.Open(entries[1].Manifest)
+	if err != nil {
+		t.Fatalf("Open() failed with error: %v", err)
+	}
+	defer file.Close()
+	read := make([]byte, 12)
+	n, err := file.ReadAt(context.Background(), read, 5)
+	if err != nil || string(read[:n]) != contents[5:17] {
+		t.Errorf("FAIL: Expected to read %q across chunks, got %q (%v)", contents[5:17], read[:n], err)
+	}
+	read = make([]byte, 2*len(contents))
+	n, err = file.ReadAt(context.Background(), read, 0)
+	if !errors.Is(err, io.EOF) || string(read[:n]) != contents {
+		t.Errorf("FAIL: Expected to read the whole file up to its end, got %q (%v)", read[:n], err)
+	}
+	fetchMutex.Lock()
+	if fetched == 0 {
+		t.Errorf("FAIL: Expected missing chunks to be fetched")
+	}
+	fetchMutex.Unlock()
+
+	encrypted := *entries[1].Manifest
+	encrypted.Encrypted = true
+	if _, err := source.Open(&encrypted); !errors.Is(err, ErrEncryptedFile) {
+		t.Errorf("FAIL: Expected opening an encrypted file to fail with %v, got %v", ErrEncryptedFile, err)
+	}
+}
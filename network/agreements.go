@@ -0,0 +1,125 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// Error returned when a provider refuses to sign a storage agreement
+var ErrAgreementRefused = errors.New("provider refused to sign the storage agreement")
+
+// AgreementRequest - Payload of the message asking a provider to sign a storage agreement for a file it holds
+type AgreementRequest struct {
+	Agreement   core.Agreement `json:"agreement"`   // Terms of the agreement, without a signature
+	ChunkHashes [][]byte       `json:"chunkHashes"` // Hashes of the file's chunks, which the provider must hold
+}
+
+// AgreementReply - Payload of the message answering a request to sign a storage agreement
+type AgreementReply struct {
+	Signature []byte `json:"signature,omitempty"` // Provider's signature over the agreement's terms (empty if refused)
+}
+
+// Function that asks a provider to sign a storage agreement for a file it holds, returning its signature
+// The signature is checked against the provider's identity key before it is returned
+func RequestAgreement(ctx context.Context, provider string, agreement core.Agreement, chunkHashes [][]byte) (signature []byte, err error) {
+	ctx, span := tracing.Start(ctx, "network.request_agreement", attribute.String("peer", provider))
+	defer func() { tracing.End(span, err) }()
+
+	if nodeHost == nil {
+		return nil, errors.New("node has not been started")
+	}
+	peerID, err := peer.Decode(provider)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := nodeHost.NewStream(ctx, peerID, protocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(chunkRequestTimeout))
+
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	agreement.Signature = nil
+	err = writeFlushed(rw, SignAgreement, AgreementRequest{Agreement: agreement, ChunkHashes: chunkHashes})
+	if err != nil {
+		return nil, err
+	}
+	var reply AgreementReply
+	err = readReply(rw.Reader, AgreementSignature, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Signature, checkAgreementSignature(peerID, agreement, reply.Signature)
+}
+
+// Function that checks that a signature over an agreement's terms was made by the provider's identity key
+func checkAgreementSignature(provider peer.ID, agreement core.Agreement, signature []byte) error {
+	if len(signature) == 0 {
+		return ErrAgreementRefused
+	}
+	publicKey, err := provider.ExtractPublicKey()
+	if err != nil {
+		return err
+	}
+	valid, err := publicKey.Verify(verify.AgreementTerms(agreement), signature)
+	if err != nil || !valid {
+		return ErrAgreementRefused
+	}
+	return nil
+}
+
+// Function that handles a request to sign a storage agreement, answering on the same stream
+// The agreement is only signed if it names the node as its provider and the requesting peer as the uploader of the
+// file, and the node holds every chunk of the file
+func handleSignAgreement(rw *bufio.ReadWriter, peerID peer.ID, payload json.RawMessage) {
+	var request AgreementRequest
+	if err := decodePayload(payload, &request); err != nil {
+		logger.Warn("error encountered when unmarshalling agreement request", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+
+	var reply AgreementReply
+	if mayAgree(peerID, request) {
+		agreement := request.Agreement
+		agreement.Signature = nil
+		signature, err := identityKey.Sign(verify.AgreementTerms(agreement))
+		if err != nil {
+			logger.Error("error encountered when signing storage agreement", "error", err)
+		}
+		reply.Signature = signature
+	}
+	err := writeFlushed(rw, AgreementSignature, reply)
+	if err != nil {
+		logger.Error("error encountered when answering agreement request", "error", err)
+	}
+}
+
+// Function that returns whether the node may sign a storage agreement a peer asked it to sign
+func mayAgree(peerID peer.ID, request AgreementRequest) bool {
+	if identityKey == nil || Chunks == nil || len(request.ChunkHashes) == 0 {
+		return false
+	}
+	self, err := peer.IDFromPrivateKey(identityKey)
+	if err != nil || request.Agreement.Provider != self.String() || request.Agreement.Uploader != peerID.String() {
+		return false
+	}
+	if !isUploader(peerID, request.Agreement.MerkleRoot) {
+		return false
+	}
+	for _, hash := range request.ChunkHashes {
+		if !Chunks.HasChunk(hash) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package network
+
+import (
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"context"
+	"encoding/hex"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"go.opentelemetry.io/otel/attribute"
+	"math/rand"
+	"sync"
+)
+
+// Number of chunks each provider is challenged for in an audit when no number is given
+const DefaultAuditChallenges = 3
+
+// Error returned when an audit finds no providers of the file to challenge
+var ErrNoProviders = errors.New("no providers of the file were found")
+
+// ChallengeFailure - Structure describing a challenge a provider failed to answer with the right chunk
+type ChallengeFailure struct {
+	Hash  string `json:"hash"`  // Hex encoded hash of the chunk the provider was challenged for
+	Error string `json:"error"` // Why the challenge failed
+}
+
+// ProviderAudit - Structure describing how a provider of a file answered the challenges of an audit
+type ProviderAudit struct {
+	Peer       string             `json:"peer"`               // Peer ID of the provider
+	Challenges int                `json:"challenges"`         // Number of chunks the provider was challenged for
+	Passed     int                `json:"passed"`             // Number of challenges answered with the right chunk
+	Failures   []ChallengeFailure `json:"failures,omitempty"` // Challenges the provider failed
+}
+
+// Function that audits every known provider of a file by challenging it for random chunks of the file, returning how
+// each provider answered
+// Providers are the peers announcing the file's Merkle root or its first chunk. Every provider is challenged for a
+// different random selection of chunks, at most one of each, and must serve each one matching its hash. If record is
+// set, every failed challenge counts against the provider's reputation.
+func AuditProviders(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte, challenges int,
+	record bool) (audits []ProviderAudit, err error) {
+	ctx, span := tracing.Start(ctx, "network.audit_providers", attribute.String("merkleRoot",
+		hex.EncodeToString(merkleRoot)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(chunkHashes) == 0 {
+		return nil, errors.New("file has no chunks to audit")
+	}
+	if challenges <= 0 {
+		challenges = DefaultAuditChallenges
+	}
+	providers, err := auditedProviders(ctx, merkleRoot, chunkHashes[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	audits = make([]ProviderAudit, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			audits[i] = challengeProvider(ctx, provider, pickChallenges(chunkHashes, challenges), record)
+		}()
+	}
+	wg.Wait()
+	return audits, nil
+}
+
+// Function that picks up to a number of distinct chunks of a file at random to challenge a provider for
+func pickChallenges(chunkHashes [][]byte, count int) [][]byte {
+	picked := make(map[string]bool)
+	var hashes [][]byte
+	for _, index := range rand.Perm(len(chunkHashes)) {
+		if len(hashes) == count {
+			break
+		}
+		key := hex.EncodeToString(chunkHashes[index])
+		if !picked[key] {
+			picked[key] = true
+			hashes = append(hashes, chunkHashes[index])
+		}
+	}
+	return hashes
+}
+
+// Function that finds the providers of a file to audit, which announce either its Merkle root or its first chunk
+func auditedProviders(ctx context.Context, merkleRoot []byte, firstChunk []byte) ([]peer.ID, error) {
+	seen := make(map[peer.ID]bool)
+	var providers []peer.ID
+	for _, hash := range [][]byte{merkleRoot, firstChunk} {
+		found, err := FindProviders(ctx, hash, maxReplicaHolders)
+		if err != nil {
+			return nil, err
+		}
+		for _, provider := range found {
+			if seen[provider.ID] {
+				continue
+			}
+			seen[provider.ID] = true
+			// Remember the provider's addresses so that a stream can be opened to it
+			nodeHost.Peerstore().AddAddrs(provider.ID, provider.Addrs, peerstore.TempAddrTTL)
+			providers = append(providers, provider.ID)
+		}
+	}
+	return providers, nil
+}
+
+// Function that challenges a provider for chunks, recording each failed challenge against its reputation if asked to
+func challengeProvider(ctx context.Context, peerID peer.ID, hashes [][]byte, record bool) ProviderAudit {
+	audit := ProviderAudit{Peer: peerID.String(), Challenges: len(hashes)}
+	for i, err := range challengeChunks(ctx, peerID, hashes) {
+		if err == nil {
+			audit.Passed++
+			continue
+		}
+		audit.Failures = append(audit.Failures, ChallengeFailure{Hash: hex.EncodeToString(hashes[i]), Error: err.Error()})
+		if record {
+			RecordReputationEvent(peerID, EventAuditFailed)
+		}
+	}
+	return audit
+}
+
+// Function that requests chunks from a peer holding them and checks each one against its hash, returning why each
+// challenge failed in the order of the hashes (nil for those the peer passed)
+func challengeChunks(ctx context.Context, peerID peer.ID, hashes [][]byte) []error {
+	positions := make(map[string]int, len(hashes))
+	results := make([]error, len(hashes))
+	for i, hash := range hashes {
+		positions[hex.EncodeToString(hash)] = i
+		results[i] = ErrChunkNotFound
+	}
+	requestChunks(ctx, peerID, hashes, nil, nil, func(hash []byte, chunk []byte, err error) {
+		if err == nil {
+			if _, valid := verify.IdentifyHash(chunk, hash); !valid {
+				err = ErrChunkCorrupt
+			}
+		}
+		results[positions[hex.EncodeToString(hash)]] = err
+	})
+	return results
+}
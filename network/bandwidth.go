@@ -0,0 +1,175 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+	"io"
+	"sync"
+	"time"
+)
+
+// Smallest burst of a bandwidth limit, so that a low limit still lets a message be written in a few pieces
+const minBandwidthBurst = 16 * 1024
+
+// Time after which the limiters of a peer the node has not exchanged data with are dropped
+const peerLimiterTTL = 10 * time.Minute
+
+// BandwidthLimits - Structure holding the rates chunk transfers are limited to, in bytes per second (0 for no limit)
+// The global limits cap the node's transfers with every peer put together, while the per-peer limits cap the
+// transfers with each peer on its own
+type BandwidthLimits struct {
+	Upload       int64 `json:"upload"`       // Rate data can be sent to peers at
+	Download     int64 `json:"download"`     // Rate data can be received from peers at
+	PeerUpload   int64 `json:"peerUpload"`   // Rate data can be sent to a single peer at
+	PeerDownload int64 `json:"peerDownload"` // Rate data can be received from a single peer at
+}
+
+// peerLimiters - Structure holding the limiters of the transfers with a single peer
+type peerLimiters struct {
+	upload   *rate.Limiter
+	download *rate.Limiter
+	lastUsed time.Time
+}
+
+// Limits and limiters applied to the node's transfers, which can be changed while the node runs
+var bandwidthLimits BandwidthLimits
+var uploadLimiter = rate.NewLimiter(rate.Inf, minBandwidthBurst)
+var downloadLimiter = rate.NewLimiter(rate.Inf, minBandwidthBurst)
+var peerBandwidth = make(map[peer.ID]*peerLimiters)
+var bandwidthMutex sync.Mutex
+
+// Function that sets the rates the node's transfers are limited to, taking effect on transfers already running
+func SetBandwidthLimits(limits BandwidthLimits) error {
+	if limits.Upload < 0 || limits.Download < 0 || limits.PeerUpload < 0 || limits.PeerDownload < 0 {
+		return errors.New("bandwidth limits must not be negative")
+	}
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	bandwidthLimits = limits
+	setLimit(uploadLimiter, limits.Upload)
+	setLimit(downloadLimiter, limits.Download)
+	for _, limiters := range peerBandwidth {
+		setLimit(limiters.upload, limits.PeerUpload)
+		setLimit(limiters.download, limits.PeerDownload)
+	}
+	return nil
+}
+
+// Function that returns the rates the node's transfers are limited to
+func GetBandwidthLimits() BandwidthLimits {
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	return bandwidthLimits
+}
+
+// Function that sets a limiter to a rate in bytes per second, with a rate of 0 lifting the limit
+func setLimit(limiter *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond == 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+	limiter.SetBurst(int(max(bytesPerSecond, minBandwidthBurst)))
+}
+
+// Function that returns the limiters of the transfers with a peer, creating them on the first transfer
+func limitersFor(peerID peer.ID, now time.Time) *peerLimiters {
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	limiters, found := peerBandwidth[peerID]
+	if !found {
+		// Drop the limiters of idle peers whenever a new peer is seen so that the map does not grow without bound
+		for otherID, other := range peerBandwidth {
+			if now.Sub(other.lastUsed) > peerLimiterTTL {
+				delete(peerBandwidth, otherID)
+			}
+		}
+		limiters = &peerLimiters{
+			upload:   rate.NewLimiter(rate.Inf, minBandwidthBurst),
+			download: rate.NewLimiter(rate.Inf, minBandwidthBurst),
+		}
+		setLimit(limiters.upload, bandwidthLimits.PeerUpload)
+		setLimit(limiters.download, bandwidthLimits.PeerDownload)
+		peerBandwidth[peerID] = limiters
+	}
+	limiters.lastUsed = now
+	return limiters
+}
+
+// limitedStream - Structure wrapping a stream with a peer so that reads and writes stay within the bandwidth limits
+type limitedStream struct {
+	ctx    context.Context // Context ending waits for bandwidth once the exchange on the stream is abandoned
+	stream io.ReadWriter
+	peerID peer.ID
+}
+
+// Function that wraps a stream with a peer so that the data exchanged on it is limited to the node's bandwidth limits
+func limitStream(ctx context.Context, stream io.ReadWriter, peerID peer.ID) io.ReadWriter {
+	return &limitedStream{ctx: ctx, stream: stream, peerID: peerID}
+}
+
+// Function that reads from the stream, then waits until the data read fits within the download limits
+// Reads are capped at the burst of the limiters, so that data is never received faster than it can be accounted for
+func (limited *limitedStream) Read(p []byte) (int, error) {
+	limiters := limitersFor(limited.peerID, time.Now())
+	p = p[:pieceSize(len(p), downloadLimiter, limiters.download)]
+	n, err := limited.stream.Read(p)
+	if n > 0 {
+		waitErr := waitBandwidth(limited.ctx, n, downloadLimiter, limiters.download)
+		if err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// Function that waits until data fits within the upload limits and writes it to the stream, in pieces if needed
+func (limited *limitedStream) Write(p []byte) (int, error) {
+	limiters := limitersFor(limited.peerID, time.Now())
+	written := 0
+	for written < len(p) {
+		piece := p[written:]
+		piece = piece[:pieceSize(len(piece), uploadLimiter, limiters.upload)]
+		err := waitBandwidth(limited.ctx, len(piece), uploadLimiter, limiters.upload)
+		if err != nil {
+			return written, err
+		}
+		n, err := limited.stream.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Function that caps a number of bytes at the burst of every given limiter that has a limit
+func pieceSize(n int, limiters ...*rate.Limiter) int {
+	for _, limiter := range limiters {
+		if limiter.Limit() != rate.Inf {
+			n = min(n, limiter.Burst())
+		}
+	}
+	return n
+}
+
+// Function that waits until a number of bytes fits within every one of the given limiters, or the context ends
+func waitBandwidth(ctx context.Context, n int, limiters ...*rate.Limiter) error {
+	for _, limiter := range limiters {
+		if limiter.Limit() == rate.Inf {
+			continue
+		}
+		// The burst can shrink between reading it and waiting if the limits are changed, so the wait is split up
+		for remaining := n; remaining > 0; {
+			tokens := min(remaining, limiter.Burst())
+			err := limiter.WaitN(ctx, tokens)
+			if err != nil {
+				return err
+			}
+			remaining -= tokens
+		}
+	}
+	return nil
+}
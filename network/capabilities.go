@@ -0,0 +1,205 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sync"
+	"time"
+)
+
+// Time an advert stays valid for after it is signed
+const advertTTL = 10 * time.Minute
+
+// Interval at which the node signs and sends a fresh advert and prunes expired ones, well before adverts expire
+const advertRefreshInterval = advertTTL / 3
+
+// Error returned when an advert's signature does not match the peer it claims to come from
+var ErrAdvertSignature = errors.New("capability advert signature is invalid")
+
+// Error returned when an advert has expired
+var ErrAdvertExpired = errors.New("capability advert has expired")
+
+// Error returned when an advert claims to be issued in the future or to stay valid for longer than adverts do
+var ErrAdvertLifetime = errors.New("capability advert is issued in the future or valid for too long")
+
+// Capabilities - Structure describing what a peer offers to the network
+type Capabilities struct {
+	FreeSpace int64    `json:"freeSpace"` // Number of bytes the peer is willing to store for others
+	Roles     []string `json:"roles"`     // Roles the peer takes on (e.g. storage or relay)
+	Price     float64  `json:"price"`     // Price the peer asks per GiB stored (0 if free)
+}
+
+// CapabilityAdvert - Capabilities of a peer, signed by the peer's identity key so that they cannot be spoofed,
+// and carrying an expiry so that stale capacity is not used for placement
+type CapabilityAdvert struct {
+	PeerID       peer.ID      `json:"peerID"`       // Peer the capabilities belong to
+	Capabilities Capabilities `json:"capabilities"` // Capabilities being advertised
+	IssuedAt     time.Time    `json:"issuedAt"`     // Time the advert was signed
+	ExpiresAt    time.Time    `json:"expiresAt"`    // Time after which the advert must be ignored
+	Signature    []byte       `json:"signature"`    // Signature over every other field by the peer's identity key
+}
+
+var adverts = make(map[peer.ID]*CapabilityAdvert)
+var advertMutex = &sync.Mutex{}
+
+// Function that creates an advert for the peer owning the private key, signed by that key
+func NewCapabilityAdvert(privKey crypto.PrivKey, capabilities Capabilities, ttl time.Duration) (*CapabilityAdvert, error) {
+	peerID, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	advert := &CapabilityAdvert{
+		PeerID:       peerID,
+		Capabilities: capabilities,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(ttl),
+	}
+	signedBytes, err := advert.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	advert.Signature, err = privKey.Sign(signedBytes)
+	if err != nil {
+		return nil, err
+	}
+	return advert, nil
+}
+
+// Function that returns the bytes covered by the advert's signature (the advert without its signature)
+func (advert *CapabilityAdvert) signedBytes() ([]byte, error) {
+	unsigned := *advert
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// Function that checks that an advert was signed by the peer it names and has not expired
+// The public key is taken from the peer ID, so a peer cannot sign adverts on behalf of another. The peer chooses when
+// its advert is issued and expires, so an advert issued in the future (beyond the clock offset allowed between peers)
+// or valid for longer than advertTTL is refused, as it could otherwise stay valid indefinitely.
+func (advert *CapabilityAdvert) Verify(now time.Time) error {
+	if !now.Before(advert.ExpiresAt) {
+		return ErrAdvertExpired
+	}
+	if advert.IssuedAt.After(now.Add(maxClockOffset)) || advert.ExpiresAt.After(advert.IssuedAt.Add(advertTTL)) {
+		return ErrAdvertLifetime
+	}
+	publicKey, err := advert.PeerID.ExtractPublicKey()
+	if err != nil {
+		return ErrAdvertSignature
+	}
+	signedBytes, err := advert.signedBytes()
+	if err != nil {
+		return err
+	}
+	valid, err := publicKey.Verify(signedBytes, advert.Signature)
+	if err != nil || !valid {
+		return ErrAdvertSignature
+	}
+	return nil
+}
+
+// Function that verifies an advert and keeps it unless a more recent advert from the same peer is already held
+func recordAdvert(advert *CapabilityAdvert) error {
+	err := advert.Verify(time.Now())
+	if err != nil {
+		return err
+	}
+
+	advertMutex.Lock()
+	defer advertMutex.Unlock()
+	current, found := adverts[advert.PeerID]
+	if found && !advert.IssuedAt.After(current.IssuedAt) {
+		return nil
+	}
+	adverts[advert.PeerID] = advert
+	return nil
+}
+
+// Function that returns the advertised capabilities of a peer, if it has an advert that has not expired
+func GetCapabilities(peerID peer.ID) (Capabilities, bool) {
+	advertMutex.Lock()
+	defer advertMutex.Unlock()
+
+	advert, found := adverts[peerID]
+	if !found || !time.Now().Before(advert.ExpiresAt) {
+		return Capabilities{}, false
+	}
+	return advert.Capabilities, true
+}
+
+// Function that removes every advert that has expired
+func pruneExpiredAdverts(now time.Time) {
+	advertMutex.Lock()
+	defer advertMutex.Unlock()
+
+	for peerID, advert := range adverts {
+		if !now.Before(advert.ExpiresAt) {
+			delete(adverts, peerID)
+		}
+	}
+}
+
+// Function that removes the advert of a peer that is no longer connected
+func removeAdvert(peerID peer.ID) {
+	advertMutex.Lock()
+	defer advertMutex.Unlock()
+	delete(adverts, peerID)
+}
+
+// Function that handles an advert sent by a peer
+// Adverts are only accepted from the peer they belong to, and forged adverts count against the sender's reputation
+func handleSendCapabilities(peerID peer.ID, payload json.RawMessage) {
+	var advert CapabilityAdvert
+	if err := decodePayload(payload, &advert); err != nil {
+		logger.Warn("error encountered when unmarshalling capability advert", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	if advert.PeerID != peerID {
+		RecordReputationEvent(peerID, EventInvalidAdvert)
+		return
+	}
+	err := recordAdvert(&advert)
+	if errors.Is(err, ErrAdvertSignature) {
+		RecordReputationEvent(peerID, EventInvalidAdvert)
+	}
+}
+
+// Function that keeps the node's own advert fresh on every connected peer and prunes expired adverts from peers
+// If capabilities is nil the node does not advertise anything but still prunes the adverts it has received
+func manageAdverts(ctx context.Context, capabilities func() Capabilities) {
+	ticker := time.NewTicker(advertRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if capabilities != nil {
+			publishAdvert(ctx, capabilities())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pruneExpiredAdverts(now)
+		}
+	}
+}
+
+// Function that signs an advert of the node's capabilities and sends it to every connected peer
+func publishAdvert(ctx context.Context, capabilities Capabilities) {
+	advert, err := NewCapabilityAdvert(nodeHost.Peerstore().PrivKey(nodeHost.ID()), capabilities, advertTTL)
+	if err != nil {
+		logger.Error("error encountered when signing capability advert", "error", err)
+		return
+	}
+	for _, peerInfo := range GetPeers() {
+		go func(peerID peer.ID) {
+			err := sendMessage(ctx, peerID, SendCapabilities, advert)
+			if err != nil {
+				logger.Error("error encountered when sending capability advert to peer", "peer", peerID, "error", err)
+			}
+		}(peerInfo.ID)
+	}
+}
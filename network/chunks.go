@@ -0,0 +1,864 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/resources"
+	"blockchain-storage/storage"
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Maximum number of providers looked up in the DHT for a single file
+const maxChunkProviders = 20
+
+// Number of chunk requests sent to peers at the same time
+const chunkFetchConcurrency = 4
+
+// Maximum number of chunks asked for in a single request
+const chunkBatchSize = 5
+
+// Maximum time a peer is given to say which of the requested chunks it holds
+const chunkAvailabilityTimeout = 30 * time.Second
+
+// Maximum time a peer is given to send each chunk it said it holds
+const chunkRequestTimeout = 2 * time.Minute
+
+// Name of the compression applied to chunks in transit, sent with each compressed chunk
+const chunkCompression = "zstd"
+
+// First protocol version in which peers accept chunks compressed for transit
+const compressionProtocolVersion = 2
+
+// Error returned when a peer does not hold a requested chunk
+var ErrChunkNotFound = errors.New("peer does not hold the requested chunk")
+
+// Error returned when a peer's replica of a requested chunk is corrupt
+var ErrChunkCorrupt = errors.New("peer's replica of the requested chunk is corrupt")
+
+// The node's local chunk store, which requested chunks are served from and fetched chunks are saved to
+var Chunks *storage.ChunkStore
+
+// The node's cache of recently served chunks of files it has not pinned, which requested chunks are also served from
+// (may be nil)
+var Cache *storage.ChunkCache
+
+// The node's access list, recording which of its chunks are only served to peers holding a capability (may be nil)
+var Access *storage.AccessList
+
+// Monitor of the node's resource usage, which throttles chunk transfers while the node is over its ceilings (may be nil)
+var Resources *resources.Monitor
+
+// Number of good chunk copies pushed to peers that served corrupt ones, and accepted from peers
+var repairsSent = expvar.NewInt("chunkRepairsSent")
+var repairsReceived = expvar.NewInt("chunkRepairsReceived")
+
+// ChunkRequest - Payload of a message requesting a batch of chunks from a peer
+type ChunkRequest struct {
+	Hashes [][]byte `json:"hashes"` // Hashes of the requested chunks
+
+	// Capability granting access to the chunks of an encrypted file, presented without its key (may be nil)
+	Capability *core.Capability `json:"capability,omitempty"`
+
+	// Merkle root of the file the chunks are requested for, asking for the proof that each chunk belongs to it (empty
+	// if no proofs are wanted)
+	MerkleRoot []byte `json:"merkleRoot,omitempty"`
+}
+
+// ChunkAvailability - Payload of the first message sent in reply to a chunk request
+// Missing chunks are listed straight away so that the requester can ask other peers for them without waiting
+type ChunkAvailability struct {
+	Have    [][]byte `json:"have"`    // Hashes of the chunks that follow, in the order they are sent
+	Missing [][]byte `json:"missing"` // Hashes of the chunks the peer does not hold
+	Token   string   `json:"token"`   // Token resuming the transfer if the stream drops (empty if it cannot be resumed)
+
+	Version *NodeVersion `json:"version,omitempty"` // Build the peer runs, attested to so that audits can check it
+}
+
+// ChunkResponse - Payload of a message carrying a single chunk
+type ChunkResponse struct {
+	Hash    []byte `json:"hash"`    // Hash of the chunk
+	Found   bool   `json:"found"`   // Whether the peer holds the chunk
+	Corrupt bool   `json:"corrupt"` // Whether the peer should hold the chunk but its replica failed validation
+	Data    []byte `json:"data"`    // Contents of the chunk (empty if it was not found)
+
+	// Compression applied to the data in transit (empty if it is sent as is), the hash is always of the uncompressed data
+	Compression string `json:"compression,omitempty"`
+
+	// Merkle root of the encrypted file a placed chunk belongs to, whose chunks are only served with a capability
+	Restricted []byte `json:"restricted,omitempty"`
+
+	// Number of bytes of data that follow in ChunkData messages instead of being carried in Data (0 if none do)
+	Streamed int64 `json:"streamed,omitempty"`
+
+	// Merkle root of the file a placed chunk belongs to, and the proof that the chunk is one of its leaves (empty for
+	// chunks sent in reply to a request, unless the request named a Merkle root the peer holds a proof for)
+	MerkleRoot []byte             `json:"merkleRoot,omitempty"`
+	Proof      []verify.ProofStep `json:"proof,omitempty"`
+
+	// Hash of the chunk along with the algorithm it was computed with (empty from peers that predate multihashes,
+	// whose chunks are checked against every known algorithm instead)
+	Multihash verify.Multihash `json:"multihash,omitempty"`
+}
+
+// Function that handles a chunk request by replying on the same stream
+// The reply starts with which of the requested chunks the node holds ("3 of 5"), followed by each of those chunks
+func handleRequestChunks(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, peerID peer.ID,
+	payload json.RawMessage) {
+	var request ChunkRequest
+	if err := decodePayload(payload, &request); err != nil {
+		logger.Warn("error encountered when unmarshalling chunk request", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+
+	// A cache node fetches the chunks it does not hold before replying, so that it can serve them
+	relayMissingChunks(peerID, request.Hashes)
+
+	// Chunks of encrypted files are reported as missing to peers without access, so they are asked for elsewhere
+	var availability ChunkAvailability
+	for _, hash := range request.Hashes {
+		if holdsChunk(hash) && canAccessChunk(peerID, hash, request.Capability) {
+			availability.Have = append(availability.Have, hash)
+		} else {
+			availability.Missing = append(availability.Missing, hash)
+		}
+	}
+	if len(availability.Have) > 0 {
+		availability.Token = newChunkSession(peerID, availability.Have, request.MerkleRoot, time.Now())
+	}
+	sendChunks(rw, setReadDeadline, availability, request.MerkleRoot, peerID)
+}
+
+// Function that checks whether the node can serve a chunk from its chunk store or one of its caches
+func holdsChunk(hash []byte) bool {
+	return (Chunks != nil && Chunks.HasChunk(hash)) || (Cache != nil && Cache.Has(hash)) ||
+		(RelayCache != nil && RelayCache.Has(hash))
+}
+
+// Function that reads a chunk the node serves along with the multihash it is addressed by, from the chunk store if it
+// is held there and from the caches otherwise
+func readServedChunk(hash []byte) ([]byte, verify.Multihash, error) {
+	if Chunks != nil && Chunks.HasChunk(hash) {
+		return Chunks.GetAddressedChunk(hash)
+	}
+	for _, cache := range []*storage.ChunkCache{Cache, RelayCache} {
+		if cache == nil {
+			continue
+		}
+		if chunk, multihash, found := cache.Get(hash); found {
+			return chunk, multihash, nil
+		}
+	}
+	return nil, nil, ErrChunkNotFound
+}
+
+// Function that checks whether a peer may be served a chunk, which it always may unless the chunk is restricted
+// The chunks of an encrypted file are served to its uploader and to peers presenting a capability the uploader granted
+func canAccessChunk(peerID peer.ID, hash []byte, capability *core.Capability) bool {
+	merkleRoot, restricted := Access.RestrictedTo(hash)
+	if !restricted {
+		return true
+	}
+	if Chain == nil {
+		return false
+	}
+	if isUploader(peerID, merkleRoot) {
+		return true
+	}
+	if capability == nil || !bytes.Equal(capability.MerkleRoot, merkleRoot) {
+		return false
+	}
+	err := Chain.VerifyCapability(capability, peerID.String(), time.Now())
+	if err != nil {
+		logger.Warn("refused chunk request with an invalid capability", "peer", peerID, "error", err)
+		return false
+	}
+	return true
+}
+
+// Function that returns whether a peer uploaded a file committed on the local chain
+func isUploader(peerID peer.ID, merkleRoot []byte) bool {
+	if Chain == nil {
+		return false
+	}
+	uploader, err := Chain.FileUploader(merkleRoot)
+	if err != nil {
+		return false
+	}
+	publicKey, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return false
+	}
+	raw, err := publicKey.Raw()
+	return err == nil && bytes.Equal(raw, uploader)
+}
+
+// Function that sends which chunks follow on a stream and then each of those chunks, compressed if the peer accepts it
+// Chunks larger than a frame are streamed in frames after their message to peers that accept it, and chunks requested
+// for a file's Merkle root carry the proof that they belong to it if the node holds one
+func sendChunks(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, availability ChunkAvailability,
+	merkleRoot []byte, peerID peer.ID) {
+	compress, streaming := acceptsCompressedChunks(peerID), acceptsStreamedChunks(peerID)
+	version := BuildVersion()
+	availability.Version = &version
+	err := writeFlushed(rw, ChunkAvailabilityReply, availability)
+	if err != nil {
+		logger.Error("error encountered when sending chunk availability", "error", err)
+		return
+	}
+
+	for _, hash := range availability.Have {
+		response := ChunkResponse{Hash: hash}
+		var streamed []byte
+		chunk, multihash, err := readServedChunk(hash)
+		if err == nil {
+			response.Found = true
+			response.Data = chunk
+			response.Multihash = multihash
+			response.attachProof(merkleRoot)
+			if compress {
+				response.compress()
+			}
+			streamed = response.detachData(streaming)
+		} else {
+			// Let the requester know so that it can push a good copy back once it finds one
+			response.Corrupt = true
+		}
+		err = writeFlushed(rw, SendChunks, response)
+		if err == nil && streamed != nil {
+			err = streamChunkData(rw, setReadDeadline, hash, streamed)
+		}
+		if err != nil {
+			logger.Error("error encountered when sending chunk", "error", err)
+			return
+		}
+	}
+}
+
+// Function that checks a chunk's data against its hash, returning the algorithm the hash was computed with
+// Only the algorithm named by the chunk's multihash is tried, and only if the multihash is of the chunk's hash
+func (response *ChunkResponse) verifyData() (verify.HashAlgorithm, bool) {
+	if len(response.Multihash) == 0 {
+		return verify.IdentifyHash(response.Data, response.Hash)
+	}
+	algorithm, digest, err := response.Multihash.Decode()
+	if err != nil || !bytes.Equal(digest, response.Hash) || !response.Multihash.Verify(response.Data) {
+		return "", false
+	}
+	return algorithm, true
+}
+
+// Function that compresses a chunk's data for transit if that makes it smaller
+func (response *ChunkResponse) compress() {
+	data, compressed := storage.CompressChunk(response.Data)
+	if compressed {
+		response.Data = data
+		response.Compression = chunkCompression
+	}
+}
+
+// Function that restores a chunk's data to how it was before it was compressed for transit
+func (response *ChunkResponse) decompress() error {
+	switch response.Compression {
+	case "":
+		return nil
+	case chunkCompression:
+		data, err := storage.DecompressChunk(response.Data)
+		if err != nil {
+			return err
+		}
+		response.Data = data
+		response.Compression = ""
+		return nil
+	default:
+		return fmt.Errorf("unsupported chunk compression %q", response.Compression)
+	}
+}
+
+// Function that returns whether a peer accepts chunks compressed for transit, which it does from protocol version 2
+func acceptsCompressedChunks(peerID peer.ID) bool {
+	agreed, found := GetPeerProtocol(peerID)
+	return found && agreed.Version >= compressionProtocolVersion
+}
+
+// Function that writes a message and flushes it so that the peer receives it straight away
+func writeFlushed(rw *bufio.ReadWriter, messageType MessageType, payload any) error {
+	err := writeMessage(rw, messageType, payload)
+	if err != nil {
+		return err
+	}
+	return rw.Flush()
+}
+
+// Function that handles a chunk pushed by a peer that found the local replica to be corrupt (read-repair)
+// A pushed chunk only replaces a replica that the node is meant to hold but that no longer passes validation,
+// so that peers cannot fill the chunk store with unsolicited data
+func handleSendChunks(peerID peer.ID, payload json.RawMessage) {
+	var response ChunkResponse
+	if err := decodePayload(payload, &response); err != nil {
+		logger.Warn("error encountered when unmarshalling chunk", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	// Repairs are pushed in a single message, so there are no frames to read the data of a streamed chunk from
+	if response.Streamed > 0 {
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	if err := response.decompress(); err != nil {
+		logger.Warn("error encountered when decompressing chunk", "error", err)
+		return
+	}
+	if Chunks == nil || !response.Found || !Chunks.HasChunk(response.Hash) {
+		return
+	}
+	algorithm, valid := response.verifyData()
+	if !valid {
+		return
+	}
+	if _, err := Chunks.GetChunk(response.Hash); err == nil {
+		return
+	}
+
+	_, err := Chunks.PutChunkWith(algorithm, response.Data)
+	if err != nil {
+		logger.Error("error encountered when repairing chunk", "error", err)
+		return
+	}
+	repairsReceived.Add(1)
+}
+
+// Function that pushes a good copy of a chunk to a peer that served a corrupt copy of it
+func pushChunkRepair(peerID peer.ID, algorithm verify.HashAlgorithm, chunkHash []byte, chunk []byte) {
+	ctx, cancel := context.WithTimeout(nodeContext, chunkRequestTimeout)
+	defer cancel()
+	response := ChunkResponse{Hash: chunkHash, Found: true, Data: chunk}
+	response.Multihash, _ = verify.NewMultihash(algorithm, chunkHash)
+	if acceptsCompressedChunks(peerID) {
+		response.compress()
+	}
+	err := sendMessage(ctx, peerID, SendChunks, response)
+	if err != nil {
+		logger.Error("error encountered when repairing chunk on peer", "peer", peerID, "error", err)
+		return
+	}
+	repairsSent.Add(1)
+}
+
+// Function called with the outcome of each requested chunk as soon as it is known
+type chunkReport func(hash []byte, chunk []byte, err error)
+
+// Function type requesting a batch of chunks from a peer and reporting the outcome of every one of them
+type chunkRequester func(ctx context.Context, peerID peer.ID, hashes [][]byte, report chunkReport)
+
+// Function that requests a batch of chunks from a peer over a new stream
+// If the stream drops part way through, the transfer is resumed on a new stream with the peer's session token.
+// Every requested chunk is reported exactly once, with chunks the peer never sent reported as failed. If a Merkle root
+// is given, chunks the peer does not prove belong to it are reported as failed too.
+func requestChunks(ctx context.Context, peerID peer.ID, hashes [][]byte, merkleRoot []byte, capability *core.Capability,
+	report chunkReport) {
+	ctx, span := tracing.Start(ctx, "network.request_chunks", attribute.String("peer", peerID.String()),
+		attribute.Int("chunks", len(hashes)))
+	defer span.End()
+
+	outstanding := newOutstandingChunks(hashes, merkleRoot, report)
+	if nodeHost == nil {
+		outstanding.failAll(errors.New("node has not been started"))
+		return
+	}
+
+	stream, err := nodeHost.NewStream(ctx, peerID, protocol)
+	if err != nil {
+		outstanding.failAll(err)
+		return
+	}
+	session := &resumableTransfer{}
+	err = exchangeChunks(limitStream(ctx, stream, peerID), stream.SetReadDeadline, hashes, capability, outstanding, session)
+	stream.Close()
+	setPeerVersion(peerID, session.version)
+
+	for attempt := 0; err != nil && session.canResume(err) && ctx.Err() == nil && attempt < maxResumeAttempts; attempt++ {
+		span.AddEvent("resume", trace.WithAttributes(attribute.String("error", err.Error())))
+		stream, err = nodeHost.NewStream(ctx, peerID, protocol)
+		if err != nil {
+			break
+		}
+		err = resumeChunks(limitStream(ctx, stream, peerID), stream.SetReadDeadline, outstanding, session)
+		stream.Close()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		RecordReputationEvent(peerID, EventTimeout)
+	}
+	if err == nil {
+		err = ErrChunkNotFound
+	}
+	if len(outstanding.hashes) > 0 {
+		span.SetAttributes(attribute.Int("failed", len(outstanding.hashes)))
+	}
+	outstanding.failAll(err)
+}
+
+// Function that sends a chunk request on a stream and reads the reply
+// Each message of the reply has its own deadline, so a slow peer only holds up the chunks it has not sent yet
+func exchangeChunks(stream io.ReadWriter, setReadDeadline func(time.Time) error, hashes [][]byte,
+	capability *core.Capability, outstanding *outstandingChunks, session *resumableTransfer) error {
+	err := writeMessage(stream, RequestChunks, ChunkRequest{Hashes: hashes, Capability: capability,
+		MerkleRoot: outstanding.merkleRoot})
+	if err != nil {
+		return err
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	var availability ChunkAvailability
+	setReadDeadline(time.Now().Add(chunkAvailabilityTimeout))
+	err = readReply(rw.Reader, ChunkAvailabilityReply, &availability)
+	if err != nil {
+		return err
+	}
+	for _, hash := range availability.Missing {
+		outstanding.report(hash, nil, ErrChunkNotFound)
+	}
+	session.start(availability)
+	return readChunks(rw, setReadDeadline, availability.Have, outstanding, session)
+}
+
+// Function that reads the chunks a peer said it would send, reporting each one and marking it as received
+// The data of streamed chunks is read from the frames following their message, which the peer is granted as it goes
+func readChunks(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, have [][]byte,
+	outstanding *outstandingChunks, session *resumableTransfer) error {
+	for range have {
+		var response ChunkResponse
+		setReadDeadline(time.Now().Add(chunkRequestTimeout))
+		err := readReply(rw.Reader, SendChunks, &response)
+		if err == nil && response.Streamed > 0 {
+			response.Data, err = receiveChunkData(rw, setReadDeadline, response.Hash, response.Streamed)
+		}
+		if err == nil {
+			err = response.decompress()
+		}
+		if err != nil {
+			return err
+		}
+		session.received(response.Hash)
+		switch {
+		case response.Corrupt:
+			outstanding.report(response.Hash, nil, ErrChunkCorrupt)
+		case !response.Found:
+			outstanding.report(response.Hash, nil, ErrChunkNotFound)
+		case outstanding.merkleRoot != nil && !response.provenIn(outstanding.merkleRoot):
+			outstanding.report(response.Hash, nil, ErrChunkUnproven)
+		default:
+			outstanding.report(response.Hash, response.Data, nil)
+		}
+	}
+	return nil
+}
+
+// Function that reads a single reply message of the expected type
+func readReply(reader *bufio.Reader, expected MessageType, payload any) error {
+	frame, err := readFrame(reader)
+	if err != nil {
+		return err
+	}
+	message, err := decodeMessage(frame)
+	if err != nil {
+		return err
+	}
+	if message.Type != expected {
+		return fmt.Errorf("unexpected reply to chunk request: %s", message.Type)
+	}
+	return decodePayload(message.Payload, payload)
+}
+
+// outstandingChunks - Structure tracking the chunks of a request that have not been reported yet
+// Chunks that were not requested or were already reported are ignored, so each chunk is reported exactly once
+type outstandingChunks struct {
+	hashes     map[string][]byte
+	merkleRoot []byte // Merkle root every chunk must be proven to belong to (nil if none need proofs)
+	callback   chunkReport
+}
+
+// Function that creates the set of outstanding chunks for a request
+func newOutstandingChunks(hashes [][]byte, merkleRoot []byte, report chunkReport) *outstandingChunks {
+	outstanding := &outstandingChunks{hashes: make(map[string][]byte, len(hashes)), merkleRoot: merkleRoot,
+		callback: report}
+	for _, hash := range hashes {
+		outstanding.hashes[hex.EncodeToString(hash)] = hash
+	}
+	return outstanding
+}
+
+// Function that reports the outcome of an outstanding chunk
+func (outstanding *outstandingChunks) report(hash []byte, chunk []byte, err error) {
+	key := hex.EncodeToString(hash)
+	if _, found := outstanding.hashes[key]; !found {
+		return
+	}
+	delete(outstanding.hashes, key)
+	outstanding.callback(hash, chunk, err)
+}
+
+// Function that reports every outstanding chunk as failed
+func (outstanding *outstandingChunks) failAll(err error) {
+	for _, hash := range outstanding.hashes {
+		outstanding.report(hash, nil, err)
+	}
+}
+
+// ChunkFetch - Structure describing how a single chunk was fetched from the network
+type ChunkFetch struct {
+	Hash         string        `json:"hash"`                   // Hex encoded hash of the chunk
+	Peer         string        `json:"peer,omitempty"`         // Peer that served the copy that was stored (empty if none did)
+	Attempts     int           `json:"attempts"`               // Number of peers the chunk was requested from
+	CorruptPeers []string      `json:"corruptPeers,omitempty"` // Peers that served a corrupt copy or reported one
+	Duration     time.Duration `json:"duration"`               // Time from the first request for the chunk until it was done
+	Size         int           `json:"size,omitempty"`         // Size of the copy that was stored (0 if none was)
+	Error        string        `json:"error,omitempty"`        // Why the chunk could not be fetched (empty if it was)
+}
+
+// Function that fetches every chunk of a file that is missing from the local chunk store, returning how each of the
+// missing chunks was fetched
+// Providers of the file's Merkle root are looked up in the DHT, ranked, and chunk requests are spread across them.
+// Only if the DHT has no providers is every connected peer asked instead.
+// If progress is not nil it is called with each missing chunk as soon as it is done with.
+// The chunks of an encrypted file are only served with a capability granting access to it, which is presented to
+// every peer without its key.
+func FetchChunks(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte, capability *core.Capability,
+	progress func(ChunkFetch)) (fetches []ChunkFetch, err error) {
+	ctx, span := tracing.Start(ctx, "network.fetch_chunks", attribute.String("merkleRoot", hex.EncodeToString(merkleRoot)))
+	defer func() { tracing.End(span, err) }()
+
+	if Chunks == nil {
+		return nil, errors.New("node has no chunk store")
+	}
+	keep := func(algorithm verify.HashAlgorithm, hash []byte, chunk []byte) error {
+		_, err := Chunks.PutChunkWith(algorithm, chunk)
+		return err
+	}
+	return fetchMissingChunks(ctx, merkleRoot, chunkHashes, capability, Chunks.HasChunk, keep, progress)
+}
+
+// Function that fetches every chunk of a file the node holds neither in its chunk store nor in its cache into the
+// cache, returning how each of the missing chunks was fetched
+// Unlike FetchChunks, the chunks are not kept once the cache needs the room for others, so serving a popular file
+// never commits the node to storing it.
+func CacheChunks(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte,
+	progress func(ChunkFetch)) (fetches []ChunkFetch, err error) {
+	ctx, span := tracing.Start(ctx, "network.cache_chunks", attribute.String("merkleRoot", hex.EncodeToString(merkleRoot)))
+	defer func() { tracing.End(span, err) }()
+
+	if Cache == nil {
+		return nil, errors.New("node has no chunk cache")
+	}
+	return fetchMissingChunks(ctx, merkleRoot, chunkHashes, nil, holdsChunk, Cache.Put, progress)
+}
+
+// Function type keeping a chunk fetched from a peer once it has been checked against its hash
+type chunkKeeper func(algorithm verify.HashAlgorithm, hash []byte, chunk []byte) error
+
+// Function that fetches the chunks of a file that the node does not already hold from the file's providers, passing
+// each chunk to keep once it has been checked
+func fetchMissingChunks(ctx context.Context, merkleRoot []byte, chunkHashes [][]byte, capability *core.Capability,
+	held func([]byte) bool, keep chunkKeeper, progress func(ChunkFetch)) ([]ChunkFetch, error) {
+	var missing [][]byte
+	for _, chunkHash := range chunkHashes {
+		if !held(chunkHash) {
+			missing = append(missing, chunkHash)
+		}
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("missing", len(missing)))
+	if len(missing) == 0 {
+		return nil, nil
+	}
+	proven, err := proofRoot(merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := chunkCandidates(ctx, merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no peers available to fetch chunks from")
+	}
+
+	if capability != nil {
+		capability = capability.Redacted()
+	}
+	request := func(ctx context.Context, peerID peer.ID, hashes [][]byte, report chunkReport) {
+		requestChunks(ctx, peerID, hashes, proven, capability, report)
+	}
+	return fetchChunksFrom(ctx, missing, candidates, request, keep, progress)
+}
+
+// chunkTask - Structure tracking the fetching of a single chunk
+type chunkTask struct {
+	hash         []byte
+	index        int              // Position of the chunk in the list of chunks being fetched
+	start        int              // Position in the candidate list of the first peer to ask
+	tried        map[peer.ID]bool // Peers that have already been asked for the chunk
+	corruptPeers []peer.ID        // Peers that served a corrupt copy or reported their replica as corrupt
+	requested    time.Time        // Time the chunk was first requested (zero until it is)
+}
+
+// Function that describes how a chunk was fetched once it is done with
+func (task *chunkTask) fetch(servedBy peer.ID, size int, err error) ChunkFetch {
+	fetch := ChunkFetch{Hash: hex.EncodeToString(task.hash), Attempts: len(task.tried)}
+	for _, corruptPeer := range task.corruptPeers {
+		fetch.CorruptPeers = append(fetch.CorruptPeers, corruptPeer.String())
+	}
+	if !task.requested.IsZero() {
+		fetch.Duration = time.Since(task.requested)
+	}
+	if err != nil {
+		fetch.Error = err.Error()
+	} else {
+		fetch.Peer = servedBy.String()
+		fetch.Size = size
+	}
+	return fetch
+}
+
+// chunkOutcome - Outcome of a single chunk from a batch request
+type chunkOutcome struct {
+	task   *chunkTask
+	peerID peer.ID
+	chunk  []byte
+	err    error
+}
+
+// Function that fetches chunks from a list of candidate peers, best first
+// Chunks are requested in batches, with each batch starting at a different candidate so that requests are spread
+// across all of them. As soon as a peer says it does not hold a chunk, misses the deadline for it or serves a corrupt
+// copy, the chunk is reassigned to the next candidate that has not been asked for it, without waiting for the rest
+// of the batch.
+// Every chunk received is checked against its hash and the result is recorded against the peer that served it.
+// Once a good copy is found it is pushed back to every peer that served a corrupt copy or reported its replica as
+// corrupt, so that normal reads keep the network's replicas healthy.
+// How each chunk was fetched is returned in the order of the hashes, whether or not fetching succeeded, and is passed
+// to progress (if not nil) as each chunk is done with.
+func fetchChunksFrom(ctx context.Context, hashes [][]byte, candidates []peer.ID, request chunkRequester,
+	keep chunkKeeper, progress func(ChunkFetch)) ([]ChunkFetch, error) {
+	var pending []*chunkTask
+	for i, hash := range hashes {
+		pending = append(pending, &chunkTask{hash: hash, index: i, start: i / chunkBatchSize,
+			tried: make(map[peer.ID]bool)})
+	}
+
+	fetches := make([]ChunkFetch, len(hashes))
+	outcomes := make(chan chunkOutcome)
+	batchesDone := make(chan struct{})
+	remaining := len(pending)
+	active := 0
+	var firstErr error
+	finish := func(task *chunkTask, servedBy peer.ID, size int, err error) {
+		remaining--
+		fetches[task.index] = task.fetch(servedBy, size, err)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if progress != nil {
+			progress(fetches[task.index])
+		}
+	}
+
+	for remaining > 0 || active > 0 {
+		// Send as many batches as allowed, giving up on chunks that no candidate is left to ask for
+		for len(pending) > 0 && active < Resources.Scale(chunkFetchConcurrency) {
+			if ctx.Err() != nil {
+				for _, task := range pending {
+					finish(task, "", 0, ctx.Err())
+				}
+				pending = nil
+				break
+			}
+			peerID, batch, rest, exhausted := nextChunkBatch(pending, candidates)
+			for _, task := range exhausted {
+				finish(task, "", 0, fmt.Errorf("no peer served chunk %s", hex.EncodeToString(task.hash)))
+			}
+			pending = rest
+			if len(batch) == 0 {
+				break
+			}
+			active++
+			go runChunkBatch(ctx, request, peerID, batch, outcomes, batchesDone)
+		}
+		if remaining == 0 && active == 0 {
+			break
+		}
+
+		select {
+		case <-batchesDone:
+			active--
+		case outcome := <-outcomes:
+			done, err := storeChunkOutcome(outcome, keep)
+			if done {
+				finish(outcome.task, outcome.peerID, len(outcome.chunk), err)
+				continue
+			}
+			pending = append(pending, outcome.task)
+		}
+	}
+	return fetches, firstErr
+}
+
+// Function that picks the next batch of pending chunks to request from a single peer
+// The peer is the next candidate for the first pending chunk, and the batch is filled with other pending chunks that
+// have not been asked of that peer yet. Chunks without any candidate left to ask are returned separately.
+func nextChunkBatch(pending []*chunkTask, candidates []peer.ID) (peer.ID, []*chunkTask, []*chunkTask, []*chunkTask) {
+	var peerID peer.ID
+	var batch, rest, exhausted []*chunkTask
+	for _, task := range pending {
+		if peerID == "" {
+			candidate, found := task.nextCandidate(candidates)
+			if !found {
+				exhausted = append(exhausted, task)
+				continue
+			}
+			peerID = candidate
+		}
+		if len(batch) < chunkBatchSize && !task.tried[peerID] {
+			task.tried[peerID] = true
+			if task.requested.IsZero() {
+				task.requested = time.Now()
+			}
+			batch = append(batch, task)
+			continue
+		}
+		rest = append(rest, task)
+	}
+	return peerID, batch, rest, exhausted
+}
+
+// Function that finds the next candidate that has not been asked for a chunk, skipping excluded peers
+func (task *chunkTask) nextCandidate(candidates []peer.ID) (peer.ID, bool) {
+	for attempt := 0; attempt < len(candidates); attempt++ {
+		candidate := candidates[(task.start+attempt)%len(candidates)]
+		if !task.tried[candidate] && !IsPeerExcluded(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Function that requests a batch of chunks from a peer, passing on the outcome of each chunk as soon as it is known
+func runChunkBatch(ctx context.Context, request chunkRequester, peerID peer.ID, batch []*chunkTask,
+	outcomes chan<- chunkOutcome, batchesDone chan<- struct{}) {
+	defer func() { batchesDone <- struct{}{} }()
+
+	tasks := make(map[string]*chunkTask, len(batch))
+	hashes := make([][]byte, len(batch))
+	for i, task := range batch {
+		tasks[hex.EncodeToString(task.hash)] = task
+		hashes[i] = task.hash
+	}
+	request(ctx, peerID, hashes, func(hash []byte, chunk []byte, err error) {
+		task, found := tasks[hex.EncodeToString(hash)]
+		if !found {
+			return
+		}
+		delete(tasks, hex.EncodeToString(hash))
+		outcomes <- chunkOutcome{task: task, peerID: peerID, chunk: chunk, err: err}
+	})
+
+	// Any chunk the request did not report on still needs to be asked of another peer
+	for _, task := range tasks {
+		outcomes <- chunkOutcome{task: task, peerID: peerID, err: ErrChunkNotFound}
+	}
+}
+
+// Function that verifies a chunk received from a peer and passes it to keep
+// It returns whether the chunk is done with (kept, or failed to be kept) rather than needing another peer
+func storeChunkOutcome(outcome chunkOutcome, keep chunkKeeper) (bool, error) {
+	task := outcome.task
+	if errors.Is(outcome.err, ErrChunkCorrupt) {
+		task.corruptPeers = append(task.corruptPeers, outcome.peerID)
+	}
+	if outcome.err != nil {
+		return false, nil
+	}
+
+	// Chunks are addressed by their hash under the algorithm their file was uploaded with
+	algorithm, valid := verify.IdentifyHash(outcome.chunk, task.hash)
+	RecordChunkVerification(outcome.peerID, valid)
+	if !valid {
+		task.corruptPeers = append(task.corruptPeers, outcome.peerID)
+		return false, nil
+	}
+	for _, corruptPeer := range task.corruptPeers {
+		go pushChunkRepair(corruptPeer, algorithm, task.hash, outcome.chunk)
+	}
+	return true, keep(algorithm, task.hash, outcome.chunk)
+}
+
+// Function that finds the peers to request a file's chunks from, best first
+func chunkCandidates(ctx context.Context, merkleRoot []byte) (candidates []peer.ID, err error) {
+	ctx, span := tracing.Start(ctx, "network.find_providers")
+	defer func() {
+		span.SetAttributes(attribute.Int("candidates", len(candidates)))
+		tracing.End(span, err)
+	}()
+
+	providers, err := FindProviders(ctx, merkleRoot, maxChunkProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, provider := range providers {
+		// Remember the provider's addresses so that a stream can be opened to it
+		nodeHost.Peerstore().AddAddrs(provider.ID, provider.Addrs, peerstore.TempAddrTTL)
+		candidates = append(candidates, provider.ID)
+	}
+
+	// Without any providers fall back to asking every connected peer
+	if len(candidates) == 0 {
+		for _, peerInfo := range GetPeers() {
+			candidates = append(candidates, peerInfo.ID)
+		}
+	}
+	candidates = withCachePeers(candidates)
+	return rankProviders(candidates, nodeHost.Peerstore().LatencyEWMA), nil
+}
+
+// Function that orders providers by reputation and then by latency, leaving out peers that should not be used
+// Providers whose latency has not been measured yet are placed after those with a known latency
+func rankProviders(candidates []peer.ID, latency func(peer.ID) time.Duration) []peer.ID {
+	ranked := RankPeers(candidates)
+	scores := make(map[peer.ID]float64, len(ranked))
+	latencies := make(map[peer.ID]time.Duration, len(ranked))
+	for _, peerID := range ranked {
+		scores[peerID] = GetReputation(peerID).Score
+		latencies[peerID] = latency(peerID)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if scores[ranked[i]] != scores[ranked[j]] {
+			return scores[ranked[i]] > scores[ranked[j]]
+		}
+		latencyI, latencyJ := latencies[ranked[i]], latencies[ranked[j]]
+		if latencyI == 0 || latencyJ == 0 {
+			return latencyJ == 0 && latencyI != 0
+		}
+		return latencyI < latencyJ
+	})
+	return ranked
+}
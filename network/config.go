@@ -0,0 +1,60 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+)
+
+// Config - Structure holding the settings used to start a node
+type Config struct {
+	Port          int                // TCP port the node listens on
+	QUICPort      int                // UDP port the node listens on for QUIC (0 disables QUIC)
+	WebSocketPort int                // TCP port the node listens on for WebSockets (0 disables WebSockets)
+	BootstrapAddr string             // Multiaddress of the bootstrap peer (empty if this is the first node)
+	IdentityKey   ed25519.PrivateKey // Ed25519 key used as the node's libp2p identity and for signing blocks
+
+	AllowedPeers []string // Peer IDs that are allowed to connect (empty allows every peer that is not banned)
+	BannedPeers  []string // Peer IDs that are refused at connection time
+	AllowedCIDRs []string // IP ranges that are allowed to connect (empty allows every range that is not banned)
+	BannedCIDRs  []string // IP ranges that are refused at connection time
+
+	EnableAutoNAT      bool     // Detect reachability with AutoNAT and request port mappings from the router
+	EnableRelay        bool     // Allow connections through circuit relays
+	EnableHolePunching bool     // Upgrade relayed connections to direct ones with DCUtR hole punching
+	RelayService       bool     // Act as a circuit relay for nodes behind NAT
+	StaticRelays       []string // Multiaddresses of relays to reserve slots on when the node is not reachable
+
+	EnableDHTDiscovery bool     // Discover peers advertising the protocol in the DHT
+	EnableMDNS         bool     // Discover peers on the local network through mDNS
+	StaticPeersFile    string   // File listing the multiaddresses of peers to connect to (empty disables it)
+	DNSSeeds           []string // Domains whose dnsaddr TXT records list peers to connect to
+	EnablePeerExchange bool     // Ask connected peers for samples of the peers they know
+
+	MinPeerVersion  string            // Minimum version peers must run for data to be placed on them (empty accepts every peer)
+	Bandwidth       BandwidthLimits   // Rates the node's transfers with peers are limited to (no limits if zero)
+	MessageRates    MessageRateLimits // Rates each peer may send each type of message at (nil applies the defaults)
+	MaxStorageBytes int64             // Bytes the chunk store may take up before chunks placed by peers are refused (0 for no quota)
+	FastSync        bool              // Catch up from a snapshot of a peer's chain up to the latest checkpoint when far behind
+	CacheOnly       bool              // Refuse placed chunks and fetch the chunks peers ask for into the relay cache instead
+	LightClient     bool              // Refuse placed chunks and only fetch chunks proven to belong to a file committed by a header
+	ClientOnly      bool              // Refuse placed chunks and query the DHT without serving it
+	BootstrapOnly   bool              // Refuse placed chunks and serve the DHT and peer discovery only
+
+	ProvidedContent   func() [][]byte     // Returns the hashes of all chunks and Merkle roots held by the node (may be nil)
+	ReprovideInterval time.Duration       // Interval the content is announced again at (0 applies the default)
+	Capabilities      func() Capabilities // Returns the capabilities the node advertises to its peers (nil advertises nothing)
+}
+
+// Function that builds the list of addresses the node listens on for each of its enabled transports
+func (config Config) listenAddrs() []string {
+	addrs := []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", config.Port)}
+	// QUIC runs over UDP so it can share the same port number as TCP
+	if config.QUICPort != 0 {
+		addrs = append(addrs, fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", config.QUICPort))
+	}
+	if config.WebSocketPort != 0 {
+		addrs = append(addrs, fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", config.WebSocketPort))
+	}
+	return addrs
+}
@@ -0,0 +1,26 @@
+package network
+
+import (
+	"blockchain-storage/storage"
+	"encoding/json"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Ledger of the storage the node provides to and consumes from its peers (nil keeps no ledger)
+var Ledger *storage.CreditLedger
+
+// Function that records a chunk stored for or by a peer in the credit ledger, with the signed acknowledgment of
+// storing it as the receipt
+func recordCredit(peerID peer.ID, direction string, size int, ack StoreAck) {
+	if Ledger == nil {
+		return
+	}
+	receipt, err := json.Marshal(ack)
+	if err == nil {
+		err = Ledger.Record(storage.CreditEntry{Peer: peerID.String(), Direction: direction, Hash: ack.Hash,
+			Size: int64(size), Receipt: receipt})
+	}
+	if err != nil {
+		logger.Warn("error encountered when recording storage credit", "peer", peerID, "error", err)
+	}
+}
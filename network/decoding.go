@@ -0,0 +1,260 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Largest message the node reads from a peer, which fits a chunk of the largest chunk size once base64 encoded in JSON
+// along with the rest of its fields
+const maxMessageSize = core.MaxChunkSize/3*4 + 64*1024
+
+// Largest message of a type that carries neither a chunk nor a block
+const maxControlMessageSize = 1024 * 1024
+
+// Largest number of chunks a single request or reply may name
+const maxRequestedChunks = 4096
+
+// Longest hash a peer may name a chunk or file by, which fits every supported algorithm and its multihash prefix
+const maxHashSize = 64
+
+// Largest number of steps in a Merkle proof, enough for a file with more chunks than could ever be committed
+const maxProofSteps = 64
+
+// Longest token a peer may hand out for resuming a chunk transfer
+const maxTokenSize = 128
+
+// Largest size of each type of message, so that a peer cannot make the node decode far more than the type ever needs
+// Blocks are bounded by the largest block size, with room for JSON encoding their fields less compactly than the wire
+// encoding. Types missing from the table are held to the control message size.
+var messageSizeLimits = map[MessageType]int{
+	SendNewBlock:     4 * core.MaxBlockSize,
+	SendEncodedBlock: 2 * core.MaxBlockSize,
+	SendChunks:       maxMessageSize,
+	StoreChunk:       maxMessageSize,
+}
+
+// Errors returned when a peer sends a message that the node refuses to decode
+var (
+	ErrMessageTooLarge  = errors.New("message exceeds the size limit of its type")
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+// payloadSchema - Interface implemented by payloads that limit their fields beyond what their types allow
+type payloadSchema interface {
+	validate() error
+}
+
+// Function that reads a single newline delimited message from a peer, refusing one longer than the limit of its type
+// The message is read a buffer at a time and its type is read from the start of the frame, so an oversized message is
+// refused without ever being held in full. Until the type is known the message is held to the smallest limit.
+func readFrame(reader *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	limit := maxControlMessageSize
+	typed := false
+	for {
+		line, err := reader.ReadSlice('\n')
+		if len(frame)+len(line) > maxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+		frame = append(frame, line...)
+		if !typed {
+			var messageType MessageType
+			messageType, typed = frameType(frame)
+			limit = messageSizeLimit(messageType)
+		}
+		if len(frame) > limit {
+			return nil, ErrMessageTooLarge
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return frame, err
+		}
+	}
+}
+
+// Function that reads the type of a message from the start of its frame, without decoding the rest of it
+// The node always encodes the type first, so the limit of the type is known before the payload is read. A frame that
+// does not start with its type gets the empty type, which is held to the smallest limit, and false is returned while
+// the frame is too short to tell.
+func frameType(frame []byte) (MessageType, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	var tokens [3]json.Token
+	for i := range tokens {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", false
+		}
+		if err != nil {
+			return "", true
+		}
+		tokens[i] = token
+	}
+	messageType, isString := tokens[2].(string)
+	if tokens[0] != json.Delim('{') || tokens[1] != "type" || !isString {
+		return "", true
+	}
+	return MessageType(messageType), true
+}
+
+// Function that decodes a message read from a peer, checking it against the size limit of its type
+// The limit is checked before the message is decoded, and the envelope is decoded strictly, as its fields never change
+// between versions of the protocol
+func decodeMessage(frame []byte) (Message, error) {
+	messageType, _ := frameType(frame)
+	if len(frame) > messageSizeLimit(messageType) {
+		return Message{}, fmt.Errorf("%w: %d byte %s message", ErrMessageTooLarge, len(frame), messageType)
+	}
+	var message Message
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&message)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("trailing data after message")
+		}
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return message, nil
+}
+
+// Function that returns the largest size of a type of message
+func messageSizeLimit(messageType MessageType) int {
+	if limit, found := messageSizeLimits[messageType]; found {
+		return limit
+	}
+	return maxControlMessageSize
+}
+
+// Function that decodes the payload of a message into its schema, checking the limits the schema places on its fields
+// Unknown fields are ignored so that newer peers can add optional fields, but the payload must be a single JSON value
+func decodePayload(payload json.RawMessage, value any) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	err := decoder.Decode(value)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("trailing data after payload")
+		}
+	}
+	if err == nil {
+		if schema, ok := value.(payloadSchema); ok {
+			err = schema.validate()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedMessage, err)
+	}
+	return nil
+}
+
+// Function that checks a list of hashes is no longer than a request may be and that every hash is a plausible length
+func validateHashes(hashes [][]byte) error {
+	if len(hashes) > maxRequestedChunks {
+		return fmt.Errorf("%d hashes named, at most %d are allowed", len(hashes), maxRequestedChunks)
+	}
+	for _, hash := range hashes {
+		if err := validateHash(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that checks a hash is neither empty nor longer than any supported algorithm produces
+func validateHash(hash []byte) error {
+	if len(hash) == 0 || len(hash) > maxHashSize {
+		return fmt.Errorf("invalid hash length %d", len(hash))
+	}
+	return nil
+}
+
+func (request *ChunkRequest) validate() error {
+	if request.MerkleRoot != nil {
+		if err := validateHash(request.MerkleRoot); err != nil {
+			return err
+		}
+	}
+	return validateHashes(request.Hashes)
+}
+
+func (availability *ChunkAvailability) validate() error {
+	if len(availability.Have)+len(availability.Missing) > maxRequestedChunks {
+		return fmt.Errorf("%d chunks named, at most %d are allowed", len(availability.Have)+len(availability.Missing),
+			maxRequestedChunks)
+	}
+	if len(availability.Token) > maxTokenSize {
+		return errors.New("resume token too long")
+	}
+	if err := validateHashes(availability.Have); err != nil {
+		return err
+	}
+	return validateHashes(availability.Missing)
+}
+
+func (response *ChunkResponse) validate() error {
+	if err := validateHash(response.Hash); err != nil {
+		return err
+	}
+	if len(response.Data) > core.MaxChunkSize || response.Streamed > core.MaxChunkSize {
+		return fmt.Errorf("chunk exceeds the largest chunk size")
+	}
+	if response.Streamed < 0 || (response.Streamed > 0 && (len(response.Data) > 0 || !response.Found)) {
+		return errors.New("invalid streamed chunk")
+	}
+	if response.Restricted != nil {
+		if err := validateHash(response.Restricted); err != nil {
+			return err
+		}
+	}
+	if len(response.Multihash) > maxHashSize {
+		return fmt.Errorf("invalid multihash length %d", len(response.Multihash))
+	}
+	if response.MerkleRoot != nil {
+		if err := validateHash(response.MerkleRoot); err != nil {
+			return err
+		}
+	}
+	if len(response.Proof) > maxProofSteps {
+		return fmt.Errorf("Merkle proof of %d steps is too long", len(response.Proof))
+	}
+	for _, step := range response.Proof {
+		if err := validateHash(step.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (request *ResumeRequest) validate() error {
+	if len(request.Token) > maxTokenSize {
+		return errors.New("resume token too long")
+	}
+	if len(request.Received) > (maxRequestedChunks+7)/8 {
+		return errors.New("received bitmap too long")
+	}
+	return nil
+}
+
+func (frame *ChunkFrame) validate() error {
+	if len(frame.Data) == 0 || len(frame.Data) > chunkFrameSize {
+		return fmt.Errorf("invalid chunk frame length %d", len(frame.Data))
+	}
+	return nil
+}
+
+func (update *WindowUpdate) validate() error {
+	if update.Frames != chunkWindowFrames/2 {
+		return fmt.Errorf("unexpected window update of %d frames", update.Frames)
+	}
+	return nil
+}
+
+func (ack *StoreAck) validate() error {
+	return validateHash(ack.Hash)
+}
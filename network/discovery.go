@@ -0,0 +1,254 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Number of candidate peers that can be waiting to be connected to
+const candidateBufferSize = 64
+
+// Prefix of the TXT records listing a DNS seed's peers (the same format as libp2p's dnsaddr)
+const dnsAddrPrefix = "dnsaddr="
+
+// DiscoveryBackend - Source of candidate peers for the node to connect to
+// Every enabled backend runs at the same time and sends the peers it finds on a shared channel
+type DiscoveryBackend interface {
+	Name() string                                                     // Name of the backend used in log messages
+	Start(ctx context.Context, candidates chan<- peer.AddrInfo) error // Starts discovering peers in the background
+}
+
+// Function that builds the discovery backends enabled in the node's configuration
+func discoveryBackends(config Config, host host.Host, routingDiscovery *routing.RoutingDiscovery) []DiscoveryBackend {
+	var backends []DiscoveryBackend
+	if config.StaticPeersFile != "" {
+		backends = append(backends, &staticPeersBackend{path: config.StaticPeersFile})
+	}
+	if config.EnableDHTDiscovery && routingDiscovery != nil {
+		backends = append(backends, &dhtBackend{routingDiscovery: routingDiscovery})
+	}
+	if config.EnableMDNS {
+		backends = append(backends, &mdnsBackend{host: host})
+	}
+	if len(config.DNSSeeds) > 0 {
+		backends = append(backends, &dnsBackend{seeds: config.DNSSeeds})
+	}
+	if config.EnablePeerExchange {
+		backends = append(backends, &pexBackend{})
+	}
+	return backends
+}
+
+// Function that starts every discovery backend and connects to the candidates they find until the context is done
+func startDiscovery(ctx context.Context, host host.Host, backends []DiscoveryBackend) error {
+	candidates := make(chan peer.AddrInfo, candidateBufferSize)
+	for _, backend := range backends {
+		err := backend.Start(ctx, candidates)
+		if err != nil {
+			return fmt.Errorf("failed to start %s discovery: %w", backend.Name(), err)
+		}
+	}
+	go connectCandidates(ctx, host, candidates)
+	return nil
+}
+
+// Function that connects to candidate peers from every discovery backend as they arrive
+func connectCandidates(ctx context.Context, host host.Host, candidates <-chan peer.AddrInfo) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case candidate := <-candidates:
+			// Skip this node itself, peers the operator has banned, and peers another backend already found
+			if candidate.ID == host.ID() || !Gater.peerAllowed(candidate.ID) || isKnownPeer(candidate.ID) {
+				continue
+			}
+			go func(candidate peer.AddrInfo) {
+				ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+				defer cancel()
+				err := host.Connect(ctx, candidate)
+				if err != nil {
+					logger.Debug("failed to connect to discovered peer", "peer", candidate.ID, "error", err)
+					return
+				}
+				addPeer(&candidate)
+			}(candidate)
+		}
+	}
+}
+
+// Function that checks whether a peer is already in the list of peers
+func isKnownPeer(peerID peer.ID) bool {
+	PeersMutex.Lock()
+	defer PeersMutex.Unlock()
+
+	for _, peerInfo := range Peers {
+		if peerInfo.ID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// Function that sends a candidate unless the context is done first
+func sendCandidate(ctx context.Context, candidates chan<- peer.AddrInfo, candidate peer.AddrInfo) bool {
+	select {
+	case candidates <- candidate:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// staticPeersBackend - Discovery backend reading peers from a file with one multiaddress per line
+type staticPeersBackend struct {
+	path string
+}
+
+func (backend *staticPeersBackend) Name() string {
+	return "static peers"
+}
+
+func (backend *staticPeersBackend) Start(ctx context.Context, candidates chan<- peer.AddrInfo) error {
+	file, err := os.Open(backend.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	peers, err := parseStaticPeers(file)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for _, peerInfo := range peers {
+			if !sendCandidate(ctx, candidates, peerInfo) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Function that parses a static peers file, skipping blank lines and lines starting with #
+func parseStaticPeers(reader io.Reader) ([]peer.AddrInfo, error) {
+	var peers []peer.AddrInfo
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peerInfo, err := parsePeerAddr(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %q: %w", line, err)
+		}
+		peers = append(peers, *peerInfo)
+	}
+	return peers, scanner.Err()
+}
+
+// dhtBackend - Discovery backend finding peers that advertise the protocol in the Kademlia DHT
+type dhtBackend struct {
+	routingDiscovery *routing.RoutingDiscovery
+}
+
+func (backend *dhtBackend) Name() string {
+	return "DHT"
+}
+
+func (backend *dhtBackend) Start(ctx context.Context, candidates chan<- peer.AddrInfo) error {
+	// Advertise that this node is accepting requests on the protocol so that other nodes can find it too
+	util.Advertise(ctx, backend.routingDiscovery, protocol)
+
+	peerChan, err := backend.routingDiscovery.FindPeers(ctx, protocol)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for peerInfo := range peerChan {
+			if !sendCandidate(ctx, candidates, peerInfo) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// mdnsBackend - Discovery backend finding peers on the local network through mDNS
+// This avoids needing a bootstrap node for LAN setups
+type mdnsBackend struct {
+	host       host.Host
+	ctx        context.Context
+	candidates chan<- peer.AddrInfo
+}
+
+func (backend *mdnsBackend) Name() string {
+	return "mDNS"
+}
+
+func (backend *mdnsBackend) Start(ctx context.Context, candidates chan<- peer.AddrInfo) error {
+	backend.ctx = ctx
+	backend.candidates = candidates
+	service := mdns.NewMdnsService(backend.host, protocol, backend)
+	return service.Start()
+}
+
+// HandlePeerFound - Called by the mDNS service whenever a peer advertising the protocol is found on the local network
+func (backend *mdnsBackend) HandlePeerFound(peerInfo peer.AddrInfo) {
+	sendCandidate(backend.ctx, backend.candidates, peerInfo)
+}
+
+// dnsBackend - Discovery backend reading peers from the TXT records of DNS seed domains
+// Each seed lists its peers as "dnsaddr=<multiaddress>" records under _dnsaddr.<domain>
+type dnsBackend struct {
+	seeds []string
+}
+
+func (backend *dnsBackend) Name() string {
+	return "DNS seed"
+}
+
+func (backend *dnsBackend) Start(ctx context.Context, candidates chan<- peer.AddrInfo) error {
+	go func() {
+		for _, seed := range backend.seeds {
+			records, err := net.DefaultResolver.LookupTXT(ctx, "_dnsaddr."+seed)
+			if err != nil {
+				logger.Warn("error encountered when resolving DNS seed", "seed", seed, "error", err)
+				continue
+			}
+			for _, peerInfo := range parseDNSAddrRecords(records) {
+				if !sendCandidate(ctx, candidates, peerInfo) {
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Function that extracts the peers listed in dnsaddr TXT records, ignoring any other or invalid records
+func parseDNSAddrRecords(records []string) []peer.AddrInfo {
+	var peers []peer.AddrInfo
+	for _, record := range records {
+		if !strings.HasPrefix(record, dnsAddrPrefix) {
+			continue
+		}
+		peerInfo, err := parsePeerAddr(strings.TrimPrefix(record, dnsAddrPrefix))
+		if err != nil {
+			continue
+		}
+		peers = append(peers, *peerInfo)
+	}
+	return peers
+}
@@ -0,0 +1,140 @@
+package network
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"net"
+	"sync"
+)
+
+// PeerGater - Connection gater enforcing the operator's allowed and banned peers and address ranges
+// If any allowed peers or ranges are configured, only connections matching them are accepted
+type PeerGater struct {
+	allowedPeers map[peer.ID]bool
+	bannedPeers  map[peer.ID]bool
+	allowedNets  []*net.IPNet
+	bannedNets   []*net.IPNet
+	mutex        sync.RWMutex
+}
+
+// Function that creates a connection gater from lists of peer ID and CIDR range strings
+func NewPeerGater(allowedPeers, bannedPeers, allowedCIDRs, bannedCIDRs []string) (*PeerGater, error) {
+	gater := &PeerGater{
+		allowedPeers: make(map[peer.ID]bool),
+		bannedPeers:  make(map[peer.ID]bool),
+	}
+
+	// Parse each of the peer ID lists
+	for _, list := range []struct {
+		ids    []string
+		target map[peer.ID]bool
+	}{{allowedPeers, gater.allowedPeers}, {bannedPeers, gater.bannedPeers}} {
+		for _, id := range list.ids {
+			peerID, err := peer.Decode(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer ID %s: %w", id, err)
+			}
+			list.target[peerID] = true
+		}
+	}
+
+	// Parse each of the CIDR range lists
+	var err error
+	gater.allowedNets, err = parseCIDRs(allowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	gater.bannedNets, err = parseCIDRs(bannedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return gater, nil
+}
+
+// Function that parses a list of CIDR range strings
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %s: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Function that bans a peer at runtime, disconnecting it if it is currently connected
+func (gater *PeerGater) BanPeer(peerID peer.ID) {
+	gater.mutex.Lock()
+	gater.bannedPeers[peerID] = true
+	gater.mutex.Unlock()
+	disconnectPeer(peerID)
+}
+
+// Function that checks whether a peer ID is permitted
+func (gater *PeerGater) peerAllowed(peerID peer.ID) bool {
+	gater.mutex.RLock()
+	defer gater.mutex.RUnlock()
+
+	if gater.bannedPeers[peerID] {
+		return false
+	}
+	// An empty allowlist means every peer that is not banned is allowed
+	return len(gater.allowedPeers) == 0 || gater.allowedPeers[peerID]
+}
+
+// Function that checks whether an address is permitted based on the IP ranges
+// Addresses without an IP component (e.g. DNS or relay addresses) are only rejected if an allowlist is configured
+func (gater *PeerGater) addrAllowed(addr multiaddr.Multiaddr) bool {
+	gater.mutex.RLock()
+	defer gater.mutex.RUnlock()
+
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return len(gater.allowedNets) == 0
+	}
+	for _, ipNet := range gater.bannedNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(gater.allowedNets) == 0 {
+		return true
+	}
+	for _, ipNet := range gater.allowedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// InterceptPeerDial - Refuses outbound dials to banned peers
+func (gater *PeerGater) InterceptPeerDial(peerID peer.ID) bool {
+	return gater.peerAllowed(peerID)
+}
+
+// InterceptAddrDial - Refuses outbound dials to banned peers or addresses
+func (gater *PeerGater) InterceptAddrDial(peerID peer.ID, addr multiaddr.Multiaddr) bool {
+	return gater.peerAllowed(peerID) && gater.addrAllowed(addr)
+}
+
+// InterceptAccept - Refuses inbound connections from banned addresses before any handshake takes place
+func (gater *PeerGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return gater.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+// InterceptSecured - Refuses connections from banned peers once their identity is known
+func (gater *PeerGater) InterceptSecured(direction network.Direction, peerID peer.ID, addrs network.ConnMultiaddrs) bool {
+	return gater.peerAllowed(peerID) && gater.addrAllowed(addrs.RemoteMultiaddr())
+}
+
+// InterceptUpgraded - Accepts all fully upgraded connections as they have already passed the other checks
+func (gater *PeerGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
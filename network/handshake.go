@@ -0,0 +1,308 @@
+package network
+
+import (
+	"blockchain-storage/events"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Protocol used for the handshake that takes place whenever two nodes connect
+const handshakeProtocol = "/blockchain-storage/handshake/1.0.0"
+
+// Maximum time allowed for a handshake to complete
+const handshakeTimeout = 10 * time.Second
+
+// Largest clock offset recorded for a peer either way, so that a peer misreporting its clock can only shift the
+// timestamps it is judged by this much
+const maxClockOffset = 30 * time.Second
+
+// Name of the canonical wire encoding of blocks, advertised in handshakes by nodes that accept blocks in it
+const wireBlockEncoding = "wire/1"
+
+// Version of the protocol the node speaks, raised whenever a change is made that older nodes do not understand
+// Version 1 added heartbeats, which nodes speaking version 0 (from before versions were negotiated) do not answer,
+// version 2 added chunks compressed for transit, version 3 added the sync protocol serving snapshots and blocks,
+// version 4 added peer exchange and version 5 added chunks streamed in frames with flow control
+const protocolVersion = 5
+
+// Oldest version of the protocol the node still speaks, which peers are downgraded to if that is all they speak
+const minProtocolVersion = 0
+
+// Error returned when a peer only speaks protocol versions the node does not
+var ErrProtocolVersion = errors.New("peer speaks no protocol version in common with the node")
+
+// Error returned when a peer's chain starts from a different genesis block, meaning it is on another network
+var ErrGenesisMismatch = errors.New("peer's chain has a different genesis block")
+
+// HandshakeRequest - Message sent by the node that opens the handshake
+type HandshakeRequest struct {
+	SentAt         time.Time `json:"sentAt"`                   // Time the request was sent according to the sender's clock
+	BlockEncodings []string  `json:"blockEncodings,omitempty"` // Block encodings the sender accepts besides JSON
+
+	Version     *NodeVersion `json:"version,omitempty"`     // Build the sender runs
+	ChainLength int          `json:"chainLength,omitempty"` // Number of blocks in the sender's chain
+
+	ProtocolVersion    int      `json:"protocolVersion,omitempty"`    // Newest protocol version the sender speaks
+	MinProtocolVersion int      `json:"minProtocolVersion,omitempty"` // Oldest protocol version the sender speaks
+	GenesisHash        []byte   `json:"genesisHash,omitempty"`        // Hash of the first block of the sender's chain
+	Roles              []string `json:"roles,omitempty"`              // Roles the sender takes on (e.g. storage, relay or light)
+}
+
+// HandshakeResponse - Message sent back by the node receiving the handshake
+type HandshakeResponse struct {
+	ReceivedAt     time.Time `json:"receivedAt"`               // Time the request was received according to the responder's clock
+	SentAt         time.Time `json:"sentAt"`                   // Time the response was sent according to the responder's clock
+	BlockEncodings []string  `json:"blockEncodings,omitempty"` // Block encodings the responder accepts besides JSON
+
+	Version     *NodeVersion `json:"version,omitempty"`     // Build the responder runs
+	ChainLength int          `json:"chainLength,omitempty"` // Number of blocks in the responder's chain
+
+	ProtocolVersion    int      `json:"protocolVersion,omitempty"`    // Newest protocol version the responder speaks
+	MinProtocolVersion int      `json:"minProtocolVersion,omitempty"` // Oldest protocol version the responder speaks
+	GenesisHash        []byte   `json:"genesisHash,omitempty"`        // Hash of the first block of the responder's chain
+	Roles              []string `json:"roles,omitempty"`              // Roles the responder takes on (e.g. storage, relay or light)
+	Refused            string   `json:"refused,omitempty"`            // Why the responder refused the connection (empty if accepted)
+}
+
+// PeerProtocol - Structure describing what was agreed with a peer in its handshake
+type PeerProtocol struct {
+	Version int      `json:"version"` // Protocol version both nodes speak, the newest they have in common
+	Roles   []string `json:"roles"`   // Roles the peer takes on
+}
+
+// Function returning the roles the node advertises in handshakes (nil advertises none)
+var localRoles func() []string
+
+// Protocol versions and roles agreed with peers in their handshakes
+var peerProtocols = make(map[peer.ID]PeerProtocol)
+var peerProtocolsMutex = &sync.Mutex{}
+
+// Estimated clock offsets of peers, by how far ahead of the local clock their clocks are
+var clockOffsets = make(map[peer.ID]time.Duration)
+var clockOffsetsMutex = &sync.Mutex{}
+
+// Peers that accept blocks in the canonical wire encoding (nodes that do not are sent blocks as JSON)
+var wireBlockPeers = make(map[peer.ID]bool)
+var wireBlockPeersMutex = &sync.Mutex{}
+
+// Function that handles a handshake opened by another node
+func handleHandshake(stream network.Stream) {
+	defer stream.Close()
+	receivedAt := time.Now()
+	stream.SetDeadline(receivedAt.Add(handshakeTimeout))
+
+	var request HandshakeRequest
+	err := json.NewDecoder(stream).Decode(&request)
+	if err != nil {
+		logger.Warn("error encountered when reading handshake", "peer", stream.Conn().RemotePeer(), "error", err)
+		return
+	}
+	peerID := stream.Conn().RemotePeer()
+	version := BuildVersion()
+	response := HandshakeResponse{ReceivedAt: receivedAt, BlockEncodings: []string{wireBlockEncoding}, Version: &version,
+		ChainLength: localChainLength(), ProtocolVersion: protocolVersion, MinProtocolVersion: minProtocolVersion,
+		GenesisHash: localGenesisHash(), Roles: handshakeRoles()}
+	agreed, refusal := negotiateProtocol(request.ProtocolVersion, request.MinProtocolVersion, request.GenesisHash)
+	if refusal != nil {
+		// The reason is sent back so that the peer can report it, then the connection is closed
+		response.Refused = refusal.Error()
+	}
+	response.SentAt = time.Now()
+	err = json.NewEncoder(stream).Encode(response)
+	if refusal != nil {
+		logger.Warn("refused handshake with peer", "peer", peerID, "protocolVersion", request.ProtocolVersion,
+			"error", refusal)
+		disconnectPeer(peerID)
+		return
+	}
+	if err != nil {
+		logger.Error("error encountered when replying to handshake", "peer", peerID, "error", err)
+		return
+	}
+	setPeerProtocol(peerID, PeerProtocol{Version: agreed, Roles: request.Roles})
+	setBlockEncodings(peerID, request.BlockEncodings)
+	setPeerVersion(peerID, request.Version)
+	setPeerChainLength(peerID, request.ChainLength)
+	peerConnected(peerID)
+}
+
+// Function that performs a handshake with a newly connected peer and records its clock offset
+func performHandshake(ctx context.Context, peerID peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := nodeHost.NewStream(ctx, peerID, handshakeProtocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	sentAt := time.Now()
+	version := BuildVersion()
+	err = json.NewEncoder(stream).Encode(HandshakeRequest{SentAt: sentAt, BlockEncodings: []string{wireBlockEncoding}, Version: &version,
+		ChainLength: localChainLength(), ProtocolVersion: protocolVersion, MinProtocolVersion: minProtocolVersion,
+		GenesisHash: localGenesisHash(), Roles: handshakeRoles()})
+	if err != nil {
+		return err
+	}
+	var response HandshakeResponse
+	err = json.NewDecoder(stream).Decode(&response)
+	if err != nil {
+		return err
+	}
+	receivedAt := time.Now()
+
+	// Peers refusing the connection close it themselves, but older peers that cannot refuse are disconnected here
+	if response.Refused != "" {
+		disconnectPeer(peerID)
+		return fmt.Errorf("peer refused the handshake: %s", response.Refused)
+	}
+	agreed, err := negotiateProtocol(response.ProtocolVersion, response.MinProtocolVersion, response.GenesisHash)
+	if err != nil {
+		disconnectPeer(peerID)
+		return err
+	}
+
+	setPeerProtocol(peerID, PeerProtocol{Version: agreed, Roles: response.Roles})
+	setClockOffset(peerID, estimateClockOffset(sentAt, response.ReceivedAt, response.SentAt, receivedAt))
+	setBlockEncodings(peerID, response.BlockEncodings)
+	setPeerVersion(peerID, response.Version)
+	setPeerChainLength(peerID, response.ChainLength)
+	logger.Debug("shook hands with peer", "peer", peerID, "version", response.Version, "chainLength", response.ChainLength,
+		"protocolVersion", agreed)
+	peerConnected(peerID)
+	return nil
+}
+
+// Function that announces a peer whose handshake completed and checks whether it shows the local chain to be behind,
+// syncing with the peer if it does
+func peerConnected(peerID peer.ID) {
+	Events.Publish(events.Event{Type: events.PeerConnected, Peer: peerID.String()})
+	updateSyncState()
+	peerChainLengthsMutex.Lock()
+	peerLength := peerChainLengths[peerID]
+	peerChainLengthsMutex.Unlock()
+	if peerLength > localChainLength() {
+		go syncWithPeer(nodeContext, peerID)
+	}
+}
+
+// Function that agrees on the protocol version to speak with a peer from the versions it speaks and its genesis block
+// The newest version both nodes speak is used, so newer nodes downgrade to talk to older ones. Peers that do not send
+// a version are treated as speaking version 0, and peers that do not send a genesis hash are not checked against it.
+func negotiateProtocol(peerVersion, peerMinVersion int, peerGenesisHash []byte) (int, error) {
+	genesisHash := localGenesisHash()
+	if len(genesisHash) > 0 && len(peerGenesisHash) > 0 && !bytes.Equal(genesisHash, peerGenesisHash) {
+		return 0, ErrGenesisMismatch
+	}
+	agreed := min(protocolVersion, peerVersion)
+	if agreed < minProtocolVersion || agreed < peerMinVersion {
+		return 0, fmt.Errorf("%w: node speaks versions %d to %d, peer speaks %d to %d", ErrProtocolVersion,
+			minProtocolVersion, protocolVersion, peerMinVersion, peerVersion)
+	}
+	return agreed, nil
+}
+
+// Function that returns the hash of the first block of the local chain (nil if there is no chain yet)
+func localGenesisHash() []byte {
+	if Chain == nil {
+		return nil
+	}
+	// Pruned blocks keep their header, so the genesis hash is known however far the chain has been pruned
+	genesis, err := Chain.GetBlockByHeight(0)
+	if err != nil {
+		return nil
+	}
+	return genesis.Hash
+}
+
+// Function that returns the roles the node advertises in handshakes
+func handshakeRoles() []string {
+	if localRoles == nil {
+		return nil
+	}
+	return localRoles()
+}
+
+// Function that records what was agreed with a peer in its handshake
+func setPeerProtocol(peerID peer.ID, agreed PeerProtocol) {
+	peerProtocolsMutex.Lock()
+	defer peerProtocolsMutex.Unlock()
+	peerProtocols[peerID] = agreed
+}
+
+// Function that returns what was agreed with a peer in its handshake, if a handshake with it has completed
+func GetPeerProtocol(peerID peer.ID) (PeerProtocol, bool) {
+	peerProtocolsMutex.Lock()
+	defer peerProtocolsMutex.Unlock()
+	agreed, found := peerProtocols[peerID]
+	return agreed, found
+}
+
+// Function that estimates how far ahead a peer's clock is of the local clock (negative if it is behind)
+// This is the same calculation as NTP, which assumes the network delay is equal in both directions:
+// requestSent and responseReceived are local times, while requestReceived and responseSent are the peer's times
+func estimateClockOffset(requestSent, requestReceived, responseSent, responseReceived time.Time) time.Duration {
+	return (requestReceived.Sub(requestSent) + responseSent.Sub(responseReceived)) / 2
+}
+
+// Function that stores the estimated clock offset of a peer, clamped to maxClockOffset
+func setClockOffset(peerID peer.ID, offset time.Duration) {
+	clockOffsetsMutex.Lock()
+	defer clockOffsetsMutex.Unlock()
+	clockOffsets[peerID] = min(max(offset, -maxClockOffset), maxClockOffset)
+}
+
+// Function that returns the estimated clock offset of a peer (zero if no handshake has completed)
+func GetClockOffset(peerID peer.ID) time.Duration {
+	clockOffsetsMutex.Lock()
+	defer clockOffsetsMutex.Unlock()
+	return clockOffsets[peerID]
+}
+
+// Function that records whether a peer accepts blocks in the canonical wire encoding from the encodings it advertised
+func setBlockEncodings(peerID peer.ID, encodings []string) {
+	wireBlockPeersMutex.Lock()
+	defer wireBlockPeersMutex.Unlock()
+	wireBlockPeers[peerID] = slices.Contains(encodings, wireBlockEncoding)
+}
+
+// Function that returns whether a peer accepts blocks in the canonical wire encoding
+func acceptsWireBlocks(peerID peer.ID) bool {
+	wireBlockPeersMutex.Lock()
+	defer wireBlockPeersMutex.Unlock()
+	return wireBlockPeers[peerID]
+}
+
+// Function that converts a time reported by a peer into the equivalent time on the local clock
+func ToLocalTime(peerID peer.ID, peerTime time.Time) time.Time {
+	return peerTime.Add(-GetClockOffset(peerID))
+}
+
+// Function that registers the handshake so that it takes place on every new connection
+func registerHandshake(ctx context.Context) {
+	nodeHost.SetStreamHandler(handshakeProtocol, handleHandshake)
+	nodeHost.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			// Only the node that opened the connection starts the handshake, so each pair of nodes shakes hands once
+			if conn.Stat().Direction != network.DirOutbound {
+				return
+			}
+			go func() {
+				err := performHandshake(ctx, conn.RemotePeer())
+				if err != nil {
+					logger.Warn("handshake with peer failed", "peer", conn.RemotePeer(), "error", err)
+				}
+			}()
+		},
+	})
+}
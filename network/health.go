@@ -0,0 +1,103 @@
+package network
+
+import (
+	"blockchain-storage/events"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of blocks the local chain may be behind the longest chain reported by a peer and still count as synced,
+// so that a block still on its way to the node does not hold uploads back
+const maxSyncLag = 1
+
+// Error returned when the network is not healthy enough for an upload's chunks to be placed
+var ErrNetworkUnhealthy = errors.New("network is not healthy enough to upload to")
+
+// Lengths of the chains reported by peers in handshakes and implied by the blocks they send
+var peerChainLengths = make(map[peer.ID]int)
+var peerChainLengthsMutex = &sync.Mutex{}
+
+// Function that records the length of a peer's chain, keeping the longest length it has reported
+func setPeerChainLength(peerID peer.ID, length int) {
+	peerChainLengthsMutex.Lock()
+	defer peerChainLengthsMutex.Unlock()
+	if length > peerChainLengths[peerID] {
+		peerChainLengths[peerID] = length
+	}
+}
+
+// Function that returns the length of the longest chain reported by a connected peer
+func bestPeerChainLength() int {
+	peers := GetPeers()
+	peerChainLengthsMutex.Lock()
+	defer peerChainLengthsMutex.Unlock()
+	best := 0
+	for _, peerInfo := range peers {
+		best = max(best, peerChainLengths[peerInfo.ID])
+	}
+	return best
+}
+
+// Whether the local chain was last found to be behind the longest chain reported by a peer
+var syncBehind atomic.Bool
+
+// Function that records whether the local chain is synced with its peers, publishing SyncCompleted when a chain that
+// was behind catches up
+func updateSyncState() {
+	local, best := localChainLength(), bestPeerChainLength()
+	if local+maxSyncLag < best {
+		syncBehind.Store(true)
+		return
+	}
+	if syncBehind.Swap(false) {
+		logger.Info("chain synced with peers", "length", local)
+		Events.Publish(events.Event{Type: events.SyncCompleted, Height: int64(local - 1)})
+	}
+}
+
+// Function that returns whether the local chain is synced with the longest chain reported by a connected peer
+func Synced() bool {
+	return localChainLength()+maxSyncLag >= bestPeerChainLength()
+}
+
+// Function that returns the length of the local chain (0 if the node has no chain)
+func localChainLength() int {
+	if Chain == nil {
+		return 0
+	}
+	return Chain.Length()
+}
+
+// Function that counts the connected peers data could be placed on: peers advertising storage capacity that are not
+// excluded, run an accepted version and have not recently refused a chunk for being over their quota
+func StoragePeerCount() int {
+	count := 0
+	for _, peerInfo := range GetPeers() {
+		capabilities, found := GetCapabilities(peerInfo.ID)
+		if !found || capabilities.FreeSpace <= 0 || !slices.Contains(capabilities.Roles, StorageRole) {
+			continue
+		}
+		if !IsPeerExcluded(peerInfo.ID) && acceptablePeerVersion(peerInfo.ID) && !isPeerFull(peerInfo.ID, time.Now()) {
+			count++
+		}
+	}
+	return count
+}
+
+// Function that checks whether the network is healthy enough for uploads: at least the given number of storage
+// peers must be connected and the local chain must be synced with the chains of its peers
+// The error explains what is unhealthy and wraps ErrNetworkUnhealthy
+func CheckHealth(minStoragePeers int) error {
+	if count := StoragePeerCount(); count < minStoragePeers {
+		return fmt.Errorf("%w: %d of %d storage peers connected", ErrNetworkUnhealthy, count, minStoragePeers)
+	}
+	if local, best := localChainLength(), bestPeerChainLength(); local+maxSyncLag < best {
+		return fmt.Errorf("%w: chain has %d blocks but peers have %d", ErrNetworkUnhealthy, local, best)
+	}
+	return nil
+}
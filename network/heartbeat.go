@@ -0,0 +1,162 @@
+package network
+
+import (
+	"blockchain-storage/events"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"sync"
+	"time"
+)
+
+// Protocol used for the heartbeats that check connected peers are still alive
+const heartbeatProtocol = "/blockchain-storage/heartbeat/1.0.0"
+
+// First protocol version in which peers answer heartbeats
+const heartbeatProtocolVersion = 1
+
+// Interval between heartbeats sent to each connected peer
+const heartbeatInterval = 30 * time.Second
+
+// Maximum time a peer has to answer a heartbeat before it counts as missed
+const heartbeatTimeout = 10 * time.Second
+
+// Number of heartbeats in a row a peer can miss before it is considered dead and pruned
+const maxMissedHeartbeats = 3
+
+// Heartbeat - Message sent to a peer to check it is alive, which the peer echoes back
+type Heartbeat struct {
+	Nonce  uint64    `json:"nonce"`  // Number identifying the heartbeat, echoed back in the reply
+	SentAt time.Time `json:"sentAt"` // Time the heartbeat was sent according to the sender's clock
+}
+
+// Number of heartbeats each peer has missed in a row
+var missedHeartbeats = make(map[peer.ID]int)
+var missedHeartbeatsMutex = &sync.Mutex{}
+
+// Function that handles a heartbeat sent by another node
+func handleHeartbeat(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(heartbeatTimeout))
+	err := answerHeartbeat(stream)
+	if err != nil {
+		logger.Warn("error encountered when answering heartbeat", "peer", stream.Conn().RemotePeer(), "error", err)
+	}
+}
+
+// Function that reads a heartbeat from a stream and echoes it back
+func answerHeartbeat(rw io.ReadWriter) error {
+	var heartbeat Heartbeat
+	err := json.NewDecoder(rw).Decode(&heartbeat)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(rw).Encode(heartbeat)
+}
+
+// Function that sends a heartbeat to a peer and waits for it to be echoed back, returning the round trip time
+func sendHeartbeat(ctx context.Context, peerID peer.ID, nonce uint64) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, heartbeatTimeout)
+	defer cancel()
+
+	stream, err := nodeHost.NewStream(ctx, peerID, heartbeatProtocol)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(heartbeatTimeout))
+
+	sentAt := time.Now()
+	err = json.NewEncoder(stream).Encode(Heartbeat{Nonce: nonce, SentAt: sentAt})
+	if err != nil {
+		return 0, err
+	}
+	var reply Heartbeat
+	err = json.NewDecoder(stream).Decode(&reply)
+	if err != nil {
+		return 0, err
+	}
+	if reply.Nonce != nonce {
+		return 0, errors.New("peer echoed the wrong heartbeat")
+	}
+	return time.Since(sentAt), nil
+}
+
+// Function that records whether a peer answered a heartbeat, pruning it once it misses too many in a row
+// Returns whether the peer was pruned
+func recordHeartbeat(peerID peer.ID, answered bool) bool {
+	missedHeartbeatsMutex.Lock()
+	if answered {
+		delete(missedHeartbeats, peerID)
+		missedHeartbeatsMutex.Unlock()
+		return false
+	}
+	missedHeartbeats[peerID]++
+	dead := missedHeartbeats[peerID] >= maxMissedHeartbeats
+	if dead {
+		delete(missedHeartbeats, peerID)
+	}
+	missedHeartbeatsMutex.Unlock()
+
+	if dead {
+		prunePeer(peerID)
+	}
+	return dead
+}
+
+// Function that drops every record of a dead peer, so that it is no longer offered data, asked for chunks or
+// returned by provider lookups answered by this node
+func prunePeer(peerID peer.ID) {
+	logger.Info("pruned peer that stopped answering heartbeats", "peer", peerID)
+	disconnectPeer(peerID)
+	peerChainLengthsMutex.Lock()
+	delete(peerChainLengths, peerID)
+	peerChainLengthsMutex.Unlock()
+	peerProtocolsMutex.Lock()
+	delete(peerProtocols, peerID)
+	peerProtocolsMutex.Unlock()
+	if localDHT != nil {
+		localDHT.RoutingTable().RemovePeer(peerID)
+	}
+	if nodeHost != nil {
+		nodeHost.Peerstore().ClearAddrs(peerID)
+	}
+	Events.Publish(events.Event{Type: events.PeerLost, Peer: peerID.String()})
+}
+
+// Function that sends a heartbeat to every connected peer at each interval until the context is cancelled
+func runHeartbeats(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	var nonce uint64
+	for {
+		select {
+		case <-ticker.C:
+			for _, peerInfo := range GetPeers() {
+				// Peers speaking a protocol version without heartbeats would never answer, so they are left alone
+				if agreed, found := GetPeerProtocol(peerInfo.ID); found && agreed.Version < heartbeatProtocolVersion {
+					continue
+				}
+				nonce++
+				// Heartbeats are sent concurrently so that peers that do not answer do not hold up the others
+				go func(peerID peer.ID, nonce uint64) {
+					rtt, err := sendHeartbeat(ctx, peerID, nonce)
+					if err != nil && ctx.Err() != nil {
+						return
+					}
+					if err != nil {
+						logger.Debug("peer missed heartbeat", "peer", peerID, "error", err)
+					} else {
+						logger.Debug("peer answered heartbeat", "peer", peerID, "rtt", rtt)
+					}
+					recordHeartbeat(peerID, err == nil)
+				}(peerInfo.ID, nonce)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
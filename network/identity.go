@@ -0,0 +1,43 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Function that returns the peer ID a node with the given identity key has on the network
+func PeerIDFromKey(identityKey ed25519.PrivateKey) (peer.ID, error) {
+	_, publicKey, err := crypto.KeyPairFromStdKey(&identityKey)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(publicKey)
+}
+
+// Function that returns the full multiaddresses of a peer (ending in /p2p/<peer ID>), as used for bootstrap addresses
+func PeerAddrs(peerID peer.ID, addrs []multiaddr.Multiaddr) ([]string, error) {
+	fullAddrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: peerID, Addrs: addrs})
+	if err != nil {
+		return nil, err
+	}
+	strAddrs := make([]string, len(fullAddrs))
+	for i, addr := range fullAddrs {
+		strAddrs[i] = addr.String()
+	}
+	return strAddrs, nil
+}
+
+// Function that returns the full multiaddresses the running node can be reached on (empty until the node is started)
+func GetHostAddrs() []string {
+	if nodeHost == nil {
+		return []string{}
+	}
+	addrs, err := PeerAddrs(nodeHost.ID(), nodeHost.Addrs())
+	if err != nil {
+		logger.Warn("error encountered when building the node's multiaddresses", "error", err)
+		return []string{}
+	}
+	return addrs
+}
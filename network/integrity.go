@@ -0,0 +1,93 @@
+package network
+
+import (
+	"expvar"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sync"
+	"time"
+)
+
+// Minimum number of chunks a peer must have served before its failure rate is used to exclude it
+const integrityMinSamples = 5
+
+// Failure rate above which a peer is temporarily excluded from chunk requests
+const integrityMaxFailureRate = 0.2
+
+// Duration for which a peer with too many failed chunks is excluded
+const integrityExclusionPeriod = 10 * time.Minute
+
+// IntegrityStats - Structure holding the chunk verification statistics for a single peer
+type IntegrityStats struct {
+	Verified      int       `json:"verified"`      // Number of served chunks that passed Merkle verification
+	Failed        int       `json:"failed"`        // Number of served chunks that failed Merkle verification
+	ExcludedUntil time.Time `json:"excludedUntil"` // Time until which the peer is excluded from chunk requests
+}
+
+// Function to calculate the fraction of served chunks that failed verification
+func (stats *IntegrityStats) FailureRate() float64 {
+	total := stats.Verified + stats.Failed
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Failed) / float64(total)
+}
+
+var integrityStats = make(map[peer.ID]*IntegrityStats)
+var integrityMutex = &sync.Mutex{}
+
+func init() {
+	// Expose the statistics as a metric so that they can be scraped from the node's API
+	expvar.Publish("chunkIntegrity", expvar.Func(func() any {
+		stats := GetIntegrityStats()
+		published := make(map[string]IntegrityStats, len(stats))
+		for peerID, peerStats := range stats {
+			published[peerID.String()] = peerStats
+		}
+		return published
+	}))
+}
+
+// Function that records whether a chunk served by a peer passed verification
+// Once a peer has served enough chunks, it is excluded for a period if its failure rate is too high
+func RecordChunkVerification(peerID peer.ID, valid bool) {
+	integrityMutex.Lock()
+	defer integrityMutex.Unlock()
+
+	stats, found := integrityStats[peerID]
+	if !found {
+		stats = &IntegrityStats{}
+		integrityStats[peerID] = stats
+	}
+	if valid {
+		stats.Verified++
+		RecordReputationEvent(peerID, EventChunkVerified)
+		return
+	}
+	stats.Failed++
+	RecordReputationEvent(peerID, EventChunkFailed)
+
+	if stats.Verified+stats.Failed >= integrityMinSamples && stats.FailureRate() > integrityMaxFailureRate {
+		stats.ExcludedUntil = time.Now().Add(integrityExclusionPeriod)
+	}
+}
+
+// Function that checks whether a peer is currently excluded from chunk requests
+func IsPeerExcluded(peerID peer.ID) bool {
+	integrityMutex.Lock()
+	defer integrityMutex.Unlock()
+
+	stats, found := integrityStats[peerID]
+	return found && time.Now().Before(stats.ExcludedUntil)
+}
+
+// Function that returns a copy of the chunk verification statistics of every peer
+func GetIntegrityStats() map[peer.ID]IntegrityStats {
+	integrityMutex.Lock()
+	defer integrityMutex.Unlock()
+
+	stats := make(map[peer.ID]IntegrityStats, len(integrityStats))
+	for peerID, peerStats := range integrityStats {
+		stats[peerID] = *peerStats
+	}
+	return stats
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"bytes"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Role advertised by light clients, which keep only the chain's headers and store nothing but the files they retrieve
+const LightRole = "light"
+
+// Error returned when a light client fetches the chunks of a file that none of its headers commits
+var ErrRootNotCommitted = errors.New("file's Merkle root is not committed by any header")
+
+// Error returned when a peer serves a chunk without a valid proof that it belongs to the file it was requested for
+var ErrChunkUnproven = errors.New("peer did not prove the chunk belongs to the requested file")
+
+// The node's store of the proofs that chunks placed on it belong to their files, which are served to peers asking for
+// proofs along with the chunks (may be nil)
+var Proofs *storage.ProofStore
+
+// Whether the node is a light client, refusing chunks placed on it and only accepting chunks proven to belong to a
+// file committed by one of its headers (set when the node starts)
+var lightClient bool
+
+// Function that returns whether a peer is a light client, going by the roles it gave in its handshake
+func isLightPeer(peerID peer.ID) bool {
+	return hasExclusiveRole(peerID, LightRole)
+}
+
+// Function that returns the Merkle root the chunks of a file must be proven to belong to when fetched (nil if the node
+// checks chunks against their hashes alone)
+// A light client holds no blocks to check a manifest against, so it only fetches files committed by its headers and
+// has every chunk proven to belong to the committed root.
+func proofRoot(merkleRoot []byte) ([]byte, error) {
+	if !lightClient {
+		return nil, nil
+	}
+	if Chain == nil {
+		return nil, ErrRootNotCommitted
+	}
+	if _, err := Chain.GetBlockByMerkelRoot(merkleRoot); err != nil {
+		return nil, ErrRootNotCommitted
+	}
+	return merkleRoot, nil
+}
+
+// Function that attaches the proof that a chunk belongs to the file with a Merkle root, if the node holds one
+func (response *ChunkResponse) attachProof(merkleRoot []byte) {
+	if merkleRoot == nil || !response.Found {
+		return
+	}
+	proof, err := Proofs.GetProof(merkleRoot, response.Hash)
+	if err != nil {
+		return
+	}
+	response.MerkleRoot = merkleRoot
+	response.Proof = proof
+}
+
+// Function that checks a chunk's data matches its hash and is proven to be a leaf of the file with a Merkle root
+func (response *ChunkResponse) provenIn(merkleRoot []byte) bool {
+	if !bytes.Equal(response.MerkleRoot, merkleRoot) {
+		return false
+	}
+	algorithm, valid := response.verifyData()
+	return valid && verify.MerkleProofWith(algorithm, response.Data, merkleRoot, response.Proof)
+}
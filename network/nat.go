@@ -0,0 +1,52 @@
+package network
+
+import (
+	"errors"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Function that builds the libp2p options that let nodes behind NAT participate in the network
+func natOptions(config Config) ([]libp2p.Option, error) {
+	var options []libp2p.Option
+
+	// AutoNAT lets the node learn whether it is publicly reachable (and help other nodes learn the same), while port
+	// mapping asks the router (via UPnP or NAT-PMP) to forward a port to the node
+	if config.EnableAutoNAT {
+		options = append(options, libp2p.EnableNATService(), libp2p.NATPortMap())
+	}
+
+	if !config.EnableRelay {
+		// Hole punching is coordinated through a relayed connection so it cannot work without relay support
+		if config.EnableHolePunching {
+			return nil, errors.New("hole punching requires relay support to be enabled")
+		}
+		return append(options, libp2p.DisableRelay()), nil
+	}
+
+	// Allow the node to dial and be dialled through circuit relays
+	options = append(options, libp2p.EnableRelay())
+	if len(config.StaticRelays) > 0 {
+		var relays []peer.AddrInfo
+		for _, relayAddr := range config.StaticRelays {
+			relay, err := parsePeerAddr(relayAddr)
+			if err != nil {
+				return nil, err
+			}
+			relays = append(relays, *relay)
+		}
+		// If the node finds out it is unreachable, it reserves a slot on one of these relays and advertises it
+		options = append(options, libp2p.EnableAutoRelayWithStaticRelays(relays))
+	}
+
+	// Publicly reachable nodes can act as relays for nodes behind NAT
+	if config.RelayService {
+		options = append(options, libp2p.EnableRelayService())
+	}
+
+	// Upgrade relayed connections to direct ones where the NATs on both sides allow it
+	if config.EnableHolePunching {
+		options = append(options, libp2p.EnableHolePunching())
+	}
+	return options, nil
+}
@@ -0,0 +1,1657 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/verify"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that a peer is excluded once enough of its served chunks fail verification
+func TestRecordChunkVerification_Exclusion(t *testing.T) {
+	goodPeer := peer.ID("good-peer")
+	badPeer := peer.ID("bad-peer")
+
+	for i := 0; i < integrityMinSamples; i++ {
+		RecordChunkVerification(goodPeer, true)
+		RecordChunkVerification(badPeer, i%2 == 1)
+	}
+
+	if IsPeerExcluded(goodPeer) {
+		t.Errorf("FAIL: A peer whose chunks all passed verification was excluded")
+	}
+	if !IsPeerExcluded(badPeer) {
+		t.Errorf("FAIL: A peer with a high failure rate was not excluded")
+	}
+
+	stats := GetIntegrityStats()[badPeer]
+	if stats.Verified != 2 || stats.Failed != 3 {
+		t.Errorf("FAIL: Expected 2 verified and 3 failed chunks, got %d and %d", stats.Verified, stats.Failed)
+	}
+}
+
+// Tests that peers are ranked by reputation and that misbehaving peers are left out
+func TestRankPeers(t *testing.T) {
+	goodPeer := peer.ID("ranked-good")
+	averagePeer := peer.ID("ranked-average")
+	maliciousPeer := peer.ID("ranked-malicious")
+
+	RecordReputationEvent(goodPeer, EventChunkVerified)
+	RecordReputationEvent(goodPeer, EventValidBlock)
+	RecordReputationEvent(averagePeer, EventTimeout)
+	for i := 0; i < 6; i++ {
+		RecordReputationEvent(maliciousPeer, EventInvalidBlock)
+	}
+
+	if GetReputation(maliciousPeer).Score >= DisconnectThreshold {
+		t.Fatalf("FAIL: Repeated invalid blocks did not push the score below the disconnect threshold")
+	}
+
+	ranked := RankPeers([]peer.ID{averagePeer, maliciousPeer, goodPeer})
+	if len(ranked) != 2 || ranked[0] != goodPeer || ranked[1] != averagePeer {
+		t.Errorf("FAIL: Peers were not ranked correctly, got %v", ranked)
+	}
+}
+
+// Tests that the connection gater enforces banned and allowed peers and address ranges
+func TestPeerGater(t *testing.T) {
+	_, publicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	bannedPeer, _ := peer.IDFromPublicKey(publicKey)
+	otherPeer := peer.ID("other-peer")
+
+	gater, err := NewPeerGater(nil, []string{bannedPeer.String()}, []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatalf("NewPeerGater() failed with error: %v", err)
+	}
+
+	if gater.InterceptPeerDial(bannedPeer) || !gater.InterceptPeerDial(otherPeer) {
+		t.Errorf("FAIL: Peer ID bans were not enforced correctly")
+	}
+
+	allowedAddr, _ := multiaddr.NewMultiaddr("/ip4/10.2.3.4/tcp/4001")
+	bannedAddr, _ := multiaddr.NewMultiaddr("/ip4/10.1.3.4/tcp/4001")
+	outsideAddr, _ := multiaddr.NewMultiaddr("/ip4/192.168.1.1/tcp/4001")
+	if !gater.InterceptAddrDial(otherPeer, allowedAddr) {
+		t.Errorf("FAIL: An address inside the allowed range was refused")
+	}
+	if gater.InterceptAddrDial(otherPeer, bannedAddr) {
+		t.Errorf("FAIL: An address inside a banned range was allowed")
+	}
+	if gater.InterceptAddrDial(otherPeer, outsideAddr) {
+		t.Errorf("FAIL: An address outside the allowed ranges was allowed")
+	}
+
+	if _, err := NewPeerGater(nil, nil, []string{"not-a-cidr"}, nil); err == nil {
+		t.Errorf("FAIL: NewPeerGater() accepted an invalid CIDR range")
+	}
+}
+
+// Tests that hole punching cannot be enabled without relay support
+func TestNatOptions_HolePunchingRequiresRelay(t *testing.T) {
+	if _, err := natOptions(Config{EnableHolePunching: true}); err == nil {
+		t.Errorf("FAIL: natOptions() allowed hole punching without relay support")
+	}
+	if _, err := natOptions(Config{EnableAutoNAT: true, EnableRelay: true, EnableHolePunching: true}); err != nil {
+		t.Errorf("FAIL: natOptions() rejected a valid configuration: %v", err)
+	}
+}
+
+// Tests that listen addresses are only added for enabled transports
+func TestConfig_listenAddrs(t *testing.T) {
+	tcpOnly := Config{Port: 4001}.listenAddrs()
+	if len(tcpOnly) != 1 || tcpOnly[0] != "/ip4/0.0.0.0/tcp/4001" {
+		t.Errorf("FAIL: Unexpected listen addresses for a TCP-only node: %v", tcpOnly)
+	}
+
+	all := Config{Port: 4001, QUICPort: 4001, WebSocketPort: 4002}.listenAddrs()
+	expected := []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic-v1", "/ip4/0.0.0.0/tcp/4002/ws"}
+	if len(all) != len(expected) {
+		t.Fatalf("FAIL: Expected %d listen addresses, got %d", len(expected), len(all))
+	}
+	for i := range expected {
+		if all[i] != expected[i] {
+			t.Errorf("FAIL: Expected listen address %s, got %s", expected[i], all[i])
+		}
+	}
+}
+
+// Tests that the peer ID derived from an identity key is the one libp2p gives the node, and that full multiaddresses
+// end in it
+func TestPeerIDFromKey(t *testing.T) {
+	_, identityKey, _ := ed25519.GenerateKey(rand.Reader)
+	peerID, err := PeerIDFromKey(identityKey)
+	if err != nil {
+		t.Fatalf("PeerIDFromKey() failed with error: %v", err)
+	}
+	privKey, _, _ := crypto.KeyPairFromStdKey(&identityKey)
+	if expected, _ := peer.IDFromPrivateKey(privKey); peerID != expected {
+		t.Errorf("FAIL: Expected peer ID %s, got %s", expected, peerID)
+	}
+
+	addrs, err := PeerAddrs(peerID, []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/10.0.0.1/tcp/4001")})
+	if err != nil || len(addrs) != 1 || addrs[0] != "/ip4/10.0.0.1/tcp/4001/p2p/"+peerID.String() {
+		t.Errorf("FAIL: Unexpected full multiaddresses %v (%v)", addrs, err)
+	}
+}
+
+// Tests that peers found by several discovery mechanisms only appear once in the list of peers
+func TestAddPeer_Deduplicates(t *testing.T) {
+	peerInfo := &peer.AddrInfo{ID: peer.ID("discovered-twice")}
+	addPeer(peerInfo)
+	addPeer(&peer.AddrInfo{ID: peerInfo.ID})
+
+	count := 0
+	for _, existing := range GetPeers() {
+		if existing.ID == peerInfo.ID {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("FAIL: Expected the peer to be listed once, found %d times", count)
+	}
+
+	removePeer(peerInfo.ID)
+	if len(GetPeers()) != 0 {
+		t.Errorf("FAIL: removePeer() did not remove the peer")
+	}
+}
+
+// Tests that the DHT key of a piece of content carries its SHA-256 hash
+func TestContentCID(t *testing.T) {
+	hash := sha256.Sum256([]byte("chunk"))
+	key, err := contentCID(hash[:])
+	if err != nil {
+		t.Fatalf("contentCID() failed with error: %v", err)
+	}
+	decoded, err := multihash.Decode(key.Hash())
+	if err != nil || decoded.Code != multihash.SHA2_256 || !bytes.Equal(decoded.Digest, hash[:]) {
+		t.Errorf("FAIL: Content identifier does not contain the original hash")
+	}
+
+	if _, err := FindProviders(context.Background(), hash[:], 1); err == nil {
+		t.Errorf("FAIL: FindProviders() succeeded before the node was started")
+	}
+}
+
+// Tests that a peer's clock offset is estimated from handshake timestamps, bounded and used to convert its times
+func TestEstimateClockOffset(t *testing.T) {
+	// The peer's clock is 30 seconds ahead and each direction of the exchange takes 100ms
+	local := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	requestSent := local
+	requestReceived := local.Add(30*time.Second + 100*time.Millisecond)
+	responseSent := requestReceived.Add(10 * time.Millisecond)
+	responseReceived := local.Add(210 * time.Millisecond)
+
+	offset := estimateClockOffset(requestSent, requestReceived, responseSent, responseReceived)
+	if offset != 30*time.Second {
+		t.Fatalf("FAIL: Expected an offset of 30s, got %s", offset)
+	}
+
+	peerID := peer.ID("offset-peer")
+	setClockOffset(peerID, offset)
+	if !ToLocalTime(peerID, requestReceived).Equal(local.Add(100 * time.Millisecond)) {
+		t.Errorf("FAIL: ToLocalTime() did not correct for the peer's clock offset")
+	}
+	// A peer cannot claim its clock is further off than the bound
+	setClockOffset(peerID, time.Hour)
+	if GetClockOffset(peerID) != maxClockOffset {
+		t.Errorf("FAIL: Expected the offset to be clamped to %s, got %s", maxClockOffset, GetClockOffset(peerID))
+	}
+	setClockOffset(peerID, -time.Hour)
+	if GetClockOffset(peerID) != -maxClockOffset {
+		t.Errorf("FAIL: Expected the offset to be clamped to -%s, got %s", maxClockOffset, GetClockOffset(peerID))
+	}
+	if GetClockOffset(peer.ID("unknown-peer")) != 0 {
+		t.Errorf("FAIL: Expected no offset for a peer without a handshake")
+	}
+}
+
+// Tests that providers are ranked by reputation first and then by latency, with unmeasured latencies last
+func TestRankProviders(t *testing.T) {
+	fast := peer.ID("rank-fast")
+	slow := peer.ID("rank-slow")
+	unmeasured := peer.ID("rank-unmeasured")
+	trusted := peer.ID("rank-trusted")
+	RecordReputationEvent(trusted, EventValidBlock)
+
+	latencies := map[peer.ID]time.Duration{fast: 10 * time.Millisecond, slow: 200 * time.Millisecond, trusted: time.Second}
+	ranked := rankProviders([]peer.ID{unmeasured, slow, fast, trusted}, func(peerID peer.ID) time.Duration {
+		return latencies[peerID]
+	})
+
+	expected := []peer.ID{trusted, fast, slow, unmeasured}
+	if len(ranked) != len(expected) {
+		t.Fatalf("FAIL: Expected %d ranked providers, got %d", len(expected), len(ranked))
+	}
+	for i := range expected {
+		if ranked[i] != expected[i] {
+			t.Errorf("FAIL: Expected %s at position %d, got %s", expected[i], i, ranked[i])
+		}
+	}
+}
+
+// Tests that a chunk request is answered with which chunks are held, followed by those chunks
+func TestHandleRequestChunks(t *testing.T) {
+	chunkStore, err := storage.NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore() failed with error: %v", err)
+	}
+	Chunks = chunkStore
+	defer func() { Chunks = nil }()
+	hash, _ := chunkStore.PutChunk([]byte("chunk contents"))
+
+	// Serve the request on one end of an in-memory connection the same way a stream would be
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		str, _ := reader.ReadString('\n')
+		var message Message
+		json.Unmarshal([]byte(str), &message)
+		handleRequestChunks(bufio.NewReadWriter(reader, bufio.NewWriter(server)), noDeadline, "", message.Payload)
+	}()
+
+	results := make(map[string]error)
+	var served []byte
+	hashes := [][]byte{[]byte("missing"), hash}
+	outstanding := newOutstandingChunks(hashes, nil, func(hash []byte, chunk []byte, err error) {
+		results[string(hash)] = err
+		if err == nil {
+			served = chunk
+		}
+	})
+	err = exchangeChunks(client, client.SetReadDeadline, hashes, nil, outstanding, &resumableTransfer{})
+	if err != nil {
+		t.Fatalf("FAIL: Chunk exchange failed with error: %v", err)
+	}
+	if len(results) != 2 || results["missing"] != ErrChunkNotFound || results[string(hash)] != nil {
+		t.Errorf("FAIL: Expected the missing chunk to be reported as not found and the other to be served, got %v", results)
+	}
+	if string(served) != "chunk contents" {
+		t.Errorf("FAIL: Reply did not contain the stored chunk")
+	}
+}
+
+// Tests that placed chunks are stored by the receiving node and acknowledged one by one
+func TestStoreChunks(t *testing.T) {
+	senderStore, _ := storage.NewChunkStore(t.TempDir())
+	receiverStore, _ := storage.NewChunkStore(t.TempDir())
+	first, _ := senderStore.PutChunk([]byte("first chunk"))
+	// The second chunk shrinks when compressed, so it is sent compressed and must be decompressed by the receiver
+	second, _ := senderStore.PutChunk(bytes.Repeat([]byte("second chunk "), 100))
+
+	privKey, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	holder, _ := peer.IDFromPrivateKey(privKey)
+	Chunks = receiverStore
+	identityKey = privKey
+	defer func() {
+		Chunks = nil
+		identityKey = nil
+	}()
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))
+		for {
+			str, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var message Message
+			json.Unmarshal([]byte(str), &message)
+			handleStoreChunk(rw, noDeadline, "", message.Payload)
+		}
+	}()
+
+	receipts, err := storeChunks(context.Background(), client, client.SetDeadline, senderStore, holder, [][]byte{first, second}, time.Millisecond, true, false)
+	if err != nil {
+		t.Fatalf("FAIL: Placing chunks failed with error: %v", err)
+	}
+	for _, hash := range [][]byte{first, second} {
+		if _, err := receiverStore.GetChunk(hash); err != nil {
+			t.Errorf("FAIL: Placed chunk was not stored by the receiver: %v", err)
+		}
+	}
+	// Each chunk is acknowledged with a receipt signed by the holder, attesting to its hash and Merkle proof
+	merkleRoot := verify.MerkleRoot([][]byte{first, second})
+	if len(receipts) != 2 || !bytes.Equal(receipts[1].MerkleRoot, merkleRoot) || receipts[1].Verify(holder, merkleRoot) != nil {
+		t.Errorf("FAIL: Expected a verified receipt for each placed chunk, got %v", receipts)
+	}
+	forged := receipts[0]
+	forged.Hash = second
+	if forged.Verify(holder, merkleRoot) == nil {
+		t.Errorf("FAIL: Expected a receipt altered after signing to be refused")
+	}
+	// Acknowledgments signed by a peer other than the one the chunks were placed on are not accepted
+	_, err = storeChunks(context.Background(), client, client.SetDeadline, senderStore, peer.ID("other"), [][]byte{first}, 0, false, false)
+	if !errors.Is(err, ErrAckUnverified) {
+		t.Errorf("FAIL: Expected ErrAckUnverified for an acknowledgment from another peer, got %v", err)
+	}
+
+	// A chunk whose data does not match its hash must be refused
+	var output bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output))
+	payload, _ := json.Marshal(ChunkResponse{Hash: first, Found: true, Data: []byte("forged")})
+	handleStoreChunk(rw, noDeadline, "", payload)
+	var ack StoreAck
+	if err := readReply(bufio.NewReader(&output), StoreChunkAck, &ack); err != nil || ack.Stored || ack.Reason != RefusalInvalid {
+		t.Errorf("FAIL: Expected a forged chunk to be refused, got %v with error %v", ack, err)
+	}
+
+	// The algorithm named by a chunk's multihash is the only one tried, so a chunk is refused if it names another one
+	multihash, _ := verify.NewMultihash(verify.BLAKE3, first)
+	output.Reset()
+	payload, _ = json.Marshal(ChunkResponse{Hash: first, Found: true, Data: []byte("first chunk"), Multihash: multihash})
+	handleStoreChunk(rw, noDeadline, "", payload)
+	if err := readReply(bufio.NewReader(&output), StoreChunkAck, &ack); err != nil || ack.Stored || ack.Reason != RefusalInvalid {
+		t.Errorf("FAIL: Expected a chunk whose multihash names another algorithm to be refused, got %v with error %v", ack, err)
+	}
+
+	// A chunk whose proof does not lead to the Merkle root it was placed for is refused
+	output.Reset()
+	payload, _ = json.Marshal(ChunkResponse{Hash: first, Found: true, Data: []byte("first chunk"), MerkleRoot: merkleRoot})
+	handleStoreChunk(rw, noDeadline, "", payload)
+	if err := readReply(bufio.NewReader(&output), StoreChunkAck, &ack); err != nil || ack.Stored || ack.Reason != RefusalInvalid {
+		t.Errorf("FAIL: Expected a chunk with an invalid Merkle proof to be refused, got %v with error %v", ack, err)
+	}
+}
+
+// Tests that chunks placed on a node over its storage quota are refused with a reason the uploader understands, and
+// that the uploader leaves the full peer out of placement for a while
+func TestStoreChunks_QuotaExceeded(t *testing.T) {
+	senderStore, _ := storage.NewChunkStore(t.TempDir())
+	receiverStore, _ := storage.NewChunkStore(t.TempDir())
+	held, _ := receiverStore.PutChunk([]byte("held chunk"))
+	senderStore.PutChunk([]byte("held chunk"))
+	placed, _ := senderStore.PutChunk([]byte("placed chunk"))
+
+	privKey, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	holder, _ := peer.IDFromPrivateKey(privKey)
+	Chunks = receiverStore
+	identityKey = privKey
+	SetStorageQuota(receiverStore.UsedSpace())
+	defer func() {
+		Chunks = nil
+		identityKey = nil
+		SetStorageQuota(0)
+	}()
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		rw := bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))
+		for {
+			str, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var message Message
+			json.Unmarshal([]byte(str), &message)
+			handleStoreChunk(rw, noDeadline, "", message.Payload)
+		}
+	}()
+
+	// A chunk the node already holds takes up no more space, while a new one would go over the quota
+	_, err := storeChunks(context.Background(), client, client.SetDeadline, senderStore, holder, [][]byte{held, placed}, 0, false, false)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("FAIL: Expected ErrQuotaExceeded when placing over the quota, got %v", err)
+	}
+	if receiverStore.HasChunk(placed) {
+		t.Errorf("FAIL: Chunk placed over the quota was stored")
+	}
+
+	full := peer.ID("full-peer")
+	now := time.Now()
+	markPeerFull(full, now)
+	if !isPeerFull(full, now.Add(time.Minute)) || isPeerFull(full, now.Add(fullPeerTTL+time.Minute)) {
+		t.Errorf("FAIL: Expected a full peer to be left out of placement until its refusal is old enough")
+	}
+}
+
+// Tests that a transfer cut off part way through is resumed with its token, sending only the chunks not received
+func TestChunkSessionResume(t *testing.T) {
+	chunkStore, _ := storage.NewChunkStore(t.TempDir())
+	Chunks = chunkStore
+	defer func() { Chunks = nil }()
+	var hashes [][]byte
+	for _, contents := range []string{"first", "second", "third"} {
+		hash, _ := chunkStore.PutChunk([]byte(contents))
+		hashes = append(hashes, hash)
+	}
+	requester := peer.ID("requester")
+
+	// Serve the request, but let the requester drop the stream after the first chunk
+	var output bytes.Buffer
+	payload, _ := json.Marshal(ChunkRequest{Hashes: hashes})
+	handleRequestChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline, requester, payload)
+	lines := strings.SplitAfter(output.String(), "\n")
+	truncated := strings.NewReader(lines[0] + lines[1])
+
+	received := make(map[string]bool)
+	outstanding := newOutstandingChunks(hashes, nil, func(hash []byte, chunk []byte, err error) {
+		received[string(chunk)] = err == nil
+	})
+	transfer := &resumableTransfer{}
+	err := exchangeChunks(struct {
+		io.Reader
+		io.Writer
+	}{truncated, io.Discard}, func(time.Time) error { return nil }, hashes, nil, outstanding, transfer)
+	if !transfer.canResume(err) {
+		t.Fatalf("FAIL: Expected a dropped transfer to be resumable, got error %v", err)
+	}
+
+	// Only another peer holding the token is refused
+	var refused bytes.Buffer
+	resume, _ := json.Marshal(ResumeRequest{Token: transfer.token, Received: transfer.bitmap})
+	handleResumeChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&refused)), noDeadline, "other", resume)
+	var availability ChunkAvailability
+	readReply(bufio.NewReader(&refused), ChunkAvailabilityReply, &availability)
+	if availability.Token != "" || len(availability.Have) != 0 {
+		t.Errorf("FAIL: A different peer resumed the transfer")
+	}
+
+	var resumed bytes.Buffer
+	handleResumeChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&resumed)), noDeadline, requester, resume)
+	err = resumeChunks(struct {
+		io.Reader
+		io.Writer
+	}{&resumed, io.Discard}, func(time.Time) error { return nil }, outstanding, transfer)
+	if err != nil {
+		t.Fatalf("FAIL: Resuming the transfer failed with error: %v", err)
+	}
+	if len(received) != 3 || !received["first"] || !received["second"] || !received["third"] {
+		t.Errorf("FAIL: Expected every chunk to be received exactly once, got %v", received)
+	}
+
+	// A requester claiming to have received nothing only has chunks resent until the session's credits run out
+	replay, _ := json.Marshal(ResumeRequest{Token: transfer.token})
+	resent := 2 // The chunks resent when the transfer was resumed above
+	for i := 0; i < 4; i++ {
+		var replayed bytes.Buffer
+		handleResumeChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&replayed)), noDeadline, requester, replay)
+		var availability ChunkAvailability
+		readReply(bufio.NewReader(&replayed), ChunkAvailabilityReply, &availability)
+		resent += len(availability.Have)
+	}
+	if resent != len(hashes)*maxResumeAttempts {
+		t.Errorf("FAIL: Expected %d chunks to be resent in total, got %d", len(hashes)*maxResumeAttempts, resent)
+	}
+}
+
+// Tests that a corrupt replica is reported as such and replaced by a good copy pushed by a peer
+func TestReadRepair(t *testing.T) {
+	dir := t.TempDir()
+	chunkStore, _ := storage.NewChunkStore(dir)
+	Chunks = chunkStore
+	defer func() { Chunks = nil }()
+	hash, _ := chunkStore.PutChunk([]byte("chunk contents"))
+
+	// Corrupt the last byte of the stored chunk
+	path := filepath.Join(dir, hex.EncodeToString(hash)+".chunk")
+	contents, _ := os.ReadFile(path)
+	contents[len(contents)-1] ^= 0xff
+	os.WriteFile(path, contents, 0644)
+
+	var output bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output))
+	payload, _ := json.Marshal(ChunkRequest{Hashes: [][]byte{hash}})
+	handleRequestChunks(rw, noDeadline, "", payload)
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("FAIL: Expected an availability and a chunk message, got %q", output.String())
+	}
+	var message Message
+	var response ChunkResponse
+	json.Unmarshal([]byte(lines[1]), &message)
+	json.Unmarshal(message.Payload, &response)
+	if !response.Corrupt || response.Found {
+		t.Fatalf("FAIL: Expected the corrupt replica to be reported as corrupt")
+	}
+
+	// Unsolicited chunks and copies that do not match their hash must be ignored
+	unsolicited, _ := json.Marshal(ChunkResponse{Hash: []byte("other"), Found: true, Data: []byte("other")})
+	handleSendChunks("", unsolicited)
+	mismatched, _ := json.Marshal(ChunkResponse{Hash: hash, Found: true, Data: []byte("wrong contents")})
+	handleSendChunks("", mismatched)
+	if _, err := chunkStore.GetChunk(hash); err == nil {
+		t.Fatalf("FAIL: A chunk not matching its hash repaired the replica")
+	}
+
+	repair, _ := json.Marshal(ChunkResponse{Hash: hash, Found: true, Data: []byte("chunk contents")})
+	handleSendChunks("", repair)
+	chunk, err := chunkStore.GetChunk(hash)
+	if err != nil || string(chunk) != "chunk contents" {
+		t.Errorf("FAIL: A good copy pushed by a peer did not repair the replica")
+	}
+}
+
+// Tests that chunks a peer is missing or serves corrupt are reassigned to other peers as soon as they are reported
+func TestFetchChunksFrom(t *testing.T) {
+	chunkStore, _ := storage.NewChunkStore(t.TempDir())
+	Chunks = chunkStore
+	defer func() { Chunks = nil }()
+
+	contents := map[string][]byte{}
+	var hashes [][]byte
+	for _, data := range []string{"first chunk", "second chunk", "third chunk"} {
+		hash := sha256.Sum256([]byte(data))
+		contents[string(hash[:])] = []byte(data)
+		hashes = append(hashes, hash[:])
+	}
+
+	partial := peer.ID("fetch-partial")
+	corrupt := peer.ID("fetch-corrupt")
+	full := peer.ID("fetch-full")
+	var requests []peer.ID
+	var requestsMutex sync.Mutex
+	request := func(ctx context.Context, peerID peer.ID, requested [][]byte, report chunkReport) {
+		requestsMutex.Lock()
+		requests = append(requests, peerID)
+		requestsMutex.Unlock()
+		for _, hash := range requested {
+			switch {
+			case peerID == partial && bytes.Equal(hash, hashes[2]):
+				report(hash, nil, ErrChunkNotFound)
+			case peerID == corrupt:
+				report(hash, []byte("corrupt copy"), nil)
+			default:
+				report(hash, contents[string(hash)], nil)
+			}
+		}
+	}
+
+	var progress []ChunkFetch
+	keep := func(algorithm verify.HashAlgorithm, hash []byte, chunk []byte) error {
+		_, err := chunkStore.PutChunkWith(algorithm, chunk)
+		return err
+	}
+	fetches, err := fetchChunksFrom(context.Background(), hashes, []peer.ID{partial, corrupt, full}, request, keep,
+		func(fetch ChunkFetch) { progress = append(progress, fetch) })
+	if err != nil {
+		t.Fatalf("FAIL: fetchChunksFrom() failed with error: %v", err)
+	}
+	for _, hash := range hashes {
+		if _, err := chunkStore.GetChunk(hash); err != nil {
+			t.Errorf("FAIL: Chunk %x was not fetched", hash)
+		}
+	}
+	expected := []peer.ID{partial, corrupt, full}
+	if len(requests) != len(expected) {
+		t.Fatalf("FAIL: Expected requests to %v, got %v", expected, requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Errorf("FAIL: Expected request %d to go to %s, got %s", i, expected[i], requests[i])
+		}
+	}
+	if GetIntegrityStats()[corrupt].Failed != 1 {
+		t.Errorf("FAIL: The corrupt copy was not recorded against the peer that served it")
+	}
+
+	// Every chunk records the peer that served it and the peers that were asked before it
+	if len(fetches) != len(hashes) || fetches[0].Peer != partial.String() || fetches[0].Attempts != 1 {
+		t.Fatalf("FAIL: Expected the first chunk to be served by the first peer, got %+v", fetches)
+	}
+	last := fetches[2]
+	if last.Hash != hex.EncodeToString(hashes[2]) || last.Peer != full.String() || last.Attempts != 3 ||
+		len(last.CorruptPeers) != 1 || last.CorruptPeers[0] != corrupt.String() || last.Error != "" {
+		t.Errorf("FAIL: Expected the last chunk to be served by the third peer after a corrupt copy, got %+v", last)
+	}
+	if last.Size != len(contents[string(hashes[2])]) {
+		t.Errorf("FAIL: Expected the last chunk to record the size of the stored copy, got %d", last.Size)
+	}
+
+	// Progress is reported for every chunk as soon as it is done with
+	if len(progress) != len(hashes) {
+		t.Errorf("FAIL: Expected progress for %d chunks, got %d", len(hashes), len(progress))
+	}
+}
+
+// Tests that peers are read from a static peers file and from dnsaddr TXT records
+func TestDiscoveryBackends_Parsing(t *testing.T) {
+	_, publicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	peerID, _ := peer.IDFromPublicKey(publicKey)
+	addr := "/ip4/10.0.0.1/tcp/4001/p2p/" + peerID.String()
+
+	peers, err := parseStaticPeers(strings.NewReader("# Office nodes\n\n" + addr + "\n"))
+	if err != nil || len(peers) != 1 || peers[0].ID != peerID {
+		t.Errorf("FAIL: parseStaticPeers() did not return the listed peer")
+	}
+	if _, err := parseStaticPeers(strings.NewReader("not an address\n")); err == nil {
+		t.Errorf("FAIL: parseStaticPeers() accepted an invalid address")
+	}
+
+	peers = parseDNSAddrRecords([]string{"v=spf1 -all", "dnsaddr=" + addr, "dnsaddr=invalid"})
+	if len(peers) != 1 || peers[0].ID != peerID {
+		t.Errorf("FAIL: parseDNSAddrRecords() did not return only the valid dnsaddr record")
+	}
+}
+
+// Tests that each discovery backend is only used when it is enabled in the configuration
+func TestDiscoveryBackends_Config(t *testing.T) {
+	config := Config{EnableMDNS: true, StaticPeersFile: "peers.txt", DNSSeeds: []string{"seed.example.com"}}
+	var names []string
+	for _, backend := range discoveryBackends(config, nil, nil) {
+		names = append(names, backend.Name())
+	}
+	if strings.Join(names, ",") != "static peers,mDNS,DNS seed" {
+		t.Errorf("FAIL: Unexpected discovery backends %v", names)
+	}
+	if len(discoveryBackends(Config{}, nil, nil)) != 0 {
+		t.Errorf("FAIL: Discovery backends were enabled without being configured")
+	}
+}
+
+// Tests that capability adverts are only accepted when signed by the peer they name and until they expire
+func TestCapabilityAdverts(t *testing.T) {
+	privKey, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	otherKey, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	capabilities := Capabilities{FreeSpace: 1 << 30, Roles: []string{"storage"}, Price: 0.5}
+
+	advert, err := NewCapabilityAdvert(privKey, capabilities, time.Minute)
+	if err != nil {
+		t.Fatalf("NewCapabilityAdvert() failed with error: %v", err)
+	}
+	if err := advert.Verify(time.Now()); err != nil {
+		t.Fatalf("FAIL: A freshly signed advert failed verification: %v", err)
+	}
+	if err := advert.Verify(advert.ExpiresAt); err != ErrAdvertExpired {
+		t.Errorf("FAIL: Expected an advert past its expiry to be rejected, got %v", err)
+	}
+
+	// Adverts altered after signing or signed on behalf of another peer must be rejected
+	inflated := *advert
+	inflated.Capabilities.FreeSpace *= 100
+	if err := inflated.Verify(time.Now()); err != ErrAdvertSignature {
+		t.Errorf("FAIL: Expected an altered advert to be rejected, got %v", err)
+	}
+	spoofed, _ := NewCapabilityAdvert(otherKey, capabilities, time.Minute)
+	spoofed.PeerID = advert.PeerID
+	if err := spoofed.Verify(time.Now()); err != ErrAdvertSignature {
+		t.Errorf("FAIL: Expected an advert signed by another peer to be rejected, got %v", err)
+	}
+
+	// Adverts valid for longer than adverts last or issued in the future must be rejected, even if correctly signed
+	if lasting, _ := NewCapabilityAdvert(privKey, capabilities, 100*advertTTL); lasting.Verify(time.Now()) != ErrAdvertLifetime {
+		t.Errorf("FAIL: Expected an advert valid for too long to be rejected")
+	}
+	if err := advert.Verify(advert.IssuedAt.Add(-time.Hour)); err != ErrAdvertLifetime {
+		t.Errorf("FAIL: Expected an advert issued in the future to be rejected, got %v", err)
+	}
+
+	// Only the peer an advert belongs to may send it
+	otherID, _ := peer.IDFromPrivateKey(otherKey)
+	payload, _ := json.Marshal(advert)
+	handleSendCapabilities(otherID, payload)
+	if _, found := GetCapabilities(advert.PeerID); found {
+		t.Fatalf("FAIL: An advert relayed by another peer was accepted")
+	}
+	handleSendCapabilities(advert.PeerID, payload)
+	received, found := GetCapabilities(advert.PeerID)
+	if !found || received.FreeSpace != capabilities.FreeSpace {
+		t.Fatalf("FAIL: A valid advert was not recorded")
+	}
+
+	pruneExpiredAdverts(advert.ExpiresAt)
+	if _, found := GetCapabilities(advert.PeerID); found {
+		t.Errorf("FAIL: An expired advert was not pruned")
+	}
+}
+
+// Tests that nodes attest to their version in chunk replies and that peers below the minimum version are not accepted
+func TestPeerVersions(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	defer SetMinPeerVersion("")
+	Version = "v1.3.0"
+
+	// The version is attested to in the availability reply, even when the peer holds none of the chunks
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		str, _ := reader.ReadString('\n')
+		var message Message
+		json.Unmarshal([]byte(str), &message)
+		handleRequestChunks(bufio.NewReadWriter(reader, bufio.NewWriter(server)), noDeadline, "", message.Payload)
+	}()
+	hashes := [][]byte{[]byte("missing")}
+	session := &resumableTransfer{}
+	exchangeChunks(client, client.SetReadDeadline, hashes, nil, newOutstandingChunks(hashes, nil, func([]byte, []byte, error) {}), session)
+	if session.version == nil || session.version.Version != "v1.3.0" {
+		t.Fatalf("FAIL: Expected the reply to attest to v1.3.0, got %+v", session.version)
+	}
+
+	current, older, silent := peer.ID("current"), peer.ID("older"), peer.ID("silent")
+	setPeerVersion(current, session.version)
+	setPeerVersion(older, &NodeVersion{Version: "v1.2.9", Commit: "abc123"})
+	if !acceptablePeerVersion(silent) {
+		t.Errorf("FAIL: Expected every peer to be accepted without a minimum version")
+	}
+	if SetMinPeerVersion("1.3") == nil {
+		t.Errorf("FAIL: Expected a minimum version that is not a semantic version to be rejected")
+	}
+	if err := SetMinPeerVersion("v1.3.0"); err != nil {
+		t.Fatalf("SetMinPeerVersion() failed with error: %v", err)
+	}
+	if !acceptablePeerVersion(current) || acceptablePeerVersion(older) || acceptablePeerVersion(silent) {
+		t.Errorf("FAIL: Expected only the peer running v1.3.0 to be accepted")
+	}
+	if version, _ := GetPeerVersion(older); version.String() != "v1.2.9 (abc123)" {
+		t.Errorf("FAIL: Expected the version to be shown as v1.2.9 (abc123), got %s", version)
+	}
+}
+
+// Tests that the network is only healthy with enough storage peers and a chain as long as its peers' chains
+func TestCheckHealth(t *testing.T) {
+	storagePeer, relayPeer := peer.ID("storage-peer"), peer.ID("relay-peer")
+	addPeer(&peer.AddrInfo{ID: storagePeer})
+	addPeer(&peer.AddrInfo{ID: relayPeer})
+	defer removePeer(storagePeer)
+	defer removePeer(relayPeer)
+	if err := CheckHealth(1); !errors.Is(err, ErrNetworkUnhealthy) {
+		t.Errorf("FAIL: Expected the network to be unhealthy without storage adverts, got %v", err)
+	}
+
+	// Only peers advertising free space in the storage role count
+	expires := time.Now().Add(time.Minute)
+	advertMutex.Lock()
+	adverts[storagePeer] = &CapabilityAdvert{Capabilities: Capabilities{FreeSpace: 1 << 30, Roles: []string{"storage"}}, ExpiresAt: expires}
+	adverts[relayPeer] = &CapabilityAdvert{Capabilities: Capabilities{FreeSpace: 1 << 30, Roles: []string{"relay"}}, ExpiresAt: expires}
+	advertMutex.Unlock()
+	defer removeAdvert(storagePeer)
+	defer removeAdvert(relayPeer)
+	if StoragePeerCount() != 1 || CheckHealth(1) != nil {
+		t.Errorf("FAIL: Expected one storage peer and a healthy network, got %d peers (%v)", StoragePeerCount(), CheckHealth(1))
+	}
+	if err := CheckHealth(2); !errors.Is(err, ErrNetworkUnhealthy) {
+		t.Errorf("FAIL: Expected the network to be unhealthy with too few storage peers, got %v", err)
+	}
+
+	// A chain more than a block behind a peer's is not synced
+	setPeerChainLength(relayPeer, maxSyncLag)
+	if err := CheckHealth(1); err != nil {
+		t.Errorf("FAIL: Expected a chain within the allowed lag to count as synced, got %v", err)
+	}
+	setPeerChainLength(relayPeer, maxSyncLag+5)
+	if err := CheckHealth(1); !errors.Is(err, ErrNetworkUnhealthy) {
+		t.Errorf("FAIL: Expected the network to be unhealthy with an unsynced chain, got %v", err)
+	}
+	peerChainLengthsMutex.Lock()
+	delete(peerChainLengths, relayPeer)
+	peerChainLengthsMutex.Unlock()
+}
+
+// Tests that the chunks of an encrypted file are only served to its uploader and to peers presenting a capability
+func TestCanAccessChunk(t *testing.T) {
+	uploaderKey, uploaderPublicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	_, granteePublicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	uploader, _ := peer.IDFromPublicKey(uploaderPublicKey)
+	grantee, _ := peer.IDFromPublicKey(granteePublicKey)
+	rawUploaderKey, _ := uploaderKey.Raw()
+	identityKey := ed25519.PrivateKey(rawUploaderKey)
+
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	Chain.AddBlock(&core.Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	Chain.AddBlock(&core.Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"),
+		UploaderPublicKey: identityKey.Public().(ed25519.PublicKey)})
+	Access, _ = storage.NewAccessList(filepath.Join(t.TempDir(), "access.json"))
+	defer func() { Chain, Access = nil, nil }()
+	Access.Restrict([]byte("root1"), [][]byte{[]byte("restricted chunk")})
+
+	manifest := &core.Manifest{MerkleRoot: []byte("root1")}
+	capability := core.NewCapability(identityKey, manifest, grantee.String(), []byte("key"), time.Now().Add(time.Hour))
+	tests := []struct {
+		name       string
+		peerID     peer.ID
+		hash       string
+		capability *core.Capability
+		expected   bool
+	}{
+		{"unrestricted chunk", grantee, "open chunk", nil, true},
+		{"peer without a capability", grantee, "restricted chunk", nil, false},
+		{"peer with a capability", grantee, "restricted chunk", capability.Redacted(), true},
+		{"peer with another peer's capability", peer.ID("other-peer"), "restricted chunk", capability.Redacted(), false},
+		{"uploader", uploader, "restricted chunk", nil, true},
+	}
+	for _, test := range tests {
+		if canAccessChunk(test.peerID, []byte(test.hash), test.capability) != test.expected {
+			t.Errorf("FAIL: Expected access for the %s to be %v", test.name, test.expected)
+		}
+	}
+
+	// Only the uploader of a file can restrict access to its chunks when placing them, and never to a chunk already held
+	Chunks, _ = storage.NewChunkStore(t.TempDir())
+	defer func() { Chunks = nil }()
+	chunks := [][]byte{[]byte("secret"), []byte("public")}
+	tree := core.NewMerkleTree(chunks)
+	Chain.AddBlock(&core.Block{Index: 2, Hash: []byte("hash2"), MerkelRoot: tree.Root.Hash,
+		UploaderPublicKey: identityKey.Public().(ed25519.PublicKey)})
+	Chunks.PutChunk(chunks[1])
+	place := func(placer peer.ID, i int) StoreAck {
+		hash := sha256.Sum256(chunks[i])
+		payload, _ := json.Marshal(ChunkResponse{Hash: hash[:], Found: true, Data: chunks[i], MerkleRoot: tree.Root.Hash,
+			Proof: tree.GenerateMerkleProof(i), Restricted: tree.Root.Hash})
+		var output bytes.Buffer
+		handleStoreChunk(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline,
+			placer, payload)
+		var ack StoreAck
+		readReply(bufio.NewReader(&output), StoreChunkAck, &ack)
+		return ack
+	}
+	if ack := place(grantee, 0); ack.Stored || ack.Reason != RefusalRestricted {
+		t.Errorf("FAIL: Expected a chunk restricted by a peer other than the uploader to be refused, got %v", ack)
+	}
+	if ack := place(uploader, 0); !ack.Stored || !canAccessChunk(uploader, ack.Hash, nil) || canAccessChunk(grantee,
+		ack.Hash, nil) {
+		t.Errorf("FAIL: Expected the uploader to restrict access to its chunk, got %v", ack)
+	}
+	if ack := place(uploader, 1); !ack.Stored || !canAccessChunk(grantee, ack.Hash, nil) {
+		t.Errorf("FAIL: Expected a chunk already held to be stored without being restricted, got %v", ack)
+	}
+}
+
+// Tests that a provider only signs a storage agreement naming it and the file's uploader for a file it holds, and that
+// its signature covers the agreement's terms
+func TestSignAgreement(t *testing.T) {
+	uploaderKey, uploaderPublicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	providerKey, providerPublicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	_, otherPublicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+	uploader, _ := peer.IDFromPublicKey(uploaderPublicKey)
+	provider, _ := peer.IDFromPublicKey(providerPublicKey)
+	other, _ := peer.IDFromPublicKey(otherPublicKey)
+	rawUploaderKey, _ := uploaderKey.Raw()
+
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	Chain.AddBlock(&core.Block{Index: 0, Hash: []byte("hash0"), MerkelRoot: []byte("genesis")})
+	Chain.AddBlock(&core.Block{Index: 1, Hash: []byte("hash1"), MerkelRoot: []byte("root1"),
+		UploaderPublicKey: ed25519.PrivateKey(rawUploaderKey).Public().(ed25519.PublicKey)})
+	Chunks, _ = storage.NewChunkStore(t.TempDir())
+	identityKey = providerKey
+	defer func() { Chain, Chunks, identityKey = nil, nil, nil }()
+	held, _ := Chunks.PutChunk([]byte("chunk"))
+
+	agreement := core.Agreement{Uploader: uploader.String(), Provider: provider.String(), MerkleRoot: []byte("root1"),
+		Duration: 3600, Replication: 1}
+	request := func(requester peer.ID, agreement core.Agreement, chunkHashes [][]byte) []byte {
+		payload, _ := json.Marshal(AgreementRequest{Agreement: agreement, ChunkHashes: chunkHashes})
+		var output bytes.Buffer
+		handleSignAgreement(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)),
+			requester, payload)
+		var reply AgreementReply
+		readReply(bufio.NewReader(&output), AgreementSignature, &reply)
+		return reply.Signature
+	}
+
+	signature := request(uploader, agreement, [][]byte{held})
+	if err := checkAgreementSignature(provider, agreement, signature); err != nil {
+		t.Errorf("FAIL: Expected the provider to sign the agreement, got %v", err)
+	}
+	lengthened := agreement
+	lengthened.Duration = 7200
+	if err := checkAgreementSignature(provider, lengthened, signature); !errors.Is(err, ErrAgreementRefused) {
+		t.Errorf("FAIL: Expected the signature not to cover changed terms, got %v", err)
+	}
+	if signature := request(other, agreement, [][]byte{held}); signature != nil {
+		t.Errorf("FAIL: Provider signed an agreement requested by a peer other than the uploader")
+	}
+	if signature := request(uploader, agreement, [][]byte{held, []byte("missing")}); signature != nil {
+		t.Errorf("FAIL: Provider signed an agreement for a file it does not hold")
+	}
+	misnamed := agreement
+	misnamed.Provider = other.String()
+	if signature := request(uploader, misnamed, [][]byte{held}); signature != nil {
+		t.Errorf("FAIL: Provider signed an agreement naming another provider")
+	}
+}
+
+// Tests that writes on a limited stream are held to the per-peer upload limit and end with their context, and that
+// lifting the limit takes effect
+func TestLimitStream(t *testing.T) {
+	t.Cleanup(func() { SetBandwidthLimits(BandwidthLimits{}) })
+	if err := SetBandwidthLimits(BandwidthLimits{Upload: -1}); err == nil {
+		t.Errorf("FAIL: SetBandwidthLimits() accepted a negative limit")
+	}
+	if err := SetBandwidthLimits(BandwidthLimits{PeerUpload: minBandwidthBurst}); err != nil {
+		t.Fatalf("SetBandwidthLimits() failed with error: %v", err)
+	}
+
+	// The first burst is sent straight away and the rest has to wait for the limiter to refill
+	var sent bytes.Buffer
+	stream := limitStream(context.Background(), &sent, peer.ID("limited-peer"))
+	data := make([]byte, minBandwidthBurst*3/2)
+	started := time.Now()
+	if n, err := stream.Write(data); err != nil || n != len(data) || sent.Len() != len(data) {
+		t.Fatalf("FAIL: Expected %d bytes to be written, got %d (%v)", len(data), n, err)
+	}
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Errorf("FAIL: Expected writing 1.5 times the limit to take about half a second, took %v", elapsed)
+	}
+	// Other peers have limiters of their own
+	if limiters := limitersFor(peer.ID("other-peer"), time.Now()); limiters.upload.Tokens() < minBandwidthBurst {
+		t.Errorf("FAIL: Another peer's limiter was drained by the limited peer's transfer")
+	}
+	// A transfer waiting for bandwidth gives up once its context ends
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	abandoned := limitStream(ctx, &bytes.Buffer{}, peer.ID("limited-peer"))
+	if _, err := abandoned.Write(data); !errors.Is(err, context.Canceled) {
+		t.Errorf("FAIL: Expected a write with a cancelled context to fail with %v, got %v", context.Canceled, err)
+	}
+
+	SetBandwidthLimits(BandwidthLimits{})
+	started = time.Now()
+	if _, err := stream.Write(make([]byte, minBandwidthBurst*4)); err != nil || time.Since(started) > 100*time.Millisecond {
+		t.Errorf("FAIL: Expected lifting the limit to apply to the running transfer (%v)", err)
+	}
+}
+
+// Tests that heartbeats are echoed back and that a peer is only pruned once it misses several in a row
+func TestHeartbeat(t *testing.T) {
+	var stream bytes.Buffer
+	json.NewEncoder(&stream).Encode(Heartbeat{Nonce: 7})
+	if err := answerHeartbeat(&stream); err != nil {
+		t.Fatalf("answerHeartbeat() failed with error: %v", err)
+	}
+	var reply Heartbeat
+	if err := json.NewDecoder(&stream).Decode(&reply); err != nil || reply.Nonce != 7 {
+		t.Errorf("FAIL: Expected the heartbeat to be echoed back, got %+v (%v)", reply, err)
+	}
+
+	peerID := peer.ID("silent-peer")
+	addPeer(&peer.AddrInfo{ID: peerID})
+	defer removePeer(peerID)
+	for i := 1; i < maxMissedHeartbeats; i++ {
+		if recordHeartbeat(peerID, false) {
+			t.Fatalf("FAIL: Peer was pruned after missing %d heartbeats", i)
+		}
+	}
+	// An answered heartbeat resets the count
+	recordHeartbeat(peerID, true)
+	if recordHeartbeat(peerID, false) {
+		t.Fatalf("FAIL: Peer was pruned after missing a heartbeat following one it answered")
+	}
+	for i := 1; i < maxMissedHeartbeats-1; i++ {
+		recordHeartbeat(peerID, false)
+	}
+	if !recordHeartbeat(peerID, false) || isKnownPeer(peerID) {
+		t.Errorf("FAIL: Expected the peer to be pruned after missing %d heartbeats in a row", maxMissedHeartbeats)
+	}
+}
+
+// Tests that the delay before reconnecting to a peer doubles with each failed attempt up to the maximum, with jitter
+// spreading it over the upper half of the delay
+func TestReconnectDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		random   float64
+		expected time.Duration
+	}{
+		{0, 0, reconnectBaseDelay / 2},
+		{0, 1, reconnectBaseDelay},
+		{3, 1, 8 * reconnectBaseDelay},
+		{3, 0.5, 6 * reconnectBaseDelay},
+		{20, 1, reconnectMaxDelay},
+		{100, 0, reconnectMaxDelay / 2},
+	}
+	for _, test := range tests {
+		if delay := reconnectDelay(test.attempt, test.random); delay != test.expected {
+			t.Errorf("FAIL: Expected a delay of %v after %d attempts (random %v), got %v", test.expected, test.attempt,
+				test.random, delay)
+		}
+	}
+}
+
+// Tests that peer exchanges only share good peers other than the requester, and that shared addresses are grouped by peer
+func TestPeerExchange(t *testing.T) {
+	var peerIDs []peer.ID
+	for i := 0; i < 3; i++ {
+		_, publicKey, _ := crypto.GenerateEd25519Key(rand.Reader)
+		peerID, _ := peer.IDFromPublicKey(publicKey)
+		addr, _ := multiaddr.NewMultiaddr("/ip4/10.0.0.1/tcp/4001")
+		addPeer(&peer.AddrInfo{ID: peerID, Addrs: []multiaddr.Multiaddr{addr}})
+		defer removePeer(peerID)
+		peerIDs = append(peerIDs, peerID)
+	}
+	requester, good, excluded := peerIDs[0], peerIDs[1], peerIDs[2]
+	integrityMutex.Lock()
+	integrityStats[excluded] = &IntegrityStats{ExcludedUntil: time.Now().Add(time.Hour)}
+	integrityMutex.Unlock()
+	defer func() {
+		integrityMutex.Lock()
+		delete(integrityStats, excluded)
+		integrityMutex.Unlock()
+	}()
+
+	shared := parseExchangedPeers(append(samplePeers(requester, maxExchangedPeers), "/ip4/10.0.0.9/tcp/4001", "invalid"))
+	if len(shared) != 1 || shared[0].ID != good || len(shared[0].Addrs) != 1 {
+		t.Errorf("FAIL: Expected only the good peer to be shared with its address, got %v", shared)
+	}
+	if sample := samplePeers(requester, 0); len(sample) != 0 {
+		t.Errorf("FAIL: Expected no peers to be shared when none are asked for, got %v", sample)
+	}
+}
+
+// Tests that the newest protocol version both nodes speak is agreed on, and that peers on another chain are refused
+func TestNegotiateProtocol(t *testing.T) {
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	Chain.AddBlock(&core.Block{Index: 0, Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis")})
+	defer func() { Chain = nil }()
+
+	tests := []struct {
+		name        string
+		version     int
+		minVersion  int
+		genesisHash []byte
+		expected    int
+		expectedErr error
+	}{
+		{"same version", protocolVersion, minProtocolVersion, []byte("genesis_hash"), protocolVersion, nil},
+		{"older peer", 0, 0, nil, 0, nil},
+		{"newer peer", protocolVersion + 1, protocolVersion, []byte("genesis_hash"), protocolVersion, nil},
+		{"peer only speaking newer versions", protocolVersion + 2, protocolVersion + 1, nil, 0, ErrProtocolVersion},
+		{"peer on another chain", protocolVersion, minProtocolVersion, []byte("other_genesis"), 0, ErrGenesisMismatch},
+	}
+	for _, test := range tests {
+		agreed, err := negotiateProtocol(test.version, test.minVersion, test.genesisHash)
+		if !errors.Is(err, test.expectedErr) || agreed != test.expected {
+			t.Errorf("FAIL: %s: Expected version %d and error %v, got %d and %v", test.name, test.expected,
+				test.expectedErr, agreed, err)
+		}
+	}
+}
+
+// Tests that sync requests are answered with ranges of blocks and with snapshots leaving the recent blocks out
+func TestAnswerSync(t *testing.T) {
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	defer func() { Chain = nil }()
+	for i := int64(0); i < snapshotRecentBlocks+5; i++ {
+		Chain.AddBlock(&core.Block{Index: i, Hash: []byte{byte(i), 1}, MerkelRoot: []byte{byte(i)}})
+	}
+
+	response, err := answerSync(SyncRequest{From: 3, Count: 2})
+	if err != nil || len(response.Blocks) != 2 {
+		t.Fatalf("FAIL: Expected 2 blocks, got %v (error %v)", response, err)
+	}
+	blocks, err := decodeBlocks(response.Blocks)
+	if err != nil || blocks[0].Index != 3 || blocks[1].Index != 4 {
+		t.Errorf("FAIL: Expected blocks 3 and 4 to be decoded, got error %v", err)
+	}
+	if response, err := answerSync(SyncRequest{From: 2, Count: maxSyncBlocks * 2}); err != nil || len(response.Blocks) != snapshotRecentBlocks+3 {
+		t.Errorf("FAIL: Expected every block from height 2 to the tip, got error %v", err)
+	}
+	response, err = answerSync(SyncRequest{Headers: true, From: 0, Count: 3})
+	if err != nil || len(response.Headers) != 3 || len(response.Blocks) != 0 {
+		t.Fatalf("FAIL: Expected only the headers of 3 blocks, got %v (error %v)", response, err)
+	}
+	if headers, err := decodeBlocks(response.Headers); err != nil || !headers[2].Pruned {
+		t.Errorf("FAIL: Expected the headers to be sent as pruned blocks, got error %v", err)
+	}
+
+	if _, err := answerSync(SyncRequest{Snapshot: true}); err == nil {
+		t.Errorf("FAIL: Expected a node without a signing key to refuse to serve snapshots")
+	}
+	_, snapshotKey, _ = ed25519.GenerateKey(rand.Reader)
+	defer func() { snapshotKey = nil }()
+	response, err = answerSync(SyncRequest{Snapshot: true})
+	if err != nil || response.Snapshot.Height != 4 || len(response.Headers) != 0 {
+		t.Fatalf("FAIL: Expected a snapshot up to height 4 without its headers, got error %v", err)
+	}
+	if !response.Snapshot.VerifySignature() {
+		t.Errorf("FAIL: Expected the snapshot to be signed")
+	}
+	if response, err := answerSync(SyncRequest{Snapshot: true, Height: 2}); err != nil || response.Snapshot.Height != 2 {
+		t.Errorf("FAIL: Expected a snapshot up to the requested height 2, got error %v", err)
+	}
+	if _, err := answerSync(SyncRequest{Snapshot: true, Height: snapshotRecentBlocks + 5}); err == nil {
+		t.Errorf("FAIL: Expected a snapshot beyond the tip to be refused")
+	}
+}
+
+// Tests that blocks arriving before their parents are held and connected once the parents arrive
+func TestOrphanBlocks(t *testing.T) {
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	defer func() { Chain = nil }()
+	difficulty := core.MiningDifficulty
+	core.MiningDifficulty = 0
+	defer func() { core.MiningDifficulty = difficulty }()
+
+	source := core.NewBlockchain(core.NewMemoryChainStore())
+	genesis := core.NewGenesisBlock(time.Now().Add(-time.Hour))
+	source.AddBlock(genesis)
+	Chain.AddBlock(genesis)
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	var blocks []*core.Block
+	for i := 0; i < 3; i++ {
+		block := core.CreateBlock(source, []byte{byte(i)}, core.Records{}, publicKey, verify.SHA256)
+		block.Sign(privateKey)
+		source.AddBlock(block)
+		blocks = append(blocks, block)
+	}
+
+	// The grandchild and child arrive first and are held, then the parent connects both of them
+	peerID := peer.ID("sender")
+	receiveBlock(peerID, blocks[2])
+	receiveBlock(peerID, blocks[1])
+	if Chain.Length() != 1 || orphanCount != 2 {
+		t.Fatalf("FAIL: Expected 2 orphans to be held, got %d (chain length %d)", orphanCount, Chain.Length())
+	}
+	if addOrphan(blocks[2], peerID, time.Now()) {
+		t.Errorf("FAIL: Expected an orphan that is already held not to be added again")
+	}
+	peerChainLength := func(peerID peer.ID) int {
+		peerChainLengthsMutex.Lock()
+		defer peerChainLengthsMutex.Unlock()
+		return peerChainLengths[peerID]
+	}
+	if peerChainLength(peerID) != 0 {
+		t.Errorf("FAIL: Expected the sender's chain length to be left until its orphans connect, got %d",
+			peerChainLength(peerID))
+	}
+	receiveBlock(peerID, blocks[0])
+	if Chain.Length() != 4 || orphanCount != 0 || peerChainLength(peerID) != 4 {
+		t.Errorf("FAIL: Expected the orphans to be connected, got chain length %d and %d orphans", Chain.Length(), orphanCount)
+	}
+
+	// A block that is not mined and signed is not held, however far ahead it claims to be
+	junk := *blocks[2]
+	junk.Index = 1 << 40
+	receiveBlock(peer.ID("junk sender"), &junk)
+	if orphanCount != 0 || peerChainLength(peer.ID("junk sender")) != 0 {
+		t.Errorf("FAIL: Expected a block not matching its hash to be refused, got %d orphans", orphanCount)
+	}
+
+	// Orphans waiting too long are dropped
+	addOrphan(&core.Block{Index: 9, Hash: []byte("stale"), PrevHash: []byte("missing")}, peerID, time.Now().Add(-2*orphanTTL))
+	addOrphan(&core.Block{Index: 9, Hash: []byte("fresh"), PrevHash: []byte("missing")}, peerID, time.Now())
+	if waiting := takeOrphans([]byte("missing")); len(waiting) != 1 || string(waiting[0].block.Hash) != "fresh" {
+		t.Errorf("FAIL: Expected only the fresh orphan to be kept, got %d orphans", len(waiting))
+	}
+}
+
+// Tests that oversized and malformed messages are refused and count against the sender, and that a peer sending an
+// oversized message has its stream dropped
+func TestDecodeMessage(t *testing.T) {
+	valid, _ := json.Marshal(Message{Type: RequestChunks, Payload: json.RawMessage(`{"hashes":["AQID"]}`)})
+	message, err := decodeMessage(append(valid, '\n'))
+	var request ChunkRequest
+	if err != nil || decodePayload(message.Payload, &request) != nil || len(request.Hashes) != 1 {
+		t.Fatalf("FAIL: Expected a valid message to decode, got error %v", err)
+	}
+
+	malformed := []string{
+		`{"type":"RequestChunks","payload":{},"extra":1}`,
+		`{"type":"RequestChunks","payload":{}} {}`,
+		`{"type":"RequestChunks"`,
+	}
+	for _, frame := range malformed {
+		if _, err := decodeMessage([]byte(frame)); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("FAIL: Expected %s to be refused as malformed, got %v", frame, err)
+		}
+	}
+	oversized := `{"type":"SendCapabilities","payload":"` + strings.Repeat("a", maxControlMessageSize) + `"}`
+	if _, err := decodeMessage([]byte(oversized)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("FAIL: Expected a control message over its limit to be refused, got %v", err)
+	}
+	// The limit of the type is enforced before the body is decoded, or even read in full, so a malformed body is never
+	// reached
+	unread := strings.NewReader(`{"type":"SendCapabilities","payload":` + strings.Repeat("[", 2*maxControlMessageSize))
+	if _, err := readFrame(bufio.NewReader(unread)); !errors.Is(err, ErrMessageTooLarge) || unread.Len() == 0 {
+		t.Errorf("FAIL: Expected an oversized control message to be refused before it was read, got %v", err)
+	}
+	malformedBody := `{"type":"SendCapabilities","payload":` + strings.Repeat("[", maxControlMessageSize)
+	if _, err := decodeMessage([]byte(malformedBody)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("FAIL: Expected an oversized control message to be refused without being decoded, got %v", err)
+	}
+
+	// Payloads are held to the limits of their schema
+	payloads := []struct {
+		payload string
+		value   any
+	}{
+		{`{"hashes":[""]}`, &ChunkRequest{}},
+		{`{"hashes":["` + strings.Repeat("A", 2*maxHashSize) + `"]}`, &ChunkRequest{}},
+		{`{"token":"` + strings.Repeat("a", maxTokenSize+1) + `"}`, &ResumeRequest{}},
+		{`{"hash":"AQID"} []`, &ChunkResponse{}},
+	}
+	for _, test := range payloads {
+		if err := decodePayload(json.RawMessage(test.payload), test.value); !errors.Is(err, ErrMalformedMessage) {
+			t.Errorf("FAIL: Expected payload %.40s to be refused, got %v", test.payload, err)
+		}
+	}
+
+	// A frame over the largest message size is refused, and the sender's stream is dropped
+	peerID := peer.ID("oversized")
+	input := strings.NewReader(strings.Repeat("a", maxMessageSize+1) + "\n")
+	var output bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(input), bufio.NewWriter(&output))
+	done := make(chan struct{})
+	go func() {
+		determineHandler(rw, func(time.Time) error { return nil }, peerID)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("FAIL: Expected the stream of a peer sending an oversized message to be dropped")
+	}
+	if reputation := GetReputation(peerID); reputation.Events[EventMalformedMessage] != 1 {
+		t.Errorf("FAIL: Expected the oversized message to count against the peer, got %v", reputation.Events)
+	}
+}
+
+// Tests that each peer may only send each type of message at its rate limit, and that messages over it are dropped
+// and count against the peer
+func TestMessageRateLimits(t *testing.T) {
+	err := SetMessageRateLimits(MessageRateLimits{RequestChunks: {Rate: 1, Burst: 2}})
+	if err != nil {
+		t.Fatalf("FAIL: Setting the rate limits failed with error: %v", err)
+	}
+	defer SetMessageRateLimits(DefaultMessageRateLimits())
+	if SetMessageRateLimits(MessageRateLimits{RequestChunks: {Rate: 1}}) == nil {
+		t.Errorf("FAIL: Expected a rate limit without a burst to be refused")
+	}
+
+	now := time.Now()
+	flooder, other := peer.ID("flooder"), peer.ID("other")
+	if !allowMessage(flooder, RequestChunks, now) || !allowMessage(flooder, RequestChunks, now) {
+		t.Fatalf("FAIL: Expected the burst to be allowed")
+	}
+	if allowMessage(flooder, RequestChunks, now) {
+		t.Errorf("FAIL: Expected a message over the burst to be refused")
+	}
+	if !allowMessage(other, RequestChunks, now) || !allowMessage(flooder, SendCapabilities, now) {
+		t.Errorf("FAIL: Expected other peers and unlimited message types not to be affected")
+	}
+	if !allowMessage(flooder, RequestChunks, now.Add(time.Second)) {
+		t.Errorf("FAIL: Expected the allowance to refill over time")
+	}
+
+	// Messages over the limit are dropped by the handler and recorded against the sender
+	sender := peer.ID("rate limited")
+	var input bytes.Buffer
+	for i := 0; i < 3; i++ {
+		writeMessage(&input, RequestChunks, ChunkRequest{Hashes: [][]byte{{1, 2, 3}}})
+	}
+	var output bytes.Buffer
+	rw := bufio.NewReadWriter(bufio.NewReader(&input), bufio.NewWriter(&output))
+	determineHandler(rw, func(time.Time) error { return nil }, sender)
+	if replies := strings.Count(output.String(), string(ChunkAvailabilityReply)); replies != 2 {
+		t.Errorf("FAIL: Expected 2 of the 3 requests to be answered, got %d", replies)
+	}
+	if reputation := GetReputation(sender); reputation.Events[EventRateLimited] != 1 {
+		t.Errorf("FAIL: Expected the dropped message to count against the peer, got %v", reputation.Events)
+	}
+
+	messageType, limit, err := ParseMessageRateLimit("RequestChunks=0.5/10")
+	if err != nil || messageType != RequestChunks || limit.Rate != 0.5 || limit.Burst != 10 {
+		t.Errorf("FAIL: Expected the rate limit to be parsed, got %s %v with error %v", messageType, limit, err)
+	}
+	for _, value := range []string{"RequestChunks", "RequestChunks=1", "Unknown=1/1", "RequestChunks=a/1"} {
+		if _, _, err := ParseMessageRateLimit(value); err == nil {
+			t.Errorf("FAIL: Expected %s to be refused", value)
+		}
+	}
+}
+
+// Tests that chunks larger than a frame are streamed in frames with flow control, both when served and when placed,
+// and that the progress of both ends is reported
+func TestStreamedChunks(t *testing.T) {
+	senderStore, _ := storage.NewChunkStore(t.TempDir())
+	receiverStore, _ := storage.NewChunkStore(t.TempDir())
+	// The chunk spans more frames than fit in a window, so the receiver has to grant the sender more of them
+	contents := make([]byte, (chunkWindowFrames+chunkWindowFrames/2+3)*chunkFrameSize+1)
+	rand.Read(contents)
+	hash, _ := senderStore.PutChunk(contents)
+
+	privKey, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	holder, _ := peer.IDFromPrivateKey(privKey)
+	identityKey = privKey
+	setPeerProtocol(holder, PeerProtocol{Version: protocolVersion})
+	var progressMutex sync.Mutex
+	var sent, received int64
+	ChunkProgress = func(progress ChunkTransferProgress) {
+		progressMutex.Lock()
+		defer progressMutex.Unlock()
+		if progress.Sending {
+			sent = progress.Transferred
+		} else {
+			received = progress.Transferred
+		}
+	}
+	defer func() {
+		Chunks = nil
+		identityKey = nil
+		ChunkProgress = nil
+		peerProtocolsMutex.Lock()
+		delete(peerProtocols, holder)
+		peerProtocolsMutex.Unlock()
+	}()
+
+	// Streams are buffered like libp2p streams, so a window update can be written while the sender is still writing
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("FAIL: Listening failed with error: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+				determineHandler(rw, conn.SetReadDeadline, holder)
+			}()
+		}
+	}()
+
+	Chunks = senderStore
+	client, _ := net.Dial("tcp", listener.Addr().String())
+	var served []byte
+	outstanding := newOutstandingChunks([][]byte{hash}, nil, func(_ []byte, chunk []byte, err error) {
+		served = chunk
+	})
+	err = exchangeChunks(client, client.SetReadDeadline, [][]byte{hash}, nil, outstanding, &resumableTransfer{})
+	client.Close()
+	if err != nil || !bytes.Equal(served, contents) {
+		t.Fatalf("FAIL: Expected the streamed chunk to be served intact, got %d bytes with error %v", len(served), err)
+	}
+	progressMutex.Lock()
+	if sent != int64(len(contents)) || received != int64(len(contents)) {
+		t.Errorf("FAIL: Expected both ends to report the whole chunk, got %d sent and %d received", sent, received)
+	}
+	progressMutex.Unlock()
+
+	Chunks = receiverStore
+	client, _ = net.Dial("tcp", listener.Addr().String())
+	defer client.Close()
+	_, err = storeChunks(context.Background(), client, client.SetDeadline, senderStore, holder, [][]byte{hash}, 0, false, true)
+	if err != nil {
+		t.Fatalf("FAIL: Placing the streamed chunk failed with error: %v", err)
+	}
+	if stored, err := receiverStore.GetChunk(hash); err != nil || !bytes.Equal(stored, contents) {
+		t.Errorf("FAIL: Expected the placed chunk to be stored intact, got error %v", err)
+	}
+
+	// Frames must be full until the last one, so a sender cannot send other data than it announced
+	var input, output bytes.Buffer
+	writeMessage(&input, ChunkData, ChunkFrame{Data: []byte("short")})
+	rw := bufio.NewReadWriter(bufio.NewReader(&input), bufio.NewWriter(&output))
+	if _, err := receiveChunkData(rw, noDeadline, hash, 2*chunkFrameSize); !errors.Is(err, ErrMalformedMessage) {
+		t.Errorf("FAIL: Expected a short frame to be refused, got %v", err)
+	}
+}
+
+// Function that stands in for setting a deadline on streams that have none
+func noDeadline(time.Time) error {
+	return nil
+}
+
+// Tests that content is announced again at the interval, and once after a burst of network changes has settled
+func TestReannounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	announced := make(chan bool, 10)
+	changes := make(chan struct{}, 1)
+	go reannounce(ctx, time.Hour, 20*time.Millisecond, changes, func(ctx context.Context, networkChanged bool) {
+		announced <- networkChanged
+	})
+
+	// A burst of changes leads to a single announcement once it has settled
+	for i := 0; i < 3; i++ {
+		changes <- struct{}{}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case networkChanged := <-announced:
+		if !networkChanged {
+			t.Errorf("FAIL: Announcement after a network change was not marked as one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FAIL: Content was not announced after the network changed")
+	}
+	select {
+	case <-announced:
+		t.Errorf("FAIL: A burst of network changes led to more than one announcement")
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go reannounce(ctx, 10*time.Millisecond, time.Hour, nil, func(ctx context.Context, networkChanged bool) {
+		announced <- networkChanged
+	})
+	select {
+	case networkChanged := <-announced:
+		if networkChanged {
+			t.Errorf("FAIL: Periodic announcement was marked as following a network change")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("FAIL: Content was not announced again at the interval")
+	}
+}
+
+// Tests that a cache node serves the chunks in its relay cache, refuses chunks placed on it and is told apart from
+// storage peers by the roles it gives in its handshake
+func TestCacheOnlyNode(t *testing.T) {
+	chunkStore, _ := storage.NewChunkStore(t.TempDir())
+	relayCache, _ := storage.NewChunkCache(1024, "", 0)
+	Chunks, RelayCache, cacheOnly = chunkStore, relayCache, true
+	defer func() { Chunks, RelayCache, cacheOnly = nil, nil, false }()
+
+	chunk := []byte("relayed chunk")
+	hash := sha256.Sum256(chunk)
+	relayCache.Put(verify.SHA256, hash[:], chunk)
+	missing := sha256.Sum256([]byte("missing chunk"))
+
+	var output bytes.Buffer
+	payload, _ := json.Marshal(ChunkRequest{Hashes: [][]byte{hash[:], missing[:]}})
+	handleRequestChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline,
+		"requester", payload)
+	reader := bufio.NewReader(&output)
+	var availability ChunkAvailability
+	var response ChunkResponse
+	readReply(reader, ChunkAvailabilityReply, &availability)
+	err := readReply(reader, SendChunks, &response)
+	if len(availability.Have) != 1 || err != nil || !response.Found || !bytes.Equal(response.Data, chunk) {
+		t.Errorf("FAIL: Expected the relayed chunk to be served from the relay cache, got %v (%v)", availability, err)
+	}
+
+	output.Reset()
+	payload, _ = json.Marshal(ChunkResponse{Hash: hash[:], Found: true, Data: chunk})
+	handleStoreChunk(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline, "",
+		payload)
+	var ack StoreAck
+	err = readReply(bufio.NewReader(&output), StoreChunkAck, &ack)
+	if err != nil || ack.Stored || ack.Reason != RefusalCacheOnly || chunkStore.HasChunk(hash[:]) {
+		t.Errorf("FAIL: Expected a chunk placed on a cache node to be refused, got %v (%v)", ack, err)
+	}
+
+	cachePeer, storagePeer := peer.ID("cache-peer"), peer.ID("caching-storage-peer")
+	setPeerProtocol(cachePeer, PeerProtocol{Version: protocolVersion, Roles: []string{CacheRole}})
+	setPeerProtocol(storagePeer, PeerProtocol{Version: protocolVersion, Roles: []string{"storage", CacheRole}})
+	defer func() {
+		peerProtocolsMutex.Lock()
+		delete(peerProtocols, cachePeer)
+		delete(peerProtocols, storagePeer)
+		peerProtocolsMutex.Unlock()
+	}()
+	if !isCachePeer(cachePeer) || isCachePeer(storagePeer) || isCachePeer("unknown-peer") {
+		t.Errorf("FAIL: Cache peers were not told apart from storage peers")
+	}
+}
+
+// Tests that a light client keeps only headers, refuses placed chunks and only accepts chunks proven to belong to a
+// file committed by one of its headers, and that storage nodes serve the proofs of placed chunks
+func TestLightClient(t *testing.T) {
+	difficulty := core.MiningDifficulty
+	core.MiningDifficulty = 0
+	defer func() { core.MiningDifficulty = difficulty }()
+	chunks := [][]byte{[]byte("first chunk"), []byte("second chunk")}
+	tree := core.NewMerkleTree(chunks)
+	source := core.NewBlockchain(core.NewMemoryChainStore())
+	genesis := core.NewGenesisBlock(time.Now().Add(-time.Hour))
+	source.AddBlock(genesis)
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	block := core.CreateBlock(source, tree.Root.Hash, core.Records{}, publicKey, verify.SHA256)
+	block.Sign(privateKey)
+
+	Chain = core.NewBlockchain(core.NewMemoryChainStore())
+	Chain.HeadersOnly = true
+	Chain.AddBlock(genesis)
+	chunkStore, _ := storage.NewChunkStore(t.TempDir())
+	proofStore, _ := storage.NewProofStore(t.TempDir())
+	Chunks, Proofs = chunkStore, proofStore
+	defer func() { Chain, Chunks, Proofs, lightClient = nil, nil, nil, false }()
+	added, err := Chain.AddHeaders([]*core.Block{block}, 0)
+	stored, _ := Chain.GetBlockByHeight(1)
+	if added != 1 || err != nil || stored == nil || !stored.Pruned || stored.Signature == nil {
+		t.Fatalf("FAIL: Expected the light client to keep the signed header, got %d added (%v)", added, err)
+	}
+
+	// A storage node serves the proof it was placed with to peers naming the file's Merkle root
+	hash := sha256.Sum256(chunks[0])
+	chunkStore.PutChunk(chunks[0])
+	proofStore.PutProof(tree.Root.Hash, hash[:], tree.GenerateMerkleProof(0))
+	var output bytes.Buffer
+	payload, _ := json.Marshal(ChunkRequest{Hashes: [][]byte{hash[:]}, MerkleRoot: tree.Root.Hash})
+	handleRequestChunks(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline,
+		"light-peer", payload)
+	reader := bufio.NewReader(&output)
+	var availability ChunkAvailability
+	var response ChunkResponse
+	readReply(reader, ChunkAvailabilityReply, &availability)
+	err = readReply(reader, SendChunks, &response)
+	if err == nil {
+		err = response.decompress()
+	}
+	if err != nil || !response.provenIn(tree.Root.Hash) {
+		t.Errorf("FAIL: Expected the chunk to be served with a valid proof, got %v (%v)", response.Proof, err)
+	}
+	if response.provenIn(genesis.Hash) {
+		t.Errorf("FAIL: A chunk was proven to belong to a file it was not requested for")
+	}
+	response.Proof = nil
+	if response.provenIn(tree.Root.Hash) {
+		t.Errorf("FAIL: A chunk served without a proof was accepted")
+	}
+
+	lightClient = true
+	if root, err := proofRoot(tree.Root.Hash); err != nil || !bytes.Equal(root, tree.Root.Hash) {
+		t.Errorf("FAIL: Expected the committed root to be proven against, got %x (%v)", root, err)
+	}
+	if _, err := proofRoot([]byte("uncommitted root")); !errors.Is(err, ErrRootNotCommitted) {
+		t.Errorf("FAIL: Expected a root no header commits to be refused, got %v", err)
+	}
+	output.Reset()
+	payload, _ = json.Marshal(ChunkResponse{Hash: hash[:], Found: true, Data: chunks[0]})
+	handleStoreChunk(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline, "",
+		payload)
+	var ack StoreAck
+	err = readReply(bufio.NewReader(&output), StoreChunkAck, &ack)
+	if err != nil || ack.Stored || ack.Reason != RefusalLightClient {
+		t.Errorf("FAIL: Expected a chunk placed on a light client to be refused, got %v (%v)", ack, err)
+	}
+}
+
+func TestAuditProviders(t *testing.T) {
+	var chunkHashes [][]byte
+	for i := 0; i < 5; i++ {
+		hash := sha256.Sum256([]byte{byte(i)})
+		chunkHashes = append(chunkHashes, hash[:])
+	}
+	// A file repeating a chunk must not have it challenged twice
+	chunkHashes = append(chunkHashes, chunkHashes[0])
+	picked := pickChallenges(chunkHashes, 3)
+	if len(picked) != 3 {
+		t.Errorf("FAIL: Expected 3 chunks to be picked, got %d", len(picked))
+	}
+	if picked = pickChallenges(chunkHashes, 10); len(picked) != 5 {
+		t.Errorf("FAIL: Expected every distinct chunk to be picked once, got %d chunks", len(picked))
+	}
+	seen := make(map[string]bool)
+	for _, hash := range picked {
+		if seen[string(hash)] {
+			t.Errorf("FAIL: Chunk %x was picked twice", hash)
+		}
+		seen[string(hash)] = true
+	}
+
+	// Without a running node no challenge can be answered, so every one fails
+	provider := peer.ID("audited-provider")
+	audit := challengeProvider(context.Background(), provider, chunkHashes[:2], false)
+	if audit.Challenges != 2 || audit.Passed != 0 || len(audit.Failures) != 2 {
+		t.Errorf("FAIL: Expected both challenges to fail, got %+v", audit)
+	}
+	if GetReputation(provider).Events[EventAuditFailed] != 0 {
+		t.Errorf("FAIL: Failed challenges were recorded without being asked to")
+	}
+	challengeProvider(context.Background(), provider, chunkHashes[:2], true)
+	if events := GetReputation(provider).Events[EventAuditFailed]; events != 2 {
+		t.Errorf("FAIL: Expected 2 failed audits to be recorded, got %d", events)
+	}
+}
+
+func TestNodeRoles(t *testing.T) {
+	cases := []struct {
+		roles     []string
+		exclusive string
+		err       error
+	}{
+		{[]string{StorageRole}, "", nil},
+		{[]string{StorageRole, BootstrapRole}, "", nil},
+		{[]string{"relay"}, "", nil},
+		{[]string{ClientRole}, ClientRole, nil},
+		{[]string{BootstrapRole, "relay"}, BootstrapRole, nil},
+		{[]string{ClientRole, BootstrapRole}, "", ErrConflictingRoles},
+		{[]string{CacheRole, LightRole}, "", ErrConflictingRoles},
+	}
+	for _, c := range cases {
+		exclusive, err := ExclusiveRole(c.roles)
+		if exclusive != c.exclusive || !errors.Is(err, c.err) {
+			t.Errorf("FAIL: Expected roles %v to give %q (%v), got %q (%v)", c.roles, c.exclusive, c.err, exclusive, err)
+		}
+	}
+
+	// Chunks placed on a node that stores nothing for its peers are refused with the reason for its role
+	defer func() { clientOnly, bootstrapOnly = false, false }()
+	hash := sha256.Sum256([]byte("chunk"))
+	payload, _ := json.Marshal(ChunkResponse{Hash: hash[:], Found: true, Data: []byte("chunk")})
+	for _, reason := range []string{RefusalClient, RefusalBootstrap} {
+		clientOnly, bootstrapOnly = reason == RefusalClient, reason == RefusalBootstrap
+		var output bytes.Buffer
+		handleStoreChunk(bufio.NewReadWriter(bufio.NewReader(&bytes.Buffer{}), bufio.NewWriter(&output)), noDeadline,
+			"", payload)
+		var ack StoreAck
+		err := readReply(bufio.NewReader(&output), StoreChunkAck, &ack)
+		if err != nil || ack.Stored || ack.Reason != reason {
+			t.Errorf("FAIL: Expected a chunk to be refused with reason %q, got %v (%v)", reason, ack, err)
+		}
+	}
+}
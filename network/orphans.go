@@ -0,0 +1,143 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sync"
+	"time"
+)
+
+// Maximum number of orphan blocks held at once, so that a peer sending blocks with made up parents cannot exhaust memory
+const maxOrphanBlocks = 100
+
+// Time after which an orphan block whose parents have not arrived is dropped
+const orphanTTL = 10 * time.Minute
+
+// orphanBlock - Structure holding a block whose parent is not in the local chain yet
+type orphanBlock struct {
+	block    *core.Block
+	peerID   peer.ID   // Peer that sent the block
+	received time.Time // Time the block was received
+}
+
+// Blocks received before their parents, by the hex encoded hash of the parent they are waiting for
+var orphans = make(map[string][]*orphanBlock)
+var orphanCount int
+var orphansMutex = &sync.Mutex{}
+
+// Function that holds a block until its parent arrives, returning whether it was not already held
+// Expired orphans are dropped first, and once the pool is full the oldest orphan makes way for the new one
+func addOrphan(block *core.Block, peerID peer.ID, now time.Time) bool {
+	orphansMutex.Lock()
+	defer orphansMutex.Unlock()
+	parent := hex.EncodeToString(block.PrevHash)
+	for _, orphan := range orphans[parent] {
+		if bytes.Equal(orphan.block.Hash, block.Hash) {
+			return false
+		}
+	}
+
+	var oldestParent string
+	var oldest *orphanBlock
+	for parentHash, waiting := range orphans {
+		kept := waiting[:0]
+		for _, orphan := range waiting {
+			if now.Sub(orphan.received) > orphanTTL {
+				orphanCount--
+				continue
+			}
+			kept = append(kept, orphan)
+			if oldest == nil || orphan.received.Before(oldest.received) {
+				oldestParent, oldest = parentHash, orphan
+			}
+		}
+		orphans[parentHash] = kept
+		if len(kept) == 0 {
+			delete(orphans, parentHash)
+		}
+	}
+	if orphanCount >= maxOrphanBlocks && oldest != nil {
+		removeOrphan(oldestParent, oldest)
+	}
+
+	orphans[parent] = append(orphans[parent], &orphanBlock{block: block, peerID: peerID, received: now})
+	orphanCount++
+	return true
+}
+
+// Function that removes a single orphan waiting for a parent while the lock is held
+func removeOrphan(parent string, removed *orphanBlock) {
+	waiting := orphans[parent]
+	for i, orphan := range waiting {
+		if orphan == removed {
+			orphans[parent] = append(waiting[:i], waiting[i+1:]...)
+			orphanCount--
+			break
+		}
+	}
+	if len(orphans[parent]) == 0 {
+		delete(orphans, parent)
+	}
+}
+
+// Function that removes and returns the orphans waiting for a parent
+func takeOrphans(parentHash []byte) []*orphanBlock {
+	orphansMutex.Lock()
+	defer orphansMutex.Unlock()
+	parent := hex.EncodeToString(parentHash)
+	waiting := orphans[parent]
+	delete(orphans, parent)
+	orphanCount -= len(waiting)
+	return waiting
+}
+
+// Function that adds the orphans waiting for a newly added block to the local chain, which in turn connects the
+// orphans waiting for them
+func connectOrphans(parentHash []byte) {
+	for _, orphan := range takeOrphans(parentHash) {
+		err := Chain.AddValidBlock(orphan.block, core.MiningDifficulty)
+		if err != nil {
+			logger.Warn("rejected orphan block", "peer", orphan.peerID, "block", orphan.block.Index, "error", err)
+			RecordReputationEvent(orphan.peerID, EventInvalidBlock)
+			continue
+		}
+		acceptedBlock(orphan.peerID, orphan.block)
+	}
+}
+
+// Function that asks the peer that sent an orphan block for the blocks between the local tip and the orphan
+// Each block is added as it arrives, and the orphan is connected once its parent has been added
+func requestParents(ctx context.Context, peerID peer.ID, orphan *core.Block) {
+	agreed, found := GetPeerProtocol(peerID)
+	if nodeHost == nil || !found || agreed.Version < syncProtocolVersion || !syncing.CompareAndSwap(false, true) {
+		return
+	}
+	defer syncing.Store(false)
+
+	for from := int64(localChainLength()); from < orphan.Index; from = int64(localChainLength()) {
+		response, err := requestSync(ctx, peerID, SyncRequest{From: from, Count: int(min(orphan.Index-from, maxSyncBlocks))})
+		if err != nil {
+			logger.Warn("error encountered when requesting parents of orphan block", "peer", peerID,
+				"block", orphan.Index, "error", err)
+			return
+		}
+		parents, err := decodeBlocks(response.Blocks)
+		if err != nil || len(parents) == 0 {
+			logger.Warn("peer sent no parents of orphan block", "peer", peerID, "block", orphan.Index, "error", err)
+			RecordReputationEvent(peerID, EventInvalidBlock)
+			return
+		}
+		for _, parent := range parents {
+			err := Chain.AddValidBlock(parent, core.MiningDifficulty)
+			if err != nil {
+				logger.Warn("rejected parent of orphan block", "peer", peerID, "block", parent.Index, "error", err)
+				RecordReputationEvent(peerID, EventInvalidBlock)
+				return
+			}
+			acceptedBlock(peerID, parent)
+		}
+	}
+}
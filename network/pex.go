@@ -0,0 +1,187 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"math/rand"
+	"time"
+)
+
+// Protocol used by peers to share samples of the peers they know with each other
+const pexProtocol = "/blockchain-storage/pex/1.0.0"
+
+// First protocol version in which peers answer peer exchange requests
+const pexProtocolVersion = 4
+
+// Interval between the rounds in which every connected peer is asked for a sample of its peers
+const pexInterval = time.Minute
+
+// Maximum time a peer has to answer a peer exchange request
+const pexTimeout = 10 * time.Second
+
+// Greatest number of peers shared in, or taken from, a single peer exchange
+const maxExchangedPeers = 16
+
+// PeerExchangeRequest - Message asking a peer for a sample of the peers it is connected to
+type PeerExchangeRequest struct {
+	Count int `json:"count"` // Number of peers wanted (capped at maxExchangedPeers)
+}
+
+// PeerExchangeResponse - Message sent back with a sample of the responder's peers
+type PeerExchangeResponse struct {
+	Peers []string `json:"peers,omitempty"` // Multiaddresses of the peers, each including its peer ID
+}
+
+// Function that handles a peer exchange request sent by another node
+func handlePeerExchange(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(pexTimeout))
+	var request PeerExchangeRequest
+	err := json.NewDecoder(stream).Decode(&request)
+	if err != nil {
+		logger.Warn("error encountered when reading peer exchange request", "peer", stream.Conn().RemotePeer(),
+			"error", err)
+		return
+	}
+	response := PeerExchangeResponse{Peers: samplePeers(stream.Conn().RemotePeer(), request.Count)}
+	err = json.NewEncoder(stream).Encode(response)
+	if err != nil {
+		logger.Error("error encountered when replying to peer exchange request", "peer", stream.Conn().RemotePeer(),
+			"error", err)
+	}
+}
+
+// Function that picks a random sample of the node's good peers to share with the peer asking for them
+// Peers below the reputation threshold or excluded for serving bad chunks are never shared, nor are peers without a
+// known address, as they could not be connected to
+func samplePeers(requester peer.ID, count int) []string {
+	var candidates []peer.ID
+	addrs := make(map[peer.ID][]multiaddr.Multiaddr)
+	for _, peerInfo := range GetPeers() {
+		if peerInfo.ID == requester {
+			continue
+		}
+		peerAddrs := peerInfo.Addrs
+		if nodeHost != nil {
+			peerAddrs = append(peerAddrs, nodeHost.Peerstore().Addrs(peerInfo.ID)...)
+		}
+		if len(peerAddrs) == 0 {
+			continue
+		}
+		candidates = append(candidates, peerInfo.ID)
+		addrs[peerInfo.ID] = peerAddrs
+	}
+	good := RankPeers(candidates)
+	rand.Shuffle(len(good), func(i, j int) { good[i], good[j] = good[j], good[i] })
+	good = good[:min(len(good), max(min(count, maxExchangedPeers), 0))]
+
+	var sample []string
+	for _, peerID := range good {
+		p2pAddrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: peerID, Addrs: addrs[peerID]})
+		if err != nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, addr := range p2pAddrs {
+			if !seen[addr.String()] {
+				seen[addr.String()] = true
+				sample = append(sample, addr.String())
+			}
+		}
+	}
+	return sample
+}
+
+// Function that asks a peer for a sample of its peers
+func requestPeerExchange(ctx context.Context, peerID peer.ID) ([]peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, pexTimeout)
+	defer cancel()
+
+	stream, err := nodeHost.NewStream(ctx, peerID, pexProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(pexTimeout))
+
+	err = json.NewEncoder(stream).Encode(PeerExchangeRequest{Count: maxExchangedPeers})
+	if err != nil {
+		return nil, err
+	}
+	var response PeerExchangeResponse
+	err = json.NewDecoder(stream).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	return parseExchangedPeers(response.Peers), nil
+}
+
+// Function that groups the multiaddresses shared by a peer by the peers they belong to, ignoring invalid addresses
+// and any peers beyond the most that can be shared in one exchange
+func parseExchangedPeers(addrs []string) []peer.AddrInfo {
+	var p2pAddrs []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		p2pAddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			continue
+		}
+		// Addresses without a peer ID cannot be grouped or dialled
+		if _, err := peer.AddrInfoFromP2pAddr(p2pAddr); err != nil {
+			continue
+		}
+		p2pAddrs = append(p2pAddrs, p2pAddr)
+	}
+	peers, err := peer.AddrInfosFromP2pAddrs(p2pAddrs...)
+	if err != nil {
+		return nil
+	}
+	return peers[:min(len(peers), maxExchangedPeers)]
+}
+
+// pexBackend - Discovery backend asking connected peers for samples of the peers they know
+// In small private networks a DHT walk finds few peers and is slow to do so, whereas every peer exchange hands over
+// peers that are already known to be good
+type pexBackend struct{}
+
+func (backend *pexBackend) Name() string {
+	return "peer exchange"
+}
+
+func (backend *pexBackend) Start(ctx context.Context, candidates chan<- peer.AddrInfo) error {
+	go func() {
+		ticker := time.NewTicker(pexInterval)
+		defer ticker.Stop()
+		for {
+			exchangePeers(ctx, candidates)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Function that asks every connected peer speaking the peer exchange protocol for a sample of its peers, sending the
+// peers shared as candidates
+func exchangePeers(ctx context.Context, candidates chan<- peer.AddrInfo) {
+	for _, peerInfo := range GetPeers() {
+		if agreed, found := GetPeerProtocol(peerInfo.ID); !found || agreed.Version < pexProtocolVersion {
+			continue
+		}
+		shared, err := requestPeerExchange(ctx, peerInfo.ID)
+		if err != nil {
+			logger.Debug("error encountered when exchanging peers", "peer", peerInfo.ID, "error", err)
+			continue
+		}
+		for _, candidate := range shared {
+			if !sendCandidate(ctx, candidates, candidate) {
+				return
+			}
+		}
+	}
+}
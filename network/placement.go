@@ -0,0 +1,441 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/events"
+	"blockchain-storage/storage"
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Error returned when a peer refuses to store a chunk placed on it
+var ErrChunkRefused = errors.New("peer refused to store the chunk")
+
+// Error returned when a peer refuses to store a chunk placed on it because it would go over its storage quota
+var ErrQuotaExceeded = errors.New("peer's storage quota is exceeded")
+
+// Error returned when a peer acknowledges storing a chunk without a valid signature or without having verified it
+var ErrAckUnverified = errors.New("peer's acknowledgment does not attest to a verified chunk")
+
+// Reasons a peer gives for refusing to store a chunk placed on it
+const (
+	RefusalInvalid       = "invalid"        // The chunk does not match its hash, or its access cannot be restricted
+	RefusalQuotaExceeded = "quota_exceeded" // Storing the chunk would take the peer over its storage quota
+	RefusalStoreFailed   = "store_failed"   // The peer failed to write the chunk to its chunk store
+	RefusalCacheOnly     = "cache_only"     // The peer only caches chunks and stores nothing permanently
+	RefusalLightClient   = "light_client"   // The peer is a light client and stores nothing but its own files
+	RefusalClient        = "client"         // The peer is a client and stores nothing but its own files
+	RefusalBootstrap     = "bootstrap"      // The peer only serves the DHT and peer discovery
+	RefusalRestricted    = "restricted"     // The placing peer did not show it uploaded the file it restricts the chunk to
+)
+
+// Time a peer that refused a chunk for being over its quota is left out of placement, as it may free up space later
+const fullPeerTTL = 10 * time.Minute
+
+// Checks a peer lists in its acknowledgment as having passed before it stored a placed chunk
+const (
+	VerifiedHash        = "hash"         // The chunk's data matches its hash
+	VerifiedMerkleProof = "merkle_proof" // The chunk's hash is proven to be a leaf of the file's Merkle root
+)
+
+// StoreAck - Payload of the message acknowledging that a placed chunk has been stored
+// Acknowledgments are signed by the holder's identity key, so that the uploader can keep them as receipts of placement
+type StoreAck struct {
+	Hash   []byte `json:"hash"`             // Hash of the placed chunk
+	Stored bool   `json:"stored"`           // Whether the chunk was stored
+	Reason string `json:"reason,omitempty"` // Why the chunk was refused (empty if it was stored)
+
+	Holder     peer.ID   `json:"holder,omitempty"`     // Peer that received the chunk
+	MerkleRoot []byte    `json:"merkleRoot,omitempty"` // Merkle root the chunk was proven to belong to (empty without a proof)
+	Verified   []string  `json:"verified,omitempty"`   // Checks the chunk passed before it was stored
+	IssuedAt   time.Time `json:"issuedAt"`             // Time the acknowledgment was signed
+	Signature  []byte    `json:"signature,omitempty"`  // Signature over every other field by the holder's identity key
+}
+
+// Number of bytes the chunk store may take up on disk before placed chunks are refused (0 for no quota)
+var storageQuota atomic.Int64
+
+// Peers that refused a chunk for being over their quota, mapped to the time they refused it
+var fullPeers = make(map[peer.ID]time.Time)
+var fullPeersMutex sync.Mutex
+
+// Function that signs an acknowledgment with the private key of the peer sending it
+func (ack *StoreAck) Sign(privKey crypto.PrivKey) error {
+	holder, err := peer.IDFromPrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+	ack.Holder = holder
+	ack.IssuedAt = time.Now()
+	signedBytes, err := ack.signedBytes()
+	if err != nil {
+		return err
+	}
+	ack.Signature, err = privKey.Sign(signedBytes)
+	return err
+}
+
+// Function that returns the bytes covered by the acknowledgment's signature (the acknowledgment without its signature)
+func (ack *StoreAck) signedBytes() ([]byte, error) {
+	unsigned := *ack
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// Function that checks that an acknowledgment of storing a chunk was signed by the peer it was placed on, and that
+// the peer verified the chunk's hash and, when one was given, its proof against the Merkle root
+func (ack *StoreAck) Verify(holder peer.ID, merkleRoot []byte) error {
+	if ack.Holder != holder {
+		return ErrAckUnverified
+	}
+	publicKey, err := holder.ExtractPublicKey()
+	if err != nil {
+		return ErrAckUnverified
+	}
+	signedBytes, err := ack.signedBytes()
+	if err != nil {
+		return err
+	}
+	valid, err := publicKey.Verify(signedBytes, ack.Signature)
+	if err != nil || !valid || !slices.Contains(ack.Verified, VerifiedHash) {
+		return ErrAckUnverified
+	}
+	if merkleRoot != nil && (!bytes.Equal(ack.MerkleRoot, merkleRoot) || !slices.Contains(ack.Verified, VerifiedMerkleProof)) {
+		return ErrAckUnverified
+	}
+	return nil
+}
+
+// Function that sets the number of bytes the chunk store may take up before placed chunks are refused (0 for no quota)
+func SetStorageQuota(bytes int64) {
+	storageQuota.Store(bytes)
+}
+
+// Function that returns the number of bytes the chunk store may take up before placed chunks are refused (0 for no quota)
+func StorageQuota() int64 {
+	return storageQuota.Load()
+}
+
+// Function that checks whether storing a placed chunk would take the chunk store over its quota
+// A chunk the node already holds takes up no more space, so it is never refused
+func exceedsQuota(hash []byte, size int64) bool {
+	quota := storageQuota.Load()
+	return quota > 0 && !Chunks.HasChunk(hash) && Chunks.UsedSpace()+size > quota
+}
+
+// Function that records that a peer refused a chunk for being over its quota
+func markPeerFull(peerID peer.ID, now time.Time) {
+	fullPeersMutex.Lock()
+	defer fullPeersMutex.Unlock()
+	fullPeers[peerID] = now
+}
+
+// Function that checks whether a peer recently refused a chunk for being over its quota
+func isPeerFull(peerID peer.ID, now time.Time) bool {
+	fullPeersMutex.Lock()
+	defer fullPeersMutex.Unlock()
+	refused, found := fullPeers[peerID]
+	if !found {
+		return false
+	}
+	if now.Sub(refused) > fullPeerTTL {
+		delete(fullPeers, peerID)
+		return false
+	}
+	return true
+}
+
+// Function that handles a chunk placed on the node by an uploader, storing it and acknowledging it on the same stream
+func handleStoreChunk(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, peerID peer.ID,
+	payload json.RawMessage) {
+	var placed ChunkResponse
+	if err := decodePayload(payload, &placed); err != nil {
+		logger.Warn("error encountered when unmarshalling placed chunk", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	if placed.Streamed > 0 {
+		var err error
+		placed.Data, err = receiveChunkData(rw, setReadDeadline, placed.Hash, placed.Streamed)
+		if err != nil {
+			logger.Warn("error encountered when receiving placed chunk", "peer", peerID, "error", err)
+			return
+		}
+	}
+
+	ack := StoreAck{Hash: placed.Hash}
+	// Chunks are checked against their hash once decompressed, so a chunk that fails to decompress is invalid
+	var algorithm verify.HashAlgorithm
+	valid := false
+	if placed.decompress() == nil {
+		algorithm, valid = placed.verifyData()
+	}
+	if valid {
+		ack.Verified = append(ack.Verified, VerifiedHash)
+	}
+	// A chunk placed with a proof is only stored if the proof shows it belongs to the file it was placed for
+	if valid && placed.MerkleRoot != nil {
+		valid = verify.MerkleProofWith(algorithm, placed.Data, placed.MerkleRoot, placed.Proof)
+		if valid {
+			ack.MerkleRoot = placed.MerkleRoot
+			ack.Verified = append(ack.Verified, VerifiedMerkleProof)
+		}
+	}
+	// A node without an access list could not keep the chunk of an encrypted file from peers without access to it
+	if placed.Restricted != nil && Access == nil {
+		valid = false
+	}
+	// A chunk already held is left as it is, as it may belong to other files that are not restricted
+	restrict := placed.Restricted != nil && Chunks != nil && !Chunks.HasChunk(placed.Hash)
+	switch {
+	case !valid:
+		ack.Reason = RefusalInvalid
+	case cacheOnly:
+		ack.Reason = RefusalCacheOnly
+	case lightClient:
+		ack.Reason = RefusalLightClient
+	case clientOnly:
+		ack.Reason = RefusalClient
+	case bootstrapOnly:
+		ack.Reason = RefusalBootstrap
+	case Chunks == nil:
+		ack.Reason = RefusalStoreFailed
+	case restrict && !mayRestrict(peerID, placed.Restricted, ack.MerkleRoot):
+		ack.Reason = RefusalRestricted
+	case exceedsQuota(placed.Hash, int64(len(placed.Data))):
+		// The uploader is told why, so that it places the chunk on another peer rather than retrying this one
+		ack.Reason = RefusalQuotaExceeded
+		logger.Warn("refused placed chunk over the storage quota", "quota", storageQuota.Load())
+	default:
+		var err error
+		if restrict {
+			err = Access.Restrict(placed.Restricted, [][]byte{placed.Hash})
+		}
+		if err == nil {
+			_, err = Chunks.PutChunkWith(algorithm, placed.Data)
+		}
+		if err != nil {
+			logger.Error("error encountered when storing placed chunk", "error", err)
+			ack.Reason = RefusalStoreFailed
+		}
+		ack.Stored = err == nil
+		// The proof is kept so that light clients, which cannot check the chunk against a manifest, can be sent it
+		if ack.Stored && ack.MerkleRoot != nil && Proofs != nil {
+			err = Proofs.PutProof(placed.MerkleRoot, placed.Hash, placed.Proof)
+			if err != nil {
+				logger.Warn("error encountered when storing proof of placed chunk", "error", err)
+			}
+		}
+	}
+	if identityKey != nil {
+		if err := ack.Sign(identityKey); err != nil {
+			logger.Error("error encountered when signing acknowledgment of placed chunk", "error", err)
+		}
+	}
+	if ack.Stored {
+		recordCredit(peerID, storage.CreditProvided, len(placed.Data), ack)
+	}
+	err := writeFlushed(rw, StoreChunkAck, ack)
+	if err != nil {
+		logger.Error("error encountered when acknowledging placed chunk", "error", err)
+	}
+}
+
+// Function that returns whether a peer placing a chunk may restrict access to it to the peers with access to a file
+// The chunk must be proven to belong to the file, and the file must be committed by the placing peer, so that no peer
+// can keep others from a chunk of a file that is not its own
+func mayRestrict(peerID peer.ID, restricted []byte, provenRoot []byte) bool {
+	return bytes.Equal(restricted, provenRoot) && isUploader(peerID, restricted)
+}
+
+// Function that stores a file's chunks on connected peers other than the given holders
+// Peers running a version below the configured minimum are skipped. The rest are tried from the best reputation down
+// until the wanted number have acknowledged storing every chunk or none are left. The IDs of those that acknowledged
+// are returned
+func PlaceChunks(ctx context.Context, chunkHashes [][]byte, holders []string, wanted int, jitter time.Duration) (placed []string, err error) {
+	ctx, span := tracing.Start(ctx, "network.place_chunks", attribute.Int("chunks", len(chunkHashes)),
+		attribute.Int("wanted", wanted))
+	defer func() {
+		span.SetAttributes(attribute.Int("placed", len(placed)))
+		tracing.End(span, err)
+	}()
+
+	if Chunks == nil || nodeHost == nil {
+		return nil, errors.New("node has not been started")
+	}
+	holding := make(map[string]bool)
+	for _, holder := range holders {
+		holding[holder] = true
+	}
+	var candidates []peer.ID
+	for _, peerInfo := range GetPeers() {
+		// Peers that store nothing for other peers, such as clients and cache nodes, would refuse every chunk
+		if !holding[peerInfo.ID.String()] && !IsPeerExcluded(peerInfo.ID) && acceptablePeerVersion(peerInfo.ID) &&
+			!isPeerFull(peerInfo.ID, time.Now()) && !refusesPlacement(peerInfo.ID) {
+			candidates = append(candidates, peerInfo.ID)
+		}
+	}
+
+	for _, peerID := range RankPeers(candidates) {
+		if len(placed) == wanted {
+			break
+		}
+		// Placement is retried in the background, so it waits while the node is over its resource ceilings
+		if err := Resources.Wait(ctx); err != nil {
+			return placed, err
+		}
+		err := placeOnPeer(ctx, peerID, chunkHashes, jitter)
+		if ctx.Err() != nil {
+			return placed, ctx.Err()
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			// A full peer is not misbehaving, so it is only left out of placement until it has space again
+			logger.Info("peer is over its storage quota, placing chunks elsewhere", "peer", peerID)
+			markPeerFull(peerID, time.Now())
+			continue
+		}
+		if err != nil {
+			logger.Error("error encountered when placing chunks on peer", "peer", peerID, "error", err)
+			continue
+		}
+		placed = append(placed, peerID.String())
+	}
+	return placed, nil
+}
+
+// Function that stores every chunk of a file on a peer over a new stream
+func placeOnPeer(ctx context.Context, peerID peer.ID, chunkHashes [][]byte, jitter time.Duration) (err error) {
+	ctx, span := tracing.Start(ctx, "network.place_on_peer", attribute.String("peer", peerID.String()))
+	defer func() { tracing.End(span, err) }()
+
+	stream, err := nodeHost.NewStream(ctx, peerID, protocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	receipts, err := storeChunks(ctx, limitStream(ctx, stream, peerID), stream.SetDeadline, Chunks, peerID,
+		chunkHashes, jitter, acceptsCompressedChunks(peerID), acceptsStreamedChunks(peerID))
+	if err != nil {
+		return err
+	}
+	Events.Publish(events.Event{Type: events.ReplicaPlaced, Peer: peerID.String(), MerkleRoot: receipts[0].MerkleRoot})
+	return nil
+}
+
+// Function that sends a file's chunks from a chunk store on a stream, returning the holder's signed acknowledgment of
+// each one
+// Every chunk is sent with its proof against the file's Merkle root, and its acknowledgment must attest that the
+// holder checked both the chunk's hash and the proof before storing it.
+// With a jitter, each chunk is sent after a random delay so that the file's structure is not revealed by timing
+// With compression, chunks that shrink when compressed are sent compressed
+// With streaming, chunks larger than a frame are streamed in frames after their message
+func storeChunks(ctx context.Context, stream io.ReadWriter, setDeadline func(time.Time) error,
+	chunkStore *storage.ChunkStore, holder peer.ID, chunkHashes [][]byte, jitter time.Duration,
+	compress bool, streaming bool) ([]StoreAck, error) {
+	if len(chunkHashes) == 0 {
+		return nil, errors.New("file has no chunks to place")
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	var tree *core.MerkleTree
+	receipts := make([]StoreAck, 0, len(chunkHashes))
+	for i, hash := range chunkHashes {
+		if jitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		chunk, multihash, err := chunkStore.GetAddressedChunk(hash)
+		if err != nil {
+			return nil, err
+		}
+		// The tree is built with the algorithm the file's chunks are hashed with, once the first chunk names it
+		if tree == nil {
+			algorithm, _, err := multihash.Decode()
+			if err == nil {
+				tree, err = core.NewMerkleTreeFromHashesWith(algorithm, chunkHashes)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		setDeadline(time.Now().Add(chunkRequestTimeout))
+		// Peers are told which chunks belong to encrypted files, so that they restrict access to them too
+		placed := ChunkResponse{Hash: hash, Found: true, Data: chunk, Multihash: multihash,
+			MerkleRoot: tree.Root.Hash, Proof: tree.GenerateMerkleProof(i)}
+		placed.Restricted, _ = Access.RestrictedTo(hash)
+		if compress {
+			placed.compress()
+		}
+		streamed := placed.detachData(streaming)
+		err = writeFlushed(rw, StoreChunk, placed)
+		if err == nil && streamed != nil {
+			err = streamChunkData(rw, setDeadline, hash, streamed)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ack StoreAck
+		err = readReply(rw.Reader, StoreChunkAck, &ack)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(ack.Hash, hash) {
+			return nil, ErrChunkRefused
+		}
+		if !ack.Stored {
+			if ack.Reason == RefusalQuotaExceeded {
+				return nil, ErrQuotaExceeded
+			}
+			if ack.Reason != "" {
+				return nil, fmt.Errorf("%w (%s)", ErrChunkRefused, ack.Reason)
+			}
+			return nil, ErrChunkRefused
+		}
+		if err := ack.Verify(holder, placed.MerkleRoot); err != nil {
+			return nil, err
+		}
+		recordCredit(holder, storage.CreditConsumed, len(chunk), ack)
+		receipts = append(receipts, ack)
+	}
+	return receipts, nil
+}
+
+// Function that audits a peer's replica of a file by fetching one of its chunks, chosen at random, and checking it
+func AuditReplica(ctx context.Context, holder string, chunkHashes [][]byte) (err error) {
+	ctx, span := tracing.Start(ctx, "network.audit_replica", attribute.String("peer", holder))
+	defer func() { tracing.End(span, err) }()
+
+	peerID, err := peer.Decode(holder)
+	if err != nil {
+		return err
+	}
+	if len(chunkHashes) == 0 {
+		return errors.New("file has no chunks to audit")
+	}
+	hash := chunkHashes[rand.Intn(len(chunkHashes))]
+
+	auditErr := challengeChunks(ctx, peerID, [][]byte{hash})[0]
+	RecordChunkVerification(peerID, auditErr == nil)
+	// The peer attests to its version in its reply, so a replica held by a build below the minimum fails the audit
+	if auditErr == nil && !acceptablePeerVersion(peerID) {
+		return ErrPeerVersion
+	}
+	return auditErr
+}
@@ -1,77 +1,287 @@
 package network
 
 import (
+	"blockchain-storage/core"
+	"blockchain-storage/events"
+	"blockchain-storage/tracing"
 	"bufio"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
+	"time"
 )
 
 // Define the protocol name
 const protocol = "blockchain-storage"
 
+// Longest a single message may take to send to a peer, when the caller gives no earlier deadline
+const sendTimeout = 30 * time.Second
+
+// Longest an incoming stream is kept open while its peer sends nothing
+const streamIdleTimeout = 2 * time.Minute
+
 // Define a new type for type of message
 type MessageType string
 
 // Define the various constants that the message type type can be (i.e. all the different message types)
 const (
 	SendNewBlock      MessageType = "NewBlock"
+	SendEncodedBlock  MessageType = "EncodedBlock"
 	SendChunks        MessageType = "SendChunks"
 	RequestChunks     MessageType = "RequestChunks"
+	ResumeChunks      MessageType = "ResumeChunks"
 	RequestBlockchain MessageType = "RequestBlockchain"
+
+	ChunkAvailabilityReply MessageType = "ChunkAvailability"
+	SendCapabilities       MessageType = "SendCapabilities"
+	StoreChunk             MessageType = "StoreChunk"
+	StoreChunkAck          MessageType = "StoreChunkAck"
+	ChunkData              MessageType = "ChunkData"
+	ChunkWindow            MessageType = "ChunkWindow"
+	SignAgreement          MessageType = "SignAgreement"
+	AgreementSignature     MessageType = "AgreementSignature"
 )
 
+// The node's local copy of the blockchain that received blocks are added to
+var Chain *core.Blockchain
+
+// Bus that PeerConnected, PeerLost, PeerReconnected, ReplicaPlaced and SyncCompleted events are published on (nil
+// publishes nothing)
+var Events *events.Bus
+
+// Channel on which newly accepted blocks are announced so that a local miner can pre-empt its work
+var NewTips = make(chan *core.Block, 1)
+
 // Define the message structure holding its type and json payload
 type Message struct {
 	Type    MessageType     `json:"type"`
 	Payload json.RawMessage `json:"payload"`
 }
 
+// Function that sends a single message to a peer on a new stream
+func sendMessage(ctx context.Context, peerID peer.ID, messageType MessageType, payload any) error {
+	if nodeHost == nil {
+		return errors.New("node has not been started")
+	}
+
+	stream, err := nodeHost.NewStream(ctx, peerID, protocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// The stream does not follow the context once it is open, so a peer that stops reading is bounded by its deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		err = stream.SetWriteDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	return writeMessage(stream, messageType, payload)
+}
+
+// Function that writes a single message to a stream
+func writeMessage(w io.Writer, messageType MessageType, payload any) error {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	jsonMessage, err := json.Marshal(Message{Type: messageType, Payload: jsonPayload})
+	if err != nil {
+		return err
+	}
+
+	// Messages are delimited by newlines, which is what the receiving handler reads up to
+	_, err = w.Write(append(jsonMessage, '\n'))
+	return err
+}
+
+// Function that announces a newly mined block to every connected peer
+func BroadcastBlock(block *core.Block) {
+	for _, peerInfo := range GetPeers() {
+		// Send to each peer concurrently so that one slow peer does not delay the others
+		go func(peerID peer.ID) {
+			ctx, cancel := context.WithTimeout(nodeContext, sendTimeout)
+			defer cancel()
+			err := sendBlock(ctx, peerID, block)
+			if err != nil {
+				logger.Error("error encountered when sending block to peer", "peer", peerID, "error", err)
+			}
+		}(peerInfo.ID)
+	}
+}
+
+// Function that sends a block to a peer, in the canonical wire encoding if the peer accepts it and as JSON otherwise
+func sendBlock(ctx context.Context, peerID peer.ID, block *core.Block) (err error) {
+	ctx, span := tracing.Start(ctx, "network.send_block", attribute.String("peer", peerID.String()),
+		attribute.Int64("block", block.Index))
+	defer func() { tracing.End(span, err) }()
+
+	if !acceptsWireBlocks(peerID) {
+		return sendMessage(ctx, peerID, SendNewBlock, block)
+	}
+	encoded, err := block.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return sendMessage(ctx, peerID, SendEncodedBlock, encoded)
+}
+
 // Function that the host uses to handle a stream
 func handleStream(stream network.Stream) {
-	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	// Chunks are served and placed on the same streams as every other message, so all of them count towards the limits
+	limited := limitStream(nodeContext, stream, stream.Conn().RemotePeer())
+	rw := bufio.NewReadWriter(bufio.NewReader(limited), bufio.NewWriter(limited))
 	// Handle the actual stream in a go routine to allow handleStream to return and be used for the next incoming stream
-	go determineHandler(rw)
+	go determineHandler(rw, stream.SetReadDeadline, stream.Conn().RemotePeer())
 }
 
-func determineHandler(rw *bufio.ReadWriter) {
+// Function that reads messages from a peer's stream and dispatches them to the handler for their type
+// A peer that sends nothing for the idle timeout has its stream abandoned, so idle streams do not hold handlers open
+func determineHandler(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, peerID peer.ID) {
 	for {
-		// Read a full message
-		str, err := rw.ReadString('\n')
+		err := setReadDeadline(time.Now().Add(streamIdleTimeout))
 		if err != nil {
-			if err != io.EOF {
-				break
-			} else {
-				fmt.Printf("error encountered when reading stream: %s", err)
-				return
-			}
+			logger.Warn("error encountered when setting stream deadline", "peer", peerID, "error", err)
+			return
+		}
+		// Read a full message, dropping the stream if it cannot be decoded as there is no telling where the next starts
+		frame, err := readFrame(rw.Reader)
+		if errors.Is(err, ErrMessageTooLarge) {
+			logger.Warn("dropped stream of peer sending an oversized message", "peer", peerID)
+			RecordReputationEvent(peerID, EventMalformedMessage)
+			return
+		}
+		// A peer closing its stream is routine, so only other read errors are reported as errors
+		if errors.Is(err, io.EOF) {
+			logger.Debug("peer closed stream", "peer", peerID)
+			return
+		}
+		if err != nil {
+			logger.Error("error encountered when reading stream", "peer", peerID, "error", err)
+			return
 		}
-		if str == "" || str == "\n" {
+		if len(frame) == 0 || string(frame) == "\n" {
 			continue
 		}
-		var message Message
-		if err := json.Unmarshal([]byte(str), &message); err != nil {
-			fmt.Printf("error encountered when unmarshalling message: %s", err)
+		message, err := decodeMessage(frame)
+		if err != nil {
+			logger.Warn("error encountered when decoding message", "peer", peerID, "error", err)
+			RecordReputationEvent(peerID, EventMalformedMessage)
+			return
+		}
+		// Messages over the rate limit are dropped unanswered, so a flooding peer gets no more work out of the node
+		if !allowMessage(peerID, message.Type, time.Now()) {
+			logger.Warn("dropped message over the rate limit", "peer", peerID, "type", message.Type)
+			RecordReputationEvent(peerID, EventRateLimited)
 			continue
 		}
 		switch message.Type {
 		case SendNewBlock:
-			handleSendNewBlock()
+			handleSendNewBlock(peerID, message.Payload)
+		case SendEncodedBlock:
+			handleSendEncodedBlock(peerID, message.Payload)
 		case SendChunks:
-			handleSendChunks()
+			handleSendChunks(peerID, message.Payload)
 		case RequestChunks:
-			handleRequestChunks()
+			handleRequestChunks(rw, setReadDeadline, peerID, message.Payload)
+		case ResumeChunks:
+			handleResumeChunks(rw, setReadDeadline, peerID, message.Payload)
 		case RequestBlockchain:
 			handleRequestBlockchain()
+		case SendCapabilities:
+			handleSendCapabilities(peerID, message.Payload)
+		case StoreChunk:
+			handleStoreChunk(rw, setReadDeadline, peerID, message.Payload)
+		case SignAgreement:
+			handleSignAgreement(rw, peerID, message.Payload)
 		}
 	}
 }
 
-func handleSendNewBlock() {}
+// Function that handles a newly mined block sent by another node as JSON
+func handleSendNewBlock(peerID peer.ID, payload json.RawMessage) {
+	var block core.Block
+	if err := decodePayload(payload, &block); err != nil {
+		logger.Warn("error encountered when unmarshalling block", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	receiveBlock(peerID, &block)
+}
+
+// Function that handles a newly mined block sent by another node in the canonical wire encoding
+func handleSendEncodedBlock(peerID peer.ID, payload json.RawMessage) {
+	var encoded []byte
+	var block core.Block
+	if err := decodePayload(payload, &encoded); err != nil {
+		logger.Warn("error encountered when unmarshalling encoded block", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+	if err := block.UnmarshalBinary(encoded); err != nil {
+		logger.Warn("error encountered when decoding block", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return
+	}
+	receiveBlock(peerID, &block)
+}
+
+// Function that adds a block received from another node to the local chain if it validly extends it
+func receiveBlock(peerID peer.ID, block *core.Block) {
+	if Chain == nil {
+		return
+	}
+
+	// Reject blocks from the future, judging the timestamp by the sender's clock rather than assuming synced clocks
+	if ToLocalTime(peerID, block.Timestamp).After(time.Now().Add(core.MaxBlockTimeDrift)) {
+		logger.Warn("rejected block with a timestamp too far in the future", "peer", peerID, "block", block.Index)
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return
+	}
+
+	// Only accept the block if it validly extends the local chain
+	if err := Chain.AddValidBlock(block, core.MiningDifficulty); err != nil {
+		// A block beyond the next height may only have arrived before its parents, so it is held until they arrive
+		// Only a mined and signed block is held, and the sender's chain length is only raised once it connects, so
+		// that a made up block cannot make the node believe it is behind
+		if block.Index > int64(localChainLength()) {
+			if err := block.VerifyDetached(core.MiningDifficulty); err != nil {
+				logger.Warn("rejected orphan block", "peer", peerID, "block", block.Index, "error", err)
+				RecordReputationEvent(peerID, EventInvalidBlock)
+				return
+			}
+			if addOrphan(block, peerID, time.Now()) {
+				logger.Debug("holding orphan block until its parents arrive", "peer", peerID, "block", block.Index)
+				go requestParents(nodeContext, peerID, block)
+			}
+			return
+		}
+		logger.Warn("rejected block", "peer", peerID, "block", block.Index, "error", err)
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return
+	}
+	acceptedBlock(peerID, block)
+}
 
-func handleSendChunks() {}
+// Function that records a block from another node being added to the local chain, connecting any orphan blocks
+// waiting for it
+func acceptedBlock(peerID peer.ID, block *core.Block) {
+	RecordReputationEvent(peerID, EventValidBlock)
+	logger.Info("accepted block", "peer", peerID, "block", block.Index)
+	setPeerChainLength(peerID, int(block.Index)+1)
+	updateSyncState()
 
-func handleRequestChunks() {}
+	// Notify any local miner of the new tip without blocking if nobody is listening
+	select {
+	case NewTips <- block:
+	default:
+	}
+	connectOrphans(block.Hash)
+}
 
 func handleRequestBlockchain() {}
@@ -0,0 +1,115 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"context"
+	"errors"
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// Maximum number of peers looked up when finding the holders of a file's replicas
+const maxReplicaHolders = 20
+
+// The distributed hash table of the running node (nil until the node is started)
+var localDHT *dht.IpfsDHT
+
+// Function that converts a SHA-256 hash of a chunk or Merkle root into the content identifier used as a DHT key
+func contentCID(hash []byte) (cid.Cid, error) {
+	encoded, err := multihash.Encode(hash, multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, encoded), nil
+}
+
+// Function that announces through the DHT that this node holds the content with the given hash
+func Provide(ctx context.Context, hash []byte) error {
+	if localDHT == nil {
+		return errors.New("node has not been started")
+	}
+	key, err := contentCID(hash)
+	if err != nil {
+		return err
+	}
+	return localDHT.Provide(ctx, key, true)
+}
+
+// Function that announces a file's Merkle root and every one of its chunks, so that peers can find the node either
+// when resolving a whole file or when looking for an individual chunk
+func ProvideManifest(ctx context.Context, manifest *core.Manifest) error {
+	err := Provide(ctx, manifest.MerkleRoot)
+	if err != nil {
+		return err
+	}
+	for _, chunkHash := range manifest.ChunkHashes {
+		err := Provide(ctx, chunkHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that looks up which peers hold the content with the given hash, returning at most count peers
+func FindProviders(ctx context.Context, hash []byte, count int) ([]peer.AddrInfo, error) {
+	if localDHT == nil {
+		return nil, errors.New("node has not been started")
+	}
+	key, err := contentCID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []peer.AddrInfo
+	for provider := range localDHT.FindProvidersAsync(ctx, key, count) {
+		// This node may be one of the providers, but it never needs to ask itself for data
+		if nodeHost != nil && provider.ID == nodeHost.ID() {
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// Function that looks up the peers holding a replica of a file, returning at most maxReplicaHolders peer IDs
+// Peers holding replicas announce the file's chunks rather than its Merkle root, so the first chunk is looked up
+func FindReplicaHolders(ctx context.Context, chunkHashes [][]byte) ([]string, error) {
+	if len(chunkHashes) == 0 {
+		return []string{}, nil
+	}
+	providers, err := FindProviders(ctx, chunkHashes[0], maxReplicaHolders)
+	if err != nil {
+		return nil, err
+	}
+	holders := make([]string, len(providers))
+	for i, provider := range providers {
+		holders[i] = provider.ID.String()
+	}
+	return holders, nil
+}
+
+// Function that announces every piece of content held by the node, logging how many announcements failed
+// A node can hold many thousands of chunks, so failures are summarised rather than logged one by one.
+func provideAll(ctx context.Context, hashes [][]byte) {
+	failed := 0
+	var lastErr error
+	for _, hash := range hashes {
+		if ctx.Err() != nil {
+			return
+		}
+		err := Provide(ctx, hash)
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		logger.Error("error encountered when announcing content", "failed", failed, "total", len(hashes),
+			"error", lastErr)
+		return
+	}
+	logger.Info("announced content", "total", len(hashes))
+}
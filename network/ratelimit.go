@@ -0,0 +1,131 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/time/rate"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error returned when a rate limit is not given as the type of message, its rate and its burst
+var ErrInvalidRateLimit = errors.New("rate limit must be given as <message type>=<messages per second>/<burst>")
+
+// MessageRateLimit - Structure holding how many messages of a type a single peer may send
+// Messages arriving faster than the rate are dropped once the burst is used up (a rate of 0 for no limit)
+type MessageRateLimit struct {
+	Rate  float64 `json:"rate"`  // Messages per second a peer may send on average
+	Burst int     `json:"burst"` // Messages a peer may send at once after being idle
+}
+
+// MessageRateLimits - Map holding the rate limit of each type of message (types missing from it have no limit)
+type MessageRateLimits map[MessageType]MessageRateLimit
+
+// Function that returns the rate limits applied when none are configured
+// Requests make the node read chunks or build replies, so they are limited more tightly than announcements. Chunks
+// placed on the node are limited loosely, as an uploader places a whole file's worth of them at once.
+func DefaultMessageRateLimits() MessageRateLimits {
+	return MessageRateLimits{
+		SendNewBlock:      {Rate: 5, Burst: 20},
+		SendEncodedBlock:  {Rate: 5, Burst: 20},
+		SendChunks:        {Rate: 20, Burst: 50},
+		RequestChunks:     {Rate: 20, Burst: 50},
+		ResumeChunks:      {Rate: 2, Burst: 10},
+		RequestBlockchain: {Rate: 0.2, Burst: 2},
+		SendCapabilities:  {Rate: 0.2, Burst: 5},
+		StoreChunk:        {Rate: 100, Burst: 500},
+	}
+}
+
+// Limits applied to the messages received from peers and the limiters of each peer, which can be changed while the
+// node runs
+var messageRateLimits = DefaultMessageRateLimits()
+var peerMessageLimiters = make(map[peer.ID]*messageLimiters)
+var messageRateMutex sync.Mutex
+
+// messageLimiters - Structure holding the limiters of the messages received from a single peer, one for each type
+type messageLimiters struct {
+	limiters map[MessageType]*rate.Limiter
+	lastUsed time.Time
+}
+
+// Function that sets the rates peers may send each type of message at, taking effect on peers already connected
+func SetMessageRateLimits(limits MessageRateLimits) error {
+	for messageType, limit := range limits {
+		if limit.Rate < 0 || limit.Burst < 0 || (limit.Rate > 0 && limit.Burst == 0) {
+			return fmt.Errorf("invalid rate limit for %s messages: the rate must not be negative and the burst must "+
+				"be positive", messageType)
+		}
+	}
+	messageRateMutex.Lock()
+	defer messageRateMutex.Unlock()
+	messageRateLimits = make(MessageRateLimits, len(limits))
+	for messageType, limit := range limits {
+		messageRateLimits[messageType] = limit
+	}
+	// Limiters are created again on each peer's next message, with the new limits
+	clear(peerMessageLimiters)
+	return nil
+}
+
+// Function that returns the rates peers may send each type of message at
+func GetMessageRateLimits() MessageRateLimits {
+	messageRateMutex.Lock()
+	defer messageRateMutex.Unlock()
+	limits := make(MessageRateLimits, len(messageRateLimits))
+	for messageType, limit := range messageRateLimits {
+		limits[messageType] = limit
+	}
+	return limits
+}
+
+// Function that checks whether a message a peer sent is within the rate limit of its type, using up one message of
+// the peer's allowance if it is
+func allowMessage(peerID peer.ID, messageType MessageType, now time.Time) bool {
+	messageRateMutex.Lock()
+	defer messageRateMutex.Unlock()
+	limit, found := messageRateLimits[messageType]
+	if !found || limit.Rate == 0 {
+		return true
+	}
+	peerLimiters, found := peerMessageLimiters[peerID]
+	if !found {
+		// Drop the limiters of idle peers whenever a new peer is seen so that the map does not grow without bound
+		for otherID, other := range peerMessageLimiters {
+			if now.Sub(other.lastUsed) > peerLimiterTTL {
+				delete(peerMessageLimiters, otherID)
+			}
+		}
+		peerLimiters = &messageLimiters{limiters: make(map[MessageType]*rate.Limiter)}
+		peerMessageLimiters[peerID] = peerLimiters
+	}
+	peerLimiters.lastUsed = now
+	limiter, found := peerLimiters.limiters[messageType]
+	if !found {
+		limiter = rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)
+		peerLimiters.limiters[messageType] = limiter
+	}
+	return limiter.AllowN(now, 1)
+}
+
+// Function that parses a rate limit given as "RequestChunks=20/50", being the type of message, the messages a peer may
+// send per second and the burst
+func ParseMessageRateLimit(value string) (MessageType, MessageRateLimit, error) {
+	messageType, rateAndBurst, found := strings.Cut(value, "=")
+	if !found {
+		return "", MessageRateLimit{}, fmt.Errorf("%w: %s", ErrInvalidRateLimit, value)
+	}
+	if _, known := DefaultMessageRateLimits()[MessageType(messageType)]; !known {
+		return "", MessageRateLimit{}, fmt.Errorf("unknown message type %s", messageType)
+	}
+	rateValue, burstValue, found := strings.Cut(rateAndBurst, "/")
+	messagesPerSecond, rateErr := strconv.ParseFloat(rateValue, 64)
+	burst, burstErr := strconv.Atoi(burstValue)
+	if !found || rateErr != nil || burstErr != nil {
+		return "", MessageRateLimit{}, fmt.Errorf("%w: %s", ErrInvalidRateLimit, value)
+	}
+	return MessageType(messageType), MessageRateLimit{Rate: messagesPerSecond, Burst: burst}, nil
+}
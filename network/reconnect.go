@@ -0,0 +1,114 @@
+package network
+
+import (
+	"blockchain-storage/events"
+	"context"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Delay before the first attempt to reconnect to a peer whose connection dropped, which doubles after each failure
+const reconnectBaseDelay = time.Second
+
+// Longest delay between two attempts to reconnect to a peer
+const reconnectMaxDelay = 5 * time.Minute
+
+// Number of failed attempts after which a peer is given up on (bootstrap peers are retried for as long as the node
+// runs, as they are how it joins the network)
+const maxReconnectAttempts = 10
+
+// Peers that are being reconnected to
+var reconnecting = make(map[peer.ID]bool)
+var reconnectingMutex = &sync.Mutex{}
+
+// IDs of the bootstrap peers the node was started with
+var bootstrapPeerIDs = make(map[peer.ID]bool)
+
+// Function that registers the connection manager, which reconnects to peers whose connections drop until the context
+// is cancelled
+// Only peers in the list of peers are reconnected to, so peers that were disconnected on purpose (e.g. banned or
+// pruned for missing heartbeats) are left alone, as they are removed from the list before their connections close.
+func registerReconnects(ctx context.Context, bootstrapPeers []*peer.AddrInfo) {
+	reconnectingMutex.Lock()
+	bootstrapPeerIDs = make(map[peer.ID]bool)
+	for _, peerInfo := range bootstrapPeers {
+		bootstrapPeerIDs[peerInfo.ID] = true
+	}
+	reconnectingMutex.Unlock()
+
+	nodeHost.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: func(_ network.Network, conn network.Conn) {
+			go peerDisconnected(ctx, conn.RemotePeer())
+		},
+	})
+}
+
+// Function that handles a closed connection to a peer, reconnecting to it if it was the peer's last connection
+func peerDisconnected(ctx context.Context, peerID peer.ID) {
+	if ctx.Err() != nil || nodeHost.Network().Connectedness(peerID) == network.Connected || !isKnownPeer(peerID) {
+		return
+	}
+	reconnectingMutex.Lock()
+	if reconnecting[peerID] {
+		reconnectingMutex.Unlock()
+		return
+	}
+	reconnecting[peerID] = true
+	bootstrap := bootstrapPeerIDs[peerID]
+	reconnectingMutex.Unlock()
+	defer func() {
+		reconnectingMutex.Lock()
+		delete(reconnecting, peerID)
+		reconnectingMutex.Unlock()
+	}()
+
+	// The peer's addresses are kept before it is removed, as they are what it is dialled on again
+	peerInfo := peer.AddrInfo{ID: peerID, Addrs: nodeHost.Peerstore().Addrs(peerID)}
+	removePeer(peerID)
+	logger.Info("lost connection to peer, reconnecting", "peer", peerID)
+
+	for attempt := 0; bootstrap || attempt < maxReconnectAttempts; attempt++ {
+		select {
+		case <-time.After(reconnectDelay(attempt, rand.Float64())):
+		case <-ctx.Done():
+			return
+		}
+		if !Gater.peerAllowed(peerID) {
+			return
+		}
+		// The peer may have connected to this node in the meantime, which is as good as reconnecting to it
+		if nodeHost.Network().Connectedness(peerID) != network.Connected {
+			err := connectWithTimeout(ctx, peerInfo)
+			if err != nil {
+				logger.Debug("failed to reconnect to peer", "peer", peerID, "attempt", attempt+1, "error", err)
+				continue
+			}
+		}
+		addPeer(&peerInfo)
+		logger.Info("reconnected to peer", "peer", peerID, "attempts", attempt+1)
+		Events.Publish(events.Event{Type: events.PeerReconnected, Peer: peerID.String()})
+		return
+	}
+	logger.Warn("gave up reconnecting to peer", "peer", peerID, "attempts", maxReconnectAttempts)
+}
+
+// Function that connects to a peer, giving up once the connection timeout passes
+func connectWithTimeout(ctx context.Context, peerInfo peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return nodeHost.Connect(ctx, peerInfo)
+}
+
+// Function that returns how long to wait before an attempt to reconnect, given how many attempts have failed
+// The delay doubles with each failure up to the maximum, and a random factor from 0 to 1 spreads it over its upper
+// half, so that nodes losing a peer at the same time do not all dial it at once
+func reconnectDelay(attempt int, random float64) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 32 && reconnectBaseDelay<<attempt < reconnectMaxDelay {
+		delay = reconnectBaseDelay << attempt
+	}
+	return delay/2 + time.Duration(random*float64(delay/2))
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"blockchain-storage/storage"
+	"context"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"slices"
+	"time"
+)
+
+// Role advertised by nodes that store nothing permanently and cache the chunks passing through them instead
+const CacheRole = "cache"
+
+// Longest time a cache node spends fetching the chunks a peer asked for that it does not hold, well within the time
+// the peer waits for its reply
+const relayFetchTimeout = 30 * time.Second
+
+// The cache of a cache node holding the chunks it fetched for its peers, apart from the cache of the chunks it serves
+// itself so that passing traffic does not push those out (nil unless the node only caches chunks)
+var RelayCache *storage.ChunkCache
+
+// Whether the node only caches chunks, refusing chunks placed on it and fetching the chunks peers ask for that it does
+// not hold from other peers into its relay cache (set when the node starts)
+var cacheOnly bool
+
+// Function that returns whether a peer only caches chunks rather than storing them, going by the roles it gave in its
+// handshake
+func isCachePeer(peerID peer.ID) bool {
+	return hasExclusiveRole(peerID, CacheRole)
+}
+
+// Function that fetches the chunks a peer asked a cache node for that it does not hold into the relay cache, so that
+// they can be served in the reply and to every peer that asks for them later
+// Requests from other cache nodes are never relayed, so that a request passes through at most one cache node and cache
+// nodes cannot keep asking each other for a chunk none of them holds.
+func relayMissingChunks(requester peer.ID, hashes [][]byte) {
+	if !cacheOnly || RelayCache == nil || nodeHost == nil || isCachePeer(requester) {
+		return
+	}
+	var missing [][]byte
+	for _, hash := range hashes {
+		if !holdsChunk(hash) {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), relayFetchTimeout)
+	defer cancel()
+	candidates := relayCandidates(ctx, requester, missing[0])
+	if len(candidates) == 0 {
+		return
+	}
+	request := func(ctx context.Context, peerID peer.ID, hashes [][]byte, report chunkReport) {
+		requestChunks(ctx, peerID, hashes, nil, nil, report)
+	}
+	fetches, err := fetchChunksFrom(ctx, missing, candidates, request, RelayCache.Put, nil)
+	if err != nil {
+		logger.Debug("failed to relay every chunk asked for", "peer", requester, "error", err)
+	}
+	// Announcing the cached chunks lets peers near the cache node find them there
+	var cached [][]byte
+	for i, fetch := range fetches {
+		if fetch.Error == "" {
+			cached = append(cached, missing[i])
+		}
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), relayFetchTimeout)
+		defer cancel()
+		for _, hash := range cached {
+			if err := Provide(ctx, hash); err != nil {
+				logger.Debug("failed to announce relayed chunk", "error", err)
+				return
+			}
+		}
+	}()
+}
+
+// Function that finds the peers a cache node asks for chunks it does not hold, best first, which are the providers of
+// the first chunk or else every connected peer, leaving out the requester and other cache nodes
+// The chunks a peer asks for in one request are usually of one file, so their holders usually hold every one of them.
+func relayCandidates(ctx context.Context, requester peer.ID, hash []byte) []peer.ID {
+	var candidates []peer.ID
+	providers, err := FindProviders(ctx, hash, maxChunkProviders)
+	if err != nil {
+		return nil
+	}
+	for _, provider := range providers {
+		nodeHost.Peerstore().AddAddrs(provider.ID, provider.Addrs, peerstore.TempAddrTTL)
+		candidates = append(candidates, provider.ID)
+	}
+	if len(candidates) == 0 {
+		for _, peerInfo := range GetPeers() {
+			candidates = append(candidates, peerInfo.ID)
+		}
+	}
+	candidates = slices.DeleteFunc(candidates, func(candidate peer.ID) bool {
+		return candidate == requester || isCachePeer(candidate)
+	})
+	return rankProviders(candidates, nodeHost.Peerstore().LatencyEWMA)
+}
+
+// Function that adds the connected cache nodes to the candidates for fetching a file's chunks
+// Cache nodes may not hold the chunks yet, but they fetch them on first request and then serve them to every peer near
+// them, so asking them spreads the load of popular files away from their providers.
+func withCachePeers(candidates []peer.ID) []peer.ID {
+	for _, peerInfo := range GetPeers() {
+		if isCachePeer(peerInfo.ID) && !slices.Contains(candidates, peerInfo.ID) {
+			candidates = append(candidates, peerInfo.ID)
+		}
+	}
+	return candidates
+}
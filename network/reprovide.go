@@ -0,0 +1,90 @@
+package network
+
+import (
+	"context"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"time"
+)
+
+// Interval content is announced again at when none is configured, well within the 48 hours DHT provider records last
+const defaultReprovideInterval = 12 * time.Hour
+
+// Time waited after the node's addresses or reachability change before announcing again, so that the changes made
+// while a new network is joined (new addresses, then a relay reservation) lead to a single announcement
+const networkChangeSettle = 30 * time.Second
+
+// Function that watches the node's addresses and reachability, signalling on the returned channel whenever they change
+// Signals are coalesced, so a change made while an earlier one has not been handled yet is not signalled again.
+func watchNetworkChanges(ctx context.Context, host host.Host) (<-chan struct{}, error) {
+	subscription, err := host.EventBus().Subscribe([]any{
+		new(event.EvtLocalAddressesUpdated),
+		new(event.EvtLocalReachabilityChanged),
+	})
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer subscription.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-subscription.Out():
+				if !ok {
+					return
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// Function that announces the node's content (and its protocol, if it is discovered through the DHT) again at an
+// interval and shortly after every change to the node's network, until the context is done
+// Peers that joined the network after the last announcement, or that only know the node's old addresses, would
+// otherwise not find its content once the provider records stored when it first started have expired.
+func reprovide(ctx context.Context, content func() [][]byte, routingDiscovery *routing.RoutingDiscovery,
+	interval time.Duration, changes <-chan struct{}) {
+	reannounce(ctx, interval, networkChangeSettle, changes, func(ctx context.Context, networkChanged bool) {
+		if networkChanged && routingDiscovery != nil {
+			_, err := routingDiscovery.Advertise(ctx, protocol)
+			if err != nil {
+				logger.Warn("error encountered when advertising the protocol", "error", err)
+			}
+		}
+		if content != nil {
+			provideAll(ctx, content())
+		}
+	})
+}
+
+// Function that calls announce every interval, and once network changes have settled, until the context is done
+// Announcements never overlap, as one that takes longer than the interval delays the next.
+func reannounce(ctx context.Context, interval time.Duration, settle time.Duration, changes <-chan struct{},
+	announce func(ctx context.Context, networkChanged bool)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// The settle timer only runs once a change has been seen
+	settled := time.NewTimer(settle)
+	settled.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			settled.Reset(settle)
+		case <-settled.C:
+			announce(ctx, true)
+			ticker.Reset(interval)
+		case <-ticker.C:
+			announce(ctx, false)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package network
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReputationEvent - Type of peer behaviour that affects its reputation score
+type ReputationEvent string
+
+const (
+	EventChunkVerified ReputationEvent = "ChunkVerified" // Peer served a chunk that passed verification
+	EventChunkFailed   ReputationEvent = "ChunkFailed"   // Peer served a chunk that failed verification
+	EventValidBlock    ReputationEvent = "ValidBlock"    // Peer sent a block that was accepted
+	EventInvalidBlock  ReputationEvent = "InvalidBlock"  // Peer sent a block that was rejected
+	EventTimeout       ReputationEvent = "Timeout"       // Peer did not respond to a request in time
+	EventInvalidAdvert ReputationEvent = "InvalidAdvert" // Peer sent a capability advert with an invalid signature
+	EventAuditFailed   ReputationEvent = "AuditFailed"   // Peer failed to serve a chunk of a file it provides when audited
+
+	EventMalformedMessage ReputationEvent = "MalformedMessage" // Peer sent a message that was oversized or malformed
+	EventRateLimited      ReputationEvent = "RateLimited"      // Peer sent a message over the rate limit of its type
+)
+
+// Change in score applied for each type of event
+var reputationWeights = map[ReputationEvent]float64{
+	EventChunkVerified: 1,
+	EventChunkFailed:   -5,
+	EventValidBlock:    1,
+	EventInvalidBlock:  -10,
+	EventTimeout:       -2,
+	EventInvalidAdvert: -10,
+	EventAuditFailed:   -5,
+
+	EventMalformedMessage: -10,
+	EventRateLimited:      -1,
+}
+
+// Bounds of the reputation score so that a long history cannot make a peer untouchable or unredeemable
+const maxReputationScore = 100
+const minReputationScore = -100
+
+// Score below which a peer is disconnected
+const DisconnectThreshold = -50
+
+// Reputation - Structure holding the reputation of a single peer
+type Reputation struct {
+	Score       float64                 `json:"score"`       // Current score of the peer (higher is better)
+	Events      map[ReputationEvent]int `json:"events"`      // Number of times each event has been recorded
+	LastUpdated time.Time               `json:"lastUpdated"` // Time the reputation was last changed
+}
+
+var reputations = make(map[peer.ID]*Reputation)
+var reputationMutex = &sync.Mutex{}
+
+// Function that records an event for a peer and updates its score
+// If the score falls below the disconnect threshold the peer is disconnected
+func RecordReputationEvent(peerID peer.ID, event ReputationEvent) {
+	reputationMutex.Lock()
+	reputation, found := reputations[peerID]
+	if !found {
+		reputation = &Reputation{Events: make(map[ReputationEvent]int)}
+		reputations[peerID] = reputation
+	}
+	reputation.Events[event]++
+	reputation.Score += reputationWeights[event]
+	if reputation.Score > maxReputationScore {
+		reputation.Score = maxReputationScore
+	} else if reputation.Score < minReputationScore {
+		reputation.Score = minReputationScore
+	}
+	reputation.LastUpdated = time.Now()
+	score := reputation.Score
+	reputationMutex.Unlock()
+
+	if score < DisconnectThreshold {
+		disconnectPeer(peerID)
+	}
+}
+
+// Function that returns a copy of the reputation of a peer (the zero reputation if nothing has been recorded)
+func GetReputation(peerID peer.ID) Reputation {
+	reputationMutex.Lock()
+	defer reputationMutex.Unlock()
+
+	reputation, found := reputations[peerID]
+	if !found {
+		return Reputation{Events: make(map[ReputationEvent]int)}
+	}
+	events := make(map[ReputationEvent]int, len(reputation.Events))
+	for event, count := range reputation.Events {
+		events[event] = count
+	}
+	return Reputation{Score: reputation.Score, Events: events, LastUpdated: reputation.LastUpdated}
+}
+
+// Function that orders candidate peers for downloads and replication from best to worst reputation
+// Peers below the disconnect threshold or currently excluded for serving bad chunks are left out entirely
+func RankPeers(candidates []peer.ID) []peer.ID {
+	scores := make(map[peer.ID]float64, len(candidates))
+	var ranked []peer.ID
+	for _, peerID := range candidates {
+		score := GetReputation(peerID).Score
+		if score < DisconnectThreshold || IsPeerExcluded(peerID) {
+			continue
+		}
+		scores[peerID] = score
+		ranked = append(ranked, peerID)
+	}
+
+	// A stable sort keeps the caller's order for peers with equal scores
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+	return ranked
+}
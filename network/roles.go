@@ -0,0 +1,62 @@
+package network
+
+import (
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"slices"
+)
+
+// Roles a node can take on, which decide the subsystems it runs and are advertised to its peers
+// A node given the storage role stores the chunks placed on it whatever other roles it takes on, while the other
+// roles listed here store nothing for its peers unless combined with it.
+const (
+	StorageRole   = "storage"   // Stores the chunks its peers place on it
+	ClientRole    = "client"    // Uploads and downloads its own files, storing nothing for its peers
+	BootstrapRole = "bootstrap" // Serves the DHT and peer discovery only, storing nothing and taking no uploads
+)
+
+// Error returned when a node is given roles that cannot be combined
+var ErrConflictingRoles = errors.New("the bootstrap, client, cache and light roles cannot be combined")
+
+// Whether the node only uploads and downloads its own files, refusing chunks placed on it and querying the DHT without
+// serving it (set when the node starts)
+var clientOnly bool
+
+// Whether the node only serves the DHT and peer discovery, refusing chunks placed on it (set when the node starts)
+var bootstrapOnly bool
+
+// Function that returns the role a node given the roles takes on instead of storing chunks for its peers (empty if it
+// stores them), refusing roles that cannot be combined
+func ExclusiveRole(roles []string) (string, error) {
+	if slices.Contains(roles, StorageRole) {
+		return "", nil
+	}
+	exclusive := ""
+	for _, role := range []string{BootstrapRole, ClientRole, CacheRole, LightRole} {
+		if !slices.Contains(roles, role) {
+			continue
+		}
+		if exclusive != "" {
+			return "", ErrConflictingRoles
+		}
+		exclusive = role
+	}
+	return exclusive, nil
+}
+
+// Function that returns whether a peer took on a role without also storing chunks, going by the roles it gave in its
+// handshake
+func hasExclusiveRole(peerID peer.ID, role string) bool {
+	agreed, found := GetPeerProtocol(peerID)
+	return found && slices.Contains(agreed.Roles, role) && !slices.Contains(agreed.Roles, StorageRole)
+}
+
+// Function that returns whether a peer stores nothing for its peers, going by the roles it gave in its handshake
+func refusesPlacement(peerID peer.ID) bool {
+	for _, role := range []string{BootstrapRole, ClientRole, CacheRole, LightRole} {
+		if hasExclusiveRole(peerID, role) {
+			return true
+		}
+	}
+	return false
+}
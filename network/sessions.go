@@ -0,0 +1,169 @@
+package network
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Time a chunk transfer can be resumed for after it started
+const chunkSessionTTL = 5 * time.Minute
+
+// Number of times a dropped chunk transfer is resumed before the remaining chunks are asked for elsewhere
+const maxResumeAttempts = 2
+
+// Error returned when a peer no longer has the session a transfer was resumed with
+var ErrSessionExpired = errors.New("chunk transfer session has expired")
+
+// ResumeRequest - Payload of a message resuming a chunk transfer that was cut off
+// Only the chunks whose bit is not set in Received are sent again, so the transfer is not negotiated from scratch
+type ResumeRequest struct {
+	Token    string `json:"token"`    // Token the peer handed out when the transfer started
+	Received []byte `json:"received"` // Bitmap over the chunks the peer said it would send, set for those received
+}
+
+// chunkSession - Structure holding what the node needs to resume a chunk transfer it is serving
+// The requester says which chunks it received, so the session limits how many chunks are resent in total, or a peer
+// claiming to have received nothing could have the whole transfer resent for as long as the session lasts
+type chunkSession struct {
+	peerID  peer.ID   // Peer the transfer belongs to, the only one allowed to resume it
+	have    [][]byte  // Hashes of the chunks the node said it would send, in order
+	root    []byte    // Merkle root the chunks were requested for, whose proofs are sent with them (nil if none are)
+	expires time.Time // Time after which the transfer can no longer be resumed
+	credits int       // Number of chunks the node will still resend, spent by every resumed transfer
+}
+
+// Chunk transfers served by the node that can still be resumed, keyed by their token
+var chunkSessions = make(map[string]*chunkSession)
+var chunkSessionsMutex sync.Mutex
+
+// Function that records a chunk transfer served to a peer and returns the token resuming it
+func newChunkSession(peerID peer.ID, have [][]byte, merkleRoot []byte, now time.Time) string {
+	tokenBytes := make([]byte, 16)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return ""
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	chunkSessionsMutex.Lock()
+	defer chunkSessionsMutex.Unlock()
+	// Drop expired sessions whenever a new one starts so that the map does not grow without bound
+	for existingToken, session := range chunkSessions {
+		if now.After(session.expires) {
+			delete(chunkSessions, existingToken)
+		}
+	}
+	// Every chunk can be resent as many times as a requester resumes a transfer
+	chunkSessions[token] = &chunkSession{peerID: peerID, have: have, root: merkleRoot,
+		expires: now.Add(chunkSessionTTL), credits: len(have) * maxResumeAttempts}
+	return token
+}
+
+// Function that handles a request to resume a chunk transfer by sending the chunks that were not received
+// A token only resumes transfers to the peer it was handed to, and an unknown or expired token (or one whose session
+// has no credits left) gets an empty reply without a token so that the requester asks for the chunks again instead.
+// At most the session's remaining credits of chunks are resent, and the rest are left for the requester to ask for
+// elsewhere.
+func handleResumeChunks(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, peerID peer.ID,
+	payload json.RawMessage) {
+	var request ResumeRequest
+	if err := decodePayload(payload, &request); err != nil {
+		logger.Warn("error encountered when unmarshalling resume request", "peer", peerID, "error", err)
+		RecordReputationEvent(peerID, EventMalformedMessage)
+		return
+	}
+
+	chunkSessionsMutex.Lock()
+	session, found := chunkSessions[request.Token]
+	if found && (session.peerID != peerID || time.Now().After(session.expires) || session.credits <= 0) {
+		found = false
+	}
+	availability := ChunkAvailability{Token: request.Token}
+	if found {
+		for i, hash := range session.have {
+			if !bitSet(request.Received, i) && len(availability.Have) < session.credits {
+				availability.Have = append(availability.Have, hash)
+			}
+		}
+		session.credits -= len(availability.Have)
+	}
+	chunkSessionsMutex.Unlock()
+	if !found {
+		sendChunks(rw, setReadDeadline, ChunkAvailability{}, nil, peerID)
+		return
+	}
+	sendChunks(rw, setReadDeadline, availability, session.root, peerID)
+}
+
+// resumableTransfer - Structure tracking a chunk transfer on the requesting side so that it can be resumed
+type resumableTransfer struct {
+	token   string         // Token handed out by the peer (empty if the transfer cannot be resumed)
+	indexes map[string]int // Position of each chunk the peer said it would send
+	bitmap  []byte         // Bitmap over those chunks, set for the ones received
+	version *NodeVersion   // Build the peer attested to in its availability reply (nil if it did not attest to one)
+}
+
+// Function that records the start of a transfer from the peer's availability reply
+func (transfer *resumableTransfer) start(availability ChunkAvailability) {
+	transfer.token = availability.Token
+	transfer.version = availability.Version
+	transfer.indexes = make(map[string]int, len(availability.Have))
+	for i, hash := range availability.Have {
+		transfer.indexes[hex.EncodeToString(hash)] = i
+	}
+	transfer.bitmap = make([]byte, (len(availability.Have)+7)/8)
+}
+
+// Function that marks a chunk of the transfer as received
+func (transfer *resumableTransfer) received(hash []byte) {
+	if i, found := transfer.indexes[hex.EncodeToString(hash)]; found {
+		transfer.bitmap[i/8] |= 1 << (i % 8)
+	}
+}
+
+// Function that checks whether a transfer that failed with the given error can be resumed
+// A stream that dropped can be resumed, but a peer that timed out or lost the session is not tried again
+func (transfer *resumableTransfer) canResume(err error) bool {
+	if transfer.token == "" || errors.Is(err, ErrSessionExpired) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
+
+// Function that resumes a dropped transfer on a new stream, reading only the chunks that were not received
+func resumeChunks(stream io.ReadWriter, setReadDeadline func(time.Time) error, outstanding *outstandingChunks,
+	transfer *resumableTransfer) error {
+	err := writeMessage(stream, ResumeChunks, ResumeRequest{Token: transfer.token, Received: transfer.bitmap})
+	if err != nil {
+		return err
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+
+	var availability ChunkAvailability
+	setReadDeadline(time.Now().Add(chunkAvailabilityTimeout))
+	err = readReply(rw.Reader, ChunkAvailabilityReply, &availability)
+	if err != nil {
+		return err
+	}
+	if availability.Token != transfer.token {
+		return ErrSessionExpired
+	}
+	return readChunks(rw, setReadDeadline, availability.Have, outstanding, transfer)
+}
+
+// Function that checks whether a bit is set in a bitmap (bits past the end of the bitmap are unset)
+func bitSet(bitmap []byte, i int) bool {
+	return i/8 < len(bitmap) && bitmap[i/8]&(1<<(i%8)) != 0
+}
@@ -1,58 +1,153 @@
 package network
 
 import (
+	"blockchain-storage/logging"
 	"context"
 	"errors"
-	"fmt"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	"github.com/libp2p/go-libp2p/p2p/discovery/util"
 	"github.com/multiformats/go-multiaddr"
 	"sync"
+	"time"
 )
 
+// Longest a single attempt to connect to a peer may take
+const connectTimeout = 15 * time.Second
+
+// Logger of the networking component
+var logger = logging.Component(logging.Network)
+
 var Peers []*peer.AddrInfo
 var PeersMutex = &sync.Mutex{}
 
-func StartNode(port int, bootstrapAddr string) error {
-	// Context created for many of the network calls
-	ctx := context.Background()
+// The libp2p host of the running node (nil until the node is started)
+var nodeHost host.Host
+
+// Private key of the running node's identity, which signs the acknowledgments of chunks placed on it (nil until the
+// node is started)
+var identityKey crypto.PrivKey
+
+// Context of the running node, which work started by messages from peers runs under so that it ends when the node
+// stops (the background context until the node is started)
+var nodeContext = context.Background()
+
+// The connection gater enforcing allowed and banned peers (nil until the node is started)
+var Gater *PeerGater
+
+// Function that returns a copy of the list of connected peers
+func GetPeers() []peer.AddrInfo {
+	PeersMutex.Lock()
+	defer PeersMutex.Unlock()
+
+	peers := make([]peer.AddrInfo, len(Peers))
+	for i, peerInfo := range Peers {
+		peers[i] = *peerInfo
+	}
+	return peers
+}
+
+// Function that returns the peer ID of the node (empty until the node is started)
+func GetHostID() string {
+	if nodeHost == nil {
+		return ""
+	}
+	return nodeHost.ID().String()
+}
+
+// Function that starts the node and runs it until the context is cancelled, when the node's host is shut down
+func StartNode(ctx context.Context, config Config) error {
+	// Use the node's Ed25519 identity key (the same key that signs uploaded blocks) as its libp2p identity
+	priv, _, err := crypto.KeyPairFromStdKey(&config.IdentityKey)
+	if err != nil {
+		return err
+	}
+
+	// Data is only placed on peers running at least the minimum version, so it must be valid before the node starts
+	err = SetMinPeerVersion(config.MinPeerVersion)
+	if err != nil {
+		return err
+	}
+	err = SetBandwidthLimits(config.Bandwidth)
+	if err != nil {
+		return err
+	}
+	if config.MessageRates != nil {
+		err = SetMessageRateLimits(config.MessageRates)
+		if err != nil {
+			return err
+		}
+	}
+	SetStorageQuota(config.MaxStorageBytes)
+	cacheOnly = config.CacheOnly
+	lightClient = config.LightClient
+	clientOnly = config.ClientOnly
+	bootstrapOnly = config.BootstrapOnly
+
+	// Create the connection gater so that banned peers are refused at connection time
+	Gater, err = NewPeerGater(config.AllowedPeers, config.BannedPeers, config.AllowedCIDRs, config.BannedCIDRs)
+	if err != nil {
+		return err
+	}
 
-	// Generate a key pair for the node's identity
-	priv, _, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	// Options for NAT traversal depend on the node's configuration
+	options, err := natOptions(config)
 	if err != nil {
 		return err
 	}
+	// Listen on TCP along with any other enabled transports (QUIC noticeably improves connection setup and throughput)
+	options = append(options, libp2p.ListenAddrStrings(config.listenAddrs()...), libp2p.Identity(priv),
+		libp2p.ConnectionGater(Gater))
 
 	// Create a libp2p node
-	host, err := libp2p.New(libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port)), libp2p.Identity(priv))
+	host, err := libp2p.New(options...)
 	if err != nil {
 		return err
 	}
+	defer host.Close()
 
+	nodeContext = ctx
 	host.SetStreamHandler(protocol, handleStream)
+	nodeHost = host
+	identityKey = priv
+	logger.Info("node started", "peer", host.ID(), "addrs", host.Addrs())
+
+	// Shake hands with every newly connected peer to agree on a protocol version and estimate how far its clock is
+	// from the local one, advertising the same roles as the node's capability adverts
+	if config.Capabilities != nil {
+		localRoles = func() []string { return config.Capabilities().Roles }
+	}
+	registerHandshake(ctx)
+	// Check connected peers are alive with heartbeats, pruning those that stop answering
+	host.SetStreamHandler(heartbeatProtocol, handleHeartbeat)
+	go runHeartbeats(ctx)
+	// Serve snapshots and blocks to peers that are behind, signing snapshots with the identity key
+	fastSync = config.FastSync
+	snapshotKey = config.IdentityKey
+	host.SetStreamHandler(syncProtocol, handleSync)
+	// Share samples of the node's good peers with peers asking for them, whether or not it asks for theirs
+	host.SetStreamHandler(pexProtocol, handlePeerExchange)
 
 	// Create a local distributed hash table for peer discovery
 	// Its mode is set to server so that it can respond to query requests
-	// As every node is on a private network, all nodes should act as servers
-	localDHT, err := dht.New(ctx, host, dht.Mode(dht.ModeServer))
+	// As every node is on a private network, all nodes act as servers except clients, which only query it
+	mode := dht.ModeServer
+	if config.ClientOnly {
+		mode = dht.ModeClient
+	}
+	localDHT, err = dht.New(ctx, host, dht.Mode(mode))
+	if err != nil {
+		return err
+	}
 
 	// TODO: Allow multiple bootstrap peers to be added
 	var bootstrapPeers []*peer.AddrInfo
 
-	if bootstrapAddr != "" {
-		// Convert the address string into an address object
-		addr, err := multiaddr.NewMultiaddr(bootstrapAddr)
-		if err != nil {
-			return err
-		}
-
-		// Get peer ID and address
-		peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
+	if config.BootstrapAddr != "" {
+		peerInfo, err := parsePeerAddr(config.BootstrapAddr)
 		if err != nil {
 			return err
 		}
@@ -61,6 +156,9 @@ func StartNode(port int, bootstrapAddr string) error {
 		bootstrapPeers = append(bootstrapPeers, peerInfo)
 	}
 
+	// Reconnect to peers whose connections drop, retrying the bootstrap peers for as long as the node runs
+	registerReconnects(ctx, bootstrapPeers)
+
 	// Check if bootstrap peers provided and if so connect to them
 	if len(bootstrapPeers) > 0 {
 		err := connectToBootstrapPeers(ctx, host, bootstrapPeers)
@@ -69,18 +167,100 @@ func StartNode(port int, bootstrapAddr string) error {
 		}
 	}
 
+	// Populate the DHT's routing table so that provider records can be stored and looked up
+	err = localDHT.Bootstrap(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Announce all content already held by the node so that other peers can find it
+	if config.ProvidedContent != nil {
+		go provideAll(ctx, config.ProvidedContent())
+	}
+
+	// Advertise the node's capabilities to its peers and drop adverts from peers once they expire
+	go manageAdverts(ctx, config.Capabilities)
+
 	// Create a helper discovery object with the local DHT as its routing system
 	// It acts as a high-level API for discovery operations with the DHT
 	routingDiscovery := routing.NewRoutingDiscovery(localDHT)
 
-	// Advertise that the newly created node is accepting requests on the provided protocol
-	util.Advertise(ctx, routingDiscovery, protocol)
+	// Discover peers through every enabled backend, connecting to the candidates they find
+	err = startDiscovery(ctx, host, discoveryBackends(config, host, routingDiscovery))
+	if err != nil {
+		return err
+	}
+
+	// Announce the content again before its provider records expire and whenever the node's network changes, along
+	// with the protocol when peers discover the node through the DHT
+	networkChanges, err := watchNetworkChanges(ctx, host)
+	if err != nil {
+		return err
+	}
+	reprovideInterval := config.ReprovideInterval
+	if reprovideInterval <= 0 {
+		reprovideInterval = defaultReprovideInterval
+	}
+	var advertisedDiscovery *routing.RoutingDiscovery
+	if config.EnableDHTDiscovery {
+		advertisedDiscovery = routingDiscovery
+	}
+	go reprovide(ctx, config.ProvidedContent, advertisedDiscovery, reprovideInterval, networkChanges)
 
-	// Attempt to discover other peers
-	go discoverPeers(ctx, host, routingDiscovery)
+	// Run until the node is stopped (e.g. with Ctrl-C), which also stops everything started with the context
+	<-ctx.Done()
+	return nil
+}
 
-	// Temporarily block forever with a select statement (will be removed)
-	select {}
+// Function that converts a multiaddress string including a peer ID into the peer's ID and address
+func parsePeerAddr(peerAddr string) (*peer.AddrInfo, error) {
+	// Convert the address string into an address object
+	addr, err := multiaddr.NewMultiaddr(peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	// Get peer ID and address
+	return peer.AddrInfoFromP2pAddr(addr)
+}
+
+// Function that adds a peer to the list of peers, unless it is already in the list
+func addPeer(peerInfo *peer.AddrInfo) {
+	PeersMutex.Lock()
+	defer PeersMutex.Unlock()
+
+	for _, existing := range Peers {
+		if existing.ID == peerInfo.ID {
+			return
+		}
+	}
+	Peers = append(Peers, peerInfo)
+}
+
+// Function that removes a peer from the list of peers
+func removePeer(peerID peer.ID) {
+	PeersMutex.Lock()
+	defer PeersMutex.Unlock()
+
+	// Filter the list in place, keeping every peer apart from the one being removed
+	remaining := Peers[:0]
+	for _, peerInfo := range Peers {
+		if peerInfo.ID != peerID {
+			remaining = append(remaining, peerInfo)
+		}
+	}
+	Peers = remaining
+}
+
+// Function that closes all connections to a peer and removes it from the list of peers
+func disconnectPeer(peerID peer.ID) {
+	removePeer(peerID)
+	removeAdvert(peerID)
+	if nodeHost != nil {
+		err := nodeHost.Network().ClosePeer(peerID)
+		if err != nil {
+			logger.Error("error encountered when disconnecting peer", "peer", peerID, "error", err)
+		}
+	}
 }
 
 // Function used to connect to a number of bootstrap peers
@@ -118,43 +298,15 @@ func connectToBootstrapPeers(ctx context.Context, host host.Host, bootstrapPeers
 
 // Function used to connect to an individual peer
 func connectToBootstrapPeer(ctx context.Context, host host.Host, peerAddr *peer.AddrInfo, success chan bool) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
 	err := host.Connect(ctx, *peerAddr)
 	if err != nil {
 		// If connection errored, report this back to handler function
 		success <- false
 	} else {
-		PeersMutex.Lock()
 		// Connection successful so add peer to list of peers
-		Peers = append(Peers, peerAddr)
-		PeersMutex.Unlock()
+		addPeer(peerAddr)
 		success <- true
 	}
 }
-
-// Function used to discover other peers once connected to the bootstrap network
-func discoverPeers(ctx context.Context, host host.Host, routingDiscovery *routing.RoutingDiscovery) {
-	// Create a channel on which new peers will be discovered
-	peerChan, err := routingDiscovery.FindPeers(ctx, protocol)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	// Infinitely loop waiting for a new peer to be discovered
-	for peer := range peerChan {
-		if peer.ID == host.ID() {
-			continue
-		}
-
-		// Attempt a connection to the peer
-		err := host.Connect(ctx, peer)
-		if err != nil {
-			fmt.Printf("Failed to connect to peer %s for reason %s", peer.ID, err)
-		} else {
-			// If connection successful add it to the list of peers
-			PeersMutex.Lock()
-			Peers = append(Peers, &peer)
-			PeersMutex.Unlock()
-		}
-	}
-}
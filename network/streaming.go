@@ -0,0 +1,134 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"time"
+)
+
+// Size of the frames the data of a streamed chunk is split into, so that no single message has to hold a whole chunk
+const chunkFrameSize = 256 * 1024
+
+// Number of frames the sender of a streamed chunk may have in flight, half of which the receiver grants at a time
+const chunkWindowFrames = 16
+
+// First protocol version in which peers accept chunks streamed in frames
+const streamingProtocolVersion = 5
+
+// ChunkFrame - Payload of a message carrying one frame of a streamed chunk's data
+type ChunkFrame struct {
+	Data []byte `json:"data"` // Next part of the chunk's data (a full frame unless it is the last)
+}
+
+// WindowUpdate - Payload of a message granting the sender of a streamed chunk more frames
+type WindowUpdate struct {
+	Frames int `json:"frames"` // Number of further frames the sender may send
+}
+
+// ChunkTransferProgress - Structure describing how much of a streamed chunk has been sent or received
+type ChunkTransferProgress struct {
+	Hash        []byte `json:"hash"`        // Hash of the chunk
+	Sending     bool   `json:"sending"`     // Whether the node is sending the chunk rather than receiving it
+	Transferred int64  `json:"transferred"` // Bytes of the chunk's data sent or received so far
+	Size        int64  `json:"size"`        // Bytes of the chunk's data in total, as sent (compressed if it was)
+}
+
+// Function called on both ends of a streamed chunk each time one of its frames is sent or received (nil reports
+// nothing)
+var ChunkProgress func(ChunkTransferProgress)
+
+// Function that returns whether a peer accepts chunks streamed in frames, which it does from protocol version 5
+func acceptsStreamedChunks(peerID peer.ID) bool {
+	agreed, found := GetPeerProtocol(peerID)
+	return found && agreed.Version >= streamingProtocolVersion
+}
+
+// Function that takes a chunk's data out of its message if it is large enough to be streamed after it instead,
+// returning the data to stream (nil if the data stays in the message)
+func (response *ChunkResponse) detachData(streaming bool) []byte {
+	if !streaming || len(response.Data) <= chunkFrameSize {
+		return nil
+	}
+	data := response.Data
+	response.Data = nil
+	response.Streamed = int64(len(data))
+	return data
+}
+
+// Function that returns the number of frames data of a size is streamed in
+func frameCount(size int64) int {
+	return int((size + chunkFrameSize - 1) / chunkFrameSize)
+}
+
+// Function that checks whether the receiver of a streamed chunk grants the sender more frames after receiving some
+// Half a window is granted each time half a window has been received, but only while the sender has frames left
+// beyond those it may already send, as it only reads grants once its window is used up and none may be left unread
+func windowGrantDue(received int, total int) bool {
+	return received%(chunkWindowFrames/2) == 0 && received+chunkWindowFrames/2 < total
+}
+
+// Function that streams a chunk's data in frames once its message has been sent, waiting for the receiver to grant
+// more frames whenever the window is used up
+func streamChunkData(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, hash []byte, data []byte) error {
+	total := frameCount(int64(len(data)))
+	window := chunkWindowFrames
+	for i := 0; i < total; i++ {
+		if window == 0 {
+			var update WindowUpdate
+			setReadDeadline(time.Now().Add(chunkRequestTimeout))
+			err := readReply(rw.Reader, ChunkWindow, &update)
+			if err != nil {
+				return err
+			}
+			window += update.Frames
+		}
+		end := min((i+1)*chunkFrameSize, len(data))
+		err := writeFlushed(rw, ChunkData, ChunkFrame{Data: data[i*chunkFrameSize : end]})
+		if err != nil {
+			return err
+		}
+		window--
+		reportChunkProgress(ChunkTransferProgress{Hash: hash, Sending: true, Transferred: int64(end),
+			Size: int64(len(data))})
+	}
+	return nil
+}
+
+// Function that receives the data of a streamed chunk whose message announced its size, granting the sender more
+// frames as they arrive
+// Every frame but the last must be full, so a sender cannot announce one size and then send more or less data
+func receiveChunkData(rw *bufio.ReadWriter, setReadDeadline func(time.Time) error, hash []byte,
+	size int64) ([]byte, error) {
+	data := make([]byte, 0, size)
+	total := frameCount(size)
+	for received := 1; received <= total; received++ {
+		var frame ChunkFrame
+		setReadDeadline(time.Now().Add(chunkRequestTimeout))
+		err := readReply(rw.Reader, ChunkData, &frame)
+		if err != nil {
+			return nil, err
+		}
+		expected := min(chunkFrameSize, size-int64(len(data)))
+		if int64(len(frame.Data)) != expected {
+			return nil, fmt.Errorf("%w: chunk frame of %d bytes where %d were expected", ErrMalformedMessage,
+				len(frame.Data), expected)
+		}
+		data = append(data, frame.Data...)
+		reportChunkProgress(ChunkTransferProgress{Hash: hash, Transferred: int64(len(data)), Size: size})
+		if windowGrantDue(received, total) {
+			err = writeFlushed(rw, ChunkWindow, WindowUpdate{Frames: chunkWindowFrames / 2})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+// Function that passes the progress of a streamed chunk to the progress callback, if there is one
+func reportChunkProgress(progress ChunkTransferProgress) {
+	if ChunkProgress != nil {
+		ChunkProgress(progress)
+	}
+}
@@ -0,0 +1,388 @@
+package network
+
+import (
+	"blockchain-storage/core"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Protocol used to fetch chain snapshots and blocks from peers whose chains are longer than the local one
+const syncProtocol = "/blockchain-storage/sync/1.0.0"
+
+// First protocol version in which peers serve snapshots and blocks on the sync protocol
+const syncProtocolVersion = 3
+
+// Maximum time a peer has to answer a single sync request
+const syncTimeout = 30 * time.Second
+
+// Maximum number of blocks sent in reply to a single request
+const maxSyncBlocks = 500
+
+// Maximum number of headers sent in reply to a single request, which are far smaller than blocks
+const maxSyncHeaders = 2000
+
+// Number of blocks fetched from a single peer at a time once their headers have been checked
+const syncBodyBatch = 100
+
+// Maximum number of batches of blocks fetched at once, spread across the peers that hold them
+const maxParallelBodies = 4
+
+// Number of most recent blocks that are always synced in full, so a snapshot only covers the blocks before them
+const snapshotRecentBlocks = 100
+
+// SyncRequest - Message asking a peer for a snapshot of its chain or for a range of its blocks or their headers
+type SyncRequest struct {
+	Snapshot bool  `json:"snapshot,omitempty"` // Whether a snapshot is requested rather than blocks
+	Height   int64 `json:"height,omitempty"`   // Height the requested snapshot covers up to (0 leaves out the recent blocks)
+	Headers  bool  `json:"headers,omitempty"`  // Whether only the headers of the blocks are requested
+	From     int64 `json:"from,omitempty"`     // Height of the first block requested
+	Count    int   `json:"count,omitempty"`    // Number of blocks requested (capped at maxSyncBlocks, or maxSyncHeaders for headers)
+}
+
+// SyncResponse - Message sent back with the requested snapshot or blocks, all in the canonical wire encoding
+// The headers a snapshot covers are not sent with it, as there can be far more of them than fit in one message, so
+// they are requested separately in batches
+type SyncResponse struct {
+	Snapshot *core.ChainSnapshot `json:"snapshot,omitempty"` // Snapshot of the peer's chain (snapshot requests only)
+	Headers  [][]byte            `json:"headers,omitempty"`  // Headers of the blocks requested
+	Blocks   [][]byte            `json:"blocks,omitempty"`   // Blocks requested, oldest first
+	Error    string              `json:"error,omitempty"`    // Why the request could not be answered (empty if it was)
+}
+
+// Whether snapshots are used to catch up when the local chain is far behind a peer's
+var fastSync bool
+
+// Key the node signs the snapshots it serves with (nil until the node is started)
+var snapshotKey ed25519.PrivateKey
+
+// Whether the node is currently syncing, so that only one sync runs at a time
+var syncing atomic.Bool
+
+// Function that handles a sync request sent by another node
+func handleSync(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(syncTimeout))
+	var request SyncRequest
+	err := json.NewDecoder(stream).Decode(&request)
+	if err != nil {
+		logger.Warn("error encountered when reading sync request", "peer", stream.Conn().RemotePeer(), "error", err)
+		return
+	}
+	response, err := answerSync(request)
+	if err != nil {
+		response = &SyncResponse{Error: err.Error()}
+	}
+	err = json.NewEncoder(stream).Encode(response)
+	if err != nil {
+		logger.Error("error encountered when replying to sync request", "peer", stream.Conn().RemotePeer(), "error", err)
+	}
+}
+
+// Function that builds the reply to a sync request from the local chain
+func answerSync(request SyncRequest) (*SyncResponse, error) {
+	if Chain == nil {
+		return nil, errors.New("node has no chain")
+	}
+	response := &SyncResponse{}
+	if request.Snapshot {
+		if snapshotKey == nil {
+			return nil, errors.New("node does not serve snapshots")
+		}
+		height := request.Height
+		if height <= 0 {
+			height = int64(Chain.Length() - 1 - snapshotRecentBlocks)
+		}
+		snapshot, _, err := Chain.CreateSnapshot(height, snapshotKey, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		response.Snapshot = snapshot
+		return response, nil
+	}
+	limit := maxSyncBlocks
+	if request.Headers {
+		limit = maxSyncHeaders
+	}
+	count := min(max(request.Count, 0), limit)
+	err := Chain.Iterate(request.From, request.From+int64(count)-1, func(block *core.Block) error {
+		if request.Headers {
+			encoded, err := block.Header().MarshalBinary()
+			response.Headers = append(response.Headers, encoded)
+			return err
+		}
+		encoded, err := block.MarshalBinary()
+		response.Blocks = append(response.Blocks, encoded)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Function that sends a sync request to a peer and waits for the reply
+func requestSync(ctx context.Context, peerID peer.ID, request SyncRequest) (*SyncResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	stream, err := nodeHost.NewStream(ctx, peerID, syncProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(syncTimeout))
+
+	err = json.NewEncoder(stream).Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	var response SyncResponse
+	err = json.NewDecoder(stream).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("peer could not answer sync request: %s", response.Error)
+	}
+	return &response, nil
+}
+
+// Function that decodes blocks sent in the canonical wire encoding
+func decodeBlocks(encoded [][]byte) ([]*core.Block, error) {
+	blocks := make([]*core.Block, len(encoded))
+	for i, data := range encoded {
+		blocks[i] = &core.Block{}
+		err := blocks[i].UnmarshalBinary(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// Function that brings the local chain up to date with a peer's longer chain
+// If fast sync is enabled and the local chain is further behind than the recent blocks that are always synced in full,
+// the headers up to a snapshot of the peer's chain are fetched first. The remaining blocks are then synced headers
+// first: a batch of headers is fetched from the peer and checked, and only then are the blocks themselves fetched, in
+// parallel from every peer holding them. Each block is only added if it validly extends the local chain.
+func syncWithPeer(ctx context.Context, peerID peer.ID) {
+	if Chain == nil || nodeHost == nil || !syncing.CompareAndSwap(false, true) {
+		return
+	}
+	defer syncing.Store(false)
+	defer updateSyncState()
+	agreed, found := GetPeerProtocol(peerID)
+	if !found || agreed.Version < syncProtocolVersion {
+		return
+	}
+	// Orphan blocks that arrived while syncing may be waiting for the last synced block
+	defer func() {
+		if tip := Chain.LastBlock(); tip != nil {
+			connectOrphans(tip.Hash)
+		}
+	}()
+
+	peerChainLengthsMutex.Lock()
+	target := peerChainLengths[peerID]
+	peerChainLengthsMutex.Unlock()
+	if fastSync && localChainLength()+snapshotRecentBlocks < target {
+		err := syncSnapshot(ctx, peerID)
+		if err != nil {
+			logger.Warn("error encountered when syncing snapshot, falling back to full sync", "peer", peerID,
+				"error", err)
+		}
+	}
+
+	for localChainLength() < target {
+		headers, err := syncHeaders(ctx, peerID)
+		if err != nil {
+			logger.Warn("error encountered when syncing headers", "peer", peerID, "error", err)
+			return
+		}
+		// Light clients keep the checked headers themselves and never fetch the blocks
+		var added int
+		if Chain.HeadersOnly {
+			added, err = Chain.AddHeaders(headers, core.MiningDifficulty)
+		} else {
+			added = syncBodies(ctx, headers, syncCandidates(peerID, headers[len(headers)-1].Index+1))
+		}
+		if added < len(headers) {
+			logger.Warn("could not sync every block of the checked headers", "peer", peerID, "added", added,
+				"headers", len(headers), "error", err)
+			return
+		}
+		logger.Debug("synced blocks", "peer", peerID, "length", localChainLength(), "target", target)
+	}
+}
+
+// Function that fetches the next batch of headers after the local tip from a peer, returning those that validly
+// extend the local chain
+// A peer sending headers that do not extend the chain is penalised, although the valid headers before the first
+// invalid one are still returned
+func syncHeaders(ctx context.Context, peerID peer.ID) ([]*core.Block, error) {
+	response, err := requestSync(ctx, peerID, SyncRequest{Headers: true, From: int64(localChainLength()), Count: maxSyncHeaders})
+	if err != nil {
+		RecordReputationEvent(peerID, EventTimeout)
+		return nil, err
+	}
+	headers, err := decodeBlocks(response.Headers)
+	if err != nil {
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return nil, err
+	}
+	valid, err := Chain.ValidateHeaders(headers, core.MiningDifficulty)
+	if err != nil {
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		logger.Warn("peer sent headers that do not extend the chain", "peer", peerID, "valid", valid, "error", err)
+	}
+	if valid == 0 {
+		return nil, errors.New("peer sent no headers extending the chain")
+	}
+	return headers[:valid], nil
+}
+
+// Function that returns the peers a range of blocks can be fetched from: the given peer first, followed by every other
+// connected peer speaking the sync protocol whose chain is long enough
+func syncCandidates(first peer.ID, length int64) []peer.ID {
+	candidates := []peer.ID{first}
+	for _, peerInfo := range GetPeers() {
+		agreed, found := GetPeerProtocol(peerInfo.ID)
+		// Light clients only hold headers, so they cannot send blocks
+		if peerInfo.ID == first || !found || agreed.Version < syncProtocolVersion || isLightPeer(peerInfo.ID) {
+			continue
+		}
+		peerChainLengthsMutex.Lock()
+		peerLength := peerChainLengths[peerInfo.ID]
+		peerChainLengthsMutex.Unlock()
+		if int64(peerLength) >= length {
+			candidates = append(candidates, peerInfo.ID)
+		}
+	}
+	return candidates
+}
+
+// Function that fetches the blocks of checked headers in batches spread across the candidates, and adds them to the
+// local chain in order, returning how many were added
+// A batch that a peer fails to send, or sends blocks not matching the headers for, is retried with the next candidate
+func syncBodies(ctx context.Context, headers []*core.Block, candidates []peer.ID) int {
+	batches := make([][]*core.Block, (len(headers)+syncBodyBatch-1)/syncBodyBatch)
+	var wait sync.WaitGroup
+	slots := make(chan struct{}, maxParallelBodies)
+	for i := range batches {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wait.Done()
+			defer func() { <-slots }()
+			batchHeaders := headers[i*syncBodyBatch : min((i+1)*syncBodyBatch, len(headers))]
+			// Start each batch on a different peer, so that the batches are spread across the candidates
+			for attempt := range candidates {
+				peerID := candidates[(i+attempt)%len(candidates)]
+				blocks, err := fetchBodies(ctx, peerID, batchHeaders)
+				if err == nil {
+					batches[i] = blocks
+					return
+				}
+				logger.Warn("error encountered when fetching blocks", "peer", peerID, "from", batchHeaders[0].Index,
+					"error", err)
+			}
+		}(i)
+	}
+	wait.Wait()
+
+	added := 0
+	for _, blocks := range batches {
+		if blocks == nil {
+			return added
+		}
+		for _, block := range blocks {
+			err := Chain.AddValidBlock(block, core.MiningDifficulty)
+			if err != nil {
+				logger.Warn("rejected synced block", "block", block.Index, "error", err)
+				return added
+			}
+			added++
+		}
+	}
+	return added
+}
+
+// Function that fetches the blocks of a batch of checked headers from a peer, checking each block matches its header
+func fetchBodies(ctx context.Context, peerID peer.ID, headers []*core.Block) ([]*core.Block, error) {
+	response, err := requestSync(ctx, peerID, SyncRequest{From: headers[0].Index, Count: len(headers)})
+	if err != nil {
+		RecordReputationEvent(peerID, EventTimeout)
+		return nil, err
+	}
+	blocks, err := decodeBlocks(response.Blocks)
+	if err == nil && len(blocks) != len(headers) {
+		err = fmt.Errorf("expected %d blocks, got %d", len(headers), len(blocks))
+	}
+	for i := 0; err == nil && i < len(blocks); i++ {
+		if blocks[i].Index != headers[i].Index || !bytes.Equal(blocks[i].Hash, headers[i].Hash) {
+			err = fmt.Errorf("block %d does not match its header", headers[i].Index)
+		}
+	}
+	if err != nil {
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// Function that fetches a snapshot of a peer's chain and adds the blocks it covers to the local chain
+// The snapshot must be signed by the peer it was fetched from, so that a peer serving a bad one can be held to account
+func syncSnapshot(ctx context.Context, peerID peer.ID) error {
+	// A snapshot is only trusted up to the latest checkpoint, so it is requested up to the checkpoint
+	height := Chain.LatestCheckpoint()
+	if height < int64(localChainLength()) {
+		return errors.New("no checkpoint beyond the local chain to sync a snapshot up to")
+	}
+	response, err := requestSync(ctx, peerID, SyncRequest{Snapshot: true, Height: height})
+	if err != nil {
+		return err
+	}
+	if response.Snapshot == nil || response.Snapshot.Height != height {
+		return errors.New("peer sent no snapshot up to the checkpoint")
+	}
+	signer, err := crypto.UnmarshalEd25519PublicKey(response.Snapshot.SignerPublicKey)
+	if err != nil || !peerID.MatchesPublicKey(signer) {
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return errors.New("snapshot was not created by the peer that sent it")
+	}
+	// The blocks are fetched as the peer stores them, as the state the snapshot summarises is built from their records
+	var blocks []*core.Block
+	for int64(len(blocks)) <= height {
+		count := int(min(height+1-int64(len(blocks)), maxSyncBlocks))
+		page, err := requestSync(ctx, peerID, SyncRequest{From: int64(len(blocks)), Count: count})
+		if err != nil {
+			return err
+		}
+		decoded, err := decodeBlocks(page.Blocks)
+		if err == nil && (len(decoded) == 0 || len(decoded) > count) {
+			err = errors.New("peer sent the wrong number of blocks")
+		}
+		if err != nil {
+			RecordReputationEvent(peerID, EventInvalidBlock)
+			return err
+		}
+		blocks = append(blocks, decoded...)
+	}
+	_, err = Chain.ApplySnapshot(response.Snapshot, blocks, core.MiningDifficulty)
+	if err != nil {
+		RecordReputationEvent(peerID, EventInvalidBlock)
+		return err
+	}
+	logger.Info("synced chain snapshot", "peer", peerID, "height", response.Snapshot.Height)
+	return nil
+}
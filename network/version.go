@@ -0,0 +1,108 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/mod/semver"
+	"runtime/debug"
+	"sync"
+)
+
+// Version of the node's build, embedded at compile time with
+// -ldflags "-X blockchain-storage/network.Version=v1.2.3"
+// If it is not set, the module version recorded by go install is used instead (none for development builds)
+var Version string
+
+// Error returned when a peer runs a build older than the minimum version accepted for placing data on it
+var ErrPeerVersion = errors.New("peer runs a node version below the minimum accepted")
+
+// NodeVersion - Structure describing the build a node runs, which nodes attest to in handshakes and audit responses
+type NodeVersion struct {
+	Version string `json:"version,omitempty"` // Semantic version of the build, e.g. v1.2.3 (empty for development builds)
+	Commit  string `json:"commit,omitempty"`  // Revision the build was made from, suffixed with -dirty for modified trees
+}
+
+// Function that returns the version of the node's own build
+func BuildVersion() NodeVersion {
+	var version NodeVersion
+	info, ok := debug.ReadBuildInfo()
+	if ok {
+		if info.Main.Version != "(devel)" && semver.IsValid(info.Main.Version) {
+			version.Version = info.Main.Version
+		}
+		modified := false
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				version.Commit = setting.Value
+			case "vcs.modified":
+				modified = setting.Value == "true"
+			}
+		}
+		if modified && version.Commit != "" {
+			version.Commit += "-dirty"
+		}
+	}
+	if Version != "" {
+		version.Version = Version
+	}
+	return version
+}
+
+// Function that returns the version as it is shown to users
+func (version NodeVersion) String() string {
+	name := version.Version
+	if name == "" {
+		name = "devel"
+	}
+	if version.Commit != "" {
+		name += " (" + version.Commit + ")"
+	}
+	return name
+}
+
+// Minimum version peers must run for data to be placed on them (empty accepts every peer)
+var minPeerVersion string
+
+// Versions attested to by peers, which are kept up to date from their handshakes and chunk replies
+var peerVersions = make(map[peer.ID]NodeVersion)
+var peerVersionsMutex = &sync.Mutex{}
+
+// Function that sets the minimum version peers must run for data to be placed on them
+func SetMinPeerVersion(version string) error {
+	if version != "" && !semver.IsValid(version) {
+		return fmt.Errorf("invalid minimum peer version %q: expected a semantic version such as v1.2.3", version)
+	}
+	minPeerVersion = version
+	return nil
+}
+
+// Function that records the version a peer attested to
+func setPeerVersion(peerID peer.ID, version *NodeVersion) {
+	if version == nil {
+		return
+	}
+	peerVersionsMutex.Lock()
+	defer peerVersionsMutex.Unlock()
+	peerVersions[peerID] = *version
+}
+
+// Function that returns the version a peer last attested to, if it has attested to one
+func GetPeerVersion(peerID peer.ID) (NodeVersion, bool) {
+	peerVersionsMutex.Lock()
+	defer peerVersionsMutex.Unlock()
+	version, found := peerVersions[peerID]
+	return version, found
+}
+
+// Function that returns whether a peer's version is accepted for placing data on it
+// Once a minimum is set, peers that have not attested to a release version (such as older nodes and development
+// builds) are not accepted either
+func acceptablePeerVersion(peerID peer.ID) bool {
+	if minPeerVersion == "" {
+		return true
+	}
+	version, found := GetPeerVersion(peerID)
+	return found && semver.IsValid(version.Version) && semver.Compare(version.Version, minPeerVersion) >= 0
+}
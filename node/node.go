@@ -0,0 +1,308 @@
+// Package node embeds a storage node in a Go application, without going through the command line interface.
+// The networking layer keeps its state in package variables, so only one node can be started in a process at a time.
+package node
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/logging"
+	"blockchain-storage/network"
+	"blockchain-storage/storage"
+	"blockchain-storage/upload"
+	"blockchain-storage/verify"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Locations of the node's persistent data within its data directory
+const (
+	blocksFile      = "blocks.ndjson"
+	identityKeyFile = "identity.key"
+	chunkKeyFile    = "chunks.key"
+	chunkDir        = "chunks"
+	manifestDir     = "manifests"
+	pinsFile        = "pins.json"
+	accessListFile  = "access.json"
+	proofDir        = "proofs"
+	creditsFile     = "credits.ndjson"
+	uploadDir       = "uploads"
+)
+
+// File name recorded for uploads that are not given one
+const defaultFileName = "file"
+
+// Errors returned by an embedded node
+var (
+	ErrNoGenesis      = errors.New("blockchain is empty and no genesis time was given")
+	ErrNotStarted     = errors.New("node is not started, so chunks missing locally cannot be fetched")
+	ErrAlreadyStarted = errors.New("node has already been started")
+)
+
+// Options - Structure configuring an embedded node
+type Options struct {
+	DataDir string // Directory the node keeps its chain, chunks, manifests and identity key in
+
+	// Time of the genesis block created when the data directory holds no chain yet. Every node of a network must be
+	// given the same time, so that they all create the same genesis block.
+	GenesisTime time.Time
+	// Number of most recent blocks kept in full, with only the headers of older blocks being kept (0 keeps every block)
+	PruneDepth int
+	// Configuration of the node's networking, used by Start. The identity key is always the one in the data directory.
+	Network network.Config
+}
+
+// UploadOptions - Structure holding the options of a single upload
+type UploadOptions struct {
+	FileName    string               // Name of the file recorded in its manifest (defaults to "file")
+	Alias       string               // Name to upload the file under (defaults to the file name)
+	Name        string               // Name to claim for the file in the on-chain name registry (empty if none)
+	PrevVersion string               // Name or hex encoded Merkle root of the file's previous version (empty if none)
+	Tags        []string             // Labels to record in the file's manifest
+	Replicas    int                  // Number of peers that must acknowledge storing the file (0 does not place it)
+	Encrypt     bool                 // Whether to encrypt the file so only peers granted access can read it
+	Workers     int                  // Number of concurrent block mining workers (defaults to 1)
+	Retries     int                  // Number of retries if mining fails (defaults to 1)
+	Hash        verify.HashAlgorithm // Algorithm to hash the file with (SHA-256 if empty)
+	Agreement   time.Duration        // Time every replica holder promises to store the file for, recorded on the chain (0 records none)
+}
+
+// Node - Structure of a storage node embedded in an application
+type Node struct {
+	options Options
+	env     *upload.Environment
+	proofs  *storage.ProofStore   // Proofs of the chunks placed on the node, served to light clients
+	ledger  *storage.CreditLedger // Storage the node provides to and consumes from its peers
+	started atomic.Bool
+}
+
+// Function that opens the node whose data is kept in the options' data directory, creating it if it does not exist
+// The node can be used offline straight away, and joins the network once it is started
+func Open(options Options) (*Node, error) {
+	err := os.MkdirAll(options.DataDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	chainStore, err := storage.NewNDJSONChainStore(dataPath(options.DataDir, blocksFile))
+	if err != nil {
+		return nil, err
+	}
+	blockchain := core.NewBlockchain(chainStore)
+	blockchain.PruneDepth = options.PruneDepth
+	// A light client keeps only the headers of the chain
+	blockchain.HeadersOnly = options.Network.LightClient
+	if blockchain.Length() == 0 {
+		if options.GenesisTime.IsZero() {
+			return nil, ErrNoGenesis
+		}
+		err = blockchain.AddBlock(core.NewGenesisBlock(options.GenesisTime))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	identityKey, err := core.LoadIdentityKey(dataPath(options.DataDir, identityKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	chunkStore, err := storage.NewChunkStore(dataPath(options.DataDir, chunkDir))
+	if err != nil {
+		return nil, err
+	}
+	chunkStore.Key, err = storage.LoadChunkKey(dataPath(options.DataDir, chunkKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	manifestStore, err := storage.NewManifestStore(dataPath(options.DataDir, manifestDir))
+	if err != nil {
+		return nil, err
+	}
+	pinSet, err := storage.NewPinSet(dataPath(options.DataDir, pinsFile))
+	if err != nil {
+		return nil, err
+	}
+	accessList, err := storage.NewAccessList(dataPath(options.DataDir, accessListFile))
+	if err != nil {
+		return nil, err
+	}
+	proofStore, err := storage.NewProofStore(dataPath(options.DataDir, proofDir))
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := storage.NewCreditLedger(dataPath(options.DataDir, creditsFile))
+	if err != nil {
+		return nil, err
+	}
+	env := upload.NewEnvironment(blockchain, identityKey, chunkStore, manifestStore, pinSet)
+	env.Access = accessList
+	return &Node{options: options, env: env, proofs: proofStore, ledger: ledger}, nil
+}
+
+// Function that returns the path of a file in a node's data directory
+func dataPath(dataDir string, name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// Function that connects the node to the network and runs it until the context is cancelled
+// Once started, uploads are announced to the network and placed on peers, and downloads fetch missing chunks from it.
+// Uploads should not be running while the node starts or stops, as they would see its networking change under them.
+func (node *Node) Start(ctx context.Context) error {
+	if !node.started.CompareAndSwap(false, true) {
+		return ErrAlreadyStarted
+	}
+	defer node.started.Store(false)
+
+	env := node.env
+	network.Chain = env.Chain
+	network.Chunks = env.ChunkStore
+	network.Access = env.Access
+	network.Proofs = node.proofs
+	network.Ledger = node.ledger
+	env.NewTips = network.NewTips
+	env.Broadcast = network.BroadcastBlock
+	env.Provide = func(manifest *core.Manifest) {
+		go func() {
+			err := network.ProvideManifest(ctx, manifest)
+			if err != nil {
+				logging.Component(logging.Network).Error("error encountered when announcing upload", "error", err)
+			}
+		}()
+	}
+	env.Place = network.PlaceChunks
+	env.Audit = network.AuditReplica
+	env.FindHolders = network.FindReplicaHolders
+	env.Agree = network.RequestAgreement
+	peerID, err := network.PeerIDFromKey(env.IdentityKey)
+	if err != nil {
+		return err
+	}
+	env.PeerID = peerID.String()
+	defer func() {
+		env.NewTips, env.Broadcast, env.Provide = nil, nil, nil
+		env.Place, env.Audit, env.FindHolders, env.Agree = nil, nil, nil, nil
+		env.PeerID = ""
+	}()
+
+	config := node.options.Network
+	config.IdentityKey = env.IdentityKey
+	if config.ProvidedContent == nil {
+		config.ProvidedContent = node.providedContent
+	}
+	return network.StartNode(ctx, config)
+}
+
+// Function that lists the Merkle root of every file and the hash of every chunk held by the node
+func (node *Node) providedContent() [][]byte {
+	var hashes [][]byte
+	manifests, err := node.env.ManifestStore.ListManifests()
+	if err != nil {
+		logging.Component(logging.Storage).Error("error encountered when listing manifests", "error", err)
+	}
+	for _, manifest := range manifests {
+		hashes = append(hashes, manifest.MerkleRoot)
+	}
+	return append(hashes, node.env.ChunkStore.Hashes()...)
+}
+
+// Function that uploads the contents of a reader as a file, committing it to the blockchain
+// The contents are spooled to the data directory first, as a file is hashed and chunked in several passes
+func (node *Node) Upload(ctx context.Context, r io.Reader, options UploadOptions) (*upload.Result, error) {
+	err := os.MkdirAll(dataPath(node.options.DataDir, uploadDir), 0755)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(dataPath(node.options.DataDir, uploadDir), "upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := options.FileName
+	if fileName == "" {
+		fileName = defaultFileName
+	}
+	path := filepath.Join(dir, filepath.Base(fileName))
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return upload.Run(ctx, node.env, upload.Params{
+		FilePath:          path,
+		Alias:             options.Alias,
+		Workers:           max(options.Workers, 1),
+		Retries:           max(options.Retries, 1),
+		Replicas:          options.Replicas,
+		HashAlgorithm:     options.Hash,
+		AgreementDuration: options.Agreement,
+		Tags:              options.Tags,
+		Name:              options.Name,
+		PrevVersion:       options.PrevVersion,
+		Encrypt:           options.Encrypt,
+	})
+}
+
+// Function that writes the file with a Merkle root to a writer, after checking its chunks against the Merkle root
+// Chunks missing from the local chunk store are fetched from the network, which needs the node to be started
+func (node *Node) Download(ctx context.Context, merkleRoot []byte, w io.Writer) error {
+	env := node.env
+	manifest, err := env.ManifestStore.GetManifest(merkleRoot)
+	if err != nil {
+		return fmt.Errorf("node holds no manifest for file %x: %w", merkleRoot, err)
+	}
+	if tombstone, err := env.Chain.FindTombstone(merkleRoot); err == nil {
+		return fmt.Errorf("file %x was deleted by its uploader in block %d", merkleRoot, tombstone.Index)
+	}
+
+	for _, chunkHash := range manifest.ChunkHashes {
+		if env.ChunkStore.HasChunk(chunkHash) {
+			continue
+		}
+		if !node.started.Load() {
+			return ErrNotStarted
+		}
+		_, err := network.FetchChunks(ctx, merkleRoot, manifest.ChunkHashes, nil, nil)
+		if err != nil {
+			return err
+		}
+		break
+	}
+
+	key := manifest.Key
+	if key == nil && manifest.Encrypted && env.Keys != nil {
+		key, _ = env.Keys.FileKey(merkleRoot)
+	}
+	return storage.ReassembleFile(w, manifest, key, func(index int) ([]byte, error) {
+		return env.ChunkStore.GetChunk(manifest.ChunkHashes[index])
+	})
+}
+
+// Function that returns the node's copy of the blockchain
+func (node *Node) Chain() *core.Blockchain {
+	return node.env.Chain
+}
+
+// Function that returns the peers the node is connected to (none unless it is started)
+func (node *Node) Peers() []peer.AddrInfo {
+	if !node.started.Load() {
+		return nil
+	}
+	return network.GetPeers()
+}
+
+// Function that returns the peer ID the node is known by on the network
+func (node *Node) ID() (peer.ID, error) {
+	return network.PeerIDFromKey(node.env.IdentityKey)
+}
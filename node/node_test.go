@@ -0,0 +1,54 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that a file uploaded through an embedded node is committed to its chain and can be downloaded again
+func TestNode_UploadDownload(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(Options{DataDir: dir}); !errors.Is(err, ErrNoGenesis) {
+		t.Fatalf("FAIL: Expected ErrNoGenesis when opening an empty data directory, got %v", err)
+	}
+	node, err := Open(Options{DataDir: dir, GenesisTime: time.Unix(1700000000, 0)})
+	if err != nil {
+		t.Fatalf("Open() failed with error: %v", err)
+	}
+
+	contents := bytes.Repeat([]byte("embedded node "), 1000)
+	result, err := node.Upload(context.Background(), bytes.NewReader(contents), UploadOptions{FileName: "notes.txt",
+		Name: "notes"})
+	if err != nil {
+		t.Fatalf("Upload() failed with error: %v", err)
+	}
+	if node.Chain().Length() != 2 || result.BlockIndex != 1 {
+		t.Errorf("FAIL: Expected the upload to be committed in block 1, got block %d of %d", result.BlockIndex,
+			node.Chain().Length())
+	}
+	claim, err := node.Chain().ResolveName("notes", -1)
+	if err != nil || hex.EncodeToString(claim.MerkleRoot) != result.MerkleRoot {
+		t.Errorf("FAIL: Expected the claimed name to point at the upload, got %+v (%v)", claim, err)
+	}
+
+	var downloaded bytes.Buffer
+	if err := node.Download(context.Background(), claim.MerkleRoot, &downloaded); err != nil {
+		t.Fatalf("Download() failed with error: %v", err)
+	}
+	if !bytes.Equal(downloaded.Bytes(), contents) {
+		t.Errorf("FAIL: Downloaded file does not match the uploaded file")
+	}
+	if len(node.Peers()) != 0 {
+		t.Errorf("FAIL: Expected a node that was never started to have no peers")
+	}
+
+	// The chain is kept in the data directory, so reopening it does not need a genesis time
+	reopened, err := Open(Options{DataDir: dir})
+	if err != nil || reopened.Chain().Length() != 2 {
+		t.Errorf("FAIL: Expected the reopened node to hold both blocks, got error %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package resources
+
+import (
+	"blockchain-storage/logging"
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Logger of the resource monitoring component
+var logger = logging.Component(logging.Resources)
+
+// Interval between samples of the node's resource usage
+var SampleInterval = 5 * time.Second
+
+// Smallest share of its normal work the node is throttled down to, so that it keeps making progress
+const minThrottleFactor = 0.125
+
+// Share of a ceiling usage has to fall below before throttling is eased off, so the node does not oscillate around it
+const easeOffThreshold = 0.8
+
+// Limits - Structure holding the ceilings on the node's resource usage (a zero ceiling is not enforced)
+type Limits struct {
+	CPUPercent float64 // Share of the machine's total CPU time the node may use, in percent
+	Memory     uint64  // Resident memory the node may use, in bytes
+	DiskIO     uint64  // Bytes per second the node may read from and write to disk
+}
+
+// Function that returns whether any ceiling is set
+func (limits Limits) Enabled() bool {
+	return limits.CPUPercent > 0 || limits.Memory > 0 || limits.DiskIO > 0
+}
+
+// Usage - Structure describing the node's resource usage over the last sample interval
+type Usage struct {
+	CPUPercent float64 `json:"cpuPercent"` // Share of the machine's total CPU time used, in percent
+	Memory     uint64  `json:"memory"`     // Resident memory in bytes
+	DiskIO     uint64  `json:"diskIO"`     // Bytes per second read from and written to disk
+}
+
+// Function that returns how far usage is above the ceilings, as the largest ratio of usage to ceiling
+func (limits Limits) load(usage Usage) float64 {
+	load := 0.0
+	if limits.CPUPercent > 0 {
+		load = math.Max(load, usage.CPUPercent/limits.CPUPercent)
+	}
+	if limits.Memory > 0 {
+		load = math.Max(load, float64(usage.Memory)/float64(limits.Memory))
+	}
+	if limits.DiskIO > 0 {
+		load = math.Max(load, float64(usage.DiskIO)/float64(limits.DiskIO))
+	}
+	return load
+}
+
+// Monitor - Structure that samples the node's resource usage and decides how much the node should throttle itself
+// Work that can be spread across workers is scaled by the throttle factor, which is halved every sample that is over
+// a ceiling and raised again gradually once usage is comfortably below every ceiling. Deferrable work waits until
+// usage is back under the ceilings. A nil monitor never throttles.
+type Monitor struct {
+	limits Limits
+	sample func() (processUsage, error) // Reads the process's cumulative resource usage
+
+	mutex    sync.Mutex
+	usage    Usage
+	factor   float64
+	over     bool
+	previous processUsage
+	sampled  time.Time
+}
+
+// Function that creates a monitor enforcing the given ceilings on the node's own process
+func NewMonitor(limits Limits) *Monitor {
+	return &Monitor{limits: limits, sample: readProcessUsage, factor: 1}
+}
+
+// Function that samples the node's resource usage every SampleInterval until the context is cancelled
+func (monitor *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(SampleInterval)
+	defer ticker.Stop()
+	monitor.takeSample(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			monitor.takeSample(now)
+		}
+	}
+}
+
+// Function that reads the process's usage and updates the throttle factor from the change since the last sample
+func (monitor *Monitor) takeSample(now time.Time) {
+	current, err := monitor.sample()
+	if err != nil {
+		logger.Error("error encountered when reading resource usage", "error", err)
+		return
+	}
+	monitor.mutex.Lock()
+	previous, sampled := monitor.previous, monitor.sampled
+	monitor.previous, monitor.sampled = current, now
+	monitor.mutex.Unlock()
+	// Rates need two samples, so the first one only records the starting point
+	if sampled.IsZero() {
+		return
+	}
+	elapsed := now.Sub(sampled).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	usage := Usage{
+		CPUPercent: 100 * (current.cpuTime - previous.cpuTime).Seconds() / (elapsed * float64(runtime.NumCPU())),
+		Memory:     current.memory,
+		DiskIO:     uint64(float64(current.diskIO-previous.diskIO) / elapsed),
+	}
+	monitor.update(usage)
+}
+
+// Function that records the usage over the last interval and adjusts the throttle factor to it
+func (monitor *Monitor) update(usage Usage) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	load := monitor.limits.load(usage)
+	monitor.usage = usage
+	monitor.over = load > 1
+	switch {
+	case monitor.over:
+		monitor.factor = math.Max(monitor.factor/2, minThrottleFactor)
+	case load < easeOffThreshold:
+		monitor.factor = math.Min(monitor.factor+minThrottleFactor, 1)
+	}
+}
+
+// Function that returns the node's resource usage over the last sample interval
+func (monitor *Monitor) Usage() Usage {
+	if monitor == nil {
+		return Usage{}
+	}
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.usage
+}
+
+// Function that returns the share of its normal work the node should currently do, between 0.125 and 1
+func (monitor *Monitor) Factor() float64 {
+	if monitor == nil {
+		return 1
+	}
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.factor
+}
+
+// Function that scales a number of workers or concurrent operations by the throttle factor, keeping at least one
+func (monitor *Monitor) Scale(n int) int {
+	scaled := int(math.Ceil(float64(n) * monitor.Factor()))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// Function that waits until the node's usage is back under every ceiling or the context is cancelled
+func (monitor *Monitor) Wait(ctx context.Context) error {
+	for {
+		if monitor == nil || !monitor.overCeiling() {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(SampleInterval):
+		}
+	}
+}
+
+// Function that returns whether the last sample was over any ceiling
+func (monitor *Monitor) overCeiling() bool {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.over
+}
@@ -0,0 +1,98 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// Tests that the throttle factor drops while usage is over a ceiling and recovers gradually once it is well below
+func TestMonitor_Throttling(t *testing.T) {
+	monitor := NewMonitor(Limits{CPUPercent: 50, Memory: 1000})
+	if monitor.Scale(8) != 8 {
+		t.Errorf("FAIL: Expected no throttling before any sample, got %d of 8 workers", monitor.Scale(8))
+	}
+
+	monitor.update(Usage{CPUPercent: 80, Memory: 500})
+	if monitor.Factor() != 0.5 || monitor.Scale(8) != 4 {
+		t.Errorf("FAIL: Expected the factor to halve over the CPU ceiling, got %v", monitor.Factor())
+	}
+	for i := 0; i < 10; i++ {
+		monitor.update(Usage{CPUPercent: 10, Memory: 2000})
+	}
+	if monitor.Factor() != minThrottleFactor || monitor.Scale(3) != 1 {
+		t.Errorf("FAIL: Expected the factor to bottom out at %v, got %v", minThrottleFactor, monitor.Factor())
+	}
+
+	// Usage just under a ceiling holds the factor, and only usage well under every ceiling raises it
+	monitor.update(Usage{CPUPercent: 10, Memory: 900})
+	if monitor.Factor() != minThrottleFactor {
+		t.Errorf("FAIL: Expected the factor to hold just under the ceiling, got %v", monitor.Factor())
+	}
+	monitor.update(Usage{CPUPercent: 10, Memory: 100})
+	if monitor.Factor() != 2*minThrottleFactor {
+		t.Errorf("FAIL: Expected the factor to rise by %v, got %v", minThrottleFactor, monitor.Factor())
+	}
+	if monitor.Usage().Memory != 100 {
+		t.Errorf("FAIL: Expected the last usage to be reported, got %+v", monitor.Usage())
+	}
+
+	// A nil monitor never throttles
+	var disabled *Monitor
+	if disabled.Scale(4) != 4 || disabled.Wait(context.Background()) != nil {
+		t.Errorf("FAIL: A nil monitor throttled work")
+	}
+}
+
+// Tests that deferrable work waits until usage is back under the ceilings
+func TestMonitor_Wait(t *testing.T) {
+	defer func(interval time.Duration) { SampleInterval = interval }(SampleInterval)
+	SampleInterval = 10 * time.Millisecond
+
+	monitor := NewMonitor(Limits{DiskIO: 100})
+	monitor.update(Usage{DiskIO: 500})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := monitor.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FAIL: Expected Wait() to block while over the ceiling, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- monitor.Wait(context.Background()) }()
+	monitor.update(Usage{DiskIO: 50})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("FAIL: Wait() failed with error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("FAIL: Wait() did not return once usage was under the ceiling")
+	}
+}
+
+// Tests that usage rates are calculated from the change between samples
+func TestMonitor_Sample(t *testing.T) {
+	monitor := NewMonitor(Limits{CPUPercent: 100})
+	current := processUsage{cpuTime: time.Second, memory: 4096, diskIO: 1000}
+	monitor.sample = func() (processUsage, error) { return current, nil }
+
+	start := time.Now()
+	monitor.takeSample(start)
+	current = processUsage{cpuTime: time.Second + time.Duration(runtime.NumCPU())*time.Second, memory: 8192, diskIO: 3000}
+	monitor.takeSample(start.Add(2 * time.Second))
+
+	usage := monitor.Usage()
+	if usage.CPUPercent != 50 || usage.Memory != 8192 || usage.DiskIO != 1000 {
+		t.Errorf("FAIL: Expected 50%% CPU, 8192 bytes and 1000 bytes/s, got %+v", usage)
+	}
+
+	// The real process's usage can be read on Linux
+	if runtime.GOOS == "linux" {
+		usage, err := readProcessUsage()
+		if err != nil || usage.memory == 0 {
+			t.Errorf("FAIL: Failed to read the process's usage: %+v, %v", usage, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Clock ticks per second that CPU times in /proc are counted in (USER_HZ, which Linux fixes at 100)
+const clockTicksPerSecond = 100
+
+// Error returned when the process's resource usage cannot be read on this platform
+var ErrUsageUnavailable = errors.New("resource usage is not available on this platform")
+
+// Structure holding the cumulative resource usage of the process
+type processUsage struct {
+	cpuTime time.Duration // CPU time used in user and kernel mode
+	memory  uint64        // Resident memory in bytes
+	diskIO  uint64        // Bytes read from and written to disk
+}
+
+// Function that reads the cumulative resource usage of the node's own process from /proc
+// Resident memory falls back to the memory held by the Go runtime, and disk I/O is left at zero if /proc/self/io
+// cannot be read (it is restricted on some systems)
+func readProcessUsage() (processUsage, error) {
+	var usage processUsage
+	stat, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return usage, ErrUsageUnavailable
+	}
+	// The process name may contain spaces, so the fields are counted from the end of it, starting at the state (3rd)
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return usage, ErrUsageUnavailable
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return usage, ErrUsageUnavailable
+	}
+	userTicks, userErr := strconv.ParseUint(fields[11], 10, 64)
+	systemTicks, systemErr := strconv.ParseUint(fields[12], 10, 64)
+	if userErr != nil || systemErr != nil {
+		return usage, ErrUsageUnavailable
+	}
+	usage.cpuTime = time.Duration(userTicks+systemTicks) * time.Second / clockTicksPerSecond
+
+	usage.memory = residentMemory()
+	usage.diskIO = diskIO()
+	return usage, nil
+}
+
+// Function that returns the resident memory of the process in bytes
+func residentMemory() uint64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		fields := strings.Fields(string(statm))
+		if len(fields) >= 2 {
+			pages, err := strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys
+}
+
+// Function that returns the number of bytes the process has read from and written to disk
+func diskIO() uint64 {
+	file, err := os.Open("/proc/self/io")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	var total uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ":")
+		if !found || (name != "read_bytes" && name != "write_bytes") {
+			continue
+		}
+		count, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err == nil {
+			total += count
+		}
+	}
+	return total
+}
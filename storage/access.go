@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// AccessList - Structure recording which chunks belong to encrypted files that are only served with a capability
+// Chunks are keyed by their hex encoded hash and map to the hex encoded Merkle root of the file they belong to
+type AccessList struct {
+	Path       string            // Path of the JSON file the access list is saved to
+	Restricted map[string]string // Merkle roots of the files restricted chunks belong to, keyed by chunk hash
+	mutex      sync.Mutex
+}
+
+// Function that loads an access list from a file (an empty access list is returned if the file does not exist yet)
+func NewAccessList(path string) (*AccessList, error) {
+	accessList := &AccessList{Path: path, Restricted: make(map[string]string)}
+	jsonAccessList, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return accessList, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(jsonAccessList, &accessList.Restricted)
+	if err != nil {
+		return nil, err
+	}
+	return accessList, nil
+}
+
+// Function that restricts chunks to peers holding a capability for the file they belong to and saves the access list
+func (accessList *AccessList) Restrict(merkleRoot []byte, chunkHashes [][]byte) error {
+	accessList.mutex.Lock()
+	for _, chunkHash := range chunkHashes {
+		accessList.Restricted[hex.EncodeToString(chunkHash)] = hex.EncodeToString(merkleRoot)
+	}
+	accessList.mutex.Unlock()
+	return accessList.save()
+}
+
+// Function that lifts the restriction on every chunk of a file and saves the access list
+func (accessList *AccessList) Release(merkleRoot []byte) error {
+	hexRoot := hex.EncodeToString(merkleRoot)
+	accessList.mutex.Lock()
+	for hexHash, restrictedRoot := range accessList.Restricted {
+		if restrictedRoot == hexRoot {
+			delete(accessList.Restricted, hexHash)
+		}
+	}
+	accessList.mutex.Unlock()
+	return accessList.save()
+}
+
+// Function that returns the Merkle root of the file a restricted chunk belongs to, if the chunk is restricted
+// A nil access list restricts nothing
+func (accessList *AccessList) RestrictedTo(chunkHash []byte) ([]byte, bool) {
+	if accessList == nil {
+		return nil, false
+	}
+	accessList.mutex.Lock()
+	defer accessList.mutex.Unlock()
+	hexRoot, found := accessList.Restricted[hex.EncodeToString(chunkHash)]
+	if !found {
+		return nil, false
+	}
+	merkleRoot, err := hex.DecodeString(hexRoot)
+	return merkleRoot, err == nil
+}
+
+// Function that writes the access list to its file
+func (accessList *AccessList) save() error {
+	accessList.mutex.Lock()
+	jsonAccessList, err := json.MarshalIndent(accessList.Restricted, "", "  ")
+	accessList.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(accessList.Path, jsonAccessList, 0644)
+}
@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"bytes"
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema of the block index database
+const blockIndexSchema = `
+CREATE TABLE IF NOT EXISTS blocks (
+	height      INTEGER PRIMARY KEY,
+	hash        BLOB NOT NULL,
+	timestamp   INTEGER NOT NULL,
+	merkle_root BLOB NOT NULL,
+	uploader    BLOB,
+	file_name   TEXT,
+	alias       TEXT
+);
+CREATE INDEX IF NOT EXISTS blocks_by_uploader ON blocks (uploader, timestamp);
+CREATE INDEX IF NOT EXISTS blocks_by_timestamp ON blocks (timestamp);
+CREATE INDEX IF NOT EXISTS blocks_by_merkle_root ON blocks (merkle_root);
+CREATE TABLE IF NOT EXISTS block_tags (
+	height INTEGER NOT NULL,
+	tag    TEXT NOT NULL,
+	PRIMARY KEY (height, tag)
+);
+CREATE INDEX IF NOT EXISTS block_tags_by_tag ON block_tags (tag, height);
+`
+
+// BlockRecord - Metadata of a single block held in the block index
+type BlockRecord struct {
+	Height     int64     `json:"height"`     // Height of the block
+	Hash       []byte    `json:"hash"`       // Hash of the block
+	Timestamp  time.Time `json:"timestamp"`  // Timestamp when the block was created
+	MerkleRoot []byte    `json:"merkleRoot"` // Merkle root of the file committed to in the block
+	Uploader   []byte    `json:"uploader"`   // Public key of the node that uploaded the file
+	FileName   string    `json:"fileName"`   // Original name of the file (empty if its manifest is not held locally)
+	Alias      string    `json:"alias"`      // Name the file was uploaded under (empty if its manifest is not held locally)
+	Tags       []string  `json:"tags"`       // Tags the file was uploaded with (empty if its manifest is not held locally)
+}
+
+// BlockQuery - Filters for searching the block index, where zero values match every block
+type BlockQuery struct {
+	Uploader []byte    // Only blocks uploaded by this public key
+	From     time.Time // Only blocks created at or after this time
+	To       time.Time // Only blocks created before this time
+	FileName string    // Only blocks whose file name or alias contains this text
+	Tag      string    // Only blocks whose file was uploaded with this tag
+}
+
+// BlockIndex - SQLite database of block metadata supporting queries that the chain stores cannot answer,
+// such as every upload by a peer within a month
+// The index is derived entirely from the blockchain and the manifest store, so it can be deleted and rebuilt at any time
+type BlockIndex struct {
+	db *sql.DB
+}
+
+// Function that opens (creating if needed) the block index at the given path
+func NewBlockIndex(path string) (*BlockIndex, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(blockIndexSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BlockIndex{db: db}, nil
+}
+
+// Function that closes the block index database
+func (index *BlockIndex) Close() error {
+	return index.db.Close()
+}
+
+// Function that brings the block index up to date with the blockchain
+// Blocks that are new or were replaced by a fork are (re)indexed, and blocks beyond the end of the chain are removed.
+// File names are taken from the manifest store when the manifest of a block's file is held locally.
+func (index *BlockIndex) Sync(blockchain *core.Blockchain, manifestStore *ManifestStore) error {
+	indexed := make(map[int64][]byte)
+	rows, err := index.db.Query("SELECT height, hash FROM blocks")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var height int64
+		var hash []byte
+		err = rows.Scan(&height, &hash)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		indexed[height] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := index.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = blockchain.Iterate(0, -1, func(block *core.Block) error {
+		if bytes.Equal(indexed[block.Index], block.Hash) {
+			return nil
+		}
+		record := BlockRecord{
+			Height:     block.Index,
+			Hash:       block.Hash,
+			Timestamp:  block.Timestamp,
+			MerkleRoot: block.MerkelRoot,
+			Uploader:   block.UploaderPublicKey,
+		}
+		if manifestStore != nil {
+			manifest, err := manifestStore.GetManifest(block.MerkelRoot)
+			if err == nil {
+				record.FileName = manifest.FileName
+				record.Alias = manifest.Alias
+				record.Tags = manifest.Tags
+			}
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO blocks (height, hash, timestamp, merkle_root, uploader, file_name, alias)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, record.Height, record.Hash, record.Timestamp.UnixNano(), record.MerkleRoot,
+			record.Uploader, record.FileName, record.Alias)
+		if err != nil {
+			return err
+		}
+		// Tags of a block replaced by a fork belong to the old block's file
+		_, err = tx.Exec("DELETE FROM block_tags WHERE height = ?", record.Height)
+		if err != nil {
+			return err
+		}
+		for _, tag := range record.Tags {
+			_, err = tx.Exec("INSERT OR IGNORE INTO block_tags (height, tag) VALUES (?, ?)", record.Height, tag)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM blocks WHERE height >= ?", blockchain.Length())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM block_tags WHERE height >= ?", blockchain.Length())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Function that returns the metadata of every indexed block matching a query, in height order
+func (index *BlockIndex) Query(query BlockQuery) ([]BlockRecord, error) {
+	var conditions []string
+	var args []any
+	if len(query.Uploader) > 0 {
+		conditions = append(conditions, "uploader = ?")
+		args = append(args, query.Uploader)
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, query.From.UnixNano())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, query.To.UnixNano())
+	}
+	if query.FileName != "" {
+		conditions = append(conditions, "(instr(file_name, ?) > 0 OR instr(alias, ?) > 0)")
+		args = append(args, query.FileName, query.FileName)
+	}
+	if query.Tag != "" {
+		conditions = append(conditions, "height IN (SELECT height FROM block_tags WHERE tag = ?)")
+		args = append(args, query.Tag)
+	}
+
+	statement := "SELECT height, hash, timestamp, merkle_root, uploader, file_name, alias FROM blocks"
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	statement += " ORDER BY height"
+
+	rows, err := index.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []BlockRecord
+	for rows.Next() {
+		var record BlockRecord
+		var timestamp int64
+		err := rows.Scan(&record.Height, &record.Hash, &timestamp, &record.MerkleRoot, &record.Uploader,
+			&record.FileName, &record.Alias)
+		if err != nil {
+			return nil, err
+		}
+		record.Timestamp = time.Unix(0, timestamp)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range records {
+		records[i].Tags, err = index.tags(records[i].Height)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Function that returns the tags of the block at a height, in alphabetical order
+func (index *BlockIndex) tags(height int64) ([]string, error) {
+	rows, err := index.db.Query("SELECT tag FROM block_tags WHERE height = ? ORDER BY tag", height)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		err := rows.Scan(&tag)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Function that parses how far back a query should reach, such as 30d, 2w or 12h
+// Days and weeks are accepted on top of the units understood by time.ParseDuration
+func ParseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		count, found := strings.CutSuffix(value, suffix)
+		if !found {
+			continue
+		}
+		n, err := strconv.ParseUint(count, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q: expected a duration such as 30d, 2w or 12h", value)
+	}
+	return age, nil
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"encoding/binary"
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"io"
+	"time"
+)
+
+// Buckets of the chain store database
+var (
+	blocksByHeightBucket      = []byte("blocksByHeight")      // Height (big-endian) to JSON encoded block
+	heightsByHashBucket       = []byte("heightsByHash")       // Block hash to height
+	heightsByMerkleRootBucket = []byte("heightsByMerkleRoot") // Merkle root to height
+)
+
+// Maximum time to wait for another process (e.g. a running node) to release the database
+const chainStoreLockTimeout = 5 * time.Second
+
+// BoltChainStore - Chain store keeping blocks in a Bolt key-value database
+// The database is only opened for the duration of each operation, as Bolt locks the file for as long as it is open
+// and both a running node and the CLI commands need to access the blockchain
+type BoltChainStore struct {
+	Path string // Path of the database file
+}
+
+// Function that opens (creating if needed) the chain store database at the given path
+func NewBoltChainStore(path string) (*BoltChainStore, error) {
+	store := &BoltChainStore{Path: path}
+	err := store.update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{blocksByHeightBucket, heightsByHashBucket, heightsByMerkleRootBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Function that runs a read-write transaction on the database
+func (store *BoltChainStore) update(fn func(tx *bbolt.Tx) error) error {
+	db, err := bbolt.Open(store.Path, 0644, &bbolt.Options{Timeout: chainStoreLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+// Function that runs a read-only transaction on the database
+func (store *BoltChainStore) view(fn func(tx *bbolt.Tx) error) error {
+	db, err := bbolt.Open(store.Path, 0644, &bbolt.Options{Timeout: chainStoreLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// Function that encodes a height as a key, big-endian so that keys sort in height order
+func heightKey(height int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+// Function that stores a block along with its hash and Merkle root indices
+func (store *BoltChainStore) PutBlock(block *core.Block) error {
+	jsonBlock, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	return store.update(func(tx *bbolt.Tx) error {
+		blocks := tx.Bucket(blocksByHeightBucket)
+		var nextHeight int64
+		if lastKey, _ := blocks.Cursor().Last(); lastKey != nil {
+			nextHeight = int64(binary.BigEndian.Uint64(lastKey)) + 1
+		}
+		if block.Index > nextHeight {
+			return core.ErrBlockGap
+		}
+		key := heightKey(block.Index)
+
+		// Remove the indices of any block being replaced so that they do not point at the new block
+		replaced, err := blockAtKey(tx, key)
+		if err == nil {
+			tx.Bucket(heightsByHashBucket).Delete(replaced.Hash)
+			for _, root := range replaced.CommittedRoots() {
+				tx.Bucket(heightsByMerkleRootBucket).Delete(root)
+			}
+		}
+
+		err = blocks.Put(key, jsonBlock)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(heightsByHashBucket).Put(block.Hash, key)
+		if err != nil {
+			return err
+		}
+		for _, root := range block.CommittedRoots() {
+			err = tx.Bucket(heightsByMerkleRootBucket).Put(root, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Function that calls fn on every stored block in height order
+func (store *BoltChainStore) Iterate(fn func(block *core.Block) error) error {
+	return store.view(func(tx *bbolt.Tx) error {
+		return tx.Bucket(blocksByHeightBucket).ForEach(func(_, jsonBlock []byte) error {
+			var block core.Block
+			err := json.Unmarshal(jsonBlock, &block)
+			if err != nil {
+				return err
+			}
+			return fn(&block)
+		})
+	})
+}
+
+// Function that returns the block at the greatest height
+func (store *BoltChainStore) Head() (*core.Block, error) {
+	var block *core.Block
+	err := store.view(func(tx *bbolt.Tx) error {
+		key, _ := tx.Bucket(blocksByHeightBucket).Cursor().Last()
+		if key == nil {
+			return core.ErrBlockNotFound
+		}
+		var err error
+		block, err = blockAtKey(tx, key)
+		return err
+	})
+	return block, err
+}
+
+// Function that returns the block at a height
+func (store *BoltChainStore) GetByHeight(height int64) (*core.Block, error) {
+	var block *core.Block
+	err := store.view(func(tx *bbolt.Tx) error {
+		var err error
+		block, err = blockAtKey(tx, heightKey(height))
+		return err
+	})
+	return block, err
+}
+
+// Function that returns the block with a hash
+func (store *BoltChainStore) GetByHash(hash []byte) (*core.Block, error) {
+	return store.blockByIndex(heightsByHashBucket, hash)
+}
+
+// Function that returns the block committing to a Merkle root
+func (store *BoltChainStore) GetByMerkleRoot(merkleRoot []byte) (*core.Block, error) {
+	return store.blockByIndex(heightsByMerkleRootBucket, merkleRoot)
+}
+
+// Function that looks up the height of a block in an index bucket and returns the block at that height
+func (store *BoltChainStore) blockByIndex(bucket []byte, key []byte) (*core.Block, error) {
+	var block *core.Block
+	err := store.view(func(tx *bbolt.Tx) error {
+		height := tx.Bucket(bucket).Get(key)
+		if height == nil {
+			return core.ErrBlockNotFound
+		}
+		var err error
+		block, err = blockAtKey(tx, height)
+		return err
+	})
+	return block, err
+}
+
+// Function that writes a consistent copy of the database and returns the block at the greatest height in the copy
+// The copy is written in a single read transaction, so writes by a running node wait for it to finish
+func (store *BoltChainStore) Backup(w io.Writer) (*core.Block, error) {
+	var head *core.Block
+	err := store.view(func(tx *bbolt.Tx) error {
+		key, _ := tx.Bucket(blocksByHeightBucket).Cursor().Last()
+		if key == nil {
+			return core.ErrBlockNotFound
+		}
+		var err error
+		head, err = blockAtKey(tx, key)
+		if err != nil {
+			return err
+		}
+		_, err = tx.WriteTo(w)
+		return err
+	})
+	return head, err
+}
+
+// Function that decodes the block stored under a height key
+func blockAtKey(tx *bbolt.Tx, key []byte) (*core.Block, error) {
+	jsonBlock := tx.Bucket(blocksByHeightBucket).Get(key)
+	if jsonBlock == nil {
+		return nil, core.ErrBlockNotFound
+	}
+	var block core.Block
+	err := json.Unmarshal(jsonBlock, &block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"blockchain-storage/verify"
+	"container/list"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extension used for every chunk file in the chunk cache directory
+const cachedChunkExtension = ".cached"
+
+// CacheStats - Structure describing how full a chunk cache is and how well it has been serving chunks
+type CacheStats struct {
+	MemoryChunks int    `json:"memoryChunks"` // Number of chunks held in memory
+	MemoryBytes  int64  `json:"memoryBytes"`  // Size of the chunks held in memory
+	DiskChunks   int    `json:"diskChunks"`   // Number of chunks held on disk
+	DiskBytes    int64  `json:"diskBytes"`    // Size of the chunks held on disk
+	Hits         uint64 `json:"hits"`         // Number of reads served from the cache
+	Misses       uint64 `json:"misses"`       // Number of reads of chunks the cache did not hold
+	Evictions    uint64 `json:"evictions"`    // Number of chunks dropped from the cache to make room for others
+}
+
+// Structure holding a single cached chunk, which is in exactly one of the cache's tiers
+type cacheEntry struct {
+	multihash verify.Multihash // Hash of the chunk along with the algorithm it was computed with
+	data      []byte           // Contents of the chunk (nil while it is on disk)
+	size      int64            // Size of the chunk
+	element   *list.Element    // Position of the entry in the list of its tier
+}
+
+// ChunkCache - Structure holding recently served chunks of files the node has not pinned, least recently used first to
+// go once it is full
+// Chunks are kept in memory and, if the cache has a directory, moved to disk when they are pushed out of memory. The
+// cache is separate from the chunk store, so cached chunks never take up the space offered for pinned files.
+type ChunkCache struct {
+	Dir         string // Directory holding the disk tier (empty if the cache only uses memory)
+	MemoryLimit int64  // Bytes of chunks held in memory
+	DiskLimit   int64  // Bytes of chunks held on disk
+
+	entries     map[string]*cacheEntry // Entries of both tiers keyed by hex encoded chunk hash
+	memory      *list.List             // Entries held in memory, most recently used first
+	disk        *list.List             // Entries held on disk, most recently used first
+	memoryBytes int64
+	diskBytes   int64
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	mutex       sync.Mutex
+}
+
+// Function that creates a chunk cache holding up to the given number of bytes in memory and, if a directory is given,
+// on disk as well
+// Chunks left in the directory by a previous run are taken back, most recently written first.
+func NewChunkCache(memoryLimit int64, dir string, diskLimit int64) (*ChunkCache, error) {
+	cache := &ChunkCache{
+		Dir:         dir,
+		MemoryLimit: memoryLimit,
+		DiskLimit:   diskLimit,
+		entries:     make(map[string]*cacheEntry),
+		memory:      list.New(),
+		disk:        list.New(),
+	}
+	if dir == "" {
+		return cache, nil
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type cachedFile struct {
+		entry   *cacheEntry
+		modTime int64
+	}
+	var cached []cachedFile
+	for _, file := range files {
+		name, found := strings.CutSuffix(file.Name(), cachedChunkExtension)
+		info, err := file.Info()
+		if !found || err != nil {
+			continue
+		}
+		multihash, err := hex.DecodeString(name)
+		if err == nil {
+			_, _, err = verify.Multihash(multihash).Decode()
+		}
+		if err != nil {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		entry := &cacheEntry{multihash: multihash, size: info.Size()}
+		cached = append(cached, cachedFile{entry: entry, modTime: info.ModTime().UnixNano()})
+	}
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].modTime > cached[j].modTime
+	})
+	for _, file := range cached {
+		file.entry.element = cache.disk.PushBack(file.entry)
+		cache.entries[hex.EncodeToString(file.entry.multihash.Digest())] = file.entry
+		cache.diskBytes += file.entry.size
+	}
+	cache.evictDisk()
+	return cache, nil
+}
+
+// Function that returns the path of the file holding a chunk in the disk tier
+func (cache *ChunkCache) chunkPath(multihash verify.Multihash) string {
+	return filepath.Join(cache.Dir, hex.EncodeToString(multihash)+cachedChunkExtension)
+}
+
+// Function that adds a chunk that has been checked against its hash to the cache, making it the most recently used
+// A chunk larger than every tier of the cache is not cached at all.
+func (cache *ChunkCache) Put(algorithm verify.HashAlgorithm, hash []byte, chunk []byte) error {
+	multihash, err := verify.NewMultihash(algorithm, hash)
+	if err != nil {
+		return err
+	}
+	size := int64(len(chunk))
+	if size > cache.MemoryLimit && (cache.Dir == "" || size > cache.DiskLimit) {
+		return nil
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if entry, found := cache.entries[hex.EncodeToString(hash)]; found {
+		if entry.data != nil {
+			cache.memory.MoveToFront(entry.element)
+			return nil
+		}
+		cache.removeFromDisk(entry)
+	}
+	cache.addToMemory(&cacheEntry{multihash: multihash, data: chunk, size: size})
+	return nil
+}
+
+// Function that reads a chunk from the cache along with the multihash it is addressed by, making it the most recently
+// used
+// A chunk read from disk is checked against its hash, dropping it if it no longer matches, and moved back to memory.
+func (cache *ChunkCache) Get(hash []byte) ([]byte, verify.Multihash, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, found := cache.entries[hex.EncodeToString(hash)]
+	if !found {
+		cache.misses++
+		return nil, nil, false
+	}
+	if entry.data != nil {
+		cache.memory.MoveToFront(entry.element)
+		cache.hits++
+		return entry.data, entry.multihash, true
+	}
+
+	data, err := os.ReadFile(cache.chunkPath(entry.multihash))
+	cache.removeFromDisk(entry)
+	if err != nil || !entry.multihash.Verify(data) {
+		logger.Warn("dropped cached chunk that could not be read", "hash", hex.EncodeToString(hash), "error", err)
+		delete(cache.entries, hex.EncodeToString(hash))
+		cache.misses++
+		return nil, nil, false
+	}
+	entry.data = data
+	entry.size = int64(len(data))
+	cache.addToMemory(entry)
+	cache.hits++
+	return data, entry.multihash, true
+}
+
+// Function that checks whether a chunk is held in either tier of the cache, without counting as a use of it
+func (cache *ChunkCache) Has(hash []byte) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	_, found := cache.entries[hex.EncodeToString(hash)]
+	return found
+}
+
+// Function that returns the largest number of bytes of chunks the cache holds across both of its tiers
+func (cache *ChunkCache) Capacity() int64 {
+	if cache.Dir == "" {
+		return cache.MemoryLimit
+	}
+	return cache.MemoryLimit + cache.DiskLimit
+}
+
+// Function that returns how full the cache is and how well it has been serving chunks
+func (cache *ChunkCache) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return CacheStats{
+		MemoryChunks: cache.memory.Len(),
+		MemoryBytes:  cache.memoryBytes,
+		DiskChunks:   cache.disk.Len(),
+		DiskBytes:    cache.diskBytes,
+		Hits:         cache.hits,
+		Misses:       cache.misses,
+		Evictions:    cache.evictions,
+	}
+}
+
+// Function that adds an entry to the front of the memory tier, pushing the least recently used entries out of memory
+// once it is full (the caller must hold the cache's mutex)
+// Entries pushed out of memory move to the disk tier if the cache has one, and are dropped otherwise.
+func (cache *ChunkCache) addToMemory(entry *cacheEntry) {
+	entry.element = cache.memory.PushFront(entry)
+	cache.entries[hex.EncodeToString(entry.multihash.Digest())] = entry
+	cache.memoryBytes += entry.size
+
+	for cache.memoryBytes > cache.MemoryLimit {
+		oldest := cache.memory.Remove(cache.memory.Back()).(*cacheEntry)
+		cache.memoryBytes -= oldest.size
+		data := oldest.data
+		oldest.data = nil
+		if cache.Dir == "" || oldest.size > cache.DiskLimit {
+			cache.drop(oldest)
+			continue
+		}
+		err := os.WriteFile(cache.chunkPath(oldest.multihash), data, 0644)
+		if err != nil {
+			logger.Warn("error encountered when moving cached chunk to disk", "error", err)
+			cache.drop(oldest)
+			continue
+		}
+		oldest.element = cache.disk.PushFront(oldest)
+		cache.diskBytes += oldest.size
+		cache.evictDisk()
+	}
+}
+
+// Function that deletes the least recently used entries of the disk tier until it fits its limit (the caller must
+// hold the cache's mutex)
+func (cache *ChunkCache) evictDisk() {
+	for cache.diskBytes > cache.DiskLimit {
+		oldest := cache.disk.Back().Value.(*cacheEntry)
+		cache.removeFromDisk(oldest)
+		cache.drop(oldest)
+	}
+}
+
+// Function that takes an entry out of the disk tier and deletes its file (the caller must hold the cache's mutex)
+func (cache *ChunkCache) removeFromDisk(entry *cacheEntry) {
+	cache.disk.Remove(entry.element)
+	cache.diskBytes -= entry.size
+	os.Remove(cache.chunkPath(entry.multihash))
+}
+
+// Function that forgets an entry pushed out of the cache (the caller must hold the cache's mutex)
+func (cache *ChunkCache) drop(entry *cacheEntry) {
+	delete(cache.entries, hex.EncodeToString(entry.multihash.Digest()))
+	cache.evictions++
+}
@@ -0,0 +1,343 @@
+package storage
+
+import (
+	"blockchain-storage/events"
+	"blockchain-storage/logging"
+	"blockchain-storage/verify"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Logger of the storage component
+var logger = logging.Component(logging.Storage)
+
+// Extension used for every chunk file in the chunk store directory
+const chunkExtension = ".chunk"
+
+// Name of the index file kept in the chunk store directory
+const indexFileName = "index.json"
+
+// IndexEntry - Structure holding the metadata of a single stored chunk in the index
+type IndexEntry struct {
+	Hash           string          `json:"hash"`           // Hex encoded hash of the original chunk
+	StoredSize     int64           `json:"storedSize"`     // Size of the chunk file on disk (including its header)
+	OriginalLength uint64          `json:"originalLength"` // Length of the original chunk in bytes
+	Compression    CompressionType `json:"compression"`    // Compression algorithm applied to the stored data
+	Encryption     EncryptionType  `json:"encryption"`     // Encryption algorithm applied to the stored data
+}
+
+// ChunkStore - Structure for storing chunks on disk, where every chunk is kept in its own file prefixed by a header
+type ChunkStore struct {
+	Dir   string                 // Directory holding the chunk files and the index
+	Index map[string]*IndexEntry // Index between hex encoded chunk hashes and their metadata
+	Key   []byte                 // Node-local key that new chunks are encrypted with (nil stores them unencrypted)
+	mutex sync.Mutex
+
+	// Whether new chunks are compressed with Zstandard when that makes them smaller
+	// Chunks are always addressed by the hash of their uncompressed contents, so compression never changes a hash
+	Compress bool
+
+	// Bus that a ChunkStored event is published on for every stored chunk (nil publishes nothing)
+	Events *events.Bus
+}
+
+// Function that opens (or creates) a chunk store in the given directory and loads its index
+func NewChunkStore(dir string) (*ChunkStore, error) {
+	// Permissions 0755 means the owner can read, write and traverse, but others can only read and traverse
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkStore := &ChunkStore{
+		Dir:   dir,
+		Index: make(map[string]*IndexEntry),
+	}
+
+	// Load the index if it exists. A missing index is not an error as the store may be new
+	jsonIndex, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chunkStore, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(jsonIndex, &chunkStore.Index)
+	if err != nil {
+		return nil, err
+	}
+	return chunkStore, nil
+}
+
+// Function that returns the path of the file holding a chunk given its hex encoded hash
+func (chunkStore *ChunkStore) chunkPath(hexHash string) string {
+	return filepath.Join(chunkStore.Dir, hexHash+chunkExtension)
+}
+
+// Function that stores a chunk in the chunk store, addressed by its SHA-256 hash, and returns its hash
+func (chunkStore *ChunkStore) PutChunk(chunk []byte) ([]byte, error) {
+	return chunkStore.PutChunkWith(verify.SHA256, chunk)
+}
+
+// Function that stores a chunk in the chunk store, addressed by its hash under the given algorithm, and returns its hash
+func (chunkStore *ChunkStore) PutChunkWith(algorithm verify.HashAlgorithm, chunk []byte) ([]byte, error) {
+	hash, err := algorithm.Sum(chunk)
+	if err != nil {
+		return nil, err
+	}
+	header := &ChunkHeader{
+		Version:        HeaderVersion,
+		Compression:    CompressionNone,
+		Encryption:     EncryptionNone,
+		HashAlgorithm:  algorithm.Canonical(),
+		Hash:           hash,
+		OriginalLength: uint64(len(chunk)),
+	}
+	data := chunk
+	if chunkStore.Compress {
+		var compressed bool
+		data, compressed = CompressChunk(chunk)
+		if compressed {
+			header.Compression = CompressionZstd
+		}
+	}
+	if chunkStore.Key != nil {
+		data, err = EncryptChunk(chunkStore.Key, data)
+		if err != nil {
+			return nil, err
+		}
+		header.Encryption = EncryptionAES256GCM
+	}
+	encodedHeader, err := header.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the header immediately followed by the chunk data
+	contents := append(encodedHeader, data...)
+	hexHash := hex.EncodeToString(hash)
+	err = os.WriteFile(chunkStore.chunkPath(hexHash), contents, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkStore.mutex.Lock()
+	chunkStore.Index[hexHash] = indexEntryFromHeader(header, int64(len(contents)))
+	chunkStore.mutex.Unlock()
+
+	err = chunkStore.WriteIndex()
+	if err != nil {
+		return nil, err
+	}
+	chunkStore.Events.Publish(events.Event{Type: events.ChunkStored, Hash: hash})
+	return hash, nil
+}
+
+// Function that retrieves a chunk from the chunk store, validating its header and contents
+func (chunkStore *ChunkStore) GetChunk(hash []byte) ([]byte, error) {
+	chunk, _, err := chunkStore.readChunk(hash)
+	return chunk, err
+}
+
+// Function that retrieves a chunk from the chunk store along with the multihash it is addressed by
+func (chunkStore *ChunkStore) GetAddressedChunk(hash []byte) ([]byte, verify.Multihash, error) {
+	chunk, header, err := chunkStore.readChunk(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	multihash, err := verify.NewMultihash(header.HashAlgorithm, header.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chunk, multihash, nil
+}
+
+// Function that reads a chunk and its header from the chunk store, validating both
+func (chunkStore *ChunkStore) readChunk(hash []byte) ([]byte, *ChunkHeader, error) {
+	contents, err := os.ReadFile(chunkStore.chunkPath(hex.EncodeToString(hash)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header, err := DecodeHeader(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The header must describe the chunk that was asked for
+	if !bytes.Equal(header.Hash, hash) {
+		return nil, nil, errors.New("chunk header hash does not match requested hash")
+	}
+
+	chunk := contents[header.Size():]
+	if header.Encryption == EncryptionAES256GCM {
+		if chunkStore.Key == nil {
+			return nil, nil, ErrChunkKeyMissing
+		}
+		chunk, err = DecryptChunk(chunkStore.Key, chunk)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if header.Encryption != EncryptionNone {
+		return nil, nil, fmt.Errorf("unsupported chunk encryption %d", header.Encryption)
+	}
+	if header.Compression == CompressionZstd {
+		chunk, err = DecompressChunk(chunk)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if header.Compression != CompressionNone {
+		return nil, nil, fmt.Errorf("unsupported chunk compression %d", header.Compression)
+	}
+
+	// Check that the data itself has not been truncated or corrupted
+	if uint64(len(chunk)) != header.OriginalLength {
+		return nil, nil, errors.New("chunk length does not match header")
+	}
+	chunkHash, err := header.HashAlgorithm.Sum(chunk)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(chunkHash, header.Hash) {
+		return nil, nil, errors.New("chunk data does not match header hash")
+	}
+	return chunk, header, nil
+}
+
+// Function that checks whether a chunk is held in the chunk store
+func (chunkStore *ChunkStore) HasChunk(hash []byte) bool {
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+	_, found := chunkStore.Index[hex.EncodeToString(hash)]
+	return found
+}
+
+// Function that lists the hashes of every chunk in the chunk store
+func (chunkStore *ChunkStore) Hashes() [][]byte {
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+
+	var hashes [][]byte
+	for hexHash := range chunkStore.Index {
+		hash, err := hex.DecodeString(hexHash)
+		if err != nil {
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// Function that returns the number of bytes taken up on disk by every chunk in the chunk store
+func (chunkStore *ChunkStore) UsedSpace() int64 {
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+
+	var used int64
+	for _, entry := range chunkStore.Index {
+		used += entry.StoredSize
+	}
+	return used
+}
+
+// Function that removes a chunk from the chunk store
+func (chunkStore *ChunkStore) DeleteChunk(hash []byte) error {
+	hexHash := hex.EncodeToString(hash)
+	err := os.Remove(chunkStore.chunkPath(hexHash))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	chunkStore.mutex.Lock()
+	delete(chunkStore.Index, hexHash)
+	chunkStore.mutex.Unlock()
+
+	return chunkStore.WriteIndex()
+}
+
+// Function that writes the index to disk
+func (chunkStore *ChunkStore) WriteIndex() error {
+	chunkStore.mutex.Lock()
+	jsonIndex, err := json.MarshalIndent(chunkStore.Index, "", "  ")
+	chunkStore.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(chunkStore.Dir, indexFileName), jsonIndex, 0644)
+}
+
+// Function that reconstructs the index purely from the headers of the chunk files in the store directory
+// Chunk files with missing or corrupt headers are skipped and returned so that the caller can report them
+func (chunkStore *ChunkStore) RebuildIndex() ([]string, error) {
+	entries, err := os.ReadDir(chunkStore.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]*IndexEntry)
+	var skipped []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), chunkExtension) {
+			continue
+		}
+
+		header, size, err := readHeader(filepath.Join(chunkStore.Dir, entry.Name()))
+		if err != nil {
+			logger.Warn("skipped chunk file with an unreadable header", "file", entry.Name(), "error", err)
+			skipped = append(skipped, entry.Name())
+			continue
+		}
+		index[hex.EncodeToString(header.Hash)] = indexEntryFromHeader(header, size)
+	}
+
+	chunkStore.mutex.Lock()
+	chunkStore.Index = index
+	chunkStore.mutex.Unlock()
+	logger.Info("rebuilt chunk index", "chunks", len(index), "skipped", len(skipped))
+
+	return skipped, chunkStore.WriteIndex()
+}
+
+// Function that reads only the header of a chunk file, returning it along with the total file size
+func readHeader(path string) (*ChunkHeader, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Version 1 headers are a byte shorter, so a short read is only an error if it is shorter than any header
+	buffer := make([]byte, HeaderSize)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && n >= headerSizeV1) {
+		return nil, 0, err
+	}
+	header, err := DecodeHeader(buffer[:n])
+	if err != nil {
+		return nil, 0, err
+	}
+	return header, info.Size(), nil
+}
+
+// Function that creates an index entry from a chunk header
+func indexEntryFromHeader(header *ChunkHeader, storedSize int64) *IndexEntry {
+	return &IndexEntry{
+		Hash:           hex.EncodeToString(header.Hash),
+		StoredSize:     storedSize,
+		OriginalLength: header.OriginalLength,
+		Compression:    header.Compression,
+		Encryption:     header.Encryption,
+	}
+}
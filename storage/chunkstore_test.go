@@ -0,0 +1,859 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/verify"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that a chunk header survives an encode/decode round trip and that corruption is detected
+func TestChunkHeader_EncodeDecode(t *testing.T) {
+	hash := sha256.Sum256([]byte("chunk"))
+	header := &ChunkHeader{Version: HeaderVersion, Hash: hash[:], OriginalLength: 5}
+
+	encoded, err := header.Encode()
+	if err != nil {
+		t.Fatalf("Encode() failed with error: %v", err)
+	}
+	decoded, err := DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("DecodeHeader() failed with error: %v", err)
+	}
+	if !bytes.Equal(decoded.Hash, header.Hash) || decoded.OriginalLength != header.OriginalLength {
+		t.Errorf("FAIL: Decoded header does not match the original header")
+	}
+
+	// Flip a bit in the original length field, which should invalidate the CRC
+	encoded[41] ^= 1
+	if _, err := DecodeHeader(encoded); err == nil {
+		t.Errorf("FAIL: DecodeHeader() accepted a header with a corrupted field")
+	}
+}
+
+// Tests storing and retrieving a chunk, including detection of corrupted chunk data
+func TestChunkStore_PutGet(t *testing.T) {
+	chunkStore, err := NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore() failed with error: %v", err)
+	}
+
+	chunk := []byte("some chunk data")
+	hash, err := chunkStore.PutChunk(chunk)
+	if err != nil {
+		t.Fatalf("PutChunk() failed with error: %v", err)
+	}
+	if !chunkStore.HasChunk(hash) {
+		t.Errorf("FAIL: HasChunk() returned false for a stored chunk")
+	}
+
+	retrieved, err := chunkStore.GetChunk(hash)
+	if err != nil || !bytes.Equal(retrieved, chunk) {
+		t.Errorf("FAIL: GetChunk() did not return the stored chunk")
+	}
+
+	// Corrupt the chunk data on disk and check it is rejected
+	path := chunkStore.chunkPath(hex.EncodeToString(hash))
+	contents, _ := os.ReadFile(path)
+	contents[len(contents)-1] ^= 1
+	os.WriteFile(path, contents, 0644)
+	if _, err := chunkStore.GetChunk(hash); err == nil {
+		t.Errorf("FAIL: GetChunk() returned a chunk with corrupted data")
+	}
+}
+
+// Tests that chunks are addressed by the hash algorithm of their file and that version 1 headers can still be read
+func TestChunkStore_HashAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	chunkStore, _ := NewChunkStore(dir)
+	chunk := []byte("some chunk data")
+	hash, err := chunkStore.PutChunkWith(verify.BLAKE3, chunk)
+	if err != nil {
+		t.Fatalf("PutChunkWith() failed with error: %v", err)
+	}
+	blake3Hash, _ := verify.BLAKE3.Sum(chunk)
+	if !bytes.Equal(hash, blake3Hash) {
+		t.Fatalf("FAIL: BLAKE3 chunk is not addressed by its BLAKE3 hash")
+	}
+	if retrieved, err := chunkStore.GetChunk(hash); err != nil || !bytes.Equal(retrieved, chunk) {
+		t.Errorf("FAIL: GetChunk() did not return the BLAKE3 chunk (error %v)", err)
+	}
+	if _, err := chunkStore.PutChunkWith("unknown", chunk); err != verify.ErrUnknownHash {
+		t.Errorf("FAIL: Expected ErrUnknownHash for an unregistered algorithm, got %v", err)
+	}
+
+	// Write a chunk with a version 1 header, which has no hash algorithm byte
+	sha256Hash := sha256.Sum256(chunk)
+	v1Header := append([]byte{}, headerMagic...)
+	v1Header = append(v1Header, 1, byte(CompressionNone), byte(EncryptionNone), 32)
+	v1Header = append(v1Header, sha256Hash[:]...)
+	v1Header = binary.BigEndian.AppendUint64(v1Header, uint64(len(chunk)))
+	v1Header = binary.BigEndian.AppendUint32(v1Header, crc32.ChecksumIEEE(v1Header))
+	os.WriteFile(chunkStore.chunkPath(hex.EncodeToString(sha256Hash[:])), append(v1Header, chunk...), 0644)
+
+	skipped, err := chunkStore.RebuildIndex()
+	if err != nil || len(skipped) != 0 {
+		t.Fatalf("FAIL: RebuildIndex() skipped %v (error %v)", skipped, err)
+	}
+	if retrieved, err := chunkStore.GetChunk(sha256Hash[:]); err != nil || !bytes.Equal(retrieved, chunk) {
+		t.Errorf("FAIL: GetChunk() did not return the chunk with a version 1 header (error %v)", err)
+	}
+	if !chunkStore.HasChunk(hash) {
+		t.Errorf("FAIL: Rebuilt index is missing the BLAKE3 chunk")
+	}
+}
+
+// Tests that the index can be reconstructed purely from chunk headers
+func TestChunkStore_RebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	chunkStore, _ := NewChunkStore(dir)
+	hash1, _ := chunkStore.PutChunk([]byte("chunk1"))
+	hash2, _ := chunkStore.PutChunk([]byte("chunk2"))
+
+	// Delete the index and add a junk file that is not a valid chunk
+	os.Remove(filepath.Join(dir, indexFileName))
+	os.WriteFile(filepath.Join(dir, "junk"+chunkExtension), []byte("junk"), 0644)
+
+	reopened, _ := NewChunkStore(dir)
+	if len(reopened.Index) != 0 {
+		t.Fatalf("FAIL: Index should be empty after it was deleted")
+	}
+
+	skipped, err := reopened.RebuildIndex()
+	if err != nil {
+		t.Fatalf("RebuildIndex() failed with error: %v", err)
+	}
+	if len(skipped) != 1 {
+		t.Errorf("FAIL: Expected 1 skipped chunk file, got %d", len(skipped))
+	}
+	if !reopened.HasChunk(hash1) || !reopened.HasChunk(hash2) {
+		t.Errorf("FAIL: Rebuilt index is missing stored chunks")
+	}
+	if reopened.Index[hex.EncodeToString(hash1)].OriginalLength != 6 {
+		t.Errorf("FAIL: Rebuilt index entry has the wrong original length")
+	}
+}
+
+// Tests that garbage collection planning classifies chunks correctly and that running it deletes them
+func TestPlanGC(t *testing.T) {
+	dir := t.TempDir()
+	chunkStore, _ := NewChunkStore(dir)
+	pinSet, _ := NewPinSet(filepath.Join(dir, "pins.json"))
+
+	pinnedChunk, _ := chunkStore.PutChunk([]byte("pinned"))
+	sharedChunk, _ := chunkStore.PutChunk([]byte("shared"))
+	expiredChunk, _ := chunkStore.PutChunk([]byte("expired"))
+	unpinnedChunk, _ := chunkStore.PutChunk([]byte("unpinned"))
+	orphanChunk, _ := chunkStore.PutChunk([]byte("orphan"))
+
+	now := time.Now()
+	pinnedFile := &core.Manifest{MerkleRoot: []byte("pinned root"), ChunkHashes: [][]byte{pinnedChunk, sharedChunk}}
+	expiredFile := &core.Manifest{MerkleRoot: []byte("expired root"), ChunkHashes: [][]byte{expiredChunk, sharedChunk}}
+	unpinnedFile := &core.Manifest{MerkleRoot: []byte("unpinned root"), ChunkHashes: [][]byte{unpinnedChunk}}
+	pinSet.Pin(pinnedFile.MerkleRoot, now.Add(time.Hour))
+	pinSet.Pin(expiredFile.MerkleRoot, now.Add(-time.Hour))
+
+	report := PlanGC(chunkStore, []*core.Manifest{pinnedFile, expiredFile, unpinnedFile}, pinSet, now)
+
+	expected := map[string]GCReason{
+		hex.EncodeToString(expiredChunk):  ReasonExpiredLease,
+		hex.EncodeToString(unpinnedChunk): ReasonUnpinned,
+		hex.EncodeToString(orphanChunk):   ReasonUnreferenced,
+	}
+	if len(report.Candidates) != len(expected) {
+		t.Fatalf("FAIL: Expected %d GC candidates, got %d", len(expected), len(report.Candidates))
+	}
+	for _, candidate := range report.Candidates {
+		if expected[candidate.Hash] != candidate.Reason {
+			t.Errorf("FAIL: Chunk %s had reason %s, expected %s", candidate.Hash, candidate.Reason, expected[candidate.Hash])
+		}
+	}
+
+	// A dry run must not delete anything, but running the report must
+	if !chunkStore.HasChunk(orphanChunk) {
+		t.Errorf("FAIL: Planning garbage collection deleted a chunk")
+	}
+	if err := chunkStore.RunGC(report); err != nil {
+		t.Fatalf("RunGC() failed with error: %v", err)
+	}
+	if chunkStore.HasChunk(orphanChunk) || !chunkStore.HasChunk(sharedChunk) {
+		t.Errorf("FAIL: RunGC() did not delete exactly the planned chunks")
+	}
+}
+
+// Tests that deleting a file only deletes the chunks no other file references
+func TestPlanDeletion(t *testing.T) {
+	chunkStore, _ := NewChunkStore(t.TempDir())
+	ownChunk, _ := chunkStore.PutChunk([]byte("own"))
+	sharedChunk, _ := chunkStore.PutChunk([]byte("shared"))
+	otherChunk, _ := chunkStore.PutChunk([]byte("other"))
+
+	deletedFile := &core.Manifest{MerkleRoot: []byte("deleted root"), ChunkHashes: [][]byte{ownChunk, sharedChunk, ownChunk}}
+	otherFile := &core.Manifest{MerkleRoot: []byte("other root"), ChunkHashes: [][]byte{sharedChunk, otherChunk}}
+
+	report := PlanDeletion(chunkStore, deletedFile, []*core.Manifest{deletedFile, otherFile})
+	if len(report.Candidates) != 1 || report.Candidates[0].Hash != hex.EncodeToString(ownChunk) ||
+		report.Candidates[0].Reason != ReasonDeleted {
+		t.Fatalf("FAIL: Expected only the deleted file's own chunk to be deleted, got %+v", report.Candidates)
+	}
+	if err := chunkStore.RunGC(report); err != nil {
+		t.Fatalf("RunGC() failed with error: %v", err)
+	}
+	if chunkStore.HasChunk(ownChunk) || !chunkStore.HasChunk(sharedChunk) || !chunkStore.HasChunk(otherChunk) {
+		t.Errorf("FAIL: RunGC() did not delete exactly the deleted file's own chunk")
+	}
+}
+
+// Tests that every chain store backend behaves the same way
+func TestChainStores(t *testing.T) {
+	dir := t.TempDir()
+	boltStore, _ := NewBoltChainStore(filepath.Join(dir, "blockchain.db"))
+	ndjsonStore, _ := NewNDJSONChainStore(filepath.Join(dir, "blocks.ndjson"))
+	jsonStore, _ := NewJSONChainStore(filepath.Join(dir, "blockchain.json"))
+	stores := map[string]core.ChainStore{
+		"memory": core.NewMemoryChainStore(),
+		"bolt":   boltStore,
+		"ndjson": ndjsonStore,
+		"json":   jsonStore,
+	}
+
+	for name, store := range stores {
+		if _, err := store.Head(); err != core.ErrBlockNotFound {
+			t.Errorf("FAIL: %s: Expected no head in an empty store, got error %v", name, err)
+		}
+		genesis := &core.Block{Index: 0, Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis_root")}
+		first := &core.Block{Index: 1, Hash: []byte("first_hash"), PrevHash: genesis.Hash, MerkelRoot: []byte("first_root")}
+		for _, block := range []*core.Block{genesis, first} {
+			if err := store.PutBlock(block); err != nil {
+				t.Fatalf("FAIL: %s: PutBlock() failed with error: %v", name, err)
+			}
+		}
+		if store.PutBlock(&core.Block{Index: 5}) != core.ErrBlockGap {
+			t.Errorf("FAIL: %s: PutBlock() accepted a block that leaves a gap in the chain", name)
+		}
+
+		blocks, err := core.NewBlockchain(store).Blocks()
+		if err != nil || len(blocks) != 2 || blocks[0].Index != 0 {
+			t.Fatalf("FAIL: %s: Blocks() did not return the blocks in height order", name)
+		}
+		if head, err := store.Head(); err != nil || head.Index != 1 {
+			t.Errorf("FAIL: %s: Head() did not return the first block", name)
+		}
+		if block, err := store.GetByHash(first.Hash); err != nil || block.Index != 1 {
+			t.Errorf("FAIL: %s: GetByHash() did not return the first block", name)
+		}
+		if block, err := store.GetByMerkleRoot(genesis.MerkelRoot); err != nil || block.Index != 0 {
+			t.Errorf("FAIL: %s: GetByMerkleRoot() did not return the genesis block", name)
+		}
+
+		// Replacing a block must also replace its index entries
+		replacement := &core.Block{Index: 1, Hash: []byte("other_hash"), PrevHash: genesis.Hash, MerkelRoot: []byte("other_root")}
+		if err := store.PutBlock(replacement); err != nil {
+			t.Fatalf("FAIL: %s: PutBlock() failed to replace a block with error: %v", name, err)
+		}
+		if _, err := store.GetByHash(first.Hash); err != core.ErrBlockNotFound {
+			t.Errorf("FAIL: %s: Expected the replaced block's hash to be removed, got error %v", name, err)
+		}
+		if block, err := store.GetByHeight(1); err != nil || string(block.Hash) != "other_hash" {
+			t.Errorf("FAIL: %s: GetByHeight() did not return the replacement block", name)
+		}
+	}
+}
+
+// Tests that blocks appended to an NDJSON chain store survive reopening and that replacing a block rewrites the file
+func TestNDJSONChainStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocks.ndjson")
+	store, err := NewNDJSONChainStore(path)
+	if err != nil {
+		t.Fatalf("NewNDJSONChainStore() failed with error: %v", err)
+	}
+	genesis := &core.Block{Index: 0, Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis_root")}
+	first := &core.Block{Index: 1, Hash: []byte("first_hash"), PrevHash: genesis.Hash, MerkelRoot: []byte("first_root")}
+	second := &core.Block{Index: 2, Hash: []byte("second_hash"), PrevHash: first.Hash, MerkelRoot: []byte("second_root")}
+	for _, block := range []*core.Block{genesis, first, second} {
+		if err := store.PutBlock(block); err != nil {
+			t.Fatalf("PutBlock() failed with error: %v", err)
+		}
+	}
+	if store.PutBlock(&core.Block{Index: 5}) == nil {
+		t.Errorf("FAIL: PutBlock() accepted a block that leaves a gap in the chain")
+	}
+
+	// Each block is a single line in the file
+	contents, _ := os.ReadFile(path)
+	if lines := bytes.Count(contents, []byte("\n")); lines != 3 {
+		t.Fatalf("FAIL: Expected 3 lines in the blocks file, got %d", lines)
+	}
+
+	// A partially written block must be ignored when the store is reopened
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.Write([]byte(`{"index":3,"ha`))
+	file.Close()
+	store, err = NewNDJSONChainStore(path)
+	if err != nil {
+		t.Fatalf("NewNDJSONChainStore() failed to reopen the store with error: %v", err)
+	}
+	if block, err := store.GetByHash(second.Hash); err != nil || block.Index != 2 {
+		t.Errorf("FAIL: GetByHash() did not return the second block after reopening")
+	}
+	// The partial line is cut off, so the next block appended is on a line of its own
+	third := &core.Block{Index: 3, Hash: []byte("third_hash"), PrevHash: second.Hash, MerkelRoot: []byte("third_root")}
+	if err := store.PutBlock(third); err != nil {
+		t.Fatalf("PutBlock() failed after reopening with error: %v", err)
+	}
+	os.Remove(path + ndjsonIndexExtension)
+	store, err = NewNDJSONChainStore(path)
+	if err != nil {
+		t.Fatalf("NewNDJSONChainStore() failed to reopen the store with error: %v", err)
+	}
+	if block, err := store.GetByHeight(3); err != nil || string(block.Hash) != "third_hash" {
+		t.Errorf("FAIL: Expected the block appended after a partial line to be read back, got error %v", err)
+	}
+	os.WriteFile(path, contents, 0644)
+	store, _ = NewNDJSONChainStore(path)
+
+	replacement := &core.Block{Index: 1, Hash: []byte("other_hash"), PrevHash: genesis.Hash, MerkelRoot: []byte("other_root")}
+	if err := store.PutBlock(replacement); err != nil {
+		t.Fatalf("PutBlock() failed to replace a block with error: %v", err)
+	}
+	blocks, err := core.NewBlockchain(store).Blocks()
+	if err != nil || len(blocks) != 3 || string(blocks[1].Hash) != "other_hash" {
+		t.Fatalf("FAIL: Blocks() did not return the chain with the replaced block")
+	}
+	if _, err := store.GetByMerkleRoot(first.MerkelRoot); err != core.ErrBlockNotFound {
+		t.Errorf("FAIL: Expected the replaced block's Merkle root to be removed, got error %v", err)
+	}
+	if block, err := store.GetByHeight(2); err != nil || string(block.Hash) != "second_hash" {
+		t.Errorf("FAIL: GetByHeight() did not return the block after the replaced one")
+	}
+}
+
+// Tests that the block index answers queries by uploader, time and file name and follows forks of the chain
+func TestBlockIndex(t *testing.T) {
+	dir := t.TempDir()
+	manifestStore, _ := NewManifestStore(filepath.Join(dir, "manifests"))
+	manifestStore.PutManifest(&core.Manifest{FileName: "report.pdf", MerkleRoot: []byte("march_root"), Tags: []string{"finance", "q1"}})
+	manifestStore.PutManifest(&core.Manifest{FileName: "notes.txt", MerkleRoot: []byte("bob_root"), Tags: []string{"q1"}})
+
+	alice, bob := []byte("alice_key"), []byte("bob_key")
+	march := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	blockchain := core.NewBlockchain(core.NewMemoryChainStore())
+	for _, block := range []*core.Block{
+		{Index: 0, Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis_root"), Timestamp: march.AddDate(0, -1, 0)},
+		{Index: 1, Hash: []byte("first_hash"), MerkelRoot: []byte("march_root"), Timestamp: march, UploaderPublicKey: alice},
+		{Index: 2, Hash: []byte("second_hash"), MerkelRoot: []byte("april_root"), Timestamp: march.AddDate(0, 1, 0), UploaderPublicKey: alice},
+		{Index: 3, Hash: []byte("third_hash"), MerkelRoot: []byte("bob_root"), Timestamp: march, UploaderPublicKey: bob},
+	} {
+		blockchain.Store.PutBlock(block)
+	}
+
+	blockIndex, err := NewBlockIndex(filepath.Join(dir, "blocks.sqlite"))
+	if err != nil {
+		t.Fatalf("NewBlockIndex() failed with error: %v", err)
+	}
+	defer blockIndex.Close()
+	err = blockIndex.Sync(blockchain, manifestStore)
+	if err != nil {
+		t.Fatalf("Sync() failed with error: %v", err)
+	}
+
+	records, err := blockIndex.Query(BlockQuery{
+		Uploader: alice,
+		From:     time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC),
+		To:       time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil || len(records) != 1 || records[0].Height != 1 {
+		t.Fatalf("FAIL: Expected only alice's March upload, got %v (error %v)", records, err)
+	}
+	if records[0].FileName != "report.pdf" || !records[0].Timestamp.Equal(march) {
+		t.Errorf("FAIL: Indexed metadata did not match the block and its manifest, got %+v", records[0])
+	}
+	if records, _ := blockIndex.Query(BlockQuery{FileName: "report"}); len(records) != 1 {
+		t.Errorf("FAIL: Expected one block matching the file name, got %d", len(records))
+	}
+	if records, _ := blockIndex.Query(BlockQuery{Tag: "q1"}); len(records) != 2 || len(records[0].Tags) != 2 {
+		t.Errorf("FAIL: Expected two blocks tagged q1 with their tags, got %+v", records)
+	}
+	if records, _ := blockIndex.Query(BlockQuery{Tag: "finance", Uploader: bob}); len(records) != 0 {
+		t.Errorf("FAIL: Tag filter was not combined with the uploader filter")
+	}
+
+	// Replace the tip on a fork and check that the index follows
+	blockchain.Store.PutBlock(&core.Block{Index: 3, Hash: []byte("fork_hash"), MerkelRoot: []byte("fork_root"), UploaderPublicKey: alice})
+	err = blockIndex.Sync(blockchain, manifestStore)
+	if err != nil {
+		t.Fatalf("Sync() failed with error: %v", err)
+	}
+	if records, _ := blockIndex.Query(BlockQuery{Uploader: bob}); len(records) != 0 {
+		t.Errorf("FAIL: A block replaced by a fork is still indexed")
+	}
+	if records, _ := blockIndex.Query(BlockQuery{Tag: "q1"}); len(records) != 1 {
+		t.Errorf("FAIL: Tags of a block replaced by a fork are still indexed")
+	}
+	if records, _ := blockIndex.Query(BlockQuery{}); len(records) != 4 || string(records[3].Hash) != "fork_hash" {
+		t.Errorf("FAIL: Expected the index to hold the four blocks of the fork")
+	}
+}
+
+// Tests parsing the ages given to block queries
+func TestParseAge(t *testing.T) {
+	for value, expected := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		if age, err := ParseAge(value); err != nil || age != expected {
+			t.Errorf("FAIL: Parsed %q as %v (error %v)", value, age, err)
+		}
+	}
+	for _, value := range []string{"", "d", "-1d", "30x", "-5h"} {
+		if _, err := ParseAge(value); err == nil {
+			t.Errorf("FAIL: Invalid age %q was accepted", value)
+		}
+	}
+}
+
+// Tests that verifying a snapshot reports exactly what a restore would recover without changing the snapshot
+func TestPlanRestore(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := DataLayout{
+		ChainJSONPath:   filepath.Join(dir, "blockchain.json"),
+		ChainBoltPath:   filepath.Join(dir, "blockchain.db"),
+		ChainNDJSONPath: filepath.Join(dir, "blocks.ndjson"),
+		ChunkDir:        filepath.Join(dir, "chunks"),
+		ManifestDir:     filepath.Join(dir, "manifests"),
+		PinsPath:        filepath.Join(dir, "pins.json"),
+	}
+
+	// The second block's records were altered after it was signed, so only the genesis block is valid
+	genesis := core.NewGenesisBlock(time.Unix(1700000000, 0))
+	source := core.NewBlockchain(core.NewMemoryChainStore())
+	source.AddBlock(genesis)
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	tampered := core.CreateBlock(source, []byte("first_root"), core.Records{}, publicKey, verify.SHA256)
+	tampered.Sign(privateKey)
+	tampered.Name = "forged"
+	core.WriteBlocksToFile([]*core.Block{genesis, tampered}, snapshot.ChainJSONPath)
+
+	chunkStore, _ := NewChunkStore(snapshot.ChunkDir)
+	good, _ := chunkStore.PutChunk([]byte("good chunk"))
+	corrupt, _ := chunkStore.PutChunk([]byte("corrupt chunk"))
+	corruptPath := filepath.Join(snapshot.ChunkDir, hex.EncodeToString(corrupt)+".chunk")
+	contents, _ := os.ReadFile(corruptPath)
+	contents[len(contents)-1] ^= 0xff
+	os.WriteFile(corruptPath, contents, 0644)
+
+	manifestStore, _ := NewManifestStore(snapshot.ManifestDir)
+	manifestStore.PutManifest(&core.Manifest{FileName: "file.txt", MerkleRoot: []byte("first_root")})
+	os.WriteFile(filepath.Join(snapshot.ManifestDir, "broken.json"), []byte("{"), 0644)
+
+	report, err := PlanRestore(snapshot, nil, 0)
+	if err != nil {
+		t.Fatalf("PlanRestore() failed with error: %v", err)
+	}
+	if report.Complete() {
+		t.Errorf("FAIL: A snapshot with invalid parts was reported as complete")
+	}
+	if report.ChainSource != snapshot.ChainJSONPath || report.ChainLength != 2 || len(report.Blocks) != 1 {
+		t.Errorf("FAIL: Expected 1 of 2 blocks to be recoverable from the JSON file, got %d of %d from %q",
+			len(report.Blocks), report.ChainLength, report.ChainSource)
+	}
+	if len(report.Chunks) != 1 || !bytes.Equal(report.Chunks[0], good) || len(report.CorruptChunks) != 1 {
+		t.Errorf("FAIL: Expected the good chunk to be recoverable and the corrupt one to be reported")
+	}
+	if len(report.Manifests) != 1 || len(report.InvalidManifests) != 1 {
+		t.Errorf("FAIL: Expected one recoverable and one invalid manifest")
+	}
+	if _, err := os.Stat(snapshot.ChainBoltPath); err == nil {
+		t.Errorf("FAIL: Verifying the snapshot created files in it")
+	}
+
+	// The live chain is longer than the snapshot's, and none of its blocks may be left after the restored ones
+	live := t.TempDir()
+	livePath := filepath.Join(live, "blocks.ndjson")
+	liveChain, _ := NewNDJSONChainStore(livePath)
+	for i := int64(0); i < 3; i++ {
+		liveChain.PutBlock(&core.Block{Index: i, Hash: []byte{byte(i)}, MerkelRoot: []byte{byte(i)}})
+	}
+	liveChunks, _ := NewChunkStore(filepath.Join(live, "chunks"))
+	liveManifests, _ := NewManifestStore(filepath.Join(live, "manifests"))
+	if err := RunRestore(report, snapshot, core.NewBlockchain(liveChain), 0, liveChunks, liveManifests,
+		filepath.Join(live, "pins.json")); err == nil {
+		t.Errorf("FAIL: RunRestore() restored over a chain that was not empty")
+	}
+	restoredChain, _ := NewNDJSONChainStore(livePath + ".restore")
+	err = RunRestore(report, snapshot, core.NewBlockchain(restoredChain), 0, liveChunks, liveManifests,
+		filepath.Join(live, "pins.json"))
+	if err != nil {
+		t.Fatalf("RunRestore() failed with error: %v", err)
+	}
+	if err := ReplaceChainFile(livePath+".restore", livePath); err != nil {
+		t.Fatalf("ReplaceChainFile() failed with error: %v", err)
+	}
+	liveChain, _ = NewNDJSONChainStore(livePath)
+	head, err := liveChain.Head()
+	if err != nil || head.Index != 0 || !bytes.Equal(head.Hash, genesis.Hash) {
+		t.Errorf("FAIL: Expected the live chain to be replaced by the restored genesis block, got error %v", err)
+	}
+	if !liveChunks.HasChunk(good) || liveChunks.HasChunk(corrupt) {
+		t.Errorf("FAIL: Restore did not recover exactly what the report described")
+	}
+	if _, err := liveManifests.GetManifest([]byte("first_root")); err != nil {
+		t.Errorf("FAIL: Restore did not recover the valid manifest")
+	}
+}
+
+// Tests that chunks are encrypted at rest with the chunk key and cannot be read without it
+func TestChunkStore_Encryption(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateChunkKey(filepath.Join(dir, "chunks.key"))
+	if err != nil {
+		t.Fatalf("GenerateChunkKey() failed with error: %v", err)
+	}
+	if _, err := GenerateChunkKey(filepath.Join(dir, "chunks.key")); err == nil {
+		t.Errorf("FAIL: GenerateChunkKey() overwrote an existing key")
+	}
+	chunkStore, err := NewChunkStore(filepath.Join(dir, "chunks"))
+	if err != nil {
+		t.Fatalf("NewChunkStore() failed with error: %v", err)
+	}
+	chunkStore.Key = key
+
+	chunk := []byte("secret chunk data")
+	hash, err := chunkStore.PutChunk(chunk)
+	if err != nil {
+		t.Fatalf("PutChunk() failed with error: %v", err)
+	}
+	contents, _ := os.ReadFile(chunkStore.chunkPath(hex.EncodeToString(hash)))
+	if bytes.Contains(contents, chunk) {
+		t.Errorf("FAIL: Chunk was stored unencrypted")
+	}
+	retrieved, err := chunkStore.GetChunk(hash)
+	if err != nil || !bytes.Equal(retrieved, chunk) {
+		t.Errorf("FAIL: GetChunk() did not decrypt the stored chunk")
+	}
+
+	chunkStore.Key = nil
+	if _, err := chunkStore.GetChunk(hash); err != ErrChunkKeyMissing {
+		t.Errorf("FAIL: Expected ErrChunkKeyMissing without a key, got %v", err)
+	}
+	chunkStore.Key = make([]byte, ChunkKeySize)
+	if _, err := chunkStore.GetChunk(hash); err != ErrChunkDecrypt {
+		t.Errorf("FAIL: Expected ErrChunkDecrypt with the wrong key, got %v", err)
+	}
+}
+
+// Tests that compressed chunks are stored smaller but still addressed and returned by their uncompressed contents
+func TestChunkStore_Compression(t *testing.T) {
+	chunkStore, err := NewChunkStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewChunkStore() failed with error: %v", err)
+	}
+	chunkStore.Compress = true
+	chunkStore.Key = make([]byte, ChunkKeySize)
+
+	chunk := bytes.Repeat([]byte("compressible chunk data "), 200)
+	hash, err := chunkStore.PutChunk(chunk)
+	if err != nil {
+		t.Fatalf("PutChunk() failed with error: %v", err)
+	}
+	if expected := sha256.Sum256(chunk); !bytes.Equal(hash, expected[:]) {
+		t.Errorf("FAIL: Compressed chunk was not addressed by the hash of its uncompressed contents")
+	}
+	entry := chunkStore.Index[hex.EncodeToString(hash)]
+	if entry.Compression != CompressionZstd || entry.StoredSize >= int64(len(chunk)) {
+		t.Errorf("FAIL: Expected the chunk to be stored compressed, got %+v", entry)
+	}
+	retrieved, err := chunkStore.GetChunk(hash)
+	if err != nil || !bytes.Equal(retrieved, chunk) {
+		t.Errorf("FAIL: GetChunk() did not decompress the stored chunk (%v)", err)
+	}
+
+	// Chunks that do not shrink are stored as they are
+	incompressible, _ := chunkStore.PutChunk([]byte("short"))
+	if entry := chunkStore.Index[hex.EncodeToString(incompressible)]; entry.Compression != CompressionNone {
+		t.Errorf("FAIL: Chunk that does not shrink was stored compressed")
+	}
+}
+
+// Tests splitting a chunk key among custodians and recovering it from any threshold of their shares
+func TestKeyEscrow(t *testing.T) {
+	key := make([]byte, ChunkKeySize)
+	for i := range key {
+		key[i] = byte(i * 7)
+	}
+	shares, err := SplitKey(key, []string{"alice", "bob", "carol", "dave"}, 3)
+	if err != nil {
+		t.Fatalf("SplitKey() failed with error: %v", err)
+	}
+
+	for _, subset := range [][]int{{0, 1, 2}, {1, 3, 0}, {3, 2, 1, 0}} {
+		var chosen []*KeyShare
+		for _, i := range subset {
+			chosen = append(chosen, shares[i])
+		}
+		recovered, err := CombineShares(chosen)
+		if err != nil || !bytes.Equal(recovered, key) {
+			t.Errorf("FAIL: Shares %v did not recover the key (error %v)", subset, err)
+		}
+	}
+
+	if _, err := CombineShares(shares[:2]); err != ErrTooFewShares {
+		t.Errorf("FAIL: Expected ErrTooFewShares with fewer shares than the threshold, got %v", err)
+	}
+	if _, err := CombineShares([]*KeyShare{shares[0], shares[0], shares[1]}); err != ErrTooFewShares {
+		t.Errorf("FAIL: A repeated share was counted towards the threshold")
+	}
+
+	// A corrupted share must not silently produce the wrong key
+	shares[1].Share[0] ^= 1
+	if _, err := CombineShares(shares[:3]); err != ErrShareMismatch {
+		t.Errorf("FAIL: Expected ErrShareMismatch with a corrupted share, got %v", err)
+	}
+
+	if _, err := SplitKey(key, []string{"alice", "bob"}, 3); err == nil {
+		t.Errorf("FAIL: SplitKey() accepted a threshold above the number of custodians")
+	}
+}
+
+// Tests that a snapshot of a node's data only holds files committed on its chain and can be restored in full
+func TestCreateSnapshot(t *testing.T) {
+	live := t.TempDir()
+	chainStore, _ := NewBoltChainStore(filepath.Join(live, "blockchain.db"))
+	genesis := core.NewGenesisBlock(time.Unix(1700000000, 0))
+	chainStore.PutBlock(genesis)
+	chunkStore, _ := NewChunkStore(filepath.Join(live, "chunks"))
+	chunkHash, _ := chunkStore.PutChunk([]byte("snapshot chunk"))
+	manifestStore, _ := NewManifestStore(filepath.Join(live, "manifests"))
+	pinSet, _ := NewPinSet(filepath.Join(live, "pins.json"))
+	// The second file is not committed on the chain yet, so it is left out of the snapshot
+	for _, root := range [][]byte{genesis.MerkelRoot, []byte("pending_root")} {
+		manifestStore.PutManifest(&core.Manifest{FileName: "file.txt", MerkleRoot: root})
+		pinSet.Pin(root, time.Time{})
+	}
+
+	dir := filepath.Join(t.TempDir(), "snapshot")
+	snapshot := DataLayout{
+		ChainJSONPath:   filepath.Join(dir, "blockchain.json"),
+		ChainBoltPath:   filepath.Join(dir, "blockchain.db"),
+		ChainNDJSONPath: filepath.Join(dir, "blocks.ndjson"),
+		ChunkDir:        filepath.Join(dir, "chunks"),
+		ManifestDir:     filepath.Join(dir, "manifests"),
+		PinsPath:        filepath.Join(dir, "pins.json"),
+	}
+	options := SnapshotOptions{IncludeChunks: true, ConfigHash: "config"}
+	info, err := CreateSnapshot(dir, snapshot, chainStore, chunkStore, manifestStore, pinSet, options)
+	if err != nil {
+		t.Fatalf("CreateSnapshot() failed with error: %v", err)
+	}
+	if info.ChainHeight != 0 || info.Chunks != 1 || info.Manifests != 1 || info.Pins != 1 {
+		t.Errorf("FAIL: Expected 1 block, chunk, manifest and pin in the snapshot, got %+v", info)
+	}
+	if info.Files["blockchain.db"] == "" || info.ConfigHash != "config" {
+		t.Errorf("FAIL: Expected the snapshot to record its chain file's hash and the config hash, got %+v", info)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotInfoFile)); err != nil {
+		t.Errorf("FAIL: The snapshot is missing its description: %v", err)
+	}
+
+	report, err := PlanRestore(snapshot, nil, 0)
+	if err != nil {
+		t.Fatalf("PlanRestore() failed with error: %v", err)
+	}
+	if !report.Complete() || len(report.Blocks) != 1 || len(report.Chunks) != 1 || !bytes.Equal(report.Chunks[0], chunkHash) {
+		t.Errorf("FAIL: Expected the snapshot to be restorable in full, got %+v", report)
+	}
+	if report.Pins == nil || len(report.Pins.Pins) != 1 || len(report.Manifests) != 1 {
+		t.Errorf("FAIL: Expected the committed file's manifest and pin to be restorable")
+	}
+
+	// An existing snapshot is never overwritten
+	if _, err := CreateSnapshot(dir, snapshot, chainStore, chunkStore, manifestStore, pinSet, options); err == nil {
+		t.Errorf("FAIL: CreateSnapshot() wrote into an existing directory")
+	}
+}
+
+// Tests that every chunk is counted in exactly one usage class and that files are listed largest first
+func TestPlanUsage(t *testing.T) {
+	dir := t.TempDir()
+	chunkStore, _ := NewChunkStore(dir)
+	pinSet, _ := NewPinSet(filepath.Join(dir, "pins.json"))
+
+	pinnedChunk, _ := chunkStore.PutChunk([]byte("pinned"))
+	sharedChunk, _ := chunkStore.PutChunk([]byte("shared"))
+	cachedChunk, _ := chunkStore.PutChunk([]byte("cached contents"))
+	chunkStore.PutChunk([]byte("orphan"))
+
+	now := time.Now()
+	pinnedFile := &core.Manifest{MerkleRoot: []byte("pinned root"), ChunkHashes: [][]byte{pinnedChunk, sharedChunk}}
+	cachedFile := &core.Manifest{MerkleRoot: []byte("cached root"), ChunkHashes: [][]byte{cachedChunk, sharedChunk, cachedChunk}}
+	pinSet.Pin(pinnedFile.MerkleRoot, time.Time{})
+
+	report := PlanUsage(chunkStore, []*core.Manifest{cachedFile, pinnedFile}, pinSet, now, 10)
+	if report.Pinned.Chunks != 2 || report.Cached.Chunks != 1 || report.Orphaned.Chunks != 1 || report.Total.Chunks != 4 {
+		t.Errorf("FAIL: Unexpected usage classes %+v", report)
+	}
+	if report.Pinned.Bytes+report.Cached.Bytes+report.Orphaned.Bytes != chunkStore.UsedSpace() ||
+		report.Total.Bytes != chunkStore.UsedSpace() {
+		t.Errorf("FAIL: Usage classes do not add up to the space used by the chunk store")
+	}
+	// A chunk repeated within a file is only counted once for it
+	if len(report.Largest) != 2 || report.Largest[0].MerkleRoot != hex.EncodeToString(cachedFile.MerkleRoot) ||
+		report.Largest[0].Chunks != 2 || report.Largest[0].Pinned || !report.Largest[1].Pinned {
+		t.Errorf("FAIL: Unexpected largest files %+v", report.Largest)
+	}
+	if top := PlanUsage(chunkStore, []*core.Manifest{cachedFile, pinnedFile}, pinSet, now, 1); len(top.Largest) != 1 {
+		t.Errorf("FAIL: Expected only the largest file to be listed, got %d", len(top.Largest))
+	}
+}
+
+// Tests that the chunk cache moves least recently used chunks from memory to disk and then out of the cache, takes
+// its disk tier back when reopened and drops cached chunks that were corrupted on disk
+func TestChunkCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewChunkCache(20, dir, 20)
+	if err != nil {
+		t.Fatalf("NewChunkCache() failed with error: %v", err)
+	}
+	var hashes [][]byte
+	for i := 0; i < 5; i++ {
+		chunk := []byte("cached-" + string(rune('a'+i)) + "..")
+		hash := sha256.Sum256(chunk)
+		hashes = append(hashes, hash[:])
+		if err := cache.Put(verify.SHA256, hash[:], chunk); err != nil {
+			t.Fatalf("Put() failed with error: %v", err)
+		}
+		// Reading the first chunk keeps it the most recently used
+		if _, _, found := cache.Get(hashes[0]); !found {
+			t.Fatalf("FAIL: Most recently used chunk was pushed out of the cache")
+		}
+	}
+
+	// The first chunk stays in memory, the two chunks used last after it are on disk and the oldest two are gone
+	stats := cache.Stats()
+	if stats.MemoryChunks != 2 || stats.DiskChunks != 2 || stats.Evictions != 1 {
+		t.Errorf("FAIL: Unexpected cache contents %+v", stats)
+	}
+	if cache.Has(hashes[1]) || !cache.Has(hashes[2]) {
+		t.Errorf("FAIL: Least recently used chunk was not the one evicted")
+	}
+	chunk, multihash, found := cache.Get(hashes[2])
+	if !found || !multihash.Verify(chunk) || !bytes.Equal(multihash.Digest(), hashes[2]) {
+		t.Errorf("FAIL: Chunk read back from disk does not match its hash")
+	}
+
+	// Reopening the cache takes back the chunks on disk, dropping one that was corrupted
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+cachedChunkExtension))
+	if len(files) != 2 {
+		t.Fatalf("FAIL: Expected 2 chunks on disk, found %d", len(files))
+	}
+	os.WriteFile(files[0], []byte("corrupt!!!"), 0644)
+	reopened, err := NewChunkCache(20, dir, 20)
+	if err != nil {
+		t.Fatalf("NewChunkCache() failed with error: %v", err)
+	}
+	if stats := reopened.Stats(); stats.DiskChunks != 2 || stats.MemoryChunks != 0 {
+		t.Errorf("FAIL: Reopened cache did not take back its disk tier: %+v", stats)
+	}
+	valid := 0
+	for _, hash := range hashes {
+		if _, _, found := reopened.Get(hash); found {
+			valid++
+		}
+	}
+	if valid != 1 || reopened.Stats().DiskChunks != 0 {
+		t.Errorf("FAIL: Expected only the intact chunk to be read from disk, read %d", valid)
+	}
+
+	// A chunk larger than every tier is not cached
+	large := bytes.Repeat([]byte("x"), 64)
+	largeHash := sha256.Sum256(large)
+	reopened.Put(verify.SHA256, largeHash[:], large)
+	if reopened.Has(largeHash[:]) {
+		t.Errorf("FAIL: Chunk larger than the cache was cached")
+	}
+}
+
+// Tests that the credit ledger counts each chunk once per peer and direction and keeps its totals across reopening
+func TestCreditLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credits.ndjson")
+	ledger, err := NewCreditLedger(path)
+	if err != nil {
+		t.Fatalf("NewCreditLedger() failed with error: %v", err)
+	}
+	receipt := []byte(`{"stored":true}`)
+	entries := []CreditEntry{
+		{Peer: "uploader", Direction: CreditProvided, Hash: []byte("a"), Size: 300, Receipt: receipt},
+		{Peer: "uploader", Direction: CreditProvided, Hash: []byte("a"), Size: 300, Receipt: receipt},
+		{Peer: "uploader", Direction: CreditConsumed, Hash: []byte("a"), Size: 300, Receipt: receipt},
+		{Peer: "holder", Direction: CreditConsumed, Hash: []byte("b"), Size: 500, Receipt: receipt},
+	}
+	for _, entry := range entries {
+		if err := ledger.Record(entry); err != nil {
+			t.Fatalf("Record() failed with error: %v", err)
+		}
+	}
+
+	reopened, err := NewCreditLedger(path)
+	if err != nil {
+		t.Fatalf("NewCreditLedger() failed to reopen the ledger with error: %v", err)
+	}
+	for _, balance := range []CreditBalance{ledger.Balance(), reopened.Balance()} {
+		if balance.Provided != 300 || balance.Consumed != 800 || balance.Balance != -500 || balance.Receipts != 3 {
+			t.Errorf("FAIL: Expected 300 bytes provided and 800 consumed over 3 receipts, got %+v", balance)
+		}
+		if len(balance.Peers) != 2 || balance.Peers[0].Peer != "uploader" || balance.Peers[1].Consumed != 500 {
+			t.Errorf("FAIL: Expected the uploader to be listed before the holder, got %+v", balance.Peers)
+		}
+	}
+}
+
+// Tests that a file is reassembled from its chunks with its padding cut off and its chunks decrypted, and that chunks
+// or manifests that do not match the file's Merkle root are refused
+func TestReassembleFile(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, ChunkKeySize)
+	plain := [][]byte{[]byte("first chunk"), []byte("last chunk\x00\x00\x00")}
+	encrypted := make([][]byte, len(plain))
+	for i, chunk := range plain {
+		encrypted[i], _ = EncryptChunk(key, chunk)
+	}
+	manifestOf := func(chunks [][]byte) *core.Manifest {
+		tree := core.NewMerkleTree(chunks)
+		manifest := &core.Manifest{MerkleRoot: tree.Root.Hash, Padding: 3}
+		for _, chunk := range chunks {
+			hash := sha256.Sum256(chunk)
+			manifest.ChunkHashes = append(manifest.ChunkHashes, hash[:])
+		}
+		return manifest
+	}
+	reassemble := func(manifest *core.Manifest, key []byte, chunks [][]byte) (string, error) {
+		var output bytes.Buffer
+		err := ReassembleFile(&output, manifest, key, func(index int) ([]byte, error) { return chunks[index], nil })
+		return output.String(), err
+	}
+
+	if contents, err := reassemble(manifestOf(plain), nil, plain); err != nil || contents != "first chunklast chunk" {
+		t.Errorf("FAIL: Expected the unpadded file, got %q (%v)", contents, err)
+	}
+	manifest := manifestOf(encrypted)
+	manifest.Encrypted = true
+	if contents, err := reassemble(manifest, key, encrypted); err != nil || contents != "first chunklast chunk" {
+		t.Errorf("FAIL: Expected the decrypted file, got %q (%v)", contents, err)
+	}
+	if _, err := reassemble(manifest, nil, encrypted); err == nil {
+		t.Errorf("FAIL: Encrypted file was reassembled without its key")
+	}
+	if _, err := reassemble(manifestOf(plain), nil, [][]byte{plain[0], []byte("tampered")}); err != ErrChunkMismatch {
+		t.Errorf("FAIL: Expected ErrChunkMismatch for a tampered chunk, got %v", err)
+	}
+	forged := manifestOf(plain)
+	forged.ChunkHashes = forged.ChunkHashes[:1]
+	if _, err := reassemble(forged, nil, plain); err != ErrManifestMismatch {
+		t.Errorf("FAIL: Expected ErrManifestMismatch for a manifest not matching its root, got %v", err)
+	}
+}
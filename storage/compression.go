@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Error returned when a compressed chunk cannot be decompressed
+var ErrChunkDecompress = errors.New("chunk could not be decompressed")
+
+// Encoder and decoder shared by every chunk, both of which are safe for concurrent use when used on whole chunks
+// The decoder refuses to produce more than the largest chunk size, so a small chunk cannot expand without bound
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(core.MaxChunkSize))
+
+// Function that compresses a chunk with Zstandard, returning the chunk unchanged and false if that does not shrink it
+// Chunks that are already compressed or encrypted rarely shrink, so they are kept as they are
+func CompressChunk(chunk []byte) ([]byte, bool) {
+	compressed := zstdEncoder.EncodeAll(chunk, nil)
+	if len(compressed) >= len(chunk) {
+		return chunk, false
+	}
+	return compressed, true
+}
+
+// Function that decompresses a chunk compressed by CompressChunk
+func DecompressChunk(data []byte) ([]byte, error) {
+	chunk, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, ErrChunkDecompress
+	}
+	return chunk, nil
+}
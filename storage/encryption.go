@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Size in bytes of the key chunks are encrypted with
+const ChunkKeySize = 32
+
+// Errors returned when encrypted chunks cannot be read
+var (
+	ErrChunkKeyMissing = errors.New("chunk is encrypted but the chunk store has no key")
+	ErrChunkDecrypt    = errors.New("chunk could not be decrypted with the chunk store's key")
+)
+
+// Function that generates a new chunk key and saves it to a file, refusing to overwrite an existing key
+// Chunks encrypted with a key are unreadable without it, so the key should be escrowed with custodians (see SplitKey)
+func GenerateChunkKey(path string) ([]byte, error) {
+	key := make([]byte, ChunkKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, SaveChunkKey(path, key)
+}
+
+// Function that saves a chunk key to a file, refusing to overwrite an existing key
+func SaveChunkKey(path string, key []byte) error {
+	if len(key) != ChunkKeySize {
+		return errors.New("chunk key has the wrong length")
+	}
+	// File permissions 0600 means only the file owner can read and write the key
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(hex.EncodeToString(key))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Function that loads the chunk key from a file, returning nil if the node has no key (chunks are not encrypted)
+func LoadChunkKey(path string) ([]byte, error) {
+	hexKey, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(hexKey)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ChunkKeySize {
+		return nil, errors.New("chunk key file does not contain a valid key")
+	}
+	return key, nil
+}
+
+// Function that returns a short fingerprint identifying a chunk key without revealing it
+func KeyFingerprint(key []byte) string {
+	hash := sha256.Sum256(append([]byte("chunk-key:"), key...))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Function that encrypts a chunk with AES-256-GCM, returning the nonce followed by the ciphertext
+func EncryptChunk(key []byte, chunk []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, chunk, nil), nil
+}
+
+// Function that decrypts a chunk encrypted by EncryptChunk
+func DecryptChunk(key []byte, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrChunkDecrypt
+	}
+	chunk, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrChunkDecrypt
+	}
+	return chunk, nil
+}
+
+// Function that creates an AES-GCM cipher from a chunk key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Errors returned when key shares cannot be combined
+var (
+	ErrTooFewShares  = errors.New("not enough key shares to recover the key")
+	ErrShareMismatch = errors.New("key shares do not belong to the same key")
+)
+
+// KeyShare - One custodian's share of the chunk key, split with Shamir secret sharing
+// Any Threshold shares recover the key, while fewer reveal nothing about it
+type KeyShare struct {
+	Custodian   string `json:"custodian"`   // Name of the custodian holding the share
+	Index       byte   `json:"index"`       // X coordinate of the share (1-255)
+	Threshold   int    `json:"threshold"`   // Number of shares needed to recover the key
+	Fingerprint string `json:"fingerprint"` // Fingerprint of the key, used to check the recovered key
+	Share       []byte `json:"share"`       // Value of the sharing polynomial at Index for every byte of the key
+}
+
+// Function that splits a chunk key into one share per custodian, any threshold of which recover the key
+// Every byte of the key is the constant term of its own random polynomial of degree threshold-1 over GF(256), and each
+// custodian is given the value of every polynomial at a different point
+func SplitKey(key []byte, custodians []string, threshold int) ([]*KeyShare, error) {
+	if threshold < 2 || threshold > len(custodians) {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of custodians (%d)", len(custodians))
+	}
+	if len(custodians) > 255 {
+		return nil, errors.New("a key can be split among at most 255 custodians")
+	}
+
+	shares := make([]*KeyShare, len(custodians))
+	for i, custodian := range custodians {
+		shares[i] = &KeyShare{
+			Custodian:   custodian,
+			Index:       byte(i + 1),
+			Threshold:   threshold,
+			Fingerprint: KeyFingerprint(key),
+			Share:       make([]byte, len(key)),
+		}
+	}
+
+	coefficients := make([]byte, threshold)
+	for byteIndex, secret := range key {
+		coefficients[0] = secret
+		_, err := rand.Read(coefficients[1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			share.Share[byteIndex] = evaluatePolynomial(coefficients, share.Index)
+		}
+	}
+	return shares, nil
+}
+
+// Function that recovers a chunk key from key shares by Lagrange interpolation at zero
+// The recovered key is checked against the fingerprint in the shares, so a wrong or corrupted share is detected
+func CombineShares(shares []*KeyShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrTooFewShares
+	}
+	first := shares[0]
+	seen := make(map[byte]bool)
+	var unique []*KeyShare
+	for _, share := range shares {
+		if share.Fingerprint != first.Fingerprint || share.Threshold != first.Threshold || len(share.Share) != len(first.Share) {
+			return nil, ErrShareMismatch
+		}
+		if share.Index == 0 || seen[share.Index] {
+			continue
+		}
+		seen[share.Index] = true
+		unique = append(unique, share)
+	}
+	if len(unique) < first.Threshold {
+		return nil, ErrTooFewShares
+	}
+	unique = unique[:first.Threshold]
+
+	key := make([]byte, len(first.Share))
+	for i, share := range unique {
+		// Lagrange basis polynomial for this share evaluated at zero (subtraction is XOR in GF(256))
+		basis := byte(1)
+		for j, other := range unique {
+			if i != j {
+				basis = gfMul(basis, gfMul(other.Index, gfInverse(other.Index^share.Index)))
+			}
+		}
+		for byteIndex := range key {
+			key[byteIndex] ^= gfMul(share.Share[byteIndex], basis)
+		}
+	}
+	if KeyFingerprint(key) != first.Fingerprint {
+		return nil, ErrShareMismatch
+	}
+	return key, nil
+}
+
+// Function that writes a key share to a file, to be handed to its custodian
+func WriteKeyShare(path string, share *KeyShare) error {
+	jsonShare, err := json.MarshalIndent(share, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, jsonShare, 0600)
+}
+
+// Function that reads a key share returned by a custodian
+func ReadKeyShare(path string) (*KeyShare, error) {
+	jsonShare, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	share := &KeyShare{}
+	err = json.Unmarshal(jsonShare, share)
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// Function that evaluates a polynomial over GF(256) at x using Horner's method
+func evaluatePolynomial(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// Function that multiplies two elements of GF(256), using the AES reduction polynomial x^8 + x^4 + x^3 + x + 1
+func gfMul(a byte, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// Function that returns the multiplicative inverse of a non-zero element of GF(256), which is a^254
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for i := 0; i < 254; i++ {
+		result = gfMul(result, a)
+	}
+	return result
+}
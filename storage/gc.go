@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"bytes"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// GCReason - Reason a chunk is eligible for garbage collection
+type GCReason string
+
+const (
+	ReasonUnreferenced GCReason = "unreferenced"  // No manifest references the chunk
+	ReasonExpiredLease GCReason = "expired-lease" // Every file referencing the chunk has an expired lease
+	ReasonUnpinned     GCReason = "unpinned"      // No file referencing the chunk is pinned
+	ReasonDeleted      GCReason = "deleted"       // The file referencing the chunk was deleted by its uploader
+)
+
+// GCCandidate - Structure describing a single chunk that garbage collection would delete
+type GCCandidate struct {
+	Hash   string   `json:"hash"`   // Hex encoded hash of the chunk
+	Size   int64    `json:"size"`   // Space on disk that deleting the chunk would reclaim
+	Reason GCReason `json:"reason"` // Reason the chunk is eligible for deletion
+}
+
+// GCReport - Structure describing everything a garbage collection run would delete
+type GCReport struct {
+	Candidates     []GCCandidate      `json:"candidates"`     // Every chunk that would be deleted
+	ReclaimedBytes int64              `json:"reclaimedBytes"` // Total space that would be reclaimed
+	BytesByReason  map[GCReason]int64 `json:"bytesByReason"`  // Space that would be reclaimed for each reason
+	CountByReason  map[GCReason]int   `json:"countByReason"`  // Number of chunks that would be deleted for each reason
+}
+
+// Function that works out which chunks garbage collection would delete without deleting anything
+// A chunk is kept as long as at least one file referencing it is pinned with an unexpired lease
+func PlanGC(chunkStore *ChunkStore, manifests []*core.Manifest, pinSet *PinSet, now time.Time) *GCReport {
+	// Work out the pin status of every file referencing each chunk
+	statuses := make(map[string][]PinStatus)
+	for _, manifest := range manifests {
+		status := pinSet.Status(manifest.MerkleRoot, now)
+		for _, chunkHash := range manifest.ChunkHashes {
+			hexHash := hex.EncodeToString(chunkHash)
+			statuses[hexHash] = append(statuses[hexHash], status)
+		}
+	}
+
+	report := &GCReport{
+		BytesByReason: make(map[GCReason]int64),
+		CountByReason: make(map[GCReason]int),
+	}
+
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+	for hexHash, entry := range chunkStore.Index {
+		reason, collect := gcReason(statuses[hexHash])
+		if !collect {
+			continue
+		}
+		report.Candidates = append(report.Candidates, GCCandidate{Hash: hexHash, Size: entry.StoredSize, Reason: reason})
+		report.ReclaimedBytes += entry.StoredSize
+		report.BytesByReason[reason] += entry.StoredSize
+		report.CountByReason[reason]++
+	}
+
+	// Sort the candidates so that reports are stable between runs
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Hash < report.Candidates[j].Hash
+	})
+	return report
+}
+
+// Function that works out which chunks deleting a file would delete without deleting anything
+// Chunks the file shares with any of the other manifests are kept, so the manifests of files that were themselves
+// deleted should be left out
+func PlanDeletion(chunkStore *ChunkStore, manifest *core.Manifest, others []*core.Manifest) *GCReport {
+	shared := make(map[string]bool)
+	for _, other := range others {
+		if bytes.Equal(other.MerkleRoot, manifest.MerkleRoot) {
+			continue
+		}
+		for _, chunkHash := range other.ChunkHashes {
+			shared[hex.EncodeToString(chunkHash)] = true
+		}
+	}
+
+	report := &GCReport{
+		BytesByReason: make(map[GCReason]int64),
+		CountByReason: make(map[GCReason]int),
+	}
+
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+	for _, chunkHash := range manifest.ChunkHashes {
+		hexHash := hex.EncodeToString(chunkHash)
+		entry, found := chunkStore.Index[hexHash]
+		if !found || shared[hexHash] {
+			continue
+		}
+		// A file can hold the same chunk more than once, but it only needs deleting once
+		shared[hexHash] = true
+		report.Candidates = append(report.Candidates, GCCandidate{Hash: hexHash, Size: entry.StoredSize, Reason: ReasonDeleted})
+		report.ReclaimedBytes += entry.StoredSize
+		report.BytesByReason[ReasonDeleted] += entry.StoredSize
+		report.CountByReason[ReasonDeleted]++
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].Hash < report.Candidates[j].Hash
+	})
+	return report
+}
+
+// Function that decides whether a chunk should be collected given the pin statuses of the files referencing it
+func gcReason(statuses []PinStatus) (GCReason, bool) {
+	if len(statuses) == 0 {
+		return ReasonUnreferenced, true
+	}
+	expired := false
+	for _, status := range statuses {
+		if status == Pinned {
+			return "", false
+		}
+		if status == LeaseExpired {
+			expired = true
+		}
+	}
+	// A lease that has run out is the more specific explanation so it takes priority
+	if expired {
+		return ReasonExpiredLease, true
+	}
+	return ReasonUnpinned, true
+}
+
+// Function that deletes every chunk listed in a garbage collection report
+func (chunkStore *ChunkStore) RunGC(report *GCReport) error {
+	for _, candidate := range report.Candidates {
+		hash, err := hex.DecodeString(candidate.Hash)
+		if err != nil {
+			return err
+		}
+		err = chunkStore.DeleteChunk(hash)
+		if err != nil {
+			return err
+		}
+		logger.Debug("garbage collected chunk", "chunk", candidate.Hash)
+	}
+	logger.Info("garbage collection finished", "chunks", len(report.Candidates))
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"blockchain-storage/verify"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Magic bytes written at the start of every stored chunk so that chunk files can be recognised when scanning
+var headerMagic = []byte("BSCH")
+
+// Current version of the chunk header format
+// Version 1 headers do not record a hash algorithm, so the chunks they describe are always hashed with SHA-256
+const HeaderVersion uint8 = 2
+
+// Size of the encoded header in bytes:
+// magic (4) + version (1) + compression (1) + encryption (1) + hash algorithm (1) + hash length (1) + hash (32) +
+// original length (8) + CRC (4)
+const HeaderSize = 4 + 1 + 1 + 1 + 1 + 1 + 32 + 8 + 4
+
+// Size of a version 1 header, which lacks the hash algorithm
+const headerSizeV1 = HeaderSize - 1
+
+// CompressionType - Identifier for the compression algorithm applied to the chunk data
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = 0
+	CompressionZstd CompressionType = 1 // Zstandard, applied before any encryption
+)
+
+// EncryptionType - Identifier for the encryption algorithm applied to the chunk data
+type EncryptionType uint8
+
+const (
+	EncryptionNone      EncryptionType = 0
+	EncryptionAES256GCM EncryptionType = 1 // AES-256-GCM with the node's chunk key, the nonce is stored before the data
+)
+
+// ChunkHeader - Self-describing metadata stored in front of every chunk in the chunk store
+// This allows the chunk store to validate and interpret chunks even if the external index is lost
+// The CRC only detects corruption of the header, it cannot correct it
+type ChunkHeader struct {
+	Version        uint8                // Version of the header format
+	Compression    CompressionType      // Compression algorithm applied to the stored data
+	Encryption     EncryptionType       // Encryption algorithm applied to the stored data
+	HashAlgorithm  verify.HashAlgorithm // Algorithm the chunk is addressed by
+	Hash           []byte               // Hash of the original (uncompressed, unencrypted) chunk
+	OriginalLength uint64               // Length of the original chunk in bytes
+}
+
+// Function that returns the size of the encoded header, which depends on its version
+func (header *ChunkHeader) Size() int {
+	if header.Version == 1 {
+		return headerSizeV1
+	}
+	return HeaderSize
+}
+
+// Function that encodes a chunk header into its fixed-size binary representation followed by a CRC
+// Headers are always encoded in the current version
+func (header *ChunkHeader) Encode() ([]byte, error) {
+	if len(header.Hash) != 32 {
+		return nil, fmt.Errorf("invalid chunk hash length: %d", len(header.Hash))
+	}
+	algorithmID, err := header.HashAlgorithm.ID()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, 0, HeaderSize)
+	buffer = append(buffer, headerMagic...)
+	buffer = append(buffer, HeaderVersion, byte(header.Compression), byte(header.Encryption), algorithmID)
+	buffer = append(buffer, byte(len(header.Hash)))
+	buffer = append(buffer, header.Hash...)
+	buffer = binary.BigEndian.AppendUint64(buffer, header.OriginalLength)
+
+	// The CRC covers every byte of the header before it so corruption of the metadata is detected, though not repaired
+	buffer = binary.BigEndian.AppendUint32(buffer, crc32.ChecksumIEEE(buffer))
+	return buffer, nil
+}
+
+// Function that decodes and validates a chunk header from the start of a stored chunk
+// Version 1 headers are still accepted, so chunks stored before hash algorithms were recorded can be read
+func DecodeHeader(data []byte) (*ChunkHeader, error) {
+	if len(data) < headerSizeV1 {
+		return nil, errors.New("chunk is too short to contain a header")
+	}
+	if !bytes.Equal(data[:4], headerMagic) {
+		return nil, errors.New("chunk does not start with a valid header")
+	}
+
+	header := &ChunkHeader{
+		Version:     data[4],
+		Compression: CompressionType(data[5]),
+		Encryption:  EncryptionType(data[6]),
+	}
+	if header.Version != 1 && header.Version != HeaderVersion {
+		return nil, fmt.Errorf("unsupported chunk header version: %d", header.Version)
+	}
+	size := header.Size()
+	if len(data) < size {
+		return nil, errors.New("chunk is too short to contain a header")
+	}
+
+	// Check the CRC before interpreting any of the fields
+	checksum := binary.BigEndian.Uint32(data[size-4 : size])
+	if crc32.ChecksumIEEE(data[:size-4]) != checksum {
+		return nil, errors.New("chunk header checksum mismatch")
+	}
+
+	// Fields after the encryption type are shifted by the hash algorithm byte in version 2 headers
+	offset := 7
+	header.HashAlgorithm = verify.SHA256
+	if header.Version == HeaderVersion {
+		algorithm, err := verify.HashAlgorithmByID(data[offset])
+		if err != nil {
+			return nil, err
+		}
+		header.HashAlgorithm = algorithm
+		offset++
+	}
+	if data[offset] != 32 {
+		return nil, fmt.Errorf("invalid chunk hash length: %d", data[offset])
+	}
+
+	// Copy the hash so the header does not keep the whole chunk buffer alive
+	header.Hash = make([]byte, 32)
+	copy(header.Hash, data[offset+1:offset+33])
+	header.OriginalLength = binary.BigEndian.Uint64(data[offset+33 : offset+41])
+	return header, nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"errors"
+	"os"
+)
+
+// JSONChainStore - Chain store keeping blocks in memory and saving the whole chain to a JSON file after every change
+// This is the original persistence format, which is simple to inspect but rewrites the entire file for every block
+type JSONChainStore struct {
+	*core.MemoryChainStore
+	Path string // Path of the JSON file
+}
+
+// Function that opens the JSON chain store at the given path, loading any blocks already saved there
+func NewJSONChainStore(path string) (*JSONChainStore, error) {
+	store := &JSONChainStore{MemoryChainStore: core.NewMemoryChainStore(), Path: path}
+	blocks, err := core.BlocksFromFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		err := store.MemoryChainStore.PutBlock(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// Function that stores a block and saves the whole chain back to the file
+func (store *JSONChainStore) PutBlock(block *core.Block) error {
+	err := store.MemoryChainStore.PutBlock(block)
+	if err != nil {
+		return err
+	}
+
+	var blocks []*core.Block
+	store.Iterate(func(block *core.Block) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	return core.WriteBlocksToFile(blocks, store.Path)
+}
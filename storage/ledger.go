@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Directions of storage recorded in the credit ledger
+const (
+	CreditProvided = "provided" // The node stored a chunk for a peer
+	CreditConsumed = "consumed" // A peer stored a chunk for the node
+)
+
+// CreditEntry - Structure recording a chunk stored for a peer or by a peer, along with the receipt proving it
+type CreditEntry struct {
+	Peer       string          `json:"peer"`       // Peer ID of the peer the chunk was stored for or by
+	Direction  string          `json:"direction"`  // Whether the chunk was provided to or consumed from the peer
+	Hash       []byte          `json:"hash"`       // Hash of the chunk
+	Size       int64           `json:"size"`       // Size of the chunk's data
+	Receipt    json.RawMessage `json:"receipt"`    // Acknowledgment of storing the chunk signed by the peer holding it
+	RecordedAt time.Time       `json:"recordedAt"` // Time the entry was recorded
+}
+
+// PeerCredits - Structure holding the bytes stored for and by a single peer
+type PeerCredits struct {
+	Peer     string `json:"peer"`     // Peer ID of the peer
+	Provided int64  `json:"provided"` // Bytes the node stored for the peer
+	Consumed int64  `json:"consumed"` // Bytes the peer stored for the node
+}
+
+// CreditBalance - Structure summarising the credit ledger
+// A positive balance means the node has stored more for others than others have stored for it.
+type CreditBalance struct {
+	Provided int64         `json:"provided"` // Bytes the node stored for every peer
+	Consumed int64         `json:"consumed"` // Bytes every peer stored for the node
+	Balance  int64         `json:"balance"`  // Bytes provided less bytes consumed
+	Receipts int           `json:"receipts"` // Number of receipts backing the ledger
+	Peers    []PeerCredits `json:"peers"`    // Bytes stored for and by each peer, the peers owing the most first
+}
+
+// CreditLedger - Structure accounting for the storage the node provides to and consumes from its peers
+// Entries are appended to a file with one JSON encoded entry per line. A chunk is only counted once per peer and
+// direction, so placing the same chunk again does not count it twice.
+type CreditLedger struct {
+	Path    string                  // Path of the ledger file
+	credits map[string]*PeerCredits // Bytes stored for and by each peer, keyed by peer ID
+	counted map[string]bool         // Peer, direction and hash of every entry counted so far
+	mutex   sync.Mutex
+}
+
+// Function that opens (creating if needed) the credit ledger at the given path, totalling the entries already in it
+func NewCreditLedger(path string) (*CreditLedger, error) {
+	ledger := &CreditLedger{Path: path, credits: make(map[string]*PeerCredits), counted: make(map[string]bool)}
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partially written final line (without its newline) is ignored
+			return ledger, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry CreditEntry
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return nil, err
+		}
+		ledger.count(&entry)
+	}
+}
+
+// Function that adds an entry to the totals, returning false if the chunk was already counted
+func (ledger *CreditLedger) count(entry *CreditEntry) bool {
+	key := creditKey(entry)
+	if ledger.counted[key] {
+		return false
+	}
+	ledger.counted[key] = true
+	credits, found := ledger.credits[entry.Peer]
+	if !found {
+		credits = &PeerCredits{Peer: entry.Peer}
+		ledger.credits[entry.Peer] = credits
+	}
+	if entry.Direction == CreditProvided {
+		credits.Provided += entry.Size
+	} else {
+		credits.Consumed += entry.Size
+	}
+	return true
+}
+
+// Function that returns the key an entry is counted under, which is the same for every entry of a chunk stored for or
+// by the same peer
+func creditKey(entry *CreditEntry) string {
+	return entry.Peer + "/" + entry.Direction + "/" + hex.EncodeToString(entry.Hash)
+}
+
+// Function that records a chunk stored for or by a peer with its receipt, appending it to the ledger file
+// An entry for a chunk already counted for the peer in the same direction is ignored.
+func (ledger *CreditLedger) Record(entry CreditEntry) error {
+	if entry.RecordedAt.IsZero() {
+		entry.RecordedAt = time.Now()
+	}
+	jsonEntry, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	if ledger.counted[creditKey(&entry)] {
+		return nil
+	}
+	file, err := os.OpenFile(ledger.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(jsonEntry, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// The entry is only counted once it is in the file, so the totals match the ledger after a restart
+	ledger.count(&entry)
+	return nil
+}
+
+// Function that returns the bytes stored for and by every peer, along with the node's overall balance
+func (ledger *CreditLedger) Balance() CreditBalance {
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+
+	balance := CreditBalance{Receipts: len(ledger.counted), Peers: []PeerCredits{}}
+	for _, credits := range ledger.credits {
+		balance.Provided += credits.Provided
+		balance.Consumed += credits.Consumed
+		balance.Peers = append(balance.Peers, *credits)
+	}
+	balance.Balance = balance.Provided - balance.Consumed
+	sort.Slice(balance.Peers, func(i, j int) bool {
+		owedI := balance.Peers[i].Provided - balance.Peers[i].Consumed
+		owedJ := balance.Peers[j].Provided - balance.Peers[j].Consumed
+		if owedI != owedJ {
+			return owedI > owedJ
+		}
+		return balance.Peers[i].Peer < balance.Peers[j].Peer
+	})
+	return balance
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestStore - Structure for storing file manifests on disk, with one JSON file per manifest named by Merkle root
+type ManifestStore struct {
+	Dir string // Directory holding the manifest files
+}
+
+// Function that opens (or creates) a manifest store in the given directory
+func NewManifestStore(dir string) (*ManifestStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestStore{Dir: dir}, nil
+}
+
+// Function that saves a manifest to the manifest store
+func (manifestStore *ManifestStore) PutManifest(manifest *core.Manifest) error {
+	jsonManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(manifestStore.Dir, hex.EncodeToString(manifest.MerkleRoot)+".json")
+	return os.WriteFile(path, jsonManifest, 0644)
+}
+
+// Function that retrieves a manifest by the Merkle root of its file
+func (manifestStore *ManifestStore) GetManifest(merkleRoot []byte) (*core.Manifest, error) {
+	return readManifest(filepath.Join(manifestStore.Dir, hex.EncodeToString(merkleRoot)+".json"))
+}
+
+// Function that lists every manifest held in the manifest store
+func (manifestStore *ManifestStore) ListManifests() ([]*core.Manifest, error) {
+	entries, err := os.ReadDir(manifestStore.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*core.Manifest
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(manifestStore.Dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// Function that lists every stored version of a file, i.e. every manifest uploaded under the same alias
+func (manifestStore *ManifestStore) ManifestsByAlias(alias string) ([]*core.Manifest, error) {
+	manifests, err := manifestStore.ListManifests()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []*core.Manifest
+	for _, manifest := range manifests {
+		if manifest.Alias == alias {
+			versions = append(versions, manifest)
+		}
+	}
+	return versions, nil
+}
+
+// Function that reads a single manifest file
+func readManifest(path string) (*core.Manifest, error) {
+	jsonManifest, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest core.Manifest
+	err = json.Unmarshal(jsonManifest, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Extension of the index file kept alongside an NDJSON blocks file
+const ndjsonIndexExtension = ".index"
+
+// ChainIndex - Structure locating every block within an NDJSON blocks file
+type ChainIndex struct {
+	Size                int64            `json:"size"`                // Size of the blocks file when the index was written
+	Offsets             []int64          `json:"offsets"`             // Byte offset of the line holding each block, by height
+	HeightsByHash       map[string]int64 `json:"heightsByHash"`       // Hex encoded block hash to height
+	HeightsByMerkleRoot map[string]int64 `json:"heightsByMerkleRoot"` // Hex encoded Merkle root to height
+}
+
+// NDJSONChainStore - Chain store keeping blocks in a file with one JSON encoded block per line
+// Adding a block appends a single line to the file, and an index file records where each block starts
+type NDJSONChainStore struct {
+	Path      string      // Path of the blocks file
+	IndexPath string      // Path of the index file
+	Index     *ChainIndex // Location of every block in the blocks file
+	mutex     sync.Mutex
+}
+
+// Function that opens (creating if needed) the NDJSON chain store at the given path
+// If the index is missing or does not match the blocks file (e.g. after a crash mid-append) it is rebuilt
+func NewNDJSONChainStore(path string) (*NDJSONChainStore, error) {
+	store := &NDJSONChainStore{Path: path, IndexPath: path + ndjsonIndexExtension}
+
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonIndex, err := os.ReadFile(store.IndexPath)
+	if err == nil {
+		var index ChainIndex
+		err = json.Unmarshal(jsonIndex, &index)
+		if err == nil && index.Size == info.Size() {
+			store.Index = &index
+			return store, nil
+		}
+	}
+
+	err = store.rebuildIndex()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Function that rebuilds the index by scanning every line of the blocks file
+// A partially written final line is cut off, so that the next block appended starts on a line of its own
+func (store *NDJSONChainStore) rebuildIndex() error {
+	index := newChainIndex()
+	end, err := store.scan(func(offset int64, block *core.Block) error {
+		index.add(offset, block)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > end {
+		err = os.Truncate(store.Path, end)
+		if err != nil {
+			return err
+		}
+	}
+	index.Size = end
+	store.Index = index
+	return store.writeIndex()
+}
+
+// Function that calls fn with the offset and contents of every block in the blocks file, in order, returning the
+// offset just past the last complete line
+func (store *NDJSONChainStore) scan(fn func(offset int64, block *core.Block) error) (int64, error) {
+	file, err := os.Open(store.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partially written final line (without its newline) is ignored
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		var block core.Block
+		err = json.Unmarshal(line, &block)
+		if err != nil {
+			return offset, err
+		}
+		err = fn(offset, &block)
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+	}
+}
+
+// Function that writes the index file
+func (store *NDJSONChainStore) writeIndex() error {
+	jsonIndex, err := json.Marshal(store.Index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(store.IndexPath, jsonIndex, 0644)
+}
+
+// Function that stores a block
+// A block at the next height is appended to the file. Replacing an existing block (which only happens on a fork)
+// requires rewriting the file from that block onwards.
+func (store *NDJSONChainStore) PutBlock(block *core.Block) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	height := int64(len(store.Index.Offsets))
+	if block.Index > height {
+		return core.ErrBlockGap
+	}
+	if block.Index < height {
+		return store.replaceBlock(block)
+	}
+
+	jsonBlock, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(store.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(jsonBlock, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	store.Index.add(store.Index.Size, block)
+	store.Index.Size += int64(len(jsonBlock)) + 1
+	return store.writeIndex()
+}
+
+// Function that replaces an existing block, rewriting the file from the replaced block onwards
+func (store *NDJSONChainStore) replaceBlock(block *core.Block) error {
+	var blocks []*core.Block
+	_, err := store.scan(func(_ int64, stored *core.Block) error {
+		if stored.Index >= block.Index {
+			if stored.Index == block.Index {
+				stored = block
+			}
+			blocks = append(blocks, stored)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Cut the file at the replaced block and write it and every later block back
+	offset := store.Index.Offsets[block.Index]
+	var contents []byte
+	for _, stored := range blocks {
+		jsonBlock, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		contents = append(contents, append(jsonBlock, '\n')...)
+	}
+	file, err := os.OpenFile(store.Path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(contents, offset)
+	if err == nil {
+		err = file.Truncate(offset + int64(len(contents)))
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return store.rebuildIndex()
+}
+
+// Function that calls fn on every stored block in height order
+func (store *NDJSONChainStore) Iterate(fn func(block *core.Block) error) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, err := store.scan(func(_ int64, block *core.Block) error {
+		return fn(block)
+	})
+	return err
+}
+
+// Function that returns the block at the greatest height
+func (store *NDJSONChainStore) Head() (*core.Block, error) {
+	store.mutex.Lock()
+	height := int64(len(store.Index.Offsets)) - 1
+	store.mutex.Unlock()
+	return store.GetByHeight(height)
+}
+
+// Function that returns the block at a height by reading only its line
+func (store *NDJSONChainStore) GetByHeight(height int64) (*core.Block, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if height < 0 || height >= int64(len(store.Index.Offsets)) {
+		return nil, core.ErrBlockNotFound
+	}
+	file, err := os.Open(store.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = file.Seek(store.Index.Offsets[height], io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var block core.Block
+	err = json.Unmarshal(line, &block)
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Function that returns the block with a hash
+func (store *NDJSONChainStore) GetByHash(hash []byte) (*core.Block, error) {
+	store.mutex.Lock()
+	height, found := store.Index.HeightsByHash[hex.EncodeToString(hash)]
+	store.mutex.Unlock()
+	if !found {
+		return nil, core.ErrBlockNotFound
+	}
+	return store.GetByHeight(height)
+}
+
+// Function that returns the block committing to a Merkle root
+func (store *NDJSONChainStore) GetByMerkleRoot(merkleRoot []byte) (*core.Block, error) {
+	store.mutex.Lock()
+	height, found := store.Index.HeightsByMerkleRoot[hex.EncodeToString(merkleRoot)]
+	store.mutex.Unlock()
+	if !found {
+		return nil, core.ErrBlockNotFound
+	}
+	return store.GetByHeight(height)
+}
+
+// Function that creates an empty chain index
+func newChainIndex() *ChainIndex {
+	return &ChainIndex{
+		Offsets:             []int64{},
+		HeightsByHash:       make(map[string]int64),
+		HeightsByMerkleRoot: make(map[string]int64),
+	}
+}
+
+// Function that records the location of a block appended to the blocks file
+func (index *ChainIndex) add(offset int64, block *core.Block) {
+	index.Offsets = append(index.Offsets, offset)
+	index.HeightsByHash[hex.EncodeToString(block.Hash)] = block.Index
+	for _, root := range block.CommittedRoots() {
+		index.HeightsByMerkleRoot[hex.EncodeToString(root)] = block.Index
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pin - Structure recording that the chunks of a file must be kept, optionally only until a lease expires
+type Pin struct {
+	Expiry time.Time `json:"expiry"` // Time the lease expires (the zero time means the pin never expires)
+}
+
+// PinStatus - State of a file's pin at a point in time
+type PinStatus int
+
+const (
+	Unpinned     PinStatus = iota // The file has no pin
+	Pinned                        // The file is pinned and its lease (if any) has not expired
+	LeaseExpired                  // The file was pinned but its lease has expired
+)
+
+// PinSet - Structure holding the pins of every file kept by the node, keyed by hex encoded Merkle root
+type PinSet struct {
+	Path  string          // Path of the JSON file the pins are saved to
+	Pins  map[string]*Pin // Pins keyed by hex encoded Merkle root
+	mutex sync.Mutex
+}
+
+// Function that loads a pin set from a file (an empty pin set is returned if the file does not exist yet)
+func NewPinSet(path string) (*PinSet, error) {
+	pinSet := &PinSet{Path: path, Pins: make(map[string]*Pin)}
+	jsonPins, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pinSet, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(jsonPins, &pinSet.Pins)
+	if err != nil {
+		return nil, err
+	}
+	return pinSet, nil
+}
+
+// Function that pins a file until the given expiry (the zero time pins it indefinitely) and saves the pin set
+func (pinSet *PinSet) Pin(merkleRoot []byte, expiry time.Time) error {
+	pinSet.mutex.Lock()
+	pinSet.Pins[hex.EncodeToString(merkleRoot)] = &Pin{Expiry: expiry}
+	pinSet.mutex.Unlock()
+	return pinSet.save()
+}
+
+// Function that removes the pin of a file and saves the pin set
+func (pinSet *PinSet) Unpin(merkleRoot []byte) error {
+	pinSet.mutex.Lock()
+	delete(pinSet.Pins, hex.EncodeToString(merkleRoot))
+	pinSet.mutex.Unlock()
+	return pinSet.save()
+}
+
+// Function that returns the state of a file's pin at the given time
+func (pinSet *PinSet) Status(merkleRoot []byte, now time.Time) PinStatus {
+	pinSet.mutex.Lock()
+	defer pinSet.mutex.Unlock()
+
+	pin, found := pinSet.Pins[hex.EncodeToString(merkleRoot)]
+	if !found {
+		return Unpinned
+	}
+	if !pin.Expiry.IsZero() && now.After(pin.Expiry) {
+		return LeaseExpired
+	}
+	return Pinned
+}
+
+// Function that lists the Merkle roots of every file pinned with an unexpired lease at the given time
+func (pinSet *PinSet) Pinned(now time.Time) [][]byte {
+	pinSet.mutex.Lock()
+	defer pinSet.mutex.Unlock()
+
+	var merkleRoots [][]byte
+	for hexRoot, pin := range pinSet.Pins {
+		if !pin.Expiry.IsZero() && now.After(pin.Expiry) {
+			continue
+		}
+		merkleRoot, err := hex.DecodeString(hexRoot)
+		if err != nil {
+			continue
+		}
+		merkleRoots = append(merkleRoots, merkleRoot)
+	}
+	return merkleRoots
+}
+
+// Function that writes the pin set to its file
+func (pinSet *PinSet) save() error {
+	pinSet.mutex.Lock()
+	jsonPins, err := json.MarshalIndent(pinSet.Pins, "", "  ")
+	pinSet.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pinSet.Path, jsonPins, 0644)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"blockchain-storage/verify"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Error returned when the proof store holds no proof of a chunk belonging to a file
+var ErrProofNotFound = errors.New("no proof of the chunk belonging to the file")
+
+// ProofStore - Structure for storing the Merkle proofs that placed chunks belong to their files on disk, so that they
+// can be served along with the chunks to peers that cannot check a chunk against its file otherwise
+// Proofs are kept in a directory per file named by its Merkle root, with one JSON file per chunk named by its hash.
+type ProofStore struct {
+	Dir string // Directory holding the proof files
+}
+
+// Function that opens (or creates) a proof store in the given directory
+func NewProofStore(dir string) (*ProofStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofStore{Dir: dir}, nil
+}
+
+// Function that returns the path of the file holding the proof of a chunk belonging to a file
+func (proofStore *ProofStore) proofPath(merkleRoot []byte, chunkHash []byte) string {
+	return filepath.Join(proofStore.Dir, hex.EncodeToString(merkleRoot), hex.EncodeToString(chunkHash)+".json")
+}
+
+// Function that saves the proof of a chunk belonging to the file with a Merkle root
+func (proofStore *ProofStore) PutProof(merkleRoot []byte, chunkHash []byte, proof []verify.ProofStep) error {
+	jsonProof, err := json.Marshal(proof)
+	if err != nil {
+		return err
+	}
+	path := proofStore.proofPath(merkleRoot, chunkHash)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, jsonProof, 0644)
+}
+
+// Function that retrieves the proof of a chunk belonging to the file with a Merkle root
+// A nil proof store holds no proofs
+func (proofStore *ProofStore) GetProof(merkleRoot []byte, chunkHash []byte) ([]verify.ProofStep, error) {
+	if proofStore == nil {
+		return nil, ErrProofNotFound
+	}
+	jsonProof, err := os.ReadFile(proofStore.proofPath(merkleRoot, chunkHash))
+	if os.IsNotExist(err) {
+		return nil, ErrProofNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var proof []verify.ProofStep
+	err = json.Unmarshal(jsonProof, &proof)
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned when a file cannot be reassembled from its chunks
+var (
+	ErrManifestMismatch = errors.New("manifest does not match the file's Merkle root")
+	ErrChunkMismatch    = errors.New("retrieved chunks do not match the file's Merkle root")
+)
+
+// Function that reassembles a file from its chunks and writes its contents, reading each chunk by its position
+// The manifest's chunk hashes are checked against its Merkle root before anything is written, and every chunk is
+// checked against its hash as it is read, so only the file committed under the root is ever written. The chunks of an
+// encrypted file are decrypted with the given key, and the padding is cut off the last chunk.
+func ReassembleFile(w io.Writer, manifest *core.Manifest, key []byte, read func(index int) ([]byte, error)) error {
+	builder := core.MerkleBuilder{Algorithm: manifest.HashAlgorithm}
+	for _, chunkHash := range manifest.ChunkHashes {
+		builder.AddHash(chunkHash)
+	}
+	if root, err := builder.Root(); err != nil || !bytes.Equal(root, manifest.MerkleRoot) {
+		return ErrManifestMismatch
+	}
+	if (manifest.Encrypted || manifest.Key != nil) && key == nil {
+		return fmt.Errorf("key of file %x is not held by this node", manifest.MerkleRoot)
+	}
+
+	for i, chunkHash := range manifest.ChunkHashes {
+		chunk, err := read(i)
+		if err != nil {
+			return err
+		}
+		hash, err := manifest.HashAlgorithm.Sum(chunk)
+		if err != nil || !bytes.Equal(hash, chunkHash) {
+			return ErrChunkMismatch
+		}
+		if key != nil {
+			chunk, err = DecryptChunk(key, chunk)
+			if err != nil {
+				return err
+			}
+		}
+		if i == len(manifest.ChunkHashes)-1 {
+			unpadded, err := manifest.Unpad([][]byte{chunk})
+			if err != nil {
+				return err
+			}
+			chunk = unpadded[0]
+		}
+		_, err = w.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
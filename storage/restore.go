@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DataLayout - Structure locating every part of a node's data, either in its live data directory or in a backup snapshot
+// A snapshot is a copy of the data directory, so both share the same layout
+type DataLayout struct {
+	ChainJSONPath   string // JSON blockchain file
+	ChainBoltPath   string // Bolt chain store database
+	ChainNDJSONPath string // NDJSON blocks file (with its index alongside)
+	ChunkDir        string // Chunk store directory
+	ManifestDir     string // Manifest store directory
+	PinsPath        string // Pin set file
+	ChunkKeyPath    string // Key the chunks are encrypted with (if they are encrypted)
+}
+
+// RestoreReport - Structure describing exactly what restoring a backup snapshot would recover
+type RestoreReport struct {
+	ChainSource string        `json:"chainSource"` // Chain file in the snapshot that the blockchain would be restored from
+	ChainLength int           `json:"chainLength"` // Number of blocks held in that chain file
+	Blocks      []*core.Block `json:"-"`           // Blocks that would be restored (those before the first invalid block)
+	ChainErr    string        `json:"chainErr"`    // Why no chain could be read from the snapshot (empty if one could)
+
+	Chunks          [][]byte `json:"-"`               // Hashes of the chunks that would be restored
+	ChunkBytes      int64    `json:"chunkBytes"`      // Total size of the chunks that would be restored
+	CorruptChunks   []string `json:"corruptChunks"`   // Chunk files that fail validation and would be skipped
+	MissingChunks   []string `json:"missingChunks"`   // Chunks listed in the snapshot's index without a chunk file
+	UnindexedChunks []string `json:"unindexedChunks"` // Chunk files that the snapshot's index does not list
+
+	Manifests        []*core.Manifest `json:"-"`                // Manifests that would be restored
+	InvalidManifests []string         `json:"invalidManifests"` // Manifest files that do not parse and would be skipped
+
+	Pins    *PinSet `json:"-"`       // Pin set that would be restored (nil if the snapshot has none)
+	PinsErr string  `json:"pinsErr"` // Why the snapshot's pin set could not be read (empty if it could)
+}
+
+// Function that checks whether every part of the snapshot would be restored in full
+func (report *RestoreReport) Complete() bool {
+	return report.ChainErr == "" && len(report.Blocks) == report.ChainLength && len(report.CorruptChunks) == 0 &&
+		len(report.MissingChunks) == 0 && len(report.UnindexedChunks) == 0 && len(report.InvalidManifests) == 0 &&
+		report.PinsErr == ""
+}
+
+// Function that works out what restoring a backup snapshot would recover without changing anything
+// The chain is imported up to the first invalid block with the given checkpoints and difficulty, checking every block
+// as RunRestore does, chunks are checked against their hashes and the snapshot's chunk index, and manifests and pins
+// must parse. Nothing is written to the snapshot or to the live data directory.
+func PlanRestore(snapshot DataLayout, checkpoints []core.Checkpoint, difficulty uint) (*RestoreReport, error) {
+	report := &RestoreReport{}
+	planChainRestore(snapshot, checkpoints, difficulty, report)
+
+	err := planChunkRestore(snapshot, report)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(snapshot.ManifestDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		manifest, err := readManifest(filepath.Join(snapshot.ManifestDir, entry.Name()))
+		if err != nil {
+			report.InvalidManifests = append(report.InvalidManifests, entry.Name())
+			continue
+		}
+		report.Manifests = append(report.Manifests, manifest)
+	}
+
+	if _, err := os.Stat(snapshot.PinsPath); err == nil {
+		report.Pins, err = NewPinSet(snapshot.PinsPath)
+		if err != nil {
+			report.PinsErr = err.Error()
+		}
+	}
+	return report, nil
+}
+
+// Function that picks the chain file in the snapshot holding the most valid blocks
+func planChainRestore(snapshot DataLayout, checkpoints []core.Checkpoint, difficulty uint, report *RestoreReport) {
+	sources := []struct {
+		path string
+		open func(path string) (core.ChainStore, error)
+	}{
+		{snapshot.ChainBoltPath, func(path string) (core.ChainStore, error) { return NewBoltChainStore(path) }},
+		{snapshot.ChainNDJSONPath, func(path string) (core.ChainStore, error) { return NewNDJSONChainStore(path) }},
+		{snapshot.ChainJSONPath, func(path string) (core.ChainStore, error) { return NewJSONChainStore(path) }},
+	}
+
+	report.ChainErr = "snapshot holds no blockchain"
+	for _, source := range sources {
+		if _, err := os.Stat(source.path); err != nil {
+			continue
+		}
+		blocks, length, err := readSnapshotChain(source.path, source.open, checkpoints, difficulty)
+		if err != nil {
+			if report.ChainSource == "" {
+				report.ChainErr = err.Error()
+			}
+			continue
+		}
+		if report.ChainSource == "" || len(blocks) > len(report.Blocks) {
+			report.ChainSource = source.path
+			report.ChainLength = length
+			report.Blocks = blocks
+			report.ChainErr = ""
+		}
+	}
+}
+
+// Function that reads the valid blocks of a chain file in a snapshot, along with the number of blocks it holds
+// Opening a chain store can write to it (e.g. to rebuild an index), so the store is opened on a temporary copy. The
+// blocks are imported into a chain kept in memory, so they are checked exactly as a restore would check them.
+func readSnapshotChain(path string, open func(path string) (core.ChainStore, error), checkpoints []core.Checkpoint,
+	difficulty uint) ([]*core.Block, int, error) {
+	tempDir, err := os.MkdirTemp("", "restore-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	copyPath := filepath.Join(tempDir, filepath.Base(path))
+	err = copyFile(path, copyPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	chainStore, err := open(copyPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	blockchain := core.NewBlockchain(chainStore)
+	blocks, err := blockchain.Blocks()
+	if err != nil {
+		return nil, 0, err
+	}
+	verifier := core.NewBlockchain(core.NewMemoryChainStore())
+	verifier.Checkpoints = checkpoints
+	imported, _ := verifier.ImportBlocks(blocks, difficulty)
+	return blocks[:imported], len(blocks), nil
+}
+
+// Function that checks every chunk file in a snapshot's chunk store against its hash and the chunk index
+func planChunkRestore(snapshot DataLayout, report *RestoreReport) error {
+	if _, err := os.Stat(snapshot.ChunkDir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	chunkStore, err := NewChunkStore(snapshot.ChunkDir)
+	if err != nil {
+		return err
+	}
+	chunkStore.Key, err = LoadChunkKey(snapshot.ChunkKeyPath)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(snapshot.ChunkDir)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), chunkExtension) {
+			continue
+		}
+		hexHash := strings.TrimSuffix(entry.Name(), chunkExtension)
+		files[hexHash] = true
+		if _, found := chunkStore.Index[hexHash]; !found {
+			report.UnindexedChunks = append(report.UnindexedChunks, entry.Name())
+		}
+
+		hash, err := hex.DecodeString(hexHash)
+		if err != nil {
+			report.CorruptChunks = append(report.CorruptChunks, entry.Name())
+			continue
+		}
+		chunk, err := chunkStore.GetChunk(hash)
+		if err != nil {
+			report.CorruptChunks = append(report.CorruptChunks, entry.Name())
+			continue
+		}
+		report.Chunks = append(report.Chunks, hash)
+		report.ChunkBytes += int64(len(chunk))
+	}
+	for hexHash := range chunkStore.Index {
+		if !files[hexHash] {
+			report.MissingChunks = append(report.MissingChunks, hexHash)
+		}
+	}
+	return nil
+}
+
+// Function that restores everything a restore report found to be recoverable from a snapshot
+// Blocks are imported into the given blockchain, which must be empty, so that they are checked against its checkpoints
+// and rules as any imported block is. Restoring over the live chain could leave its later blocks after the restored
+// ones, so the blockchain is kept in a fresh chain store that then replaces the live one (see ReplaceChainFile).
+func RunRestore(report *RestoreReport, snapshot DataLayout, blockchain *core.Blockchain, difficulty uint,
+	chunkStore *ChunkStore, manifestStore *ManifestStore, pinsPath string) error {
+	if blockchain.Length() > 0 {
+		return errors.New("blockchain must be restored into an empty chain store")
+	}
+	_, err := blockchain.ImportBlocks(report.Blocks, difficulty)
+	if err != nil {
+		return err
+	}
+
+	snapshotKey, err := LoadChunkKey(snapshot.ChunkKeyPath)
+	if err != nil {
+		return err
+	}
+	snapshotChunks := &ChunkStore{Dir: snapshot.ChunkDir, Key: snapshotKey}
+	for _, hash := range report.Chunks {
+		chunk, multihash, err := snapshotChunks.GetAddressedChunk(hash)
+		if err != nil {
+			return err
+		}
+		// Keep the chunk under the algorithm its file was hashed with, which its header records
+		algorithm, _, err := multihash.Decode()
+		if err != nil {
+			return err
+		}
+		_, err = chunkStore.PutChunkWith(algorithm, chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, manifest := range report.Manifests {
+		err := manifestStore.PutManifest(manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	if report.Pins != nil && report.PinsErr == "" {
+		pinSet := &PinSet{Path: pinsPath, Pins: report.Pins.Pins}
+		return pinSet.save()
+	}
+	return nil
+}
+
+// Function that replaces the file of a live chain store with that of a restored one, along with any index kept
+// alongside it
+func ReplaceChainFile(restoredPath string, livePath string) error {
+	err := os.Rename(restoredPath, livePath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(restoredPath+ndjsonIndexExtension, livePath+ndjsonIndexExtension)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Remove(livePath + ndjsonIndexExtension)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Function that removes the file of a chain store, along with any index kept alongside it
+func RemoveChainFile(path string) error {
+	for _, file := range []string{path, path + ndjsonIndexExtension} {
+		err := os.Remove(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function that copies a file
+func copyFile(source string, destination string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destinationFile, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(destinationFile, sourceFile)
+	if closeErr := destinationFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
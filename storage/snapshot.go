@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the file describing a snapshot, kept at the top of the snapshot directory
+const snapshotInfoFile = "snapshot.json"
+
+// SnapshotOptions - Structure holding the choices made when creating a snapshot
+type SnapshotOptions struct {
+	IncludeChunks bool   // Whether to copy the chunk files as well as the chunk index
+	ConfigHash    string // Hex encoded hash of the node's configuration, recorded so the snapshot can be traced to it
+}
+
+// SnapshotInfo - Structure describing a snapshot, which is saved in it as snapshot.json
+type SnapshotInfo struct {
+	CreatedAt   time.Time         `json:"createdAt"`            // Time the snapshot was started
+	ChainHeight int64             `json:"chainHeight"`          // Height of the last block in the snapshot
+	ChainHead   string            `json:"chainHead"`            // Hex encoded hash of the last block in the snapshot
+	ChainFile   string            `json:"chainFile"`            // File holding the chain (a Bolt database or NDJSON blocks)
+	Chunks      int               `json:"chunks"`               // Number of chunks in the snapshot's chunk index
+	ChunkData   bool              `json:"chunkData"`            // Whether the chunk files were copied
+	Manifests   int               `json:"manifests"`            // Number of manifests in the snapshot
+	Pins        int               `json:"pins"`                 // Number of pins in the snapshot
+	ConfigHash  string            `json:"configHash,omitempty"` // Hex encoded hash of the node's configuration
+	Files       map[string]string `json:"files"`                // Hex encoded SHA-256 of every file, by path within the snapshot
+}
+
+// Function that exports a consistent point-in-time snapshot of a node's data into a new directory, laid out as given
+// so that the restore command can read it. The node does not need to be stopped.
+// The chain is captured first, in a single read transaction for Bolt stores, and its last block fixes the point in
+// time: only manifests and pins of files committed at or below it are included. The chunk index is copied under the
+// chunk store's lock and the indexed chunk files are copied afterwards, as chunk files are addressed by their contents
+// (chunks removed in the meantime are left out of the snapshot's index).
+func CreateSnapshot(dir string, snapshot DataLayout, chainStore core.ChainStore, chunkStore *ChunkStore,
+	manifestStore *ManifestStore, pinSet *PinSet, options SnapshotOptions) (*SnapshotInfo, error) {
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	info, err := writeSnapshot(dir, snapshot, chainStore, chunkStore, manifestStore, pinSet, options)
+	if err != nil {
+		// Never leave a partial snapshot behind that could be mistaken for a complete one
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	logger.Info("created snapshot", "dir", dir, "chainHeight", info.ChainHeight, "chunks", info.Chunks)
+	return info, nil
+}
+
+// Function that writes every part of a snapshot into its directory
+func writeSnapshot(dir string, snapshot DataLayout, chainStore core.ChainStore, chunkStore *ChunkStore,
+	manifestStore *ManifestStore, pinSet *PinSet, options SnapshotOptions) (*SnapshotInfo, error) {
+	info := &SnapshotInfo{CreatedAt: time.Now().UTC(), ChunkData: options.IncludeChunks, ConfigHash: options.ConfigHash}
+
+	head, err := snapshotChain(snapshot, chainStore, info)
+	if err != nil {
+		return nil, fmt.Errorf("snapshotting chain: %w", err)
+	}
+	info.ChainHeight = head.Index
+	info.ChainHead = hex.EncodeToString(head.Hash)
+	committed := func(merkleRoot []byte) bool {
+		block, err := chainStore.GetByMerkleRoot(merkleRoot)
+		return err == nil && block.Index <= head.Index
+	}
+
+	err = snapshotChunks(snapshot.ChunkDir, chunkStore, options.IncludeChunks, info)
+	if err != nil {
+		return nil, fmt.Errorf("snapshotting chunks: %w", err)
+	}
+
+	manifests, err := manifestStore.ListManifests()
+	if err != nil {
+		return nil, fmt.Errorf("snapshotting manifests: %w", err)
+	}
+	snapshotManifests, err := NewManifestStore(snapshot.ManifestDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range manifests {
+		if !committed(manifest.MerkleRoot) {
+			continue
+		}
+		err = snapshotManifests.PutManifest(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("snapshotting manifests: %w", err)
+		}
+		info.Manifests++
+	}
+
+	pins := make(map[string]*Pin)
+	pinSet.mutex.Lock()
+	for hexRoot, pin := range pinSet.Pins {
+		pins[hexRoot] = &Pin{Expiry: pin.Expiry}
+	}
+	pinSet.mutex.Unlock()
+	for hexRoot := range pins {
+		merkleRoot, err := hex.DecodeString(hexRoot)
+		if err != nil || !committed(merkleRoot) {
+			delete(pins, hexRoot)
+		}
+	}
+	info.Pins = len(pins)
+	err = writeJSONFile(snapshot.PinsPath, pins)
+	if err != nil {
+		return nil, err
+	}
+
+	info.Files, err = hashSnapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return info, writeJSONFile(filepath.Join(dir, snapshotInfoFile), info)
+}
+
+// Function that writes the chain into a snapshot and returns its last block
+// Bolt stores are copied as a database; other stores are exported as NDJSON blocks, which the ndjson backend reads
+func snapshotChain(snapshot DataLayout, chainStore core.ChainStore, info *SnapshotInfo) (*core.Block, error) {
+	if boltStore, ok := chainStore.(*BoltChainStore); ok {
+		info.ChainFile = filepath.Base(snapshot.ChainBoltPath)
+		file, err := os.Create(snapshot.ChainBoltPath)
+		if err != nil {
+			return nil, err
+		}
+		head, err := boltStore.Backup(file)
+		return head, errors.Join(err, file.Close())
+	}
+
+	info.ChainFile = filepath.Base(snapshot.ChainNDJSONPath)
+	head, err := chainStore.Head()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(snapshot.ChainNDJSONPath)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	// Blocks are read up to the head found first, so blocks added while exporting are left out
+	for height := int64(0); height <= head.Index && err == nil; height++ {
+		var block *core.Block
+		block, err = chainStore.GetByHeight(height)
+		if err == nil {
+			err = encoder.Encode(block)
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	return head, errors.Join(err, file.Close())
+}
+
+// Function that writes the chunk index into a snapshot, along with a copy of every indexed chunk file if asked to
+func snapshotChunks(chunksDir string, chunkStore *ChunkStore, includeData bool, info *SnapshotInfo) error {
+	index := make(map[string]*IndexEntry)
+	chunkStore.mutex.Lock()
+	for hexHash, entry := range chunkStore.Index {
+		copied := *entry
+		index[hexHash] = &copied
+	}
+	chunkStore.mutex.Unlock()
+
+	err := os.MkdirAll(chunksDir, 0755)
+	if err != nil {
+		return err
+	}
+	if includeData {
+		for hexHash := range index {
+			err := copyFile(chunkStore.chunkPath(hexHash), filepath.Join(chunksDir, hexHash+chunkExtension))
+			if errors.Is(err, fs.ErrNotExist) {
+				// The chunk was garbage collected after the index was copied
+				delete(index, hexHash)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	info.Chunks = len(index)
+	return writeJSONFile(filepath.Join(chunksDir, indexFileName), index)
+}
+
+// Function that writes a value to a file as indented JSON
+func writeJSONFile(path string, value any) error {
+	jsonValue, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, jsonValue, 0644)
+}
+
+// Function that hashes every file in a snapshot so that it can later be checked for tampering
+func hashSnapshotFiles(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(relative)] = hex.EncodeToString(hash.Sum(nil))
+		return nil
+	})
+	return hashes, err
+}
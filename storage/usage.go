@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"blockchain-storage/core"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// UsageClass - Structure describing the chunks in one class of the chunk store's usage
+type UsageClass struct {
+	Chunks int   `json:"chunks"` // Number of chunks in the class
+	Bytes  int64 `json:"bytes"`  // Space taken up on disk by the chunks
+}
+
+// FileUsage - Structure describing the space taken up by a single file's chunks
+type FileUsage struct {
+	MerkleRoot string `json:"merkleRoot"` // Hex encoded Merkle root of the file
+	Alias      string `json:"alias"`      // Name the file was uploaded under
+	Chunks     int    `json:"chunks"`     // Number of the file's distinct chunks held locally
+	Bytes      int64  `json:"bytes"`      // Space taken up on disk by those chunks (shared chunks count towards each file)
+	Pinned     bool   `json:"pinned"`     // Whether the file is pinned with an unexpired lease
+}
+
+// UsageReport - Structure breaking down the space taken up by the chunk store
+// Every chunk is in exactly one class: pinned if a pinned file references it, cached if only files that are not
+// pinned reference it, and orphaned if no manifest held by the node references it
+type UsageReport struct {
+	Total    UsageClass  `json:"total"`    // Every chunk in the chunk store
+	Pinned   UsageClass  `json:"pinned"`   // Chunks of files that are pinned with an unexpired lease
+	Cached   UsageClass  `json:"cached"`   // Chunks only of files that are unpinned or whose lease has expired
+	Orphaned UsageClass  `json:"orphaned"` // Chunks no manifest references
+	Largest  []FileUsage `json:"largest"`  // Files taking up the most space, largest first
+}
+
+// Function that works out how the space taken up by the chunk store breaks down, listing the largest files
+func PlanUsage(chunkStore *ChunkStore, manifests []*core.Manifest, pinSet *PinSet, now time.Time, top int) *UsageReport {
+	report := &UsageReport{Largest: []FileUsage{}}
+
+	chunkStore.mutex.Lock()
+	defer chunkStore.mutex.Unlock()
+
+	// A chunk referenced by any pinned file is pinned, however many unpinned files also reference it
+	referenced := make(map[string]bool)
+	pinned := make(map[string]bool)
+	for _, manifest := range manifests {
+		file := FileUsage{
+			MerkleRoot: hex.EncodeToString(manifest.MerkleRoot),
+			Alias:      manifest.Alias,
+			Pinned:     pinSet.Status(manifest.MerkleRoot, now) == Pinned,
+		}
+		counted := make(map[string]bool)
+		for _, chunkHash := range manifest.ChunkHashes {
+			hexHash := hex.EncodeToString(chunkHash)
+			entry, found := chunkStore.Index[hexHash]
+			if !found || counted[hexHash] {
+				continue
+			}
+			counted[hexHash] = true
+			referenced[hexHash] = true
+			pinned[hexHash] = pinned[hexHash] || file.Pinned
+			file.Chunks++
+			file.Bytes += entry.StoredSize
+		}
+		if file.Chunks > 0 {
+			report.Largest = append(report.Largest, file)
+		}
+	}
+
+	for hexHash, entry := range chunkStore.Index {
+		class := &report.Orphaned
+		if pinned[hexHash] {
+			class = &report.Pinned
+		} else if referenced[hexHash] {
+			class = &report.Cached
+		}
+		class.Chunks++
+		class.Bytes += entry.StoredSize
+		report.Total.Chunks++
+		report.Total.Bytes += entry.StoredSize
+	}
+
+	// Ties are broken by Merkle root so that reports are stable between runs
+	sort.Slice(report.Largest, func(i, j int) bool {
+		if report.Largest[i].Bytes != report.Largest[j].Bytes {
+			return report.Largest[i].Bytes > report.Largest[j].Bytes
+		}
+		return report.Largest[i].MerkleRoot < report.Largest[j].MerkleRoot
+	})
+	if top >= 0 && len(report.Largest) > top {
+		report.Largest = report.Largest[:top]
+	}
+	return report
+}
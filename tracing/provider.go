@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"io"
+	"sync"
+	"time"
+)
+
+// SpanRecord - Structure describing a finished span as it is written by a FileProvider
+type SpanRecord struct {
+	TraceID      string         `json:"traceId"`                // Hex encoded ID of the trace the span belongs to
+	SpanID       string         `json:"spanId"`                 // Hex encoded ID of the span
+	ParentSpanID string         `json:"parentSpanId,omitempty"` // Hex encoded ID of the parent span (empty for a root span)
+	Name         string         `json:"name"`                   // Name of the work the span covers
+	Start        time.Time      `json:"start"`                  // Time the span started
+	End          time.Time      `json:"end"`                    // Time the span ended
+	Duration     time.Duration  `json:"duration"`               // Time between the start and end of the span
+	Attributes   map[string]any `json:"attributes,omitempty"`   // Attributes set on the span
+	Events       []EventRecord  `json:"events,omitempty"`       // Events that happened during the span, including errors
+	Status       string         `json:"status,omitempty"`       // Status of the span (Error if the work failed)
+	Description  string         `json:"description,omitempty"`  // Description of the status, such as the error message
+}
+
+// EventRecord - Structure describing an event recorded during a span
+type EventRecord struct {
+	Name       string         `json:"name"`                 // Name of the event
+	Time       time.Time      `json:"time"`                 // Time the event happened
+	Attributes map[string]any `json:"attributes,omitempty"` // Attributes of the event
+}
+
+// FileProvider - Structure of a tracer provider that writes every finished span to a writer as a line of JSON
+// It follows the OpenTelemetry tracing API, so the spans can be loaded into any tool that reads trace data as JSON
+type FileProvider struct {
+	embedded.TracerProvider
+	mutex  sync.Mutex
+	output *json.Encoder
+}
+
+// Function that creates a tracer provider writing finished spans to the given writer
+func NewFileProvider(w io.Writer) *FileProvider {
+	return &FileProvider{output: json.NewEncoder(w)}
+}
+
+// Function that returns a tracer whose spans are written by the provider
+func (provider *FileProvider) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return &fileTracer{provider: provider}
+}
+
+// Function that writes a finished span
+func (provider *FileProvider) write(record *SpanRecord) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	// Traces are diagnostics, so failing to write one must never interrupt the work being traced
+	_ = provider.output.Encode(record)
+}
+
+// Structure of a tracer that starts spans recorded by a FileProvider
+type fileTracer struct {
+	embedded.Tracer
+	provider *FileProvider
+}
+
+// Function that starts a span, continuing the trace of the span in the context unless a new root is asked for
+func (tracer *fileTracer) Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context,
+	trace.Span) {
+	config := trace.NewSpanStartConfig(options...)
+	parent := trace.SpanContextFromContext(ctx)
+	if config.NewRoot() {
+		parent = trace.SpanContext{}
+	}
+
+	spanConfig := trace.SpanContextConfig{TraceID: parent.TraceID(), TraceFlags: trace.FlagsSampled}
+	if !parent.IsValid() {
+		rand.Read(spanConfig.TraceID[:])
+	}
+	rand.Read(spanConfig.SpanID[:])
+
+	start := config.Timestamp()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	span := &fileSpan{
+		provider:    tracer.provider,
+		spanContext: trace.NewSpanContext(spanConfig),
+		record:      SpanRecord{Name: name, Start: start, Attributes: attributeMap(nil, config.Attributes())},
+	}
+	span.record.TraceID = span.spanContext.TraceID().String()
+	span.record.SpanID = span.spanContext.SpanID().String()
+	if parent.IsValid() {
+		span.record.ParentSpanID = parent.SpanID().String()
+	}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+// Structure of a span that is written by its provider once it ends
+type fileSpan struct {
+	embedded.Span
+	provider    *FileProvider
+	spanContext trace.SpanContext
+	mutex       sync.Mutex
+	record      SpanRecord
+	ended       bool
+}
+
+// Function that ends the span and writes it (only the first call has any effect)
+func (span *fileSpan) End(options ...trace.SpanEndOption) {
+	config := trace.NewSpanEndConfig(options...)
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.record.End = config.Timestamp()
+	if span.record.End.IsZero() {
+		span.record.End = time.Now()
+	}
+	span.record.Duration = span.record.End.Sub(span.record.Start)
+	record := span.record
+	span.mutex.Unlock()
+	span.provider.write(&record)
+}
+
+func (span *fileSpan) AddEvent(name string, options ...trace.EventOption) {
+	config := trace.NewEventConfig(options...)
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	if !span.ended {
+		event := EventRecord{Name: name, Time: config.Timestamp(), Attributes: attributeMap(nil, config.Attributes())}
+		span.record.Events = append(span.record.Events, event)
+	}
+}
+
+// Links are not recorded, as the node never starts spans from more than one cause
+func (span *fileSpan) AddLink(link trace.Link) {}
+
+func (span *fileSpan) IsRecording() bool {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	return !span.ended
+}
+
+func (span *fileSpan) RecordError(err error, options ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	options = append(options, trace.WithAttributes(attribute.String("exception.message", err.Error())))
+	span.AddEvent("exception", options...)
+}
+
+func (span *fileSpan) SpanContext() trace.SpanContext {
+	return span.spanContext
+}
+
+func (span *fileSpan) SetStatus(code codes.Code, description string) {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	if span.ended {
+		return
+	}
+	span.record.Status = code.String()
+	span.record.Description = ""
+	if code == codes.Error {
+		span.record.Description = description
+	}
+}
+
+func (span *fileSpan) SetName(name string) {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	span.record.Name = name
+}
+
+func (span *fileSpan) SetAttributes(attributes ...attribute.KeyValue) {
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+	if !span.ended {
+		span.record.Attributes = attributeMap(span.record.Attributes, attributes)
+	}
+}
+
+func (span *fileSpan) TracerProvider() trace.TracerProvider {
+	return span.provider
+}
+
+// Function that adds attributes to a map of attribute values, creating the map if needed
+func attributeMap(values map[string]any, attributes []attribute.KeyValue) map[string]any {
+	if len(attributes) == 0 {
+		return values
+	}
+	if values == nil {
+		values = make(map[string]any, len(attributes))
+	}
+	for _, keyValue := range attributes {
+		values[string(keyValue.Key)] = keyValue.Value.AsInterface()
+	}
+	return values
+}
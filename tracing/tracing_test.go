@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"testing"
+)
+
+// Tests that finished spans are written with their parent, attributes and status
+func TestFileProvider(t *testing.T) {
+	var output bytes.Buffer
+	otel.SetTracerProvider(NewFileProvider(&output))
+
+	ctx, parent := Start(context.Background(), "upload", attribute.String("file", "file.txt"))
+	_, child := Start(ctx, "upload.chunk")
+	child.SetAttributes(attribute.Int("chunks", 3))
+	End(child, errors.New("chunking failed"))
+	End(child, nil)
+	End(parent, nil)
+
+	var records []SpanRecord
+	decoder := json.NewDecoder(&output)
+	for decoder.More() {
+		var record SpanRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("FAIL: Failed to decode span record: %v", err)
+		}
+		records = append(records, record)
+	}
+	// Spans are written as they end, so the child comes first, and ending a span twice writes it once
+	if len(records) != 2 || records[0].Name != "upload.chunk" || records[1].Name != "upload" {
+		t.Fatalf("FAIL: Expected the child span followed by its parent, got %+v", records)
+	}
+	childRecord, parentRecord := records[0], records[1]
+	if childRecord.TraceID != parentRecord.TraceID || childRecord.ParentSpanID != parentRecord.SpanID ||
+		parentRecord.ParentSpanID != "" {
+		t.Errorf("FAIL: Expected the child span to belong to its parent's trace, got %+v", records)
+	}
+	if childRecord.Status != "Error" || childRecord.Description != "chunking failed" || len(childRecord.Events) != 1 {
+		t.Errorf("FAIL: Expected the child span to record its error, got %+v", childRecord)
+	}
+	if childRecord.Attributes["chunks"] != 3.0 || parentRecord.Attributes["file"] != "file.txt" {
+		t.Errorf("FAIL: Expected the spans to keep their attributes, got %+v", records)
+	}
+	if parentRecord.Status != "" || parentRecord.Duration < childRecord.Duration {
+		t.Errorf("FAIL: Expected the parent span to succeed and cover its child, got %+v", parentRecord)
+	}
+}
@@ -0,0 +1,73 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/tracing"
+	"context"
+	"encoding/hex"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// Function type asking a provider to sign the terms of a storage agreement for a file it holds, returning its signature
+type AgreeFunc func(ctx context.Context, provider string, agreement core.Agreement, chunkHashes [][]byte) ([]byte, error)
+
+// Function that records on the chain that the peers holding a replica of an uploaded file promised to store it
+// A single block records an agreement with each replica holder that signed its terms, running for the duration the
+// upload asked for from the block's timestamp. The block's hash is kept in the result so that the agreements are only
+// recorded once.
+func recordAgreements(ctx context.Context, env *Environment, params Params, result *Result) (err error) {
+	if params.AgreementDuration <= 0 || result.AgreementBlock != "" || len(result.Replicas) == 0 {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "upload.agreements", attribute.Int("providers", len(result.Replicas)))
+	defer func() { tracing.End(span, err) }()
+
+	if env.PeerID == "" || env.Agree == nil {
+		return errors.New("node has no peer ID to record agreements under")
+	}
+	merkleRoot, err := hex.DecodeString(result.MerkleRoot)
+	if err != nil {
+		return err
+	}
+	// A provider that does not sign the terms has promised nothing, so no agreement is recorded for it
+	var agreements []core.Agreement
+	for _, provider := range result.Replicas {
+		agreement := core.Agreement{
+			Uploader:    env.PeerID,
+			Provider:    provider,
+			MerkleRoot:  merkleRoot,
+			Duration:    uint64(params.AgreementDuration / time.Second),
+			Replication: uint64(params.Replicas),
+		}
+		agreement.Signature, err = env.Agree(ctx, provider, agreement, result.chunkHashes)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			agreements = append(agreements, agreement)
+		}
+	}
+	if len(agreements) == 0 {
+		return errors.New("no replica holder signed a storage agreement")
+	}
+	records := core.Records{Agreements: agreements}
+
+	// Wait for the mining slot, giving up if the upload is cancelled in the meantime
+	select {
+	case env.miningSlot <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	block, err := commitBlock(ctx, env, core.AgreementsRoot(params.HashAlgorithm, agreements), records, params)
+	<-env.miningSlot
+	if err != nil {
+		return err
+	}
+	if env.Broadcast != nil {
+		env.Broadcast(block)
+	}
+	result.AgreementBlock = hex.EncodeToString(block.Hash)
+	return nil
+}
@@ -0,0 +1,219 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/verify"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Error returned when a file is committed while the same file is already waiting to be committed
+var ErrAlreadyPending = errors.New("file is already waiting to be committed in a batch")
+
+// Greatest encoded size of the file records committed in one block, which leaves room for the rest of the block
+const maxBatchBytes = core.MaxBlockSize * 3 / 4
+
+// Batcher - Structure pooling pending uploads so that several files are committed in each mined block
+// Mining a block for every upload makes proof of work the main cost of uploading small files, so uploads arriving
+// within a window of each other are committed together in one block, under a single Merkle root of their records
+type Batcher struct {
+	env      *Environment
+	window   time.Duration       // Time the first upload of a batch waits for others to join it
+	maxSize  int                 // Greatest number of uploads committed in one block
+	maxBytes int                 // Greatest encoded size of the file records committed in one block
+	pending  chan *pendingUpload // Uploads waiting to join a batch
+}
+
+// Structure holding an upload waiting to be committed, which is told the outcome on done
+type pendingUpload struct {
+	record core.FileRecord
+	params Params
+	block  *core.Block // Block committing the upload, set before the outcome is sent
+	done   chan error
+}
+
+// Function that creates a batcher committing the uploads of an environment in batches of at most maxSize files
+func NewBatcher(env *Environment, window time.Duration, maxSize int) *Batcher {
+	return &Batcher{env: env, window: window, maxSize: max(maxSize, 1), maxBytes: maxBatchBytes,
+		pending: make(chan *pendingUpload)}
+}
+
+// Function that adds a file to the pool of pending uploads and waits for the block committing it
+// If the context is cancelled the file is no longer waited for, although it may still be committed if it has already
+// joined a batch
+func (batcher *Batcher) Commit(ctx context.Context, merkleRoot []byte, records core.Records, params Params) (*core.Block,
+	error) {
+	upload := &pendingUpload{
+		record: core.FileRecord{MerkleRoot: merkleRoot, Name: records.Name, PrevVersion: records.PrevVersion},
+		params: params,
+		done:   make(chan error, 1),
+	}
+	select {
+	case batcher.pending <- upload:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case err := <-upload.done:
+		return upload.block, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Function that commits pending uploads in batches until the context ends
+// A batch is mined once the window has passed since its first upload arrived, or as soon as it is full. A batch is full
+// once it holds the greatest number of uploads, or when the next upload's record would take it over the greatest
+// encoded size, in which case that upload starts the next batch. Files hashed with different algorithms are committed
+// in separate blocks, as a block's hash and Merkle root share one algorithm.
+func (batcher *Batcher) Run(ctx context.Context) {
+	var next *pendingUpload // Upload that did not fit in the previous batch
+	for {
+		if next == nil {
+			select {
+			case next = <-batcher.pending:
+			case <-ctx.Done():
+				return
+			}
+		}
+		batch := []*pendingUpload{next}
+		size := recordSize(next.record)
+		next = nil
+
+		timer := time.NewTimer(batcher.window)
+	collect:
+		for len(batch) < batcher.maxSize {
+			select {
+			case upload := <-batcher.pending:
+				if size+recordSize(upload.record) > batcher.maxBytes {
+					next = upload
+					break collect
+				}
+				batch = append(batch, upload)
+				size += recordSize(upload.record)
+			case <-timer.C:
+				break collect
+			case <-ctx.Done():
+				break collect
+			}
+		}
+		timer.Stop()
+
+		for _, uploads := range groupByAlgorithm(batch) {
+			batcher.commitBatch(ctx, uploads)
+		}
+	}
+}
+
+// Function that returns the size a file record takes up in a block's encoding
+func recordSize(record core.FileRecord) int {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return maxBatchBytes
+	}
+	// Records are separated by a comma in the list of a block's files
+	return len(encoded) + 1
+}
+
+// Function that splits a batch into the uploads hashed with each algorithm, keeping the order they arrived in
+func groupByAlgorithm(batch []*pendingUpload) [][]*pendingUpload {
+	var groups [][]*pendingUpload
+	positions := make(map[verify.HashAlgorithm]int)
+	for _, upload := range batch {
+		algorithm := upload.params.HashAlgorithm.Canonical()
+		position, found := positions[algorithm]
+		if !found {
+			position = len(groups)
+			positions[algorithm] = position
+			groups = append(groups, nil)
+		}
+		groups[position] = append(groups[position], upload)
+	}
+	return groups
+}
+
+// Function that mines and commits a block for uploads hashed with the same algorithm, telling each upload the outcome
+// Uploads whose records the chain would no longer accept fail on their own rather than failing the whole batch
+func (batcher *Batcher) commitBatch(ctx context.Context, uploads []*pendingUpload) {
+	var accepted []*pendingUpload
+	var files []core.FileRecord
+	seen := make(map[string]bool)
+	params := Params{HashAlgorithm: uploads[0].params.HashAlgorithm, Workers: 1, Retries: 1}
+	for _, upload := range uploads {
+		root := hex.EncodeToString(upload.record.MerkleRoot)
+		if seen[root] {
+			upload.done <- ErrAlreadyPending
+			continue
+		}
+		err := batcher.checkRecord(upload.record)
+		if err != nil {
+			upload.done <- err
+			continue
+		}
+		seen[root] = true
+		accepted = append(accepted, upload)
+		files = append(files, upload.record)
+		params.Workers = max(params.Workers, upload.params.Workers)
+		params.Retries = max(params.Retries, upload.params.Retries)
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	block, err := batcher.mine(ctx, files, params)
+	if err == nil && batcher.env.Broadcast != nil {
+		batcher.env.Broadcast(block)
+	}
+	for _, upload := range accepted {
+		upload.block = block
+		upload.done <- err
+	}
+}
+
+// Function that checks that the chain would accept a file's record on top of the current tip
+func (batcher *Batcher) checkRecord(record core.FileRecord) error {
+	probe := &core.Block{Index: int64(batcher.env.Chain.Length()),
+		UploaderPublicKey: batcher.env.IdentityKey.Public().(ed25519.PublicKey)}
+	probe.Name = record.Name
+	probe.PrevVersion = record.PrevVersion
+	err := batcher.env.Chain.CheckNameClaim(probe)
+	if err != nil {
+		return err
+	}
+	return batcher.env.Chain.CheckPrevVersion(probe)
+}
+
+// Function that mines and commits a block for the files of a batch once the mining slot is free
+// A single file is committed in a block of its own, so that batching only changes the blocks of busy nodes
+func (batcher *Batcher) mine(ctx context.Context, files []core.FileRecord, params Params) (*core.Block, error) {
+	env := batcher.env
+	select {
+	case env.miningSlot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-env.miningSlot }()
+
+	if len(files) == 1 {
+		records := core.Records{Name: files[0].Name, PrevVersion: files[0].PrevVersion}
+		return commitBlock(ctx, env, files[0].MerkleRoot, records, params)
+	}
+	workers := env.Resources.Scale(params.Workers)
+	block, err := core.MineBatchOnTip(ctx, env.Chain, files, params.HashAlgorithm, env.IdentityKey,
+		core.MiningDifficulty, workers, params.Retries, env.NewTips, env.Progress)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	err = addMinedBlock(env, block)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,112 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/storage"
+	"blockchain-storage/tracing"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+)
+
+// DeleteResult - Structure describing a file deleted by a tombstone block
+type DeleteResult struct {
+	MerkleRoot     string `json:"merkleRoot"`     // Hex encoded Merkle root of the deleted file
+	BlockHash      string `json:"blockHash"`      // Hex encoded hash of the tombstone block
+	BlockIndex     int64  `json:"blockIndex"`     // Index of the tombstone block
+	DeletedChunks  int    `json:"deletedChunks"`  // Number of the file's chunks deleted from the local chunk store
+	ReclaimedBytes int64  `json:"reclaimedBytes"` // Space on disk reclaimed by deleting the chunks
+}
+
+// Function that deletes a file by mining and committing a tombstone block, then deleting the file's local chunks
+// The tombstone is signed with the node's identity key, so only files the node uploaded can be deleted. The block
+// committing the file stays on the chain along with its tombstone, which records who deleted the file and when.
+func Delete(ctx context.Context, env *Environment, merkleRoot []byte, workers int, retries int) (result *DeleteResult,
+	err error) {
+	ctx, span := tracing.Start(ctx, "delete", attribute.String("file", hex.EncodeToString(merkleRoot)))
+	defer func() { tracing.End(span, err) }()
+
+	// A tombstone the chain would refuse fails the deletion before any mining is done
+	records := core.Records{Tombstone: merkleRoot}
+	tombstoneRoot := core.TombstoneRoot(merkleRoot)
+	err = env.Chain.CheckTombstone(&core.Block{Index: int64(env.Chain.Length()), MerkelRoot: tombstoneRoot,
+		UploaderPublicKey: env.IdentityKey.Public().(ed25519.PublicKey), Records: records})
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the mining slot, giving up if the deletion is cancelled in the meantime
+	select {
+	case env.miningSlot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	block, err := commitBlock(ctx, env, tombstoneRoot, records, Params{Workers: workers, Retries: retries})
+	<-env.miningSlot
+	if err != nil {
+		return nil, err
+	}
+	if env.Broadcast != nil {
+		env.Broadcast(block)
+	}
+
+	report, err := ApplyTombstone(env, merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{
+		MerkleRoot:     hex.EncodeToString(merkleRoot),
+		BlockHash:      hex.EncodeToString(block.Hash),
+		BlockIndex:     block.Index,
+		DeletedChunks:  len(report.Candidates),
+		ReclaimedBytes: report.ReclaimedBytes,
+	}, nil
+}
+
+// Function that honours the deletion of a file by unpinning it and deleting its chunks from the local chunk store
+// Chunks shared with files that were not deleted are kept. Without the file's manifest the node cannot tell which
+// chunks are the file's, but as nothing references them they are left for garbage collection.
+func ApplyTombstone(env *Environment, merkleRoot []byte) (*storage.GCReport, error) {
+	err := env.PinSet.Unpin(merkleRoot)
+	if err != nil {
+		return nil, err
+	}
+	if env.Access != nil {
+		err = env.Access.Release(merkleRoot)
+		if err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := env.ManifestStore.GetManifest(merkleRoot)
+	if errors.Is(err, os.ErrNotExist) {
+		return &storage.GCReport{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only files that have not been deleted themselves keep the chunks they share with the deleted file
+	manifests, err := env.ManifestStore.ListManifests()
+	if err != nil {
+		return nil, err
+	}
+	var kept []*core.Manifest
+	for _, other := range manifests {
+		_, err := env.Chain.FindTombstone(other.MerkleRoot)
+		if errors.Is(err, core.ErrBlockNotFound) {
+			kept = append(kept, other)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	report := storage.PlanDeletion(env.ChunkStore, manifest, kept)
+	err = env.ChunkStore.RunGC(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
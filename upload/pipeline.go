@@ -0,0 +1,390 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/keystore"
+	"blockchain-storage/resources"
+	"blockchain-storage/storage"
+	"blockchain-storage/tracing"
+	"blockchain-storage/verify"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Params - Structure holding the parameters of a single upload
+type Params struct {
+	FilePath string `json:"filePath"` // Path of the file to upload
+	Alias    string `json:"alias"`    // Name to upload the file under (defaults to the file name)
+	Workers  int    `json:"workers"`  // Number of concurrent block mining workers
+	Retries  int    `json:"retries"`  // Number of retries if mining fails
+	Replicas int    `json:"replicas"` // Number of peers that must acknowledge storing the file (0 does not place it)
+	Audit    bool   `json:"audit"`    // Whether every acknowledging peer must also pass an audit of its replica
+	Private  bool   `json:"private"`  // Whether to pad chunks to a uniform size and randomise the timing of placement
+
+	HashAlgorithm verify.HashAlgorithm `json:"hashAlgorithm,omitempty"` // Algorithm to hash the file with (SHA-256 if empty)
+	Tags          []string             `json:"tags,omitempty"`          // Labels to record in the file's manifest
+	ChunkSize     int64                `json:"chunkSize,omitempty"`     // Size of the file's chunks in bytes (0 picks it from the file size)
+	Force         bool                 `json:"force,omitempty"`         // Whether to upload straight away even if the network is unhealthy
+	Name          string               `json:"name,omitempty"`          // Name to claim for the file in the on-chain name registry
+	PrevVersion   string               `json:"prevVersion,omitempty"`   // Name or hex encoded Merkle root of the file's previous version
+	Encrypt       bool                 `json:"encrypt,omitempty"`       // Whether to encrypt the file so only peers granted access can read it
+	// Time every replica holder promises to store the file for, recorded in an agreement on the chain (0 records none)
+	AgreementDuration time.Duration `json:"agreementDuration,omitempty"`
+}
+
+// Result - Structure describing a completed upload
+type Result struct {
+	MerkleRoot string   `json:"merkleRoot"`     // Hex encoded Merkle root of the file
+	CID        string   `json:"cid"`            // CID identifying the file by its Merkle root
+	BlockHash  string   `json:"blockHash"`      // Hex encoded hash of the block committing the file
+	BlockIndex int64    `json:"blockIndex"`     // Index of the block committing the file
+	ChunkCount int      `json:"chunkCount"`     // Number of chunks the file was split into
+	Replicas   []string `json:"replicas"`       // Peers that acknowledged storing every chunk of the file
+	Audited    bool     `json:"audited"`        // Whether every acknowledging peer passed an audit of its replica
+	Name       string   `json:"name,omitempty"` // Name claimed for the file in the on-chain name registry
+	// Hex encoded Merkle root of the previous version of the file (empty if it is the first version)
+	PrevVersion string `json:"prevVersion,omitempty"`
+	// Hex encoded hash of the block recording the replica holders' storage agreements (empty if none were recorded)
+	AgreementBlock string `json:"agreementBlock,omitempty"`
+
+	chunkHashes [][]byte // Hashes of the file's chunks, needed to retry placement
+}
+
+// Environment - Structure holding everything an upload needs from the node it runs on
+type Environment struct {
+	Chain         *core.Blockchain          // The node's copy of the blockchain, which persists every committed block
+	IdentityKey   ed25519.PrivateKey        // Key used to sign uploaded blocks
+	ChunkStore    *storage.ChunkStore       // Store holding the local copy of every chunk
+	ManifestStore *storage.ManifestStore    // Store holding the manifest of every uploaded file
+	PinSet        *storage.PinSet           // Pins protecting uploaded files from garbage collection
+	NewTips       <-chan *core.Block        // Blocks received from the network, used to pre-empt mining (may be nil)
+	Broadcast     func(*core.Block)         // Function announcing newly mined blocks to the network (may be nil)
+	Provide       func(*core.Manifest)      // Function announcing that the node holds a file's chunks (may be nil)
+	Place         PlaceFunc                 // Function storing a file's chunks on peers (nil if the node is offline)
+	Audit         AuditFunc                 // Function auditing a peer's replica of a file (nil if the node is offline)
+	FindHolders   HolderFunc                // Function finding the peers holding a file (nil if the node is offline)
+	Agree         AgreeFunc                 // Function asking a replica holder to sign a storage agreement (nil if the node is offline)
+	Progress      func(core.MiningProgress) // Function reporting progress while the file's block is mined (may be nil)
+	Resources     *resources.Monitor        // Monitor throttling mining and audits when the node uses too much (may be nil)
+	Health        func() error              // Function explaining why the network is too unhealthy to upload to (may be nil)
+	PeerID        string                    // Peer ID of the node, recorded as the uploader in storage agreements (empty if offline)
+
+	// Function reporting each chunk of the file as it is stored, out of the file's total (may be nil)
+	ChunkProgress func(stored, total, size int)
+	// Access list restricting the chunks of encrypted files to peers granted access (nil if files cannot be encrypted)
+	Access *storage.AccessList
+	// Keystore holding the keys of encrypted files (nil if the keys are kept in their manifests)
+	Keys *keystore.Keystore
+	// Pool committing uploads in batches of several files per block (nil mines a block for every upload)
+	Batcher *Batcher
+
+	// Only one block can be mined at a time, as every block extends the same tip
+	miningSlot chan struct{}
+}
+
+// Function that creates the environment uploads run in
+func NewEnvironment(chain *core.Blockchain, identityKey ed25519.PrivateKey, chunkStore *storage.ChunkStore,
+	manifestStore *storage.ManifestStore, pinSet *storage.PinSet) *Environment {
+	return &Environment{
+		Chain:         chain,
+		IdentityKey:   identityKey,
+		ChunkStore:    chunkStore,
+		ManifestStore: manifestStore,
+		PinSet:        pinSet,
+		miningSlot:    make(chan struct{}, 1),
+	}
+}
+
+// Function that runs the full upload pipeline for a file: chunking, local storage, mining and committing the block
+// Cancelling the context aborts the upload at the next step (including while mining)
+// Every step is traced in its own span, so that the time taken by a slow upload can be attributed to one of them
+func Run(ctx context.Context, env *Environment, params Params) (result *Result, err error) {
+	ctx, span := tracing.Start(ctx, "upload", attribute.String("file", params.FilePath))
+	defer func() { tracing.End(span, err) }()
+
+	// Records that would get the mined block refused make the upload fail before doing any work
+	records, err := fileRecords(env, params)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, chunkSize, padding, err := chunkFile(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypted files are hashed as ciphertext, so storage nodes can check their chunks without being able to read them
+	var key []byte
+	var fileSize int64
+	if params.Encrypt {
+		if env.Access == nil {
+			return nil, errors.New("node cannot restrict access to encrypted files")
+		}
+		key, fileSize, err = encryptChunks(chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create merkle tree of file
+	_, merkleSpan := tracing.Start(ctx, "upload.merkle", attribute.Int("chunks", len(chunks)))
+	merkleTree, err := core.NewMerkleTreeWith(params.HashAlgorithm, chunks)
+	tracing.End(merkleSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep a local copy of every chunk so the file can be served and retrieved later
+	err = storeChunks(ctx, env, params, chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	// The alias defaults to the name of the file so that re-uploads of the same file become new versions
+	alias := params.Alias
+	if alias == "" {
+		alias = filepath.Base(params.FilePath)
+	}
+	manifest := core.NewManifest(alias, filepath.Base(params.FilePath), chunkSize, chunks, merkleTree)
+	manifest.AutoChunkSize = params.ChunkSize == 0
+	manifest.FileSize -= padding
+	manifest.Padding = padding
+	manifest.Tags = params.Tags
+	if env.ChunkStore.Compress {
+		manifest.Compression = "zstd"
+	}
+	if params.Encrypt {
+		manifest.FileSize = fileSize - padding
+		manifest.Encrypted = true
+		// A node with a keystore keeps the key there, encrypted, rather than in the plaintext manifest
+		if env.Keys != nil {
+			err = env.Keys.SetFileKey(manifest.MerkleRoot, key)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			manifest.Key = key
+		}
+	}
+
+	// A node pooling its uploads commits the file along with others in a batch, which also announces the block
+	var block *core.Block
+	if env.Batcher != nil {
+		_, batchSpan := tracing.Start(ctx, "upload.batch")
+		block, err = env.Batcher.Commit(ctx, merkleTree.Root.Hash, records, params)
+		tracing.End(batchSpan, err)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Wait for the mining slot, giving up if the upload is cancelled in the meantime
+		_, slotSpan := tracing.Start(ctx, "upload.mining_slot")
+		select {
+		case env.miningSlot <- struct{}{}:
+			tracing.End(slotSpan, nil)
+		case <-ctx.Done():
+			tracing.End(slotSpan, ctx.Err())
+			return nil, ctx.Err()
+		}
+		block, err = commitBlock(ctx, env, merkleTree.Root.Hash, records, params)
+		<-env.miningSlot
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only save the manifest once its Merkle root has been committed to the blockchain
+	err = env.ManifestStore.PutManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pin the uploaded file indefinitely so that its local chunks are never garbage collected
+	err = env.PinSet.Pin(merkleTree.Root.Hash, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	// Chunks of an encrypted file are only served to peers the uploader grants access to
+	if params.Encrypt {
+		err = env.Access.Restrict(merkleTree.Root.Hash, manifest.ChunkHashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if env.Broadcast != nil && env.Batcher == nil {
+		env.Broadcast(block)
+	}
+	if env.Provide != nil {
+		env.Provide(manifest)
+	}
+
+	cid, err := manifest.CID()
+	if err != nil {
+		return nil, err
+	}
+	result = &Result{
+		MerkleRoot:  hex.EncodeToString(merkleTree.Root.Hash),
+		CID:         cid,
+		BlockHash:   hex.EncodeToString(block.Hash),
+		BlockIndex:  block.Index,
+		ChunkCount:  len(chunks),
+		Name:        params.Name,
+		PrevVersion: hex.EncodeToString(records.PrevVersion),
+		chunkHashes: manifest.ChunkHashes,
+	}
+
+	// The upload only succeeds once enough peers hold the file, otherwise the result is returned along with ErrDegraded
+	return result, ensureRedundancy(ctx, env, params, result)
+}
+
+// Function that splits a file into chunks, sized for the file unless a chunk size was given
+// Chunks of private uploads are padded, and the chunk size and length of the padding are returned with the chunks
+func chunkFile(ctx context.Context, params Params) (chunks [][]byte, chunkSize int64, padding int64, err error) {
+	_, span := tracing.Start(ctx, "upload.chunk")
+	defer func() { tracing.End(span, err) }()
+
+	chunkSize = params.ChunkSize
+	if chunkSize == 0 {
+		var info os.FileInfo
+		info, err = os.Stat(params.FilePath)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		chunkSize = core.ChooseChunkSize(info.Size())
+	} else if err = core.ValidateChunkSize(chunkSize); err != nil {
+		return nil, 0, 0, err
+	}
+	chunks, err = core.ChunkFileBytes(params.FilePath, chunkSize)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	span.SetAttributes(attribute.Int64("chunkSize", chunkSize), attribute.Int("chunks", len(chunks)))
+
+	// Private uploads pad the last chunk so that every chunk seen on the network has the same size
+	if params.Private {
+		chunks, padding, err = core.PadChunks(chunks, chunkSize)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return chunks, chunkSize, padding, nil
+}
+
+// Function that encrypts every chunk of a file in place with a new key, returning the key and the file's original size
+func encryptChunks(chunks [][]byte) ([]byte, int64, error) {
+	key := make([]byte, storage.ChunkKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	for i, chunk := range chunks {
+		size += int64(len(chunk))
+		chunks[i], err = storage.EncryptChunk(key, chunk)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return key, size, nil
+}
+
+// Function that keeps a local copy of every chunk of a file in the chunk store
+func storeChunks(ctx context.Context, env *Environment, params Params, chunks [][]byte) (err error) {
+	ctx, span := tracing.Start(ctx, "upload.store_chunks", attribute.Int("chunks", len(chunks)))
+	defer func() { tracing.End(span, err) }()
+
+	for i, chunk := range chunks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		_, err := env.ChunkStore.PutChunkWith(params.HashAlgorithm, chunk)
+		if err != nil {
+			return err
+		}
+		if env.ChunkProgress != nil {
+			env.ChunkProgress(i+1, len(chunks), len(chunk))
+		}
+	}
+	return nil
+}
+
+// Function that builds the records of the block committing a file, checking that the chain would accept them
+// Claiming a name again without naming a previous version makes the file the next version of the one the name
+// currently points at
+func fileRecords(env *Environment, params Params) (core.Records, error) {
+	records := core.Records{Name: params.Name}
+	uploader := env.IdentityKey.Public().(ed25519.PublicKey)
+	if params.Name != "" {
+		err := env.Chain.CanClaimName(params.Name, uploader)
+		if err != nil {
+			return records, err
+		}
+		claim, err := env.Chain.ResolveName(params.Name, -1)
+		if err == nil && params.PrevVersion == "" {
+			records.PrevVersion = claim.MerkleRoot
+		}
+	}
+	if params.PrevVersion != "" {
+		prevVersion, err := env.Chain.ResolveFile(params.PrevVersion)
+		if err != nil {
+			return records, err
+		}
+		records.PrevVersion = prevVersion
+	}
+
+	// The block is only checked against the current tip, so its index just needs to be above every committed block
+	err := env.Chain.CheckPrevVersion(&core.Block{Index: int64(env.Chain.Length()), UploaderPublicKey: uploader,
+		Records: records})
+	return records, err
+}
+
+// Function that mines, signs and commits a block for a file (the caller must hold the mining slot)
+func commitBlock(ctx context.Context, env *Environment, merkleRoot []byte, records core.Records,
+	params Params) (*core.Block, error) {
+	// Create, mine and sign the block on top of the current tip, restarting if a competing block arrives
+	// Fewer workers are used while the node is over its resource ceilings
+	workers := env.Resources.Scale(params.Workers)
+	block, err := core.MineOnTip(ctx, env.Chain, merkleRoot, records, params.HashAlgorithm, env.IdentityKey, core.MiningDifficulty, workers, params.Retries, env.NewTips, env.Progress)
+	if err != nil {
+		// Report cancellation through the context's error so callers can tell it apart from a mining failure
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	err = addMinedBlock(env, block)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Function that adds a block that has successfully been mined to the blockchain, unless a block that arrived while it
+// was mined invalidates its records (such as by claiming the same name for another uploader)
+func addMinedBlock(env *Environment, block *core.Block) error {
+	err := env.Chain.CheckNameClaim(block)
+	if err != nil {
+		return err
+	}
+	err = env.Chain.CheckPrevVersion(block)
+	if err != nil {
+		return err
+	}
+	err = env.Chain.CheckTombstone(block)
+	if err != nil {
+		return err
+	}
+	err = env.Chain.CheckAgreements(block)
+	if err != nil {
+		return err
+	}
+	return env.Chain.AddBlock(block)
+}
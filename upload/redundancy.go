@@ -0,0 +1,97 @@
+package upload
+
+import (
+	"blockchain-storage/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// Longest random delay before each chunk of a private upload is placed, so its structure is not revealed by timing
+const privatePlacementJitter = 2 * time.Second
+
+// Error returned when an upload was committed but too few peers have acknowledged storing it
+var ErrDegraded = errors.New("upload has fewer acknowledged replicas than required")
+
+// Function type storing a file's chunks on peers other than the given holders
+// It returns the IDs of the peers that acknowledged storing every chunk, stopping once it has the number wanted. Each
+// chunk is sent after a random delay of up to jitter (0 sends them straight away).
+type PlaceFunc func(ctx context.Context, chunkHashes [][]byte, holders []string, wanted int, jitter time.Duration) ([]string, error)
+
+// Function type checking that a peer can still serve its replica of a file
+type AuditFunc func(ctx context.Context, holder string, chunkHashes [][]byte) error
+
+// DegradedError - Error describing how far an upload is from the redundancy it asked for
+type DegradedError struct {
+	Acknowledged int  // Number of peers that acknowledged storing the file
+	Required     int  // Number of acknowledgments the upload asked for
+	AuditPending bool // Whether the file still has to pass an audit round
+}
+
+func (err *DegradedError) Error() string {
+	if err.AuditPending && err.Acknowledged >= err.Required {
+		return fmt.Sprintf("upload is degraded: %d replicas acknowledged, waiting for an audit round to pass", err.Acknowledged)
+	}
+	return fmt.Sprintf("upload is degraded: %d of %d replicas acknowledged", err.Acknowledged, err.Required)
+}
+
+func (err *DegradedError) Unwrap() error {
+	return ErrDegraded
+}
+
+// Function that places a committed file on peers until the number of acknowledgments its upload asked for is reached
+// If an audit is required, every acknowledging peer is audited once enough of them hold the file, and peers that fail
+// are dropped so that placement is retried elsewhere. Progress is kept in the result so that it can be called again.
+func ensureRedundancy(ctx context.Context, env *Environment, params Params, result *Result) (err error) {
+	if params.Replicas <= 0 {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "upload.redundancy", attribute.Int("replicas", params.Replicas),
+		attribute.Bool("audit", params.Audit))
+	defer func() { tracing.End(span, err) }()
+
+	if missing := params.Replicas - len(result.Replicas); missing > 0 && env.Place != nil {
+		var jitter time.Duration
+		if params.Private {
+			jitter = privatePlacementJitter
+		}
+		holders, err := env.Place(ctx, result.chunkHashes, result.Replicas, missing, jitter)
+		result.Replicas = append(result.Replicas, holders...)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if params.Audit && !result.Audited && len(result.Replicas) >= params.Replicas && env.Audit != nil {
+		var passed []string
+		for _, holder := range result.Replicas {
+			// Audits can wait, so they are held back while the node is over its resource ceilings
+			if env.Resources.Wait(ctx) != nil {
+				break
+			}
+			if env.Audit(ctx, holder, result.chunkHashes) == nil {
+				passed = append(passed, holder)
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		result.Audited = len(passed) == len(result.Replicas)
+		result.Replicas = passed
+	}
+
+	if len(result.Replicas) < params.Replicas || (params.Audit && !result.Audited) {
+		return &DegradedError{Acknowledged: len(result.Replicas), Required: params.Replicas, AuditPending: params.Audit && !result.Audited}
+	}
+	// The replica holders' promises are only recorded once the upload has every replica it asked for
+	return recordAgreements(ctx, env, params, result)
+}
+
+// Function that copies a result, so that job snapshots are not changed by placement still running in the background
+func (result *Result) snapshot() *Result {
+	copied := *result
+	copied.Replicas = append([]string(nil), result.Replicas...)
+	return &copied
+}
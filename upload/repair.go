@@ -0,0 +1,117 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/tracing"
+	"context"
+	"encoding/hex"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// Function type finding the peers that announce holding a file's chunks
+type HolderFunc func(ctx context.Context, chunkHashes [][]byte) ([]string, error)
+
+// RepairReport - Structure describing a round of checking and restoring the replication of pinned files
+type RepairReport struct {
+	Checked  int      `json:"checked"`  // Number of pinned files whose replicas were checked
+	Repaired int      `json:"repaired"` // Number of files placed on new peers to make up for missing replicas
+	Placed   int      `json:"placed"`   // Number of new replicas placed across every file
+	Skipped  int      `json:"skipped"`  // Number of files that could not be repaired as the node does not hold every chunk
+	Degraded []string `json:"degraded"` // Hex encoded Merkle roots of files still short of the target after the round
+}
+
+// RepairManager - Structure restoring the replication of pinned files when the peers holding them disappear
+// Holders are found through the DHT and each is probed with an audit, so that only peers that can still serve the
+// file count towards its replication. Files short of the target are placed on new peers from the local chunk store.
+type RepairManager struct {
+	env      *Environment
+	replicas int // Number of live replicas every pinned file should have
+}
+
+// Function that creates a repair manager keeping every pinned file on the given number of peers
+func NewRepairManager(env *Environment, replicas int) *RepairManager {
+	return &RepairManager{env: env, replicas: replicas}
+}
+
+// Function that checks the replication of every pinned file once, placing files that are short on new peers
+func (manager *RepairManager) RepairOnce(ctx context.Context) (report *RepairReport, err error) {
+	ctx, span := tracing.Start(ctx, "upload.repair", attribute.Int("replicas", manager.replicas))
+	defer func() { tracing.End(span, err) }()
+
+	env := manager.env
+	report = &RepairReport{Degraded: []string{}}
+	if env.Place == nil || env.FindHolders == nil || manager.replicas <= 0 {
+		return report, errors.New("node cannot place files on peers")
+	}
+	for _, merkleRoot := range env.PinSet.Pinned(time.Now()) {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		manifest, err := env.ManifestStore.GetManifest(merkleRoot)
+		if err != nil {
+			continue
+		}
+		// Deleted files are no longer meant to be held by anyone
+		if _, err := env.Chain.FindTombstone(merkleRoot); !errors.Is(err, core.ErrBlockNotFound) {
+			continue
+		}
+		report.Checked++
+		if !manager.holdsFile(manifest) {
+			report.Skipped++
+			continue
+		}
+
+		live, err := manager.liveHolders(ctx, manifest)
+		if err != nil {
+			return report, err
+		}
+		if missing := manager.replicas - len(live); missing > 0 {
+			placed, _ := env.Place(ctx, manifest.ChunkHashes, live, missing, 0)
+			if len(placed) > 0 {
+				report.Repaired++
+				report.Placed += len(placed)
+			}
+			if len(live)+len(placed) < manager.replicas {
+				report.Degraded = append(report.Degraded, hex.EncodeToString(merkleRoot))
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("checked", report.Checked), attribute.Int("placed", report.Placed))
+	return report, nil
+}
+
+// Function that checks whether every chunk of a file is held in the local chunk store, so that it can be placed
+func (manager *RepairManager) holdsFile(manifest *core.Manifest) bool {
+	for _, chunkHash := range manifest.ChunkHashes {
+		if !manager.env.ChunkStore.HasChunk(chunkHash) {
+			return false
+		}
+	}
+	return true
+}
+
+// Function that finds the peers holding a file and returns those that pass a probe of their replica
+// Without an audit function every peer announcing the file is taken to hold it
+func (manager *RepairManager) liveHolders(ctx context.Context, manifest *core.Manifest) ([]string, error) {
+	env := manager.env
+	holders, err := env.FindHolders(ctx, manifest.ChunkHashes)
+	if err != nil {
+		return nil, err
+	}
+	if env.Audit == nil {
+		return holders, nil
+	}
+	live := []string{}
+	for _, holder := range holders {
+		// Probes can wait, so they are held back while the node is over its resource ceilings
+		if err := env.Resources.Wait(ctx); err != nil {
+			return nil, err
+		}
+		if env.Audit(ctx, holder, manifest.ChunkHashes) == nil {
+			live = append(live, holder)
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,480 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Maximum number of uploads that can be waiting for a worker at once
+const maxQueuedJobs = 100
+
+// Time waited between attempts to place a degraded upload on more peers
+const placementRetryInterval = 30 * time.Second
+
+// Time waited between checks of the network's health while uploads are deferred
+const healthCheckInterval = 15 * time.Second
+
+// JobStatus - State of an upload job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"    // Waiting for a worker
+	JobDeferred  JobStatus = "deferred"  // Waiting for the network to become healthy enough to upload to
+	JobRunning   JobStatus = "running"   // Being processed by a worker
+	JobDegraded  JobStatus = "degraded"  // Committed, but still being placed on enough peers
+	JobCompleted JobStatus = "completed" // Finished successfully
+	JobFailed    JobStatus = "failed"    // Finished with an error
+	JobCancelled JobStatus = "cancelled" // Cancelled before it finished
+)
+
+// Function to check whether a job status is final
+func (status JobStatus) IsFinal() bool {
+	return status == JobCompleted || status == JobFailed || status == JobCancelled
+}
+
+// JobInfo - Snapshot of the state of an upload job
+type JobInfo struct {
+	ID      string    `json:"id"`      // Identifier of the job
+	Params  Params    `json:"params"`  // Parameters the upload was submitted with
+	Status  JobStatus `json:"status"`  // Current state of the job
+	Error   string    `json:"error"`   // Error the job failed with, or why it is degraded or deferred (empty otherwise)
+	Result  *Result   `json:"result"`  // Result of the upload (nil unless the job completed or is degraded)
+	Created time.Time `json:"created"` // Time the job was submitted
+	Updated time.Time `json:"updated"` // Time the job's state last changed
+}
+
+// Structure holding an upload job along with what is needed to cancel and await it
+type job struct {
+	info   JobInfo
+	cancel context.CancelFunc // Cancels the upload while it is running (nil until it starts)
+	done   chan struct{}      // Closed once the job reaches a final status
+}
+
+// QueueStatus - Snapshot of the state of the upload queue as a whole
+type QueueStatus struct {
+	Workers   int               `json:"workers"`             // Number of background workers running uploads
+	Capacity  int               `json:"capacity"`            // Maximum number of uploads that can wait for a worker
+	Jobs      map[JobStatus]int `json:"jobs"`                // Number of jobs in each status
+	Persisted bool              `json:"persisted"`           // Whether the state of the jobs is saved across restarts
+	SaveError string            `json:"saveError,omitempty"` // Why the state of the jobs was last not saved (if it was not)
+}
+
+// Scheduler - Structure that runs submitted uploads on a pool of background workers
+type Scheduler struct {
+	env     *Environment
+	jobs    map[string]*job
+	queue   chan *job
+	mutex   sync.Mutex
+	workers int    // Number of background workers
+	path    string // JSON file the state of every job is saved to (empty if it is not saved)
+	saveErr error  // Error the state of the jobs was last saved with (nil if it was saved)
+
+	retryInterval  time.Duration // Time waited between attempts to place degraded uploads
+	healthInterval time.Duration // Time waited between checks of the network's health while uploads are deferred
+}
+
+// Error returned when a job ID does not match any submitted job
+var ErrJobNotFound = errors.New("no upload job with matching ID")
+
+// Function that creates an upload scheduler and starts the given number of background workers
+func NewScheduler(env *Environment, concurrency int) *Scheduler {
+	scheduler := newScheduler(env, concurrency)
+	scheduler.start()
+	return scheduler
+}
+
+// Function that creates an upload scheduler whose jobs are saved to a file, restoring the jobs saved there by a
+// previous run before starting the given number of background workers
+// Jobs that had not finished are queued again, so an upload interrupted while it was running starts over. Degraded
+// jobs carry on being placed, and finished jobs are kept so that they can still be listed.
+func NewPersistentScheduler(env *Environment, concurrency int, path string) (*Scheduler, error) {
+	scheduler := newScheduler(env, concurrency)
+	scheduler.path = path
+	jsonJobs, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var infos []JobInfo
+		err = json.Unmarshal(jsonJobs, &infos)
+		if err != nil {
+			return nil, err
+		}
+		scheduler.restore(infos)
+	}
+	scheduler.start()
+	return scheduler, nil
+}
+
+// Function that creates an upload scheduler without starting its workers
+func newScheduler(env *Environment, concurrency int) *Scheduler {
+	return &Scheduler{
+		env:            env,
+		jobs:           make(map[string]*job),
+		queue:          make(chan *job, maxQueuedJobs),
+		workers:        concurrency,
+		retryInterval:  placementRetryInterval,
+		healthInterval: healthCheckInterval,
+	}
+}
+
+// Function that starts the scheduler's background workers
+func (scheduler *Scheduler) start() {
+	for i := 0; i < scheduler.workers; i++ {
+		go scheduler.worker()
+	}
+}
+
+// Function that takes back the jobs saved by a previous run, oldest first so that they are queued in their old order
+func (scheduler *Scheduler) restore(infos []JobInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for _, info := range infos {
+		restoredJob := &job{info: info, done: make(chan struct{})}
+		scheduler.jobs[info.ID] = restoredJob
+		switch {
+		case info.Status.IsFinal():
+			close(restoredJob.done)
+		case info.Status == JobDegraded:
+			scheduler.resumePlacement(restoredJob)
+		default:
+			restoredJob.info.Status = JobQueued
+			restoredJob.info.Error = ""
+			restoredJob.info.Updated = time.Now()
+			select {
+			case scheduler.queue <- restoredJob:
+			default:
+				scheduler.finish(restoredJob, JobFailed, nil, errors.New("upload queue is full"))
+			}
+		}
+	}
+	scheduler.save()
+}
+
+// Function that carries on placing a restored degraded job, whose chunk hashes are read back from its file's manifest
+// (the caller must hold the scheduler's mutex)
+func (scheduler *Scheduler) resumePlacement(degradedJob *job) {
+	result := degradedJob.info.Result
+	var err error
+	if result == nil {
+		err = errors.New("degraded upload has no result")
+	} else {
+		var merkleRoot []byte
+		merkleRoot, err = hex.DecodeString(result.MerkleRoot)
+		if err == nil {
+			var manifest *core.Manifest
+			manifest, err = scheduler.env.ManifestStore.GetManifest(merkleRoot)
+			if err == nil {
+				result.chunkHashes = manifest.ChunkHashes
+			}
+		}
+	}
+	if err != nil {
+		scheduler.finish(degradedJob, JobFailed, result, err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	degradedJob.cancel = cancel
+	go scheduler.retryPlacement(ctx, cancel, degradedJob, result)
+}
+
+// Function that returns a snapshot of the state of the upload queue
+func (scheduler *Scheduler) Status() QueueStatus {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	status := QueueStatus{Workers: scheduler.workers, Capacity: maxQueuedJobs, Jobs: make(map[JobStatus]int),
+		Persisted: scheduler.path != ""}
+	for _, existingJob := range scheduler.jobs {
+		status.Jobs[existingJob.info.Status]++
+	}
+	if scheduler.saveErr != nil {
+		status.SaveError = scheduler.saveErr.Error()
+	}
+	return status
+}
+
+// Function that submits an upload and immediately returns the ID of its job
+func (scheduler *Scheduler) Submit(params Params) (string, error) {
+	// Generate a random identifier for the job
+	idBytes := make([]byte, 8)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	newJob := &job{
+		info: JobInfo{ID: hex.EncodeToString(idBytes), Params: params, Status: JobQueued, Created: now, Updated: now},
+		done: make(chan struct{}),
+	}
+
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	select {
+	case scheduler.queue <- newJob:
+	default:
+		return "", errors.New("upload queue is full")
+	}
+	scheduler.jobs[newJob.info.ID] = newJob
+	scheduler.save()
+	return newJob.info.ID, nil
+}
+
+// Function that returns a snapshot of a job's state
+func (scheduler *Scheduler) Get(id string) (JobInfo, error) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	foundJob, found := scheduler.jobs[id]
+	if !found {
+		return JobInfo{}, ErrJobNotFound
+	}
+	return foundJob.info, nil
+}
+
+// Function that returns a snapshot of every job, oldest first
+func (scheduler *Scheduler) List() []JobInfo {
+	scheduler.mutex.Lock()
+	var jobs []JobInfo
+	for _, existingJob := range scheduler.jobs {
+		jobs = append(jobs, existingJob.info)
+	}
+	scheduler.mutex.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// Function that cancels a job. Queued jobs are cancelled immediately, while deferred and running jobs stop at their
+// next step. Cancelling a degraded job stops placing it, but its block stays committed
+func (scheduler *Scheduler) Cancel(id string) error {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+
+	foundJob, found := scheduler.jobs[id]
+	if !found {
+		return ErrJobNotFound
+	}
+	switch foundJob.info.Status {
+	case JobQueued:
+		// The worker that eventually takes the job off the queue will skip it
+		scheduler.finish(foundJob, JobCancelled, nil, nil)
+	case JobDeferred, JobRunning, JobDegraded:
+		foundJob.cancel()
+	default:
+		return errors.New("upload job has already finished")
+	}
+	return nil
+}
+
+// Function that waits until a job reaches a final status (or the context is cancelled) and returns its state
+func (scheduler *Scheduler) Await(ctx context.Context, id string) (JobInfo, error) {
+	scheduler.mutex.Lock()
+	foundJob, found := scheduler.jobs[id]
+	scheduler.mutex.Unlock()
+	if !found {
+		return JobInfo{}, ErrJobNotFound
+	}
+
+	select {
+	case <-foundJob.done:
+		return scheduler.Get(id)
+	case <-ctx.Done():
+		return JobInfo{}, ctx.Err()
+	}
+}
+
+// Function run by every background worker, processing jobs from the queue one at a time
+func (scheduler *Scheduler) worker() {
+	for nextJob := range scheduler.queue {
+		// Skip jobs that were cancelled while they were queued, otherwise mark the job as running
+		ctx, cancel := context.WithCancel(context.Background())
+		scheduler.mutex.Lock()
+		if nextJob.info.Status != JobQueued {
+			scheduler.mutex.Unlock()
+			cancel()
+			continue
+		}
+		nextJob.cancel = cancel
+		nextJob.info.Updated = time.Now()
+
+		// Uploads are deferred while the network is unhealthy, as their chunks could not be placed anywhere
+		if err := scheduler.checkHealth(nextJob.info.Params); err != nil {
+			nextJob.info.Status = JobDeferred
+			nextJob.info.Error = err.Error()
+			scheduler.save()
+			scheduler.mutex.Unlock()
+			go scheduler.deferUntilHealthy(ctx, cancel, nextJob)
+			continue
+		}
+		nextJob.info.Status = JobRunning
+		scheduler.save()
+		scheduler.mutex.Unlock()
+
+		result, err := Run(ctx, scheduler.env, nextJob.info.Params)
+
+		scheduler.mutex.Lock()
+		switch {
+		case err == nil:
+			scheduler.finish(nextJob, JobCompleted, result.snapshot(), nil)
+		case ctx.Err() != nil:
+			scheduler.finish(nextJob, JobCancelled, nil, nil)
+		case errors.Is(err, ErrDegraded):
+			// Keep placing the file in the background so that the worker can move on to the next upload
+			nextJob.info.Status = JobDegraded
+			nextJob.info.Result = result.snapshot()
+			nextJob.info.Error = err.Error()
+			nextJob.info.Updated = time.Now()
+			scheduler.save()
+			scheduler.mutex.Unlock()
+			go scheduler.retryPlacement(ctx, cancel, nextJob, result)
+			continue
+		default:
+			scheduler.finish(nextJob, JobFailed, nil, err)
+		}
+		scheduler.mutex.Unlock()
+		cancel()
+	}
+}
+
+// Function that checks whether the network is healthy enough for an upload, unless the upload is forced
+func (scheduler *Scheduler) checkHealth(params Params) error {
+	if params.Force || scheduler.env.Health == nil {
+		return nil
+	}
+	return scheduler.env.Health()
+}
+
+// Function that waits until the network is healthy enough for a deferred upload and then queues it again
+func (scheduler *Scheduler) deferUntilHealthy(ctx context.Context, cancel context.CancelFunc, deferredJob *job) {
+	defer cancel()
+	for {
+		select {
+		case <-time.After(scheduler.healthInterval):
+		case <-ctx.Done():
+		}
+
+		var err error
+		if ctx.Err() == nil {
+			err = scheduler.checkHealth(deferredJob.info.Params)
+		}
+
+		scheduler.mutex.Lock()
+		switch {
+		case ctx.Err() != nil:
+			scheduler.finish(deferredJob, JobCancelled, nil, nil)
+		case err != nil:
+			deferredJob.info.Error = err.Error()
+			deferredJob.info.Updated = time.Now()
+			scheduler.mutex.Unlock()
+			continue
+		default:
+			deferredJob.info.Status = JobQueued
+			deferredJob.info.Error = ""
+			deferredJob.info.Updated = time.Now()
+			scheduler.save()
+			select {
+			case scheduler.queue <- deferredJob:
+			default:
+				scheduler.finish(deferredJob, JobFailed, nil, errors.New("upload queue is full"))
+			}
+		}
+		scheduler.mutex.Unlock()
+		return
+	}
+}
+
+// Function that keeps retrying placement of a degraded upload until it has enough replicas or is cancelled
+func (scheduler *Scheduler) retryPlacement(ctx context.Context, cancel context.CancelFunc, degradedJob *job, result *Result) {
+	defer cancel()
+	for {
+		select {
+		case <-time.After(scheduler.retryInterval):
+		case <-ctx.Done():
+		}
+
+		var err error
+		if ctx.Err() == nil {
+			err = ensureRedundancy(ctx, scheduler.env, degradedJob.info.Params, result)
+		}
+
+		scheduler.mutex.Lock()
+		switch {
+		case ctx.Err() != nil:
+			scheduler.finish(degradedJob, JobCancelled, result.snapshot(), nil)
+		case err == nil:
+			scheduler.finish(degradedJob, JobCompleted, result.snapshot(), nil)
+		default:
+			degradedJob.info.Result = result.snapshot()
+			degradedJob.info.Error = err.Error()
+			degradedJob.info.Updated = time.Now()
+			scheduler.save()
+			scheduler.mutex.Unlock()
+			continue
+		}
+		scheduler.mutex.Unlock()
+		return
+	}
+}
+
+// Function that moves a job to a final status (the caller must hold the scheduler's mutex)
+func (scheduler *Scheduler) finish(finishedJob *job, status JobStatus, result *Result, err error) {
+	finishedJob.info.Status = status
+	finishedJob.info.Result = result
+	finishedJob.info.Error = ""
+	if err != nil {
+		finishedJob.info.Error = err.Error()
+	}
+	finishedJob.info.Updated = time.Now()
+	close(finishedJob.done)
+	scheduler.save()
+}
+
+// Function that saves the state of every job to the scheduler's file, if it has one, replacing the file in one step
+// so that a crash never leaves it half written (the caller must hold the scheduler's mutex)
+// A failed save is reported in the queue's status rather than failing the job whose change triggered it.
+func (scheduler *Scheduler) save() {
+	if scheduler.path == "" {
+		return
+	}
+	infos := make([]JobInfo, 0, len(scheduler.jobs))
+	for _, existingJob := range scheduler.jobs {
+		infos = append(infos, existingJob.info)
+	}
+	scheduler.saveErr = writeJobs(scheduler.path, infos)
+}
+
+// Function that writes the state of jobs to a file through a temporary file in the same directory
+func writeJobs(path string, infos []JobInfo) error {
+	jsonJobs, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	_, err = tempFile.Write(jsonJobs)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
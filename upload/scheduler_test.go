@@ -0,0 +1,460 @@
+package upload
+
+import (
+	"blockchain-storage/core"
+	"blockchain-storage/keystore"
+	"blockchain-storage/storage"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Function that creates an upload environment with a genesis-only blockchain in a temporary directory
+func newTestEnvironment(t *testing.T) *Environment {
+	dir := t.TempDir()
+	chainStore, _ := storage.NewNDJSONChainStore(filepath.Join(dir, "blocks.ndjson"))
+	blockchain := core.NewBlockchain(chainStore)
+	blockchain.AddBlock(&core.Block{Index: 0, Hash: []byte("genesis_hash"), MerkelRoot: []byte("genesis_merkel")})
+
+	_, identityKey, _ := ed25519.GenerateKey(rand.Reader)
+	chunkStore, _ := storage.NewChunkStore(filepath.Join(dir, "chunks"))
+	manifestStore, _ := storage.NewManifestStore(filepath.Join(dir, "manifests"))
+	pinSet, _ := storage.NewPinSet(filepath.Join(dir, "pins.json"))
+	return NewEnvironment(blockchain, identityKey, chunkStore, manifestStore, pinSet)
+}
+
+// Function that writes a small file to upload in a temporary directory
+func newTestFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(path, []byte(contents), 0644)
+	return path
+}
+
+// Tests that a submitted upload runs in the background and can be awaited
+func TestScheduler_SubmitAwait(t *testing.T) {
+	env := newTestEnvironment(t)
+	scheduler := NewScheduler(env, 2)
+
+	var ids []string
+	for _, contents := range []string{"first file", "second file"} {
+		id, err := scheduler.Submit(Params{FilePath: newTestFile(t, contents), Workers: 2, Retries: 1})
+		if err != nil {
+			t.Fatalf("Submit() failed with error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+		info, err := scheduler.Await(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Await() failed with error: %v", err)
+		}
+		if info.Status != JobCompleted {
+			t.Fatalf("FAIL: Upload job finished with status %s and error %s", info.Status, info.Error)
+		}
+	}
+
+	// Both uploads must have been committed in separate blocks on top of each other
+	if env.Chain.Length() != 3 {
+		t.Errorf("FAIL: Expected 3 blocks after two uploads, got %d", env.Chain.Length())
+	}
+	if len(scheduler.List()) != 2 {
+		t.Errorf("FAIL: List() did not return both jobs")
+	}
+}
+
+// Tests that an upload can be cancelled while it is waiting to mine
+func TestScheduler_Cancel(t *testing.T) {
+	env := newTestEnvironment(t)
+	scheduler := NewScheduler(env, 1)
+
+	// Hold the mining slot so that the upload cannot finish before it is cancelled
+	env.miningSlot <- struct{}{}
+	id, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "contents"), Workers: 1, Retries: 1})
+
+	// Wait until the job has been picked up by the worker before cancelling it
+	for {
+		info, _ := scheduler.Get(id)
+		if info.Status == JobRunning {
+			break
+		}
+	}
+	if err := scheduler.Cancel(id); err != nil {
+		t.Fatalf("Cancel() failed with error: %v", err)
+	}
+
+	info, _ := scheduler.Await(context.Background(), id)
+	if info.Status != JobCancelled {
+		t.Errorf("FAIL: Expected a cancelled job, got status %s", info.Status)
+	}
+	if env.Chain.Length() != 1 {
+		t.Errorf("FAIL: A cancelled upload added a block to the blockchain")
+	}
+	if scheduler.Cancel(id) == nil {
+		t.Errorf("FAIL: Cancel() succeeded for a job that had already finished")
+	}
+}
+
+// Tests that an upload without enough acknowledged replicas is degraded until placement succeeds
+func TestScheduler_Degraded(t *testing.T) {
+	env := newTestEnvironment(t)
+	var mutex sync.Mutex
+	peers := []string{}
+	env.Place = func(ctx context.Context, chunkHashes [][]byte, holders []string, wanted int, jitter time.Duration) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if len(peers) > wanted {
+			return peers[:wanted], nil
+		}
+		return peers, nil
+	}
+	audited := 0
+	env.Audit = func(ctx context.Context, holder string, chunkHashes [][]byte) error {
+		audited++
+		return nil
+	}
+	scheduler := NewScheduler(env, 1)
+	scheduler.retryInterval = 10 * time.Millisecond
+
+	id, err := scheduler.Submit(Params{FilePath: newTestFile(t, "file"), Workers: 2, Retries: 1, Replicas: 2, Audit: true})
+	if err != nil {
+		t.Fatalf("Submit() failed with error: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, _ := scheduler.Get(id)
+		if info.Status == JobDegraded {
+			if info.Result == nil || info.Error == "" {
+				t.Errorf("FAIL: Degraded job does not describe its result and why it is degraded")
+			}
+			break
+		}
+		if info.Status.IsFinal() || time.Now().After(deadline) {
+			t.Fatalf("FAIL: Expected the upload to be degraded with no peers, got %s", info.Status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Peers only become available after the upload was committed, which the retries should pick up
+	mutex.Lock()
+	peers = []string{"peer1", "peer2", "peer3"}
+	mutex.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := scheduler.Await(ctx, id)
+	if err != nil {
+		t.Fatalf("Await() failed with error: %v", err)
+	}
+	if info.Status != JobCompleted || len(info.Result.Replicas) != 2 || !info.Result.Audited || info.Error != "" {
+		t.Errorf("FAIL: Expected the upload to complete with 2 audited replicas, got %s with %v", info.Status, info.Result)
+	}
+	if audited != 2 {
+		t.Errorf("FAIL: Expected both replicas to be audited once, got %d audits", audited)
+	}
+}
+
+// Tests that uploads are deferred while the network is unhealthy unless they are forced
+func TestScheduler_Deferred(t *testing.T) {
+	env := newTestEnvironment(t)
+	var mutex sync.Mutex
+	healthErr := errors.New("no storage peers")
+	env.Health = func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return healthErr
+	}
+	scheduler := NewScheduler(env, 1)
+	scheduler.healthInterval = 10 * time.Millisecond
+
+	id, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "deferred"), Workers: 2, Retries: 1})
+	cancelledID, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "cancelled"), Workers: 2, Retries: 1})
+	forcedID, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "forced"), Workers: 2, Retries: 1, Force: true})
+
+	// A forced upload runs straight away, even behind deferred ones
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, err := scheduler.Await(ctx, forcedID)
+	if err != nil || info.Status != JobCompleted {
+		t.Fatalf("FAIL: Expected the forced upload to complete, got %s (%v)", info.Status, err)
+	}
+	info, _ = scheduler.Get(id)
+	if info.Status != JobDeferred || info.Error != healthErr.Error() {
+		t.Fatalf("FAIL: Expected the upload to be deferred with the reason, got %s (%s)", info.Status, info.Error)
+	}
+	if err := scheduler.Cancel(cancelledID); err != nil {
+		t.Fatalf("Cancel() failed with error: %v", err)
+	}
+
+	// Once the network is healthy the deferred upload runs
+	mutex.Lock()
+	healthErr = nil
+	mutex.Unlock()
+	info, err = scheduler.Await(ctx, id)
+	if err != nil || info.Status != JobCompleted || info.Error != "" {
+		t.Errorf("FAIL: Expected the deferred upload to complete, got %s (%v)", info.Status, err)
+	}
+	info, _ = scheduler.Await(ctx, cancelledID)
+	if info.Status != JobCancelled {
+		t.Errorf("FAIL: Expected the cancelled deferred upload to stay cancelled, got %s", info.Status)
+	}
+}
+
+// Tests that the jobs of a persistent scheduler are restored after a restart, with unfinished jobs queued again
+func TestScheduler_Persistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads.json")
+	env := newTestEnvironment(t)
+	env.Health = func() error { return errors.New("no storage peers") }
+	scheduler, err := NewPersistentScheduler(env, 1, path)
+	if err != nil {
+		t.Fatalf("NewPersistentScheduler() failed with error: %v", err)
+	}
+
+	deferredID, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "deferred"), Workers: 2, Retries: 1})
+	forcedID, _ := scheduler.Submit(Params{FilePath: newTestFile(t, "forced"), Workers: 2, Retries: 1, Force: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if info, err := scheduler.Await(ctx, forcedID); err != nil || info.Status != JobCompleted {
+		t.Fatalf("FAIL: Expected the forced upload to complete, got %s (%v)", info.Status, err)
+	}
+	status := scheduler.Status()
+	if !status.Persisted || status.SaveError != "" || status.Workers != 1 || status.Jobs[JobDeferred] != 1 ||
+		status.Jobs[JobCompleted] != 1 {
+		t.Errorf("FAIL: Unexpected queue status %+v", status)
+	}
+
+	// A new scheduler reading the same file stands in for the node restarting, now with a healthy network
+	restarted, err := NewPersistentScheduler(newTestEnvironment(t), 1, path)
+	if err != nil {
+		t.Fatalf("NewPersistentScheduler() failed with error: %v", err)
+	}
+	info, err := restarted.Await(ctx, deferredID)
+	if err != nil || info.Status != JobCompleted {
+		t.Errorf("FAIL: Expected the restored deferred upload to complete, got %s (%v)", info.Status, err)
+	}
+	info, err = restarted.Get(forcedID)
+	if err != nil || info.Status != JobCompleted || info.Result == nil {
+		t.Errorf("FAIL: Expected the completed upload to be restored with its result, got %s (%v)", info.Status, err)
+	}
+
+	// A file that is not valid JSON is refused rather than silently dropping the jobs in it
+	os.WriteFile(path, []byte("not json"), 0644)
+	if _, err := NewPersistentScheduler(newTestEnvironment(t), 1, path); err == nil {
+		t.Errorf("FAIL: NewPersistentScheduler() accepted a corrupt state file")
+	}
+}
+
+// Tests that the chunk size is picked from the file size unless one is given, and that the choice is recorded
+func TestRun_ChunkSize(t *testing.T) {
+	env := newTestEnvironment(t)
+	path := newTestFile(t, "0123456789")
+	for _, test := range []struct {
+		chunkSize int64
+		chunks    int
+		expected  int64
+	}{{0, 1, core.MinChunkSize}, {4, 3, 4}} {
+		result, err := Run(context.Background(), env, Params{FilePath: path, Workers: 2, Retries: 1, ChunkSize: test.chunkSize})
+		if err != nil {
+			t.Fatalf("Run() failed with error: %v", err)
+		}
+		merkleRoot, _ := hex.DecodeString(result.MerkleRoot)
+		manifest, _ := env.ManifestStore.GetManifest(merkleRoot)
+		if result.ChunkCount != test.chunks || manifest.ChunkSize != test.expected || manifest.AutoChunkSize != (test.chunkSize == 0) {
+			t.Errorf("FAIL: Chunk size %d produced %d chunks and manifest %+v", test.chunkSize, result.ChunkCount, manifest)
+		}
+	}
+	if _, err := Run(context.Background(), env, Params{FilePath: path, Workers: 2, Retries: 1, ChunkSize: -1}); err == nil {
+		t.Errorf("FAIL: An invalid chunk size was accepted")
+	}
+}
+
+// Tests that deleting a file commits a tombstone and deletes the file's local chunks, but only once
+func TestDelete(t *testing.T) {
+	env := newTestEnvironment(t)
+	result, err := Run(context.Background(), env, Params{FilePath: newTestFile(t, "file to delete"), Workers: 2, Retries: 1})
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+	merkleRoot, _ := hex.DecodeString(result.MerkleRoot)
+
+	deleted, err := Delete(context.Background(), env, merkleRoot, 2, 1)
+	if err != nil {
+		t.Fatalf("Delete() failed with error: %v", err)
+	}
+	if deleted.BlockIndex != 2 || deleted.DeletedChunks != result.ChunkCount {
+		t.Errorf("FAIL: Expected a tombstone in block 2 deleting %d chunks, got %+v", result.ChunkCount, deleted)
+	}
+	if len(env.ChunkStore.Hashes()) != 0 || env.PinSet.Status(merkleRoot, time.Now()) != storage.Unpinned {
+		t.Errorf("FAIL: Deleted file still has local chunks or a pin")
+	}
+	// The block committing the file stays on the chain as part of the audit trail
+	if _, err := env.Chain.GetBlockByMerkelRoot(merkleRoot); err != nil {
+		t.Errorf("FAIL: Block committing the deleted file was removed from the chain: %v", err)
+	}
+	if _, err := Delete(context.Background(), env, merkleRoot, 2, 1); !errors.Is(err, core.ErrAlreadyDeleted) {
+		t.Errorf("FAIL: Expected ErrAlreadyDeleted when deleting a file twice, got %v", err)
+	}
+}
+
+// Tests that an encrypted file's chunks are stored and committed as ciphertext and restricted to peers granted access
+func TestRun_Encrypt(t *testing.T) {
+	env := newTestEnvironment(t)
+	params := Params{FilePath: newTestFile(t, "secret contents"), Workers: 2, Retries: 1, Encrypt: true}
+	if _, err := Run(context.Background(), env, params); err == nil {
+		t.Errorf("FAIL: File was encrypted by a node without an access list")
+	}
+
+	env.Access, _ = storage.NewAccessList(filepath.Join(t.TempDir(), "access.json"))
+	result, err := Run(context.Background(), env, params)
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+	merkleRoot, _ := hex.DecodeString(result.MerkleRoot)
+	manifest, err := env.ManifestStore.GetManifest(merkleRoot)
+	if err != nil || manifest.Key == nil || manifest.FileSize != int64(len("secret contents")) {
+		t.Fatalf("FAIL: Expected the manifest to keep the key and original size, got %+v (%v)", manifest, err)
+	}
+	chunk, _ := env.ChunkStore.GetChunk(manifest.ChunkHashes[0])
+	decrypted, err := storage.DecryptChunk(manifest.Key, chunk)
+	if bytes.Contains(chunk, []byte("secret")) || err != nil || string(decrypted) != "secret contents" {
+		t.Errorf("FAIL: Expected the stored chunk to be the encrypted contents, got %q (%v)", chunk, err)
+	}
+	if restrictedTo, restricted := env.Access.RestrictedTo(manifest.ChunkHashes[0]); !restricted ||
+		!bytes.Equal(restrictedTo, merkleRoot) {
+		t.Errorf("FAIL: Encrypted file's chunk is not restricted to peers granted access")
+	}
+
+	// A node with a keystore keeps the key there rather than in the manifest
+	env.Keys, err = keystore.Create(filepath.Join(t.TempDir(), "keystore.json"), []byte("passphrase"), env.IdentityKey)
+	if err != nil {
+		t.Fatalf("keystore.Create() failed with error: %v", err)
+	}
+	params.FilePath = newTestFile(t, "other secret contents")
+	result, err = Run(context.Background(), env, params)
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+	merkleRoot, _ = hex.DecodeString(result.MerkleRoot)
+	manifest, _ = env.ManifestStore.GetManifest(merkleRoot)
+	if _, held := env.Keys.FileKey(merkleRoot); manifest.Key != nil || !manifest.Encrypted || !held {
+		t.Errorf("FAIL: Expected the file key to be held by the keystore and not the manifest, got %+v", manifest)
+	}
+}
+
+// Tests that a pinned file whose holders fail their probes is placed on new peers until it reaches the target
+func TestRepairManager_RepairOnce(t *testing.T) {
+	env := newTestEnvironment(t)
+	result, err := Run(context.Background(), env, Params{FilePath: newTestFile(t, "file to repair"), Workers: 2, Retries: 1})
+	if err != nil {
+		t.Fatalf("Run() failed with error: %v", err)
+	}
+
+	env.FindHolders = func(ctx context.Context, chunkHashes [][]byte) ([]string, error) {
+		return []string{"live-peer", "gone-peer"}, nil
+	}
+	env.Audit = func(ctx context.Context, holder string, chunkHashes [][]byte) error {
+		if holder == "gone-peer" {
+			return errors.New("peer did not answer")
+		}
+		return nil
+	}
+	var excluded []string
+	env.Place = func(ctx context.Context, chunkHashes [][]byte, holders []string, wanted int, jitter time.Duration) ([]string, error) {
+		excluded = holders
+		return []string{"new-peer"}[:min(wanted, 1)], nil
+	}
+
+	report, err := NewRepairManager(env, 2).RepairOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RepairOnce() failed with error: %v", err)
+	}
+	if report.Checked != 1 || report.Repaired != 1 || report.Placed != 1 || len(report.Degraded) != 0 {
+		t.Errorf("FAIL: Expected one file repaired with one new replica, got %+v", report)
+	}
+	if len(excluded) != 1 || excluded[0] != "live-peer" {
+		t.Errorf("FAIL: Expected only the live holder to be excluded from placement, got %v", excluded)
+	}
+
+	// A target the new peers cannot make up for leaves the file degraded
+	report, _ = NewRepairManager(env, 4).RepairOnce(context.Background())
+	if len(report.Degraded) != 1 || report.Degraded[0] != result.MerkleRoot {
+		t.Errorf("FAIL: Expected the file to be reported as degraded, got %+v", report)
+	}
+}
+
+// Tests that uploads arriving within the batch window are committed together in one block
+func TestBatcher_CommitsTogether(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.Batcher = NewBatcher(env, 200*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go env.Batcher.Run(ctx)
+
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	errs := make([]error, 2)
+	for i, contents := range []string{"first file", "second file"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = Run(ctx, env, Params{FilePath: newTestFile(t, contents), Workers: 2, Retries: 1})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Run() failed with error: %v", err)
+		}
+	}
+	if results[0].BlockHash != results[1].BlockHash {
+		t.Fatalf("FAIL: Expected both uploads in one block, got blocks %d and %d", results[0].BlockIndex, results[1].BlockIndex)
+	}
+	block := env.Chain.LastBlock()
+	if env.Chain.Length() != 2 || len(block.Files) != 2 {
+		t.Errorf("FAIL: Expected a single block committing both files, got %d blocks with %d files", env.Chain.Length(), len(block.Files))
+	}
+	for _, result := range results {
+		merkleRoot, _ := hex.DecodeString(result.MerkleRoot)
+		if _, err := env.ManifestStore.GetManifest(merkleRoot); err != nil {
+			t.Errorf("FAIL: Manifest of batched file %s was not saved: %v", result.MerkleRoot, err)
+		}
+	}
+}
+
+// Tests that a batch is committed early when the next upload's record would take it over the greatest encoded size,
+// and that the upload is committed in the next batch
+func TestBatcher_CapsEncodedSize(t *testing.T) {
+	env := newTestEnvironment(t)
+	env.Batcher = NewBatcher(env, 200*time.Millisecond, 10)
+	env.Batcher.maxBytes = recordSize(core.FileRecord{MerkleRoot: make([]byte, 32)}) * 3 / 2
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go env.Batcher.Run(ctx)
+
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	errs := make([]error, 2)
+	for i, contents := range []string{"first file", "second file"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = Run(ctx, env, Params{FilePath: newTestFile(t, contents), Workers: 2, Retries: 1})
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Run() failed with error: %v", err)
+		}
+	}
+	if results[0].BlockHash == results[1].BlockHash || env.Chain.Length() != 3 {
+		t.Errorf("FAIL: Expected the uploads in separate blocks, got %d blocks", env.Chain.Length())
+	}
+}
@@ -0,0 +1,17 @@
+// Package blake3hash registers BLAKE3 with the verify package's hash registry.
+// It is kept apart from verify so that auditors who only check SHA-256 chains do not need the dependency.
+package blake3hash
+
+import (
+	"blockchain-storage/verify"
+	"hash"
+
+	"lukechampine.com/blake3"
+)
+
+// Binary identifier of BLAKE3 in chunk headers and encoded Merkle trees
+const ID = 1
+
+func init() {
+	verify.RegisterHash(verify.BLAKE3, ID, func() hash.Hash { return blake3.New(32, nil) })
+}
@@ -0,0 +1,90 @@
+package verify
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Codecs of the content a CID identifies, from the multicodec table
+// Chunks are identified as raw bytes, so IPFS tooling can check a chunk against its CID. Files are identified by their
+// Merkle root, which is not the hash of any one block of bytes, so they use a code from the private use range.
+const (
+	CodecChunk uint64 = 0x55
+	CodecFile  uint64 = 0x300000
+)
+
+// Version of the CIDs emitted, and the multibase prefix of the lowercase base32 they are encoded in
+const (
+	cidVersion      = 1
+	cidBase32Prefix = 'b'
+)
+
+// Encoding of CIDs in lowercase base32 without padding, as used by IPFS for CIDv1
+var cidEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Error returned when a string is not a CID this package can decode
+var ErrInvalidCID = errors.New("invalid content identifier")
+
+// CID - Self-describing content identifier, holding a hash along with its algorithm and the codec of the content
+type CID struct {
+	Codec     uint64        // Codec of the identified content (CodecChunk or CodecFile)
+	Algorithm HashAlgorithm // Algorithm the hash was computed with
+	Hash      []byte        // Hash of a chunk, or Merkle root of a file
+}
+
+// Function that returns the CID of a chunk or file hashed with an algorithm, encoded as a CIDv1 in lowercase base32
+func EncodeCID(codec uint64, algorithm HashAlgorithm, hash []byte) (string, error) {
+	multihash, err := NewMultihash(algorithm, hash)
+	if err != nil {
+		return "", err
+	}
+	return EncodeMultihashCID(codec, multihash), nil
+}
+
+// Function that returns the CID of a chunk or file identified by a multihash
+func EncodeMultihashCID(codec uint64, multihash Multihash) string {
+	encoded := binary.AppendUvarint(nil, cidVersion)
+	encoded = binary.AppendUvarint(encoded, codec)
+	encoded = append(encoded, multihash...)
+	return string(cidBase32Prefix) + cidEncoding.EncodeToString(encoded)
+}
+
+// Function that decodes a CIDv1 in lowercase base32, as emitted by EncodeCID
+func DecodeCID(value string) (CID, error) {
+	if len(value) < 2 || value[0] != cidBase32Prefix {
+		return CID{}, fmt.Errorf("%w: %s is not a base32 CIDv1", ErrInvalidCID, value)
+	}
+	encoded, err := cidEncoding.DecodeString(strings.ToLower(value[1:]))
+	if err != nil {
+		return CID{}, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	version, n := binary.Uvarint(encoded)
+	if n <= 0 || version != cidVersion {
+		return CID{}, fmt.Errorf("%w: unsupported version", ErrInvalidCID)
+	}
+	codec, m := binary.Uvarint(encoded[n:])
+	if m <= 0 {
+		return CID{}, fmt.Errorf("%w: truncated codec", ErrInvalidCID)
+	}
+	algorithm, hash, err := Multihash(encoded[n+m:]).Decode()
+	if err != nil {
+		return CID{}, fmt.Errorf("%w: %v", ErrInvalidCID, err)
+	}
+	return CID{Codec: codec, Algorithm: algorithm, Hash: hash}, nil
+}
+
+// Function that parses a hash given either hex encoded or as a CID, so that users can name content either way
+func ParseHash(value string) ([]byte, error) {
+	if hash, err := hex.DecodeString(value); err == nil && len(hash) > 0 {
+		return hash, nil
+	}
+	cid, err := DecodeCID(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a hex encoded hash nor a CID", value)
+	}
+	return cid.Hash, nil
+}
@@ -0,0 +1,41 @@
+package verify_test
+
+import (
+	"blockchain-storage/verify"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// Checks that a chunk belongs to a file using only the file's Merkle root and a proof supplied by a storage node
+func ExampleMerkleProof() {
+	left := sha256.Sum256([]byte("first chunk"))
+	right := sha256.Sum256([]byte("second chunk"))
+	root := verify.MerkleRoot([][]byte{left[:], right[:]})
+
+	proof := []verify.ProofStep{{Hash: left[:], Left: true}}
+	fmt.Println(verify.MerkleProof([]byte("second chunk"), root, proof))
+	fmt.Println(verify.MerkleProof([]byte("tampered chunk"), root, proof))
+	// Output:
+	// true
+	// false
+}
+
+// Checks that a manifest describes the file committed to in a block exported from a node
+func ExampleManifestCommitment() {
+	first := sha256.Sum256([]byte("first chunk"))
+	second := sha256.Sum256([]byte("second chunk"))
+	chunkHashes := [][]byte{first[:], second[:]}
+	merkleRoot := verify.MerkleRoot(chunkHashes)
+
+	// Blocks exported with "chain export" decode directly into headers
+	exported, _ := json.Marshal(map[string]any{"index": 7, "merkelRoot": merkleRoot})
+	var header verify.Header
+	json.Unmarshal(exported, &header)
+
+	fmt.Println(verify.ManifestCommitment(chunkHashes, merkleRoot, &header))
+	fmt.Println(verify.ManifestCommitment(chunkHashes[:1], merkleRoot, &header))
+	// Output:
+	// <nil>
+	// chunk hashes do not produce the committed Merkle root
+}
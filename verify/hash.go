@@ -0,0 +1,132 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"sort"
+	"sync"
+)
+
+// HashAlgorithm - Name of a hash algorithm used for block hashes, Merkle trees and chunk addresses
+// The empty name stands for SHA-256, so blocks and manifests created before algorithms were recorded keep verifying
+type HashAlgorithm string
+
+// Hash algorithms known to the network. Only SHA-256 is built into this package, so that it keeps depending on the
+// standard library alone; BLAKE3 is registered by importing blockchain-storage/verify/blake3hash
+const (
+	SHA256 HashAlgorithm = "sha256"
+	BLAKE3 HashAlgorithm = "blake3"
+)
+
+// Error returned when a hash algorithm has not been registered
+var ErrUnknownHash = errors.New("unknown hash algorithm")
+
+// Structure of a registered hash algorithm
+type hashEntry struct {
+	id      uint8            // Identifier used for the algorithm in binary encodings
+	newHash func() hash.Hash // Constructor of the algorithm's hash function
+}
+
+// Registry of hash algorithms, keyed by name
+var (
+	hashRegistry      = map[HashAlgorithm]hashEntry{SHA256: {id: 0, newHash: sha256.New}}
+	hashRegistryMutex sync.RWMutex
+)
+
+// Function that registers a hash algorithm under a name and a binary identifier
+// Every algorithm must produce 32-byte hashes, as blocks, proofs and chunk headers all hold hashes of that size
+func RegisterHash(algorithm HashAlgorithm, id uint8, newHash func() hash.Hash) {
+	if newHash().Size() != sha256.Size {
+		panic("verify: hash algorithm " + string(algorithm) + " does not produce 32-byte hashes")
+	}
+	hashRegistryMutex.Lock()
+	defer hashRegistryMutex.Unlock()
+	for name, entry := range hashRegistry {
+		if entry.id == id && name != algorithm {
+			panic("verify: hash identifier already registered for " + string(name))
+		}
+	}
+	hashRegistry[algorithm] = hashEntry{id: id, newHash: newHash}
+}
+
+// Function that returns the registered algorithms, sorted by name
+func HashAlgorithms() []HashAlgorithm {
+	hashRegistryMutex.RLock()
+	defer hashRegistryMutex.RUnlock()
+	algorithms := make([]HashAlgorithm, 0, len(hashRegistry))
+	for algorithm := range hashRegistry {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Slice(algorithms, func(i, j int) bool { return algorithms[i] < algorithms[j] })
+	return algorithms
+}
+
+// Function that returns the name of the algorithm with the empty name resolved to SHA-256
+func (algorithm HashAlgorithm) Canonical() HashAlgorithm {
+	if algorithm == "" {
+		return SHA256
+	}
+	return algorithm
+}
+
+// Function that looks up the registry entry of an algorithm
+func (algorithm HashAlgorithm) entry() (hashEntry, error) {
+	hashRegistryMutex.RLock()
+	defer hashRegistryMutex.RUnlock()
+	entry, ok := hashRegistry[algorithm.Canonical()]
+	if !ok {
+		return hashEntry{}, ErrUnknownHash
+	}
+	return entry, nil
+}
+
+// Function that returns whether the algorithm has been registered
+func (algorithm HashAlgorithm) Available() bool {
+	_, err := algorithm.entry()
+	return err == nil
+}
+
+// Function that returns the binary identifier of the algorithm
+func (algorithm HashAlgorithm) ID() (uint8, error) {
+	entry, err := algorithm.entry()
+	return entry.id, err
+}
+
+// Function that returns the algorithm registered under a binary identifier
+func HashAlgorithmByID(id uint8) (HashAlgorithm, error) {
+	hashRegistryMutex.RLock()
+	defer hashRegistryMutex.RUnlock()
+	for algorithm, entry := range hashRegistry {
+		if entry.id == id {
+			return algorithm, nil
+		}
+	}
+	return "", ErrUnknownHash
+}
+
+// Function that hashes the concatenation of the given byte slices with the algorithm
+func (algorithm HashAlgorithm) Sum(data ...[]byte) ([]byte, error) {
+	entry, err := algorithm.entry()
+	if err != nil {
+		return nil, err
+	}
+	hashFunction := entry.newHash()
+	for _, part := range data {
+		hashFunction.Write(part)
+	}
+	return hashFunction.Sum(nil), nil
+}
+
+// Function that finds the registered algorithm under which data has the given hash
+// Chunks are requested by hash alone, so this lets a node check a chunk whatever algorithm its file was uploaded with
+func IdentifyHash(data []byte, expected []byte) (HashAlgorithm, bool) {
+	for _, algorithm := range HashAlgorithms() {
+		hash, err := algorithm.Sum(data)
+		if err == nil && bytes.Equal(hash, expected) {
+			return algorithm, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,115 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Errors returned when an inclusion proof does not show that a chunk belongs to a committed file
+var (
+	ErrChunkMismatch    = errors.New("chunk does not match the hash in the inclusion proof")
+	ErrProofInvalid     = errors.New("Merkle proof does not lead from the chunk to the file's Merkle root")
+	ErrPositionMismatch = errors.New("Merkle proof is not of the chunk at the claimed position")
+)
+
+// InclusionProof - Proof that a chunk belongs to a file committed in a block, which can be checked without a node
+// The headers run from the block committing the file up to the tip of the chain the proof was taken from, so that the
+// work done on top of the block can be checked too. Comparing the last header's hash with the tip of another node, or
+// any other trusted source, shows the block is part of the network's chain.
+// In JSON the hashes are base64 encoded and the headers match the blocks exported by nodes.
+type InclusionProof struct {
+	MerkleRoot []byte      `json:"merkleRoot"` // Merkle root of the file the chunk belongs to
+	ChunkIndex int         `json:"chunkIndex"` // Position of the chunk in the file
+	ChunkCount int         `json:"chunkCount"` // Number of chunks in the file, which bounds the chunk's position
+	ChunkHash  []byte      `json:"chunkHash"`  // Hash of the chunk
+	Proof      []ProofStep `json:"proof"`      // Steps leading from the chunk's hash to the file's Merkle root
+	Headers    []*Header   `json:"headers"`    // Headers from the block committing the file to the tip, in order
+}
+
+// Function that checks an inclusion proof, along with the chunk's data if it is given (nil checks the chunk's hash)
+// The Merkle proof must lead from the chunk's hash to the file's Merkle root through the chunk's claimed position,
+// which must be one of the file's chunks, the first header must commit to the root and meet the difficulty, and every later header must validly extend the one
+// before it. Every header must carry its uploader's signature, as an auditor has no checkpoint to trust blocks up to.
+// The first header that fails is returned as a ChainError.
+func Inclusion(proof *InclusionProof, chunk []byte, difficulty uint) error {
+	if len(proof.Headers) == 0 {
+		return ErrEmptyChain
+	}
+	block := proof.Headers[0]
+	if !block.HashAlgorithm.Available() {
+		return ErrUnknownHash
+	}
+	if !commits(block, proof.MerkleRoot) {
+		return ErrWrongBlock
+	}
+	if chunk != nil {
+		hash, err := block.HashAlgorithm.Sum(chunk)
+		if err != nil || !bytes.Equal(hash, proof.ChunkHash) {
+			return ErrChunkMismatch
+		}
+	}
+	if !bytes.Equal(foldProof(block.HashAlgorithm, proof.ChunkHash, proof.Proof), proof.MerkleRoot) {
+		return ErrProofInvalid
+	}
+	position := proofPosition(proof.Proof, proof.ChunkCount)
+	if position < 0 || position != proof.ChunkIndex {
+		return ErrPositionMismatch
+	}
+
+	// The committing block has no previous header in the proof, so it is checked on its own
+	var err error
+	switch {
+	case !bytes.Equal(block.Hash, HeaderHash(block)):
+		err = ErrHashMismatch
+	case !ProofOfWork(block.Hash, difficulty):
+		err = ErrInsufficientWork
+	case !Signature(block):
+		err = ErrBadSignature
+	}
+	if err != nil {
+		return &ChainError{Index: block.Index, Err: err}
+	}
+	for i := 1; i < len(proof.Headers); i++ {
+		err := Block(proof.Headers[i], proof.Headers[i-1], difficulty, -1)
+		if err != nil {
+			return &ChainError{Index: proof.Headers[i].Index, Err: err}
+		}
+	}
+	return nil
+}
+
+// Function that checks whether a block commits to a file's Merkle root, either as its own root or in its file records
+func commits(header *Header, merkleRoot []byte) bool {
+	if bytes.Equal(header.MerkleRoot, merkleRoot) {
+		return true
+	}
+	for _, file := range header.Files {
+		if bytes.Equal(file.MerkleRoot, merkleRoot) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function that works out the position of the leaf a Merkle proof starts from in a tree with a number of leaves,
+// returning -1 if the proof does not fit the tree
+// At each level a sibling on the left means the node is a right child, which sets the bit of that level. The last
+// node of an odd level is paired with a copy of itself, and a proof through the copy leads to the same root, so the
+// position must be below the number of leaves and the proof must have a step for every level of the tree.
+func proofPosition(proof []ProofStep, leaves int) int {
+	position := 0
+	width := leaves
+	for level, step := range proof {
+		if width <= 1 {
+			return -1
+		}
+		if step.Left {
+			position |= 1 << level
+		}
+		width = (width + 1) / 2
+	}
+	if width != 1 || position >= leaves {
+		return -1
+	}
+	return position
+}
@@ -0,0 +1,177 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// Errors returned when a file does not match the commitment in its block
+var (
+	ErrNoChunks          = errors.New("manifest lists no chunks")
+	ErrCommitmentInvalid = errors.New("chunk hashes do not produce the committed Merkle root")
+	ErrWrongBlock        = errors.New("block does not commit to the manifest's Merkle root")
+	ErrMalformedProof    = errors.New("encoded Merkle proof is malformed")
+)
+
+// Version of the binary encoding of Merkle proofs
+const proofEncodingVersion = 1
+
+// ProofStep - Single step of a Merkle proof, holding the hash of the sibling at one level of the tree
+// In JSON the hash is base64 encoded, e.g. {"hash":"...","left":true}
+type ProofStep struct {
+	Hash []byte `json:"hash"` // The hash of the current step in the proof
+	Left bool   `json:"left"` // A boolean value indicating whether the hash corresponds to a left child node
+}
+
+// Function that encodes a Merkle proof in its canonical binary form, to be sent in protocol messages
+// The encoding is a version byte and the number of steps (as a uvarint), followed by a side byte (1 for left) and the
+// 32-byte sibling hash of every step, so every proof has exactly one encoding
+func EncodeProof(proof []ProofStep) ([]byte, error) {
+	encoded := []byte{proofEncodingVersion}
+	encoded = binary.AppendUvarint(encoded, uint64(len(proof)))
+	for _, step := range proof {
+		if len(step.Hash) != sha256.Size {
+			return nil, ErrMalformedProof
+		}
+		side := byte(0)
+		if step.Left {
+			side = 1
+		}
+		encoded = append(encoded, side)
+		encoded = append(encoded, step.Hash...)
+	}
+	return encoded, nil
+}
+
+// Function that decodes a Merkle proof from its canonical binary form, rejecting any other encoding
+func DecodeProof(encoded []byte) ([]ProofStep, error) {
+	if len(encoded) == 0 || encoded[0] != proofEncodingVersion {
+		return nil, ErrMalformedProof
+	}
+	count, n := binary.Uvarint(encoded[1:])
+	rest := encoded[1+max(n, 0):]
+	// Every step takes 33 bytes, which also rules out counts that would not fit in memory
+	if n <= 0 || n != len(binary.AppendUvarint(nil, count)) || count != uint64(len(rest)/(1+sha256.Size)) ||
+		len(rest)%(1+sha256.Size) != 0 {
+		return nil, ErrMalformedProof
+	}
+
+	proof := make([]ProofStep, 0, count)
+	for len(rest) > 0 {
+		if rest[0] > 1 {
+			return nil, ErrMalformedProof
+		}
+		proof = append(proof, ProofStep{Hash: append([]byte{}, rest[1:1+sha256.Size]...), Left: rest[0] == 1})
+		rest = rest[1+sha256.Size:]
+	}
+	return proof, nil
+}
+
+// Function that checks a SHA-256 Merkle proof that a chunk belongs to the file with the given Merkle root
+func MerkleProof(chunk []byte, merkleRoot []byte, proof []ProofStep) bool {
+	return MerkleProofWith(SHA256, chunk, merkleRoot, proof)
+}
+
+// Function that checks a Merkle proof built with the given hash algorithm
+func MerkleProofWith(algorithm HashAlgorithm, chunk []byte, merkleRoot []byte, proof []ProofStep) bool {
+	// Calculate the hash of the data received
+	hash, err := algorithm.Sum(chunk)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(foldProof(algorithm, hash, proof), merkleRoot)
+}
+
+// Function that hashes a chunk's hash up through every step of a Merkle proof, returning the root it leads to
+func foldProof(algorithm HashAlgorithm, hash []byte, proof []ProofStep) []byte {
+	// Loop over every single step in the received proof
+	for _, proofStep := range proof {
+		// If the hash corresponds to a left node, prepend the proof hash to the current hash
+		if proofStep.Left {
+			hash, _ = algorithm.Sum(proofStep.Hash, hash)
+		} else {
+			// If the hash corresponds to a right node, append the proof hash to the current hash
+			hash, _ = algorithm.Sum(hash, proofStep.Hash)
+		}
+	}
+	return hash
+}
+
+// Function that calculates the SHA-256 Merkle root of a file from the hashes of its chunks (the leaves of the tree)
+func MerkleRoot(chunkHashes [][]byte) []byte {
+	return MerkleRootWith(SHA256, chunkHashes)
+}
+
+// Function that calculates the Merkle root of a file with the given hash algorithm
+// An odd node at any level is paired with itself, matching how nodes build their Merkle trees. Nil is returned if
+// there are no chunks or the algorithm is not registered.
+func MerkleRootWith(algorithm HashAlgorithm, chunkHashes [][]byte) []byte {
+	if len(chunkHashes) == 0 || !algorithm.Available() {
+		return nil
+	}
+	level := chunkHashes
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level[:len(level):len(level)], level[len(level)-1])
+		}
+		var levelAbove [][]byte
+		for i := 0; i < len(level); i += 2 {
+			hash, _ := algorithm.Sum(level[i], level[i+1])
+			levelAbove = append(levelAbove, hash)
+		}
+		level = levelAbove
+	}
+	return level[0]
+}
+
+// Function that calculates the Merkle root a block committing several files commits to, from their records
+// Every leaf is the hash of a record's encoding, so the root covers each file's name and previous version along with
+// its Merkle root. Nil is returned if there are no records or the algorithm is not registered.
+func RecordsRoot(algorithm HashAlgorithm, files []FileRecord) []byte {
+	leaves := make([][]byte, 0, len(files))
+	for _, file := range files {
+		leaf, err := algorithm.Sum(encodeFileRecord(file))
+		if err != nil {
+			return nil
+		}
+		leaves = append(leaves, leaf)
+	}
+	return MerkleRootWith(algorithm, leaves)
+}
+
+// Function that calculates the Merkle root a block recording storage agreements commits to, from the agreements
+// Every leaf is the hash of an agreement's encoding, so the root covers every term of every agreement. Nil is returned
+// if there are no agreements or the algorithm is not registered.
+func AgreementsRoot(algorithm HashAlgorithm, agreements []Agreement) []byte {
+	leaves := make([][]byte, 0, len(agreements))
+	for _, agreement := range agreements {
+		leaf, err := algorithm.Sum(encodeAgreement(agreement))
+		if err != nil {
+			return nil
+		}
+		leaves = append(leaves, leaf)
+	}
+	return MerkleRootWith(algorithm, leaves)
+}
+
+// Function that checks that a manifest's chunk hashes produce its Merkle root and that a block commits to that root
+// Together with the block's own verification, this proves that the file described by the manifest was uploaded in
+// that block. The Merkle tree is built with the block's hash algorithm. A block committing several files commits to the
+// root if one of its file records holds it, as the records are covered by the block hash.
+func ManifestCommitment(chunkHashes [][]byte, merkleRoot []byte, header *Header) error {
+	if len(chunkHashes) == 0 {
+		return ErrNoChunks
+	}
+	if !header.HashAlgorithm.Available() {
+		return ErrUnknownHash
+	}
+	if !bytes.Equal(MerkleRootWith(header.HashAlgorithm, chunkHashes), merkleRoot) {
+		return ErrCommitmentInvalid
+	}
+	if !commits(header, merkleRoot) {
+		return ErrWrongBlock
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Multihash codes of the hash algorithms known to the network, from the multicodec table
+var multihashCodes = map[HashAlgorithm]uint64{SHA256: 0x12, BLAKE3: 0x1e}
+
+// Error returned when bytes are not a multihash of a known algorithm
+var ErrInvalidMultihash = errors.New("invalid multihash")
+
+// Multihash - Hash prefixed with the code of its algorithm and the length of its digest, so that the algorithm
+// travels with the hash wherever it is sent
+type Multihash []byte
+
+// Function that wraps a hash computed with an algorithm into a multihash
+func NewMultihash(algorithm HashAlgorithm, digest []byte) (Multihash, error) {
+	code, ok := multihashCodes[algorithm.Canonical()]
+	if !ok {
+		return nil, fmt.Errorf("%w: no multihash code for %s", ErrUnknownHash, algorithm.Canonical())
+	}
+	encoded := binary.AppendUvarint(nil, code)
+	encoded = binary.AppendUvarint(encoded, uint64(len(digest)))
+	return append(encoded, digest...), nil
+}
+
+// Function that hashes the concatenation of the given byte slices with an algorithm into a multihash
+func SumMultihash(algorithm HashAlgorithm, data ...[]byte) (Multihash, error) {
+	digest, err := algorithm.Sum(data...)
+	if err != nil {
+		return nil, err
+	}
+	return NewMultihash(algorithm, digest)
+}
+
+// Function that splits a multihash into its algorithm and digest, checking the digest has the length it declares
+func (multihash Multihash) Decode() (HashAlgorithm, []byte, error) {
+	code, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return "", nil, fmt.Errorf("%w: truncated code", ErrInvalidMultihash)
+	}
+	length, m := binary.Uvarint(multihash[n:])
+	if m <= 0 {
+		return "", nil, fmt.Errorf("%w: truncated length", ErrInvalidMultihash)
+	}
+	digest := multihash[n+m:]
+	if length != uint64(len(digest)) {
+		return "", nil, fmt.Errorf("%w: digest is %d bytes but its length says %d", ErrInvalidMultihash, len(digest),
+			length)
+	}
+	for algorithm, algorithmCode := range multihashCodes {
+		if algorithmCode == code {
+			return algorithm, digest, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%w: unknown code %#x", ErrInvalidMultihash, code)
+}
+
+// Function that returns the digest of a multihash (nil if it cannot be decoded)
+func (multihash Multihash) Digest() []byte {
+	_, digest, err := multihash.Decode()
+	if err != nil {
+		return nil
+	}
+	return digest
+}
+
+// Function that checks data hashes to the multihash under the algorithm it names
+// Unlike IdentifyHash, only the named algorithm is tried, so a hash can never be matched under another algorithm
+func (multihash Multihash) Verify(data []byte) bool {
+	algorithm, digest, err := multihash.Decode()
+	if err != nil || !algorithm.Available() {
+		return false
+	}
+	hash, err := algorithm.Sum(data)
+	return err == nil && bytes.Equal(hash, digest)
+}
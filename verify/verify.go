@@ -0,0 +1,272 @@
+// Package verify checks blocks, chains, Merkle proofs and manifest commitments of the blockchain storage network.
+// It only depends on the standard library, so auditors can embed it without pulling in the networking stack.
+package verify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// Errors returned when a block fails verification
+var (
+	ErrHashMismatch        = errors.New("block hash does not match its contents")
+	ErrBrokenLink          = errors.New("block does not link to the previous block")
+	ErrIndexMismatch       = errors.New("block index does not follow the previous block")
+	ErrInsufficientWork    = errors.New("block hash does not meet the proof of work target")
+	ErrBadSignature        = errors.New("block is not signed by its uploader")
+	ErrEmptyChain          = errors.New("chain has no blocks")
+	ErrUnknownVersion      = errors.New("block has an unknown hash version")
+	ErrVersionDowngrade    = errors.New("block uses an older hash version than the previous block")
+	ErrUncoveredField      = errors.New("block carries a field its hash version does not cover")
+	ErrRecordsRoot         = errors.New("block's Merkle root is not the root of the file records it carries")
+	ErrMisplacedRecord     = errors.New("block committing several files carries a record outside its file records")
+	ErrAgreementsRoot      = errors.New("block's Merkle root is not the root of the agreements it records")
+	ErrMisplacedAgreements = errors.New("block recording agreements carries other records")
+	ErrRecordsHash         = errors.New("block's records do not match the records hash it carries")
+)
+
+// Versions of the encoding a block's contents are hashed in
+// Legacy blocks joined the fields as text, which is lossy: the nonce was converted to a single rune (so many nonces
+// produced the same hash) and the timestamp was formatted with its time zone. Blocks of existing chains keep their
+// legacy hashes, while new blocks are hashed in the canonical binary encoding, and once a chain has a canonical block
+// every block after it must be canonical too.
+// Blocks of HashVersionWire hash the fields of their wire encoding that the hash covers, so the block sent between
+// nodes is exactly what is hashed and fields added later are hashed without changing the encoding of the others.
+// Blocks of HashVersionRecords hash a hash of their records in place of the records themselves, so a pruned header
+// can drop the records and keep only their hash while still being checked against the block hash.
+const (
+	HashVersionLegacy    uint8 = 0
+	HashVersionCanonical uint8 = 1
+	HashVersionWire      uint8 = 2
+	HashVersionRecords   uint8 = 3
+
+	// Version new blocks are created with
+	CurrentHashVersion = HashVersionRecords
+)
+
+// Maximum integer value a 256-bit hash can have
+var maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// Header - Fields of a block that are needed to verify it
+// The JSON encoding matches the one used by nodes, so blocks exported from a node can be decoded straight into headers
+type Header struct {
+	Index             int64     `json:"index"`             // Height of the block
+	Timestamp         time.Time `json:"timestamp"`         // Time the block was created
+	MerkleRoot        []byte    `json:"merkelRoot"`        // Merkle root of the file committed to in the block
+	PrevHash          []byte    `json:"prevHash"`          // Hash of the previous block
+	Hash              []byte    `json:"hash"`              // Hash of the block
+	Nonce             int       `json:"nonce"`             // Nonce found by proof of work
+	UploaderPublicKey []byte    `json:"uploaderPublicKey"` // Ed25519 public key of the uploader
+	Signature         []byte    `json:"signature"`         // Uploader's signature over the block hash
+	Pruned            bool      `json:"pruned,omitempty"`  // Whether only the header is kept (not hashed, so never trusted)
+
+	HashAlgorithm HashAlgorithm `json:"hashAlgorithm,omitempty"` // Algorithm of the block hash and Merkle root (SHA-256 if empty)
+	Version       uint8         `json:"version,omitempty"`       // Encoding the block's contents are hashed in
+	Extensions    []byte        `json:"extensions,omitempty"`    // Encoded fields added by newer versions (see DecodeHeader)
+
+	Name        string `json:"name,omitempty"`        // Name claimed for the block's file in the name registry (HashVersionWire only)
+	PrevVersion []byte `json:"prevVersion,omitempty"` // Merkle root of the previous version of the block's file (HashVersionWire only)
+	Tombstone   []byte `json:"tombstone,omitempty"`   // Merkle root of the file the block deletes (HashVersionWire only)
+
+	// Records of the files the block commits, if it commits several (HashVersionWire only, see RecordsRoot)
+	Files []FileRecord `json:"files,omitempty"`
+	// Storage agreements the block records, if it records any (HashVersionWire only, see AgreementsRoot)
+	Agreements []Agreement `json:"agreements,omitempty"`
+	// Hash of the records a pruned header dropped, kept in their place (HashVersionRecords only, see RecordsHash)
+	RecordsHash []byte `json:"recordsHash,omitempty"`
+}
+
+// FileRecord - Record of one of the files a block committing several files commits
+type FileRecord struct {
+	MerkleRoot  []byte `json:"merkleRoot"`            // Merkle root of the file
+	Name        string `json:"name,omitempty"`        // Name claimed for the file in the name registry (empty if none)
+	PrevVersion []byte `json:"prevVersion,omitempty"` // Merkle root of the previous version of the file (empty if none)
+}
+
+// Agreement - Record of a provider's promise to store a file for its uploader, which a block records on the chain
+// The agreement runs from the timestamp of the block recording it for its duration.
+type Agreement struct {
+	Uploader    string `json:"uploader"`    // Peer ID of the uploader of the file
+	Provider    string `json:"provider"`    // Peer ID of the peer that promised to store the file
+	MerkleRoot  []byte `json:"merkleRoot"`  // Merkle root of the file
+	Duration    uint64 `json:"duration"`    // Number of seconds the provider promised to store the file for
+	Replication uint64 `json:"replication"` // Number of peers the uploader asked to store the file, the provider included
+	Signature   []byte `json:"signature"`   // Signature over the other terms by the provider's identity key
+}
+
+// Function that calculates the hash of a block from its contents
+// The hash is calculated with the block's hash algorithm over the encoding of its hash version, and nil is returned if
+// either is unknown
+func HeaderHash(header *Header) []byte {
+	var contents []byte
+	switch header.Version {
+	case HashVersionLegacy:
+		contents = legacyHeaderContents(header)
+	case HashVersionCanonical:
+		contents = CanonicalHeaderContents(header)
+	case HashVersionWire:
+		contents = wireHeaderContents(header)
+	case HashVersionRecords:
+		contents = recordsHeaderContents(header)
+	default:
+		return nil
+	}
+	hash, err := header.HashAlgorithm.Sum(contents)
+	if err != nil {
+		return nil
+	}
+	return hash
+}
+
+// Function that encodes the contents of a legacy block as they were hashed before the canonical encoding
+func legacyHeaderContents(header *Header) []byte {
+	// Convert index, timestamp, and nonce fields to a string, append together and join to contents
+	contents := []byte(strconv.FormatInt(header.Index, 10) + header.Timestamp.String() + string(rune(header.Nonce)))
+	// Add the other []byte arrays
+	contents = append(contents, header.MerkleRoot...)
+	contents = append(contents, header.PrevHash...)
+	contents = append(contents, header.UploaderPublicKey...)
+	// Any algorithm other than SHA-256 is covered by the hash, so a block cannot be reinterpreted under another one
+	if header.HashAlgorithm.Canonical() != SHA256 {
+		contents = append(contents, header.HashAlgorithm...)
+	}
+	return contents
+}
+
+// Function that encodes the contents of a block in the canonical binary encoding its hash is calculated over
+// The encoding is the version byte, the index, the timestamp (in nanoseconds since the Unix epoch, so it does not
+// depend on the time zone) and the nonce as 8-byte big-endian integers, followed by the Merkle root, previous hash,
+// uploader's public key and hash algorithm name, each prefixed by its length as a 4-byte big-endian integer. Every
+// field has a fixed width or an explicit length, so no two different blocks share an encoding.
+func CanonicalHeaderContents(header *Header) []byte {
+	contents := []byte{HashVersionCanonical}
+	contents = binary.BigEndian.AppendUint64(contents, uint64(header.Index))
+	contents = binary.BigEndian.AppendUint64(contents, uint64(header.Timestamp.UnixNano()))
+	contents = binary.BigEndian.AppendUint64(contents, uint64(header.Nonce))
+	for _, field := range [][]byte{header.MerkleRoot, header.PrevHash, header.UploaderPublicKey,
+		[]byte(header.HashAlgorithm.Canonical())} {
+		contents = binary.BigEndian.AppendUint32(contents, uint32(len(field)))
+		contents = append(contents, field...)
+	}
+	return contents
+}
+
+// Function that returns the value a block hash must not exceed to satisfy a difficulty (number of leading zero bits)
+func Target(difficulty uint) *big.Int {
+	return new(big.Int).Rsh(maxHash, difficulty)
+}
+
+// Function that checks whether a hash satisfies a proof of work difficulty
+func ProofOfWork(hash []byte, difficulty uint) bool {
+	return new(big.Int).SetBytes(hash).Cmp(Target(difficulty)) <= 0
+}
+
+// Function that checks the uploader's signature over the block hash
+func Signature(header *Header) bool {
+	if len(header.UploaderPublicKey) != ed25519.PublicKeySize || len(header.Signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(header.UploaderPublicKey, header.Hash, header.Signature)
+}
+
+// Function that checks that a block is a valid successor of the previous block
+// The hash must match the block's contents and satisfy the difficulty, and the block must be signed by its uploader
+// unless it is at or below the trusted height, which callers set to their latest checkpoint (a negative height trusts
+// no block). A block cannot go back to an older hash version than the previous block, and a block committing several
+// files must commit to their records.
+func Block(header *Header, prev *Header, difficulty uint, trusted int64) error {
+	if !header.HashAlgorithm.Available() {
+		return ErrUnknownHash
+	}
+	if header.Version > CurrentHashVersion {
+		return ErrUnknownVersion
+	}
+	if header.Version < prev.Version {
+		return ErrVersionDowngrade
+	}
+	// Only the wire encoding covers the fields added after it, so older versions could have them swapped freely
+	if header.Version < HashVersionWire && (header.Name != "" || len(header.PrevVersion) > 0 || len(header.Tombstone) > 0 ||
+		len(header.Files) > 0 || len(header.Agreements) > 0) {
+		return ErrUncoveredField
+	}
+	if header.Version < HashVersionRecords && len(header.RecordsHash) > 0 {
+		return ErrUncoveredField
+	}
+	// A header keeping a hash of its records in their place must not carry other records
+	if len(header.RecordsHash) > 0 && !bytes.Equal(header.RecordsHash, RecordsHash(header)) {
+		return ErrRecordsHash
+	}
+	// A block recording agreements commits to them alone, under their Merkle root
+	if len(header.Agreements) > 0 {
+		if header.Name != "" || len(header.PrevVersion) > 0 || len(header.Tombstone) > 0 || len(header.Files) > 0 {
+			return ErrMisplacedAgreements
+		}
+		if !bytes.Equal(header.MerkleRoot, AgreementsRoot(header.HashAlgorithm, header.Agreements)) {
+			return ErrAgreementsRoot
+		}
+	}
+	// A block committing several files keeps every record in its file records, under their Merkle root
+	if len(header.Files) > 0 {
+		if header.Name != "" || len(header.PrevVersion) > 0 || len(header.Tombstone) > 0 {
+			return ErrMisplacedRecord
+		}
+		if !bytes.Equal(header.MerkleRoot, RecordsRoot(header.HashAlgorithm, header.Files)) {
+			return ErrRecordsRoot
+		}
+	}
+	if !bytes.Equal(header.Hash, HeaderHash(header)) {
+		return ErrHashMismatch
+	}
+	if !bytes.Equal(header.PrevHash, prev.Hash) {
+		return ErrBrokenLink
+	}
+	if header.Index != prev.Index+1 {
+		return ErrIndexMismatch
+	}
+	if !ProofOfWork(header.Hash, difficulty) {
+		return ErrInsufficientWork
+	}
+	if header.Index > trusted && !Signature(header) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ChainError - Error describing the first block of a chain that failed verification
+type ChainError struct {
+	Index int64 // Height of the block that failed verification
+	Err   error // Reason the block failed verification
+}
+
+func (err *ChainError) Error() string {
+	return "block " + strconv.FormatInt(err.Index, 10) + ": " + err.Err.Error()
+}
+
+func (err *ChainError) Unwrap() error {
+	return err.Err
+}
+
+// Function that checks every block of a chain, starting from its genesis block
+// The genesis block is not mined or signed, so only its hash is checked, and blocks at or below the trusted height are
+// checked without their signatures. The first block that fails is returned as a ChainError, so the blocks before it can
+// still be trusted.
+func Chain(headers []*Header, difficulty uint, trusted int64) error {
+	if len(headers) == 0 {
+		return ErrEmptyChain
+	}
+	if !bytes.Equal(headers[0].Hash, HeaderHash(headers[0])) {
+		return &ChainError{Index: headers[0].Index, Err: ErrHashMismatch}
+	}
+	for i := 1; i < len(headers); i++ {
+		err := Block(headers[i], headers[i-1], difficulty, trusted)
+		if err != nil {
+			return &ChainError{Index: headers[i].Index, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,557 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Difficulty used to mine test chains quickly
+const testDifficulty = 8
+
+// Function that mines and signs a block on top of the previous one
+func mineTestBlock(t *testing.T, prev *Header, merkleRoot []byte, privateKey ed25519.PrivateKey) *Header {
+	return mineVersionedBlock(t, prev, merkleRoot, HashVersionLegacy, privateKey)
+}
+
+// Function that builds a valid chain of the given length
+func testChain(t *testing.T, length int) []*Header {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	genesis := &Header{Timestamp: time.Unix(1700000000, 0).UTC()}
+	genesis.Hash = HeaderHash(genesis)
+	chain := []*Header{genesis}
+	for len(chain) < length {
+		chain = append(chain, mineTestBlock(t, chain[len(chain)-1], []byte{byte(len(chain))}, privateKey))
+	}
+	return chain
+}
+
+// Tests that a valid chain verifies and that each kind of tampering is reported against the right block
+func TestChain(t *testing.T) {
+	chain := testChain(t, 4)
+	if err := Chain(chain, testDifficulty, -1); err != nil {
+		t.Fatalf("FAIL: A valid chain failed verification: %v", err)
+	}
+	if err := Chain(nil, testDifficulty, -1); err != ErrEmptyChain {
+		t.Errorf("FAIL: Expected an empty chain to be rejected, got %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		tamper func(header *Header)
+		want   error
+	}{
+		{"contents", func(header *Header) { header.MerkleRoot = []byte("other") }, ErrHashMismatch},
+		{"signature", func(header *Header) { header.Signature = make([]byte, ed25519.SignatureSize) }, ErrBadSignature},
+		{"link", func(header *Header) { header.PrevHash = []byte("other"); header.Hash = HeaderHash(header) }, ErrBrokenLink},
+	} {
+		tampered := *chain[2]
+		test.tamper(&tampered)
+		err := Chain([]*Header{chain[0], chain[1], &tampered, chain[3]}, testDifficulty, -1)
+		var chainErr *ChainError
+		if !errors.As(err, &chainErr) || chainErr.Index != 2 || !errors.Is(err, test.want) {
+			t.Errorf("FAIL: Expected tampered %s to fail block 2 with %v, got %v", test.name, test.want, err)
+		}
+	}
+
+	// Marking a block as pruned does not excuse a missing signature, which is only left unchecked up to the trusted height
+	pruned := *chain[1]
+	pruned.Signature = nil
+	pruned.Pruned = true
+	if err := Chain([]*Header{chain[0], &pruned, chain[2]}, testDifficulty, -1); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("FAIL: Expected an unsigned pruned block to be rejected, got %v", err)
+	}
+	if err := Chain([]*Header{chain[0], &pruned, chain[2]}, testDifficulty, 1); err != nil {
+		t.Errorf("FAIL: A chain with an unsigned block at the trusted height failed verification: %v", err)
+	}
+	if err := Block(chain[1], chain[0], 64, -1); err != ErrInsufficientWork {
+		t.Errorf("FAIL: Expected a block below the difficulty to be rejected, got %v", err)
+	}
+}
+
+// Tests that Merkle roots are built from chunk hashes the same way proofs are checked against them
+func TestMerkleProofAndCommitment(t *testing.T) {
+	chunks := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	var hashes [][]byte
+	for _, chunk := range chunks {
+		hash := sha256.Sum256(chunk)
+		hashes = append(hashes, hash[:])
+	}
+	root := MerkleRoot(hashes)
+
+	// The last chunk of an odd level is paired with itself
+	pair := sha256.Sum256(append(append([]byte{}, hashes[0]...), hashes[1]...))
+	proof := []ProofStep{{Hash: hashes[2], Left: false}, {Hash: pair[:], Left: true}}
+	if !MerkleProof(chunks[2], root, proof) {
+		t.Errorf("FAIL: A valid Merkle proof was rejected")
+	}
+	if MerkleProof([]byte("forged"), root, proof) {
+		t.Errorf("FAIL: A Merkle proof for the wrong chunk was accepted")
+	}
+
+	header := &Header{MerkleRoot: root}
+	if err := ManifestCommitment(hashes, root, header); err != nil {
+		t.Errorf("FAIL: A valid manifest commitment was rejected: %v", err)
+	}
+	if err := ManifestCommitment(hashes[:2], root, header); err != ErrCommitmentInvalid {
+		t.Errorf("FAIL: Expected a manifest missing a chunk to be rejected, got %v", err)
+	}
+	if err := ManifestCommitment(hashes, root, &Header{MerkleRoot: []byte("other")}); err != ErrWrongBlock {
+		t.Errorf("FAIL: Expected a block committing to another file to be rejected, got %v", err)
+	}
+}
+
+// Tests that Merkle proofs survive an encode/decode round trip in binary and JSON, and that other encodings are rejected
+func TestProofEncoding(t *testing.T) {
+	left := sha256.Sum256([]byte("left"))
+	right := sha256.Sum256([]byte("right"))
+	proof := []ProofStep{{Hash: left[:], Left: true}, {Hash: right[:], Left: false}}
+
+	encoded, err := EncodeProof(proof)
+	if err != nil {
+		t.Fatalf("EncodeProof() failed with error: %v", err)
+	}
+	decoded, err := DecodeProof(encoded)
+	if err != nil || !reflect.DeepEqual(decoded, proof) {
+		t.Errorf("FAIL: Decoded proof does not match the original proof (error %v)", err)
+	}
+	if empty, _ := EncodeProof(nil); len(empty) != 2 {
+		t.Errorf("FAIL: An empty proof should encode to its version and step count only")
+	}
+
+	jsonProof, _ := json.Marshal(proof)
+	var fromJSON []ProofStep
+	if err := json.Unmarshal(jsonProof, &fromJSON); err != nil || !reflect.DeepEqual(fromJSON, proof) {
+		t.Errorf("FAIL: Proof did not survive a JSON round trip: %s", jsonProof)
+	}
+
+	malformed := [][]byte{
+		nil,
+		encoded[:len(encoded)-1],
+		append(append([]byte{}, encoded...), 0),
+		append([]byte{2}, encoded[1:]...),
+		append([]byte{1, 0x82, 0x00}, encoded[2:]...),
+	}
+	badSide := append([]byte{}, encoded...)
+	badSide[2] = 2
+	malformed = append(malformed, badSide)
+	for i, bad := range malformed {
+		if _, err := DecodeProof(bad); err != ErrMalformedProof {
+			t.Errorf("FAIL: Malformed proof %d was not rejected, got %v", i, err)
+		}
+	}
+	if _, err := EncodeProof([]ProofStep{{Hash: []byte("short")}}); err != ErrMalformedProof {
+		t.Errorf("FAIL: A proof with a short hash was encoded")
+	}
+}
+
+// Tests that registered hash algorithms are used for block hashes and Merkle trees, and that unknown ones are rejected
+func TestHashRegistry(t *testing.T) {
+	const testAlgorithm HashAlgorithm = "sha512-256"
+	RegisterHash(testAlgorithm, 200, sha512.New512_256)
+	if id, err := testAlgorithm.ID(); err != nil || id != 200 {
+		t.Fatalf("FAIL: Registered algorithm has ID %d (error %v)", id, err)
+	}
+	if algorithm, err := HashAlgorithmByID(0); err != nil || algorithm != SHA256 {
+		t.Errorf("FAIL: ID 0 resolved to %q instead of SHA-256", algorithm)
+	}
+	if HashAlgorithm("").Canonical() != SHA256 {
+		t.Errorf("FAIL: The empty algorithm does not stand for SHA-256")
+	}
+
+	// A block is hashed and verified with its own algorithm, which the hash also covers
+	chain := testChain(t, 2)
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	header := *chain[1]
+	header.HashAlgorithm = testAlgorithm
+	if bytes.Equal(HeaderHash(&header), chain[1].Hash) {
+		t.Errorf("FAIL: Block hash does not depend on the hash algorithm")
+	}
+	mined := mineTestBlock(t, chain[0], []byte{1}, privateKey)
+	mined.HashAlgorithm = testAlgorithm
+	for ; ; mined.Nonce++ {
+		mined.Hash = HeaderHash(mined)
+		if ProofOfWork(mined.Hash, testDifficulty) {
+			break
+		}
+	}
+	mined.Signature = ed25519.Sign(privateKey, mined.Hash)
+	if err := Block(mined, chain[0], testDifficulty, -1); err != nil {
+		t.Errorf("FAIL: Block hashed with a registered algorithm failed verification: %v", err)
+	}
+	mined.HashAlgorithm = "unknown"
+	if err := Block(mined, chain[0], testDifficulty, -1); err != ErrUnknownHash {
+		t.Errorf("FAIL: Expected ErrUnknownHash for an unregistered algorithm, got %v", err)
+	}
+
+	// Merkle roots, proofs and chunk identification follow the algorithm
+	chunks := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	var hashes [][]byte
+	for _, chunk := range chunks {
+		hash, _ := testAlgorithm.Sum(chunk)
+		hashes = append(hashes, hash)
+	}
+	root := MerkleRootWith(testAlgorithm, hashes)
+	if bytes.Equal(root, MerkleRoot(hashes)) {
+		t.Errorf("FAIL: Merkle root does not depend on the hash algorithm")
+	}
+	ab, _ := testAlgorithm.Sum(hashes[0], hashes[1])
+	proof := []ProofStep{{Hash: hashes[0], Left: true}, {Hash: mustSum(t, testAlgorithm, hashes[2], hashes[2]), Left: false}}
+	if !MerkleProofWith(testAlgorithm, chunks[1], root, proof) || MerkleProof(chunks[1], root, proof) {
+		t.Errorf("FAIL: Merkle proof was not checked with the tree's algorithm")
+	}
+	if !bytes.Equal(root, mustSum(t, testAlgorithm, ab, proof[1].Hash)) {
+		t.Errorf("FAIL: Merkle root does not pair an odd node with itself")
+	}
+	if err := ManifestCommitment(hashes, root, &Header{MerkleRoot: root, HashAlgorithm: testAlgorithm}); err != nil {
+		t.Errorf("FAIL: Manifest commitment failed with the block's algorithm: %v", err)
+	}
+	if algorithm, ok := IdentifyHash(chunks[0], hashes[0]); !ok || algorithm != testAlgorithm {
+		t.Errorf("FAIL: Chunk hash was identified as %q", algorithm)
+	}
+	if _, ok := IdentifyHash(chunks[0], hashes[1]); ok {
+		t.Errorf("FAIL: A chunk was identified by another chunk's hash")
+	}
+}
+
+// Function that hashes data with an algorithm that must be registered
+func mustSum(t *testing.T, algorithm HashAlgorithm, data ...[]byte) []byte {
+	hash, err := algorithm.Sum(data...)
+	if err != nil {
+		t.Fatalf("Sum() failed with error: %v", err)
+	}
+	return hash
+}
+
+// Tests that the canonical encoding hashes every nonce and instant distinctly, and that chains cannot downgrade to it
+func TestHeaderHash_Versions(t *testing.T) {
+	instant := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	legacy := &Header{Index: 1, Timestamp: instant, MerkleRoot: []byte("root"), Nonce: 0xD800}
+	canonical := *legacy
+	canonical.Version = HashVersionCanonical
+
+	// Invalid code points such as surrogates all became the same rune in the legacy encoding
+	otherNonce := *legacy
+	otherNonce.Nonce = 0xDFFF
+	if !bytes.Equal(HeaderHash(legacy), HeaderHash(&otherNonce)) {
+		t.Fatalf("FAIL: Expected the legacy encoding to hash both nonces the same")
+	}
+	otherNonce.Version = HashVersionCanonical
+	if bytes.Equal(HeaderHash(&canonical), HeaderHash(&otherNonce)) {
+		t.Errorf("FAIL: Canonical encoding hashed different nonces the same")
+	}
+
+	// The same instant in another time zone is the same block
+	otherZone := canonical
+	otherZone.Timestamp = instant.In(time.FixedZone("UTC+2", 2*60*60))
+	if !bytes.Equal(HeaderHash(&canonical), HeaderHash(&otherZone)) {
+		t.Errorf("FAIL: Canonical hash depends on the time zone of the timestamp")
+	}
+	if bytes.Equal(HeaderHash(legacy), HeaderHash(&canonical)) {
+		t.Errorf("FAIL: Legacy and canonical encodings produced the same hash")
+	}
+	unknown := canonical
+	unknown.Version = CurrentHashVersion + 1
+	if HeaderHash(&unknown) != nil {
+		t.Errorf("FAIL: A block with an unknown hash version was hashed")
+	}
+
+	// A legacy chain can move to canonical blocks, but not back
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	chain := testChain(t, 2)
+	upgraded := mineVersionedBlock(t, chain[1], []byte{2}, HashVersionCanonical, privateKey)
+	if err := Block(upgraded, chain[1], testDifficulty, -1); err != nil {
+		t.Errorf("FAIL: Canonical block on top of a legacy block failed verification: %v", err)
+	}
+	downgraded := mineVersionedBlock(t, upgraded, []byte{3}, HashVersionLegacy, privateKey)
+	if err := Block(downgraded, upgraded, testDifficulty, -1); err != ErrVersionDowngrade {
+		t.Errorf("FAIL: Expected ErrVersionDowngrade for a legacy block after a canonical one, got %v", err)
+	}
+	future := mineVersionedBlock(t, upgraded, []byte{3}, HashVersionCanonical, privateKey)
+	future.Version = CurrentHashVersion + 1
+	if err := Block(future, upgraded, testDifficulty, -1); err != ErrUnknownVersion {
+		t.Errorf("FAIL: Expected ErrUnknownVersion, got %v", err)
+	}
+}
+
+// Function that mines and signs a block with the given hash version on top of the previous one
+func mineVersionedBlock(t *testing.T, prev *Header, merkleRoot []byte, version uint8, privateKey ed25519.PrivateKey) *Header {
+	header := &Header{
+		Index:             prev.Index + 1,
+		Timestamp:         time.Unix(1700000000+prev.Index, 0).UTC(),
+		MerkleRoot:        merkleRoot,
+		PrevHash:          prev.Hash,
+		UploaderPublicKey: privateKey.Public().(ed25519.PublicKey),
+		Version:           version,
+	}
+	for ; header.Nonce < 1<<16; header.Nonce++ {
+		header.Hash = HeaderHash(header)
+		if ProofOfWork(header.Hash, testDifficulty) {
+			header.Signature = ed25519.Sign(privateKey, header.Hash)
+			return header
+		}
+	}
+	t.Fatalf("failed to mine a test block")
+	return nil
+}
+
+func TestWireEncoding(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	chain := testChain(t, 2)
+	header := mineVersionedBlock(t, chain[1], []byte{2}, HashVersionWire, privateKey)
+
+	// A block survives the encoding unchanged and still verifies
+	encoded := EncodeHeader(header)
+	decoded, err := DecodeHeader(encoded)
+	if err != nil {
+		t.Fatalf("FAIL: Failed to decode an encoded block: %v", err)
+	}
+	if !reflect.DeepEqual(header, decoded) {
+		t.Errorf("FAIL: Decoded block %+v does not match the encoded block %+v", decoded, header)
+	}
+	if !bytes.Equal(EncodeHeader(decoded), encoded) {
+		t.Errorf("FAIL: Re-encoding a decoded block changed its encoding")
+	}
+	if err := Block(decoded, chain[1], testDifficulty, -1); err != nil {
+		t.Errorf("FAIL: Decoded wire block failed verification: %v", err)
+	}
+
+	// Only the canonical encoding is accepted
+	for name, malformed := range map[string][]byte{
+		"truncated":      encoded[:len(encoded)-1],
+		"out of order":   append(append([]byte{}, encoded[2:4]...), encoded[:2]...),
+		"repeated field": append(append([]byte{}, encoded...), encoded[len(encoded)-2:]...),
+		"zero value":     {fieldNonce<<3 | wireVarint, 0},
+		"empty bytes":    {fieldMerkleRoot<<3 | wireBytes, 0},
+		"long varint":    {fieldIndex<<3 | wireVarint, 0x81, 0x00},
+		"wrong type":     {fieldIndex<<3 | wireBytes, 1, 1},
+	} {
+		if _, err := DecodeHeader(malformed); err != ErrMalformedBlock {
+			t.Errorf("FAIL: Expected ErrMalformedBlock for a %s encoding, got %v", name, err)
+		}
+	}
+
+	// Fields from newer versions are kept and covered by the hash
+	extension := append(binary.AppendUvarint(nil, 16<<3|wireBytes), 3, 'n', 'e', 'w')
+	extended := append(append([]byte{}, encoded...), extension...)
+	decoded, err = DecodeHeader(extended)
+	if err != nil {
+		t.Fatalf("FAIL: Failed to decode a block with an unknown field: %v", err)
+	}
+	if !bytes.Equal(decoded.Extensions, extension) {
+		t.Errorf("FAIL: Unknown field was not kept, got extensions %v", decoded.Extensions)
+	}
+	if !bytes.Equal(EncodeHeader(decoded), extended) {
+		t.Errorf("FAIL: Block with an unknown field was not re-encoded unchanged")
+	}
+	if bytes.Equal(HeaderHash(decoded), header.Hash) {
+		t.Errorf("FAIL: Unknown field is not covered by the block hash")
+	}
+
+	// Records about the block's file are encoded after the unhashed fields, yet covered by the hash like unknown fields
+	recorded := *header
+	recorded.Name = "photos"
+	recorded.PrevVersion = []byte{1}
+	recorded.Tombstone = []byte{2}
+	recorded.Hash = HeaderHash(&recorded)
+	encoded = EncodeHeader(&recorded)
+	decoded, err = DecodeHeader(encoded)
+	if err != nil || decoded.Name != "photos" || !bytes.Equal(decoded.PrevVersion, []byte{1}) ||
+		!bytes.Equal(decoded.Tombstone, []byte{2}) || len(decoded.Extensions) != 0 {
+		t.Fatalf("FAIL: Expected the block's records to survive the encoding, got %+v (error %v)", decoded, err)
+	}
+	if bytes.Equal(recorded.Hash, header.Hash) {
+		t.Errorf("FAIL: Block's records are not covered by the block hash")
+	}
+	legacy := *header
+	legacy.Version = HashVersionCanonical
+	legacy.PrevVersion = []byte{1}
+	if err := Block(&legacy, chain[1], testDifficulty, -1); err != ErrUncoveredField {
+		t.Errorf("FAIL: Expected ErrUncoveredField for a previous version on a canonical block, got %v", err)
+	}
+}
+
+// Tests that a header of HashVersionRecords can drop its records for their hash and still be checked
+func TestRecordsHash(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	chain := testChain(t, 2)
+	header := &Header{
+		Index:             2,
+		Timestamp:         time.Unix(1700000002, 0).UTC(),
+		MerkleRoot:        []byte{1},
+		PrevHash:          chain[1].Hash,
+		UploaderPublicKey: privateKey.Public().(ed25519.PublicKey),
+		Version:           HashVersionRecords,
+		Name:              "photos",
+	}
+	for header.Hash = HeaderHash(header); !ProofOfWork(header.Hash, testDifficulty); header.Hash = HeaderHash(header) {
+		header.Nonce++
+	}
+	header.Signature = ed25519.Sign(privateKey, header.Hash)
+	if err := Block(header, chain[1], testDifficulty, -1); err != nil {
+		t.Fatalf("FAIL: Block with records failed verification: %v", err)
+	}
+
+	pruned := *header
+	pruned.Name = ""
+	pruned.Pruned = true
+	pruned.RecordsHash = RecordsHash(header)
+	if err := Block(&pruned, chain[1], testDifficulty, -1); err != nil {
+		t.Errorf("FAIL: Header keeping only the hash of its records failed verification: %v", err)
+	}
+	decoded, err := DecodeHeader(EncodeHeader(&pruned))
+	if err != nil || !reflect.DeepEqual(decoded, &pruned) {
+		t.Errorf("FAIL: Expected the records hash to survive the encoding, got %+v (error %v)", decoded, err)
+	}
+
+	// The records hash must match any records the block carries, and only this version covers it
+	mismatched := *header
+	mismatched.RecordsHash = []byte("other")
+	if err := Block(&mismatched, chain[1], testDifficulty, -1); err != ErrRecordsHash {
+		t.Errorf("FAIL: Expected ErrRecordsHash for records that do not match the records hash, got %v", err)
+	}
+	older := pruned
+	older.Version = HashVersionWire
+	if err := Block(&older, chain[1], testDifficulty, -1); err != ErrUncoveredField {
+		t.Errorf("FAIL: Expected ErrUncoveredField for a records hash on a wire block, got %v", err)
+	}
+}
+
+// Tests that a block committing several files commits to the root of their records and survives the wire encoding
+func TestFileRecords(t *testing.T) {
+	_, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	chain := testChain(t, 2)
+	files := []FileRecord{
+		{MerkleRoot: []byte{1}},
+		{MerkleRoot: []byte{2}, Name: "photos"},
+		{MerkleRoot: []byte{3}, PrevVersion: []byte{1}},
+	}
+	header := &Header{
+		Index:             2,
+		Timestamp:         time.Unix(1700000002, 0).UTC(),
+		MerkleRoot:        RecordsRoot(SHA256, files),
+		PrevHash:          chain[1].Hash,
+		UploaderPublicKey: privateKey.Public().(ed25519.PublicKey),
+		Version:           HashVersionWire,
+		Files:             files,
+	}
+	for header.Hash = HeaderHash(header); !ProofOfWork(header.Hash, testDifficulty); header.Hash = HeaderHash(header) {
+		header.Nonce++
+	}
+	header.Signature = ed25519.Sign(privateKey, header.Hash)
+	if err := Block(header, chain[1], testDifficulty, -1); err != nil {
+		t.Fatalf("FAIL: Block committing several files failed verification: %v", err)
+	}
+
+	decoded, err := DecodeHeader(EncodeHeader(header))
+	if err != nil || !reflect.DeepEqual(decoded, header) {
+		t.Fatalf("FAIL: Expected the file records to survive the encoding, got %+v (error %v)", decoded, err)
+	}
+	for _, root := range [][]byte{{1}, {2}, {3}} {
+		if err := ManifestCommitment([][]byte{{4}}, root, header); err != ErrCommitmentInvalid {
+			t.Errorf("FAIL: Expected the block to commit to file %x, got %v", root, err)
+		}
+	}
+	chunkHashes := [][]byte{sha256.New().Sum(nil)}
+	if err := ManifestCommitment(chunkHashes, MerkleRoot(chunkHashes), header); err != ErrWrongBlock {
+		t.Errorf("FAIL: Expected ErrWrongBlock for a file the block does not record, got %v", err)
+	}
+
+	// The records are covered by the block hash and must be the ones the Merkle root commits to
+	tampered := *header
+	tampered.Files = files[1:]
+	if err := Block(&tampered, chain[1], testDifficulty, -1); err != ErrRecordsRoot {
+		t.Errorf("FAIL: Expected ErrRecordsRoot for records the Merkle root does not commit to, got %v", err)
+	}
+	tampered.MerkleRoot = RecordsRoot(SHA256, tampered.Files)
+	if err := Block(&tampered, chain[1], testDifficulty, -1); err != ErrHashMismatch {
+		t.Errorf("FAIL: Expected ErrHashMismatch for records the hash does not cover, got %v", err)
+	}
+	misplaced := *header
+	misplaced.Name = "photos"
+	if err := Block(&misplaced, chain[1], testDifficulty, -1); err != ErrMisplacedRecord {
+		t.Errorf("FAIL: Expected ErrMisplacedRecord for a name outside the file records, got %v", err)
+	}
+	for name, malformed := range map[string][]byte{
+		"missing Merkle root": {fieldFiles<<3 | wireBytes, 3, 2, recordName<<3 | wireBytes, 0},
+		"out of order":        {fieldFiles<<3 | wireBytes, 7, 6, recordName<<3 | wireBytes, 1, 'a', recordMerkleRoot<<3 | wireBytes, 1, 1},
+		"unknown field":       {fieldFiles<<3 | wireBytes, 4, 3, 4<<3 | wireBytes, 1, 1},
+	} {
+		decoded, err := DecodeHeader(malformed)
+		if err == nil && len(decoded.Files) > 0 {
+			t.Errorf("FAIL: Decoded file records from a %s encoding", name)
+		}
+	}
+}
+
+// Tests that CIDs match the ones IPFS derives for the same content, and that hashes parse back from either form
+func TestCID(t *testing.T) {
+	hash := sha256.Sum256([]byte("hello world"))
+	// CID of the raw bytes "hello world", as given by ipfs add --cid-version=1 --raw-leaves
+	const expected = "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"
+	encoded, err := EncodeCID(CodecChunk, "", hash[:])
+	if err != nil || encoded != expected {
+		t.Fatalf("FAIL: Expected chunk CID %s, got %s (error %v)", expected, encoded, err)
+	}
+
+	encoded, err = EncodeCID(CodecFile, BLAKE3, hash[:])
+	if err != nil {
+		t.Fatalf("EncodeCID() failed with error: %v", err)
+	}
+	decoded, err := DecodeCID(encoded)
+	if err != nil || decoded.Codec != CodecFile || decoded.Algorithm != BLAKE3 || !bytes.Equal(decoded.Hash, hash[:]) {
+		t.Errorf("FAIL: Expected the file CID to decode to its codec, algorithm and hash, got %+v (error %v)", decoded, err)
+	}
+	if _, err := EncodeCID(CodecFile, "sha512-256", hash[:]); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("FAIL: Expected an algorithm without a multihash code to fail with %v, got %v", ErrUnknownHash, err)
+	}
+	if _, err := DecodeCID(encoded[:len(encoded)-2]); !errors.Is(err, ErrInvalidCID) {
+		t.Errorf("FAIL: Expected a truncated CID to fail with %v, got %v", ErrInvalidCID, err)
+	}
+
+	for _, value := range []string{expected, hex.EncodeToString(hash[:])} {
+		parsed, err := ParseHash(value)
+		if err != nil || !bytes.Equal(parsed, hash[:]) {
+			t.Errorf("FAIL: Expected %s to parse to the hash, got %x (error %v)", value, parsed, err)
+		}
+	}
+	if _, err := ParseHash("not a hash"); err == nil {
+		t.Errorf("FAIL: Expected parsing a string that is neither hex nor a CID to fail")
+	}
+}
+
+// Tests that multihashes carry their algorithm, and only verify data hashed with the algorithm they name
+func TestMultihash(t *testing.T) {
+	data := []byte("chunk contents")
+	multihash, err := SumMultihash(SHA256, data)
+	if err != nil {
+		t.Fatalf("SumMultihash() failed with error: %v", err)
+	}
+	algorithm, digest, err := multihash.Decode()
+	expected := sha256.Sum256(data)
+	if err != nil || algorithm != SHA256 || !bytes.Equal(digest, expected[:]) {
+		t.Errorf("FAIL: Expected the multihash to decode to SHA-256 and its digest, got %s %x (error %v)", algorithm,
+			digest, err)
+	}
+	if !multihash.Verify(data) || multihash.Verify([]byte("other contents")) {
+		t.Errorf("FAIL: Expected the multihash to verify only the data it was computed from")
+	}
+
+	// The same digest under another algorithm's code must not verify, even though SHA-256 would match it
+	relabelled, _ := NewMultihash(BLAKE3, expected[:])
+	if relabelled.Verify(data) {
+		t.Errorf("FAIL: Expected a digest relabelled with another algorithm not to verify")
+	}
+	if _, _, err := multihash[:len(multihash)-1].Decode(); !errors.Is(err, ErrInvalidMultihash) {
+		t.Errorf("FAIL: Expected a truncated multihash to fail with %v, got %v", ErrInvalidMultihash, err)
+	}
+}
@@ -0,0 +1,433 @@
+package verify
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Error returned when an encoded block is not in the canonical wire encoding
+var ErrMalformedBlock = errors.New("encoded block is malformed")
+
+// Field numbers of the block wire encoding
+// Fields 1 to 8 are covered by the block hash and fields 9 to 11 are not. Fields added later use higher numbers and
+// are always covered by the hash, so nodes that do not understand them can still check the hash. Blocks of
+// HashVersionRecords cover them through the records hash (field 17), which pruned headers keep in their place.
+const (
+	fieldIndex         = 1
+	fieldTimestamp     = 2
+	fieldMerkleRoot    = 3
+	fieldPrevHash      = 4
+	fieldNonce         = 5
+	fieldUploader      = 6
+	fieldHashAlgorithm = 7
+	fieldVersion       = 8
+	fieldHash          = 9
+	fieldSignature     = 10
+	fieldPruned        = 11
+	fieldName          = 12
+	fieldPrevVersion   = 13
+	fieldTombstone     = 14
+	fieldFiles         = 15
+	fieldAgreements    = 16
+	fieldRecordsHash   = 17
+)
+
+// Field numbers of a file record, which blocks committing several files carry in their files field
+const (
+	recordMerkleRoot  = 1
+	recordName        = 2
+	recordPrevVersion = 3
+)
+
+// Field numbers of a storage agreement, which blocks recording agreements carry in their agreements field
+const (
+	agreementUploader    = 1
+	agreementProvider    = 2
+	agreementMerkleRoot  = 3
+	agreementDuration    = 4
+	agreementReplication = 5
+	agreementSignature   = 6
+)
+
+// Wire types of the block wire encoding, which are the ones protocol buffers use
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Function that encodes a block in its canonical wire encoding, which is used to send blocks between nodes
+// The encoding follows the protocol buffers wire format: every field is a key (its number and wire type) followed by
+// its value, fields appear in ascending order and fields with zero values are left out, so every block has exactly
+// one encoding. The timestamp is the exception, as it is held in nanoseconds since the Unix epoch (as a little-endian
+// fixed64) and only left out for the zero time. Fields the node does not know about, kept in Extensions, are encoded
+// after the known fields.
+func EncodeHeader(header *Header) []byte {
+	encoded := appendHashedFields(nil, header)
+	encoded = appendBytesField(encoded, fieldHash, header.Hash)
+	encoded = appendBytesField(encoded, fieldSignature, header.Signature)
+	if header.Pruned {
+		encoded = appendVarintField(encoded, fieldPruned, 1)
+	}
+	encoded = appendAddedFields(encoded, header)
+	encoded = appendBytesField(encoded, fieldRecordsHash, header.RecordsHash)
+	return append(encoded, header.Extensions...)
+}
+
+// Function that encodes the fields of a block covered by its hash, which are hashed by blocks of HashVersionWire
+func wireHeaderContents(header *Header) []byte {
+	contents := appendAddedFields(appendHashedFields(nil, header), header)
+	return append(contents, header.Extensions...)
+}
+
+// Function that encodes the fields of a block covered by its hash, which are hashed by blocks of HashVersionRecords
+// The records are replaced by their hash, so a pruned header without them hashes the same as the full block
+func recordsHeaderContents(header *Header) []byte {
+	return appendBytesField(appendHashedFields(nil, header), fieldRecordsHash, RecordsHash(header))
+}
+
+// Function that calculates the hash of the records a block carries: the fields added after the wire encoding and the
+// fields the node does not know about
+// A pruned header carries no records, so the records hash it kept is returned instead (nil if the block has no
+// records at all, or if its hash algorithm is unknown).
+func RecordsHash(header *Header) []byte {
+	records := append(appendAddedFields(nil, header), header.Extensions...)
+	if len(records) == 0 {
+		return header.RecordsHash
+	}
+	hash, err := header.HashAlgorithm.Sum(records)
+	if err != nil {
+		return nil
+	}
+	return hash
+}
+
+// Function that appends the known fields covered by the block hash to an encoding
+func appendHashedFields(encoded []byte, header *Header) []byte {
+	encoded = appendVarintField(encoded, fieldIndex, uint64(header.Index))
+	if !header.Timestamp.IsZero() {
+		encoded = binary.AppendUvarint(encoded, fieldTimestamp<<3|wireFixed64)
+		encoded = binary.LittleEndian.AppendUint64(encoded, uint64(header.Timestamp.UnixNano()))
+	}
+	encoded = appendBytesField(encoded, fieldMerkleRoot, header.MerkleRoot)
+	encoded = appendBytesField(encoded, fieldPrevHash, header.PrevHash)
+	encoded = appendVarintField(encoded, fieldNonce, uint64(header.Nonce))
+	encoded = appendBytesField(encoded, fieldUploader, header.UploaderPublicKey)
+	encoded = appendBytesField(encoded, fieldHashAlgorithm, []byte(header.HashAlgorithm))
+	encoded = appendVarintField(encoded, fieldVersion, uint64(header.Version))
+	return encoded
+}
+
+// Function that appends the known fields added after the wire encoding, which follow the fields not covered by the
+// hash in the encoding but are hashed like fields the node does not know about
+func appendAddedFields(encoded []byte, header *Header) []byte {
+	encoded = appendBytesField(encoded, fieldName, []byte(header.Name))
+	encoded = appendBytesField(encoded, fieldPrevVersion, header.PrevVersion)
+	encoded = appendBytesField(encoded, fieldTombstone, header.Tombstone)
+	encoded = appendBytesField(encoded, fieldFiles, encodeFileRecords(header.Files))
+	return appendBytesField(encoded, fieldAgreements, encodeAgreements(header.Agreements))
+}
+
+// Function that encodes the records of the files a block commits as a single field value
+// Every record is encoded like a block, with its fields in ascending order and empty fields left out, and is prefixed
+// with its length as a uvarint
+func encodeFileRecords(files []FileRecord) []byte {
+	var encoded []byte
+	for _, file := range files {
+		record := encodeFileRecord(file)
+		encoded = binary.AppendUvarint(encoded, uint64(len(record)))
+		encoded = append(encoded, record...)
+	}
+	return encoded
+}
+
+// Function that encodes a single file record
+func encodeFileRecord(file FileRecord) []byte {
+	record := appendBytesField(nil, recordMerkleRoot, file.MerkleRoot)
+	record = appendBytesField(record, recordName, []byte(file.Name))
+	return appendBytesField(record, recordPrevVersion, file.PrevVersion)
+}
+
+// Function that decodes the records of the files a block commits, returning false unless they are canonically encoded
+func decodeFileRecords(encoded []byte) ([]FileRecord, bool) {
+	var files []FileRecord
+	for len(encoded) > 0 {
+		length, n := readUvarint(encoded)
+		if n == 0 || length == 0 || length > uint64(len(encoded)-n) {
+			return nil, false
+		}
+		file, ok := decodeFileRecord(encoded[n : n+int(length)])
+		if !ok {
+			return nil, false
+		}
+		files = append(files, file)
+		encoded = encoded[n+int(length):]
+	}
+	return files, true
+}
+
+// Function that decodes a single file record, which must at least hold the file's Merkle root
+func decodeFileRecord(encoded []byte) (FileRecord, bool) {
+	var file FileRecord
+	lastField := uint64(0)
+	for len(encoded) > 0 {
+		key, n := readUvarint(encoded)
+		field := key >> 3
+		if n == 0 || key&7 != wireBytes || field <= lastField || field > recordPrevVersion {
+			return FileRecord{}, false
+		}
+		lastField = field
+		length, lengthSize := readUvarint(encoded[n:])
+		if lengthSize == 0 || length == 0 || length > uint64(len(encoded)-n-lengthSize) {
+			return FileRecord{}, false
+		}
+		end := n + lengthSize + int(length)
+		value := append([]byte{}, encoded[n+lengthSize:end]...)
+		switch field {
+		case recordMerkleRoot:
+			file.MerkleRoot = value
+		case recordName:
+			file.Name = string(value)
+		case recordPrevVersion:
+			file.PrevVersion = value
+		}
+		encoded = encoded[end:]
+	}
+	return file, len(file.MerkleRoot) > 0
+}
+
+// Function that encodes the storage agreements a block records as a single field value
+// Every agreement is encoded like a file record and prefixed with its length as a uvarint
+func encodeAgreements(agreements []Agreement) []byte {
+	var encoded []byte
+	for _, agreement := range agreements {
+		record := encodeAgreement(agreement)
+		encoded = binary.AppendUvarint(encoded, uint64(len(record)))
+		encoded = append(encoded, record...)
+	}
+	return encoded
+}
+
+// Function that encodes a single storage agreement
+func encodeAgreement(agreement Agreement) []byte {
+	return appendBytesField(AgreementTerms(agreement), agreementSignature, agreement.Signature)
+}
+
+// Function that encodes the terms of a storage agreement without its signature, which is what the provider signs
+func AgreementTerms(agreement Agreement) []byte {
+	record := appendBytesField(nil, agreementUploader, []byte(agreement.Uploader))
+	record = appendBytesField(record, agreementProvider, []byte(agreement.Provider))
+	record = appendBytesField(record, agreementMerkleRoot, agreement.MerkleRoot)
+	record = appendVarintField(record, agreementDuration, agreement.Duration)
+	return appendVarintField(record, agreementReplication, agreement.Replication)
+}
+
+// Function that decodes the storage agreements a block records, returning false unless they are canonically encoded
+func decodeAgreements(encoded []byte) ([]Agreement, bool) {
+	var agreements []Agreement
+	for len(encoded) > 0 {
+		length, n := readUvarint(encoded)
+		if n == 0 || length == 0 || length > uint64(len(encoded)-n) {
+			return nil, false
+		}
+		agreement, ok := decodeAgreement(encoded[n : n+int(length)])
+		if !ok {
+			return nil, false
+		}
+		agreements = append(agreements, agreement)
+		encoded = encoded[n+int(length):]
+	}
+	return agreements, true
+}
+
+// Function that decodes a single storage agreement, which must hold every one of its terms
+func decodeAgreement(encoded []byte) (Agreement, bool) {
+	var agreement Agreement
+	lastField := uint64(0)
+	for len(encoded) > 0 {
+		key, n := readUvarint(encoded)
+		field, wireType := key>>3, key&7
+		if n == 0 || field <= lastField || field > agreementSignature {
+			return Agreement{}, false
+		}
+		lastField = field
+		// The duration and replication are varints, and every other term is length-delimited
+		isVarint := field == agreementDuration || field == agreementReplication
+		if isVarint != (wireType == wireVarint) || (!isVarint && wireType != wireBytes) {
+			return Agreement{}, false
+		}
+		value, valueSize := readUvarint(encoded[n:])
+		if valueSize == 0 || value == 0 {
+			return Agreement{}, false
+		}
+		end := n + valueSize
+		if !isVarint {
+			if value > uint64(len(encoded)-end) {
+				return Agreement{}, false
+			}
+			end += int(value)
+		}
+		data := append([]byte{}, encoded[n+valueSize:end]...)
+		switch field {
+		case agreementUploader:
+			agreement.Uploader = string(data)
+		case agreementProvider:
+			agreement.Provider = string(data)
+		case agreementMerkleRoot:
+			agreement.MerkleRoot = data
+		case agreementDuration:
+			agreement.Duration = value
+		case agreementReplication:
+			agreement.Replication = value
+		case agreementSignature:
+			agreement.Signature = data
+		}
+		encoded = encoded[end:]
+	}
+	complete := agreement.Uploader != "" && agreement.Provider != "" && len(agreement.MerkleRoot) > 0 &&
+		agreement.Duration > 0 && agreement.Replication > 0
+	return agreement, complete
+}
+
+// Function that appends a varint field to an encoding, leaving it out if it is zero
+func appendVarintField(encoded []byte, field uint64, value uint64) []byte {
+	if value == 0 {
+		return encoded
+	}
+	encoded = binary.AppendUvarint(encoded, field<<3|wireVarint)
+	return binary.AppendUvarint(encoded, value)
+}
+
+// Function that appends a length-delimited field to an encoding, leaving it out if it is empty
+func appendBytesField(encoded []byte, field uint64, value []byte) []byte {
+	if len(value) == 0 {
+		return encoded
+	}
+	encoded = binary.AppendUvarint(encoded, field<<3|wireBytes)
+	encoded = binary.AppendUvarint(encoded, uint64(len(value)))
+	return append(encoded, value...)
+}
+
+// Function that decodes a block from its canonical wire encoding, rejecting any other encoding
+// Fields the node does not know about are kept in Extensions, so that the block hash can still be checked and the
+// block can be passed on unchanged
+func DecodeHeader(encoded []byte) (*Header, error) {
+	header := &Header{}
+	lastField := uint64(0)
+	for len(encoded) > 0 {
+		start := encoded
+		key, n := readUvarint(encoded)
+		if n == 0 {
+			return nil, ErrMalformedBlock
+		}
+		encoded = encoded[n:]
+		field, wireType := key>>3, key&7
+		// Fields must appear once each in ascending order for the encoding to be canonical
+		if field <= lastField {
+			return nil, ErrMalformedBlock
+		}
+		lastField = field
+
+		var value uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			value, n = readUvarint(encoded)
+			if n == 0 || value == 0 {
+				return nil, ErrMalformedBlock
+			}
+		case wireBytes:
+			length, lengthSize := readUvarint(encoded)
+			if lengthSize == 0 || length == 0 || length > uint64(len(encoded)-lengthSize) {
+				return nil, ErrMalformedBlock
+			}
+			n = lengthSize + int(length)
+			data = encoded[lengthSize:n]
+		case wireFixed64:
+			n = 8
+			if len(encoded) >= n {
+				value = binary.LittleEndian.Uint64(encoded)
+			}
+		case wireFixed32:
+			n = 4
+		default:
+			return nil, ErrMalformedBlock
+		}
+		if n > len(encoded) {
+			return nil, ErrMalformedBlock
+		}
+		encoded = encoded[n:]
+
+		if !header.setField(field, wireType, value, data) {
+			if field <= fieldPruned {
+				return nil, ErrMalformedBlock
+			}
+			header.Extensions = append(header.Extensions, start[:len(start)-len(encoded)]...)
+		}
+	}
+	return header, nil
+}
+
+// Function that sets a known field of a header from its decoded value, returning false if the field is unknown or
+// has the wrong wire type
+func (header *Header) setField(field uint64, wireType uint64, value uint64, data []byte) bool {
+	copied := append([]byte{}, data...)
+	switch {
+	case field == fieldIndex && wireType == wireVarint:
+		header.Index = int64(value)
+	case field == fieldTimestamp && wireType == wireFixed64:
+		header.Timestamp = time.Unix(0, int64(value)).UTC()
+	case field == fieldMerkleRoot && wireType == wireBytes:
+		header.MerkleRoot = copied
+	case field == fieldPrevHash && wireType == wireBytes:
+		header.PrevHash = copied
+	case field == fieldNonce && wireType == wireVarint:
+		header.Nonce = int(value)
+	case field == fieldUploader && wireType == wireBytes:
+		header.UploaderPublicKey = copied
+	case field == fieldHashAlgorithm && wireType == wireBytes:
+		header.HashAlgorithm = HashAlgorithm(copied)
+	case field == fieldVersion && wireType == wireVarint && value <= 0xff:
+		header.Version = uint8(value)
+	case field == fieldHash && wireType == wireBytes:
+		header.Hash = copied
+	case field == fieldSignature && wireType == wireBytes:
+		header.Signature = copied
+	case field == fieldPruned && wireType == wireVarint && value == 1:
+		header.Pruned = true
+	case field == fieldName && wireType == wireBytes:
+		header.Name = string(copied)
+	case field == fieldPrevVersion && wireType == wireBytes:
+		header.PrevVersion = copied
+	case field == fieldTombstone && wireType == wireBytes:
+		header.Tombstone = copied
+	case field == fieldFiles && wireType == wireBytes:
+		files, ok := decodeFileRecords(data)
+		if !ok {
+			return false
+		}
+		header.Files = files
+	case field == fieldAgreements && wireType == wireBytes:
+		agreements, ok := decodeAgreements(data)
+		if !ok {
+			return false
+		}
+		header.Agreements = agreements
+	case field == fieldRecordsHash && wireType == wireBytes:
+		header.RecordsHash = copied
+	default:
+		return false
+	}
+	return true
+}
+
+// Function that reads a minimally encoded uvarint, returning its size (0 if it is missing, too long or not minimal)
+func readUvarint(encoded []byte) (uint64, int) {
+	value, n := binary.Uvarint(encoded)
+	if n <= 0 || n != len(binary.AppendUvarint(nil, value)) {
+		return 0, 0
+	}
+	return value, n
+}